@@ -0,0 +1,191 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+)
+
+// Prefix of PAX records used to carry item metadata in archives
+const archiveMetaPrefix = "ZIM.meta."
+
+// storageKeys returns the keys used to store the outputs of a Rule along
+// with the key of the Rule's metadata
+func storageKeys(key *Key, outputCount int) []string {
+	storageKey := key.String()
+	var keys []string
+	if outputCount == 1 {
+		keys = append(keys, storageKey)
+	} else {
+		for i := 0; i < outputCount; i++ {
+			keys = append(keys, fmt.Sprintf("%s-%d", storageKey, i))
+		}
+	}
+	return append(keys, fmt.Sprintf("%s.json", storageKey))
+}
+
+// Export writes the cache entries of the given Rules to a tar archive, which
+// may be imported into another cache using Import. The IDs of Rules that are
+// not present in the cache are returned.
+func (c *Cache) Export(ctx context.Context, rules []*project.Rule, w io.Writer) ([]string, error) {
+
+	tmp, err := ioutil.TempFile("", "zim-export-")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	var missing []string
+	exported := map[string]bool{}
+	tw := tar.NewWriter(w)
+	for _, r := range rules {
+		outputs := r.Outputs()
+		if len(outputs) == 0 {
+			continue
+		}
+		key, err := c.Key(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		keys := storageKeys(key, len(outputs))
+
+		// Only export complete entries. Content referred to by manifests is
+		// exported along with them, once per archive.
+		metas := make([]store.ItemMeta, len(keys))
+		var blobs []string
+		var blobMetas []store.ItemMeta
+		found := true
+		for i, k := range keys {
+			metas[i], err = c.store.Head(ctx, k)
+			if err == nil && metas[i].Meta["Format"] == FormatManifest {
+				blob, blobMeta, headErr := c.head(ctx, k)
+				blobs = append(blobs, blob)
+				blobMetas = append(blobMetas, blobMeta)
+				err = headErr
+			}
+			if err != nil {
+				if _, ok := err.(store.NotFound); !ok {
+					return nil, err
+				}
+				found = false
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, r.NodeID())
+			continue
+		}
+		for i, blob := range blobs {
+			keys = append(keys, blob)
+			metas = append(metas, blobMetas[i])
+		}
+		for i, k := range keys {
+			if exported[k] {
+				continue
+			}
+			if err := c.store.Get(ctx, k, tmp.Name()); err != nil {
+				return nil, err
+			}
+			if err := addArchiveItem(tw, k, tmp.Name(), metas[i].Meta); err != nil {
+				return nil, err
+			}
+			exported[k] = true
+		}
+	}
+	return missing, tw.Close()
+}
+
+func addArchiveItem(tw *tar.Writer, key, src string, meta map[string]string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	header := &tar.Header{
+		Name:       key,
+		Mode:       0644,
+		Size:       info.Size(),
+		ModTime:    info.ModTime(),
+		Format:     tar.FormatPAX,
+		PAXRecords: map[string]string{},
+	}
+	for k, v := range meta {
+		header.PAXRecords[archiveMetaPrefix+k] = v
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// Import stores every item in a tar archive created by Export in the cache
+// and returns the number of items imported
+func (c *Cache) Import(ctx context.Context, r io.Reader) (int, error) {
+
+	tmp, err := ioutil.TempFile("", "zim-import-")
+	if err != nil {
+		return 0, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	count := 0
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if header.Typeflag != tar.TypeReg || strings.ContainsAny(header.Name, "/\\") {
+			return count, fmt.Errorf("unexpected archive item: %s", header.Name)
+		}
+		meta := map[string]string{}
+		for k, v := range header.PAXRecords {
+			if strings.HasPrefix(k, archiveMetaPrefix) {
+				meta[strings.TrimPrefix(k, archiveMetaPrefix)] = v
+			}
+		}
+		f, err := os.Create(tmp.Name())
+		if err != nil {
+			return count, err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return count, err
+		}
+		if err := c.store.Put(ctx, header.Name, tmp.Name(), meta); err != nil {
+			return count, err
+		}
+		count++
+	}
+}
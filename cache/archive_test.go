@@ -0,0 +1,91 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportImport(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "my-component")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "main.go"), "some source code")
+
+	cDef := &definitions.Component{
+		Name: "my-component",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"build": {
+				Inputs:  []string{"main.go"},
+				Outputs: []string{"my-exe"},
+			},
+			"test": {
+				Inputs:  []string{"main.go"},
+				Outputs: []string{"results"},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	c := p.Components().WithName("my-component").First()
+	build := c.MustRule("build")
+	test := c.MustRule("test")
+
+	// Only the build rule is in the source cache
+	outputPath := build.Outputs()[0].Path()
+	require.Nil(t, os.MkdirAll(path.Dir(outputPath), 0755))
+	writeFile(outputPath, "executable")
+	src := New(Opts{Store: filesystem.New(path.Join(tmpDir, "src"))})
+	_, err = src.Write(ctx, build)
+	require.Nil(t, err)
+
+	var archive bytes.Buffer
+	missing, err := src.Export(ctx, []*project.Rule{build, test}, &archive)
+	require.Nil(t, err)
+	require.Equal(t, []string{"my-component.test"}, missing)
+
+	dst := New(Opts{Store: filesystem.New(path.Join(tmpDir, "dst"))})
+	count, err := dst.Import(ctx, &archive)
+	require.Nil(t, err)
+	require.Equal(t, 2, count) // Output and metadata
+
+	// The output is restored from the destination cache
+	require.Nil(t, os.Remove(outputPath))
+	_, err = dst.Read(ctx, build)
+	require.Nil(t, err)
+	data, err := ioutil.ReadFile(outputPath)
+	require.Nil(t, err)
+	require.Equal(t, "executable", string(data))
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fugue/zim/project"
+)
+
+// AuditResult describes what the cache would have done for one Rule
+type AuditResult struct {
+	Rule     string        `json:"rule"`
+	Key      string        `json:"key"`
+	Hit      bool          `json:"hit"`
+	Write    bool          `json:"write"`
+	Duration time.Duration `json:"duration"`
+
+	// Mismatched lists outputs of a cache hit whose contents differ from
+	// the cached item, which means the Rule isn't reproducible or the
+	// cache holds a bad item
+	Mismatched []string `json:"mismatched,omitempty"`
+}
+
+// AuditSummary totals the results of an audit. Savings is the time spent
+// running Rules that would have been restored from the cache.
+type AuditSummary struct {
+	Hits       int           `json:"hits"`
+	Misses     int           `json:"misses"`
+	Writes     int           `json:"writes"`
+	Mismatches int           `json:"mismatches"`
+	Savings    time.Duration `json:"savings"`
+}
+
+// AuditLog collects the results of running in audit mode
+type AuditLog struct {
+	mutex   sync.Mutex
+	results []AuditResult
+}
+
+func (log *AuditLog) add(result AuditResult) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	log.results = append(log.results, result)
+}
+
+// Results returns the audit result of each Rule, sorted by Rule
+func (log *AuditLog) Results() []AuditResult {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+	results := make([]AuditResult, len(log.results))
+	copy(results, log.results)
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Rule < results[j].Rule
+	})
+	return results
+}
+
+// Summary totals the audit results
+func (log *AuditLog) Summary() AuditSummary {
+	var summary AuditSummary
+	for _, result := range log.Results() {
+		if result.Hit {
+			summary.Hits++
+			summary.Savings += result.Duration
+		} else {
+			summary.Misses++
+		}
+		if result.Write {
+			summary.Writes++
+		}
+		if len(result.Mismatched) > 0 {
+			summary.Mismatches++
+		}
+	}
+	return summary
+}
+
+// AuditLog returns the log of a Cache in audit mode, or nil otherwise
+func (c *Cache) AuditLog() *AuditLog {
+	return c.audit
+}
+
+// auditRun runs the Rule without using the cache and records what the cache
+// would have done. Nothing is downloaded or uploaded.
+func (c *Cache) auditRun(ctx context.Context, r *project.Rule, opts project.RunOpts, runner project.Runner) (project.Code, error) {
+
+	key, err := c.Key(ctx, r)
+	if err != nil {
+		return project.Error, err
+	}
+	hit, err := c.Contains(ctx, r)
+	if err != nil {
+		return project.Error, err
+	}
+
+	started := time.Now()
+	code, err := runner.Run(ctx, r, opts)
+	result := AuditResult{
+		Rule:     r.NodeID(),
+		Key:      key.String(),
+		Hit:      hit,
+		Duration: time.Since(started),
+	}
+	// Only the outputs of Rules that succeed would be written, and only
+	// those can be compared with the cached items
+	if code == project.OK {
+		result.Write = !hit
+		if hit {
+			mismatched, compareErr := c.compareOutputs(ctx, r, key)
+			if compareErr != nil {
+				return project.Error, compareErr
+			}
+			result.Mismatched = mismatched
+		}
+	}
+	c.audit.add(result)
+
+	if opts.Output != nil {
+		status := "miss"
+		if result.Write {
+			status = "miss, would write"
+		}
+		if hit {
+			status = "hit, would restore"
+		}
+		if len(result.Mismatched) > 0 {
+			status = fmt.Sprintf("hit with mismatched outputs %v", result.Mismatched)
+		}
+		fmt.Fprintln(opts.Output, "audit:", project.Yellow(fmt.Sprintf(
+			"%s %s", r.NodeID(), status)))
+	}
+	return code, err
+}
+
+// compareOutputs returns the names of the Rule's file outputs whose hashes
+// differ from those of the cached items. Directory archives and outputs that
+// are not files, such as container images, are skipped.
+func (c *Cache) compareOutputs(ctx context.Context, r *project.Rule, key *Key) ([]string, error) {
+	var mismatched []string
+	outputs := r.Outputs()
+	for i, storageKey := range storageKeys(key, len(outputs))[:len(outputs)] {
+		if !outputs[i].OnFilesystem() {
+			continue
+		}
+		_, info, err := c.head(ctx, storageKey)
+		if err != nil {
+			return nil, err
+		}
+		if info.Meta["Format"] == FormatSeekableDir {
+			continue
+		}
+		hasher, err := metaHasher(info)
+		if err != nil {
+			return nil, err
+		}
+		localHash, err := hasher.File(outputs[i].Path())
+		if err != nil {
+			return nil, err
+		}
+		if localHash != contentHash(info) {
+			mismatched = append(mismatched, outputs[i].Name())
+		}
+	}
+	return mismatched, nil
+}
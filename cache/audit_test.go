@@ -0,0 +1,114 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditMode(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "my-component")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "main.go"), "some source code")
+
+	cDef := &definitions.Component{
+		Name: "my-component",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"build": {
+				Inputs:  []string{"main.go"},
+				Outputs: []string{"my-exe"},
+			},
+			"test": {
+				Inputs:  []string{"main.go"},
+				Outputs: []string{"results"},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	c := p.Components().WithName("my-component").First()
+	build := c.MustRule("build")
+	test := c.MustRule("test")
+
+	// Only the build rule is in the cache
+	storeDir := path.Join(tmpDir, "store")
+	outputPath := build.Outputs()[0].Path()
+	require.Nil(t, os.MkdirAll(path.Dir(outputPath), 0755))
+	writeFile(outputPath, "executable")
+	_, err = New(Opts{Store: filesystem.New(storeDir)}).Write(ctx, build)
+	require.Nil(t, err)
+
+	// Each rule runs, and the build creates a different output this time
+	ran := map[string]bool{}
+	runner := project.RunnerFunc(func(ctx context.Context, r *project.Rule, opts project.RunOpts) (project.Code, error) {
+		ran[r.Name()] = true
+		writeFile(r.Outputs()[0].Path(), "new "+r.Name())
+		return project.OK, nil
+	})
+	audit := New(Opts{Store: filesystem.New(storeDir), Mode: Audit})
+	middleware := NewMiddleware(audit)(runner)
+
+	var output bytes.Buffer
+	for _, r := range []*project.Rule{build, test} {
+		code, err := middleware.Run(ctx, r, project.RunOpts{Output: &output})
+		require.Nil(t, err)
+		require.Equal(t, project.OK, code)
+	}
+	require.True(t, ran["build"])
+	require.True(t, ran["test"])
+	require.Contains(t, output.String(), "my-component.test miss, would write")
+
+	results := audit.AuditLog().Results()
+	require.Len(t, results, 2)
+	require.Equal(t, "my-component.build", results[0].Rule)
+	require.True(t, results[0].Hit)
+	require.False(t, results[0].Write)
+	require.Equal(t, []string{"my-exe"}, results[0].Mismatched)
+	require.Equal(t, "my-component.test", results[1].Rule)
+	require.False(t, results[1].Hit)
+	require.True(t, results[1].Write)
+
+	summary := audit.AuditLog().Summary()
+	require.Equal(t, 1, summary.Hits)
+	require.Equal(t, 1, summary.Misses)
+	require.Equal(t, 1, summary.Writes)
+	require.Equal(t, 1, summary.Mismatches)
+
+	// Nothing was written to the cache
+	found, err := audit.Contains(ctx, test)
+	require.Nil(t, err)
+	require.False(t, found)
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/fugue/zim/store"
+)
+
+// BaselinePrefix begins the storage keys of baselines
+const BaselinePrefix = "baseline-"
+
+// baselineKey returns the storage key for the named baseline
+func baselineKey(name string) string {
+	return fmt.Sprintf("%s%x", BaselinePrefix, sha1.Sum([]byte(name)))
+}
+
+// ReadBaseline returns the named baseline or nil if it does not exist
+func (c *Cache) ReadBaseline(ctx context.Context, name string) ([]byte, error) {
+	key := baselineKey(name)
+	if _, err := c.store.Head(ctx, key); err != nil {
+		if _, ok := err.(store.NotFound); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "zim-baseline-")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := c.store.Get(ctx, key, f.Name()); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(f.Name())
+}
+
+// WriteBaseline stores the named baseline. Nothing is stored in audit mode.
+func (c *Cache) WriteBaseline(ctx context.Context, name string, data []byte) error {
+	if c.mode == Audit {
+		return nil
+	}
+	f, err := ioutil.TempFile("", "zim-baseline-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return c.store.Put(ctx, baselineKey(name), f.Name(),
+		map[string]string{"User": c.user, "Baseline": name})
+}
@@ -0,0 +1,50 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBaseline(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	c := New(Opts{Store: filesystem.New(path.Join(tmpDir, "cache"))})
+
+	data, err := c.ReadBaseline(ctx, "coverage/foo")
+	require.Nil(t, err)
+	assert.Nil(t, data)
+
+	require.Nil(t, c.WriteBaseline(ctx, "coverage/foo", []byte(`{"percent":80}`)))
+	data, err = c.ReadBaseline(ctx, "coverage/foo")
+	require.Nil(t, err)
+	assert.Equal(t, `{"percent":80}`, string(data))
+
+	data, err = c.ReadBaseline(ctx, "coverage/bar")
+	require.Nil(t, err)
+	assert.Nil(t, data)
+}
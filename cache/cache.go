@@ -20,9 +20,12 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
+	"sync"
 
 	"github.com/fugue/zim/hash"
 	"github.com/fugue/zim/project"
@@ -38,8 +41,15 @@ const (
 
 	// Disabled mode bypasses all cache interactions
 	Disabled = "disabled"
+
+	// Audit mode checks the cache but never downloads or uploads, and
+	// records what would have been restored and written
+	Audit = "audit"
 )
 
+// DefaultConcurrency is the number of outputs transferred at once by default
+const DefaultConcurrency = 8
+
 // Error is used to handle cache misses and the like
 type Error string
 
@@ -54,14 +64,42 @@ type Opts struct {
 	Hasher hash.Hasher
 	User   string
 	Mode   string
+
+	// InputHasher hashes rule input files in keys. It must use the same
+	// algorithm as Hasher, which it defaults to.
+	InputHasher hash.Hasher
+
+	// Layout selects how outputs are stored, LayoutKeys if empty. Items in
+	// either layout are read regardless.
+	Layout string
+
+	// Concurrency limits the number of outputs transferred at once across
+	// all rules, DefaultConcurrency if zero
+	Concurrency int
+
+	// Compression selects how files are compressed when stored,
+	// CompressionNone if empty. Compressed items are read regardless.
+	Compression string
+
+	// Signer signs items when they are stored and verifies them before they
+	// are used. Items are neither signed nor verified if it is nil.
+	Signer Signer
 }
 
 // Cache for rule outputs
 type Cache struct {
-	store  store.Store
-	hasher hash.Hasher
-	user   string
-	mode   string
+	store       store.Store
+	hasher      hash.Hasher
+	inputHasher hash.Hasher
+	user        string
+	mode        string
+	layout      string
+	compression string
+	signer      Signer
+	audit       *AuditLog
+	keys        map[string]string
+	keysMutex   sync.Mutex
+	transfers   chan struct{}
 }
 
 // New returns a Cache
@@ -71,45 +109,72 @@ func New(opts Opts) *Cache {
 		opts.Hasher = hash.SHA1()
 	}
 
+	if opts.InputHasher == nil {
+		opts.InputHasher = opts.Hasher
+	}
+
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+
 	c := &Cache{
-		store:  opts.Store,
-		hasher: opts.Hasher,
-		user:   opts.User,
-		mode:   opts.Mode,
+		store:       opts.Store,
+		hasher:      opts.Hasher,
+		inputHasher: opts.InputHasher,
+		user:        opts.User,
+		mode:        opts.Mode,
+		layout:      opts.Layout,
+		compression: opts.Compression,
+		signer:      opts.Signer,
+		transfers:   make(chan struct{}, opts.Concurrency),
+	}
+	if c.mode == Audit {
+		c.audit = &AuditLog{}
 	}
 	return c
 }
 
 // Write rule outputs to the cache
 func (c *Cache) Write(ctx context.Context, r *project.Rule) ([]string, error) {
+	return c.WriteBuild(ctx, r, "")
+}
 
-	outputs := r.Outputs().Paths()
+// WriteBuild writes rule outputs to the cache, recording the ID of the build
+// that produced them in the metadata of each item
+func (c *Cache) WriteBuild(ctx context.Context, r *project.Rule, buildID string) ([]string, error) {
+
+	outputs := r.Outputs()
 
 	// If the rule has no outputs then there is nothing to cache
 	if len(outputs) == 0 {
 		return nil, fmt.Errorf("rule has no outputs: %s", r.NodeID())
 	}
 
+	// Refuse to store outputs larger than the rule allows, before any are
+	// uploaded. Outputs that are not files are checked once exported.
+	for _, out := range outputs {
+		if !out.OnFilesystem() {
+			continue
+		}
+		size, err := project.PathSize(out.Path())
+		if err != nil {
+			return nil, err
+		}
+		if err := checkOutputSize(r, out, size); err != nil {
+			return nil, err
+		}
+	}
+
 	key, err := c.Key(ctx, r)
 	if err != nil {
 		return nil, err
 	}
-	storageKey := key.String()
-
-	var storagePaths []string
-	if len(outputs) == 1 {
-		if err := c.put(ctx, storageKey, outputs[0]); err != nil {
-			return nil, err
-		}
-		storagePaths = append(storagePaths, storageKey)
-	} else {
-		for i, out := range outputs {
-			storageKeyOfs := fmt.Sprintf("%s-%d", storageKey, i)
-			if err := c.put(ctx, storageKeyOfs, out); err != nil {
-				return nil, err
-			}
-			storagePaths = append(storagePaths, storageKeyOfs)
-		}
+	storagePaths := storageKeys(key, len(outputs))[:len(outputs)]
+	err = c.transferAll(ctx, storagePaths, func(ctx context.Context, i int) error {
+		return c.putOutput(ctx, r, storagePaths[i], outputs[i], buildID)
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	// The above would be sufficient for the cache to operate.
@@ -121,7 +186,10 @@ func (c *Cache) Write(ctx context.Context, r *project.Rule) ([]string, error) {
 	defer os.Remove(keyPath)
 
 	infoKey := fmt.Sprintf("%s.json", key.String())
-	if err := c.put(ctx, infoKey, keyPath); err != nil {
+	err = c.transferAll(ctx, []string{infoKey}, func(ctx context.Context, i int) error {
+		return c.put(ctx, infoKey, keyPath, key.Project, buildID)
+	})
+	if err != nil {
 		return nil, err
 	}
 
@@ -130,8 +198,45 @@ func (c *Cache) Write(ctx context.Context, r *project.Rule) ([]string, error) {
 
 // Read rule outputs from the cache
 func (c *Cache) Read(ctx context.Context, r *project.Rule) ([]string, error) {
+	return c.read(ctx, r, nil)
+}
+
+// Contains returns true if all outputs of the rule are in the cache. Nothing
+// is downloaded.
+func (c *Cache) Contains(ctx context.Context, r *project.Rule) (bool, error) {
+	outputCount := len(r.Outputs())
+	if outputCount == 0 {
+		return false, nil
+	}
+	key, err := c.Key(ctx, r)
+	if err != nil {
+		return false, err
+	}
+	keys := storageKeys(key, outputCount)
+	for _, k := range keys[:outputCount] {
+		if _, _, err := c.head(ctx, k); err != nil {
+			if _, ok := err.(store.NotFound); ok {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ReadFiles restores only the named files or directories within the rule's
+// directory outputs from the cache. Paths are relative to each directory
+// output. Outputs that are regular files are restored in full.
+func (c *Cache) ReadFiles(ctx context.Context, r *project.Rule, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no files specified to read from the cache")
+	}
+	return c.read(ctx, r, names)
+}
+
+func (c *Cache) read(ctx context.Context, r *project.Rule, names []string) ([]string, error) {
 
-	outputs := r.Outputs().Paths()
+	outputs := r.Outputs()
 
 	// If the rule has no outputs then there is nothing to read from the cache
 	if len(outputs) == 0 {
@@ -142,64 +247,329 @@ func (c *Cache) Read(ctx context.Context, r *project.Rule) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	storageKey := key.String()
+	storagePaths := storageKeys(key, len(outputs))[:len(outputs)]
+	err = c.transferAll(ctx, storagePaths, func(ctx context.Context, i int) error {
+		return c.getOutput(ctx, storagePaths[i], outputs[i], names)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return storagePaths, nil
+}
+
+// transferAll calls the function for each of the keys concurrently, with
+// no more than the configured number of transfers in progress across all
+// callers. A fixed number of workers take the keys in turn. Once one fails
+// the others are canceled, and the error for the first of the keys that
+// failed is returned, as if they were transferred in order.
+func (c *Cache) transferAll(ctx context.Context, keys []string, transfer func(ctx context.Context, i int) error) error {
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	indexes := make(chan int, len(keys))
+	for i := range keys {
+		indexes <- i
+	}
+	close(indexes)
 
-	var storagePaths []string
-	if len(outputs) == 1 {
-		if err := c.get(ctx, storageKey, outputs[0]); err != nil {
-			return nil, err
-		}
-		storagePaths = append(storagePaths, storageKey)
-	} else {
-		for i, out := range outputs {
-			storageKeyOfs := fmt.Sprintf("%s-%d", storageKey, i)
-			if err := c.get(ctx, storageKeyOfs, out); err != nil {
-				return nil, err
+	workers := cap(c.transfers)
+	if workers > len(keys) {
+		workers = len(keys)
+	}
+	errs := make([]error, len(keys))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				errs[i] = c.transferOne(ctx, func(ctx context.Context) error {
+					return transfer(ctx, i)
+				})
+				if errs[i] != nil {
+					cancel()
+				}
 			}
-			storagePaths = append(storagePaths, storageKeyOfs)
+		}()
+	}
+	wg.Wait()
+
+	// Transfers canceled because another failed report the original error
+	var canceled error
+	for _, err := range errs {
+		if err == context.Canceled && canceled == nil {
+			canceled = err
+		} else if err != nil && err != context.Canceled {
+			return err
 		}
 	}
-	return storagePaths, nil
+	return canceled
 }
 
-func (c *Cache) put(ctx context.Context, key, src string) error {
+// transferOne runs a transfer once one of the transfers shared by all
+// callers is free. The context's error is returned if it is done first.
+func (c *Cache) transferOne(ctx context.Context, transfer func(ctx context.Context) error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	select {
+	case c.transfers <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-c.transfers }()
+	return transfer(ctx)
+}
 
-	// The file hash will be added to the cache item metadata
-	hash, err := c.hasher.File(src)
+// putOutput stores a rule output. Outputs that are not files, such as
+// container images, are exported to a temporary file which is stored instead.
+func (c *Cache) putOutput(ctx context.Context, r *project.Rule, key string, out project.Resource, buildID string) error {
+	if out.OnFilesystem() {
+		return c.putFile(ctx, key, out.Path(), r.Project().Name(), buildID)
+	}
+	portable, ok := out.(project.Portable)
+	if !ok {
+		return fmt.Errorf("output cannot be cached: %s", out.Path())
+	}
+	tmpDir, err := ioutil.TempDir("", "zim-export-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	exported := filepath.Join(tmpDir, "output")
+	if err := portable.Export(exported); err != nil {
+		return fmt.Errorf("failed to export %s: %s", out.Path(), err)
+	}
+	size, err := project.PathSize(exported)
+	if err != nil {
+		return err
+	}
+	if err := checkOutputSize(r, out, size); err != nil {
+		return err
+	}
+	return c.putFile(ctx, key, exported, r.Project().Name(), buildID)
+}
+
+// getOutput restores a rule output. Outputs that are not files are
+// downloaded to a temporary file and imported from it.
+func (c *Cache) getOutput(ctx context.Context, key string, out project.Resource, names []string) error {
+	if out.OnFilesystem() {
+		return c.get(ctx, key, out.Path(), names)
+	}
+	portable, ok := out.(project.Portable)
+	if !ok {
+		return fmt.Errorf("output cannot be cached: %s", out.Path())
+	}
+	tmpDir, err := ioutil.TempDir("", "zim-import-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	exported := filepath.Join(tmpDir, "output")
+	if err := c.get(ctx, key, exported, nil); err != nil {
+		return err
+	}
+	if err := portable.Import(exported); err != nil {
+		return fmt.Errorf("failed to import %s: %s", out.Path(), err)
+	}
+	return nil
+}
+
+// putFile stores a rule output file or directory using the cache layout
+func (c *Cache) putFile(ctx context.Context, key, src, projectName, buildID string) error {
+	if c.layout != LayoutContent {
+		return c.put(ctx, key, src, projectName, buildID)
+	}
+	item, meta, err := c.prepare(src, projectName, buildID)
 	if err != nil {
 		return err
 	}
-	meta := map[string]string{
-		"Hash": hash,
-		"User": c.user,
+	if item != src {
+		defer os.Remove(item)
+	}
+	return c.putContent(ctx, key, item, meta)
+}
+
+func (c *Cache) put(ctx context.Context, key, src, projectName, buildID string) error {
+	item, meta, err := c.prepare(src, projectName, buildID)
+	if err != nil {
+		return err
+	}
+	if item != src {
+		defer os.Remove(item)
+	}
+	if err := c.sign(key, meta); err != nil {
+		return err
 	}
 
 	// Store the file in the cache
-	return c.store.Put(ctx, key, src, meta)
+	return c.store.Put(ctx, key, item, meta)
+}
+
+// prepare returns the path of the file to store for src and its metadata.
+// Directories are packed into a temporary archive which the caller removes.
+func (c *Cache) prepare(src, projectName, buildID string) (string, map[string]string, error) {
+
+	meta := map[string]string{"User": c.user}
+	// The project allows the server to apply its retention policy
+	if projectName != "" {
+		meta["Project"] = projectName
+	}
+	if buildID != "" {
+		meta["BuildID"] = buildID
+	}
+
+	// Directories are stored in an archive that supports partial restores
+	item := src
+	if info, err := os.Stat(src); err == nil && info.IsDir() {
+		packed, err := packDirectoryFile(src)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to archive directory %s: %s", src, err)
+		}
+		item = packed
+		meta["Format"] = FormatSeekableDir
+	}
+
+	// The file hash will be added to the cache item metadata
+	hash, err := c.hasher.File(item)
+	if err != nil {
+		if item != src {
+			os.Remove(item)
+		}
+		return "", nil, err
+	}
+	meta["Hash"] = hash
+	meta["HashAlgorithm"] = c.hasher.Name()
+
+	// The size allows free space to be checked before downloading
+	if info, err := os.Stat(item); err == nil {
+		meta["Size"] = strconv.FormatInt(info.Size(), 10)
+	}
+
+	// Directory archives are already compressed
+	if item == src {
+		if item, err = c.compress(src, meta); err != nil {
+			return "", nil, err
+		}
+	}
+	return item, meta, nil
 }
 
-func (c *Cache) get(ctx context.Context, key, dst string) error {
+func (c *Cache) get(ctx context.Context, key, dst string, names []string) error {
 
-	// Determine if the cache contains an item for the key
-	remoteInfo, err := c.store.Head(ctx, key)
+	// Determine if the cache contains an item for the key. Manifests are
+	// followed to the content they refer to.
+	key, remoteInfo, err := c.head(ctx, key)
 	if err != nil {
 		if _, ok := err.(store.NotFound); ok {
 			return CacheMiss
 		}
 		return err
 	}
-	remoteHash := remoteInfo.Meta["Hash"]
+	size := itemSize(remoteInfo)
+	if remoteInfo.Meta["Format"] == FormatSeekableDir {
+		if err := project.CheckFreeSpace(filepath.Dir(dst), size); err != nil {
+			return err
+		}
+		return c.getDirectory(ctx, key, dst, names, remoteInfo)
+	}
+	remoteHash := contentHash(remoteInfo)
 
 	// If a local file exists that is identical to the one in the cache,
 	// then there is nothing to do
-	if localHash, err := c.hasher.File(dst); err == nil {
-		if remoteHash == localHash {
+	if hasher, err := metaHasher(remoteInfo); err == nil {
+		if localHash, err := hasher.File(dst); err == nil && remoteHash == localHash {
 			return nil
 		}
 	}
 
-	// Download the file from the cache
-	return c.store.Get(ctx, key, dst)
+	// Compressed files are downloaded to a temporary file and decompressed
+	if remoteInfo.Meta["Encoding"] != "" {
+		return c.getEncoded(ctx, key, dst, remoteInfo)
+	}
+
+	// Download the file from the cache, if there is room for it
+	if err := project.CheckFreeSpace(filepath.Dir(dst), size); err != nil {
+		return err
+	}
+	return c.downloadFile(ctx, key, dst, remoteInfo)
+}
+
+// checkOutputSize returns an error if an output is larger than the rule's
+// max_output_size
+func checkOutputSize(r *project.Rule, out project.Resource, size int64) error {
+	if limit := r.MaxOutputSize(); limit > 0 && size > limit {
+		return fmt.Errorf("output %s of rule %s is %s, larger than its "+
+			"max_output_size of %s, and will not be cached", out.Name(),
+			r.NodeID(), project.FormatBytes(size), project.FormatBytes(limit))
+	}
+	return nil
+}
+
+// keyHashName returns the name of the hash algorithm recorded in keys. It is
+// omitted for SHA-1 so that keys computed before the algorithm could be
+// selected remain valid.
+func keyHashName(hasher hash.Hasher) string {
+	if hasher.Name() == hash.SHA1Name {
+		return ""
+	}
+	return hasher.Name()
+}
+
+// metaHasher returns the Hasher for the algorithm recorded in the metadata
+// of a cache item. Items stored before it was recorded used SHA-1.
+func metaHasher(info store.ItemMeta) (hash.Hasher, error) {
+	name := info.Meta["HashAlgorithm"]
+	if name == "" {
+		name = hash.SHA1Name
+	}
+	return hash.New(name)
+}
+
+// itemSize returns the size of a cache item recorded in its metadata, or
+// zero if it is unknown
+func itemSize(info store.ItemMeta) int64 {
+	size, err := strconv.ParseInt(info.Meta["Size"], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return size
+}
+
+// getDirectory restores a directory from the cache. If names are given, only
+// those files are restored and other contents of the directory are kept.
+// Otherwise the directory is replaced.
+func (c *Cache) getDirectory(ctx context.Context, key, dst string, names []string, info store.ItemMeta) error {
+
+	// Selected files are read straight from the archive in the Store if it
+	// supports ranged reads. Signed items are downloaded in full since only
+	// the hash of the whole archive is signed.
+	getter, ok := c.store.(store.RangeGetter)
+	if size := itemSize(info); ok && len(names) > 0 && size > 0 && c.signer == nil {
+		return unpackRanges(ctx, getter, key, size, dst, names)
+	}
+
+	// Otherwise the archive is downloaded to a temporary file and unpacked
+	if err := project.CheckFreeSpace(os.TempDir(), itemSize(info)); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile("", "zim-dir-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := c.download(ctx, key, tmp.Name(), info); err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+	return unpackFile(tmp.Name(), dst, names)
 }
 
 // Key returns a struct of information that uniquely identifies the Rule's
@@ -207,14 +577,19 @@ func (c *Cache) get(ctx context.Context, key, dst string) error {
 func (c *Cache) Key(ctx context.Context, r *project.Rule) (*Key, error) {
 	// Previously had locking and an internal cache of keys that were
 	// already computed. Removed for now to focus on correctness first.
-	return c.buildKey(ctx, r)
+	key, err := c.buildKey(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	c.recordKey(r.NodeID(), key.String())
+	return key, nil
 }
 
 // Internal function that populates the Key data structure for a rule.
 // This call must remain safe for concurrent calls from multiple goroutines!
 func (c *Cache) buildKey(ctx context.Context, r *project.Rule) (*Key, error) {
 
-	inputs, err := r.Inputs()
+	inputs, err := r.KeyInputs()
 	if err != nil {
 		return nil, err
 	}
@@ -241,28 +616,42 @@ func (c *Cache) buildKey(ctx context.Context, r *project.Rule) (*Key, error) {
 	version := "0.0.4"
 
 	key := &Key{
-		Project:     r.Project().Name(),
-		Component:   r.Component().Name(),
-		Rule:        r.Name(),
-		Image:       r.Image(),
-		Inputs:      make([]*Entry, 0, len(inputs)),
-		Deps:        make([]*Entry, 0, len(deps)),
-		Env:         make([]*Entry, 0, len(env)),
-		Toolchain:   make([]*Entry, 0, len(toolchain)),
-		Commands:    make([]string, 0, len(r.Commands())),
-		OutputCount: len(r.Outputs()),
-		Version:     version,
-		Native:      r.IsNative(),
-	}
-
-	// Include the hash of every input file in the key
-	for _, input := range inputs.Paths() {
-		hash, err := c.hasher.File(input)
+		Hash:         keyHashName(c.hasher),
+		Project:      r.Project().Name(),
+		Component:    r.Component().Name(),
+		Rule:         r.Name(),
+		Image:        r.Image(),
+		Inputs:       make([]*Entry, 0, len(inputs)),
+		Deps:         make([]*Entry, 0, len(deps)),
+		Env:          make([]*Entry, 0, len(env)),
+		Toolchain:    make([]*Entry, 0, len(toolchain)),
+		Commands:     make([]string, 0, len(r.Commands())),
+		OutputCount:  len(r.Outputs()),
+		Version:      version,
+		Native:       r.IsNative(),
+		Platform:     r.Platform(),
+		Wrapper:      r.Wrapper(),
+		Shell:        r.Shell(),
+		ShellOptions: r.ShellOptions(),
+	}
+
+	// Include the hash of every input file in the key. Inputs that are not
+	// files, such as S3 objects, are hashed by their provider.
+	for _, input := range inputs {
+		if !input.OnFilesystem() {
+			hash, err := input.Hash()
+			if err != nil {
+				return nil, err
+			}
+			key.Inputs = append(key.Inputs, newEntry(input.Path(), hash))
+			continue
+		}
+		hash, err := c.inputHasher.File(input.Path())
 		if err != nil {
 			return nil, err
 		}
 		// Use relative paths for key stability on diff machines
-		relInput, err := filepath.Rel(root, input)
+		relInput, err := filepath.Rel(root, input.Path())
 		if err != nil {
 			return nil, err
 		}
@@ -19,10 +19,15 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/fugue/zim/exec"
 	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/store/filesystem"
 
 	"github.com/fugue/zim/definitions"
 	"github.com/stretchr/testify/assert"
@@ -182,3 +187,251 @@ func TestCacheKeyNonDocker(t *testing.T) {
 	// Known / golden values
 	assert.Equal(t, "a7c87a2e99c0bbc18b3afbbd65737d8538f33111", keyStr)
 }
+
+// fakeImages is a Provider of Portable Resources held in memory
+type fakeImages struct {
+	images map[string]string
+}
+
+func (f *fakeImages) Init(opts map[string]interface{}) error { return nil }
+
+func (f *fakeImages) Name() string { return "images" }
+
+func (f *fakeImages) New(uri string) project.Resource {
+	return &fakeImage{provider: f, uri: uri}
+}
+
+func (f *fakeImages) Match(pattern string) (project.Resources, error) {
+	return project.Resources{f.New(pattern)}, nil
+}
+
+type fakeImage struct {
+	provider *fakeImages
+	uri      string
+}
+
+func (img *fakeImage) Name() string { return img.uri }
+
+func (img *fakeImage) Path() string { return img.uri }
+
+func (img *fakeImage) Exists() (bool, error) {
+	_, found := img.provider.images[img.uri]
+	return found, nil
+}
+
+func (img *fakeImage) Hash() (string, error) { return img.provider.images[img.uri], nil }
+
+func (img *fakeImage) LastModified() (time.Time, error) { return time.Time{}, nil }
+
+func (img *fakeImage) OnFilesystem() bool { return false }
+
+func (img *fakeImage) Cacheable() bool { return true }
+
+func (img *fakeImage) AsFile() (string, error) { return "", fmt.Errorf("not supported") }
+
+func (img *fakeImage) Export(dst string) error {
+	return ioutil.WriteFile(dst, []byte(img.provider.images[img.uri]), 0644)
+}
+
+func (img *fakeImage) Import(src string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	img.provider.images[img.uri] = string(data)
+	return nil
+}
+
+func TestCachePortableOutput(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "Dockerfile"), "FROM scratch")
+
+	images := &fakeImages{images: map[string]string{"images://app:latest": "image layers"}}
+	cDef := &definitions.Component{
+		Name: "app",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"image": {
+				Providers: definitions.Providers{Outputs: "images"},
+				Inputs:    []string{"Dockerfile"},
+				Outputs:   []string{"images://app:latest"},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+		Providers:     []project.Provider{images},
+	})
+	require.Nil(t, err)
+	rule := p.Components().WithName("app").First().MustRule("image")
+	require.True(t, rule.Outputs()[0].Cacheable())
+
+	c := New(Opts{Store: filesystem.New(path.Join(tmpDir, "cache"))})
+	_, err = c.Write(ctx, rule)
+	require.Nil(t, err)
+
+	// The image is exported on write and imported on read
+	delete(images.images, "images://app:latest")
+	_, err = c.Read(ctx, rule)
+	require.Nil(t, err)
+	assert.Equal(t, "image layers", images.images["images://app:latest"])
+}
+
+func TestCacheMaxOutputSize(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "main.go"), "package main")
+
+	cDef := &definitions.Component{
+		Name: "app",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"build": {
+				Inputs:        []string{"main.go"},
+				Outputs:       []string{"bundle"},
+				MaxOutputSize: "1KB",
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	build := p.Components().WithName("app").First().MustRule("build")
+
+	// The sizes of the files in a directory output are added up
+	bundle := build.Outputs()[0].Path()
+	require.Nil(t, os.MkdirAll(bundle, 0755))
+	writeFile(path.Join(bundle, "a"), strings.Repeat("a", 600))
+	writeFile(path.Join(bundle, "b"), strings.Repeat("b", 600))
+
+	c := New(Opts{Store: filesystem.New(path.Join(tmpDir, "cache"))})
+	_, err = c.Write(ctx, build)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "larger than its max_output_size")
+
+	found, err := c.Contains(ctx, build)
+	require.Nil(t, err)
+	assert.False(t, found)
+
+	require.Nil(t, os.Remove(path.Join(bundle, "b")))
+	_, err = c.Write(ctx, build)
+	require.Nil(t, err)
+}
+
+// slowStore wraps a Store, recording the most transfers in progress at once
+type slowStore struct {
+	store.Store
+	mutex    sync.Mutex
+	active   int
+	maxCount int
+}
+
+func (s *slowStore) begin() {
+	s.mutex.Lock()
+	s.active++
+	if s.active > s.maxCount {
+		s.maxCount = s.active
+	}
+	s.mutex.Unlock()
+	time.Sleep(20 * time.Millisecond)
+}
+
+func (s *slowStore) end() {
+	s.mutex.Lock()
+	s.active--
+	s.mutex.Unlock()
+}
+
+func (s *slowStore) Get(ctx context.Context, key, dst string) error {
+	s.begin()
+	defer s.end()
+	return s.Store.Get(ctx, key, dst)
+}
+
+func (s *slowStore) Put(ctx context.Context, key, src string, meta map[string]string) error {
+	s.begin()
+	defer s.end()
+	return s.Store.Put(ctx, key, src, meta)
+}
+
+func TestCacheConcurrency(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "main.go"), "package main")
+
+	cDef := &definitions.Component{
+		Name: "app",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"build": {
+				Inputs:  []string{"main.go"},
+				Outputs: []string{"a", "b", "c", "d", "e"},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	build := p.Components().WithName("app").First().MustRule("build")
+
+	for _, out := range build.Outputs() {
+		require.Nil(t, os.MkdirAll(path.Dir(out.Path()), 0755))
+		writeFile(out.Path(), path.Base(out.Path()))
+	}
+
+	s := &slowStore{Store: filesystem.New(path.Join(tmpDir, "cache"))}
+	c := New(Opts{Store: s, Concurrency: 2})
+	keys, err := c.Write(ctx, build)
+	require.Nil(t, err)
+	require.Len(t, keys, 5)
+	assert.Equal(t, 2, s.maxCount)
+
+	// Outputs are restored concurrently too, and each to the right place
+	for _, out := range build.Outputs() {
+		require.Nil(t, os.Remove(out.Path()))
+	}
+	s.maxCount = 0
+	_, err = c.Read(ctx, build)
+	require.Nil(t, err)
+	assert.Equal(t, 2, s.maxCount)
+	for _, out := range build.Outputs() {
+		data, err := ioutil.ReadFile(out.Path())
+		require.Nil(t, err)
+		assert.Equal(t, path.Base(out.Path()), string(data))
+	}
+
+	// A missing output is a cache miss
+	require.Nil(t, s.Store.(store.ListDeleter).Delete(ctx, keys[3]))
+	_, err = c.Read(ctx, build)
+	require.Equal(t, CacheMiss, err)
+}
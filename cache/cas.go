@@ -0,0 +1,157 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fugue/zim/store"
+)
+
+const (
+	// LayoutKeys stores each rule output under its rule key. This is the
+	// default layout.
+	LayoutKeys = "keys"
+
+	// LayoutContent stores each rule output once under the hash of its
+	// content, with a small manifest under the rule key pointing to it
+	LayoutContent = "content"
+
+	// FormatManifest is the value of the "Format" metadata of cache items
+	// that refer to content stored elsewhere in the cache
+	FormatManifest = "manifest"
+)
+
+// Layouts lists the supported cache storage layouts
+var Layouts = []string{LayoutKeys, LayoutContent}
+
+// manifest is the body of a cache item that refers to content stored under
+// its hash. The metadata of the item holds the same information.
+type manifest struct {
+	Blob          string `json:"blob"`
+	Hash          string `json:"hash"`
+	HashAlgorithm string `json:"hash_algorithm"`
+	Size          int64  `json:"size"`
+}
+
+// blobPrefix begins the storage keys of content stored under its hash
+const blobPrefix = "cas-"
+
+// blobKey returns the storage key of content with the given hash
+func blobKey(algorithm, hash string) string {
+	return fmt.Sprintf("%s%s-%s", blobPrefix, algorithm, hash)
+}
+
+// IsContentKey returns true if the storage key is that of content stored
+// under its hash, which manifests stored under rule keys refer to
+func IsContentKey(key string) bool {
+	return strings.HasPrefix(key, blobPrefix)
+}
+
+// ReferencedContent returns the storage keys of the content that manifests
+// among the given keys refer to. Keys that are not manifests, including
+// those deleted since they were listed, are ignored.
+func ReferencedContent(ctx context.Context, s store.Store, keys []string) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	for _, key := range keys {
+		if IsContentKey(key) {
+			continue
+		}
+		info, err := s.Head(ctx, key)
+		if err != nil {
+			if _, ok := err.(store.NotFound); ok {
+				continue
+			}
+			return nil, err
+		}
+		if info.Meta["Format"] == FormatManifest && info.Meta["Blob"] != "" {
+			referenced[info.Meta["Blob"]] = true
+		}
+	}
+	return referenced, nil
+}
+
+// putContent stores a prepared item under the hash of its content, unless
+// identical content is already stored, and stores a manifest under the key
+func (c *Cache) putContent(ctx context.Context, key, src string, meta map[string]string) error {
+
+	// Content is found by the hash it has before any compression
+	blob := blobKey(meta["HashAlgorithm"], contentHash(store.ItemMeta{Meta: meta}))
+	if _, err := c.store.Head(ctx, blob); err != nil {
+		if _, ok := err.(store.NotFound); !ok {
+			return err
+		}
+		if err := c.sign(blob, meta); err != nil {
+			return err
+		}
+		if err := c.store.Put(ctx, blob, src, meta); err != nil {
+			return err
+		}
+	}
+
+	m := manifest{
+		Blob:          blob,
+		Hash:          meta["Hash"],
+		HashAlgorithm: meta["HashAlgorithm"],
+		Size:          itemSize(store.ItemMeta{Meta: meta}),
+	}
+	manifestPath, err := writeJSON(m)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(manifestPath)
+
+	manifestMeta := map[string]string{"Format": FormatManifest, "Blob": blob}
+	for _, k := range []string{"User", "Project", "BuildID"} {
+		if v, found := meta[k]; found {
+			manifestMeta[k] = v
+		}
+	}
+	if err := c.sign(key, manifestMeta); err != nil {
+		return err
+	}
+	return c.store.Put(ctx, key, manifestPath, manifestMeta)
+}
+
+// head returns the storage key and metadata of the item holding the content
+// for a key. Manifests are followed to the content they refer to, and a
+// NotFound error is returned if that content is missing. The signatures of
+// both are checked if the cache signs items.
+func (c *Cache) head(ctx context.Context, key string) (string, store.ItemMeta, error) {
+	info, err := c.store.Head(ctx, key)
+	if err != nil {
+		return "", info, err
+	}
+	if err := c.verifyMeta(key, info); err != nil {
+		return "", info, err
+	}
+	if info.Meta["Format"] != FormatManifest {
+		return key, info, nil
+	}
+	blob := info.Meta["Blob"]
+	if blob == "" {
+		return "", info, fmt.Errorf("cache manifest %s has no content key", key)
+	}
+	info, err = c.store.Head(ctx, blob)
+	if err != nil {
+		return "", info, err
+	}
+	if err := c.verifyMeta(blob, info); err != nil {
+		return "", info, err
+	}
+	return blob, info, nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentLayout(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "a.txt"), "input a")
+	writeFile(path.Join(cDir, "b.txt"), "input b")
+
+	cDef := &definitions.Component{
+		Name: "app",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"a": {Inputs: []string{"a.txt"}, Outputs: []string{"a.out"}},
+			"b": {Inputs: []string{"b.txt"}, Outputs: []string{"b.out"}},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	app := p.Components().WithName("app").First()
+	a, b := app.MustRule("a"), app.MustRule("b")
+
+	// Both rules produce identical outputs
+	require.Nil(t, os.MkdirAll(path.Dir(a.Outputs()[0].Path()), 0755))
+	writeFile(a.Outputs()[0].Path(), "same output")
+	writeFile(b.Outputs()[0].Path(), "same output")
+
+	fsStore := filesystem.New(path.Join(tmpDir, "cache"))
+	lister := fsStore.(store.ListDeleter)
+	c := New(Opts{Store: fsStore, Layout: LayoutContent})
+	_, err = c.Write(ctx, a)
+	require.Nil(t, err)
+	_, err = c.Write(ctx, b)
+	require.Nil(t, err)
+
+	// The content is stored once
+	blobs, err := lister.List(ctx, "cas-")
+	require.Nil(t, err)
+	require.Len(t, blobs, 1)
+
+	keyA, err := c.Key(ctx, a)
+	require.Nil(t, err)
+	info, err := fsStore.Head(ctx, keyA.String())
+	require.Nil(t, err)
+	require.Equal(t, FormatManifest, info.Meta["Format"])
+	require.Equal(t, blobs[0].Key, info.Meta["Blob"])
+	require.True(t, IsContentKey(blobs[0].Key))
+
+	// Manifests are found among other keys
+	referenced, err := ReferencedContent(ctx, fsStore,
+		[]string{keyA.String(), blobs[0].Key, "missing"})
+	require.Nil(t, err)
+	require.Equal(t, map[string]bool{blobs[0].Key: true}, referenced)
+
+	// Manifests are followed on read
+	require.Nil(t, os.Remove(b.Outputs()[0].Path()))
+	found, err := c.Contains(ctx, b)
+	require.Nil(t, err)
+	require.True(t, found)
+	_, err = c.Read(ctx, b)
+	require.Nil(t, err)
+	data, err := ioutil.ReadFile(b.Outputs()[0].Path())
+	require.Nil(t, err)
+	require.Equal(t, "same output", string(data))
+
+	// Exports include the content once
+	var archive bytes.Buffer
+	missing, err := c.Export(ctx, []*project.Rule{a, b}, &archive)
+	require.Nil(t, err)
+	require.Empty(t, missing)
+	imported := New(Opts{Store: filesystem.New(path.Join(tmpDir, "imported"))})
+	count, err := imported.Import(ctx, &archive)
+	require.Nil(t, err)
+	require.Equal(t, 5, count)
+
+	report, err := c.Verify(ctx, VerifyOpts{})
+	require.Nil(t, err)
+	require.Empty(t, report.Problems)
+
+	// A manifest whose content was removed is a cache miss
+	require.Nil(t, lister.Delete(ctx, blobs[0].Key))
+	found, err = c.Contains(ctx, a)
+	require.Nil(t, err)
+	require.False(t, found)
+	_, err = c.Read(ctx, a)
+	require.Equal(t, CacheMiss, err)
+
+	report, err = c.Verify(ctx, VerifyOpts{})
+	require.Nil(t, err)
+	require.Len(t, report.Problems, 2)
+	require.Contains(t, report.Problems[0].Reason, "is missing")
+}
@@ -0,0 +1,175 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+)
+
+const (
+	// CompressionNone stores files as they are. This is the default.
+	CompressionNone = "none"
+
+	// CompressionGzip stores files compressed with gzip
+	CompressionGzip = "gzip"
+)
+
+// Compressions lists the supported compression settings
+var Compressions = []string{CompressionNone, CompressionGzip}
+
+// compress replaces a prepared file with a compressed copy if the cache is
+// configured to compress and doing so makes it smaller. The "Encoding"
+// metadata records the compression, and the hash and size of the original
+// file are kept as "ContentHash" and "ContentSize". The path of the file to
+// store is returned, which is a temporary file if it was compressed.
+func (c *Cache) compress(item string, meta map[string]string) (string, error) {
+	if c.compression != CompressionGzip {
+		return item, nil
+	}
+	compressed, err := gzipFile(item)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(compressed)
+	if err != nil {
+		os.Remove(compressed)
+		return "", err
+	}
+	// Files that are already compressed are stored as they are
+	if size := itemSize(store.ItemMeta{Meta: meta}); size > 0 && info.Size() >= size {
+		os.Remove(compressed)
+		return item, nil
+	}
+	hash, err := c.hasher.File(compressed)
+	if err != nil {
+		os.Remove(compressed)
+		return "", err
+	}
+	meta["Encoding"] = CompressionGzip
+	meta["ContentHash"] = meta["Hash"]
+	meta["ContentSize"] = meta["Size"]
+	meta["Hash"] = hash
+	meta["Size"] = strconv.FormatInt(info.Size(), 10)
+	return compressed, nil
+}
+
+// getEncoded downloads a compressed file from the cache and decompresses it
+// to the destination
+func (c *Cache) getEncoded(ctx context.Context, key, dst string, info store.ItemMeta) error {
+	encoding := info.Meta["Encoding"]
+	if encoding != CompressionGzip {
+		return fmt.Errorf("unsupported cache item encoding %q for %s", encoding, key)
+	}
+	if err := project.CheckFreeSpace(os.TempDir(), itemSize(info)); err != nil {
+		return err
+	}
+	if err := project.CheckFreeSpace(filepath.Dir(dst), contentSize(info)); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile("", "zim-compressed-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := c.download(ctx, key, tmp.Name(), info); err != nil {
+		return err
+	}
+	return gunzipFile(tmp.Name(), dst)
+}
+
+// contentHash returns the hash of the original content of a cache item,
+// before any compression
+func contentHash(info store.ItemMeta) string {
+	if hash := info.Meta["ContentHash"]; hash != "" {
+		return hash
+	}
+	return info.Meta["Hash"]
+}
+
+// contentSize returns the size of the original content of a cache item,
+// before any compression, or zero if it is unknown
+func contentSize(info store.ItemMeta) int64 {
+	if size, err := strconv.ParseInt(info.Meta["ContentSize"], 10, 64); err == nil {
+		return size
+	}
+	return itemSize(info)
+}
+
+// gzipFile writes a compressed copy of the file to a temporary file and
+// returns its path
+func gzipFile(src string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile("", "zim-compress-")
+	if err != nil {
+		return "", err
+	}
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(out.Name())
+		return "", err
+	}
+	return out.Name(), nil
+}
+
+// gunzipFile decompresses the file to the destination
+func gunzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return fmt.Errorf("failed to decompress %s: %s", dst, err)
+	}
+	defer gz.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, gz); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to decompress %s: %s", dst, err)
+	}
+	return out.Close()
+}
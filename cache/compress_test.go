@@ -0,0 +1,114 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompression(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "main.go"), "package main")
+
+	cDef := &definitions.Component{
+		Name: "app",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"build": {Inputs: []string{"main.go"}, Outputs: []string{"app.bin"}},
+			"pack":  {Inputs: []string{"main.go"}, Outputs: []string{"app.tgz"}},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	app := p.Components().WithName("app").First()
+	build, pack := app.MustRule("build"), app.MustRule("pack")
+
+	// One output compresses well and the other not at all
+	text := strings.Repeat("compressible ", 1000)
+	random := make([]byte, 4096)
+	_, err = rand.Read(random)
+	require.Nil(t, err)
+	require.Nil(t, os.MkdirAll(path.Dir(build.Outputs()[0].Path()), 0755))
+	writeFile(build.Outputs()[0].Path(), text)
+	writeFile(pack.Outputs()[0].Path(), string(random))
+
+	fsStore := filesystem.New(path.Join(tmpDir, "cache"))
+	c := New(Opts{Store: fsStore, Compression: CompressionGzip})
+	buildKeys, err := c.Write(ctx, build)
+	require.Nil(t, err)
+	packKeys, err := c.Write(ctx, pack)
+	require.Nil(t, err)
+
+	info, err := fsStore.Head(ctx, buildKeys[0])
+	require.Nil(t, err)
+	require.Equal(t, CompressionGzip, info.Meta["Encoding"])
+	require.Equal(t, int64(len(text)), contentSize(info))
+	require.True(t, itemSize(info) < int64(len(text)))
+
+	info, err = fsStore.Head(ctx, packKeys[0])
+	require.Nil(t, err)
+	require.Equal(t, "", info.Meta["Encoding"])
+
+	// Compressed items are intact and are decompressed when read, whether
+	// or not the reader compresses what it writes
+	report, err := c.Verify(ctx, VerifyOpts{})
+	require.Nil(t, err)
+	require.Empty(t, report.Problems)
+
+	for _, r := range []*project.Rule{build, pack} {
+		require.Nil(t, os.Remove(r.Outputs()[0].Path()))
+	}
+	reader := New(Opts{Store: fsStore})
+	_, err = reader.Read(ctx, build)
+	require.Nil(t, err)
+	_, err = reader.Read(ctx, pack)
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadFile(build.Outputs()[0].Path())
+	require.Nil(t, err)
+	require.Equal(t, text, string(data))
+	data, err = ioutil.ReadFile(pack.Outputs()[0].Path())
+	require.Nil(t, err)
+	require.Equal(t, random, data)
+
+	// An unchanged output is compared against the original content
+	key, err := c.Key(ctx, build)
+	require.Nil(t, err)
+	mismatched, err := c.compareOutputs(ctx, build, key)
+	require.Nil(t, err)
+	require.Empty(t, mismatched)
+}
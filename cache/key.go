@@ -8,19 +8,24 @@ import (
 
 // Key contains information used to build a key
 type Key struct {
-	Project     string   `json:"project"`
-	Component   string   `json:"component"`
-	Rule        string   `json:"rule"`
-	Image       string   `json:"image"`
-	OutputCount int      `json:"output_count"`
-	Inputs      []*Entry `json:"inputs"`
-	Deps        []*Entry `json:"deps"`
-	Env         []*Entry `json:"env"`
-	Toolchain   []*Entry `json:"toolchain"`
-	Version     string   `json:"version"`
-	Commands    []string `json:"commands"`
-	Native      bool     `json:"native,omitempty"`
-	hex         string
+	Project      string   `json:"project"`
+	Component    string   `json:"component"`
+	Rule         string   `json:"rule"`
+	Image        string   `json:"image"`
+	OutputCount  int      `json:"output_count"`
+	Inputs       []*Entry `json:"inputs"`
+	Deps         []*Entry `json:"deps"`
+	Env          []*Entry `json:"env"`
+	Toolchain    []*Entry `json:"toolchain"`
+	Version      string   `json:"version"`
+	Commands     []string `json:"commands"`
+	Native       bool     `json:"native,omitempty"`
+	Platform     string   `json:"platform,omitempty"`
+	Wrapper      []string `json:"wrapper,omitempty"`
+	Shell        string   `json:"shell,omitempty"`
+	ShellOptions []string `json:"shell_options,omitempty"`
+	Hash         string   `json:"hash,omitempty"`
+	hex          string
 }
 
 // String returns the key as a hexadecimal string
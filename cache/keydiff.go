@@ -0,0 +1,176 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of differences between two keys
+const (
+	KeyAdded   = "added"
+	KeyRemoved = "removed"
+	KeyChanged = "changed"
+)
+
+// KeyChange describes one difference between two keys. Item names the field
+// that differs, e.g. "image", or an entry of a list, e.g. "input:main.go".
+type KeyChange struct {
+	Item   string `json:"item"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// DiffKeys returns the differences between two keys. Inputs, dependencies,
+// environment variables, and toolchain entries are compared by name.
+func DiffKeys(old, new *Key) []KeyChange {
+	var changes []KeyChange
+	diffValue := func(item, oldValue, newValue string) {
+		if oldValue != newValue {
+			changes = append(changes, KeyChange{
+				Item:   item,
+				Change: KeyChanged,
+				Old:    oldValue,
+				New:    newValue,
+			})
+		}
+	}
+	diffValue("project", old.Project, new.Project)
+	diffValue("component", old.Component, new.Component)
+	diffValue("rule", old.Rule, new.Rule)
+	diffValue("image", old.Image, new.Image)
+	diffValue("output_count", strconv.Itoa(old.OutputCount), strconv.Itoa(new.OutputCount))
+	diffValue("version", old.Version, new.Version)
+	diffValue("native", strconv.FormatBool(old.Native), strconv.FormatBool(new.Native))
+	diffValue("platform", old.Platform, new.Platform)
+	diffValue("wrapper", strings.Join(old.Wrapper, " "), strings.Join(new.Wrapper, " "))
+	diffValue("shell", old.Shell, new.Shell)
+	diffValue("shell_options", strings.Join(old.ShellOptions, " "), strings.Join(new.ShellOptions, " "))
+	diffValue("hash", old.Hash, new.Hash)
+
+	changes = append(changes, diffEntries("input", old.Inputs, new.Inputs)...)
+	changes = append(changes, diffEntries("dep", old.Deps, new.Deps)...)
+	changes = append(changes, diffEntries("env", old.Env, new.Env)...)
+	changes = append(changes, diffEntries("toolchain", old.Toolchain, new.Toolchain)...)
+
+	// Commands are compared in order since their order matters
+	count := len(old.Commands)
+	if len(new.Commands) > count {
+		count = len(new.Commands)
+	}
+	for i := 0; i < count; i++ {
+		item := fmt.Sprintf("command:%d", i+1)
+		switch {
+		case i >= len(old.Commands):
+			changes = append(changes, KeyChange{Item: item, Change: KeyAdded, New: new.Commands[i]})
+		case i >= len(new.Commands):
+			changes = append(changes, KeyChange{Item: item, Change: KeyRemoved, Old: old.Commands[i]})
+		default:
+			diffValue(item, old.Commands[i], new.Commands[i])
+		}
+	}
+	return changes
+}
+
+// diffEntries compares two lists of key entries by name
+func diffEntries(kind string, old, new []*Entry) []KeyChange {
+	oldHashes := map[string]string{}
+	for _, e := range old {
+		oldHashes[e.Name] = e.Hash
+	}
+	newHashes := map[string]string{}
+	for _, e := range new {
+		newHashes[e.Name] = e.Hash
+	}
+	var names []string
+	for name := range oldHashes {
+		names = append(names, name)
+	}
+	for name := range newHashes {
+		if _, found := oldHashes[name]; !found {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var changes []KeyChange
+	for _, name := range names {
+		item := fmt.Sprintf("%s:%s", kind, name)
+		oldHash, inOld := oldHashes[name]
+		newHash, inNew := newHashes[name]
+		switch {
+		case !inOld:
+			changes = append(changes, KeyChange{Item: item, Change: KeyAdded, New: newHash})
+		case !inNew:
+			changes = append(changes, KeyChange{Item: item, Change: KeyRemoved, Old: oldHash})
+		case oldHash != newHash:
+			changes = append(changes, KeyChange{Item: item, Change: KeyChanged, Old: oldHash, New: newHash})
+		}
+	}
+	return changes
+}
+
+// LoadKey reads the composition of a key from the metadata stored alongside
+// the outputs written with it
+func (c *Cache) LoadKey(ctx context.Context, key string) (*Key, error) {
+	tmp, err := ioutil.TempFile("", "zim-key-")
+	if err != nil {
+		return nil, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	if err := c.get(ctx, fmt.Sprintf("%s.json", key), tmp.Name(), nil); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		return nil, err
+	}
+	var k Key
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, err
+	}
+	k.hex = key
+	return &k, nil
+}
+
+// Keys returns the keys computed by the cache so far, by rule node ID
+func (c *Cache) Keys() map[string]string {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+	keys := make(map[string]string, len(c.keys))
+	for id, key := range c.keys {
+		keys[id] = key
+	}
+	return keys
+}
+
+// recordKey remembers the key computed for a rule
+func (c *Cache) recordKey(id, key string) {
+	c.keysMutex.Lock()
+	defer c.keysMutex.Unlock()
+	if c.keys == nil {
+		c.keys = map[string]string{}
+	}
+	c.keys[id] = key
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffKeys(t *testing.T) {
+
+	old := &Key{
+		Project:   "test-project",
+		Component: "my-component",
+		Rule:      "build",
+		Image:     "golang:1.13",
+		Inputs: []*Entry{
+			newEntry("main.go", "a1"),
+			newEntry("util.go", "b1"),
+		},
+		Env:       []*Entry{newEntry("GOOS", "c1")},
+		Toolchain: []*Entry{newEntry("go", "d1")},
+		Commands:  []string{"go build"},
+	}
+	current := &Key{
+		Project:   "test-project",
+		Component: "my-component",
+		Rule:      "build",
+		Image:     "golang:1.14",
+		Inputs: []*Entry{
+			newEntry("main.go", "a2"),
+			newEntry("extra.go", "e1"),
+		},
+		Env:       []*Entry{newEntry("GOOS", "c1")},
+		Toolchain: []*Entry{newEntry("go", "d1")},
+		Commands:  []string{"go build", "strip my-exe"},
+	}
+
+	require.Empty(t, DiffKeys(old, old))
+	require.Equal(t, []KeyChange{
+		{Item: "image", Change: KeyChanged, Old: "golang:1.13", New: "golang:1.14"},
+		{Item: "input:extra.go", Change: KeyAdded, New: "e1"},
+		{Item: "input:main.go", Change: KeyChanged, Old: "a1", New: "a2"},
+		{Item: "input:util.go", Change: KeyRemoved, Old: "b1"},
+		{Item: "command:2", Change: KeyAdded, New: "strip my-exe"},
+	}, DiffKeys(old, current))
+}
+
+func TestLoadKey(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "my-component")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "main.go"), "some source code")
+
+	cDef := &definitions.Component{
+		Name: "my-component",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"build": {
+				Inputs:  []string{"main.go"},
+				Outputs: []string{"my-exe"},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	build := p.Components().WithName("my-component").First().MustRule("build")
+
+	outputPath := build.Outputs()[0].Path()
+	require.Nil(t, os.MkdirAll(path.Dir(outputPath), 0755))
+	writeFile(outputPath, "executable")
+
+	c := New(Opts{Store: filesystem.New(path.Join(tmpDir, "store"))})
+	_, err = c.Write(ctx, build)
+	require.Nil(t, err)
+
+	// The key used by the write was recorded
+	keys := c.Keys()
+	require.Len(t, keys, 1)
+	keyHex := keys["my-component.build"]
+	require.NotEmpty(t, keyHex)
+
+	key, err := c.LoadKey(ctx, keyHex)
+	require.Nil(t, err)
+	require.Equal(t, keyHex, key.String())
+	require.Equal(t, "my-component", key.Component)
+	require.Len(t, key.Inputs, 1)
+
+	// After an input changes, the difference is found
+	writeFile(path.Join(cDir, "main.go"), "new source code")
+	current, err := c.Key(ctx, build)
+	require.Nil(t, err)
+	changes := DiffKeys(key, current)
+	require.Len(t, changes, 1)
+	require.Equal(t, "input:"+key.Inputs[0].Name, changes[0].Item)
+	require.Equal(t, KeyChanged, changes[0].Change)
+
+	_, err = c.LoadKey(ctx, "missing")
+	require.Equal(t, CacheMiss, err)
+}
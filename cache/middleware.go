@@ -34,6 +34,24 @@ func NewMiddleware(c *Cache) project.RunnerBuilder {
 				return runner.Run(ctx, r, opts)
 			}
 
+			// In dry-run mode, only check whether the outputs are cached
+			if opts.DryRun {
+				if c.mode != WriteOnly {
+					found, err := c.Contains(ctx, r)
+					if err != nil {
+						return project.Error, err
+					}
+					if found {
+						return project.Cached, nil
+					}
+				}
+				return runner.Run(ctx, r, opts)
+			}
+
+			if c.mode == Audit {
+				return c.auditRun(ctx, r, opts, runner)
+			}
+
 			if c.mode != WriteOnly {
 				// Download matching outputs from the cache if they exist
 				_, err := c.Read(ctx, r)
@@ -51,7 +69,7 @@ func NewMiddleware(c *Cache) project.RunnerBuilder {
 			// Code "OK" indicates the rule was built which means we can
 			// store its outputs in the cache
 			if code == project.OK {
-				if _, err := c.Write(ctx, r); err != nil {
+				if _, err := c.WriteBuild(ctx, r, opts.BuildID); err != nil {
 					return project.Error, err
 				}
 			}
@@ -0,0 +1,326 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/store"
+)
+
+// Directory outputs are stored in a seekable archive so that individual files
+// can be restored without decompressing the whole directory. Each file is an
+// independently compressed gzip frame. The frames are followed by a JSON
+// index of their locations, the index length, and a magic string:
+//
+//	[frame]...[index][index length: uint64 LE][magic]
+//
+// Reading the fixed size trailer and then the index gives the byte range of
+// every file, which allows fetching only the frames that are needed from
+// Stores that support ranged reads.
+
+const (
+	// FormatSeekableDir is the value of the "Format" metadata of cache items
+	// holding a directory in the seekable archive format
+	FormatSeekableDir = "seekable-dir"
+
+	seekableMagic      = "ZIMSEEK1"
+	seekableTrailerLen = 8 + len(seekableMagic)
+)
+
+// seekableEntry locates one file or directory within a seekable archive
+type seekableEntry struct {
+	Name   string      `json:"name"`
+	Mode   os.FileMode `json:"mode"`
+	Offset int64       `json:"offset"`
+	Length int64       `json:"length"`
+}
+
+type seekableIndex struct {
+	Entries []seekableEntry `json:"entries"`
+}
+
+// packDirectory writes the contents of the directory to w in the seekable
+// archive format. Entries are sorted by name so that the output is the same
+// for identical directories.
+func packDirectory(dir string, w io.Writer) error {
+
+	var names []string
+	err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == dir {
+			return nil
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return fmt.Errorf("unsupported file type in directory output: %s", p)
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	var index seekableIndex
+	var offset int64
+	for _, name := range names {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		info, err := os.Stat(p)
+		if err != nil {
+			return err
+		}
+		entry := seekableEntry{Name: name, Mode: info.Mode(), Offset: offset}
+		if info.Mode().IsRegular() {
+			if entry.Length, err = writeFrame(w, p); err != nil {
+				return err
+			}
+		}
+		offset += entry.Length
+		index.Entries = append(index.Entries, entry)
+	}
+
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	trailer := make([]byte, 8, seekableTrailerLen)
+	binary.LittleEndian.PutUint64(trailer, uint64(len(indexData)))
+	trailer = append(trailer, seekableMagic...)
+	if _, err := w.Write(indexData); err != nil {
+		return err
+	}
+	_, err = w.Write(trailer)
+	return err
+}
+
+// packDirectoryFile writes the directory to a temporary seekable archive and
+// returns its path
+func packDirectoryFile(dir string) (string, error) {
+	f, err := ioutil.TempFile("", "zim-dir-")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if err := packDirectory(dir, f); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// writeFrame compresses the file as one gzip frame, returning its length
+func writeFrame(w io.Writer, p string) (int64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var frame bytes.Buffer
+	gz := gzip.NewWriter(&frame)
+	if _, err := io.Copy(gz, f); err != nil {
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, &frame)
+}
+
+// readSeekableIndex reads the index of a seekable archive of the given size
+func readSeekableIndex(r io.ReaderAt, size int64) (*seekableIndex, error) {
+	if size < int64(seekableTrailerLen) {
+		return nil, errors.New("invalid seekable archive: too short")
+	}
+	trailer := make([]byte, seekableTrailerLen)
+	if _, err := r.ReadAt(trailer, size-int64(seekableTrailerLen)); err != nil {
+		return nil, err
+	}
+	if string(trailer[8:]) != seekableMagic {
+		return nil, errors.New("invalid seekable archive: bad magic")
+	}
+	indexLen := int64(binary.LittleEndian.Uint64(trailer[:8]))
+	if indexLen > size-int64(seekableTrailerLen) {
+		return nil, errors.New("invalid seekable archive: bad index length")
+	}
+	indexData := make([]byte, indexLen)
+	if _, err := r.ReadAt(indexData, size-int64(seekableTrailerLen)-indexLen); err != nil {
+		return nil, err
+	}
+	index := &seekableIndex{}
+	if err := json.Unmarshal(indexData, index); err != nil {
+		return nil, fmt.Errorf("invalid seekable archive index: %s", err)
+	}
+	return index, nil
+}
+
+// selectEntries returns the index entries matching the given names, which
+// may name files or directories. All entries are returned if names is empty.
+func (index *seekableIndex) selectEntries(names []string) ([]seekableEntry, error) {
+	if len(names) == 0 {
+		return index.Entries, nil
+	}
+	var selected []seekableEntry
+	for _, name := range names {
+		name = strings.Trim(filepath.ToSlash(name), "/")
+		found := false
+		for _, entry := range index.Entries {
+			if entry.Name == name || strings.HasPrefix(entry.Name, name+"/") {
+				selected = append(selected, entry)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("not found in directory output: %s", name)
+		}
+	}
+	return selected, nil
+}
+
+// frameOpener returns a reader of the compressed frame of an entry
+type frameOpener func(entry seekableEntry) (io.ReadCloser, error)
+
+// fileFrames returns a frameOpener that reads frames from an archive file
+func fileFrames(r io.ReaderAt) frameOpener {
+	return func(entry seekableEntry) (io.ReadCloser, error) {
+		return ioutil.NopCloser(io.NewSectionReader(r, entry.Offset, entry.Length)), nil
+	}
+}
+
+// extractEntries writes the given entries of a seekable archive beneath the
+// destination directory. Parent directories are created as needed.
+func extractEntries(open frameOpener, entries []seekableEntry, dst string) error {
+	for _, entry := range entries {
+		if strings.Contains("/"+entry.Name+"/", "/../") {
+			return fmt.Errorf("invalid path in seekable archive: %s", entry.Name)
+		}
+		p := filepath.Join(dst, filepath.FromSlash(entry.Name))
+		if entry.Mode.IsDir() {
+			if err := os.MkdirAll(p, entry.Mode.Perm()|0700); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := extractFrame(open, entry, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractFrame(open frameOpener, entry seekableEntry, p string) error {
+	frame, err := open(entry)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", entry.Name, err)
+	}
+	defer frame.Close()
+	gz, err := gzip.NewReader(frame)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", entry.Name, err)
+	}
+	defer gz.Close()
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, entry.Mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, gz); err != nil {
+		return fmt.Errorf("failed to read %s: %s", entry.Name, err)
+	}
+	return nil
+}
+
+// unpackFile extracts the named entries of the seekable archive file into
+// the destination directory, or all entries if no names are given
+func unpackFile(src, dst string, names []string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	index, err := readSeekableIndex(f, info.Size())
+	if err != nil {
+		return err
+	}
+	entries, err := index.selectEntries(names)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return extractEntries(fileFrames(f), entries, dst)
+}
+
+// storeReaderAt reads ranges of an item in a Store
+type storeReaderAt struct {
+	ctx    context.Context
+	getter store.RangeGetter
+	key    string
+}
+
+func (r *storeReaderAt) ReadAt(p []byte, offset int64) (int, error) {
+	reader, err := r.getter.GetRange(r.ctx, r.key, offset, int64(len(p)))
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+	return io.ReadFull(reader, p)
+}
+
+// unpackRanges extracts the named entries of a seekable archive in a Store
+// into the destination directory. Only the archive's trailer, index, and the
+// frames of the named entries are read, each with one ranged read.
+func unpackRanges(ctx context.Context, getter store.RangeGetter, key string, size int64, dst string, names []string) error {
+	r := &storeReaderAt{ctx: ctx, getter: getter, key: key}
+	index, err := readSeekableIndex(r, size)
+	if err != nil {
+		return err
+	}
+	entries, err := index.selectEntries(names)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	return extractEntries(func(entry seekableEntry) (io.ReadCloser, error) {
+		return getter.GetRange(ctx, key, entry.Offset, entry.Length)
+	}, entries, dst)
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeTestDir(t *testing.T, dir string) {
+	require.Nil(t, os.MkdirAll(path.Join(dir, "bin"), 0755))
+	require.Nil(t, os.MkdirAll(path.Join(dir, "empty"), 0755))
+	writeFile(path.Join(dir, "bin", "app"), "app binary")
+	writeFile(path.Join(dir, "bin", "tool"), "tool binary")
+	writeFile(path.Join(dir, "README"), "readme")
+}
+
+func readTestFile(t *testing.T, p string) string {
+	data, err := ioutil.ReadFile(p)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func TestSeekableArchive(t *testing.T) {
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	src := path.Join(tmpDir, "src")
+	writeTestDir(t, src)
+
+	var archive bytes.Buffer
+	require.Nil(t, packDirectory(src, &archive))
+
+	// Packing is deterministic
+	var again bytes.Buffer
+	require.Nil(t, packDirectory(src, &again))
+	require.Equal(t, archive.Bytes(), again.Bytes())
+
+	r := bytes.NewReader(archive.Bytes())
+	index, err := readSeekableIndex(r, int64(archive.Len()))
+	require.Nil(t, err)
+	var names []string
+	for _, entry := range index.Entries {
+		names = append(names, entry.Name)
+	}
+	require.Equal(t, []string{"README", "bin", "bin/app", "bin/tool", "empty"}, names)
+
+	// Restore a single file
+	entries, err := index.selectEntries([]string{"bin/app"})
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	partial := path.Join(tmpDir, "partial")
+	require.Nil(t, extractEntries(fileFrames(r), entries, partial))
+	assert.Equal(t, "app binary", readTestFile(t, path.Join(partial, "bin", "app")))
+	_, err = os.Stat(path.Join(partial, "README"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Restore everything
+	entries, err = index.selectEntries(nil)
+	require.Nil(t, err)
+	full := path.Join(tmpDir, "full")
+	require.Nil(t, extractEntries(fileFrames(r), entries, full))
+	assert.Equal(t, "tool binary", readTestFile(t, path.Join(full, "bin", "tool")))
+	assert.Equal(t, "readme", readTestFile(t, path.Join(full, "README")))
+	info, err := os.Stat(path.Join(full, "empty"))
+	require.Nil(t, err)
+	assert.True(t, info.IsDir())
+
+	_, err = index.selectEntries([]string{"missing"})
+	assert.NotNil(t, err)
+
+	_, err = readSeekableIndex(bytes.NewReader([]byte("not an archive")), 14)
+	assert.NotNil(t, err)
+}
+
+func TestCacheDirectoryOutput(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "my-component")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "main.go"), "some source code")
+
+	cDef := &definitions.Component{
+		Name: "my-component",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"bundle": {
+				Inputs:  []string{"main.go"},
+				Outputs: []string{"bundle"},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	bundle := p.Components().WithName("my-component").First().MustRule("bundle")
+
+	outputDir := bundle.Outputs()[0].Path()
+	writeTestDir(t, outputDir)
+
+	fs := filesystem.New(path.Join(tmpDir, "cache"))
+	c := New(Opts{Store: fs})
+	_, err = c.Write(ctx, bundle)
+	require.Nil(t, err)
+
+	// Restoring one file leaves the rest of the directory alone
+	require.Nil(t, os.RemoveAll(outputDir))
+	require.Nil(t, os.MkdirAll(outputDir, 0755))
+	writeFile(path.Join(outputDir, "local"), "local file")
+	_, err = c.ReadFiles(ctx, bundle, []string{"bin/app"})
+	require.Nil(t, err)
+	assert.Equal(t, "app binary", readTestFile(t, path.Join(outputDir, "bin", "app")))
+	assert.Equal(t, "local file", readTestFile(t, path.Join(outputDir, "local")))
+	_, err = os.Stat(path.Join(outputDir, "bin", "tool"))
+	assert.True(t, os.IsNotExist(err))
+
+	// A full read replaces the directory
+	_, err = c.Read(ctx, bundle)
+	require.Nil(t, err)
+	assert.Equal(t, "tool binary", readTestFile(t, path.Join(outputDir, "bin", "tool")))
+	_, err = os.Stat(path.Join(outputDir, "local"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Stores that support ranged reads restore one file without reading
+	// the whole archive: only its trailer, index, and the file's frame
+	ranged := &rangeOnlyStore{Store: fs}
+	c = New(Opts{Store: ranged})
+	require.Nil(t, os.RemoveAll(outputDir))
+	_, err = c.ReadFiles(ctx, bundle, []string{"bin/tool"})
+	require.Nil(t, err)
+	assert.Equal(t, "tool binary", readTestFile(t, path.Join(outputDir, "bin", "tool")))
+	assert.Equal(t, 3, ranged.ranges)
+}
+
+// rangeOnlyStore fails whole item reads and counts ranged reads
+type rangeOnlyStore struct {
+	store.Store
+	ranges int
+}
+
+func (s *rangeOnlyStore) Get(ctx context.Context, key, dst string) error {
+	return fmt.Errorf("unexpected Get of %s", key)
+}
+
+func (s *rangeOnlyStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	s.ranges++
+	return s.Store.(store.RangeGetter).GetRange(ctx, key, offset, length)
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fugue/zim/store"
+)
+
+// ErrBadSignature indicates a signature does not match what was signed
+var ErrBadSignature = errors.New("signature does not match")
+
+// Signer creates and checks the signatures of cache items. Signatures are
+// stored in item metadata, detached from the items themselves.
+type Signer interface {
+
+	// Sign returns the signature of the message
+	Sign(message []byte) (string, error)
+
+	// Verify returns ErrBadSignature if the signature is not that of the
+	// message
+	Verify(message []byte, signature string) error
+}
+
+type hmacSigner struct {
+	key []byte
+}
+
+// NewHMACSigner returns a Signer using HMAC-SHA256 with a secret key shared
+// by everyone who reads from and writes to the cache
+func NewHMACSigner(key []byte) Signer {
+	return &hmacSigner{key: key}
+}
+
+func (s *hmacSigner) Sign(message []byte) (string, error) {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(message)
+	return "hmac-sha256:" + base64.StdEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+func (s *hmacSigner) Verify(message []byte, signature string) error {
+	expected, err := s.Sign(message)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// signedMessage returns what is signed for the item stored under a key. For
+// content this is the hash of the stored file and for a manifest it is the
+// key of the content it refers to, which binds both to the key.
+func signedMessage(key string, meta map[string]string) []byte {
+	if meta["Format"] == FormatManifest {
+		return []byte(fmt.Sprintf("zim-manifest\n%s\n%s", key, meta["Blob"]))
+	}
+	return []byte(fmt.Sprintf("zim-item\n%s\n%s\n%s", key, meta["HashAlgorithm"], meta["Hash"]))
+}
+
+// sign adds a signature to the metadata of an item that will be stored under
+// the key, if the cache signs items
+func (c *Cache) sign(key string, meta map[string]string) error {
+	if c.signer == nil {
+		return nil
+	}
+	signature, err := c.signer.Sign(signedMessage(key, meta))
+	if err != nil {
+		return fmt.Errorf("failed to sign cache item %s: %s", key, err)
+	}
+	meta["Signature"] = signature
+	return nil
+}
+
+// verifyMeta checks the signature in the metadata of the item stored under
+// the key, if the cache signs items. Unsigned items are treated as missing,
+// so that they are replaced by signed ones when rules run again.
+func (c *Cache) verifyMeta(key string, info store.ItemMeta) error {
+	if c.signer == nil {
+		return nil
+	}
+	signature := info.Meta["Signature"]
+	if signature == "" {
+		return store.NotFound(fmt.Sprintf("cache item %s is not signed", key))
+	}
+	if err := c.signer.Verify(signedMessage(key, info.Meta), signature); err != nil {
+		return fmt.Errorf("cache item %s failed signature verification: %s", key, err)
+	}
+	return nil
+}
+
+// download gets the item stored under the key. If the cache signs items, the
+// hash of the download is checked against the signed hash and the download
+// is removed if they differ, before it can be used.
+func (c *Cache) download(ctx context.Context, key, dst string, info store.ItemMeta) error {
+	if err := c.store.Get(ctx, key, dst); err != nil {
+		return err
+	}
+	if c.signer == nil {
+		return nil
+	}
+	hasher, err := metaHasher(info)
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	hash, err := hasher.File(dst)
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if hash != info.Meta["Hash"] {
+		os.Remove(dst)
+		return fmt.Errorf("cache item %s failed signature verification: "+
+			"its hash is %s but %s was signed", key, hash, info.Meta["Hash"])
+	}
+	return nil
+}
+
+// downloadFile gets the file stored under the key. When items are signed it
+// is downloaded beside the destination and only moved into place once
+// verified.
+func (c *Cache) downloadFile(ctx context.Context, key, dst string, info store.ItemMeta) error {
+	if c.signer == nil {
+		return c.download(ctx, key, dst, info)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".zim-download-")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	if err := c.download(ctx, key, tmp.Name(), info); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHMACSigner(t *testing.T) {
+	signer := NewHMACSigner([]byte("team key"))
+	signature, err := signer.Sign([]byte("message"))
+	require.Nil(t, err)
+	require.Nil(t, signer.Verify([]byte("message"), signature))
+	require.Equal(t, ErrBadSignature, signer.Verify([]byte("other message"), signature))
+	require.Equal(t, ErrBadSignature, NewHMACSigner([]byte("other key")).Verify([]byte("message"), signature))
+}
+
+func TestSignedItems(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	cDir := path.Join(tmpDir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	writeFile(path.Join(cDir, "a.txt"), "input a")
+	writeFile(path.Join(cDir, "b.txt"), "input b")
+
+	cDef := &definitions.Component{
+		Name: "app",
+		Path: path.Join(cDir, "component.yaml"),
+		Rules: map[string]definitions.Rule{
+			"a": {Inputs: []string{"a.txt"}, Outputs: []string{"a.out"}},
+			"b": {Inputs: []string{"b.txt"}, Outputs: []string{"b.out"}},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		ProjectDef:    &definitions.Project{Name: "test-project"},
+		Root:          tmpDir,
+		ComponentDefs: []*definitions.Component{cDef},
+	})
+	require.Nil(t, err)
+	app := p.Components().WithName("app").First()
+	a, b := app.MustRule("a"), app.MustRule("b")
+	aOut, bOut := a.Outputs()[0].Path(), b.Outputs()[0].Path()
+	require.Nil(t, os.MkdirAll(path.Dir(aOut), 0755))
+
+	signer := NewHMACSigner([]byte("team key"))
+	fsStore := filesystem.New(path.Join(tmpDir, "cache"))
+	c := New(Opts{Store: fsStore, Signer: signer})
+
+	// Items written without a signer are cache misses
+	writeFile(aOut, "output a")
+	_, err = New(Opts{Store: fsStore}).Write(ctx, a)
+	require.Nil(t, err)
+	_, err = c.Read(ctx, a)
+	require.Equal(t, CacheMiss, err)
+
+	// Signed items are verified and restored
+	keys, err := c.Write(ctx, a)
+	require.Nil(t, err)
+	require.Nil(t, os.Remove(aOut))
+	_, err = c.Read(ctx, a)
+	require.Nil(t, err)
+	data, err := ioutil.ReadFile(aOut)
+	require.Nil(t, err)
+	require.Equal(t, "output a", string(data))
+
+	// A different key doesn't verify them
+	_, err = New(Opts{Store: fsStore, Signer: NewHMACSigner([]byte("other key"))}).Read(ctx, a)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "failed signature verification")
+
+	// Content replaced under the original signature is not installed
+	info, err := fsStore.Head(ctx, keys[0])
+	require.Nil(t, err)
+	tampered := path.Join(tmpDir, "tampered")
+	writeFile(tampered, "malicious output")
+	require.Nil(t, fsStore.Put(ctx, keys[0], tampered, info.Meta))
+	require.Nil(t, os.Remove(aOut))
+	_, err = c.Read(ctx, a)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "failed signature verification")
+	_, err = os.Stat(aOut)
+	require.True(t, os.IsNotExist(err))
+
+	// So are metadata changed to match the replaced content
+	hash, err := c.hasher.File(tampered)
+	require.Nil(t, err)
+	info.Meta["Hash"] = hash
+	require.Nil(t, fsStore.Put(ctx, keys[0], tampered, info.Meta))
+	_, err = c.Read(ctx, a)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "failed signature verification")
+
+	// Manifests can't be pointed at other content
+	c = New(Opts{Store: fsStore, Signer: signer, Layout: LayoutContent})
+	writeFile(aOut, "output a")
+	writeFile(bOut, "output b")
+	aKeys, err := c.Write(ctx, a)
+	require.Nil(t, err)
+	bKeys, err := c.Write(ctx, b)
+	require.Nil(t, err)
+	aInfo, err := fsStore.Head(ctx, aKeys[0])
+	require.Nil(t, err)
+	bInfo, err := fsStore.Head(ctx, bKeys[0])
+	require.Nil(t, err)
+	aInfo.Meta["Blob"] = bInfo.Meta["Blob"]
+	manifest := path.Join(tmpDir, "manifest")
+	require.Nil(t, fsStore.Get(ctx, bKeys[0], manifest))
+	require.Nil(t, fsStore.Put(ctx, aKeys[0], manifest, aInfo.Meta))
+	_, err = c.Read(ctx, a)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "failed signature verification")
+
+	report, err := c.Verify(ctx, VerifyOpts{})
+	require.Nil(t, err)
+	require.NotEmpty(t, report.Problems)
+}
@@ -0,0 +1,195 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+
+	"github.com/fugue/zim/store"
+)
+
+// VerifyOpts configures a consistency check of the cache
+type VerifyOpts struct {
+
+	// Prefix limits the check to items with keys beginning with it
+	Prefix string
+
+	// Sample is the fraction of items to check, between zero and one.
+	// All items are checked if it is zero or one.
+	Sample float64
+
+	// Delete removes the items that fail the check
+	Delete bool
+}
+
+// Problem describes a cache item that failed verification
+type Problem struct {
+	Key     string `json:"key"`
+	Reason  string `json:"reason"`
+	Deleted bool   `json:"deleted"`
+}
+
+// VerifyReport describes the result of a consistency check of the cache
+type VerifyReport struct {
+	Items    int       `json:"items"`
+	Checked  int       `json:"checked"`
+	Problems []Problem `json:"problems"`
+}
+
+// Verify checks that items in the cache are intact. Each item checked is
+// downloaded and its size and hash are compared against those recorded in
+// its metadata when it was stored, using the hash algorithm it was stored
+// with. Directory archives must also have a readable index. The cache store
+// must support listing items.
+func (c *Cache) Verify(ctx context.Context, opts VerifyOpts) (VerifyReport, error) {
+
+	var report VerifyReport
+
+	lister, ok := c.store.(store.ListDeleter)
+	if !ok {
+		return report, errors.New("the cache does not support listing entries")
+	}
+	items, err := lister.List(ctx, opts.Prefix)
+	if err != nil {
+		return report, err
+	}
+	report.Items = len(items)
+	items = sampleItems(items, opts.Sample)
+
+	tmp, err := ioutil.TempFile("", "zim-verify-")
+	if err != nil {
+		return report, err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return report, ctx.Err()
+		}
+		reason, err := c.verifyItem(ctx, item, tmp.Name())
+		if err != nil {
+			return report, err
+		}
+		report.Checked++
+		if reason == "" {
+			continue
+		}
+		problem := Problem{Key: item.Key, Reason: reason}
+		if opts.Delete {
+			if err := lister.Delete(ctx, item.Key); err != nil {
+				return report, err
+			}
+			problem.Deleted = true
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+	return report, nil
+}
+
+// verifyItem checks one cache item, using tmp as a download location. The
+// reason the item is corrupt is returned, or an empty string if it is intact.
+func (c *Cache) verifyItem(ctx context.Context, item store.Item, tmp string) (string, error) {
+
+	info, err := c.store.Head(ctx, item.Key)
+	if err != nil {
+		if _, ok := err.(store.NotFound); ok {
+			return "metadata is missing", nil
+		}
+		return "", err
+	}
+	if err := c.verifyMeta(item.Key, info); err != nil {
+		return err.Error(), nil
+	}
+	// A manifest is intact if the content it refers to is
+	if info.Meta["Format"] == FormatManifest {
+		return c.verifyManifest(ctx, info, tmp)
+	}
+	expectedHash := info.Meta["Hash"]
+	if expectedHash == "" {
+		return "metadata has no hash", nil
+	}
+	// Size was not recorded by older versions
+	if size := itemSize(info); size > 0 && size != item.Size {
+		return fmt.Sprintf("size is %d bytes, expected %d", item.Size, size), nil
+	}
+	hasher, err := metaHasher(info)
+	if err != nil {
+		return err.Error(), nil
+	}
+	if err := c.store.Get(ctx, item.Key, tmp); err != nil {
+		return "", err
+	}
+	hash, err := hasher.File(tmp)
+	if err != nil {
+		return "", err
+	}
+	if hash != expectedHash {
+		return fmt.Sprintf("hash is %s, expected %s", hash, expectedHash), nil
+	}
+	if info.Meta["Format"] == FormatSeekableDir {
+		f, err := os.Open(tmp)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := readSeekableIndex(f, item.Size); err != nil {
+			return err.Error(), nil
+		}
+	}
+	return "", nil
+}
+
+// verifyManifest checks the content referred to by a manifest
+func (c *Cache) verifyManifest(ctx context.Context, info store.ItemMeta, tmp string) (string, error) {
+	blob := info.Meta["Blob"]
+	if blob == "" {
+		return "manifest has no content key", nil
+	}
+	blobInfo, err := c.store.Head(ctx, blob)
+	if err != nil {
+		if _, ok := err.(store.NotFound); ok {
+			return fmt.Sprintf("content %s is missing", blob), nil
+		}
+		return "", err
+	}
+	reason, err := c.verifyItem(ctx, store.Item{Key: blob, Size: itemSize(blobInfo)}, tmp)
+	if reason != "" {
+		reason = fmt.Sprintf("content %s: %s", blob, reason)
+	}
+	return reason, err
+}
+
+// sampleItems returns a random selection of the given fraction of items,
+// sorted by key. At least one item is selected if any exist.
+func sampleItems(items []store.Item, fraction float64) []store.Item {
+	if fraction > 0 && fraction < 1 && len(items) > 0 {
+		count := int(math.Ceil(fraction * float64(len(items))))
+		rand.Shuffle(len(items), func(i, j int) {
+			items[i], items[j] = items[j], items[i]
+		})
+		items = items[:count]
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
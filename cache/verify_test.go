@@ -0,0 +1,81 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerify(t *testing.T) {
+
+	ctx := context.Background()
+
+	tmpDir, err := ioutil.TempDir("", "zim-testing-")
+	require.Nil(t, err)
+	defer os.RemoveAll(tmpDir)
+
+	storeDir := path.Join(tmpDir, "store")
+	c := New(Opts{Store: filesystem.New(storeDir)})
+
+	src := path.Join(tmpDir, "src")
+	for _, key := range []string{"aaaa1", "bbbb1", "cccc1"} {
+		writeFile(src, "contents of "+key)
+		require.Nil(t, c.put(ctx, key, src, "", ""))
+	}
+
+	// Truncate one item and overwrite another with the same size
+	writeFile(path.Join(storeDir, "bb", "bb", "bbbb1"), "contents")
+	writeFile(path.Join(storeDir, "cc", "cc", "cccc1"), "CONTENTS of cccc1")
+
+	report, err := c.Verify(ctx, VerifyOpts{})
+	require.Nil(t, err)
+	require.Equal(t, 3, report.Items)
+	require.Equal(t, 3, report.Checked)
+	require.Len(t, report.Problems, 2)
+	require.Equal(t, "bbbb1", report.Problems[0].Key)
+	require.Equal(t, "size is 8 bytes, expected 17", report.Problems[0].Reason)
+	require.Equal(t, "cccc1", report.Problems[1].Key)
+	require.Contains(t, report.Problems[1].Reason, "hash is")
+	require.False(t, report.Problems[1].Deleted)
+
+	// Corrupt items are removed when requested
+	report, err = c.Verify(ctx, VerifyOpts{Delete: true})
+	require.Nil(t, err)
+	require.Len(t, report.Problems, 2)
+	require.True(t, report.Problems[0].Deleted)
+
+	_, err = c.store.Head(ctx, "bbbb1")
+	require.IsType(t, store.NotFound(""), err)
+
+	report, err = c.Verify(ctx, VerifyOpts{})
+	require.Nil(t, err)
+	require.Equal(t, 1, report.Items)
+	require.Empty(t, report.Problems)
+}
+
+func TestSampleItems(t *testing.T) {
+	items := []store.Item{{Key: "d"}, {Key: "c"}, {Key: "b"}, {Key: "a"}}
+	require.Len(t, sampleItems(items, 0.5), 2)
+	require.Len(t, sampleItems(items, 0.1), 1)
+	require.Equal(t, []store.Item{{Key: "a"}, {Key: "b"}, {Key: "c"}, {Key: "d"}},
+		sampleItems(items, 1))
+}
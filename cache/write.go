@@ -5,8 +5,8 @@ import (
 	"io/ioutil"
 )
 
-func writeJSON(key *Key) (string, error) {
-	js, err := json.Marshal(key)
+func writeJSON(v interface{}) (string, error) {
+	js, err := json.Marshal(v)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,183 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+// bootstrapDockerfile builds an image containing only the zim binary
+const bootstrapDockerfile = `FROM scratch
+COPY zim /usr/local/bin/zim
+ENTRYPOINT ["/usr/local/bin/zim"]
+`
+
+// parsePlatform splits a platform such as linux/arm64 into its OS and
+// architecture. The default is linux on the current architecture.
+func parsePlatform(platform string) (string, string, error) {
+	if platform == "" {
+		return "linux", runtime.GOARCH, nil
+	}
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid platform: %s", platform)
+	}
+	return parts[0], parts[1], nil
+}
+
+// buildStaticBinary compiles zim from the source directory into a static
+// binary for the given platform
+func buildStaticBinary(source, goos, goarch, dst string) error {
+	if _, err := os.Stat(filepath.Join(source, "main.go")); err != nil {
+		return fmt.Errorf("zim source not found in %s", source)
+	}
+	ldflags := fmt.Sprintf(
+		"-s -w -X github.com/fugue/zim/cmd.Version=%s -X github.com/fugue/zim/cmd.GitCommit=%s",
+		Version, GitCommit)
+	build := osexec.Command("go", "build", "-trimpath", "-ldflags", ldflags, "-o", dst, ".")
+	build.Dir = source
+	build.Env = append(os.Environ(),
+		"CGO_ENABLED=0",
+		"GO111MODULE=on",
+		"GOOS="+goos,
+		"GOARCH="+goarch)
+	build.Stdout = os.Stdout
+	build.Stderr = os.Stderr
+	return build.Run()
+}
+
+// copyFile copies the file at src to dst with the given mode
+func copyFile(src, dst string, mode os.FileMode) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dst, data, mode)
+}
+
+// runContainerCLI runs the container runtime CLI with the given arguments
+func runContainerCLI(runtime string, args ...string) error {
+	fmt.Println("cmd:", project.Cyan(runtime+" "+strings.Join(args, " ")))
+	c := osexec.Command(runtime, args...)
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	return c.Run()
+}
+
+// buildBootstrapImage builds the image in a temporary build context, which
+// is removed before returning
+func buildBootstrapImage(runtime, tag, source, binary, goos, goarch string, push bool) error {
+
+	contextDir, err := ioutil.TempDir("", "zim-image-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(contextDir)
+
+	binaryPath := filepath.Join(contextDir, "zim")
+	if binary != "" {
+		err = copyFile(binary, binaryPath, 0755)
+	} else {
+		err = buildStaticBinary(source, goos, goarch, binaryPath)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to prepare zim binary: %s", err)
+	}
+	dockerfile := filepath.Join(contextDir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfile, []byte(bootstrapDockerfile), 0644); err != nil {
+		return err
+	}
+
+	buildArgs := []string{"build", "--tag", tag,
+		"--platform", fmt.Sprintf("%s/%s", goos, goarch), contextDir}
+	if err := runContainerCLI(runtime, buildArgs...); err != nil {
+		return fmt.Errorf("failed to build image %s: %s", tag, err)
+	}
+	if push {
+		if err := runContainerCLI(runtime, "push", tag); err != nil {
+			return fmt.Errorf("failed to push image %s: %s", tag, err)
+		}
+	}
+	return nil
+}
+
+// NewBootstrapImageCommand returns a command that builds an image of zim
+func NewBootstrapImageCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "bootstrap-image",
+		Short: "Build a minimal container image containing zim",
+		Long: `Build a minimal container image containing a static zim binary.
+
+The binary is compiled from the zim source directory unless one is given with
+--binary, in which case it must be statically linked. Build images and remote
+workers can then copy a pinned zim from the image:
+
+    COPY --from=zim:1.2.3 /usr/local/bin/zim /usr/local/bin/zim`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			tag, _ := cmd.Flags().GetString("tag")
+			source, _ := cmd.Flags().GetString("source")
+			binary, _ := cmd.Flags().GetString("binary")
+			push, _ := cmd.Flags().GetBool("push")
+
+			if tag == "" {
+				tag = fmt.Sprintf("zim:%s", Version)
+			}
+			goos, goarch, err := parsePlatform(opts.Platform)
+			if err != nil {
+				fatal(err)
+			}
+			if goos != "linux" {
+				fatal(errors.New("bootstrap images must target linux"))
+			}
+			containerRuntime := opts.Runtime
+			if containerRuntime == "" || containerRuntime == "auto" {
+				containerRuntime = exec.DetectContainerRuntime()
+			}
+
+			err = buildBootstrapImage(containerRuntime, tag, source, binary, goos, goarch, push)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println("Built image", project.Bright(tag))
+		},
+	}
+
+	cmd.Flags().String("tag", "", "Image tag (default zim:VERSION)")
+	cmd.Flags().String("source", ".", "Directory containing the zim source")
+	cmd.Flags().String("binary", "", "Static zim binary to use instead of building one")
+	cmd.Flags().Bool("push", false, "Push the image after building it")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewBootstrapImageCommand())
+}
@@ -0,0 +1,154 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type buildViewItem struct {
+	ID       string
+	ParentID string
+	Rules    string
+	Started  string
+	Status   string
+}
+
+type buildImageViewItem struct {
+	Build  string
+	Rule   string
+	Image  string
+	Digest string
+	Pulled bool
+}
+
+// buildImageRows returns a row for each container image used by a rule in
+// the builds
+func buildImageRows(builds []*project.Build) []interface{} {
+	var rows []interface{}
+	for _, b := range builds {
+		nodeIDs := make([]string, 0, len(b.Images))
+		for nodeID := range b.Images {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+		sort.Strings(nodeIDs)
+		for _, nodeID := range nodeIDs {
+			for _, use := range b.Images[nodeID] {
+				rows = append(rows, buildImageViewItem{
+					Build:  b.ID,
+					Rule:   nodeID,
+					Image:  use.Image,
+					Digest: use.Digest,
+					Pulled: use.Pulled,
+				})
+			}
+		}
+	}
+	return rows
+}
+
+// buildStatus summarizes the outcome of a build
+func buildStatus(b *project.Build) string {
+	if b.FinishedAt == nil {
+		return "running"
+	}
+	if b.Error != "" {
+		return "failed"
+	}
+	return "ok"
+}
+
+// NewBuildsCommand returns a command that lists recorded builds
+func NewBuildsCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "builds [ID]",
+		Short: "List recorded builds",
+		Long: `List the builds recorded by zim run, most recent first. If a build ID is
+given, only the builds related to it are listed: the build that started the
+others and every build started by its rules, including retries. With
+--images, the container image digest each rule ran in is listed instead.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			proj, err := getProject(opts.Directory)
+			if err != nil {
+				fatal(err)
+			}
+			builds, err := project.LoadBuilds(proj.BuildsDir())
+			if err != nil {
+				fatal(err)
+			}
+			if len(args) > 0 {
+				builds = project.RelatedBuilds(builds, args[0])
+			}
+			limit, _ := cmd.Flags().GetInt("limit")
+			if limit > 0 && len(builds) > limit {
+				builds = builds[:limit]
+			}
+			if images, _ := cmd.Flags().GetBool("images"); images {
+				err = printRows(opts, format.TableOpts{
+					Rows:       buildImageRows(builds),
+					Columns:    []string{"Build", "Rule", "Image", "Digest", "Pulled"},
+					ShowHeader: true,
+				})
+				if err != nil {
+					fatal(err)
+				}
+				return
+			}
+			var rows []interface{}
+			for _, b := range builds {
+				rows = append(rows, buildViewItem{
+					ID:       b.ID,
+					ParentID: b.ParentID,
+					Rules:    strings.Join(b.Rules, ","),
+					Started:  b.StartedAt.Format("2006-01-02 15:04:05"),
+					Status:   buildStatus(b),
+				})
+			}
+			if len(rows) == 0 && opts.Format == format.TableFormat {
+				fmt.Println("No builds found")
+				return
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"ID", "ParentID", "Rules", "Started", "Status"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().Int("limit", 20, "Maximum number of builds to list")
+	cmd.Flags().Bool("images", false, "List the container images used by each rule")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewBuildsCommand())
+}
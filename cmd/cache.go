@@ -0,0 +1,457 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/graph"
+	"github.com/fugue/zim/hash"
+	"github.com/fugue/zim/project"
+	dockerProvider "github.com/fugue/zim/provider/docker"
+	s3Provider "github.com/fugue/zim/provider/s3"
+	"github.com/fugue/zim/store"
+	fsStore "github.com/fugue/zim/store/filesystem"
+	gcsStore "github.com/fugue/zim/store/gcs"
+	httpStore "github.com/fugue/zim/store/http"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// newBackendStore returns the store for a cache backend URL
+func newBackendStore(backend string) (store.Store, error) {
+	u, err := url.Parse(backend)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache backend %s: %s", backend, err)
+	}
+	switch u.Scheme {
+	case "gcs", "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("cache backend has no bucket: %s", backend)
+		}
+		return gcsStore.New(gcsStore.Opts{Bucket: u.Host, Prefix: u.Path}), nil
+	case "file":
+		return fsStore.New(u.Path), nil
+	default:
+		return nil, fmt.Errorf("unsupported cache backend: %s", backend)
+	}
+}
+
+// projectDefinition returns the definition of the project containing the
+// directory, or an empty definition if there is none
+func projectDefinition(dir string) *definitions.Project {
+	if rootDir, err := repositoryRoot(dir); err == nil {
+		dir = rootDir
+	}
+	def, err := definitions.LoadProjectFromPath(filepath.Join(dir, ".zim", "project.yaml"))
+	if err != nil {
+		return &definitions.Project{}
+	}
+	return def
+}
+
+// projectCacheBackend returns the cache backend set in the project
+// definition, if any
+func projectCacheBackend(dir string) string {
+	return projectDefinition(dir).CacheBackend
+}
+
+// projectHasher returns the Hasher for the hash algorithm selected in the
+// project definition, or for the default algorithm
+func projectHasher(dir string) (hash.Hasher, error) {
+	return hash.New(projectDefinition(dir).Hash)
+}
+
+// projectCacheLayout returns the cache storage layout selected by the
+// project definition
+func projectCacheLayout(dir string) (string, error) {
+	layout := projectDefinition(dir).CacheLayout
+	if layout == "" {
+		return cache.LayoutKeys, nil
+	}
+	for _, l := range cache.Layouts {
+		if layout == l {
+			return layout, nil
+		}
+	}
+	return "", fmt.Errorf("invalid cache layout: %s (%s)",
+		layout, strings.Join(cache.Layouts, " | "))
+}
+
+// projectCacheCompression returns the compression of files stored in the
+// cache selected by the project definition
+func projectCacheCompression(dir string) (string, error) {
+	compression := projectDefinition(dir).CacheCompression
+	if compression == "" {
+		return cache.CompressionNone, nil
+	}
+	for _, c := range cache.Compressions {
+		if compression == c {
+			return compression, nil
+		}
+	}
+	return "", fmt.Errorf("invalid cache compression: %s (%s)",
+		compression, strings.Join(cache.Compressions, " | "))
+}
+
+// projectInputHasher returns the Hasher for rule input files. Large files
+// are hashed incrementally if the project definition enables it, with the
+// chunk index kept in the artifacts directory.
+func projectInputHasher(dir string, hasher hash.Hasher) (hash.Hasher, error) {
+	largeFiles := projectDefinition(dir).LargeFiles
+	if largeFiles.Threshold == "" {
+		return hasher, nil
+	}
+	opts := hash.ChunkOpts{}
+	var err error
+	if opts.Threshold, err = project.ParseSize(largeFiles.Threshold); err != nil {
+		return nil, err
+	}
+	if largeFiles.ChunkSize != "" {
+		if opts.ChunkSize, err = project.ParseSize(largeFiles.ChunkSize); err != nil {
+			return nil, err
+		}
+	}
+	if rootDir, err := repositoryRoot(dir); err == nil {
+		dir = rootDir
+	}
+	opts.IndexPath = filepath.Join(dir, "artifacts", ".zim",
+		fmt.Sprintf("chunks-%s.json", hasher.Name()))
+	return hash.Chunked(hasher, opts), nil
+}
+
+// newStore returns the store configured by the options. In order of
+// precedence this is the cache backend option, the cache backend set in the
+// project definition, the remote cache if a URL is set, or the local cache
+// directory. Nil is returned if none are configured. In offline mode only
+// the local cache directory is used, which zim prefetch populates.
+func newStore(opts zimOptions) (store.Store, error) {
+	if opts.Offline {
+		return newOfflineStore(opts), nil
+	}
+	backend := opts.Backend
+	if backend == "" {
+		backend = projectCacheBackend(opts.Directory)
+	}
+	if backend != "" {
+		return newBackendStore(backend)
+	} else if opts.URL != "" {
+		return httpStore.New(opts.URL, opts.Token), nil
+	} else if opts.CachePath != "" {
+		return fsStore.New(opts.CachePath), nil
+	}
+	return nil, nil
+}
+
+// newOfflineStore returns the local store used in offline mode: a file
+// cache backend if one is configured, or the local cache directory
+func newOfflineStore(opts zimOptions) store.Store {
+	dir := localCacheDir(opts)
+	if dir == "" {
+		dir = opts.CachePath
+	}
+	return fsStore.New(dir)
+}
+
+// newCache returns the cache using the store configured by the options.
+// Nil is returned if no store is configured.
+func newCache(opts zimOptions) (*cache.Cache, error) {
+	cacheStore, err := newStore(opts)
+	if err != nil || cacheStore == nil {
+		return nil, err
+	}
+	injector, err := faultInjector()
+	if err != nil {
+		return nil, err
+	}
+	cacheStore = injector.Store(cacheStore)
+	self, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := projectHasher(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	inputHasher, err := projectInputHasher(opts.Directory, hasher)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := projectCacheLayout(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	compression, err := projectCacheCompression(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	// Items are signed with a key shared by the team, if one is configured
+	var signer cache.Signer
+	if key := viper.GetString("cache-signing-key"); key != "" {
+		signer = cache.NewHMACSigner([]byte(key))
+	}
+	return cache.New(cache.Opts{
+		Store:       cacheStore,
+		Hasher:      hasher,
+		InputHasher: inputHasher,
+		Mode:        opts.CacheMode,
+		User:        self.Name,
+		Layout:      layout,
+		Concurrency: opts.CacheConcurrency,
+		Compression: compression,
+		Signer:      signer,
+	}), nil
+}
+
+// loadProject loads the project at the root of the repository containing
+// the options directory, configured with the executor used to run rules
+func loadProject(opts zimOptions) (*project.Project, error) {
+	if rootDir, err := repositoryRoot(opts.Directory); err == nil {
+		opts.Directory = rootDir
+	}
+	executor, err := newExecutor(opts)
+	if err != nil {
+		return nil, err
+	}
+	projDef, componentDefs, err := project.Discover(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return project.NewWithOptions(project.Opts{
+		Root:          opts.Directory,
+		ProjectDef:    projDef,
+		ComponentDefs: componentDefs,
+		Executor:      executor,
+		Providers:     projectProviders(opts),
+		Offline:       opts.Offline,
+		Platform:      opts.Platform,
+		Parameters:    opts.Parameters,
+	})
+}
+
+// projectProviders returns the Providers of Resources other than files
+func projectProviders(opts zimOptions) []project.Provider {
+	return []project.Provider{
+		s3Provider.New(opts.Region),
+		dockerProvider.New(containerRuntime(opts)),
+	}
+}
+
+// openArchive returns a reader for the archive at the given path,
+// decompressing it if the name indicates gzip compression
+func openArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !isGzipArchive(path) {
+		return f, nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{gz, f}, nil
+}
+
+func isGzipArchive(path string) bool {
+	return strings.HasSuffix(path, ".gz") || strings.HasSuffix(path, ".tgz")
+}
+
+func checkArchiveName(path string) error {
+	if path == "" {
+		return fmt.Errorf("an archive path must be specified")
+	}
+	if strings.HasSuffix(path, ".zst") {
+		return fmt.Errorf("zstd compression is not supported, use .tar.gz: %s", path)
+	}
+	return nil
+}
+
+// NewCacheCommand returns a command grouping cache management commands
+func NewCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the cache",
+	}
+	cmd.AddCommand(NewCacheExportCommand())
+	cmd.AddCommand(NewCacheImportCommand())
+	cmd.AddCommand(NewCacheGCCommand())
+	cmd.AddCommand(NewCachePruneCommand())
+	cmd.AddCommand(NewCachePolicyCommand())
+	return cmd
+}
+
+// NewCacheExportCommand returns a command that exports cache entries needed
+// to build the selected rules to an archive
+func NewCacheExportCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export cache entries for rules to an archive",
+		Long: `Export the cache entries needed to build the selected rules, including their
+dependencies, to a tar archive. The archive is compressed with gzip if its name
+ends in .gz or .tgz. Importing the archive elsewhere with "zim cache import"
+allows builds to be reproduced without access to the original cache.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if len(opts.Rules) == 0 && len(args) > 0 {
+				opts.Rules = args
+			}
+			if len(opts.Rules) == 0 {
+				fatal(fmt.Errorf("Must specify one or more rules"))
+			}
+			out, err := cmd.Flags().GetString("out")
+			if err != nil {
+				fatal(err)
+			}
+			if err := checkArchiveName(out); err != nil {
+				fatal(err)
+			}
+			proj, err := loadProject(opts)
+			if err != nil {
+				fatal(err)
+			}
+			comps, err := selectComponents(proj, opts)
+			if err != nil {
+				fatal(err)
+			}
+			zimCache, err := newCache(opts)
+			if err != nil {
+				fatal(err)
+			}
+			if zimCache == nil {
+				fatal(fmt.Errorf("Cache URL is not set. See the docs!"))
+			}
+
+			rules := transitiveRules(comps, opts.Rules)
+
+			f, err := os.Create(out)
+			if err != nil {
+				fatal(err)
+			}
+			defer f.Close()
+			var w io.Writer = f
+			var gz *gzip.Writer
+			if isGzipArchive(out) {
+				gz = gzip.NewWriter(f)
+				w = gz
+			}
+			missing, err := zimCache.Export(context.Background(), rules, w)
+			if err == nil && gz != nil {
+				err = gz.Close()
+			}
+			if err != nil {
+				fatal(err)
+			}
+			for _, nodeID := range missing {
+				fmt.Fprintln(os.Stderr, project.Yellow(
+					fmt.Sprintf("Not in cache: %s", nodeID)))
+			}
+			fmt.Printf("Exported %d of %d rules to %s\n",
+				countCacheable(rules)-len(missing), countCacheable(rules), out)
+		},
+	}
+
+	cmd.Flags().String("out", "", "Path of the archive to create")
+
+	return cmd
+}
+
+// transitiveRules returns the named rules of the components along with their
+// transitive dependencies, sorted by ID
+func transitiveRules(comps project.Components, names []string) []*project.Rule {
+	var rules []*project.Rule
+	project.GraphFromRules(comps.Rules(names)).Visit(func(n graph.Node) bool {
+		rules = append(rules, n.(*project.Rule))
+		return true
+	})
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].NodeID() < rules[j].NodeID()
+	})
+	return rules
+}
+
+// countCacheable returns the number of rules that have outputs
+func countCacheable(rules []*project.Rule) (count int) {
+	for _, r := range rules {
+		if r.HasOutputs() {
+			count++
+		}
+	}
+	return
+}
+
+// NewCacheImportCommand returns a command that imports an archive created
+// by the export command into the cache
+func NewCacheImportCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "import ARCHIVE",
+		Short: "Import cache entries from an archive",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if err := checkArchiveName(args[0]); err != nil {
+				fatal(err)
+			}
+			zimCache, err := newCache(opts)
+			if err != nil {
+				fatal(err)
+			}
+			if zimCache == nil {
+				fatal(fmt.Errorf("Cache URL is not set. See the docs!"))
+			}
+			archive, err := openArchive(args[0])
+			if err != nil {
+				fatal(err)
+			}
+			defer archive.Close()
+
+			count, err := zimCache.Import(context.Background(), archive)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Printf("Imported %d items\n", count)
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewCacheCommand())
+}
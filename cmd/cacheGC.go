@@ -0,0 +1,116 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/fugue/zim/project"
+	fsStore "github.com/fugue/zim/store/filesystem"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// localCacheDir returns the directory of the local cache, or an empty string
+// if a remote cache is used
+func localCacheDir(opts zimOptions) string {
+	backend := opts.Backend
+	if backend == "" {
+		backend = projectCacheBackend(opts.Directory)
+	}
+	if backend != "" {
+		if u, err := url.Parse(backend); err == nil && u.Scheme == "file" {
+			return u.Path
+		}
+		return ""
+	}
+	if opts.URL != "" {
+		return ""
+	}
+	return opts.CachePath
+}
+
+// collectCache removes least recently used items from the local cache if
+// a maximum cache size is configured. Failures are reported as warnings.
+func collectCache(opts zimOptions) {
+	maxSize := viper.GetString("cache-max-size")
+	dir := localCacheDir(opts)
+	if maxSize == "" || dir == "" {
+		return
+	}
+	size, err := project.ParseSize(maxSize)
+	if err == nil {
+		_, err = fsStore.GarbageCollect(dir, size, false)
+	}
+	if err != nil {
+		fmt.Fprint(os.Stderr, project.Yellow(
+			fmt.Sprintf("Cache garbage collection failed: %s\n", err)))
+	}
+}
+
+// NewCacheGCCommand returns a command that evicts items from the local cache
+func NewCacheGCCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove least recently used items from the local cache",
+		Long: `Remove least recently used items from the local cache until it is no
+larger than the maximum size. The size is given by --max-size or otherwise by
+cache-max-size in ~/.zim.yaml, which also enables garbage collection after
+each run.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+			maxSize, _ := cmd.Flags().GetString("max-size")
+			if maxSize == "" {
+				maxSize = viper.GetString("cache-max-size")
+			}
+			if maxSize == "" {
+				fatal(errors.New("the maximum cache size is not set"))
+			}
+			size, err := project.ParseSize(maxSize)
+			if err != nil {
+				fatal(err)
+			}
+			dir := localCacheDir(opts)
+			if dir == "" {
+				fatal(errors.New("a remote cache is configured"))
+			}
+
+			stats, err := fsStore.GarbageCollect(dir, size, dryRun)
+			if err != nil {
+				fatal(err)
+			}
+			verb := "Removed"
+			if dryRun {
+				verb = "Would remove"
+			}
+			fmt.Printf("%s %d of %d items (%s of %s)\n", verb,
+				stats.RemovedItems, stats.Items,
+				project.FormatBytes(stats.RemovedSize), project.FormatBytes(stats.Size))
+		},
+	}
+
+	cmd.Flags().String("max-size", "", "Maximum cache size, e.g. 10GB")
+	cmd.Flags().Bool("dry-run", false, "Show what would be removed")
+
+	return cmd
+}
@@ -0,0 +1,128 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/sign"
+	httpStore "github.com/fugue/zim/store/http"
+	"github.com/spf13/cobra"
+)
+
+type cachePolicyViewItem struct {
+	Project   string
+	MaxAge    string
+	MaxSize   string
+	UpdatedBy string
+	UpdatedAt string
+}
+
+func newCachePolicyViewItem(p *sign.Policy) cachePolicyViewItem {
+	item := cachePolicyViewItem{
+		Project:   p.Project,
+		MaxAge:    "none",
+		MaxSize:   "none",
+		UpdatedBy: p.UpdatedBy,
+	}
+	if p.MaxAge > 0 {
+		item.MaxAge = p.MaxAge.String()
+	}
+	if p.MaxSize > 0 {
+		item.MaxSize = project.FormatBytes(p.MaxSize)
+	}
+	if !p.UpdatedAt.IsZero() {
+		item.UpdatedAt = p.UpdatedAt.Format("2006-01-02 15:04:05")
+	}
+	return item
+}
+
+// NewCachePolicyCommand returns a command that shows or updates the cache
+// retention policy of a project
+func NewCachePolicyCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Show or update the cache retention policy of the project",
+		Long: `Show the retention policy that the cache server applies to the items of
+the project, or update it with --max-age and --max-size. Items older than the
+maximum age are deleted periodically, as are the oldest items beyond the
+maximum total size. A limit of 0 removes it. Policies are stored by the cache
+API, so the cache url must be configured.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			projectName, _ := cmd.Flags().GetString("project")
+			if projectName == "" {
+				proj, err := getProject(opts.Directory)
+				if err != nil {
+					fatal(err)
+				}
+				projectName = proj.Name()
+			}
+			cacheStore, err := newStore(opts)
+			if err != nil {
+				fatal(err)
+			}
+			policies, ok := cacheStore.(httpStore.Policies)
+			if !ok {
+				fatal(errors.New("retention policies require the cache API url"))
+			}
+
+			ctx := context.Background()
+			policy, err := policies.GetPolicy(ctx, projectName)
+			if err != nil {
+				fatal(err)
+			}
+			if cmd.Flags().Changed("max-age") || cmd.Flags().Changed("max-size") {
+				if cmd.Flags().Changed("max-age") {
+					maxAge, _ := cmd.Flags().GetString("max-age")
+					if policy.MaxAge, err = parseAge(maxAge); err != nil {
+						fatal(err)
+					}
+				}
+				if cmd.Flags().Changed("max-size") {
+					maxSize, _ := cmd.Flags().GetString("max-size")
+					if policy.MaxSize, err = project.ParseSize(maxSize); err != nil {
+						fatal(err)
+					}
+				}
+				policy.Project = projectName
+				if policy, err = policies.PutPolicy(ctx, policy); err != nil {
+					fatal(err)
+				}
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       []interface{}{newCachePolicyViewItem(policy)},
+				Columns:    []string{"Project", "MaxAge", "MaxSize", "UpdatedBy", "UpdatedAt"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().String("project", "", "Project name, by default the current project")
+	cmd.Flags().String("max-age", "", "Delete items older than this age, e.g. 30d")
+	cmd.Flags().String("max-size", "", "Delete the oldest items beyond this total size, e.g. 50GB")
+
+	return cmd
+}
@@ -0,0 +1,167 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+	"github.com/spf13/cobra"
+)
+
+// parseAge parses an age such as "30d" or "2w", or any Go duration
+func parseAge(s string) (time.Duration, error) {
+	value := strings.TrimSpace(s)
+	days := map[string]float64{"d": 1, "w": 7}
+	for suffix, multiplier := range days {
+		if strings.HasSuffix(value, suffix) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(value, suffix), 64)
+			if err != nil || n < 0 {
+				return 0, fmt.Errorf("invalid age: %s", s)
+			}
+			return time.Duration(n * multiplier * float64(24*time.Hour)), nil
+		}
+	}
+	age, err := time.ParseDuration(value)
+	if err != nil || age < 0 {
+		return 0, fmt.Errorf("invalid age: %s", s)
+	}
+	return age, nil
+}
+
+// referencedContent returns the keys of content, stored once under its hash
+// by the content-addressed layout, that manifests which are not being
+// deleted refer to. Content is shared, so it is kept while any such manifest
+// refers to it, however old it is. Manifests with any key are followed, not
+// only those with the prefix. Nothing is read if no content has expired.
+func referencedContent(
+	ctx context.Context,
+	s store.Store,
+	lister store.ListDeleter,
+	items []store.Item,
+	prefix string,
+	cutoff time.Time,
+) (map[string]bool, error) {
+
+	var expired bool
+	for _, item := range items {
+		if cache.IsContentKey(item.Key) && item.LastModified.Before(cutoff) {
+			expired = true
+			break
+		}
+	}
+	if !expired {
+		return nil, nil
+	}
+	if prefix != "" {
+		var err error
+		if items, err = lister.List(ctx, ""); err != nil {
+			return nil, err
+		}
+	}
+	var keys []string
+	for _, item := range items {
+		if !item.LastModified.Before(cutoff) {
+			keys = append(keys, item.Key)
+		}
+	}
+	return cache.ReferencedContent(ctx, s, keys)
+}
+
+// NewCachePruneCommand returns a command that deletes stale cache entries
+func NewCachePruneCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete cache entries older than a given age",
+		Long: `Delete cache entries that were last modified before the given age, e.g.
+30d, 2w, or 12h. Only entries with keys beginning with --prefix are considered.
+Content stored once by the content layout is kept while a manifest that
+isn't deleted refers to it. With --dry-run the entries are listed along with
+the total size reclaimed, but nothing is deleted.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			olderThan, _ := cmd.Flags().GetString("older-than")
+			prefix, _ := cmd.Flags().GetString("prefix")
+			dryRun, _ := cmd.Flags().GetBool("dry-run")
+
+			if olderThan == "" {
+				fatal(errors.New("--older-than is required"))
+			}
+			age, err := parseAge(olderThan)
+			if err != nil {
+				fatal(err)
+			}
+			cacheStore, err := newStore(opts)
+			if err != nil {
+				fatal(err)
+			}
+			if cacheStore == nil {
+				fatal(errors.New("Cache URL is not set. See the docs!"))
+			}
+			lister, ok := cacheStore.(store.ListDeleter)
+			if !ok {
+				fatal(errors.New("the cache does not support listing entries"))
+			}
+
+			ctx := context.Background()
+			items, err := lister.List(ctx, prefix)
+			if err != nil {
+				fatal(err)
+			}
+			cutoff := time.Now().Add(-age)
+			referenced, err := referencedContent(ctx, cacheStore, lister, items, prefix, cutoff)
+			if err != nil {
+				fatal(err)
+			}
+			var count int
+			var size int64
+			for _, item := range items {
+				if !item.LastModified.Before(cutoff) || referenced[item.Key] {
+					continue
+				}
+				if dryRun {
+					fmt.Printf("%s  %s  %s\n", item.LastModified.Format("2006-01-02"),
+						project.FormatBytes(item.Size), item.Key)
+				} else if err := lister.Delete(ctx, item.Key); err != nil {
+					fatal(err)
+				}
+				count++
+				size += item.Size
+			}
+			verb := "Deleted"
+			if dryRun {
+				verb = "Would delete"
+			}
+			fmt.Printf("%s %d of %d entries (%s)\n", verb, count, len(items), project.FormatBytes(size))
+		},
+	}
+
+	cmd.Flags().String("older-than", "", "Delete entries older than this age, e.g. 30d")
+	cmd.Flags().String("prefix", "", "Only consider entries with keys beginning with this prefix")
+	cmd.Flags().Bool("dry-run", false, "Show what would be deleted")
+
+	return cmd
+}
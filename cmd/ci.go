@@ -0,0 +1,168 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+// warmSummary is the machine-readable result of warming the cache
+type warmSummary struct {
+	Rules    []string             `json:"rules"`
+	Duration float64              `json:"duration"`
+	Counts   map[string]int       `json:"counts"`
+	Results  []project.RuleResult `json:"results"`
+	Error    string               `json:"error,omitempty"`
+}
+
+// writeWarmSummary writes the summary as JSON to the given path, or to
+// stdout if the path is empty
+func writeWarmSummary(summary warmSummary, path string) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// writeStepSummary appends a Markdown table of result counts to the GitHub
+// Actions job summary, if running in GitHub Actions
+func writeStepSummary(summary warmSummary) error {
+	path := os.Getenv("GITHUB_STEP_SUMMARY")
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var codes []string
+	for code := range summary.Counts {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	fmt.Fprintf(f, "### zim cache warm (%.1f sec)\n\n", summary.Duration)
+	fmt.Fprintln(f, "| Result | Rules |")
+	fmt.Fprintln(f, "| --- | --- |")
+	for _, code := range codes {
+		fmt.Fprintf(f, "| %s | %d |\n", code, summary.Counts[code])
+	}
+	if summary.Error != "" {
+		fmt.Fprintf(f, "\n**Error:** %s\n", summary.Error)
+	}
+	fmt.Fprintln(f)
+	return nil
+}
+
+// NewCICommand returns a command grouping helpers intended for CI pipelines
+func NewCICommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ci",
+		Short: "Helpers for CI pipelines",
+	}
+	cmd.AddCommand(NewCIWarmCommand())
+	return cmd
+}
+
+// NewCIWarmCommand returns a command that populates the cache after a merge
+func NewCIWarmCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "warm [rule...]",
+		Short: "Build rules and write their outputs to the cache",
+		Long: `Build rules and write their outputs to the cache, intended to run in CI
+after changes are merged to the main branch so that the cache stays warm for
+developers. Rules are always rebuilt using write-only cache mode. The build
+rule is used if no rules are specified. A JSON summary of results is written
+once the run completes, and is also added to the job summary when running in
+GitHub Actions.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			closeHandler(cancel)
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if len(opts.Rules) == 0 {
+				opts.Rules = args
+			}
+			if len(opts.Rules) == 0 {
+				opts.Rules = []string{"build"}
+			}
+			opts.CacheMode = cache.WriteOnly
+			if opts.Jobs, err = cmd.Flags().GetInt("jobs"); err != nil {
+				fatal(err)
+			}
+			summaryPath, err := cmd.Flags().GetString("summary")
+			if err != nil {
+				fatal(err)
+			}
+
+			startedAt := time.Now()
+			results := project.NewSummary()
+			schedulerErr := runRules(ctx, cancel, opts, results.Middleware)
+
+			summary := warmSummary{
+				Rules:    opts.Rules,
+				Duration: time.Since(startedAt).Seconds(),
+				Counts:   results.Counts(),
+				Results:  results.Results(),
+			}
+			if schedulerErr != nil {
+				summary.Error = schedulerErr.Error()
+			}
+			if err := writeWarmSummary(summary, summaryPath); err != nil {
+				fatal(err)
+			}
+			if err := writeStepSummary(summary); err != nil {
+				fmt.Fprintln(os.Stderr, project.Yellow(
+					fmt.Sprintf("Failed to write job summary: %s", err)))
+			}
+			if schedulerErr != nil {
+				fatal(schedulerErr)
+			}
+		},
+	}
+
+	cmd.Flags().IntP("jobs", "j", runtime.NumCPU(), "Concurrent jobs")
+	cmd.Flags().String("summary", "", "Write the JSON summary to this file instead of stdout")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewCICommand())
+}
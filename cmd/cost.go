@@ -0,0 +1,172 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/graph"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	// Default Fargate task size used when a component doesn't specify one
+	defaultFargateCPU    = 1024
+	defaultFargateMemory = 2048
+
+	// Fargate Linux/x86 on-demand pricing in us-east-2 (USD)
+	defaultFargateCPUPrice    = 0.04048
+	defaultFargateMemoryPrice = 0.004445
+)
+
+type costViewItem struct {
+	Rule     string
+	Duration string
+	CPU      int
+	Memory   int
+	HitRate  string
+	Cost     string
+	Expected string
+}
+
+// fargateCost returns the cost in USD of running a task of the given size
+// for the specified number of seconds
+func fargateCost(cpu, memory int, seconds, cpuPrice, memoryPrice float64) float64 {
+	hours := seconds / 3600.0
+	vcpu := float64(cpu) / 1024.0
+	gb := float64(memory) / 1024.0
+	return hours * (vcpu*cpuPrice + gb*memoryPrice)
+}
+
+// NewCostCommand returns a command that estimates the cost of running rules
+func NewCostCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "cost",
+		Short: "Estimate the cost of running rules remotely",
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if remote := viper.GetString("remote"); remote != "fargate" {
+				fatal(fmt.Errorf("unsupported remote: %s", remote))
+			}
+			if len(opts.Rules) == 0 && len(args) > 0 {
+				opts.Rules = args
+			}
+			if len(opts.Rules) == 0 {
+				fatal(errors.New("Must specify one or more rules"))
+			}
+			proj, err := getProject(opts.Directory)
+			if err != nil {
+				fatal(err)
+			}
+			comps, err := selectComponents(proj, opts)
+			if err != nil {
+				fatal(err)
+			}
+			history, err := project.LoadHistory(proj.HistoryPath())
+			if err != nil {
+				fatal(err)
+			}
+
+			// Include the transitive dependencies of the selected rules
+			var rules []*project.Rule
+			project.GraphFromRules(comps.Rules(opts.Rules)).Visit(func(n graph.Node) bool {
+				rules = append(rules, n.(*project.Rule))
+				return true
+			})
+			sort.Slice(rules, func(i, j int) bool {
+				return rules[i].NodeID() < rules[j].NodeID()
+			})
+
+			cpuPrice := viper.GetFloat64("cpu-price")
+			memoryPrice := viper.GetFloat64("memory-price")
+
+			var rows []interface{}
+			var total, expected float64
+			var unknown int
+			for _, r := range rules {
+				rh, found := history.Rule(r)
+				if !found || rh.Executed == 0 {
+					unknown++
+					continue
+				}
+				task := r.Component().ECS()
+				cpu, memory := task.CPU, task.Memory
+				if cpu == 0 {
+					cpu = defaultFargateCPU
+				}
+				if memory == 0 {
+					memory = defaultFargateMemory
+				}
+				duration := rh.AverageDuration()
+				cost := fargateCost(cpu, memory, duration.Seconds(), cpuPrice, memoryPrice)
+				hitRate := rh.CacheHitRate()
+				total += cost
+				expected += cost * (1 - hitRate)
+				rows = append(rows, costViewItem{
+					Rule:     r.NodeID(),
+					Duration: fmt.Sprintf("%.1f sec", duration.Seconds()),
+					CPU:      cpu,
+					Memory:   memory,
+					HitRate:  fmt.Sprintf("%.0f%%", hitRate*100),
+					Cost:     fmt.Sprintf("$%.4f", cost),
+					Expected: fmt.Sprintf("$%.4f", cost*(1-hitRate)),
+				})
+			}
+			if len(rows) > 0 {
+				table, err := format.Table(format.TableOpts{
+					Rows: rows,
+					Columns: []string{
+						"Rule", "Duration", "CPU", "Memory", "HitRate", "Cost", "Expected",
+					},
+					ShowHeader: true,
+				})
+				if err != nil {
+					fatal(err)
+				}
+				for _, tableRow := range table {
+					fmt.Println(tableRow)
+				}
+			}
+			fmt.Printf("Cost without cache: $%.4f\n", total)
+			fmt.Printf("Expected cost with cache: $%.4f\n", expected)
+			if unknown > 0 {
+				fmt.Println(project.Yellow(fmt.Sprintf(
+					"%d rules have no recorded duration and were excluded", unknown)))
+			}
+		},
+	}
+
+	cmd.Flags().String("remote", "fargate", "Remote execution environment")
+	cmd.Flags().Float64("cpu-price", defaultFargateCPUPrice, "Price per vCPU hour (USD)")
+	cmd.Flags().Float64("memory-price", defaultFargateMemoryPrice, "Price per GB hour (USD)")
+	viper.BindPFlag("remote", cmd.Flags().Lookup("remote"))
+	viper.BindPFlag("cpu-price", cmd.Flags().Lookup("cpu-price"))
+	viper.BindPFlag("memory-price", cmd.Flags().Lookup("memory-price"))
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewCostCommand())
+}
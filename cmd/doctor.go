@@ -0,0 +1,241 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+	"github.com/spf13/cobra"
+)
+
+// Outcomes of a doctor check
+const (
+	checkOK      = "ok"
+	checkWarning = "warning"
+	checkFailed  = "failed"
+)
+
+// doctorTimeout limits how long a check may wait on a daemon or the network
+const doctorTimeout = 15 * time.Second
+
+type doctorViewItem struct {
+	Check  string
+	Status string
+	Detail string
+	Fix    string
+}
+
+// checkGit reports whether git is installed and the directory is within a
+// repository
+func checkGit(opts zimOptions) doctorViewItem {
+	item := doctorViewItem{Check: "git"}
+	if _, err := osexec.LookPath("git"); err != nil {
+		item.Status = checkFailed
+		item.Detail = "git is not on the PATH"
+		item.Fix = "Install git"
+		return item
+	}
+	repo, err := repositoryRoot(opts.Directory)
+	if err != nil {
+		item.Status = checkWarning
+		item.Detail = err.Error()
+		item.Fix = "Run zim within a Git or Mercurial repository"
+		return item
+	}
+	item.Status = checkOK
+	item.Detail = fmt.Sprintf("repository at %s", repo)
+	return item
+}
+
+// checkContainerRuntime reports whether the container runtime is installed
+// and its daemon responds
+func checkContainerRuntime(ctx context.Context, opts zimOptions) doctorViewItem {
+	runtime := containerRuntime(opts)
+	item := doctorViewItem{Check: runtime}
+	if !opts.UseDocker {
+		item.Status = checkOK
+		item.Detail = "not used since --docker=false"
+		return item
+	}
+	if _, err := osexec.LookPath(runtime); err != nil {
+		item.Status = checkFailed
+		item.Detail = fmt.Sprintf("%s is not on the PATH", runtime)
+		item.Fix = fmt.Sprintf("Install %s, or run rules natively with --docker=false", runtime)
+		return item
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	if err := osexec.CommandContext(ctx, runtime, "info").Run(); err != nil {
+		item.Status = checkFailed
+		item.Detail = fmt.Sprintf("%s is not responding: %s", runtime, err)
+		item.Fix = fmt.Sprintf("Start the %s daemon and check that you may connect to it", runtime)
+		return item
+	}
+	item.Status = checkOK
+	item.Detail = "responding"
+	return item
+}
+
+// checkCache reports whether the configured cache is reachable. A signed
+// request for an item that doesn't exist checks the URL and token.
+func checkCache(ctx context.Context, opts zimOptions) doctorViewItem {
+	item := doctorViewItem{Check: "cache"}
+	if opts.CacheMode == cache.Disabled {
+		item.Status = checkOK
+		item.Detail = "caching is disabled"
+		return item
+	}
+	cacheStore, err := newStore(opts)
+	if err != nil {
+		item.Status = checkFailed
+		item.Detail = err.Error()
+		item.Fix = "Fix cache_backend in .zim/project.yaml or --cache-backend"
+		return item
+	}
+	if cacheStore == nil {
+		item.Status = checkWarning
+		item.Detail = "no cache is configured"
+		item.Fix = "Set ZIM_URL and run zim add token, or set cache_backend in .zim/project.yaml"
+		return item
+	}
+	if opts.URL != "" && opts.Backend == "" && opts.Token == "" &&
+		projectCacheBackend(opts.Directory) == "" {
+		item.Status = checkFailed
+		item.Detail = fmt.Sprintf("no token is set for %s", opts.URL)
+		item.Fix = "Create a token with zim add token"
+		return item
+	}
+	ctx, cancel := context.WithTimeout(ctx, doctorTimeout)
+	defer cancel()
+	if _, err := cacheStore.Head(ctx, "zim-doctor"); err != nil {
+		if _, ok := err.(store.NotFound); !ok {
+			item.Status = checkFailed
+			item.Detail = err.Error()
+			item.Fix = "Check ZIM_URL, and create a new token with zim add token if it expired"
+			return item
+		}
+	}
+	item.Status = checkOK
+	item.Detail = "reachable"
+	return item
+}
+
+// checkArtifacts reports whether files can be written to the artifacts
+// directory
+func checkArtifacts(opts zimOptions) doctorViewItem {
+	item := doctorViewItem{Check: "artifacts"}
+	root := opts.Directory
+	if repo, err := repositoryRoot(root); err == nil {
+		root = repo
+	}
+	dir := filepath.Join(root, "artifacts")
+	err := os.MkdirAll(dir, 0755)
+	if err == nil {
+		var f *os.File
+		if f, err = ioutil.TempFile(dir, ".zim-doctor-"); err == nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		item.Status = checkFailed
+		item.Detail = err.Error()
+		item.Fix = fmt.Sprintf("Make %s writable by the current user", dir)
+		return item
+	}
+	item.Status = checkOK
+	item.Detail = dir
+	return item
+}
+
+// checkDefinitions reports whether the project and component definitions
+// load without errors
+func checkDefinitions(opts zimOptions) doctorViewItem {
+	item := doctorViewItem{Check: "definitions"}
+	proj, err := getProject(opts.Directory)
+	if err != nil {
+		item.Status = checkFailed
+		item.Detail = err.Error()
+		item.Fix = "Fix the YAML named in the error, then run zim lint"
+		return item
+	}
+	item.Status = checkOK
+	item.Detail = fmt.Sprintf("%d components", len(proj.Components()))
+	return item
+}
+
+// NewDoctorCommand returns a command that diagnoses problems with the
+// environment zim runs in
+func NewDoctorCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the environment for problems",
+		Long: `Check that git and the container runtime are available, the cache is
+reachable with the configured URL and token, the artifacts directory is
+writable, and the project's definitions are valid. A fix is suggested for
+each problem found.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			ctx := context.Background()
+			checks := []doctorViewItem{
+				checkGit(opts),
+				checkContainerRuntime(ctx, opts),
+				checkCache(ctx, opts),
+				checkArtifacts(opts),
+				checkDefinitions(opts),
+			}
+			var rows []interface{}
+			counts := map[string]int{}
+			for _, check := range checks {
+				counts[check.Status]++
+				rows = append(rows, check)
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Check", "Status", "Detail", "Fix"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+			if counts[checkFailed] > 0 {
+				fatal(fmt.Errorf("%d checks failed", counts[checkFailed]))
+			}
+			if counts[checkWarning] == 0 && opts.Format == format.TableFormat {
+				fmt.Println(project.Green("No problems found"))
+			}
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewDoctorCommand())
+}
@@ -0,0 +1,47 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"sync"
+
+	"github.com/fugue/zim/fault"
+	"github.com/spf13/viper"
+)
+
+var (
+	injectorOnce   sync.Once
+	sharedInjector *fault.Injector
+	injectorErr    error
+)
+
+// faultInjector returns the injector configured by the hidden fault-inject
+// setting, usually given as ZIM_FAULT_INJECT by tests. The injector is nil
+// if the setting is empty. It is shared so that one seed determines every
+// fault in the process.
+func faultInjector() (*fault.Injector, error) {
+	injectorOnce.Do(func() {
+		setting := viper.GetString("fault-inject")
+		if setting == "" {
+			return
+		}
+		cfg, err := fault.ParseConfig(setting)
+		if err != nil {
+			injectorErr = err
+			return
+		}
+		sharedInjector = fault.NewInjector(cfg)
+	})
+	return sharedInjector, injectorErr
+}
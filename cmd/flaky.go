@@ -0,0 +1,91 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type flakyViewItem struct {
+	Rule      string
+	Runs      int
+	Failed    int
+	Flaked    int
+	Rate      string
+	Status    string
+	LastFlake string
+}
+
+// NewFlakyCommand returns a command that reports flaky test rules
+func NewFlakyCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "flaky",
+		Short: "Show test rules that fail and then pass with unchanged inputs",
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			proj, err := getProject(opts.Directory)
+			if err != nil {
+				fatal(err)
+			}
+			tracker, err := project.LoadFlakyTracker(proj.FlakyPath())
+			if err != nil {
+				fatal(err)
+			}
+			var rows []interface{}
+			for _, r := range tracker.Rules() {
+				status := "flaked"
+				if r.Flaky() {
+					status = "flaky"
+				}
+				rows = append(rows, flakyViewItem{
+					Rule:      r.Rule,
+					Runs:      r.Runs(),
+					Failed:    r.Failed,
+					Flaked:    r.Flaked,
+					Rate:      fmt.Sprintf("%.0f%%", r.FlakeRate()*100),
+					Status:    status,
+					LastFlake: r.LastFlake.Format("2006-01-02 15:04"),
+				})
+			}
+			if len(rows) == 0 && opts.Format == format.TableFormat {
+				fmt.Println("No flaky tests found")
+				return
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows: rows,
+				Columns: []string{
+					"Rule", "Runs", "Failed", "Flaked", "Rate", "Status", "LastFlake",
+				},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewFlakyCommand())
+}
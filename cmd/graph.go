@@ -0,0 +1,127 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// writeGraphSVG renders the nodes as SVG using the Graphviz dot command
+func writeGraphSVG(w io.Writer, nodes []*project.GraphNode) error {
+	if _, err := osexec.LookPath("dot"); err != nil {
+		return errors.New("rendering SVG requires the Graphviz dot command")
+	}
+	var dot bytes.Buffer
+	if err := project.WriteGraphDOT(&dot, nodes); err != nil {
+		return err
+	}
+	render := osexec.Command("dot", "-Tsvg")
+	render.Stdin = &dot
+	render.Stdout = w
+	render.Stderr = os.Stderr
+	return render.Run()
+}
+
+// NewGraphCommand returns a command that exports the rule dependency graph
+func NewGraphCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Export the rule dependency graph",
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if len(opts.Rules) == 0 && len(args) > 0 {
+				opts.Rules = args
+			}
+			proj, err := getProject(opts.Directory)
+			if err != nil {
+				fatal(err)
+			}
+			comps, err := selectComponents(proj, opts)
+			if err != nil {
+				fatal(err)
+			}
+
+			// Graph the selected rules, or all rules if none were named
+			var rules []*project.Rule
+			if len(opts.Rules) > 0 {
+				rules = comps.Rules(opts.Rules)
+			} else {
+				for _, c := range comps {
+					rules = append(rules, c.Rules()...)
+				}
+			}
+
+			history, err := project.LoadHistory(proj.HistoryPath())
+			if err != nil {
+				fatal(err)
+			}
+			nodes := project.GraphNodes(rules, history)
+
+			var w io.Writer = os.Stdout
+			out, err := cmd.Flags().GetString("out")
+			if err != nil {
+				fatal(err)
+			}
+			if out != "" {
+				f, err := os.Create(out)
+				if err != nil {
+					fatal(err)
+				}
+				defer f.Close()
+				w = f
+			}
+
+			switch graphFormat := viper.GetString("graph-format"); graphFormat {
+			case "json":
+				err = project.WriteGraphJSON(w, nodes)
+			case "graphml":
+				err = project.WriteGraphML(w, nodes)
+			case "dot":
+				err = project.WriteGraphDOT(w, nodes)
+			case "svg":
+				err = writeGraphSVG(w, nodes)
+			default:
+				err = fmt.Errorf("unknown graph format: %s", graphFormat)
+			}
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().String("graph-format", "json", "Graph format (json | graphml | dot | svg)")
+	viper.BindPFlag("graph-format", cmd.Flags().Lookup("graph-format"))
+
+	cmd.Flags().String("out", "", "Path of the file to write instead of stdout")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewGraphCommand())
+}
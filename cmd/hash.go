@@ -0,0 +1,145 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type hashViewItem struct {
+	Path string
+	Hash string
+}
+
+// hashTargets returns the absolute paths of the files named by the
+// arguments. Each may be a file, a directory whose files are included
+// recursively, or a glob pattern relative to the working directory.
+func hashTargets(args []string) ([]string, error) {
+	found := map[string]bool{}
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil && strings.ContainsAny(arg, "*?[{") {
+			matches, err := project.MatchFiles(".", arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("No files match %s", arg)
+			}
+			for _, match := range matches {
+				found[match] = true
+			}
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			found[arg] = true
+			continue
+		}
+		err = filepath.Walk(arg, func(p string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() {
+				found[p] = true
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var paths []string
+	for p := range found {
+		absPath, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, absPath)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// NewHashCommand returns a command that hashes files the way rule keys do
+func NewHashCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "hash <path|glob>...",
+		Short: "Hash files as they are hashed in rule keys",
+		Long: `Hash files using the hash algorithm and large file settings of the project,
+exactly as input files are hashed when computing rule keys. Paths are shown
+relative to the project root, as they are named in keys. Directories are
+hashed file by file. Compare the results with zim key output when debugging
+unexpected cache misses.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			root, err := filepath.Abs(opts.Directory)
+			if err != nil {
+				fatal(err)
+			}
+			if repo, err := repositoryRoot(root); err == nil {
+				root = repo
+			}
+			hasher, err := projectHasher(root)
+			if err != nil {
+				fatal(err)
+			}
+			inputHasher, err := projectInputHasher(root, hasher)
+			if err != nil {
+				fatal(err)
+			}
+			paths, err := hashTargets(args)
+			if err != nil {
+				fatal(err)
+			}
+			var rows []interface{}
+			for _, p := range paths {
+				hash, err := inputHasher.File(p)
+				if err != nil {
+					fatal(err)
+				}
+				relPath, err := filepath.Rel(root, p)
+				if err != nil {
+					fatal(err)
+				}
+				rows = append(rows, hashViewItem{Path: relPath, Hash: hash})
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Path", "Hash"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewHashCommand())
+}
@@ -14,14 +14,15 @@
 package cmd
 
 import (
-	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/format"
 	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/vcs"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -31,14 +32,22 @@ func fatal(err error) {
 	os.Exit(1)
 }
 
+// getRepository returns the root directory of the working copy containing
+// the directory, or the directory itself if it is not under version control
 func getRepository(dir string) (string, error) {
 	if dir == "" {
 		dir = "."
 	}
-	return repoRoot(dir)
+	repo, err := vcs.Detect(dir)
+	if err != nil {
+		return "", err
+	}
+	return repo.Root(), nil
 }
 
-func gitRoot(dir string) (string, error) {
+// repositoryRoot returns the absolute path of the root directory of the
+// working copy containing the directory
+func repositoryRoot(dir string) (string, error) {
 	absDir, err := filepath.Abs(dir)
 	if err != nil {
 		return "", err
@@ -57,6 +66,67 @@ func getProject(dir string) (*project.Project, error) {
 	return project.New(absDir)
 }
 
+// selectComponents returns the components chosen by the selection options.
+// Components related to those named by --dependents-of and --dependencies-of
+// are added to any selected by name or kind.
+func selectComponents(proj *project.Project, opts zimOptions) (project.Components, error) {
+	if len(opts.DependentsOf) == 0 && len(opts.DependenciesOf) == 0 {
+		return proj.Select(opts.Components, opts.Kinds)
+	}
+	selected := map[*project.Component]bool{}
+	if len(opts.Components) > 0 || len(opts.Kinds) > 0 {
+		comps, err := proj.Select(opts.Components, opts.Kinds)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comps {
+			selected[c] = true
+		}
+	}
+	if len(opts.DependentsOf) > 0 {
+		comps, err := proj.Dependents(opts.DependentsOf)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comps {
+			selected[c] = true
+		}
+	}
+	if len(opts.DependenciesOf) > 0 {
+		comps, err := proj.Dependencies(opts.DependenciesOf)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range comps {
+			selected[c] = true
+		}
+	}
+	var result project.Components
+	for _, c := range proj.Components() {
+		if selected[c] {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// untilTarget returns the component and rule name identified by a target
+// given as "component.rule". A matrix rule name selects all its instances.
+func untilTarget(proj *project.Project, target string) (project.Components, []string, error) {
+	parts := strings.SplitN(target, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, nil, fmt.Errorf("Invalid target: %s (expected component.rule)", target)
+	}
+	c := proj.Components().WithName(filepath.Base(parts[0])).First()
+	if c == nil {
+		return nil, nil, fmt.Errorf("Unknown component: %s", parts[0])
+	}
+	if len(c.Select([]string{parts[1]})) == 0 {
+		return nil, nil, fmt.Errorf("Unknown rule: %s", target)
+	}
+	return project.Components{c}, []string{parts[1]}, nil
+}
+
 type zimOptions struct {
 	Directory  string
 	URL        string
@@ -73,6 +143,105 @@ type zimOptions struct {
 	Token      string
 	Platform   string
 	CachePath  string
+	Backend    string
+	Executor   string
+	Runtime    string
+	DryRun     bool
+	Offline    bool
+	Format     string
+	Pipeline   bool
+
+	// BuildID overrides the ID generated for a run, e.g. so that a run
+	// started through the API is identified by the ID given to the client
+	BuildID string
+
+	// CacheConcurrency limits the number of concurrent cache transfers
+	CacheConcurrency int
+
+	Kubernetes exec.KubernetesOpts
+
+	DependentsOf   []string
+	DependenciesOf []string
+
+	// Until is a "component.rule" target. Only it and its dependencies run,
+	// in place of the selected components and rules.
+	Until string
+
+	// Parameters are build parameters given as NAME=VALUE, which are
+	// variables of every rule
+	Parameters map[string]string
+}
+
+// parseParameters returns the build parameters given as NAME=VALUE
+func parseParameters(values []string) (map[string]string, error) {
+	params := map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid parameter: %s (expected NAME=VALUE)", value)
+		}
+		params[parts[0]] = parts[1]
+	}
+	return params, nil
+}
+
+// newExecutor returns the executor used to run rules, as selected by the
+// executor option. The docker option selects the default executor. Faults
+// are injected into its commands if fault injection is enabled.
+func newExecutor(opts zimOptions) (exec.Executor, error) {
+	executor, err := newSelectedExecutor(opts)
+	if err != nil {
+		return nil, err
+	}
+	injector, err := faultInjector()
+	if err != nil {
+		return nil, err
+	}
+	return injector.Executor(executor), nil
+}
+
+// newSelectedExecutor returns the executor selected by the options
+func newSelectedExecutor(opts zimOptions) (exec.Executor, error) {
+	switch opts.Executor {
+	case "":
+		if opts.UseDocker {
+			return newContainerExecutor(opts)
+		}
+		return exec.NewBashExecutor(), nil
+	case "bash":
+		return exec.NewBashExecutor(), nil
+	case "docker":
+		return newContainerExecutor(opts)
+	case "kubernetes":
+		k8sOpts := opts.Kubernetes
+		k8sOpts.MountDirectory = opts.Directory
+		return exec.NewKubernetesExecutor(k8sOpts), nil
+	default:
+		return nil, fmt.Errorf("Unknown executor: %s (bash | docker | kubernetes)", opts.Executor)
+	}
+}
+
+// newContainerExecutor returns an executor using the selected container
+// runtime, which is detected automatically by default
+func newContainerExecutor(opts zimOptions) (exec.Executor, error) {
+	runtime := opts.Runtime
+	if runtime == "auto" {
+		runtime = ""
+	}
+	if runtime != "" {
+		valid := false
+		for _, r := range exec.ContainerRuntimes {
+			valid = valid || r == runtime
+		}
+		if !valid {
+			return nil, fmt.Errorf("Unknown container runtime: %s (auto | %s)",
+				runtime, strings.Join(exec.ContainerRuntimes, " | "))
+		}
+	}
+	if opts.Offline {
+		return exec.NewOfflineContainerExecutor(runtime, opts.Directory, opts.Platform), nil
+	}
+	return exec.NewContainerExecutor(runtime, opts.Directory, opts.Platform), nil
 }
 
 func getZimOptions(cmd *cobra.Command, args []string) (zimOptions, error) {
@@ -92,6 +261,40 @@ func getZimOptions(cmd *cobra.Command, args []string) (zimOptions, error) {
 		Token:      viper.GetString("token"),
 		Platform:   viper.GetString("platform"),
 		CachePath:  viper.GetString("cache-path"),
+		Backend:    viper.GetString("cache-backend"),
+		Executor:   viper.GetString("executor"),
+		Runtime:    viper.GetString("container-runtime"),
+		Offline:    viper.GetBool("offline"),
+		Format:     viper.GetString("format"),
+		Pipeline:   viper.GetBool("pipeline"),
+
+		CacheConcurrency: viper.GetInt("cache-concurrency"),
+
+		Kubernetes: exec.KubernetesOpts{
+			Namespace:             viper.GetString("k8s-namespace"),
+			Image:                 viper.GetString("k8s-image"),
+			PersistentVolumeClaim: viper.GetString("k8s-pvc"),
+			CPU:                   viper.GetString("k8s-cpu"),
+			Memory:                viper.GetString("k8s-memory"),
+		},
+
+		DependentsOf:   viper.GetStringSlice("dependents-of"),
+		DependenciesOf: viper.GetStringSlice("dependencies-of"),
+		Until:          viper.GetString("until"),
+	}
+	// Parameters are read from the flag itself since viper doesn't parse
+	// string array flags, whose values may contain commas
+	paramValues, _ := cmd.Flags().GetStringArray("param")
+	params, err := parseParameters(paramValues)
+	if err != nil {
+		return zimOptions{}, err
+	}
+	opts.Parameters = params
+	if opts.Format == "" {
+		opts.Format = format.TableFormat
+	}
+	if opts.Format != format.TableFormat && opts.Format != format.JSONFormat {
+		return zimOptions{}, fmt.Errorf("Unknown format: %s (table | json)", opts.Format)
 	}
 	if opts.CachePath == "" {
 		opts.CachePath = LocalCacheDirectory()
@@ -106,14 +309,41 @@ func getZimOptions(cmd *cobra.Command, args []string) (zimOptions, error) {
 	for i, c := range opts.Components {
 		opts.Components[i] = filepath.Base(c)
 	}
+	for i, c := range opts.DependentsOf {
+		opts.DependentsOf[i] = filepath.Base(c)
+	}
+	for i, c := range opts.DependenciesOf {
+		opts.DependenciesOf[i] = filepath.Base(c)
+	}
 
-	// Rules can be specified by arguments or options for run
-	if cmd.Name() == "run" && len(opts.Rules) == 0 && len(args) > 0 {
+	// Rules can be specified by arguments or options for run and plan
+	if (cmd.Name() == "run" || cmd.Name() == "plan") && len(opts.Rules) == 0 && len(args) > 0 {
 		opts.Rules = args
 	}
 	return opts, nil
 }
 
+// printRows prints the rows as a table, or as JSON if that output format
+// was chosen
+func printRows(opts zimOptions, tableOpts format.TableOpts) error {
+	if opts.Format == format.JSONFormat {
+		text, err := format.JSON(tableOpts)
+		if err != nil {
+			return err
+		}
+		fmt.Println(text)
+		return nil
+	}
+	table, err := format.Table(tableOpts)
+	if err != nil {
+		return err
+	}
+	for _, tableRow := range table {
+		fmt.Println(tableRow)
+	}
+	return nil
+}
+
 // LocalCacheDirectory returns the directory in the local filesystem
 // to be used for caching
 func LocalCacheDirectory() string {
@@ -127,24 +357,3 @@ func LocalCacheDirectory() string {
 	}
 	return filepath.Join(home, ".cache", "zim")
 }
-
-// repoRoot returns the root directory of the Git repository, given any
-// path within the repository
-func repoRoot(dir string) (string, error) {
-
-	var b bytes.Buffer
-	args := []string{"rev-parse", "--git-dir"}
-	command := exec.Command("git", args...)
-	command.Dir = dir
-	command.Stdout = &b
-	command.Stderr = &b
-
-	if err := command.Run(); err != nil {
-		return "", fmt.Errorf("failed to run git rev-parse: %s", err)
-	}
-	output := strings.TrimSpace(b.String())
-	if output == ".git" {
-		return dir, nil
-	}
-	return filepath.Dir(output), nil
-}
@@ -0,0 +1,68 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+// NewInitCommand returns a command that creates the files of a new project
+func NewInitCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Create the configuration of a new project",
+		Long: `Create .zim/project.yaml, starter templates for Go and Node components in
+.zim/templates, and an example component.yaml at the root of the repository.
+With --infer, a component.yaml is created instead in each directory that
+contains a go.mod or package.json. Existing files are left unchanged.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			root := opts.Directory
+			if repo, err := repositoryRoot(root); err == nil {
+				root = repo
+			}
+			infer, _ := cmd.Flags().GetBool("infer")
+			created, err := project.Scaffold(project.ScaffoldOpts{
+				Root:  root,
+				Infer: infer,
+			})
+			if err != nil {
+				fatal(err)
+			}
+			for _, relPath := range created {
+				fmt.Println("Created", project.Bright(relPath))
+			}
+			if len(created) == 0 {
+				fmt.Println("The project is already initialized")
+			}
+		},
+	}
+
+	cmd.Flags().Bool("infer", false, "Create components for Go modules and Node packages found")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewInitCommand())
+}
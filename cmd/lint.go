@@ -0,0 +1,109 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+type lintViewItem struct {
+	Component string
+	Rule      string
+	Problem   string
+}
+
+// NewLintCommand returns a command that checks rule definitions for problems
+func NewLintCommand() *cobra.Command {
+
+	defaultCols := []string{
+		"Component",
+		"Rule",
+		"Problem",
+	}
+
+	cmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check rule definitions for problems",
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			proj, err := getProject(opts.Directory)
+			if err != nil {
+				fatal(err)
+			}
+			comps, err := selectComponents(proj, opts)
+			if err != nil {
+				fatal(err)
+			}
+
+			var rules []*project.Rule
+			for _, c := range comps {
+				rules = append(rules, c.Rules()...)
+			}
+			sort.Slice(rules, func(i, j int) bool {
+				return rules[i].NodeID() < rules[j].NodeID()
+			})
+
+			lintOpts := project.LintOpts{Deep: viper.GetBool("deep")}
+			var rows []interface{}
+			for _, r := range rules {
+				problems, err := r.Lint(lintOpts)
+				if err != nil {
+					fatal(err)
+				}
+				for _, problem := range problems {
+					rows = append(rows, lintViewItem{
+						Component: r.Component().Name(),
+						Rule:      r.Name(),
+						Problem:   problem.Message,
+					})
+				}
+			}
+			if len(rows) == 0 && opts.Format == format.TableFormat {
+				fmt.Println(project.Green("No problems found"))
+				return
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    defaultCols,
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+			if len(rows) == 0 {
+				return
+			}
+			fatal(fmt.Errorf("%d problems found", len(rows)))
+		},
+	}
+
+	cmd.Flags().Bool("deep", false, "Inspect files to find unused inputs and stale outputs")
+	viper.BindPFlag("deep", cmd.Flags().Lookup("deep"))
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewLintCommand())
+}
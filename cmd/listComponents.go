@@ -14,7 +14,7 @@
 package cmd
 
 import (
-	"fmt"
+	"strings"
 
 	"github.com/fugue/zim/format"
 	"github.com/spf13/cobra"
@@ -24,6 +24,8 @@ type listComponentsViewItem struct {
 	Name      string
 	Kind      string
 	App       string
+	Image     string
+	Tags      string
 	Directory string
 }
 
@@ -33,6 +35,8 @@ func NewListComponentsCommand() *cobra.Command {
 	defaultCols := []string{
 		"Name",
 		"Kind",
+		"Image",
+		"Tags",
 	}
 
 	cmd := &cobra.Command{
@@ -49,10 +53,13 @@ func NewListComponentsCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-			comps, err := proj.Select(opts.Components, opts.Kinds)
+			comps, err := selectComponents(proj, opts)
 			if err != nil {
 				fatal(err)
 			}
+			if tags, _ := cmd.Flags().GetStringSlice("tag"); len(tags) > 0 {
+				comps = comps.WithTag(tags...)
+			}
 
 			var rows []interface{}
 			for _, c := range comps {
@@ -60,10 +67,12 @@ func NewListComponentsCommand() *cobra.Command {
 					Name:      c.Name(),
 					Kind:      c.Kind(),
 					App:       c.App(),
+					Image:     c.Image(),
+					Tags:      strings.Join(c.Tags(), ","),
 					Directory: c.Directory(),
 				})
 			}
-			table, err := format.Table(format.TableOpts{
+			err = printRows(opts, format.TableOpts{
 				Rows:       rows,
 				Columns:    defaultCols,
 				ShowHeader: true,
@@ -71,12 +80,11 @@ func NewListComponentsCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-
-			for _, tableRow := range table {
-				fmt.Println(tableRow)
-			}
 		},
 	}
+
+	cmd.Flags().StringSlice("tag", nil, "List only components with these tags")
+
 	return cmd
 }
 
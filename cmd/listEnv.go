@@ -14,8 +14,6 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/fatih/structs"
 	"github.com/fugue/zim/format"
 	"github.com/spf13/cobra"
@@ -49,7 +47,7 @@ var listEnvCmd = &cobra.Command{
 			rows = append(rows, listEnvViewItem{Key: k, Value: fields[k]})
 		}
 
-		table, err := format.Table(format.TableOpts{
+		err = printRows(opts, format.TableOpts{
 			Rows:       rows,
 			Columns:    defaultCols,
 			ShowHeader: true,
@@ -57,10 +55,6 @@ var listEnvCmd = &cobra.Command{
 		if err != nil {
 			fatal(err)
 		}
-
-		for _, tableRow := range table {
-			fmt.Println(tableRow)
-		}
 	},
 }
 
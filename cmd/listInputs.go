@@ -14,8 +14,6 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/fugue/zim/format"
 	"github.com/spf13/cobra"
 )
@@ -49,7 +47,7 @@ func NewListInputsCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-			comps, err := proj.Select(opts.Components, opts.Kinds)
+			comps, err := selectComponents(proj, opts)
 			if err != nil {
 				fatal(err)
 			}
@@ -74,7 +72,7 @@ func NewListInputsCommand() *cobra.Command {
 					}
 				}
 			}
-			table, err := format.Table(format.TableOpts{
+			err = printRows(opts, format.TableOpts{
 				Rows:       rows,
 				Columns:    defaultCols,
 				ShowHeader: true,
@@ -82,9 +80,6 @@ func NewListInputsCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-			for _, tableRow := range table {
-				fmt.Println(tableRow)
-			}
 		},
 	}
 	return cmd
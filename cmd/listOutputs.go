@@ -14,8 +14,6 @@
 package cmd
 
 import (
-	"fmt"
-
 	"github.com/fatih/color"
 	"github.com/fugue/zim/format"
 	"github.com/spf13/cobra"
@@ -52,7 +50,7 @@ func NewListArtifactsCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-			comps, err := proj.Select(opts.Components, opts.Kinds)
+			comps, err := selectComponents(proj, opts)
 			if err != nil {
 				fatal(err)
 			}
@@ -90,7 +88,7 @@ func NewListArtifactsCommand() *cobra.Command {
 					}
 				}
 			}
-			table, err := format.Table(format.TableOpts{
+			err = printRows(opts, format.TableOpts{
 				Rows:       rows,
 				Columns:    defaultCols,
 				ShowHeader: true,
@@ -99,9 +97,6 @@ func NewListArtifactsCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-			for _, tableRow := range table {
-				fmt.Println(tableRow)
-			}
 		},
 	}
 	return cmd
@@ -14,15 +14,18 @@
 package cmd
 
 import (
-	"fmt"
+	"strings"
 
 	"github.com/fugue/zim/format"
 	"github.com/spf13/cobra"
 )
 
 type listRulesViewItem struct {
-	Component string
-	Rule      string
+	Component   string
+	Rule        string
+	Description string
+	Image       string
+	Outputs     string
 }
 
 // NewListRulesCommand returns a command that lists all rules in the project
@@ -31,6 +34,9 @@ func NewListRulesCommand() *cobra.Command {
 	defaultCols := []string{
 		"Component",
 		"Rule",
+		"Description",
+		"Image",
+		"Outputs",
 	}
 
 	cmd := &cobra.Command{
@@ -47,21 +53,39 @@ func NewListRulesCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-			comps, err := proj.Select(opts.Components, opts.Kinds)
+			comps, err := selectComponents(proj, opts)
 			if err != nil {
 				fatal(err)
 			}
+			if tags, _ := cmd.Flags().GetStringSlice("tag"); len(tags) > 0 {
+				comps = comps.WithTag(tags...)
+			}
 
 			var rows []interface{}
 			for _, c := range comps {
-				for _, r := range c.Rules() {
-					rows = append(rows, listRulesViewItem{
-						Component: c.Name(),
-						Rule:      r.Name(),
-					})
+				rules := c.Rules()
+				if len(opts.Rules) > 0 {
+					rules = c.Select(opts.Rules)
+				}
+				for _, r := range rules {
+					item := listRulesViewItem{
+						Component:   c.Name(),
+						Rule:        r.Name(),
+						Description: r.Description(),
+					}
+					if !r.IsNative() {
+						item.Image = r.Image()
+					}
+					// Output paths are shown relative to the project root
+					outputs, err := r.Outputs().RelativePaths(proj.RootAbsPath())
+					if err != nil {
+						fatal(err)
+					}
+					item.Outputs = strings.Join(outputs, ",")
+					rows = append(rows, item)
 				}
 			}
-			table, err := format.Table(format.TableOpts{
+			err = printRows(opts, format.TableOpts{
 				Rows:       rows,
 				Columns:    defaultCols,
 				ShowHeader: true,
@@ -69,12 +93,11 @@ func NewListRulesCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-
-			for _, tableRow := range table {
-				fmt.Println(tableRow)
-			}
 		},
 	}
+
+	cmd.Flags().StringSlice("tag", nil, "List only rules of components with these tags")
+
 	return cmd
 }
 
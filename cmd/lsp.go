@@ -0,0 +1,104 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+// lspResponse is written for each file requested by an editor
+type lspResponse struct {
+	*project.FileInfo
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// locateFile returns the response for a file path relative to the working
+// directory
+func locateFile(proj *project.Project, path string) lspResponse {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return lspResponse{Path: path, Error: err.Error()}
+	}
+	info, err := proj.Locate(absPath)
+	if err != nil {
+		return lspResponse{Path: path, Error: err.Error()}
+	}
+	return lspResponse{FileInfo: info}
+}
+
+// NewLspCommand returns a command that describes files for editors
+func NewLspCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "lsp [file...]",
+		Short: "Describe files for editor integrations",
+		Long: `Describe files for editor integrations.
+
+For each file, a line of JSON is written containing the component that owns
+the file, the rules that use it as an input, and actions that run those rules.
+With --stdio, file paths are read from stdin one per line and a response is
+written for each, so that editors may keep one process running.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			proj, err := getProject(opts.Directory)
+			if err != nil {
+				fatal(err)
+			}
+			stdio, _ := cmd.Flags().GetBool("stdio")
+
+			encoder := json.NewEncoder(os.Stdout)
+			for _, arg := range args {
+				if err := encoder.Encode(locateFile(proj, arg)); err != nil {
+					fatal(err)
+				}
+			}
+			if !stdio {
+				return
+			}
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				path := strings.TrimSpace(scanner.Text())
+				if path == "" {
+					continue
+				}
+				if err := encoder.Encode(locateFile(proj, path)); err != nil {
+					fatal(err)
+				}
+			}
+			if err := scanner.Err(); err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().Bool("stdio", false, "Read file paths from stdin")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewLspCommand())
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	osexec "os/exec"
+	"runtime"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+// Where a rule output can be found
+const (
+	outputLocal   = "local"
+	outputCached  = "cache"
+	outputMissing = "missing"
+)
+
+type outputViewItem struct {
+	Rule     string
+	Output   string
+	Location string
+}
+
+// locateOutputs returns the location of each output of the named rule.
+// Outputs missing from disk are looked up in the cache, if one is
+// configured, and are restored from it when fetch is set.
+func locateOutputs(opts zimOptions, ruleName string, fetch bool) ([]outputViewItem, error) {
+
+	if rootDir, err := repositoryRoot(opts.Directory); err == nil {
+		opts.Directory = rootDir
+	}
+	r, err := loadRule(opts, ruleName)
+	if err != nil {
+		return nil, err
+	}
+	outputs := r.Outputs()
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("Rule %s has no outputs", r.NodeID())
+	}
+	missing := r.MissingOutputs().Paths()
+	location := outputLocal
+	if len(missing) > 0 {
+		if location, err = cachedLocation(opts, r, fetch); err != nil {
+			return nil, err
+		}
+	}
+	isMissing := map[string]bool{}
+	for _, p := range missing {
+		isMissing[p] = true
+	}
+	var items []outputViewItem
+	for _, out := range outputs {
+		item := outputViewItem{Rule: r.NodeID(), Output: out.Path(), Location: outputLocal}
+		if isMissing[out.Path()] {
+			item.Location = location
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// cachedLocation returns where the missing outputs of a rule can be found.
+// They are restored from the cache if fetch is set.
+func cachedLocation(opts zimOptions, r *project.Rule, fetch bool) (string, error) {
+	ctx := context.Background()
+	zimCache, err := newCache(opts)
+	if err != nil || zimCache == nil {
+		return outputMissing, err
+	}
+	found, err := zimCache.Contains(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return outputMissing, nil
+	}
+	if !fetch {
+		return outputCached, nil
+	}
+	if _, err := zimCache.Read(ctx, r); err != nil {
+		if err == cache.CacheMiss {
+			return outputMissing, nil
+		}
+		return "", err
+	}
+	return outputLocal, nil
+}
+
+// openCommand returns the command that opens a file with the default
+// application of the platform
+func openCommand(path string) *osexec.Cmd {
+	switch runtime.GOOS {
+	case "darwin":
+		return osexec.Command("open", path)
+	case "windows":
+		return osexec.Command("cmd", "/c", "start", "", path)
+	default:
+		return osexec.Command("xdg-open", path)
+	}
+}
+
+// ruleArgument returns the rule given as an argument or with -r
+func ruleArgument(opts zimOptions, args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	} else if len(opts.Rules) == 1 {
+		return opts.Rules[0], nil
+	}
+	return "", errors.New("Must specify a rule as <component>.<rule>")
+}
+
+// NewOutputsCommand returns a command that shows where the outputs of a rule
+// can be found
+func NewOutputsCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "outputs <component>.<rule>",
+		Short: "Show where the outputs of a rule are",
+		Long: `Show the path of each output of a rule and where it can be found: on
+disk, in the cache, or missing. Outputs that are only in the cache are
+restored with --fetch. With --print-path, the paths of the outputs on disk are
+printed one per line, which suits scripts.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			ruleName, err := ruleArgument(opts, args)
+			if err != nil {
+				fatal(err)
+			}
+			fetch, _ := cmd.Flags().GetBool("fetch")
+			items, err := locateOutputs(opts, ruleName, fetch)
+			if err != nil {
+				fatal(err)
+			}
+			if printPath, _ := cmd.Flags().GetBool("print-path"); printPath {
+				for _, item := range items {
+					if item.Location == outputLocal {
+						fmt.Println(item.Output)
+					}
+				}
+				return
+			}
+			var rows []interface{}
+			for _, item := range items {
+				rows = append(rows, item)
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Rule", "Output", "Location"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().Bool("fetch", false, "Restore outputs that are only in the cache")
+	cmd.Flags().Bool("print-path", false, "Print only the paths of outputs on disk")
+
+	cmd.AddCommand(NewOutputsOpenCommand())
+	return cmd
+}
+
+// NewOutputsOpenCommand returns a command that opens the outputs of a rule
+func NewOutputsOpenCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "open <component>.<rule>",
+		Short: "Open the outputs of a rule",
+		Long: `Open each output of a rule with the default application for it, restoring
+the outputs from the cache first if they are not on disk.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			ruleName, err := ruleArgument(opts, args)
+			if err != nil {
+				fatal(err)
+			}
+			items, err := locateOutputs(opts, ruleName, true)
+			if err != nil {
+				fatal(err)
+			}
+			for _, item := range items {
+				if item.Location != outputLocal {
+					fatal(fmt.Errorf("Output %s is missing. Run %s first.", item.Output, item.Rule))
+				}
+				if err := openCommand(item.Output).Run(); err != nil {
+					fatal(fmt.Errorf("Failed to open %s: %s", item.Output, err))
+				}
+			}
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewOutputsCommand())
+}
@@ -0,0 +1,137 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/user"
+	"strings"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type planViewItem struct {
+	Rule     string
+	Image    string
+	Commands int
+	Requires string
+}
+
+// newKeyFunc returns a function that computes rule keys the same way the
+// cache does, without a store
+func newKeyFunc(opts zimOptions) (project.KeyFunc, error) {
+	self, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := projectHasher(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	inputHasher, err := projectInputHasher(opts.Directory, hasher)
+	if err != nil {
+		return nil, err
+	}
+	zimCache := cache.New(cache.Opts{
+		Hasher:      hasher,
+		InputHasher: inputHasher,
+		User:        self.Name,
+	})
+	return func(r *project.Rule) (string, error) {
+		key, err := zimCache.Key(context.Background(), r)
+		if err != nil {
+			return "", err
+		}
+		return key.String(), nil
+	}, nil
+}
+
+// NewPlanCommand returns a command that exports an execution plan
+func NewPlanCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "plan [RULE...]",
+		Short: "Export an execution plan for rules",
+		Long: `Export the rules selected by the arguments, and the rules they depend on,
+in the order they must run. With --format json the plan includes each rule's
+key, commands, environment, image, working directory, inputs, outputs, and
+dependencies, so that an external system can run the rules. Results of those
+rules are recorded with zim report.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if len(opts.Rules) == 0 {
+				fatal(errors.New("no rules specified"))
+			}
+			proj, err := loadProject(opts)
+			if err != nil {
+				fatal(err)
+			}
+			components, err := selectComponents(proj, opts)
+			if err != nil {
+				fatal(err)
+			}
+			keyFunc, err := newKeyFunc(opts)
+			if err != nil {
+				fatal(err)
+			}
+			build := project.NewBuild(opts.Rules)
+			plan, err := project.NewExecutionPlan(proj, build.ID,
+				components.Rules(opts.Rules), keyFunc)
+			if err != nil {
+				fatal(err)
+			}
+			if opts.Format == format.JSONFormat {
+				data, err := json.MarshalIndent(plan, "", "  ")
+				if err != nil {
+					fatal(err)
+				}
+				fmt.Println(string(data))
+				return
+			}
+			var rows []interface{}
+			for _, r := range plan.Rules {
+				rows = append(rows, planViewItem{
+					Rule:     r.ID,
+					Image:    r.Image,
+					Commands: len(r.Commands),
+					Requires: strings.Join(r.Requires, ","),
+				})
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Rule", "Image", "Commands", "Requires"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewPlanCommand())
+}
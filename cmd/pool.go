@@ -0,0 +1,125 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/fugue/zim/distributed"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// NewPoolCommand returns a command that scales workers with their queue
+func NewPoolCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "pool",
+		Short: "Scale workers for distributed builds",
+		Long: `Watch the SQS queue of distributed builds and scale the workers polling it
+between --min and --max. Workers run as the tasks of an ECS service, e.g. on
+Fargate, or as the replicas of a Kubernetes deployment, which is scaled with
+kubectl. Enough workers are run for the jobs queued or running, given the
+--jobs of each worker. The pool grows as soon as jobs are queued and shrinks
+once fewer workers have been needed for --scale-down-delay. The queue
+backlog and the number of workers are published to CloudWatch if
+--metrics-namespace is set.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			closeHandler(cancel)
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			queueURL, _ := cmd.Flags().GetString("queue")
+			if queueURL == "" {
+				queueURL = viper.GetString("queue")
+			}
+			if queueURL == "" {
+				fatal(errors.New("A queue must be given with --queue"))
+			}
+			cluster, _ := cmd.Flags().GetString("ecs-cluster")
+			service, _ := cmd.Flags().GetString("ecs-service")
+			deployment, _ := cmd.Flags().GetString("k8s-deployment")
+			namespace, _ := cmd.Flags().GetString("k8s-namespace")
+
+			var scaler distributed.Scaler
+			var name string
+			switch {
+			case service != "" && deployment != "":
+				fatal(errors.New("Give either --ecs-service or --k8s-deployment, not both"))
+			case service != "":
+				scaler = distributed.NewECSService(cluster, service, opts.Region)
+				name = service
+			case deployment != "":
+				scaler = distributed.NewKubernetesDeployment(deployment, namespace)
+				name = deployment
+			default:
+				fatal(errors.New("Workers must be given with --ecs-service or --k8s-deployment"))
+			}
+
+			minWorkers, _ := cmd.Flags().GetInt("min")
+			maxWorkers, _ := cmd.Flags().GetInt("max")
+			jobs, _ := cmd.Flags().GetInt("jobs")
+			interval, _ := cmd.Flags().GetDuration("interval")
+			delay, _ := cmd.Flags().GetDuration("scale-down-delay")
+			metricsNamespace, _ := cmd.Flags().GetString("metrics-namespace")
+
+			poolOpts := distributed.PoolOpts{
+				Backlog:        distributed.NewSQS(queueURL, opts.Region),
+				Scaler:         scaler,
+				Min:            minWorkers,
+				Max:            maxWorkers,
+				JobsPerWorker:  jobs,
+				Interval:       interval,
+				ScaleDownDelay: delay,
+			}
+			if metricsNamespace != "" {
+				poolOpts.Metrics = distributed.NewCloudWatchMetrics(metricsNamespace, name, opts.Region)
+			}
+			pool, err := distributed.NewPool(poolOpts)
+			if err != nil {
+				fatal(err)
+			}
+			fmt.Println("Scaling", name, "with", queueURL)
+			if err := pool.Start(ctx); err != nil && err != context.Canceled {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().String("queue", "", "URL of the SQS queue the workers poll")
+	cmd.Flags().String("ecs-cluster", "", "ECS cluster of the workers' service")
+	cmd.Flags().String("ecs-service", "", "ECS service that runs the workers")
+	cmd.Flags().String("k8s-deployment", "", "Kubernetes deployment that runs the workers")
+	cmd.Flags().String("k8s-namespace", "", "Namespace of the Kubernetes deployment")
+	cmd.Flags().Int("min", 0, "Fewest workers to run")
+	cmd.Flags().Int("max", 10, "Most workers to run")
+	cmd.Flags().IntP("jobs", "j", 1, "Concurrent jobs of each worker")
+	cmd.Flags().Duration("interval", distributed.DefaultPoolInterval, "Time between checks of the queue")
+	cmd.Flags().Duration("scale-down-delay", distributed.DefaultScaleDownDelay,
+		"How long fewer workers must be needed before the pool shrinks")
+	cmd.Flags().String("metrics-namespace", "", "CloudWatch namespace to publish pool metrics in")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewPoolCommand())
+}
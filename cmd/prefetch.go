@@ -0,0 +1,228 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/project"
+	s3Provider "github.com/fugue/zim/provider/s3"
+	"github.com/spf13/cobra"
+)
+
+// containerRuntime returns the container runtime selected by the options
+func containerRuntime(opts zimOptions) string {
+	if opts.Runtime == "" || opts.Runtime == "auto" {
+		return exec.DetectContainerRuntime()
+	}
+	return opts.Runtime
+}
+
+// checkOffline returns an error if the selected rules need a Docker image
+// that isn't available locally, since running them would pull it, or need
+// anything else that is only available online
+func checkOffline(
+	components project.Components,
+	executor exec.Executor,
+	projDef *definitions.Project,
+	opts zimOptions,
+) error {
+
+	rules := transitiveRules(components, opts.Rules)
+	prereqs := project.FindPrerequisites(rules, executor.UsesDocker())
+	runtime := containerRuntime(opts)
+	var missing []string
+	for _, image := range prereqs.Images {
+		if !exec.ImageExists(runtime, image) {
+			missing = append(missing, image)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("offline: images not available locally: %s (run zim prefetch first)",
+			strings.Join(missing, ", "))
+	}
+
+	// Secrets are resolved from SSM and Secrets Manager when rules run
+	var withSecrets []string
+	for _, r := range rules {
+		if len(r.Secrets()) > 0 {
+			withSecrets = append(withSecrets, r.NodeID())
+		}
+	}
+	if len(withSecrets) > 0 {
+		return fmt.Errorf("offline: rules have secrets that can only be resolved online: %s",
+			strings.Join(withSecrets, ", "))
+	}
+
+	// Inputs and outputs in S3 are read and written when rules run
+	var withS3 []string
+	for _, r := range rules {
+		if in, out := r.ProviderNames(); in == s3Provider.Name || out == s3Provider.Name {
+			withS3 = append(withS3, r.NodeID())
+		}
+	}
+	if len(withS3) > 0 {
+		return fmt.Errorf("offline: rules have inputs or outputs in S3: %s",
+			strings.Join(withS3, ", "))
+	}
+
+	// Mutexes are shared between builds through DynamoDB if configured
+	if projDef != nil && projDef.Locks.DynamoDBTable != "" {
+		var withMutex []string
+		for _, r := range rules {
+			if r.Mutex() != "" {
+				withMutex = append(withMutex, r.NodeID())
+			}
+		}
+		if len(withMutex) > 0 {
+			return fmt.Errorf("offline: rules have mutexes locked in the DynamoDB table %s: %s",
+				projDef.Locks.DynamoDBTable, strings.Join(withMutex, ", "))
+		}
+	}
+	return nil
+}
+
+// runFetchCommand runs a command that downloads a prerequisite
+func runFetchCommand(ctx context.Context, dir string, args []string) error {
+	fmt.Println("cmd:", project.Cyan(strings.Join(args, " ")))
+	command := osexec.CommandContext(ctx, args[0], args[1:]...)
+	command.Dir = dir
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	return command.Run()
+}
+
+// prefetchCache copies the cache entries of the rules from the configured
+// cache to the local cache used in offline mode. Rules missing from the
+// cache are reported as warnings.
+func prefetchCache(ctx context.Context, rules []*project.Rule, opts zimOptions) error {
+	if localCacheDir(opts) != "" {
+		return nil // Already local
+	}
+	remote, err := newCache(opts)
+	if err != nil || remote == nil {
+		return err
+	}
+	offlineOpts := opts
+	offlineOpts.Offline = true
+	local, err := newCache(offlineOpts)
+	if err != nil {
+		return err
+	}
+
+	// Stream an export of the remote entries into the local cache
+	pr, pw := io.Pipe()
+	var missing []string
+	go func() {
+		var exportErr error
+		missing, exportErr = remote.Export(ctx, rules, pw)
+		pw.CloseWithError(exportErr)
+	}()
+	count, err := local.Import(ctx, pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	for _, nodeID := range missing {
+		fmt.Fprintln(os.Stderr, project.Yellow(
+			fmt.Sprintf("Not in cache: %s", nodeID)))
+	}
+	fmt.Printf("Downloaded %d cache items\n", count)
+	return nil
+}
+
+// NewPrefetchCommand returns a command that downloads everything needed to
+// run rules in offline mode
+func NewPrefetchCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "prefetch",
+		Short: "Download what rules need to run offline",
+		Long: `Download everything needed to run the selected rules and their dependencies
+without network access: Docker images, Nix environments, tool versions, and
+cache entries from a remote cache, which are copied to the local cache. Rules
+may then be run with --offline, which fails instead of using the network.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			closeHandler(cancel)
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if len(opts.Rules) == 0 && len(args) > 0 {
+				opts.Rules = args
+			}
+			if len(opts.Rules) == 0 {
+				fatal(errors.New("Must specify one or more rules"))
+			}
+			if opts.Offline {
+				fatal(errors.New("Prefetching is not possible in offline mode"))
+			}
+			proj, err := loadProject(opts)
+			if err != nil {
+				fatal(err)
+			}
+			comps, err := selectComponents(proj, opts)
+			if err != nil {
+				fatal(err)
+			}
+			executor, err := newExecutor(opts)
+			if err != nil {
+				fatal(err)
+			}
+			rules := transitiveRules(comps, opts.Rules)
+			prereqs := project.FindPrerequisites(rules, executor.UsesDocker())
+
+			runtime := containerRuntime(opts)
+			for _, image := range prereqs.Images {
+				fmt.Println("pull:", project.Cyan(image))
+				if err := exec.PullImage(ctx, runtime, opts.Platform, image, os.Stdout); err != nil {
+					fatal(fmt.Errorf("failed to pull %s: %s", image, err))
+				}
+			}
+			for _, nix := range prereqs.Nix {
+				dir := filepath.Dir(nix.File)
+				if err := runFetchCommand(ctx, dir, nix.FetchCommand()); err != nil {
+					fatal(fmt.Errorf("failed to fetch nix environment %s: %s", nix.File, err))
+				}
+			}
+			for _, tools := range prereqs.Tools {
+				dir := filepath.Dir(tools.File)
+				if err := runFetchCommand(ctx, dir, tools.InstallCommand()); err != nil {
+					fatal(fmt.Errorf("failed to install tools for %s: %s", tools.File, err))
+				}
+			}
+			if err := prefetchCache(ctx, rules, opts); err != nil {
+				fatal(err)
+			}
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewPrefetchCommand())
+}
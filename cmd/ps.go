@@ -0,0 +1,101 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type psViewItem struct {
+	Rule     string
+	PID      int
+	Ports    string
+	Uptime   string
+	Restarts int
+	Healthy  bool
+}
+
+// readServiceStates returns the states of running services in the project
+func readServiceStates(cmd *cobra.Command, args []string) ([]*project.ServiceState, error) {
+	opts, err := getZimOptions(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	proj, err := getProject(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	return project.ReadServiceStates(proj.ServicesDir())
+}
+
+// NewPsCommand returns a command that lists running services
+func NewPsCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "ps",
+		Short: "List running services",
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			states, err := readServiceStates(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if len(states) == 0 && opts.Format == format.TableFormat {
+				fmt.Println("No services running")
+				return
+			}
+
+			var rows []interface{}
+			for _, state := range states {
+				var ports []string
+				for name, port := range state.Ports {
+					ports = append(ports, fmt.Sprintf("%s=%d", name, port))
+				}
+				sort.Strings(ports)
+				rows = append(rows, psViewItem{
+					Rule:     state.Rule,
+					PID:      state.PID,
+					Ports:    strings.Join(ports, " "),
+					Uptime:   time.Since(state.StartedAt).Round(time.Second).String(),
+					Restarts: state.Restarts,
+					Healthy:  state.Healthy,
+				})
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Rule", "PID", "Ports", "Uptime", "Restarts", "Healthy"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewPsCommand())
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type reportViewItem struct {
+	Rule    string
+	Status  string
+	Stored  string
+	Problem string
+}
+
+// ingestResult validates the outputs of one reported rule and writes them to
+// the cache. The code to record for the rule is returned along with whether
+// its outputs were stored.
+func ingestResult(
+	ctx context.Context,
+	c *cache.Cache,
+	r *project.Rule,
+	rr project.ReportedRule,
+	buildID string,
+) (project.Code, bool, error) {
+
+	code, err := rr.Code()
+	if err != nil || code != project.OK {
+		return code, false, err
+	}
+	if err := project.StageOutputs(r, rr); err != nil {
+		return project.MissingOutputError, false, err
+	}
+	if c == nil {
+		return code, false, nil
+	}
+	// Outputs are only stored under the key from the plan. A different key
+	// means the inputs changed after the plan was exported.
+	key, err := c.Key(ctx, r)
+	if err != nil {
+		return project.Error, false, err
+	}
+	if rr.Key != "" && rr.Key != key.String() {
+		return project.Error, false, fmt.Errorf(
+			"key %s does not match the current key %s", rr.Key, key.String())
+	}
+	if _, err := c.WriteBuild(ctx, r, buildID); err != nil {
+		return project.Error, false, err
+	}
+	return code, true, nil
+}
+
+// NewReportCommand returns a command that ingests results of an external run
+func NewReportCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "report RESULTS",
+		Short: "Record results of rules run by an external system",
+		Long: `Record the results of rules from an execution plan that were run by an
+external system. The results file lists each rule's id, key, status, duration,
+and the locations of its outputs. Outputs of successful rules are copied to
+their paths in the project, checked, and written to the cache. The build and
+rule durations are recorded as if the rules were run locally.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			report, err := project.LoadReport(args[0])
+			if err != nil {
+				fatal(err)
+			}
+			proj, err := loadProject(opts)
+			if err != nil {
+				fatal(err)
+			}
+			var zimCache *cache.Cache
+			if opts.CacheMode != cache.Disabled {
+				if zimCache, err = newCache(opts); err != nil {
+					fatal(err)
+				}
+			}
+			if zimCache == nil {
+				fmt.Fprint(os.Stderr, project.Yellow(
+					"Cache is not configured. Outputs will not be stored.\n"))
+			}
+			history, err := project.LoadHistory(proj.HistoryPath())
+			if err != nil {
+				fmt.Fprint(os.Stderr, project.Yellow(
+					fmt.Sprintf("Ignoring unreadable run history: %s\n", err)))
+			}
+
+			var ids []string
+			for _, rr := range report.Results {
+				ids = append(ids, rr.ID)
+			}
+			build := project.NewBuild(ids)
+			if report.BuildID != "" {
+				build.ID = report.BuildID
+			}
+
+			ctx := context.Background()
+			counts := map[string]int{}
+			var rows []interface{}
+			var failed error
+			problems := 0
+			for _, rr := range report.Results {
+				item := reportViewItem{Rule: rr.ID, Status: rr.Status, Stored: "no"}
+				code := project.Error
+				r, err := proj.RuleByID(rr.ID)
+				if err == nil {
+					var stored bool
+					code, stored, err = ingestResult(ctx, zimCache, r, rr, build.ID)
+					if stored {
+						item.Stored = "yes"
+					}
+					if err == nil && history != nil {
+						history.Record(r, code, rr.Elapsed())
+					}
+				}
+				if err != nil {
+					item.Problem = err.Error()
+					problems++
+					if failed == nil {
+						failed = err
+					}
+				}
+				item.Status = code.String()
+				counts[code.String()]++
+				rows = append(rows, item)
+			}
+
+			build.Finish(counts, failed)
+			saveBuild(proj, build)
+			if history != nil {
+				if err := history.Save(); err != nil {
+					fmt.Fprint(os.Stderr, project.Yellow(
+						fmt.Sprintf("Failed to save run history: %s\n", err)))
+				}
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Rule", "Status", "Stored", "Problem"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+			if problems > 0 {
+				fatal(fmt.Errorf("%d of %d results could not be recorded",
+					problems, len(report.Results)))
+			}
+		},
+	}
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewReportCommand())
+}
@@ -18,6 +18,8 @@ import (
 	"os"
 	"strings"
 
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/project"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -49,9 +51,17 @@ func init() {
 	rootCmd.PersistentFlags().StringSliceP("kinds", "k", nil, "Select kinds of components to operate on")
 	rootCmd.PersistentFlags().StringSliceP("components", "c", nil, "Select components to operate on by name")
 	rootCmd.PersistentFlags().StringSliceP("rules", "r", nil, "Rules to run against components")
-	rootCmd.PersistentFlags().String("cache", "read-write", "Cache mode (read-write | write-only | disabled)")
-	rootCmd.PersistentFlags().String("output", "buffered", "Output mode (buffered | unbuffered)")
+	rootCmd.PersistentFlags().StringSlice("dependents-of", nil, "Select components that depend on these components")
+	rootCmd.PersistentFlags().StringSlice("dependencies-of", nil, "Select components that these components depend on")
+	rootCmd.PersistentFlags().String("cache", "read-write", "Cache mode (read-write | write-only | audit | disabled)")
+	rootCmd.PersistentFlags().String("cache-backend", "", "Cache storage backend (gcs://bucket/prefix | file:///path)")
+	rootCmd.PersistentFlags().Int("cache-concurrency", cache.DefaultConcurrency, "Maximum number of concurrent cache transfers")
+	rootCmd.PersistentFlags().String("output", "buffered", "Output mode (buffered | unbuffered | plain | tui)")
 	rootCmd.PersistentFlags().String("platform", "", "Docker target platform (linux/amd64, linux/arm64, ...)")
+	rootCmd.PersistentFlags().String("container-runtime", "auto", "Container runtime (auto | docker | podman | nerdctl)")
+	rootCmd.PersistentFlags().String("format", "table", "Format of printed results (table | json)")
+	rootCmd.PersistentFlags().Bool("offline", false, "Fail instead of using the network, after running zim prefetch")
+	rootCmd.PersistentFlags().StringArray("param", nil, "Set a build parameter given to rules as a variable (NAME=VALUE)")
 
 	// Bind flags to environment variables if they are present
 	viper.BindPFlag("url", rootCmd.PersistentFlags().Lookup("url"))
@@ -62,9 +72,16 @@ func init() {
 	viper.BindPFlag("kinds", rootCmd.PersistentFlags().Lookup("kinds"))
 	viper.BindPFlag("components", rootCmd.PersistentFlags().Lookup("components"))
 	viper.BindPFlag("rules", rootCmd.PersistentFlags().Lookup("rules"))
+	viper.BindPFlag("dependents-of", rootCmd.PersistentFlags().Lookup("dependents-of"))
+	viper.BindPFlag("dependencies-of", rootCmd.PersistentFlags().Lookup("dependencies-of"))
 	viper.BindPFlag("cache", rootCmd.PersistentFlags().Lookup("cache"))
+	viper.BindPFlag("cache-backend", rootCmd.PersistentFlags().Lookup("cache-backend"))
+	viper.BindPFlag("cache-concurrency", rootCmd.PersistentFlags().Lookup("cache-concurrency"))
 	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
 	viper.BindPFlag("platform", rootCmd.PersistentFlags().Lookup("platform"))
+	viper.BindPFlag("container-runtime", rootCmd.PersistentFlags().Lookup("container-runtime"))
+	viper.BindPFlag("format", rootCmd.PersistentFlags().Lookup("format"))
+	viper.BindPFlag("offline", rootCmd.PersistentFlags().Lookup("offline"))
 
 	// Flag completions
 	rootCmd.RegisterFlagCompletionFunc("components", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
@@ -140,4 +157,12 @@ func initConfig() {
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.ReadInConfig()
+
+	// The live view needs a terminal to draw in
+	if viper.GetString("output") == "tui" && !project.IsTerminal(os.Stdout) {
+		viper.Set("output", "plain")
+	}
+	if viper.GetString("output") == "plain" {
+		project.SetPlainOutput(true)
+	}
 }
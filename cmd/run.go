@@ -16,21 +16,26 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
-	"os/user"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/distributed"
 	"github.com/fugue/zim/exec"
-	"github.com/fugue/zim/hash"
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/locks"
 	"github.com/fugue/zim/project"
 	"github.com/fugue/zim/sched"
-	fsStore "github.com/fugue/zim/store/filesystem"
-	httpStore "github.com/fugue/zim/store/http"
+	"github.com/fugue/zim/secrets"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -45,6 +50,569 @@ func closeHandler(cancel context.CancelFunc) {
 	}()
 }
 
+// scheduleRules runs each of the named rules in turn against the components,
+// stopping at the first failure
+func scheduleRules(
+	ctx context.Context,
+	components project.Components,
+	runner project.Runner,
+	executor exec.Executor,
+	build *project.Build,
+	opts zimOptions,
+) error {
+	scheduler := sched.NewGraphScheduler()
+	for _, rule := range opts.Rules {
+		rules := components.Rules([]string{rule})
+		if len(rules) == 0 {
+			return nil
+		}
+		err := scheduler.Run(ctx, sched.Options{
+			BuildID:       build.ID,
+			ParentBuildID: build.ParentID,
+			Rules:         rules,
+			Runner:        runner,
+			Executor:      executor,
+			NumWorkers:    opts.Jobs,
+			Pipeline:      opts.Pipeline,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queuedRules returns the IDs of the rules a run is expected to run, with
+// dependencies before the rules that need them
+func queuedRules(components project.Components, opts zimOptions) []string {
+	var nodeIDs []string
+	seen := map[string]bool{}
+	for _, rule := range opts.Rules {
+		sorted, err := project.GraphFromRules(components.Rules([]string{rule})).Sort()
+		if err != nil {
+			continue
+		}
+		for i := len(sorted) - 1; i >= 0; i-- {
+			if nodeID := sorted[i].NodeID(); !seen[nodeID] {
+				seen[nodeID] = true
+				nodeIDs = append(nodeIDs, nodeID)
+			}
+		}
+	}
+	return nodeIDs
+}
+
+// runRules runs the rules selected by the options. The extra middleware is
+// placed beneath the logger. The error returned is either one setting up the
+// run or that of the scheduler.
+func runRules(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	opts zimOptions,
+	extra ...project.RunnerBuilder,
+) error {
+
+	// If inside a git repo pick the root as the project directory
+	if repo, err := getRepository(opts.Directory); err == nil {
+		opts.Directory = repo
+	}
+	absDir, err := filepath.Abs(opts.Directory)
+	if err != nil {
+		return err
+	}
+	opts.Directory = absDir
+
+	if opts.Jobs < 1 {
+		opts.Jobs = 1
+	}
+
+	// With JSON output, stdout holds only the summary. Everything else
+	// printed during the run, including the output of rule commands, goes
+	// to stderr instead.
+	stdout := os.Stdout
+	if opts.Format == format.JSONFormat {
+		os.Stdout = os.Stderr
+		defer func() { os.Stdout = stdout }()
+	}
+
+	executor, err := newExecutor(opts)
+	if err != nil {
+		return err
+	}
+
+	projDef, componentDefs, err := project.Discover(opts.Directory)
+	if err != nil {
+		return err
+	}
+
+	// Load selected components from the project
+	proj, err := project.NewWithOptions(project.Opts{
+		Root:          opts.Directory,
+		ProjectDef:    projDef,
+		ComponentDefs: componentDefs,
+		Executor:      executor,
+		Providers:     projectProviders(opts),
+		Offline:       opts.Offline,
+		Platform:      opts.Platform,
+		Parameters:    opts.Parameters,
+	})
+	if err != nil {
+		return err
+	}
+
+	components, err := selectComponents(proj, opts)
+	if err != nil {
+		return err
+	}
+	// A target replaces the selection, so that only the rules it needs run
+	if opts.Until != "" {
+		if components, opts.Rules, err = untilTarget(proj, opts.Until); err != nil {
+			return err
+		}
+	}
+	build := project.NewBuild(opts.Rules)
+	if opts.BuildID != "" {
+		build.ID = opts.BuildID
+	}
+
+	if opts.Offline {
+		if err := checkOffline(components, executor, projDef, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		return planRules(ctx, components, executor, build, opts)
+	}
+
+	// Create list of middleware to use
+	var builders []project.RunnerBuilder
+	if opts.Debug {
+		builders = append(builders, project.Debug)
+	}
+	// Plain output is collected and printed in a stable order at the end
+	var ordered *project.OrderedOutput
+	var view *project.TerminalView
+	switch opts.OutputMode {
+	case "buffered":
+		builders = append(builders, project.BufferedOutput)
+	case "plain":
+		ordered = project.NewOrderedOutput(os.Stdout)
+		builders = append(builders, ordered.Middleware)
+	case "tui":
+		view = project.NewTerminalView(os.Stdout, queuedRules(components, opts))
+		builders = append(builders, view.Middleware)
+	}
+	builders = append(builders, project.Logger)
+
+	// The output of each rule is also kept in a log file for the build
+	ruleLogs := project.NewRuleLogs(filepath.Join(proj.LogsDir(), build.ID))
+	builders = append(builders, ruleLogs.Middleware)
+
+	// Record the outcome of each rule for the build record and for a
+	// machine-readable summary
+	summary := project.NewSummary()
+	builders = append(builders, summary.Middleware)
+	builders = append(builders, extra...)
+
+	// Record rule durations and cache hits for future estimates
+	history, err := project.LoadHistory(proj.HistoryPath())
+	if err != nil {
+		fmt.Fprint(os.Stderr, project.Yellow(
+			fmt.Sprintf("Ignoring unreadable run history: %s\n", err)))
+	} else {
+		builders = append(builders, history.Middleware)
+	}
+
+	// Failures of rules with allow_failure are reported as warnings, after
+	// any retries, and rules that depend on them are skipped
+	builders = append(builders, project.NewAllowedFailures().Middleware)
+
+	// Rules with a retry policy are run again beneath the middleware above,
+	// which sees only the final attempt
+	builders = append(builders, project.Retry)
+
+	// Track passes and failures of test rules to identify flaky tests. Each
+	// attempt of a retried rule is tracked, so a failure followed by a pass
+	// on retry counts as a flake.
+	flaky, err := project.LoadFlakyTracker(proj.FlakyPath())
+	if err != nil {
+		fmt.Fprint(os.Stderr, project.Yellow(
+			fmt.Sprintf("Ignoring unreadable flaky test history: %s\n", err)))
+	} else if err := flaky.SetRetryPolicy(viper.GetString("retry-flaky")); err != nil {
+		return err
+	} else {
+		builders = append(builders, flaky.Middleware)
+	}
+
+	// Resource accounting is recorded beneath the logger so that
+	// rules restored from the cache show no usage
+	var usage *project.UsageRecorder
+	if viper.GetBool("usage") {
+		usage = project.NewUsageRecorder()
+		builders = append(builders, usage.Middleware)
+	}
+
+	// The image digest each rule ran in is recorded in the build for audits
+	images := project.NewImageRecorder()
+	builders = append(builders, images.Middleware)
+
+	// Open the cache depending on configuration. The cache also stores
+	// baselines used by the coverage built-in.
+	standardRunner := &project.StandardRunner{
+		Secrets:          secrets.NewAWS(opts.Region),
+		ContainerRuntime: containerRuntime(opts),
+	}
+	if minFree := viper.GetString("min-free-space"); minFree != "" {
+		if standardRunner.MinFreeSpace, err = project.ParseSize(minFree); err != nil {
+			return err
+		}
+	}
+	// Strict mode checks the files each rule command writes. Rules whose
+	// outputs are restored from the cache are marked as active too.
+	var strictDef definitions.Strict
+	if projDef != nil {
+		strictDef = projDef.Strict
+	}
+	if strictDef.Enabled || viper.GetBool("strict") {
+		strict := project.NewStrictMode(proj, strictDef.Scratch)
+		builders = append(builders, strict.Middleware)
+		standardRunner.Strict = strict
+	}
+	var auditLog *cache.AuditLog
+	var zimCache *cache.Cache
+	if opts.CacheMode == cache.Disabled {
+		fmt.Fprint(os.Stdout, project.Yellow("Caching is disabled.\n"))
+	} else if cacheInterface, err := newCache(opts); err != nil {
+		return err
+	} else if cacheInterface != nil {
+		standardRunner.Baselines = cacheInterface
+		auditLog = cacheInterface.AuditLog()
+		zimCache = cacheInterface
+	} else {
+		fmt.Fprint(os.Stderr,
+			project.Yellow("Cache URL is not set. See the docs!\n"))
+	}
+
+	// In a distributed build, rules that aren't cached are run by workers.
+	// Otherwise rules run here beneath the cache.
+	var coordinator *distributed.Coordinator
+	if viper.GetBool("distributed") {
+		if coordinator, err = newCoordinator(ctx, proj, projDef, zimCache, opts); err != nil {
+			return err
+		}
+	} else {
+		if zimCache != nil {
+			builders = append(builders, cache.NewMiddleware(zimCache))
+		}
+		local, err := localMiddleware(proj, projDef, opts)
+		if err != nil {
+			return err
+		}
+		builders = append(builders, local...)
+	}
+
+	// Service rules are started in the background beneath all other
+	// middleware. Services have no outputs, so the cache passes them through.
+	services := project.NewServices(ctx, project.ServicesOpts{
+		StateDir: proj.ServicesDir(),
+	})
+	builders = append(builders, services.Middleware)
+
+	// Chain together all middleware
+	var runner project.Runner
+	if coordinator != nil {
+		runner = project.NewChain(builders...).Then(coordinator)
+	} else {
+		runner = project.NewChain(builders...).Then(standardRunner)
+	}
+
+	// Run the scheduler which gives rules to workers to execute
+	// in order of rule dependencies
+	// Record the build so that builds started by its rules, which are given
+	// its ID in their environment, can be traced back to it
+	saveBuild(proj, build)
+	startedAt := time.Now()
+	if view != nil {
+		view.Start(250 * time.Millisecond)
+	}
+	schedulerErr := scheduleRules(ctx, components, runner, executor, build, opts)
+	if view != nil {
+		view.Stop()
+	}
+	totals := summary.Totals(time.Since(startedAt), history)
+	build.Finish(summary.Counts(), schedulerErr)
+	build.Images = images.Images()
+	// Keys are recorded so that zim key diff can compare against this build
+	if zimCache != nil {
+		build.Keys = zimCache.Keys()
+	}
+	saveBuild(proj, build)
+	if ordered != nil {
+		ordered.Flush()
+	}
+
+	// Keep running until interrupted if any services were started.
+	// Otherwise stop services that were started before a failure.
+	if running := services.Running(); len(running) > 0 && schedulerErr == nil {
+		fmt.Println(project.Yellow(fmt.Sprintf(
+			"%d services running. Press Ctrl+C to stop.", len(running))))
+	} else {
+		cancel()
+	}
+	services.Wait()
+
+	if usage != nil {
+		printUsage(opts, usage)
+	}
+	if auditLog != nil {
+		printAudit(opts, auditLog)
+	}
+	if opts.Format == format.TableFormat && !project.PlainOutput() {
+		printTotals(totals)
+	}
+	if history != nil {
+		if err := history.Save(); err != nil {
+			fmt.Fprint(os.Stderr, project.Yellow(
+				fmt.Sprintf("Failed to save run history: %s\n", err)))
+		}
+	}
+	if flaky != nil {
+		if err := flaky.Save(); err != nil {
+			fmt.Fprint(os.Stderr, project.Yellow(
+				fmt.Sprintf("Failed to save flaky test history: %s\n", err)))
+		}
+	}
+	if opts.CacheMode != cache.Disabled {
+		collectCache(opts)
+	}
+	if opts.Format == format.JSONFormat {
+		if err := printRunSummary(stdout, build, summary, totals, schedulerErr); err != nil {
+			return err
+		}
+	}
+	return schedulerErr
+}
+
+// localMiddleware returns the middleware used beneath the cache when rules
+// run on this machine, including on a worker
+func localMiddleware(
+	proj *project.Project,
+	projDef *definitions.Project,
+	opts zimOptions,
+) ([]project.RunnerBuilder, error) {
+
+	var builders []project.RunnerBuilder
+
+	// Rules sharing a mutex run one at a time, beneath the cache so that
+	// cached Rules don't wait. Builds elsewhere wait too if a table of
+	// locks is configured.
+	var locker project.Locker
+	if projDef != nil && projDef.Locks.DynamoDBTable != "" {
+		var ttl time.Duration
+		if projDef.Locks.TTL != "" {
+			var err error
+			if ttl, err = time.ParseDuration(projDef.Locks.TTL); err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("invalid lock TTL: %s", projDef.Locks.TTL)
+			}
+		}
+		region := projDef.Locks.Region
+		if region == "" {
+			region = opts.Region
+		}
+		locker = locks.NewDynamoDB(projDef.Locks.DynamoDBTable, region, ttl)
+	}
+	builders = append(builders, project.NewRuleMutexes(locker).Middleware)
+
+	// Scanners inspect outputs beneath the cache, before they are stored
+	if projDef != nil && len(projDef.Scanners) > 0 {
+		scanners, err := project.NewScanners(projDef.Scanners, proj.QuarantineDir())
+		if err != nil {
+			return nil, err
+		}
+		builders = append(builders, scanners.Middleware)
+	}
+	return builders, nil
+}
+
+// runSummary is the machine-readable result of a run
+type runSummary struct {
+	BuildID       string               `json:"build_id,omitempty"`
+	ParentBuildID string               `json:"parent_build_id,omitempty"`
+	Results       []project.RuleResult `json:"results"`
+	Counts        map[string]int       `json:"counts"`
+	Totals        project.Totals       `json:"totals"`
+	Error         string               `json:"error,omitempty"`
+}
+
+// printRunSummary writes the outcome of each rule as indented JSON
+func printRunSummary(
+	w io.Writer,
+	build *project.Build,
+	summary *project.Summary,
+	totals project.Totals,
+	schedulerErr error,
+) error {
+	result := runSummary{
+		BuildID:       build.ID,
+		ParentBuildID: build.ParentID,
+		Results:       summary.Results(),
+		Counts:        summary.Counts(),
+		Totals:        totals,
+	}
+	// Build IDs and durations differ between runs
+	if project.PlainOutput() {
+		result.BuildID = ""
+		result.ParentBuildID = ""
+		for i := range result.Results {
+			result.Results[i].Duration = 0
+		}
+		result.Totals.WallTime = 0
+		result.Totals.RuleTime = 0
+		result.Totals.TimeSaved = 0
+	}
+	if schedulerErr != nil {
+		result.Error = schedulerErr.Error()
+	}
+	data, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, string(data))
+	return nil
+}
+
+// printTotals prints the time a run took and an estimate of what the cache
+// saved
+func printTotals(totals project.Totals) {
+	seconds := func(s float64) string {
+		return project.FormatDuration(time.Duration(s * float64(time.Second)))
+	}
+	fmt.Printf("Finished in %s with %s of rule time.",
+		seconds(totals.WallTime), seconds(totals.RuleTime))
+	if totals.Cached > 0 {
+		fmt.Printf(" %d cached rules saved about %s and reused %s of outputs.",
+			totals.Cached, seconds(totals.TimeSaved),
+			project.FormatBytes(totals.BytesReused))
+	}
+	fmt.Println()
+}
+
+// saveBuild writes the record of a build. Failures are reported as warnings.
+func saveBuild(proj *project.Project, build *project.Build) {
+	if err := project.SaveBuild(proj.BuildsDir(), build); err != nil {
+		fmt.Fprint(os.Stderr, project.Yellow(
+			fmt.Sprintf("Failed to save build record: %s\n", err)))
+	}
+}
+
+// planRules prints what running the rules would do without running any of
+// their commands. Conditions are evaluated and the cache is checked for each
+// rule, but nothing is downloaded, recorded, or started.
+func planRules(
+	ctx context.Context,
+	components project.Components,
+	executor exec.Executor,
+	build *project.Build,
+	opts zimOptions,
+) error {
+
+	plan := project.NewPlan()
+	builders := []project.RunnerBuilder{plan.Middleware}
+	if opts.CacheMode != cache.Disabled {
+		cacheInterface, err := newCache(opts)
+		if err != nil {
+			return err
+		}
+		if cacheInterface != nil {
+			builders = append(builders, cache.NewMiddleware(cacheInterface))
+		}
+	}
+	runner := project.NewChain(builders...).Then(&project.StandardRunner{})
+
+	if err := scheduleRules(ctx, components, runner, executor, build, opts); err != nil {
+		return err
+	}
+
+	var rows []interface{}
+	counts := map[string]int{}
+	for _, item := range plan.Items() {
+		rows = append(rows, item)
+		counts[item.Action]++
+	}
+	if len(rows) > 0 || opts.Format == format.JSONFormat {
+		err := printRows(opts, format.TableOpts{
+			Rows:       rows,
+			Columns:    []string{"Rule", "Action"},
+			ShowHeader: true,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if opts.Format == format.JSONFormat {
+		return nil
+	}
+	fmt.Printf("%d to run, %d cached, %d skipped\n",
+		counts[project.PlanRun], counts[project.PlanCached], counts[project.PlanSkip])
+	return nil
+}
+
+// openEvents opens the destination of an event stream: a file path, or
+// "fd:N" for a file descriptor opened by the parent process
+func openEvents(dest string) (io.WriteCloser, error) {
+	if strings.HasPrefix(dest, "fd:") {
+		fd, err := strconv.Atoi(strings.TrimPrefix(dest, "fd:"))
+		if err != nil || fd < 0 {
+			return nil, fmt.Errorf("Invalid event stream file descriptor: %s", dest)
+		}
+		return os.NewFile(uintptr(fd), "events"), nil
+	}
+	return os.Create(dest)
+}
+
+// runWithEvents runs rules while writing progress events to the given
+// destination as lines of JSON. Rule output is written to a log file per
+// rule rather than included in the stream.
+func runWithEvents(
+	ctx context.Context,
+	cancel context.CancelFunc,
+	opts zimOptions,
+	dest string,
+) error {
+	w, err := openEvents(dest)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	proj, err := getProject(opts.Directory)
+	if err != nil {
+		return err
+	}
+	total, err := countRules(opts)
+	if err != nil {
+		return err
+	}
+	history, _ := project.LoadHistory(proj.HistoryPath())
+
+	// Events carry the ID of the build so that they can be matched with
+	// its record and the cache items it writes
+	runID := project.UUID()
+	opts.BuildID = runID
+	progress := project.NewProgress(project.ProgressOpts{
+		RunID:   runID,
+		Total:   total,
+		History: history,
+		LogsDir: filepath.Join(proj.LogsDir(), runID),
+		Emit:    project.NewEventWriter(w),
+	})
+	progress.Start()
+	err = runRules(ctx, cancel, opts, progress.Middleware)
+	progress.Finish(err)
+	return err
+}
+
 // NewRunCommand returns a scheduler command
 func NewRunCommand() *cobra.Command {
 
@@ -61,117 +629,16 @@ func NewRunCommand() *cobra.Command {
 			if err != nil {
 				fatal(err)
 			}
-
-			// If inside a git repo pick the root as the project directory
-			if repo, err := getRepository(opts.Directory); err == nil {
-				opts.Directory = repo
-			}
-			absDir, err := filepath.Abs(opts.Directory)
-			if err != nil {
-				fatal(err)
-			}
-			opts.Directory = absDir
-
-			if opts.Jobs < 1 {
-				opts.Jobs = 1
-			}
-
-			var executor exec.Executor
-			if opts.UseDocker {
-				executor = exec.NewDockerExecutor(opts.Directory, opts.Platform)
-			} else {
-				executor = exec.NewBashExecutor()
-			}
-
-			projDef, componentDefs, err := project.Discover(opts.Directory)
-			if err != nil {
-				fatal(err)
-			}
-
-			// Load selected components from the project
-			proj, err := project.NewWithOptions(project.Opts{
-				Root:          opts.Directory,
-				ProjectDef:    projDef,
-				ComponentDefs: componentDefs,
-				Executor:      executor,
-			})
-			if err != nil {
+			if opts.DryRun, err = cmd.Flags().GetBool("dry-run"); err != nil {
 				fatal(err)
 			}
 
-			components, err := proj.Select(opts.Components, opts.Kinds)
-			if err != nil {
-				fatal(err)
-			}
-			buildID := project.UUID()
-
-			// Create list of middleware to use
-			var builders []project.RunnerBuilder
-			if opts.Debug {
-				builders = append(builders, project.Debug)
-			}
-			if opts.OutputMode == "buffered" {
-				builders = append(builders, project.BufferedOutput)
-			}
-			builders = append(builders, project.Logger)
-
-			// Add caching middleware depending on configuration
-			if opts.CacheMode == cache.Disabled {
-				fmt.Fprint(os.Stdout, project.Yellow("Caching is disabled.\n"))
-			} else if opts.URL != "" {
-				objStore := httpStore.New(opts.URL, opts.Token)
-				self, err := user.Current()
-				if err != nil {
-					fatal(err)
-				}
-				cacheInterface := cache.New(cache.Opts{
-					Store:  objStore,
-					Hasher: hash.SHA1(),
-					User:   self.Name,
-				})
-				builders = append(builders, cache.NewMiddleware(cacheInterface))
-			} else if opts.CachePath != "" {
-				objStore := fsStore.New(opts.CachePath)
-				self, err := user.Current()
-				if err != nil {
-					fatal(err)
-				}
-				cacheInterface := cache.New(cache.Opts{
-					Store:  objStore,
-					Hasher: hash.SHA1(),
-					User:   self.Name,
-				})
-				builders = append(builders, cache.NewMiddleware(cacheInterface))
-			} else {
-				fmt.Fprint(os.Stderr,
-					project.Yellow("Cache URL is not set. See the docs!\n"))
-			}
-
-			// Chain together all middleware
-			runner := project.NewChain(builders...).
-				Then(&project.StandardRunner{})
-
-			// Run the scheduler which gives rules to workers to execute
-			// in order of rule dependencies
 			var schedulerErr error
-			scheduler := sched.NewGraphScheduler()
-			for _, rule := range opts.Rules {
-				rules := components.Rules([]string{rule})
-				if len(rules) == 0 {
-					return
-				}
-				schedulerErr = scheduler.Run(ctx, sched.Options{
-					BuildID:    buildID,
-					Rules:      rules,
-					Runner:     runner,
-					Executor:   executor,
-					NumWorkers: opts.Jobs,
-				})
-				if schedulerErr != nil {
-					break
-				}
+			if events, _ := cmd.Flags().GetString("events"); events != "" {
+				schedulerErr = runWithEvents(ctx, cancel, opts, events)
+			} else {
+				schedulerErr = runRules(ctx, cancel, opts)
 			}
-
 			if schedulerErr != nil {
 				if schedulerErr.Error() == "context canceled" {
 					// Wait for cleanup before exiting
@@ -202,9 +669,146 @@ func NewRunCommand() *cobra.Command {
 	cmd.Flags().IntP("jobs", "j", 1, "Concurrent jobs")
 	viper.BindPFlag("jobs", cmd.Flags().Lookup("jobs"))
 
+	cmd.Flags().Bool("usage", false, "Show CPU, memory, and time used by each rule")
+	viper.BindPFlag("usage", cmd.Flags().Lookup("usage"))
+
+	cmd.Flags().Bool("dry-run", false, "Show which rules would run without running them")
+
+	cmd.Flags().String("events", "", "Write a stream of JSON build events to a file or fd:N")
+
+	cmd.Flags().String("until", "", "Run only the given component.rule and its dependencies")
+	viper.BindPFlag("until", cmd.Flags().Lookup("until"))
+
+	cmd.Flags().String("min-free-space", "", "Disk space that must be free before each rule runs, e.g. 1GB")
+	viper.BindPFlag("min-free-space", cmd.Flags().Lookup("min-free-space"))
+
+	cmd.Flags().Bool("strict", false, "Fail rules that write to files other than their outputs")
+	viper.BindPFlag("strict", cmd.Flags().Lookup("strict"))
+
+	cmd.Flags().Bool("pipeline", false, "Start rules once the outputs they need from dependencies exist (experimental)")
+	viper.BindPFlag("pipeline", cmd.Flags().Lookup("pipeline"))
+
+	cmd.Flags().String("retry-flaky", project.RetryNone, "Retry failing tests once (none | flaky | all)")
+	viper.BindPFlag("retry-flaky", cmd.Flags().Lookup("retry-flaky"))
+
+	cmd.Flags().Bool("distributed", false, "Run rules that aren't cached on workers polling the queue")
+	viper.BindPFlag("distributed", cmd.Flags().Lookup("distributed"))
+
+	cmd.Flags().String("queue", "", "URL of the SQS queue that distributed jobs are sent to")
+	viper.BindPFlag("queue", cmd.Flags().Lookup("queue"))
+
+	cmd.Flags().String("executor", "", "Rule executor (bash | docker | kubernetes)")
+	viper.BindPFlag("executor", cmd.Flags().Lookup("executor"))
+
+	cmd.Flags().String("k8s-namespace", "", "Kubernetes namespace for rule pods")
+	viper.BindPFlag("k8s-namespace", cmd.Flags().Lookup("k8s-namespace"))
+
+	cmd.Flags().String("k8s-image", "", "Kubernetes image for rules without a Docker image")
+	viper.BindPFlag("k8s-image", cmd.Flags().Lookup("k8s-image"))
+
+	cmd.Flags().String("k8s-pvc", "", "Persistent volume claim containing the project")
+	viper.BindPFlag("k8s-pvc", cmd.Flags().Lookup("k8s-pvc"))
+
+	cmd.Flags().String("k8s-cpu", "", "CPU request for rule pods")
+	viper.BindPFlag("k8s-cpu", cmd.Flags().Lookup("k8s-cpu"))
+
+	cmd.Flags().String("k8s-memory", "", "Memory request for rule pods")
+	viper.BindPFlag("k8s-memory", cmd.Flags().Lookup("k8s-memory"))
+
 	return cmd
 }
 
+type usageViewItem struct {
+	Rule   string
+	Wall   string
+	User   string
+	System string
+	MaxRSS string
+}
+
+func newUsageViewItem(name string, u exec.Usage) usageViewItem {
+	seconds := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f sec", d.Seconds())
+	}
+	return usageViewItem{
+		Rule:   name,
+		Wall:   seconds(u.Wall),
+		User:   seconds(u.User),
+		System: seconds(u.System),
+		MaxRSS: fmt.Sprintf("%.1f MB", float64(u.MaxRSS)/(1024*1024)),
+	}
+}
+
+// printUsage shows a table of resources consumed by each rule that ran
+func printUsage(opts zimOptions, usage *project.UsageRecorder) {
+	var rows []interface{}
+	for _, ru := range usage.Rules() {
+		rows = append(rows, newUsageViewItem(ru.NodeID, ru.Usage))
+	}
+	if len(rows) == 0 {
+		return
+	}
+	rows = append(rows, newUsageViewItem("TOTAL", usage.Total()))
+	err := printRows(opts, format.TableOpts{
+		Rows:       rows,
+		Columns:    []string{"Rule", "Wall", "User", "System", "MaxRSS"},
+		ShowHeader: true,
+	})
+	if err != nil {
+		fatal(err)
+	}
+}
+
+type auditViewItem struct {
+	Rule       string
+	Cache      string
+	Duration   string
+	Mismatched string
+}
+
+// printAudit prints what the cache would have done for each rule in audit
+// mode, followed by the totals
+func printAudit(opts zimOptions, auditLog *cache.AuditLog) {
+	var rows []interface{}
+	for _, result := range auditLog.Results() {
+		status := "miss"
+		if result.Hit {
+			status = "hit"
+		} else if result.Write {
+			status = "write"
+		}
+		item := auditViewItem{
+			Rule:       result.Rule,
+			Cache:      status,
+			Mismatched: strings.Join(result.Mismatched, ","),
+		}
+		if !project.PlainOutput() {
+			item.Duration = result.Duration.Round(time.Millisecond).String()
+		}
+		rows = append(rows, item)
+	}
+	if len(rows) == 0 {
+		return
+	}
+	err := printRows(opts, format.TableOpts{
+		Rows:       rows,
+		Columns:    []string{"Rule", "Cache", "Duration", "Mismatched"},
+		ShowHeader: true,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	if opts.Format == format.TableFormat {
+		summary := auditLog.Summary()
+		fmt.Printf("Cache audit: %d hits, %d misses, %d writes, %d mismatched.",
+			summary.Hits, summary.Misses, summary.Writes, summary.Mismatches)
+		if !project.PlainOutput() {
+			fmt.Printf(" Hits would have saved %s.", summary.Savings.Round(time.Millisecond))
+		}
+		fmt.Println()
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(NewRunCommand())
 }
@@ -0,0 +1,121 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fugue/zim/e2e"
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type selftestViewItem struct {
+	Scenario string
+	Status   string
+	Duration string
+	Detail   string
+}
+
+// NewSelftestCommand returns a command that runs end-to-end scenarios
+func NewSelftestCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "selftest [SCENARIO.yaml...]",
+		Short: "Run end-to-end scenarios against this build of zim",
+		Long: `Create fixture projects, run real builds in them with a filesystem cache,
+and check which rules ran, which were restored from the cache, and the
+contents of their outputs. The built-in scenarios are run unless scenario
+files are given. Each fixture project is created in a temporary directory,
+which is kept with --keep.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			closeHandler(cancel)
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			var scenarios []*e2e.Scenario
+			if len(args) == 0 {
+				if scenarios, err = e2e.Builtin(); err != nil {
+					fatal(err)
+				}
+			}
+			for _, arg := range args {
+				s, err := e2e.LoadScenario(arg)
+				if err != nil {
+					fatal(err)
+				}
+				scenarios = append(scenarios, s)
+			}
+			keep, _ := cmd.Flags().GetBool("keep")
+			verbose, _ := cmd.Flags().GetBool("verbose")
+			var output io.Writer
+			if verbose {
+				output = os.Stdout
+			}
+			results, err := e2e.Run(ctx, scenarios, e2e.Opts{Keep: keep, Output: output})
+			if err != nil {
+				fatal(err)
+			}
+
+			var rows []interface{}
+			var failed int
+			for _, result := range results {
+				item := selftestViewItem{
+					Scenario: result.Name,
+					Status:   "ok",
+					Duration: project.FormatDuration(result.Duration),
+				}
+				if result.Error != nil {
+					failed++
+					item.Status = "failed"
+					item.Detail = result.Error.Error()
+				}
+				if keep {
+					item.Detail = strings.TrimSpace(result.Dir + " " + item.Detail)
+				}
+				rows = append(rows, item)
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Scenario", "Status", "Duration", "Detail"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+			if failed > 0 {
+				fatal(fmt.Errorf("%d of %d scenarios failed", failed, len(results)))
+			}
+		},
+	}
+
+	cmd.Flags().Bool("keep", false, "Keep the fixture projects")
+	cmd.Flags().BoolP("verbose", "v", false, "Show the output of rules")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewSelftestCommand())
+}
@@ -0,0 +1,236 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/websocket"
+)
+
+// serveRequest is sent by a client to start a run
+type serveRequest struct {
+	Rules      []string `json:"rules"`
+	Components []string `json:"components"`
+	Kinds      []string `json:"kinds"`
+}
+
+// countRules returns the number of rules a run is expected to execute,
+// including dependencies
+func countRules(opts zimOptions) (int, error) {
+	proj, err := getProject(opts.Directory)
+	if err != nil {
+		return 0, err
+	}
+	components, err := selectComponents(proj, opts)
+	if err != nil {
+		return 0, err
+	}
+	if opts.Until != "" {
+		if components, opts.Rules, err = untilTarget(proj, opts.Until); err != nil {
+			return 0, err
+		}
+	}
+	var total int
+	for _, rule := range opts.Rules {
+		total += project.GraphFromRules(components.Rules([]string{rule})).Count()
+	}
+	return total, nil
+}
+
+// progressServer runs rules requested over websockets one at a time and
+// streams progress events back to the client
+type progressServer struct {
+	opts    zimOptions
+	running sync.Mutex
+}
+
+func (s *progressServer) handle(ws *websocket.Conn) {
+	defer ws.Close()
+
+	var sendMutex sync.Mutex
+	closed := false
+	send := func(event project.ProgressEvent) {
+		sendMutex.Lock()
+		defer sendMutex.Unlock()
+		if !closed {
+			websocket.JSON.Send(ws, event)
+		}
+	}
+	defer func() {
+		sendMutex.Lock()
+		closed = true
+		sendMutex.Unlock()
+	}()
+
+	var req serveRequest
+	if err := websocket.JSON.Receive(ws, &req); err != nil {
+		send(project.ProgressEvent{Type: project.RunFinished, Error: err.Error()})
+		return
+	}
+	opts := s.opts
+	opts.Rules = req.Rules
+	opts.Components = req.Components
+	opts.Kinds = req.Kinds
+
+	// Runs share the run history and service state so they can't overlap
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	runID := project.UUID()
+	total, err := countRules(opts)
+	if err != nil {
+		send(project.ProgressEvent{RunID: runID, Type: project.RunFinished, Error: err.Error()})
+		return
+	}
+	var history *project.History
+	if proj, err := getProject(opts.Directory); err == nil {
+		history, _ = project.LoadHistory(proj.HistoryPath())
+	}
+	progress := project.NewProgress(project.ProgressOpts{
+		RunID:   runID,
+		Total:   total,
+		History: history,
+		Emit:    send,
+	})
+
+	// Cancel the run if the client disconnects
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var ignored interface{}
+		for websocket.JSON.Receive(ws, &ignored) == nil {
+		}
+		cancel()
+	}()
+
+	// Progress events carry the ID of the build so that they can be matched
+	// with its record and the cache items it writes
+	opts.BuildID = runID
+	progress.Start()
+	err = runRules(ctx, cancel, opts, progress.Middleware)
+	progress.Finish(err)
+}
+
+// newServeToken returns a random token that clients must present
+func newServeToken() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+// isLocalHost returns true if the host name refers to this machine
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// serveHandshake accepts websocket connections that present the token, in
+// the token query parameter or as a bearer token. Browsers send the Origin of
+// the page making the connection, which must be on this machine, so that
+// other websites can't run rules. Editors and other clients send no Origin.
+func serveHandshake(token string) func(*websocket.Config, *http.Request) error {
+	return func(config *websocket.Config, req *http.Request) error {
+		origin, err := websocket.Origin(config, req)
+		if err != nil {
+			return err
+		}
+		if origin != nil && !isLocalHost(origin.Hostname()) {
+			return fmt.Errorf("origin not allowed: %s", origin)
+		}
+		config.Origin = origin
+
+		presented := req.URL.Query().Get("token")
+		if auth := req.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+			presented = strings.TrimPrefix(auth, "Bearer ")
+		}
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+}
+
+// NewServeCommand returns a command that serves a progress API for editors
+func NewServeCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve an API that runs rules and streams their progress",
+		Long: `Serve an API that runs rules and streams their progress.
+
+Clients connect to the /runs websocket and send a JSON request such as
+{"rules": ["test"], "components": ["myservice"]}. Progress events are sent
+as JSON messages as the rules run: run_started, rule_started, log,
+rule_finished, and finally run_finished, after which the connection is
+closed. Closing the connection cancels the run. Runs execute one at a time.
+
+Clients must present the token printed at startup, either in the token query
+parameter, e.g. /runs?token=TOKEN, or as a bearer token. A token may be chosen
+with --token instead. Connections from web pages are only accepted from pages
+served by this machine.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			addr, _ := cmd.Flags().GetString("addr")
+			token, _ := cmd.Flags().GetString("token")
+			if token == "" {
+				if token, err = newServeToken(); err != nil {
+					fatal(err)
+				}
+			}
+
+			server := &progressServer{opts: opts}
+			mux := http.NewServeMux()
+			mux.Handle("/runs", websocket.Server{
+				Handler:   server.handle,
+				Handshake: serveHandshake(token),
+			})
+
+			fmt.Println("Listening on", project.Bright(addr))
+			fmt.Println("Token:", token)
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().String("addr", "127.0.0.1:7337", "Address to listen on")
+	cmd.Flags().String("token", "", "Token clients must present (default random)")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewServeCommand())
+}
@@ -18,17 +18,112 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"os/user"
 	"strings"
 
 	"github.com/fugue/zim/cache"
 	"github.com/fugue/zim/exec"
-	"github.com/fugue/zim/hash"
+	"github.com/fugue/zim/format"
 	"github.com/fugue/zim/project"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+// loadRule loads the project and returns the named rule, which may be given
+// as "component.rule" or as a rule name with the component selected
+// separately
+func loadRule(opts zimOptions, ruleName string) (*project.Rule, error) {
+
+	if rootDir, err := repositoryRoot(opts.Directory); err == nil {
+		opts.Directory = rootDir
+	}
+	if strings.Contains(ruleName, ".") {
+		parts := strings.SplitN(ruleName, ".", 2)
+		ruleName = parts[1]
+		opts.Components = []string{parts[0]}
+	}
+	if len(opts.Components) != 1 {
+		return nil, errors.New("Must specify exactly one component name with -c")
+	}
+	componentName := opts.Components[0]
+
+	var executor exec.Executor
+	if opts.UseDocker {
+		executor = exec.NewDockerExecutor(opts.Directory, opts.Platform)
+	} else {
+		executor = exec.NewBashExecutor()
+	}
+
+	projDef, componentDefs, err := project.Discover(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load selected components from the project
+	proj, err := project.NewWithOptions(project.Opts{
+		Root:          opts.Directory,
+		ProjectDef:    projDef,
+		ComponentDefs: componentDefs,
+		Executor:      executor,
+		Providers:     projectProviders(opts),
+		Platform:      opts.Platform,
+		Parameters:    opts.Parameters,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c := proj.Components().WithName(componentName).First()
+	if c == nil {
+		return nil, fmt.Errorf("Unknown component: %s", componentName)
+	}
+	r, found := c.Rule(ruleName)
+	if !found {
+		return nil, fmt.Errorf("Unknown rule: %s.%s", componentName, ruleName)
+	}
+	return r, nil
+}
+
+// ruleKey computes the cache key of the named rule, which may be given as
+// "component.rule" or as a rule name with the component selected separately
+func ruleKey(opts zimOptions, ruleName string) (*cache.Key, error) {
+
+	if rootDir, err := repositoryRoot(opts.Directory); err == nil {
+		opts.Directory = rootDir
+	}
+	r, err := loadRule(opts, ruleName)
+	if err != nil {
+		return nil, err
+	}
+	self, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	hasher, err := projectHasher(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	inputHasher, err := projectInputHasher(opts.Directory, hasher)
+	if err != nil {
+		return nil, err
+	}
+
+	zimCache := cache.New(cache.Opts{
+		Store:       nil,
+		Hasher:      hasher,
+		InputHasher: inputHasher,
+		User:        self.Name,
+	})
+	return zimCache.Key(context.Background(), r)
+}
+
+// keyView shows the composition of a cache key along with the key itself
+type keyView struct {
+	Hex string `json:"key"`
+	*cache.Key
+}
+
 // NewShowKeyCommand returns a command that shows a cache key for a Rule
 func NewShowKeyCommand() *cobra.Command {
 
@@ -37,92 +132,198 @@ func NewShowKeyCommand() *cobra.Command {
 		Short: "Show a rule cache key",
 		Run: func(cmd *cobra.Command, args []string) {
 
-			var ruleName, componentName string
 			opts, err := getZimOptions(cmd, args)
 			if err != nil {
 				fatal(err)
 			}
-
-			if gitDir, err := gitRoot(opts.Directory); err == nil {
-				opts.Directory = gitDir
-			}
-
 			if len(opts.Rules) != 1 {
 				fatal(errors.New("Must specify exactly one rule name with -r"))
 			}
-
-			ruleName = opts.Rules[0]
-			if strings.Contains(ruleName, ".") {
-				parts := strings.SplitN(ruleName, ".", 2)
-				ruleName = parts[1]
-				opts.Components = []string{parts[0]}
+			key, err := ruleKey(opts, opts.Rules[0])
+			if err != nil {
+				fatal(err)
 			}
 
-			if len(opts.Components) != 1 {
-				fatal(errors.New("Must specify exactly one component name with -c"))
+			if viper.GetBool("detail") {
+				js, err := json.Marshal(key)
+				if err != nil {
+					fatal(err)
+				}
+				fmt.Println(string(js))
+			} else {
+				fmt.Println(key.String())
 			}
-			componentName = opts.Components[0]
+		},
+	}
+
+	cmd.Flags().Bool("detail", false, "Show key details")
+	viper.BindPFlag("detail", cmd.Flags().Lookup("detail"))
+
+	cmd.AddCommand(NewKeyShowCommand())
+	cmd.AddCommand(NewKeyDiffCommand())
+	return cmd
+}
+
+// NewKeyShowCommand returns a command that shows everything that feeds into
+// a rule cache key as JSON
+func NewKeyShowCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "show <component>.<rule>",
+		Short: "Show the composition of a rule cache key as JSON",
+		Long: `Show the composition of a rule cache key as JSON. This includes the
+hash of each input file, environment variables, toolchain information, the keys
+of dependencies, and the commands run by the rule.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
 
-			var executor exec.Executor
-			if opts.UseDocker {
-				executor = exec.NewDockerExecutor(opts.Directory, opts.Platform)
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			var ruleName string
+			if len(args) == 1 {
+				ruleName = args[0]
+			} else if len(opts.Rules) == 1 {
+				ruleName = opts.Rules[0]
 			} else {
-				executor = exec.NewBashExecutor()
+				fatal(errors.New("Must specify a rule as <component>.<rule>"))
 			}
-
-			projDef, componentDefs, err := project.Discover(opts.Directory)
+			key, err := ruleKey(opts, ruleName)
 			if err != nil {
 				fatal(err)
 			}
+			// Commands are shown as written rather than HTML-escaped
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(keyView{Hex: key.String(), Key: key}); err != nil {
+				fatal(err)
+			}
+		},
+	}
+	return cmd
+}
 
-			// Load selected components from the project
-			proj, err := project.NewWithOptions(project.Opts{
-				Root:          opts.Directory,
-				ProjectDef:    projDef,
-				ComponentDefs: componentDefs,
-				Executor:      executor,
-			})
+type keyChangeViewItem struct {
+	Item   string
+	Change string
+	Old    string
+	New    string
+}
+
+// previousKey loads the key to compare against, which is either a JSON file
+// or the ID of a recorded build in which the rule ran
+func previousKey(opts zimOptions, against, nodeID string) (*cache.Key, error) {
+
+	if _, err := os.Stat(against); err == nil {
+		data, err := ioutil.ReadFile(against)
+		if err != nil {
+			return nil, err
+		}
+		// Accepts both the cache metadata and the output of zim key show
+		view := keyView{Key: &cache.Key{}}
+		if err := json.Unmarshal(data, &view); err != nil {
+			return nil, fmt.Errorf("Failed to read key %s: %s", against, err)
+		}
+		return view.Key, nil
+	}
+
+	proj, err := getProject(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	builds, err := project.LoadBuilds(proj.BuildsDir())
+	if err != nil {
+		return nil, err
+	}
+	build := project.FindBuild(builds, against)
+	if build == nil {
+		return nil, fmt.Errorf("No key file or build found: %s", against)
+	}
+	keyHex, found := build.Keys[nodeID]
+	if !found {
+		return nil, fmt.Errorf("Build %s has no key for %s", against, nodeID)
+	}
+	zimCache, err := newCache(opts)
+	if err != nil {
+		return nil, err
+	}
+	if zimCache == nil {
+		return nil, errors.New("Cache URL is not set. See the docs!")
+	}
+	key, err := zimCache.LoadKey(context.Background(), keyHex)
+	if err == cache.CacheMiss {
+		return nil, fmt.Errorf("Key %s of build %s is not in the cache", keyHex, against)
+	}
+	return key, err
+}
+
+// NewKeyDiffCommand returns a command that compares a rule cache key against
+// a previously recorded one
+func NewKeyDiffCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "diff <component>.<rule>",
+		Short: "Show why a rule cache key changed",
+		Long: `Compare the current cache key of a rule against a previously recorded key
+and show which inputs, dependencies, environment variables, toolchain entries,
+and commands changed. The previous key is given with --against as either a
+JSON file, such as the .json metadata stored in the cache alongside outputs or
+the output of zim key show, or the ID of a build recorded by zim run.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
 			if err != nil {
 				fatal(err)
 			}
-			c := proj.Components().WithName(componentName).First()
-			if c == nil {
-				fatal(fmt.Errorf("Unknown component: %s", componentName))
+			var ruleName string
+			if len(args) == 1 {
+				ruleName = args[0]
+			} else if len(opts.Rules) == 1 {
+				ruleName = opts.Rules[0]
+			} else {
+				fatal(errors.New("Must specify a rule as <component>.<rule>"))
 			}
-			r, found := c.Rule(ruleName)
-			if !found {
-				fatal(fmt.Errorf("Unknown rule: %s.%s", componentName, ruleName))
+			against, _ := cmd.Flags().GetString("against")
+			if against == "" {
+				fatal(errors.New("Must specify a key file or build ID with --against"))
 			}
-			self, err := user.Current()
+			key, err := ruleKey(opts, ruleName)
 			if err != nil {
 				fatal(err)
 			}
-
-			ctx := context.Background()
-			zimCache := cache.New(cache.Opts{
-				Store:  nil,
-				Hasher: hash.SHA1(),
-				User:   self.Name,
-			})
-			key, err := zimCache.Key(ctx, r)
+			old, err := previousKey(opts, against, fmt.Sprintf("%s.%s", key.Component, key.Rule))
 			if err != nil {
 				fatal(err)
 			}
-
-			if viper.GetBool("detail") {
-				js, err := json.Marshal(key)
-				if err != nil {
-					fatal(err)
-				}
-				fmt.Println(string(js))
-			} else {
-				fmt.Println(key.String())
+			changes := cache.DiffKeys(old, key)
+			if len(changes) == 0 && opts.Format == format.TableFormat {
+				fmt.Println("No differences found")
+				return
+			}
+			var rows []interface{}
+			for _, c := range changes {
+				rows = append(rows, keyChangeViewItem{
+					Item:   c.Item,
+					Change: c.Change,
+					Old:    c.Old,
+					New:    c.New,
+				})
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Item", "Change", "Old", "New"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
 			}
 		},
 	}
 
-	cmd.Flags().Bool("detail", false, "Show key details")
-	viper.BindPFlag("detail", cmd.Flags().Lookup("detail"))
+	cmd.Flags().String("against", "", "Key JSON file or build ID to compare against")
 
 	return cmd
 }
@@ -0,0 +1,165 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/fugue/zim/format"
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+type snapshotViewItem struct {
+	Item   string
+	Change string
+	Old    string
+	New    string
+}
+
+// takeSnapshot returns a Snapshot of the project in the current directory
+func takeSnapshot(opts zimOptions, withKeys bool) (*project.Snapshot, error) {
+	proj, err := loadProject(opts)
+	if err != nil {
+		return nil, err
+	}
+	var keyFunc project.KeyFunc
+	if withKeys {
+		if keyFunc, err = newKeyFunc(opts); err != nil {
+			return nil, err
+		}
+	}
+	snapshot, err := project.NewSnapshot(proj, keyFunc)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.ZimVersion = Version
+	return snapshot, nil
+}
+
+// NewSnapshotCommand returns a command that saves a snapshot of the project
+func NewSnapshotCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save a canonical description of the project",
+		Long: `Print a canonical JSON description of the resolved project: its components
+and their rules, with each rule's key, image, commands, inputs, outputs, and
+dependencies. Compare a snapshot against the project, or another snapshot,
+with zim snapshot diff to see how upgrading zim or changing component
+definitions affects the build.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			withKeys, _ := cmd.Flags().GetBool("keys")
+			snapshot, err := takeSnapshot(opts, withKeys)
+			if err != nil {
+				fatal(err)
+			}
+			data, err := json.MarshalIndent(snapshot, "", "  ")
+			if err != nil {
+				fatal(err)
+			}
+			out, _ := cmd.Flags().GetString("out")
+			if out == "" {
+				fmt.Println(string(data))
+				return
+			}
+			if err := ioutil.WriteFile(out, append(data, '\n'), 0644); err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().Bool("keys", true, "Include rule keys, which requires hashing inputs")
+	cmd.Flags().String("out", "", "Path of the file to write instead of stdout")
+	cmd.AddCommand(NewSnapshotDiffCommand())
+
+	return cmd
+}
+
+// NewSnapshotDiffCommand returns a command that compares snapshots
+func NewSnapshotDiffCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "diff OLD [NEW]",
+		Short: "Show differences between snapshots",
+		Long: `Show the differences between a saved snapshot and the current project, or
+between two saved snapshots. Components and rules that were added or removed
+are listed, along with changes to the rules found in both. Keys are only
+compared if both snapshots include them. Exits with an error if there are
+any differences.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+
+			opts, err := getZimOptions(cmd, nil)
+			if err != nil {
+				fatal(err)
+			}
+			old, err := project.LoadSnapshot(args[0])
+			if err != nil {
+				fatal(err)
+			}
+			var current *project.Snapshot
+			if len(args) > 1 {
+				current, err = project.LoadSnapshot(args[1])
+			} else {
+				withKeys, _ := cmd.Flags().GetBool("keys")
+				current, err = takeSnapshot(opts, withKeys)
+			}
+			if err != nil {
+				fatal(err)
+			}
+			changes := project.DiffSnapshots(old, current)
+			if len(changes) == 0 && opts.Format == format.TableFormat {
+				fmt.Println("No differences found")
+				return
+			}
+			var rows []interface{}
+			for _, c := range changes {
+				rows = append(rows, snapshotViewItem{
+					Item:   c.Item,
+					Change: c.Change,
+					Old:    c.Old,
+					New:    c.New,
+				})
+			}
+			err = printRows(opts, format.TableOpts{
+				Rows:       rows,
+				Columns:    []string{"Item", "Change", "Old", "New"},
+				ShowHeader: true,
+			})
+			if err != nil {
+				fatal(err)
+			}
+			if len(changes) > 0 {
+				fatal(fmt.Errorf("%d differences found", len(changes)))
+			}
+		},
+	}
+
+	cmd.Flags().Bool("keys", true, "Compare rule keys, which requires hashing inputs")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewSnapshotCommand())
+}
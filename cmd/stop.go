@@ -0,0 +1,75 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+// NewStopCommand returns a command that stops running services
+func NewStopCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "stop [rule...]",
+		Short: "Stop running services",
+		Long: `Stop running services, or all services if none are named.
+
+Services are stopped by interrupting the zim process that started them, which
+also stops any other services started by that process. On Windows, the zim
+process and all processes it started are terminated instead.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			states, err := readServiceStates(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			matches := func(state *project.ServiceState) bool {
+				if len(args) == 0 {
+					return true
+				}
+				for _, arg := range args {
+					if state.Rule == arg || strings.HasSuffix(state.Rule, "."+arg) {
+						return true
+					}
+				}
+				return false
+			}
+
+			stopped := map[int]bool{}
+			for _, state := range states {
+				if !matches(state) || stopped[state.PID] {
+					continue
+				}
+				if err := project.StopProcess(state.PID); err != nil {
+					fatal(fmt.Errorf("failed to stop %s: %s", state.Rule, err))
+				}
+				stopped[state.PID] = true
+				fmt.Println("Stopped", project.Bright(state.Rule),
+					fmt.Sprintf("(pid %d)", state.PID))
+			}
+			if len(stopped) == 0 {
+				fmt.Println("No matching services running")
+			}
+		},
+	}
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewStopCommand())
+}
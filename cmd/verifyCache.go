@@ -0,0 +1,125 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/format"
+	"github.com/spf13/cobra"
+)
+
+type verifyViewItem struct {
+	Key     string
+	Reason  string
+	Deleted bool
+}
+
+// NewVerifyCacheCommand returns a command that checks cache items for
+// corruption
+func NewVerifyCacheCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "verify-cache",
+		Short: "Check cache entries for corruption",
+		Long: `Check that cache entries are intact by downloading them and comparing their
+size and hash against the metadata recorded when they were stored. Entries
+that are truncated or corrupt are listed, and removed if --delete is given.
+Use --sample to check a random fraction of the entries, e.g. 0.1, rather than
+all of them, and --local to check the local cache instead of a remote one.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			closeHandler(cancel)
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			prefix, _ := cmd.Flags().GetString("prefix")
+			sample, _ := cmd.Flags().GetFloat64("sample")
+			deleteItems, _ := cmd.Flags().GetBool("delete")
+			if local, _ := cmd.Flags().GetBool("local"); local {
+				opts.Offline = true
+			}
+			if sample <= 0 || sample > 1 {
+				fatal(errors.New("--sample must be greater than 0 and at most 1"))
+			}
+
+			zimCache, err := newCache(opts)
+			if err != nil {
+				fatal(err)
+			}
+			if zimCache == nil {
+				fatal(errors.New("Cache URL is not set. See the docs!"))
+			}
+			report, err := zimCache.Verify(ctx, cache.VerifyOpts{
+				Prefix: prefix,
+				Sample: sample,
+				Delete: deleteItems,
+			})
+			if err != nil {
+				fatal(err)
+			}
+
+			if opts.Format == format.JSONFormat {
+				data, err := json.MarshalIndent(report, "", "  ")
+				if err != nil {
+					fatal(err)
+				}
+				fmt.Println(string(data))
+			} else {
+				var rows []interface{}
+				for _, problem := range report.Problems {
+					rows = append(rows, verifyViewItem{
+						Key:     problem.Key,
+						Reason:  problem.Reason,
+						Deleted: problem.Deleted,
+					})
+				}
+				if len(rows) > 0 {
+					err = printRows(opts, format.TableOpts{
+						Rows:       rows,
+						Columns:    []string{"Key", "Reason", "Deleted"},
+						ShowHeader: true,
+					})
+					if err != nil {
+						fatal(err)
+					}
+				}
+				fmt.Printf("Checked %d of %d entries, %d corrupt\n",
+					report.Checked, report.Items, len(report.Problems))
+			}
+			if len(report.Problems) > 0 && !deleteItems {
+				fatal(fmt.Errorf("found %d corrupt cache entries", len(report.Problems)))
+			}
+		},
+	}
+
+	cmd.Flags().String("prefix", "", "Only check entries with keys beginning with this prefix")
+	cmd.Flags().Float64("sample", 1, "Fraction of entries to check, e.g. 0.1")
+	cmd.Flags().Bool("delete", false, "Delete corrupt entries")
+	cmd.Flags().Bool("local", false, "Check the local cache")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewVerifyCacheCommand())
+}
@@ -0,0 +1,140 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/project"
+	"github.com/spf13/cobra"
+)
+
+// loadWatchedRules returns the selected rules, excluding service rules
+// which run until stopped
+func loadWatchedRules(opts zimOptions) ([]*project.Rule, error) {
+	proj, err := getProject(opts.Directory)
+	if err != nil {
+		return nil, err
+	}
+	comps, err := selectComponents(proj, opts)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*project.Rule
+	for _, r := range comps.Rules(opts.Rules) {
+		if r.IsService() {
+			fmt.Fprint(os.Stderr, project.Yellow(
+				fmt.Sprintf("Not watching service rule %s\n", r.NodeID())))
+			continue
+		}
+		rules = append(rules, r)
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("No rules selected to watch")
+	}
+	return rules, nil
+}
+
+// runWatchedRules runs the given rules. Rules are grouped by name so that
+// only the components affected run each rule.
+func runWatchedRules(ctx context.Context, opts zimOptions, rules []*project.Rule) {
+	components := map[string][]string{}
+	for _, r := range rules {
+		components[r.Name()] = append(components[r.Name()], r.Component().Name())
+	}
+	var names []string
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		runOpts := opts
+		runOpts.Rules = []string{name}
+		runOpts.Components = components[name]
+		runOpts.Kinds = nil
+		runOpts.DependentsOf = nil
+		runOpts.DependenciesOf = nil
+
+		// Each run is given its own context since it is canceled once
+		// the run completes
+		runCtx, cancel := context.WithCancel(ctx)
+		err := runRules(runCtx, cancel, runOpts)
+		cancel()
+		if err != nil && ctx.Err() == nil {
+			fmt.Fprintln(os.Stderr, project.Red(err.Error()))
+		}
+	}
+	if ctx.Err() == nil {
+		fmt.Println(project.Yellow(fmt.Sprintf(
+			"Watching for changes to inputs of %s. Press Ctrl+C to stop.",
+			strings.Join(opts.Rules, ", "))))
+	}
+}
+
+// NewWatchCommand returns a command that runs rules when their inputs change
+func NewWatchCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "watch",
+		Short: "Run rules and run them again when their inputs change",
+		Run: func(cmd *cobra.Command, args []string) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			closeHandler(cancel)
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			if len(opts.Rules) == 0 && len(args) > 0 {
+				opts.Rules = args
+			}
+			if len(opts.Rules) == 0 {
+				fatal(errors.New("Must specify one or more rules"))
+			}
+			debounce, err := cmd.Flags().GetDuration("debounce")
+			if err != nil {
+				fatal(err)
+			}
+			err = project.Watch(ctx, project.WatchOpts{
+				Load: func() ([]*project.Rule, error) {
+					return loadWatchedRules(opts)
+				},
+				Run: func(ctx context.Context, rules []*project.Rule) {
+					runWatchedRules(ctx, opts, rules)
+				},
+				Debounce: debounce,
+			})
+			if err != nil {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().Duration("debounce", project.DefaultDebounce,
+		"Time to wait for file changes to settle before running")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewWatchCommand())
+}
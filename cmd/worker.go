@@ -0,0 +1,219 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/distributed"
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/secrets"
+	"github.com/fugue/zim/vcs"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// newCoordinator returns the runner of a distributed build. The project's
+// source is archived in the store shared with the workers, which is also
+// the store of the cache.
+func newCoordinator(
+	ctx context.Context,
+	proj *project.Project,
+	projDef *definitions.Project,
+	zimCache *cache.Cache,
+	opts zimOptions,
+) (*distributed.Coordinator, error) {
+	queueURL := viper.GetString("queue")
+	if queueURL == "" {
+		return nil, errors.New("Distributed builds need a queue. Set --queue.")
+	}
+	if zimCache == nil || opts.Offline {
+		return nil, errors.New("Distributed builds need a cache shared with the workers")
+	}
+	s, err := newStore(opts)
+	if err != nil {
+		return nil, err
+	}
+	var filter distributed.SourceFilter
+	if projDef != nil {
+		filter.Include = projDef.Distributed.Include
+		filter.Exclude = projDef.Distributed.Exclude
+	}
+	archiveKey, err := distributed.ArchiveSource(ctx, proj.VCS(), s, filter)
+	if err != nil {
+		return nil, err
+	}
+	commitID, _ := proj.CommitID()
+	return distributed.NewCoordinator(distributed.CoordinatorOpts{
+		Queue: distributed.NewSQS(queueURL, opts.Region),
+		Store: s,
+		Cache: zimCache,
+		Job: distributed.Job{
+			ArchiveKey: archiveKey,
+			CommitID:   commitID,
+			Platform:   opts.Platform,
+			Parameters: opts.Parameters,
+			Debug:      opts.Debug,
+		},
+	}), nil
+}
+
+// runJob runs the Rule of a Job in the extracted source of its build, with
+// the worker's cache and executor settings and the build's parameters. The
+// coordinator sends each dependency of the Rule as a Job of its own before
+// the Rule, so their outputs are restored from the cache rather than run.
+func runJob(opts zimOptions) distributed.RunFunc {
+	return func(ctx context.Context, dir string, job distributed.Job, output io.Writer) (project.Code, error) {
+
+		opts.Directory = dir
+		opts.Platform = job.Platform
+		opts.Parameters = job.Parameters
+		opts.Debug = opts.Debug || job.Debug
+
+		executor, err := newExecutor(opts)
+		if err != nil {
+			return project.Error, err
+		}
+		projDef, componentDefs, err := project.Discover(dir)
+		if err != nil {
+			return project.Error, err
+		}
+		proj, err := project.NewWithOptions(project.Opts{
+			Root:          dir,
+			ProjectDef:    projDef,
+			ComponentDefs: componentDefs,
+			Executor:      executor,
+			Providers:     projectProviders(opts),
+			Platform:      opts.Platform,
+			Parameters:    opts.Parameters,
+			VCS:           vcs.Extracted(dir, job.CommitID),
+		})
+		if err != nil {
+			return project.Error, err
+		}
+		c := proj.Components().WithName(job.Component).First()
+		if c == nil {
+			return project.Error, fmt.Errorf("Component not found: %s", job.Component)
+		}
+		target, found := c.Rule(job.Rule)
+		if !found {
+			return project.Error, fmt.Errorf("Rule not found: %s", job.NodeID())
+		}
+		zimCache, err := newCache(opts)
+		if err != nil {
+			return project.Error, err
+		}
+		if zimCache == nil {
+			return project.Error, errors.New("the worker has no cache")
+		}
+
+		if err := distributed.RestoreDependencies(ctx, zimCache, target); err != nil {
+			return project.Error, err
+		}
+		builders := []project.RunnerBuilder{cache.NewMiddleware(zimCache)}
+		local, err := localMiddleware(proj, projDef, opts)
+		if err != nil {
+			return project.Error, err
+		}
+		builders = append(builders, local...)
+		runner := project.NewChain(builders...).Then(&project.StandardRunner{
+			Secrets:          secrets.NewAWS(opts.Region),
+			Baselines:        zimCache,
+			ContainerRuntime: containerRuntime(opts),
+		})
+		return runner.Run(ctx, target, project.RunOpts{
+			BuildID:     job.BuildID,
+			Executor:    executor,
+			Output:      output,
+			DebugOutput: output,
+			Debug:       opts.Debug,
+		})
+	}
+}
+
+// NewWorkerCommand returns a command that runs rules for distributed builds
+func NewWorkerCommand() *cobra.Command {
+
+	cmd := &cobra.Command{
+		Use:   "worker",
+		Short: "Run rules for distributed builds",
+		Long: `Poll an SQS queue for the rules of builds run with zim run --distributed and
+run them. The project's source is extracted from the archive the build put in
+the cache store. The outputs of a rule's dependencies are restored from the
+cache before it runs, and its own outputs are stored in the cache, from which
+the build restores them. Workers must use the same cache as the build.`,
+		Run: func(cmd *cobra.Command, args []string) {
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			closeHandler(cancel)
+
+			opts, err := getZimOptions(cmd, args)
+			if err != nil {
+				fatal(err)
+			}
+			queueURL, _ := cmd.Flags().GetString("queue")
+			if queueURL == "" {
+				queueURL = viper.GetString("queue")
+			}
+			if queueURL == "" {
+				fatal(errors.New("A queue must be given with --queue"))
+			}
+			s, err := newStore(opts)
+			if err != nil {
+				fatal(err)
+			}
+			if s == nil {
+				fatal(errors.New("Workers need the cache used by distributed builds. See the docs!"))
+			}
+			dir, _ := cmd.Flags().GetString("work-dir")
+			if dir == "" {
+				dir = filepath.Join(exec.XDGCache(), "zim", "worker")
+			}
+			jobs, _ := cmd.Flags().GetInt("jobs")
+			name, _ := os.Hostname()
+
+			worker := distributed.NewWorker(distributed.WorkerOpts{
+				Name:  name,
+				Queue: distributed.NewSQS(queueURL, opts.Region),
+				Store: s,
+				Dir:   dir,
+				Jobs:  jobs,
+				Run:   runJob(opts),
+			})
+			fmt.Println("Waiting for jobs on", queueURL)
+			if err := worker.Start(ctx); err != nil && err != context.Canceled {
+				fatal(err)
+			}
+		},
+	}
+
+	cmd.Flags().String("queue", "", "URL of the SQS queue to poll for jobs")
+	cmd.Flags().String("work-dir", "", "Directory where the sources of builds are extracted")
+	cmd.Flags().IntP("jobs", "j", 1, "Concurrent jobs")
+
+	return cmd
+}
+
+func init() {
+	rootCmd.AddCommand(NewWorkerCommand())
+}
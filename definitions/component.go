@@ -22,6 +22,29 @@ import (
 // Docker defines Docker configuration for a component
 type Docker struct {
 	Image string `yaml:"image"`
+
+	// CacheVolumes maps names of volumes that persist between runs to the
+	// paths they are mounted at in the container, e.g. a package cache
+	CacheVolumes map[string]string `yaml:"cache_volumes"`
+
+	// Network is the network containers are attached to: host, none, or
+	// the name of a network created with the container runtime
+	Network string `yaml:"network"`
+}
+
+// Nix defines a Nix environment in which a component's commands run. The
+// file is a flake.nix or a shell.nix, and the lock defaults to flake.lock
+// alongside the file.
+type Nix struct {
+	File string `yaml:"file"`
+	Lock string `yaml:"lock"`
+}
+
+// Tools defines a tool version manager, asdf or mise, used to activate the
+// tool versions listed in a .tool-versions file
+type Tools struct {
+	Manager string `yaml:"manager"`
+	File    string `yaml:"file"`
 }
 
 // ECS defines ECS configuration for a component
@@ -45,26 +68,35 @@ type Toolchain struct {
 	Items []ToolchainItem `yaml:"items"`
 }
 
-// Export defines resources exposed by a Component
+// Export defines resources exposed by a Component. If FromRule is set, the
+// export consists of the outputs of the named rule rather than source files.
 type Export struct {
 	Provider  string   `yaml:"provider"`
 	Resources []string `yaml:"resources"`
 	Ignore    []string `yaml:"ignore"`
+	FromRule  string   `yaml:"from_rule"`
 }
 
 // Component defines component configuration in YAML
 type Component struct {
-	Name        string            `yaml:"name"`
-	App         string            `yaml:"app"`
-	Kind        string            `yaml:"kind"`
-	Ignore      bool              `yaml:"ignore"`
-	Docker      Docker            `yaml:"docker"`
-	ECS         ECS               `yaml:"ecs"`
-	Toolchain   Toolchain         `yaml:"toolchain"`
-	Rules       map[string]Rule   `yaml:"rules"`
-	Exports     map[string]Export `yaml:"exports"`
-	Environment map[string]string `yaml:"environment"`
-	Path        string
+	Name         string            `yaml:"name"`
+	App          string            `yaml:"app"`
+	Kind         string            `yaml:"kind"`
+	Tags         []string          `yaml:"tags"`
+	Ignore       bool              `yaml:"ignore"`
+	Docker       Docker            `yaml:"docker"`
+	Nix          Nix               `yaml:"nix"`
+	Tools        Tools             `yaml:"tools"`
+	ECS          ECS               `yaml:"ecs"`
+	Toolchain    Toolchain         `yaml:"toolchain"`
+	Rules        map[string]Rule   `yaml:"rules"`
+	Exports      map[string]Export `yaml:"exports"`
+	Environment  map[string]string `yaml:"environment"`
+	Secrets      map[string]string `yaml:"secrets"`
+	Wrapper      []string          `yaml:"wrapper"`
+	Shell        string            `yaml:"shell"`
+	ShellOptions []string          `yaml:"shell_options"`
+	Path         string
 }
 
 // LoadComponent loads a definition from the given text
@@ -76,6 +108,16 @@ func LoadComponent(text []byte) (*Component, error) {
 	return def, nil
 }
 
+// LoadComponents loads a list of definitions from the given text, which may
+// be YAML or JSON
+func LoadComponents(text []byte) ([]*Component, error) {
+	var defs []*Component
+	if err := yaml.Unmarshal(text, &defs); err != nil {
+		return nil, err
+	}
+	return defs, nil
+}
+
 // LoadComponentFromPath loads a definition from the specified file
 func LoadComponentFromPath(path string) (*Component, error) {
 	data, err := ioutil.ReadFile(path)
@@ -94,17 +136,24 @@ func LoadComponentFromPath(path string) (*Component, error) {
 // unmodified and a new Component definition is returned.
 func (c *Component) Merge(other *Component) *Component {
 	r := &Component{
-		Path:        other.Path,
-		Name:        mergeStr(c.Name, other.Name),
-		App:         mergeStr(c.App, other.App),
-		Kind:        mergeStr(c.Kind, other.Kind),
-		Ignore:      mergeBool(c.Ignore, other.Ignore),
-		Docker:      mergeDocker(c.Docker, other.Docker),
-		ECS:         mergeECS(c.ECS, other.ECS),
-		Toolchain:   mergeToolchain(c.Toolchain, other.Toolchain),
-		Rules:       mergeRules(c.Rules, other.Rules),
-		Exports:     mergeExports(c.Exports, other.Exports),
-		Environment: mergeStringsMap(c.Environment, other.Environment),
+		Path:         other.Path,
+		Name:         mergeStr(c.Name, other.Name),
+		App:          mergeStr(c.App, other.App),
+		Kind:         mergeStr(c.Kind, other.Kind),
+		Tags:         mergeStrings(c.Tags, other.Tags),
+		Ignore:       mergeBool(c.Ignore, other.Ignore),
+		Docker:       mergeDocker(c.Docker, other.Docker),
+		Nix:          mergeNix(c.Nix, other.Nix),
+		Tools:        mergeTools(c.Tools, other.Tools),
+		ECS:          mergeECS(c.ECS, other.ECS),
+		Toolchain:    mergeToolchain(c.Toolchain, other.Toolchain),
+		Rules:        mergeRules(c.Rules, other.Rules),
+		Exports:      mergeExports(c.Exports, other.Exports),
+		Environment:  mergeStringsMap(c.Environment, other.Environment),
+		Secrets:      mergeStringsMap(c.Secrets, other.Secrets),
+		Wrapper:      mergeStrings(c.Wrapper, other.Wrapper),
+		Shell:        mergeStr(c.Shell, other.Shell),
+		ShellOptions: mergeStrings(c.ShellOptions, other.ShellOptions),
 	}
 	return r
 }
@@ -116,6 +165,7 @@ func copyExports(exports map[string]Export) map[string]Export {
 			Provider:  export.Provider,
 			Resources: copyStrings(export.Resources),
 			Ignore:    copyStrings(export.Ignore),
+			FromRule:  export.FromRule,
 		}
 	}
 	return result
@@ -131,6 +181,7 @@ func mergeExports(a, b map[string]Export) map[string]Export {
 				Provider:  export.Provider,
 				Resources: copyStrings(export.Resources),
 				Ignore:    copyStrings(export.Ignore),
+				FromRule:  export.FromRule,
 			}
 		}
 		// Overriden export
@@ -138,6 +189,7 @@ func mergeExports(a, b map[string]Export) map[string]Export {
 			Provider:  mergeStr(baseExport.Provider, export.Provider),
 			Resources: mergeStrings(baseExport.Resources, export.Resources),
 			Ignore:    mergeStrings(baseExport.Ignore, export.Ignore),
+			FromRule:  mergeStr(baseExport.FromRule, export.FromRule),
 		}
 		result[k] = finalExport
 	}
@@ -145,7 +197,19 @@ func mergeExports(a, b map[string]Export) map[string]Export {
 }
 
 func mergeDocker(a, b Docker) Docker {
-	return Docker{Image: mergeStr(a.Image, b.Image)}
+	return Docker{
+		Image:        mergeStr(a.Image, b.Image),
+		CacheVolumes: mergeStringsMap(a.CacheVolumes, b.CacheVolumes),
+		Network:      mergeStr(a.Network, b.Network),
+	}
+}
+
+func mergeNix(a, b Nix) Nix {
+	return Nix{File: mergeStr(a.File, b.File), Lock: mergeStr(a.Lock, b.Lock)}
+}
+
+func mergeTools(a, b Tools) Tools {
+	return Tools{Manager: mergeStr(a.Manager, b.Manager), File: mergeStr(a.File, b.File)}
 }
 
 func mergeECS(a, b ECS) ECS {
@@ -19,12 +19,86 @@ import (
 	"github.com/go-yaml/yaml"
 )
 
+// LargeFiles configures incremental hashing of large input files. Files at
+// least as large as the threshold are hashed in chunks, e.g. "512MB".
+type LargeFiles struct {
+	Threshold string `yaml:"threshold"`
+	ChunkSize string `yaml:"chunk_size"`
+}
+
+// Scanner configures a check of the outputs of rules after they run and
+// before they are cached. The check runs a command, which finds a problem if
+// it fails, or finds output files matching any of the Forbid patterns.
+// Policy is "fail", "warn", or "quarantine" and defaults to "fail".
+type Scanner struct {
+	Name    string   `yaml:"name"`
+	Command string   `yaml:"command"`
+	Forbid  []string `yaml:"forbid"`
+	Policy  string   `yaml:"policy"`
+}
+
+// Strict configures strict mode, in which each rule command fails if it
+// writes to files in the project other than those in its component
+// directory, its declared outputs, and Scratch, a list of glob patterns
+// relative to the project root.
+type Strict struct {
+	Enabled bool     `yaml:"enabled"`
+	Scratch []string `yaml:"scratch"`
+}
+
+// ProjectDocker configures containers used by all components. Cache volumes
+// set for a component are added to these.
+type ProjectDocker struct {
+	CacheVolumes map[string]string `yaml:"cache_volumes"`
+}
+
+// Locks configures the locks that rules with a mutex acquire so that builds
+// on different machines don't run them at the same time. Locks are items in
+// a DynamoDB table whose partition key is the string "name". A lock that
+// isn't renewed within its TTL, e.g. "5m", may be taken over.
+type Locks struct {
+	DynamoDBTable string `yaml:"dynamodb_table"`
+	Region        string `yaml:"region"`
+	TTL           string `yaml:"ttl"`
+}
+
+// Distributed configures distributed builds. The archive of the project's
+// source sent to workers holds only the files matching the Include glob
+// patterns, if there are any, and none of those matching the Exclude
+// patterns. Patterns are relative to the project root, and a pattern that
+// matches a directory matches everything within it.
+type Distributed struct {
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+}
+
 // Project defines project configuration in YAML
 type Project struct {
-	Name        string                            `yaml:"name"`
-	Environment map[string]string                 `yaml:"environment"`
-	Components  []string                          `yaml:"components"`
-	Providers   map[string]map[string]interface{} `yaml:"providers"`
+	Name             string                            `yaml:"name"`
+	Environment      map[string]string                 `yaml:"environment"`
+	Components       []string                          `yaml:"components"`
+	Providers        map[string]map[string]interface{} `yaml:"providers"`
+	CacheBackend     string                            `yaml:"cache_backend"`
+	CacheLayout      string                            `yaml:"cache_layout"`
+	CacheCompression string                            `yaml:"cache_compression"`
+	Hash             string                            `yaml:"hash"`
+	LargeFiles       LargeFiles                        `yaml:"large_files"`
+	Scanners         []Scanner                         `yaml:"scanners"`
+	Strict           Strict                            `yaml:"strict"`
+	Docker           ProjectDocker                     `yaml:"docker"`
+	Locks            Locks                             `yaml:"locks"`
+	Distributed      Distributed                       `yaml:"distributed"`
+
+	// Shell and ShellOptions are the defaults for components that don't
+	// set their own, e.g. sh for projects built in images without bash
+	Shell        string   `yaml:"shell"`
+	ShellOptions []string `yaml:"shell_options"`
+
+	// DiscoveryCommand prints a JSON list of generated component
+	// definitions. Its output is reused while the DiscoveryInputs, files
+	// matching glob patterns relative to the project root, are unchanged.
+	DiscoveryCommand string   `yaml:"discovery_command"`
+	DiscoveryInputs  []string `yaml:"discovery_inputs"`
 }
 
 // LoadProject loads a definition from the given text
@@ -17,12 +17,15 @@ import (
 	"fmt"
 )
 
-// Dependency between Rules
+// Dependency between Rules. Outputs optionally names the outputs of the
+// required Rule that are needed, which allows the dependent Rule to start
+// as soon as they exist when pipelined scheduling is enabled.
 type Dependency struct {
-	Component string `yaml:"component"`
-	Rule      string `yaml:"rule"`
-	Export    string `yaml:"export"`
-	Recurse   int    `yaml:"recurse"`
+	Component string   `yaml:"component"`
+	Rule      string   `yaml:"rule"`
+	Export    string   `yaml:"export"`
+	Recurse   int      `yaml:"recurse"`
+	Outputs   []string `yaml:"outputs"`
 }
 
 // Providers specifies the name of the Provider type to be used for the
@@ -49,23 +52,64 @@ type Condition struct {
 	ResourceExists  string          `yaml:"resource_exists"`
 	DirectoryExists string          `yaml:"directory_exists"`
 	ScriptSucceeds  ConditionScript `yaml:"script_succeeds"`
+	ChangedSince    string          `yaml:"changed_since"`
+}
+
+// HealthCheck defines a script used to check whether a service is ready
+type HealthCheck struct {
+	Run      string `yaml:"run"`
+	Interval string `yaml:"interval"`
+	Timeout  string `yaml:"timeout"`
+}
+
+// Retries configures retrying a rule when it fails. On lists the failures
+// that are retried, separated by commas, e.g. "exec_error, timeout".
+type Retries struct {
+	Count int    `yaml:"count"`
+	Delay string `yaml:"delay"`
+	On    string `yaml:"on"`
+}
+
+// RuleDocker overrides the Docker configuration of a rule's component
+type RuleDocker struct {
+	Network string `yaml:"network"`
 }
 
 // Rule defines inputs, commands, and outputs for a build step or action
 type Rule struct {
-	Name        string        `yaml:"name"`
-	Inputs      []string      `yaml:"inputs"`
-	Outputs     []string      `yaml:"outputs"`
-	Ignore      []string      `yaml:"ignore"`
-	Local       bool          `yaml:"local"`
-	Native      bool          `yaml:"native"`
-	Requires    []Dependency  `yaml:"requires"`
-	Description string        `yaml:"description"`
-	Command     string        `yaml:"command"`
-	Commands    []interface{} `yaml:"commands"`
-	Providers   Providers     `yaml:"providers"`
-	When        Condition     `yaml:"when"`
-	Unless      Condition     `yaml:"unless"`
+	Name              string              `yaml:"name"`
+	Inputs            []string            `yaml:"inputs"`
+	Outputs           []string            `yaml:"outputs"`
+	Ignore            []string            `yaml:"ignore"`
+	CacheIgnoreInputs []string            `yaml:"cache_ignore_inputs"`
+	Local             bool                `yaml:"local"`
+	Native            bool                `yaml:"native"`
+	Service           bool                `yaml:"service"`
+	Ports             []string            `yaml:"ports"`
+	HealthCheck       HealthCheck         `yaml:"health_check"`
+	Restart           string              `yaml:"restart"`
+	Network           string              `yaml:"network"`
+	Docker            RuleDocker          `yaml:"docker"`
+	Wrapper           []string            `yaml:"wrapper"`
+	Shell             string              `yaml:"shell"`
+	ShellOptions      []string            `yaml:"shell_options"`
+	Ulimits           map[string]string   `yaml:"ulimits"`
+	Timeout           string              `yaml:"timeout"`
+	MaxLogSize        string              `yaml:"max_log_size"`
+	MaxOutputSize     string              `yaml:"max_output_size"`
+	Retries           Retries             `yaml:"retries"`
+	AllowFailure      bool                `yaml:"allow_failure"`
+	Mutex             string              `yaml:"mutex"`
+	Matrix            map[string][]string `yaml:"matrix"`
+	Secrets           map[string]string   `yaml:"secrets"`
+	Environment       map[string]string   `yaml:"environment"`
+	Requires          []Dependency        `yaml:"requires"`
+	Description       string              `yaml:"description"`
+	Command           string              `yaml:"command"`
+	Commands          []interface{}       `yaml:"commands"`
+	Providers         Providers           `yaml:"providers"`
+	When              Condition           `yaml:"when"`
+	Unless            Condition           `yaml:"unless"`
 }
 
 // GetCommands returns commands unmarshaled from the rule's semi-structured YAML
@@ -132,6 +176,8 @@ func getOneKeyValue(m map[interface{}]interface{}) (interface{}, interface{}) {
 	panic("Expected map to have a key")
 }
 
+// getMapWithStringKeys converts a YAML map to one with string keys. Nested
+// maps are converted too so that the result can be encoded as JSON.
 func getMapWithStringKeys(m map[interface{}]interface{}) (map[string]interface{}, error) {
 	result := map[string]interface{}{}
 	for k, v := range m {
@@ -139,6 +185,13 @@ func getMapWithStringKeys(m map[interface{}]interface{}) (map[string]interface{}
 		if !ok {
 			return nil, fmt.Errorf("Expected string key in map; got: %+v", k)
 		}
+		if nested, ok := v.(map[interface{}]interface{}); ok {
+			converted, err := getMapWithStringKeys(nested)
+			if err != nil {
+				return nil, err
+			}
+			v = converted
+		}
 		result[keyStr] = v
 	}
 	return result, nil
@@ -147,17 +200,41 @@ func getMapWithStringKeys(m map[interface{}]interface{}) (map[string]interface{}
 func mergeRule(a, b Rule) Rule {
 
 	result := Rule{
-		Inputs:      mergeStrings(a.Inputs, b.Inputs),
-		Outputs:     mergeStrings(a.Outputs, b.Outputs),
-		Ignore:      mergeStrings(a.Ignore, b.Ignore),
-		Local:       mergeBool(a.Local, b.Local),
-		Native:      mergeBool(a.Native, b.Native),
-		Requires:    mergeDependencies(a.Requires, b.Requires),
-		Description: mergeStr(a.Description, b.Description),
+		Inputs:            mergeStrings(a.Inputs, b.Inputs),
+		Outputs:           mergeStrings(a.Outputs, b.Outputs),
+		Ignore:            mergeStrings(a.Ignore, b.Ignore),
+		CacheIgnoreInputs: mergeStrings(a.CacheIgnoreInputs, b.CacheIgnoreInputs),
+		Local:             mergeBool(a.Local, b.Local),
+		Native:            mergeBool(a.Native, b.Native),
+		Service:           mergeBool(a.Service, b.Service),
+		Ports:             mergeStrings(a.Ports, b.Ports),
+		Restart:           mergeStr(a.Restart, b.Restart),
+		Network:           mergeStr(a.Network, b.Network),
+		Docker:            RuleDocker{Network: mergeStr(a.Docker.Network, b.Docker.Network)},
+		Wrapper:           mergeStrings(a.Wrapper, b.Wrapper),
+		Shell:             mergeStr(a.Shell, b.Shell),
+		ShellOptions:      mergeStrings(a.ShellOptions, b.ShellOptions),
+		Ulimits:           mergeStringsMap(a.Ulimits, b.Ulimits),
+		Timeout:           mergeStr(a.Timeout, b.Timeout),
+		MaxLogSize:        mergeStr(a.MaxLogSize, b.MaxLogSize),
+		MaxOutputSize:     mergeStr(a.MaxOutputSize, b.MaxOutputSize),
+		Retries:           mergeRetries(a.Retries, b.Retries),
+		AllowFailure:      mergeBool(a.AllowFailure, b.AllowFailure),
+		Mutex:             mergeStr(a.Mutex, b.Mutex),
+		Matrix:            mergeMatrix(a.Matrix, b.Matrix),
+		Secrets:           mergeStringsMap(a.Secrets, b.Secrets),
+		Environment:       mergeStringsMap(a.Environment, b.Environment),
+		Requires:          mergeDependencies(a.Requires, b.Requires),
+		Description:       mergeStr(a.Description, b.Description),
 		Providers: Providers{
 			Inputs:  mergeStr(a.Providers.Inputs, b.Providers.Inputs),
 			Outputs: mergeStr(a.Providers.Outputs, b.Providers.Outputs),
 		},
+		HealthCheck: HealthCheck{
+			Run:      mergeStr(a.HealthCheck.Run, b.HealthCheck.Run),
+			Interval: mergeStr(a.HealthCheck.Interval, b.HealthCheck.Interval),
+			Timeout:  mergeStr(a.HealthCheck.Timeout, b.HealthCheck.Timeout),
+		},
 		When:   mergeConditions(a.When, b.When),
 		Unless: mergeConditions(a.Unless, b.Unless),
 	}
@@ -184,6 +261,21 @@ func mergeRule(a, b Rule) Rule {
 	return result
 }
 
+func mergeRetries(a, b Retries) Retries {
+	return Retries{
+		Count: mergeInt(a.Count, b.Count),
+		Delay: mergeStr(a.Delay, b.Delay),
+		On:    mergeStr(a.On, b.On),
+	}
+}
+
+func mergeMatrix(a, b map[string][]string) map[string][]string {
+	if len(b) > 0 {
+		return b
+	}
+	return a
+}
+
 func mergeRules(a, b map[string]Rule) map[string]Rule {
 
 	names := map[string]bool{}
@@ -233,6 +325,7 @@ func mergeConditions(a, b Condition) (result Condition) {
 	result.ResourceExists = a.ResourceExists
 	result.DirectoryExists = a.DirectoryExists
 	result.ScriptSucceeds = a.ScriptSucceeds
+	result.ChangedSince = a.ChangedSince
 	if b.ResourceExists != "" {
 		result.ResourceExists = b.ResourceExists
 	}
@@ -242,5 +335,8 @@ func mergeConditions(a, b Condition) (result Condition) {
 	if !b.ScriptSucceeds.IsEmpty() {
 		result.ScriptSucceeds = b.ScriptSucceeds
 	}
+	if b.ChangedSince != "" {
+		result.ChangedSince = b.ChangedSince
+	}
 	return
 }
@@ -0,0 +1,138 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+)
+
+// DefaultPollInterval is how often the coordinator checks for the results
+// of the Jobs it sent
+const DefaultPollInterval = 2 * time.Second
+
+// CoordinatorOpts configures a Coordinator
+type CoordinatorOpts struct {
+
+	// Queue that Jobs are sent to
+	Queue Queue
+
+	// Store containing the source archive, in which workers put results
+	Store store.Store
+
+	// Cache shared with the workers. The outputs of Rules run by workers
+	// are restored from it.
+	Cache *cache.Cache
+
+	// Job is the template for the Jobs sent, which gives the source archive
+	// and the settings of the build
+	Job Job
+
+	// PollInterval defaults to DefaultPollInterval
+	PollInterval time.Duration
+}
+
+// Coordinator is a Runner that has workers run Rules. Rules with outputs in
+// the cache are restored from it instead. Otherwise a Job is sent to the
+// queue and the Coordinator waits for a worker to put its result in the
+// store, after which the Rule's outputs are restored from the cache, where
+// the worker stored them. This way the scheduler decides which Rules are
+// ready, as it does for a local build, and Rules that depend on them find
+// their outputs locally.
+type Coordinator struct {
+	opts CoordinatorOpts
+}
+
+// NewCoordinator returns a Coordinator
+func NewCoordinator(opts CoordinatorOpts) *Coordinator {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	return &Coordinator{opts: opts}
+}
+
+// cacheable returns true if the Rule's outputs can be stored in the cache
+func cacheable(r *project.Rule) bool {
+	outputs := r.Outputs()
+	return len(outputs) > 0 && outputs[0].Cacheable()
+}
+
+// Run a Rule on a worker
+func (c *Coordinator) Run(ctx context.Context, r *project.Rule, opts project.RunOpts) (project.Code, error) {
+
+	if cacheable(r) {
+		if _, err := c.opts.Cache.Read(ctx, r); err == nil {
+			return project.Cached, nil
+		} else if err != cache.CacheMiss {
+			return project.Error, err
+		}
+	}
+
+	job := c.opts.Job
+	job.ID = project.UUID()
+	job.BuildID = opts.BuildID
+	job.Component = r.Component().Name()
+	job.Rule = r.Name()
+	job.Debug = job.Debug || opts.Debug
+	if err := c.opts.Queue.Send(ctx, job); err != nil {
+		return project.Error, fmt.Errorf("failed to send job for rule %s: %s", r.NodeID(), err)
+	}
+
+	result, err := c.wait(ctx, job.ID)
+	if err != nil {
+		return project.Error, err
+	}
+	if opts.Output != nil && result.Output != "" {
+		io.WriteString(opts.Output, result.Output)
+	}
+	code := result.code()
+	if code == project.OK && cacheable(r) {
+		if _, err := c.opts.Cache.Read(ctx, r); err != nil {
+			return project.Error, fmt.Errorf(
+				"outputs of rule %s run by worker %s are not in the cache: %s",
+				r.NodeID(), result.Worker, err)
+		}
+	}
+	return code, result.err()
+}
+
+// wait polls the store until the result of the Job is there. The result is
+// then deleted, if the store supports it.
+func (c *Coordinator) wait(ctx context.Context, jobID string) (*Result, error) {
+	ticker := time.NewTicker(c.opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		result, err := getResult(ctx, c.opts.Store, jobID)
+		if err != nil {
+			return nil, err
+		}
+		if result != nil {
+			if deleter, ok := c.opts.Store.(store.ListDeleter); ok {
+				deleter.Delete(ctx, resultKey(jobID))
+			}
+			return result, nil
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
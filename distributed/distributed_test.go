@@ -0,0 +1,419 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/sched"
+	"github.com/fugue/zim/store/memory"
+	"github.com/fugue/zim/vcs"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeQueue is an in-memory Queue
+type fakeQueue struct {
+	jobs    chan Job
+	mutex   sync.Mutex
+	deleted []string
+}
+
+func newFakeQueue() *fakeQueue {
+	return &fakeQueue{jobs: make(chan Job, 100)}
+}
+
+func (q *fakeQueue) Send(ctx context.Context, job Job) error {
+	q.jobs <- job
+	return nil
+}
+
+func (q *fakeQueue) Receive(ctx context.Context, wait, visibility time.Duration) ([]Delivery, error) {
+	select {
+	case job := <-q.jobs:
+		return []Delivery{{Job: job, Handle: job.ID}}, nil
+	case <-time.After(10 * time.Millisecond):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *fakeQueue) Extend(ctx context.Context, d Delivery, visibility time.Duration) error {
+	return nil
+}
+
+func (q *fakeQueue) Delete(ctx context.Context, d Delivery) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.deleted = append(q.deleted, d.Handle)
+	return nil
+}
+
+func writeTestFile(t *testing.T, name, content string) {
+	require.Nil(t, os.MkdirAll(path.Dir(name), 0755))
+	require.Nil(t, ioutil.WriteFile(name, []byte(content), 0644))
+}
+
+func TestSource(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "zim-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := path.Join(dir, "src")
+	writeTestFile(t, path.Join(src, "widget", "main.go"), "package main")
+	writeTestFile(t, path.Join(src, "README.md"), "# Widget")
+
+	s := memory.New()
+	key, err := ArchiveSource(ctx, vcs.None(src), s, SourceFilter{})
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(key, "sources/"))
+
+	// The same source is only stored once
+	again, err := ArchiveSource(ctx, vcs.None(src), s, SourceFilter{})
+	require.Nil(t, err)
+	require.Equal(t, key, again)
+	require.Equal(t, []string{key}, s.Puts())
+
+	dst := path.Join(dir, "dst")
+	require.Nil(t, ExtractSource(ctx, s, key, dst))
+	data, err := ioutil.ReadFile(path.Join(dst, "widget", "main.go"))
+	require.Nil(t, err)
+	require.Equal(t, "package main", string(data))
+	data, err = ioutil.ReadFile(path.Join(dst, "README.md"))
+	require.Nil(t, err)
+	require.Equal(t, "# Widget", string(data))
+
+	// Files may be left out of the archive
+	writeTestFile(t, path.Join(src, "widget", "docs", "guide.md"), "# Guide")
+	writeTestFile(t, path.Join(src, "assets", "logo.png"), "png")
+	filter := SourceFilter{Include: []string{"widget"}, Exclude: []string{"**/docs"}}
+	filtered, err := ArchiveSource(ctx, vcs.None(src), s, filter)
+	require.Nil(t, err)
+	require.NotEqual(t, key, filtered)
+	dst = path.Join(dir, "filtered")
+	require.Nil(t, ExtractSource(ctx, s, filtered, dst))
+	data, err = ioutil.ReadFile(path.Join(dst, "widget", "main.go"))
+	require.Nil(t, err)
+	require.Equal(t, "package main", string(data))
+	for _, name := range []string{"README.md", "assets", path.Join("widget", "docs")} {
+		_, err = os.Stat(path.Join(dst, name))
+		require.True(t, os.IsNotExist(err), name)
+	}
+}
+
+func TestSourceFilter(t *testing.T) {
+	filter := SourceFilter{}
+	require.True(t, filter.Includes("docs/guide.md"))
+
+	filter = SourceFilter{Exclude: []string{"docs", "**/*.psd"}}
+	require.True(t, filter.Includes("widget/main.go"))
+	require.True(t, filter.Includes("./widget/main.go"))
+	require.True(t, filter.Includes("widget/docs/guide.md"))
+	require.False(t, filter.Includes("docs/"))
+	require.False(t, filter.Includes("./docs/guide.md"))
+	require.False(t, filter.Includes("assets/images/logo.psd"))
+
+	filter = SourceFilter{Include: []string{"widget", "project.yaml"}, Exclude: []string{"widget/testdata"}}
+	require.True(t, filter.Includes("project.yaml"))
+	require.True(t, filter.Includes("widget/"))
+	require.True(t, filter.Includes("widget/main.go"))
+	require.False(t, filter.Includes("widget/testdata/big.bin"))
+	require.False(t, filter.Includes("app/main.go"))
+}
+
+func TestWorkerResult(t *testing.T) {
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "zim-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := memory.New()
+	writeTestFile(t, path.Join(dir, "src", "main.go"), "package main")
+	key, err := ArchiveSource(ctx, vcs.None(path.Join(dir, "src")), s, SourceFilter{})
+	require.Nil(t, err)
+
+	var runDirs []string
+	w := NewWorker(WorkerOpts{
+		Name:  "worker-1",
+		Store: s,
+		Dir:   path.Join(dir, "work"),
+		Log:   ioutil.Discard,
+		Run: func(ctx context.Context, dir string, job Job, output io.Writer) (project.Code, error) {
+			runDirs = append(runDirs, dir)
+			if job.Rule == "fail" {
+				io.WriteString(output, "oops\n")
+				return project.Error, errors.New("exit status 1")
+			}
+			io.WriteString(output, strings.Repeat("x", MaxResultOutput+10))
+			return project.OK, nil
+		},
+	})
+
+	s1 := w.newSlot(0)
+	result := w.run(ctx, s1, Job{ID: "1", Component: "widget", Rule: "build", ArchiveKey: key})
+	require.Equal(t, "1", result.JobID)
+	require.Equal(t, "worker-1", result.Worker)
+	require.Equal(t, project.OK, result.code())
+	require.Nil(t, result.err())
+	require.True(t, strings.HasPrefix(result.Output, "...\n"))
+	require.Len(t, result.Output, MaxResultOutput+4)
+
+	result = w.run(ctx, s1, Job{ID: "2", Component: "widget", Rule: "fail", ArchiveKey: key})
+	require.Equal(t, project.Error, result.code())
+	require.Equal(t, "exit status 1", result.Error)
+	require.Equal(t, "oops\n", result.Output)
+
+	// The source is extracted once for both Jobs
+	require.Len(t, runDirs, 2)
+	require.Equal(t, runDirs[0], runDirs[1])
+	data, err := ioutil.ReadFile(path.Join(runDirs[0], "main.go"))
+	require.Nil(t, err)
+	require.Equal(t, "package main", string(data))
+
+	// Jobs that may run at the same time use separate directories
+	result = w.run(ctx, w.newSlot(1), Job{ID: "3", Component: "widget", Rule: "build", ArchiveKey: key})
+	require.Nil(t, result.err())
+	require.Len(t, runDirs, 3)
+	require.NotEqual(t, runDirs[0], runDirs[2])
+	data, err = ioutil.ReadFile(path.Join(runDirs[2], "main.go"))
+	require.Nil(t, err)
+	require.Equal(t, "package main", string(data))
+
+	// Missing sources fail the Job
+	result = w.run(ctx, s1, Job{ID: "4", Component: "widget", Rule: "build", ArchiveKey: "sources/missing.tar.gz"})
+	require.Equal(t, project.Error, result.code())
+	require.Contains(t, result.Error, "failed to get source archive")
+	require.Len(t, runDirs, 3)
+
+	// The source of the slot's previous Job is removed once another is used
+	_, err = os.Stat(runDirs[0])
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(runDirs[2])
+	require.Nil(t, err)
+}
+
+// failingQueue fails to receive Jobs a number of times, or forever if the
+// number is negative
+type failingQueue struct {
+	*fakeQueue
+	failures int
+	received int
+}
+
+func (q *failingQueue) Receive(ctx context.Context, wait, visibility time.Duration) ([]Delivery, error) {
+	q.mutex.Lock()
+	q.received++
+	failing := q.failures < 0 || q.received <= q.failures
+	q.mutex.Unlock()
+	if failing {
+		return nil, errors.New("connection reset by peer")
+	}
+	return q.fakeQueue.Receive(ctx, wait, visibility)
+}
+
+func TestWorkerRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "zim-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := memory.New()
+	writeTestFile(t, path.Join(dir, "src", "main.go"), "package main")
+	key, err := ArchiveSource(ctx, vcs.None(path.Join(dir, "src")), s, SourceFilter{})
+	require.Nil(t, err)
+
+	newWorker := func(queue Queue) *Worker {
+		return NewWorker(WorkerOpts{
+			Queue:      queue,
+			Store:      s,
+			Dir:        path.Join(dir, "work"),
+			Jobs:       2,
+			Retries:    2,
+			RetryDelay: time.Millisecond,
+			Log:        ioutil.Discard,
+			Run: func(ctx context.Context, dir string, job Job, output io.Writer) (project.Code, error) {
+				return project.OK, nil
+			},
+		})
+	}
+
+	// Jobs are received once the queue recovers
+	queue := &failingQueue{fakeQueue: newFakeQueue(), failures: 2}
+	done := make(chan error, 1)
+	go func() { done <- newWorker(queue).Start(ctx) }()
+	require.Nil(t, queue.Send(ctx, Job{ID: "1", ArchiveKey: key}))
+	for i := 0; i < 100; i++ {
+		if result, _ := getResult(ctx, s, "1"); result != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	result, err := getResult(ctx, s, "1")
+	require.Nil(t, err)
+	require.NotNil(t, result)
+	require.Equal(t, project.OK, result.code())
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+
+	// Once the retries run out every slot stops and the error is returned
+	queue = &failingQueue{fakeQueue: newFakeQueue(), failures: -1}
+	err = newWorker(queue).Start(context.Background())
+	require.NotNil(t, err)
+	require.Equal(t, "failed to receive jobs: connection reset by peer", err.Error())
+}
+
+// componentDefs returns the definitions of a project in which app.build
+// uses the output of widget.build
+func componentDefs(root string) []*definitions.Component {
+	return []*definitions.Component{
+		{
+			Path: path.Join(root, "widget", "component.yaml"),
+			Name: "widget",
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Inputs:  []string{"widget.go"},
+					Outputs: []string{"widget.txt"},
+					Command: "cat widget.go > $ARTIFACT",
+				},
+			},
+		},
+		{
+			Path: path.Join(root, "app", "component.yaml"),
+			Name: "app",
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Inputs:   []string{"app.go"},
+					Requires: []definitions.Dependency{{Component: "widget", Rule: "build"}},
+					Outputs:  []string{"app.txt"},
+					Command:  "cat $ARTIFACTS_DIR/widget.txt app.go > $ARTIFACT && echo built app",
+				},
+			},
+		},
+	}
+}
+
+func TestDistributedBuild(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir, err := ioutil.TempDir("", "zim-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	root := path.Join(dir, "src")
+	writeTestFile(t, path.Join(root, "widget", "widget.go"), "widget\n")
+	writeTestFile(t, path.Join(root, "app", "app.go"), "app\n")
+
+	p, err := project.NewWithOptions(project.Opts{Root: root, ComponentDefs: componentDefs(root)})
+	require.Nil(t, err)
+
+	s := memory.New()
+	zimCache := cache.New(cache.Opts{Store: s})
+	queue := newFakeQueue()
+
+	// Workers run only the Rule of each Job in their extracted source, with
+	// the outputs of its dependencies restored from the cache
+	w := NewWorker(WorkerOpts{
+		Name:  "worker-1",
+		Queue: queue,
+		Store: s,
+		Dir:   path.Join(dir, "work"),
+		Jobs:  2,
+		Log:   ioutil.Discard,
+		Run: func(ctx context.Context, dir string, job Job, output io.Writer) (project.Code, error) {
+			p, err := project.NewWithOptions(project.Opts{Root: dir, ComponentDefs: componentDefs(dir)})
+			if err != nil {
+				return project.Error, err
+			}
+			target := p.Components().WithName(job.Component).First().MustRule(job.Rule)
+			if err := RestoreDependencies(ctx, zimCache, target); err != nil {
+				return project.Error, err
+			}
+			runner := cache.NewMiddleware(zimCache)(&project.StandardRunner{})
+			return runner.Run(ctx, target, project.RunOpts{BuildID: job.BuildID, Output: output})
+		},
+	})
+	go w.Start(ctx)
+
+	key, err := ArchiveSource(ctx, p.VCS(), s, SourceFilter{})
+	require.Nil(t, err)
+	coordinator := NewCoordinator(CoordinatorOpts{
+		Queue:        queue,
+		Store:        s,
+		Cache:        zimCache,
+		Job:          Job{ArchiveKey: key},
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	var output bytes.Buffer
+	app := p.Components().WithName("app").First().MustRule("build")
+	runner := project.RunnerFunc(func(ctx context.Context, r *project.Rule, opts project.RunOpts) (project.Code, error) {
+		if r == app {
+			opts.Output = &output
+		}
+		return coordinator.Run(ctx, r, opts)
+	})
+	err = sched.NewGraphScheduler().Run(ctx, sched.Options{
+		BuildID: project.UUID(),
+		Rules:   []*project.Rule{app},
+		Runner:  runner,
+	})
+	require.Nil(t, err)
+
+	// Outputs built by the worker are restored locally
+	data, err := ioutil.ReadFile(app.Outputs()[0].Path())
+	require.Nil(t, err)
+	require.Equal(t, "widget\napp\n", string(data))
+	require.Equal(t, "built app\n", output.String())
+
+	// Results are deleted once they are read
+	items, err := s.List(ctx, "jobs/")
+	require.Nil(t, err)
+	require.Len(t, items, 0)
+	queue.mutex.Lock()
+	require.Len(t, queue.deleted, 2)
+	queue.mutex.Unlock()
+
+	// Nothing is sent to workers when outputs are cached
+	require.Nil(t, os.Remove(app.Outputs()[0].Path()))
+	code, err := coordinator.Run(ctx, app, project.RunOpts{})
+	require.Nil(t, err)
+	require.Equal(t, project.Cached, code)
+	require.Len(t, queue.jobs, 0)
+	data, err = ioutil.ReadFile(app.Outputs()[0].Path())
+	require.Nil(t, err)
+	require.Equal(t, "widget\napp\n", string(data))
+	// A Job fails if the outputs of its dependencies aren't cached
+	err = RestoreDependencies(ctx, cache.New(cache.Opts{Store: memory.New()}), app)
+	require.NotNil(t, err)
+	require.Equal(t, "outputs of dependency widget.build are not in the cache", err.Error())
+}
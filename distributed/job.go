@@ -0,0 +1,130 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+)
+
+// MaxResultOutput is the most output of a Rule returned to the coordinator.
+// Longer output is truncated at the start.
+const MaxResultOutput = 256 * 1024
+
+// Job asks a worker to run one Rule of a build. The worker extracts the
+// project from the source archive in the store and runs the Rule there,
+// restoring the outputs of its dependencies from the cache.
+type Job struct {
+	ID         string            `json:"id"`
+	BuildID    string            `json:"build_id"`
+	Component  string            `json:"component"`
+	Rule       string            `json:"rule"`
+	ArchiveKey string            `json:"archive_key"`
+	CommitID   string            `json:"commit_id,omitempty"`
+	Platform   string            `json:"platform,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Debug      bool              `json:"debug,omitempty"`
+}
+
+// NodeID returns the ID of the Rule the Job runs, e.g. "api.build"
+func (job Job) NodeID() string {
+	return fmt.Sprintf("%s.%s", job.Component, job.Rule)
+}
+
+// Result is the outcome of a Job, which the worker puts in the store
+type Result struct {
+	JobID    string        `json:"job_id"`
+	Worker   string        `json:"worker"`
+	Code     string        `json:"code"`
+	Error    string        `json:"error,omitempty"`
+	Output   string        `json:"output,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+// code returns the Code the Rule finished with
+func (result Result) code() project.Code {
+	code, _ := project.ParseCode(result.Code)
+	return code
+}
+
+// err returns the error the Rule failed with, if any
+func (result Result) err() error {
+	if result.Error == "" {
+		return nil
+	}
+	return errors.New(result.Error)
+}
+
+// resultKey returns the key of the result of a Job in the store
+func resultKey(jobID string) string {
+	return fmt.Sprintf("jobs/%s.json", jobID)
+}
+
+// putResult stores the result of a Job
+func putResult(ctx context.Context, s store.Store, result Result) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "zim-result-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.Put(ctx, resultKey(result.JobID), f.Name(), nil)
+}
+
+// getResult returns the result of a Job, or nil if there isn't one yet
+func getResult(ctx context.Context, s store.Store, jobID string) (*Result, error) {
+	key := resultKey(jobID)
+	if _, err := s.Head(ctx, key); err != nil {
+		if _, ok := err.(store.NotFound); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	f, err := ioutil.TempFile("", "zim-result-")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := s.Get(ctx, key, f.Name()); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		return nil, err
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("invalid result of job %s: %s", jobID, err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/fugue/zim/project"
+)
+
+// Defaults used by a Pool
+const (
+	DefaultPoolInterval   = 30 * time.Second
+	DefaultScaleDownDelay = 5 * time.Minute
+)
+
+// Backlog is implemented by Queues that can report how many Jobs are
+// waiting for a worker or being run by one
+type Backlog interface {
+	Backlog(ctx context.Context) (int, error)
+}
+
+// Scaler changes the number of workers running in a pool, e.g. the tasks
+// of an ECS service or the replicas of a Kubernetes deployment
+type Scaler interface {
+
+	// Workers returns the number of workers the pool is set to run
+	Workers(ctx context.Context) (int, error)
+
+	// Scale sets the number of workers the pool runs
+	Scale(ctx context.Context, workers int) error
+}
+
+// PoolMetrics are measurements of a Pool taken each time it is checked
+type PoolMetrics struct {
+	Backlog int
+	Workers int
+	Desired int
+}
+
+// MetricsPublisher publishes the metrics of a Pool, e.g. to CloudWatch
+type MetricsPublisher interface {
+	Publish(ctx context.Context, metrics PoolMetrics) error
+}
+
+// PoolOpts configures a Pool
+type PoolOpts struct {
+
+	// Backlog reports the Jobs in the queue the workers poll
+	Backlog Backlog
+
+	// Scaler changes the number of workers
+	Scaler Scaler
+
+	// Min and Max bound the number of workers
+	Min int
+	Max int
+
+	// JobsPerWorker is the number of Jobs each worker runs at once, one if
+	// zero
+	JobsPerWorker int
+
+	// Interval is the time between checks of the backlog. Defaults to
+	// DefaultPoolInterval.
+	Interval time.Duration
+
+	// ScaleDownDelay is how long fewer workers must be needed before the
+	// pool shrinks, so that it doesn't shrink between the waves of Jobs
+	// of a build. Defaults to DefaultScaleDownDelay.
+	ScaleDownDelay time.Duration
+
+	// Metrics publishes the metrics of each check, if it is set
+	Metrics MetricsPublisher
+
+	// Log receives a line each time the pool is scaled or a check fails,
+	// os.Stdout if nil
+	Log io.Writer
+}
+
+// Pool scales workers up and down with the backlog of their queue. The
+// pool grows as soon as more workers are needed, while it shrinks only
+// once fewer workers have been needed for the ScaleDownDelay.
+type Pool struct {
+	opts PoolOpts
+
+	// Since when fewer workers have been needed, and the most needed since
+	downSince time.Time
+	downTo    int
+}
+
+// NewPool returns a Pool
+func NewPool(opts PoolOpts) (*Pool, error) {
+	if opts.Min < 0 || opts.Max < 1 || opts.Min > opts.Max {
+		return nil, fmt.Errorf("invalid pool bounds: min %d, max %d", opts.Min, opts.Max)
+	}
+	if opts.JobsPerWorker < 1 {
+		opts.JobsPerWorker = 1
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultPoolInterval
+	}
+	if opts.ScaleDownDelay <= 0 {
+		opts.ScaleDownDelay = DefaultScaleDownDelay
+	}
+	if opts.Log == nil {
+		opts.Log = os.Stdout
+	}
+	return &Pool{opts: opts}, nil
+}
+
+// Start checks the backlog every interval and scales the pool until the
+// context is canceled. Failed checks are logged and tried again at the next
+// interval.
+func (p *Pool) Start(ctx context.Context) error {
+	ticker := time.NewTicker(p.opts.Interval)
+	defer ticker.Stop()
+	for {
+		if err := p.check(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			fmt.Fprintln(p.opts.Log, "pool:", project.Yellow(err.Error()))
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// desired returns the number of workers needed for the backlog
+func (p *Pool) desired(backlog int) int {
+	workers := (backlog + p.opts.JobsPerWorker - 1) / p.opts.JobsPerWorker
+	if workers < p.opts.Min {
+		return p.opts.Min
+	}
+	if workers > p.opts.Max {
+		return p.opts.Max
+	}
+	return workers
+}
+
+// check scales the pool for the current backlog and publishes its metrics
+func (p *Pool) check(ctx context.Context, now time.Time) error {
+	backlog, err := p.opts.Backlog.Backlog(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get backlog: %s", err)
+	}
+	workers, err := p.opts.Scaler.Workers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get workers: %s", err)
+	}
+	desired := p.desired(backlog)
+
+	scaleTo := workers
+	switch {
+	case desired > workers:
+		scaleTo = desired
+		p.downSince = time.Time{}
+	case desired < workers:
+		if p.downSince.IsZero() || desired > p.downTo {
+			if p.downSince.IsZero() {
+				p.downSince = now
+			}
+			p.downTo = desired
+		}
+		if now.Sub(p.downSince) >= p.opts.ScaleDownDelay {
+			scaleTo = p.downTo
+			p.downSince = time.Time{}
+		}
+	default:
+		p.downSince = time.Time{}
+	}
+
+	if scaleTo != workers {
+		if err := p.opts.Scaler.Scale(ctx, scaleTo); err != nil {
+			return fmt.Errorf("failed to scale from %d to %d workers: %s", workers, scaleTo, err)
+		}
+		fmt.Fprintln(p.opts.Log, "pool:", "scaled from", workers, "to",
+			project.Bright(scaleTo), "workers with", backlog, "jobs queued")
+		workers = scaleTo
+	}
+	if p.opts.Metrics != nil {
+		metrics := PoolMetrics{Backlog: backlog, Workers: workers, Desired: desired}
+		if err := p.opts.Metrics.Publish(ctx, metrics); err != nil {
+			return fmt.Errorf("failed to publish metrics: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakePool has a backlog and a number of workers that are set directly
+type fakePool struct {
+	backlog  int
+	workers  int
+	scales   []int
+	metrics  []PoolMetrics
+	scaleErr error
+}
+
+func (f *fakePool) Backlog(ctx context.Context) (int, error) {
+	return f.backlog, nil
+}
+
+func (f *fakePool) Workers(ctx context.Context) (int, error) {
+	return f.workers, nil
+}
+
+func (f *fakePool) Scale(ctx context.Context, workers int) error {
+	if f.scaleErr != nil {
+		return f.scaleErr
+	}
+	f.scales = append(f.scales, workers)
+	f.workers = workers
+	return nil
+}
+
+func (f *fakePool) Publish(ctx context.Context, metrics PoolMetrics) error {
+	f.metrics = append(f.metrics, metrics)
+	return nil
+}
+
+func TestPool(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakePool{workers: 1}
+	p, err := NewPool(PoolOpts{
+		Backlog:        fake,
+		Scaler:         fake,
+		Min:            1,
+		Max:            5,
+		JobsPerWorker:  4,
+		ScaleDownDelay: 5 * time.Minute,
+		Metrics:        fake,
+		Log:            ioutil.Discard,
+	})
+	require.Nil(t, err)
+	now := time.Now()
+
+	// The pool grows at once, up to the maximum
+	fake.backlog = 9
+	require.Nil(t, p.check(ctx, now))
+	require.Equal(t, []int{3}, fake.scales)
+	fake.backlog = 100
+	require.Nil(t, p.check(ctx, now))
+	require.Equal(t, []int{3, 5}, fake.scales)
+	require.Equal(t, PoolMetrics{Backlog: 100, Workers: 5, Desired: 5}, fake.metrics[1])
+
+	// It shrinks once fewer workers have been needed for the delay, to the
+	// most workers needed meanwhile
+	fake.backlog = 4
+	require.Nil(t, p.check(ctx, now.Add(time.Minute)))
+	fake.backlog = 8
+	require.Nil(t, p.check(ctx, now.Add(3*time.Minute)))
+	fake.backlog = 0
+	require.Nil(t, p.check(ctx, now.Add(5*time.Minute)))
+	require.Equal(t, []int{3, 5}, fake.scales)
+	require.Equal(t, PoolMetrics{Backlog: 0, Workers: 5, Desired: 1}, fake.metrics[4])
+	require.Nil(t, p.check(ctx, now.Add(6*time.Minute)))
+	require.Equal(t, []int{3, 5, 2}, fake.scales)
+
+	// A busy period resets the delay
+	require.Nil(t, p.check(ctx, now.Add(7*time.Minute)))
+	fake.backlog = 8
+	require.Nil(t, p.check(ctx, now.Add(8*time.Minute)))
+	fake.backlog = 0
+	require.Nil(t, p.check(ctx, now.Add(9*time.Minute)))
+	require.Nil(t, p.check(ctx, now.Add(13*time.Minute)))
+	require.Equal(t, []int{3, 5, 2}, fake.scales)
+	require.Nil(t, p.check(ctx, now.Add(14*time.Minute)))
+	require.Equal(t, []int{3, 5, 2, 1}, fake.scales)
+
+	fake.backlog = 20
+	fake.scaleErr = errors.New("access denied")
+	err = p.check(ctx, now.Add(15*time.Minute))
+	require.NotNil(t, err)
+	require.Equal(t, "failed to scale from 1 to 5 workers: access denied", err.Error())
+
+	_, err = NewPool(PoolOpts{Min: 3, Max: 2})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,43 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"time"
+)
+
+// Delivery is a Job received from a Queue. The Job is delivered again if it
+// isn't deleted before its visibility timeout.
+type Delivery struct {
+	Job    Job
+	Handle string
+}
+
+// Queue carries Jobs from the coordinator of a build to workers
+type Queue interface {
+
+	// Send a Job to a worker
+	Send(ctx context.Context, job Job) error
+
+	// Receive waits up to the given time for Jobs, which are hidden from
+	// other workers for the visibility timeout
+	Receive(ctx context.Context, wait, visibility time.Duration) ([]Delivery, error)
+
+	// Extend hides a Job being worked on for another visibility timeout
+	Extend(ctx context.Context, d Delivery, visibility time.Duration) error
+
+	// Delete a Job that is finished
+	Delete(ctx context.Context, d Delivery) error
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+)
+
+// ECSService scales workers run as the tasks of an ECS service, e.g. on
+// Fargate
+type ECSService struct {
+	cluster string
+	service string
+	region  string
+	mutex   sync.Mutex
+	client  ecsiface.ECSAPI
+}
+
+// NewECSService returns a Scaler for the service in the cluster
+func NewECSService(cluster, service, region string) *ECSService {
+	return &ECSService{cluster: cluster, service: service, region: region}
+}
+
+// NewECSServiceWithClient returns a Scaler that uses the given client
+func NewECSServiceWithClient(client ecsiface.ECSAPI, cluster, service string) *ECSService {
+	return &ECSService{cluster: cluster, service: service, client: client}
+}
+
+// connect creates the ECS client if it doesn't exist yet
+func (s *ECSService) connect() (ecsiface.ECSAPI, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+	sess, err := newSession(s.region)
+	if err != nil {
+		return nil, err
+	}
+	s.client = ecs.New(sess)
+	return s.client, nil
+}
+
+// Workers returns the desired count of the service's tasks
+func (s *ECSService) Workers(ctx context.Context) (int, error) {
+	client, err := s.connect()
+	if err != nil {
+		return 0, err
+	}
+	output, err := client.DescribeServicesWithContext(ctx, &ecs.DescribeServicesInput{
+		Cluster:  aws.String(s.cluster),
+		Services: aws.StringSlice([]string{s.service}),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(output.Failures) > 0 {
+		return 0, fmt.Errorf("failed to describe service %s: %s",
+			s.service, aws.StringValue(output.Failures[0].Reason))
+	}
+	if len(output.Services) == 0 {
+		return 0, fmt.Errorf("service not found: %s", s.service)
+	}
+	return int(aws.Int64Value(output.Services[0].DesiredCount)), nil
+}
+
+// Scale sets the desired count of the service's tasks
+func (s *ECSService) Scale(ctx context.Context, workers int) error {
+	client, err := s.connect()
+	if err != nil {
+		return err
+	}
+	_, err = client.UpdateServiceWithContext(ctx, &ecs.UpdateServiceInput{
+		Cluster:      aws.String(s.cluster),
+		Service:      aws.String(s.service),
+		DesiredCount: aws.Int64(int64(workers)),
+	})
+	return err
+}
+
+// KubernetesDeployment scales workers run as the replicas of a Kubernetes
+// deployment using kubectl, which must be configured for the cluster
+type KubernetesDeployment struct {
+	name      string
+	namespace string
+}
+
+// NewKubernetesDeployment returns a Scaler for the deployment. The default
+// namespace of the kubectl context is used if the namespace is empty.
+func NewKubernetesDeployment(name, namespace string) *KubernetesDeployment {
+	return &KubernetesDeployment{name: name, namespace: namespace}
+}
+
+// kubectl runs kubectl with the arguments and returns its output
+func (d *KubernetesDeployment) kubectl(ctx context.Context, args ...string) (string, error) {
+	verb := args[0]
+	if d.namespace != "" {
+		args = append([]string{"--namespace", d.namespace}, args...)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "kubectl", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("kubectl %s failed: %s: %s",
+			verb, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// Workers returns the number of replicas the deployment specifies
+func (d *KubernetesDeployment) Workers(ctx context.Context) (int, error) {
+	out, err := d.kubectl(ctx, "get", "deployment", d.name, "--output", "jsonpath={.spec.replicas}")
+	if err != nil {
+		return 0, err
+	}
+	replicas, err := strconv.Atoi(out)
+	if err != nil {
+		return 0, fmt.Errorf("invalid replicas of deployment %s: %q", d.name, out)
+	}
+	return replicas, nil
+}
+
+// Scale sets the number of replicas of the deployment
+func (d *KubernetesDeployment) Scale(ctx context.Context, workers int) error {
+	_, err := d.kubectl(ctx, "scale", "deployment", d.name, "--replicas", strconv.Itoa(workers))
+	return err
+}
+
+// CloudWatchMetrics publishes the metrics of a Pool to CloudWatch, with a
+// dimension naming the pool
+type CloudWatchMetrics struct {
+	namespace string
+	pool      string
+	region    string
+	mutex     sync.Mutex
+	client    cloudwatchiface.CloudWatchAPI
+}
+
+// NewCloudWatchMetrics returns a MetricsPublisher that puts metrics in the
+// namespace
+func NewCloudWatchMetrics(namespace, pool, region string) *CloudWatchMetrics {
+	return &CloudWatchMetrics{namespace: namespace, pool: pool, region: region}
+}
+
+// NewCloudWatchMetricsWithClient returns a MetricsPublisher that uses the
+// given client
+func NewCloudWatchMetricsWithClient(client cloudwatchiface.CloudWatchAPI, namespace, pool string) *CloudWatchMetrics {
+	return &CloudWatchMetrics{namespace: namespace, pool: pool, client: client}
+}
+
+// connect creates the CloudWatch client if it doesn't exist yet
+func (m *CloudWatchMetrics) connect() (cloudwatchiface.CloudWatchAPI, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.client != nil {
+		return m.client, nil
+	}
+	sess, err := newSession(m.region)
+	if err != nil {
+		return nil, err
+	}
+	m.client = cloudwatch.New(sess)
+	return m.client, nil
+}
+
+// Publish puts the Backlog, Workers, and DesiredWorkers metrics
+func (m *CloudWatchMetrics) Publish(ctx context.Context, metrics PoolMetrics) error {
+	client, err := m.connect()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	dimensions := []*cloudwatch.Dimension{{Name: aws.String("Pool"), Value: aws.String(m.pool)}}
+	datum := func(name string, value int) *cloudwatch.MetricDatum {
+		return &cloudwatch.MetricDatum{
+			MetricName: aws.String(name),
+			Dimensions: dimensions,
+			Timestamp:  aws.Time(now),
+			Unit:       aws.String(cloudwatch.StandardUnitCount),
+			Value:      aws.Float64(float64(value)),
+		}
+	}
+	_, err = client.PutMetricDataWithContext(ctx, &cloudwatch.PutMetricDataInput{
+		Namespace: aws.String(m.namespace),
+		MetricData: []*cloudwatch.MetricDatum{
+			datum("Backlog", metrics.Backlog),
+			datum("Workers", metrics.Workers),
+			datum("DesiredWorkers", metrics.Desired),
+		},
+	})
+	return err
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeECS has one service
+type fakeECS struct {
+	ecsiface.ECSAPI
+	desired int64
+}
+
+func (f *fakeECS) DescribeServicesWithContext(ctx aws.Context, input *ecs.DescribeServicesInput, opts ...request.Option) (*ecs.DescribeServicesOutput, error) {
+	if aws.StringValue(input.Services[0]) != "workers" {
+		return &ecs.DescribeServicesOutput{Failures: []*ecs.Failure{{Reason: aws.String("MISSING")}}}, nil
+	}
+	return &ecs.DescribeServicesOutput{Services: []*ecs.Service{{DesiredCount: aws.Int64(f.desired)}}}, nil
+}
+
+func (f *fakeECS) UpdateServiceWithContext(ctx aws.Context, input *ecs.UpdateServiceInput, opts ...request.Option) (*ecs.UpdateServiceOutput, error) {
+	f.desired = aws.Int64Value(input.DesiredCount)
+	return &ecs.UpdateServiceOutput{}, nil
+}
+
+func TestECSService(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeECS{desired: 2}
+	s := NewECSServiceWithClient(client, "zim", "workers")
+
+	workers, err := s.Workers(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 2, workers)
+	require.Nil(t, s.Scale(ctx, 4))
+	require.Equal(t, int64(4), client.desired)
+
+	_, err = NewECSServiceWithClient(client, "zim", "other").Workers(ctx)
+	require.NotNil(t, err)
+	require.Equal(t, "failed to describe service other: MISSING", err.Error())
+}
+
+// fakeCloudWatch keeps the metric data put in it
+type fakeCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	input *cloudwatch.PutMetricDataInput
+}
+
+func (f *fakeCloudWatch) PutMetricDataWithContext(ctx aws.Context, input *cloudwatch.PutMetricDataInput, opts ...request.Option) (*cloudwatch.PutMetricDataOutput, error) {
+	f.input = input
+	return &cloudwatch.PutMetricDataOutput{}, nil
+}
+
+func TestCloudWatchMetrics(t *testing.T) {
+	client := &fakeCloudWatch{}
+	m := NewCloudWatchMetricsWithClient(client, "Zim", "builds")
+	require.Nil(t, m.Publish(context.Background(), PoolMetrics{Backlog: 7, Workers: 2, Desired: 3}))
+
+	require.Equal(t, "Zim", aws.StringValue(client.input.Namespace))
+	values := map[string]float64{}
+	for _, datum := range client.input.MetricData {
+		require.Equal(t, "builds", aws.StringValue(datum.Dimensions[0].Value))
+		values[aws.StringValue(datum.MetricName)] = aws.Float64Value(datum.Value)
+	}
+	require.Equal(t, map[string]float64{"Backlog": 7, "Workers": 2, "DesiredWorkers": 3}, values)
+}
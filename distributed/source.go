@@ -0,0 +1,179 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	glob "github.com/bmatcuk/doublestar"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/vcs"
+)
+
+// SourceFilter selects the files of the project that are archived for
+// workers, using glob patterns relative to the project root. If there are
+// Include patterns, only files matching one are archived. Files matching an
+// Exclude pattern are never archived. A pattern matching a directory
+// matches everything within it.
+type SourceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// matches returns true if a pattern matches the path or a directory
+// containing it
+func matches(patterns []string, name string) bool {
+	for p := name; p != "." && p != "/"; p = path.Dir(p) {
+		for _, pattern := range patterns {
+			if matched, _ := glob.Match(pattern, p); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Includes returns true if the file, given as a slash separated path
+// relative to the project root, is archived
+func (f SourceFilter) Includes(name string) bool {
+	name = path.Clean(strings.TrimPrefix(name, "./"))
+	if name == "." {
+		return true
+	}
+	if len(f.Include) > 0 && !matches(f.Include, name) {
+		return false
+	}
+	return !matches(f.Exclude, name)
+}
+
+// archive writes the repository's archive, leaving out the files that
+// aren't included
+func (f SourceFilter) archive(repo vcs.VCS, w io.Writer) error {
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return repo.Archive(w)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(repo.Archive(pw))
+	}()
+	defer pr.Close()
+
+	tr := tar.NewReader(pr)
+	tw := tar.NewWriter(w)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		// Global headers, such as the commit ID added by git, apply to the
+		// archive rather than a file
+		if header.Typeflag != tar.TypeXGlobalHeader && !f.Includes(header.Name) {
+			continue
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ArchiveSource puts an archive of the commit checked out in the repository
+// in the store, unless the same archive is already there, and returns its
+// key. Uncommitted changes aren't archived, so they must not exist: workers
+// would compute different keys for Rules that use them. Directories that are
+// not under version control are archived in full. Git leaves out files with
+// the export-ignore attribute, and the filter may leave out others.
+func ArchiveSource(ctx context.Context, repo vcs.VCS, s store.Store, filter SourceFilter) (string, error) {
+	changes, err := repo.Changes()
+	if err != nil && err != vcs.ErrNoVersionControl {
+		return "", err
+	}
+	if changes != "" {
+		return "", errors.New("the working copy has uncommitted changes, " +
+			"which workers can't see. Commit or stash them first.")
+	}
+
+	f, err := ioutil.TempFile("", "zim-source-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+
+	// The key is the hash of the tar archive, which is the same for the
+	// same files regardless of compression
+	h := sha1.New()
+	gz := gzip.NewWriter(f)
+	if err := filter.archive(repo, io.MultiWriter(gz, h)); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := gz.Close(); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("sources/%x.tar.gz", h.Sum(nil))
+
+	if _, err := s.Head(ctx, key); err == nil {
+		return key, nil
+	} else if _, ok := err.(store.NotFound); !ok {
+		return "", err
+	}
+	if err := s.Put(ctx, key, f.Name(), map[string]string{"Format": "tar.gz"}); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// ExtractSource gets a source archive from the store and extracts it into
+// the directory
+func ExtractSource(ctx context.Context, s store.Store, key, dir string) error {
+	f, err := ioutil.TempFile("", "zim-source-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if err := s.Get(ctx, key, f.Name()); err != nil {
+		return fmt.Errorf("failed to get source archive %s: %s", key, err)
+	}
+	archive, err := os.Open(f.Name())
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+	gz, err := gzip.NewReader(archive)
+	if err != nil {
+		return err
+	}
+	return project.ExtractTar(tar.NewReader(gz), dir)
+}
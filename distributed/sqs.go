@@ -0,0 +1,196 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// SQS is a Queue backed by an Amazon SQS queue. Jobs are sent as JSON
+// message bodies. The AWS session is only created when the queue is first
+// used.
+type SQS struct {
+	url    string
+	region string
+	mutex  sync.Mutex
+	client sqsiface.SQSAPI
+}
+
+// NewSQS returns a Queue using the SQS queue with the given URL. The region
+// is taken from the AWS configuration if it is empty.
+func NewSQS(url, region string) *SQS {
+	return &SQS{url: url, region: region}
+}
+
+// NewSQSWithClient returns a Queue that uses the given client
+func NewSQSWithClient(client sqsiface.SQSAPI, url string) *SQS {
+	return &SQS{url: url, client: client}
+}
+
+// newSession returns an AWS session for the region, which is taken from
+// the AWS configuration if it is empty
+func newSession(region string) (*session.Session, error) {
+	cfg := aws.NewConfig().WithMaxRetries(8)
+	if region != "" {
+		cfg = cfg.WithRegion(region)
+	}
+	return session.NewSession(cfg)
+}
+
+// connect creates the SQS client if it doesn't exist yet
+func (q *SQS) connect() (sqsiface.SQSAPI, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.client != nil {
+		return q.client, nil
+	}
+	sess, err := newSession(q.region)
+	if err != nil {
+		return nil, err
+	}
+	q.client = sqs.New(sess)
+	return q.client, nil
+}
+
+// Send a Job to a worker
+func (q *SQS) Send(ctx context.Context, job Job) error {
+	client, err := q.connect()
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = client.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(string(body)),
+	})
+	return err
+}
+
+// Receive waits up to the given time for Jobs. SQS waits at most 20 seconds.
+// Messages that aren't valid Jobs are deleted.
+func (q *SQS) Receive(ctx context.Context, wait, visibility time.Duration) ([]Delivery, error) {
+	client, err := q.connect()
+	if err != nil {
+		return nil, err
+	}
+	output, err := client.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:            aws.String(q.url),
+		MaxNumberOfMessages: aws.Int64(1),
+		WaitTimeSeconds:     aws.Int64(seconds(wait, 20)),
+		VisibilityTimeout:   aws.Int64(seconds(visibility, 43200)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var deliveries []Delivery
+	for _, msg := range output.Messages {
+		d := Delivery{Handle: aws.StringValue(msg.ReceiptHandle)}
+		if err := json.Unmarshal([]byte(aws.StringValue(msg.Body)), &d.Job); err != nil || d.Job.ID == "" {
+			if err := q.Delete(ctx, d); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, nil
+}
+
+// Extend hides a Job being worked on for another visibility timeout
+func (q *SQS) Extend(ctx context.Context, d Delivery, visibility time.Duration) error {
+	client, err := q.connect()
+	if err != nil {
+		return err
+	}
+	_, err = client.ChangeMessageVisibilityWithContext(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(q.url),
+		ReceiptHandle:     aws.String(d.Handle),
+		VisibilityTimeout: aws.Int64(seconds(visibility, 43200)),
+	})
+	return err
+}
+
+// Delete a Job that is finished
+func (q *SQS) Delete(ctx context.Context, d Delivery) error {
+	client, err := q.connect()
+	if err != nil {
+		return err
+	}
+	_, err = client.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.url),
+		ReceiptHandle: aws.String(d.Handle),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete job: %s", err)
+	}
+	return nil
+}
+
+// backlogAttributes are the counts of messages that are waiting and being
+// worked on, which make up a queue's backlog
+var backlogAttributes = []string{
+	sqs.QueueAttributeNameApproximateNumberOfMessages,
+	sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible,
+}
+
+// Backlog returns the approximate number of Jobs waiting for a worker or
+// being run by one
+func (q *SQS) Backlog(ctx context.Context) (int, error) {
+	client, err := q.connect()
+	if err != nil {
+		return 0, err
+	}
+	output, err := client.GetQueueAttributesWithContext(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       aws.String(q.url),
+		AttributeNames: aws.StringSlice(backlogAttributes),
+	})
+	if err != nil {
+		return 0, err
+	}
+	var backlog int
+	for _, name := range backlogAttributes {
+		value := aws.StringValue(output.Attributes[name])
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid queue attribute %s: %q", name, value)
+		}
+		backlog += count
+	}
+	return backlog, nil
+}
+
+// seconds returns a duration in whole seconds, at most the given maximum
+func seconds(d time.Duration, max int64) int64 {
+	s := int64(d / time.Second)
+	if s > max {
+		return max
+	}
+	if s < 0 {
+		return 0
+	}
+	return s
+}
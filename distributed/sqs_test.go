@@ -0,0 +1,156 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQS keeps sent messages until they are deleted
+type fakeSQS struct {
+	sqsiface.SQSAPI
+	mutex      sync.Mutex
+	count      int
+	messages   []*sqs.Message
+	visibility map[string]int64
+	deleted    []string
+}
+
+func (f *fakeSQS) SendMessageWithContext(ctx aws.Context, input *sqs.SendMessageInput, opts ...request.Option) (*sqs.SendMessageOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.count++
+	f.messages = append(f.messages, &sqs.Message{
+		Body:          input.MessageBody,
+		ReceiptHandle: aws.String(strconv.Itoa(f.count)),
+	})
+	return &sqs.SendMessageOutput{}, nil
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(ctx aws.Context, input *sqs.ReceiveMessageInput, opts ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	var messages []*sqs.Message
+	for _, msg := range f.messages {
+		if int64(len(messages)) == aws.Int64Value(input.MaxNumberOfMessages) {
+			break
+		}
+		f.visibility[aws.StringValue(msg.ReceiptHandle)] = aws.Int64Value(input.VisibilityTimeout)
+		messages = append(messages, msg)
+	}
+	f.messages = f.messages[len(messages):]
+	return &sqs.ReceiveMessageOutput{Messages: messages}, nil
+}
+
+func (f *fakeSQS) ChangeMessageVisibilityWithContext(ctx aws.Context, input *sqs.ChangeMessageVisibilityInput, opts ...request.Option) (*sqs.ChangeMessageVisibilityOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.visibility[aws.StringValue(input.ReceiptHandle)] = aws.Int64Value(input.VisibilityTimeout)
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(ctx aws.Context, input *sqs.DeleteMessageInput, opts ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deleted = append(f.deleted, aws.StringValue(input.ReceiptHandle))
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func TestSQS(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeSQS{visibility: map[string]int64{}}
+	q := NewSQSWithClient(client, "https://sqs.us-east-1.amazonaws.com/123/zim")
+
+	job := Job{
+		ID:         "1",
+		BuildID:    "build",
+		Component:  "widget",
+		Rule:       "build",
+		ArchiveKey: "sources/abc.tar.gz",
+		Parameters: map[string]string{"VERSION": "1.2"},
+	}
+	require.Nil(t, q.Send(ctx, job))
+
+	deliveries, err := q.Receive(ctx, time.Minute, 5*time.Minute)
+	require.Nil(t, err)
+	require.Len(t, deliveries, 1)
+	require.Equal(t, job, deliveries[0].Job)
+	require.Equal(t, "1", deliveries[0].Handle)
+	require.Equal(t, int64(300), client.visibility["1"])
+
+	require.Nil(t, q.Extend(ctx, deliveries[0], 10*time.Minute))
+	require.Equal(t, int64(600), client.visibility["1"])
+
+	require.Nil(t, q.Delete(ctx, deliveries[0]))
+	require.Equal(t, []string{"1"}, client.deleted)
+
+	// Messages that aren't Jobs are deleted rather than delivered
+	client.messages = append(client.messages, &sqs.Message{
+		Body:          aws.String("not a job"),
+		ReceiptHandle: aws.String("bad"),
+	})
+	deliveries, err = q.Receive(ctx, time.Second, time.Minute)
+	require.Nil(t, err)
+	require.Len(t, deliveries, 0)
+	require.Equal(t, []string{"1", "bad"}, client.deleted)
+}
+
+func (f *fakeSQS) GetQueueAttributesWithContext(ctx aws.Context, input *sqs.GetQueueAttributesInput, opts ...request.Option) (*sqs.GetQueueAttributesOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return &sqs.GetQueueAttributesOutput{Attributes: map[string]*string{
+		sqs.QueueAttributeNameApproximateNumberOfMessages:           aws.String(strconv.Itoa(len(f.messages))),
+		sqs.QueueAttributeNameApproximateNumberOfMessagesNotVisible: aws.String(strconv.Itoa(len(f.visibility) - len(f.deleted))),
+	}}, nil
+}
+
+func TestSQSBacklog(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeSQS{visibility: map[string]int64{}}
+	q := NewSQSWithClient(client, "https://sqs.us-east-1.amazonaws.com/123/zim")
+
+	for _, id := range []string{"1", "2", "3"} {
+		require.Nil(t, q.Send(ctx, Job{ID: id}))
+	}
+	backlog, err := q.Backlog(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 3, backlog)
+
+	// Jobs being run count until they are deleted
+	deliveries, err := q.Receive(ctx, time.Second, time.Minute)
+	require.Nil(t, err)
+	backlog, err = q.Backlog(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 3, backlog)
+	require.Nil(t, q.Delete(ctx, deliveries[0]))
+	backlog, err = q.Backlog(ctx)
+	require.Nil(t, err)
+	require.Equal(t, 2, backlog)
+}
+
+func TestSeconds(t *testing.T) {
+	require.Equal(t, int64(20), seconds(time.Minute, 20))
+	require.Equal(t, int64(5), seconds(5500*time.Millisecond, 20))
+	require.Equal(t, int64(0), seconds(-time.Second, 20))
+}
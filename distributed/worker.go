@@ -0,0 +1,307 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package distributed
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/store"
+)
+
+// Defaults used by a Worker
+const (
+	DefaultVisibilityTimeout = 5 * time.Minute
+	DefaultReceiveWait       = 20 * time.Second
+	DefaultRetries           = 5
+	DefaultRetryDelay        = time.Second
+)
+
+// RunFunc runs the Rule of a Job in the directory the source archive was
+// extracted to, writing the Rule's output to the writer
+type RunFunc func(ctx context.Context, dir string, job Job, output io.Writer) (project.Code, error)
+
+// WorkerOpts configures a Worker
+type WorkerOpts struct {
+
+	// Name identifies the worker in results, e.g. its host name
+	Name string
+
+	// Queue that Jobs are received from
+	Queue Queue
+
+	// Store containing source archives, in which results are put
+	Store store.Store
+
+	// Dir is where source archives are extracted. Each Job run at once has
+	// a directory of its own, which holds the source of one build at a time.
+	Dir string
+
+	// Jobs is the number of Jobs run at once, one if zero
+	Jobs int
+
+	// VisibilityTimeout is how long a received Job is hidden from other
+	// workers. It is extended while the Job runs, so that the Job is only
+	// delivered again if this worker stops. Defaults to
+	// DefaultVisibilityTimeout.
+	VisibilityTimeout time.Duration
+
+	// Retries is how many times receiving Jobs, storing a result or
+	// deleting a Job is retried before the worker stops. Defaults to
+	// DefaultRetries.
+	Retries int
+
+	// RetryDelay is the wait before the first retry, which doubles with
+	// each retry. Defaults to DefaultRetryDelay.
+	RetryDelay time.Duration
+
+	// Run runs the Rule of each Job
+	Run RunFunc
+
+	// Log receives a line as each Job starts and finishes, os.Stdout if nil
+	Log io.Writer
+}
+
+// Worker runs Jobs received from a Queue
+type Worker struct {
+	opts WorkerOpts
+}
+
+// slot runs one Job at a time. Each slot extracts source archives into a
+// directory of its own, so that Jobs running at once never share a tree.
+// Only the most recent source is kept.
+type slot struct {
+	dir    string
+	source string
+}
+
+// newSlot returns the slot with the given index
+func (w *Worker) newSlot(index int) *slot {
+	return &slot{dir: filepath.Join(w.opts.Dir, strconv.Itoa(index))}
+}
+
+// NewWorker returns a Worker
+func NewWorker(opts WorkerOpts) *Worker {
+	if opts.Jobs < 1 {
+		opts.Jobs = 1
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = DefaultVisibilityTimeout
+	}
+	if opts.Retries <= 0 {
+		opts.Retries = DefaultRetries
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = DefaultRetryDelay
+	}
+	if opts.Log == nil {
+		opts.Log = os.Stdout
+	}
+	return &Worker{opts: opts}
+}
+
+// Start receives and runs Jobs until the context is canceled. Jobs that are
+// running then are left to be delivered to another worker. If the queue or
+// store fails for longer than the retries allow, every Job is stopped in
+// the same way and the error is returned.
+func (w *Worker) Start(parent context.Context) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var once sync.Once
+	var failed error
+	for i := 0; i < w.opts.Jobs; i++ {
+		wg.Add(1)
+		go func(s *slot) {
+			defer wg.Done()
+			if err := w.poll(ctx, s); err != nil {
+				once.Do(func() {
+					failed = err
+					cancel()
+				})
+			}
+		}(w.newSlot(i))
+	}
+	wg.Wait()
+	if failed != nil {
+		return failed
+	}
+	return parent.Err()
+}
+
+// poll receives and runs one Job at a time in the slot
+func (w *Worker) poll(ctx context.Context, s *slot) error {
+	for ctx.Err() == nil {
+		var deliveries []Delivery
+		err := w.retry(ctx, "receive jobs", func() (err error) {
+			deliveries, err = w.opts.Queue.Receive(ctx, DefaultReceiveWait, w.opts.VisibilityTimeout)
+			return err
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for _, d := range deliveries {
+			if err := w.handle(ctx, s, d); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// retry calls the function until it succeeds, the retries run out or the
+// context is canceled. Failures of the queue and store are often transient,
+// so the delay between attempts doubles to let them recover.
+func (w *Worker) retry(ctx context.Context, action string, fn func() error) error {
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if retry == w.opts.Retries {
+			return fmt.Errorf("failed to %s: %s", action, err)
+		}
+		delay := w.opts.RetryDelay << uint(retry)
+		fmt.Fprintln(w.opts.Log, "worker:", project.Yellow(
+			fmt.Sprintf("failed to %s, retrying in %s: %s", action, delay, err)))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// handle runs a Job and puts its result in the store. The Job is hidden
+// from other workers while it runs and deleted once there is a result.
+func (w *Worker) handle(ctx context.Context, s *slot, d Delivery) error {
+	job := d.Job
+	fmt.Fprintln(w.opts.Log, "job:", project.Bright(job.NodeID()), project.Cyan(job.ID))
+
+	extendCtx, stopExtending := context.WithCancel(ctx)
+	go w.extend(extendCtx, d)
+	result := w.run(ctx, s, job)
+	stopExtending()
+
+	// The Job is left for another worker if this one is stopping
+	if ctx.Err() != nil {
+		return nil
+	}
+	err := w.retry(ctx, "store result of job "+job.ID, func() error {
+		return putResult(ctx, w.opts.Store, result)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w.opts.Log, "job:", project.Bright(job.NodeID()), project.Cyan(job.ID),
+		project.Yellow(result.Code))
+	return w.retry(ctx, "delete job "+job.ID, func() error {
+		return w.opts.Queue.Delete(ctx, d)
+	})
+}
+
+// extend hides the Job from other workers until the context is canceled
+func (w *Worker) extend(ctx context.Context, d Delivery) {
+	ticker := time.NewTicker(w.opts.VisibilityTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := w.opts.Queue.Extend(ctx, d, w.opts.VisibilityTimeout); err != nil && ctx.Err() == nil {
+				fmt.Fprintln(w.opts.Log, "job:", project.Bright(d.Job.NodeID()),
+					project.Yellow(fmt.Sprintf("failed to extend visibility: %s", err)))
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run runs the Rule of a Job in its source, as extracted in the slot, and
+// returns the result
+func (w *Worker) run(ctx context.Context, s *slot, job Job) Result {
+	result := Result{JobID: job.ID, Worker: w.opts.Name}
+	startedAt := time.Now()
+	var output bytes.Buffer
+	code := project.Error
+	dir, err := w.source(ctx, s, job.ArchiveKey)
+	if err == nil {
+		code, err = w.opts.Run(ctx, dir, job, &output)
+	}
+	result.Duration = time.Since(startedAt)
+	result.Code = code.String()
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Output = output.String()
+	if len(result.Output) > MaxResultOutput {
+		result.Output = "...\n" + result.Output[len(result.Output)-MaxResultOutput:]
+	}
+	return result
+}
+
+// source returns the directory the source archive was extracted to in the
+// slot, extracting it if the slot's previous Job used another source. The
+// source of the previous Job is removed, along with anything left in the
+// slot by a previous worker, so that sources don't accumulate.
+func (w *Worker) source(ctx context.Context, s *slot, key string) (string, error) {
+	dir := filepath.Join(s.dir, strings.TrimSuffix(filepath.Base(key), ".tar.gz"))
+	if s.source == key {
+		return dir, nil
+	}
+	s.source = ""
+	if err := os.RemoveAll(s.dir); err != nil {
+		return "", err
+	}
+	if err := ExtractSource(ctx, w.opts.Store, key, dir); err != nil {
+		return "", err
+	}
+	s.source = key
+	return dir, nil
+}
+
+// RestoreDependencies restores the outputs of the Rule's dependencies from
+// the cache, where the workers that ran them stored them, so that the Rule
+// can be run on its own. Dependencies with outputs that aren't cached, such
+// as those without outputs, are skipped.
+func RestoreDependencies(ctx context.Context, c *cache.Cache, r *project.Rule) error {
+	for _, dep := range r.Dependencies() {
+		if !cacheable(dep) {
+			continue
+		}
+		if _, err := c.Read(ctx, dep); err == cache.CacheMiss {
+			return fmt.Errorf("outputs of dependency %s are not in the cache", dep.NodeID())
+		} else if err != nil {
+			return fmt.Errorf("failed to restore outputs of dependency %s: %s", dep.NodeID(), err)
+		}
+	}
+	return nil
+}
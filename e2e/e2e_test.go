@@ -0,0 +1,128 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package e2e
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltin(t *testing.T) {
+	scenarios, err := Builtin()
+	require.Nil(t, err)
+	require.NotEmpty(t, scenarios)
+
+	results, err := Run(context.Background(), scenarios, Opts{})
+	require.Nil(t, err)
+	require.Len(t, results, len(scenarios))
+	for _, result := range results {
+		require.Nil(t, result.Error, result.Name)
+	}
+}
+
+func TestUnexpectedOutcome(t *testing.T) {
+	s, err := ParseScenario([]byte(`
+name: wrong
+files:
+  widget/component.yaml: |
+    name: widget
+    rules:
+      build:
+        outputs: [widget.out]
+        command: echo widget > $ARTIFACT
+steps:
+  - run: [build]
+    expect: {widget.build: ok}
+  - run: [build]
+    expect: {widget.build: ok, widget.test: cached}
+    outputs: {artifacts/widget.out: "gadget\n", artifacts/other.out: ""}
+`))
+	require.Nil(t, err)
+
+	dir, err := ioutil.TempDir("", "zim-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	results, err := Run(context.Background(), []*Scenario{s}, Opts{Dir: dir, Keep: true})
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	require.Equal(t, filepath.Join(dir, "01-wrong"), results[0].Dir)
+	require.NotNil(t, results[0].Error)
+	require.Equal(t, "step 2 (build): "+
+		"rule widget.build was cached, expected ok; "+
+		"rule widget.test was not-run, expected cached",
+		results[0].Error.Error())
+
+	// The fixture project is kept
+	data, err := ioutil.ReadFile(filepath.Join(results[0].Dir, "project", "artifacts", "widget.out"))
+	require.Nil(t, err)
+	require.Equal(t, "widget\n", string(data))
+}
+
+func TestUnexpectedFailure(t *testing.T) {
+	s := &Scenario{
+		Name: "fails",
+		Files: map[string]string{
+			"widget/component.yaml": "name: widget\nrules:\n  build:\n    command: exit 1\n",
+		},
+		Steps: []Step{{Name: "broken", Rules: []string{"build"}}},
+	}
+	results, err := Run(context.Background(), []*Scenario{s}, Opts{})
+	require.Nil(t, err)
+	require.NotNil(t, results[0].Error)
+	require.Contains(t, results[0].Error.Error(), "step 1 (broken): build failed")
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		text string
+		err  string
+	}{
+		{"files: {}\nsteps: [{run: [build]}]", "scenario has no name"},
+		{"name: empty", "scenario empty has no steps"},
+		{"name: codes\nsteps: [{run: [build], expect: {a.build: great}}]",
+			`step 1 of codes expects unknown code "great" for a.build`},
+		{"name: nothing\nsteps: [{fail: true}]", "step 1 of nothing has expectations but runs no rules"},
+		{"name: escape\nsteps: [{write: {../outside.txt: x}}]",
+			"path ../outside.txt in escape must be relative to the project"},
+		{"name: absolute\nfiles: {/etc/passwd: x}\nsteps: [{run: [build]}]",
+			"path /etc/passwd in absolute must be relative to the project"},
+	}
+	for _, tt := range tests {
+		_, err := ParseScenario([]byte(tt.text))
+		require.NotNil(t, err, tt.text)
+		require.Equal(t, tt.err, err.Error())
+	}
+}
+
+func TestLoadScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zim-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	filePath := filepath.Join(dir, "smoke.yaml")
+	text := "steps:\n  - run: [build]\n    expect: {widget.build: not-run}\n"
+	require.Nil(t, ioutil.WriteFile(filePath, []byte(text), 0644))
+
+	s, err := LoadScenario(filePath)
+	require.Nil(t, err)
+	require.Equal(t, "smoke", s.Name)
+	require.Equal(t, []string{"build"}, s.Steps[0].Rules)
+	require.Equal(t, map[string]string{"widget.build": NotRun}, s.Steps[0].Expect)
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package e2e
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/sched"
+	"github.com/fugue/zim/store/filesystem"
+)
+
+// Opts configures how Scenarios run
+type Opts struct {
+
+	// Dir is where fixture projects are created, one directory for each
+	// Scenario. A temporary directory is used if it is empty.
+	Dir string
+
+	// Keep leaves the fixture projects in place after the Scenarios run
+	Keep bool
+
+	// Output receives the output of the Rules, which is discarded if nil
+	Output io.Writer
+}
+
+// Result is the outcome of a Scenario. Error describes the first step that
+// didn't turn out as expected, and is nil if every step did.
+type Result struct {
+	Name     string
+	Dir      string
+	Duration time.Duration
+	Error    error
+}
+
+// Run creates the fixture project of each Scenario and takes its steps. Each
+// project has its own cache, in a filesystem store next to it, which is
+// empty when the Scenario starts.
+func Run(ctx context.Context, scenarios []*Scenario, opts Opts) ([]Result, error) {
+	dir := opts.Dir
+	if dir == "" {
+		tmp, err := ioutil.TempDir("", "zim-e2e-")
+		if err != nil {
+			return nil, err
+		}
+		if !opts.Keep {
+			defer os.RemoveAll(tmp)
+		}
+		dir = tmp
+	}
+	if opts.Output == nil {
+		opts.Output = ioutil.Discard
+	}
+	var results []Result
+	for i, s := range scenarios {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		scenarioDir := filepath.Join(dir, fmt.Sprintf("%02d-%s", i+1, s.Name))
+		startedAt := time.Now()
+		err := runScenario(ctx, s, scenarioDir, opts)
+		results = append(results, Result{
+			Name:     s.Name,
+			Dir:      scenarioDir,
+			Duration: time.Since(startedAt),
+			Error:    err,
+		})
+		if !opts.Keep {
+			os.RemoveAll(scenarioDir)
+		}
+	}
+	return results, nil
+}
+
+// runScenario creates the fixture project in the directory and takes each
+// step of the Scenario
+func runScenario(ctx context.Context, s *Scenario, dir string, opts Opts) error {
+	root := filepath.Join(dir, "project")
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := writeFiles(root, s.Files); err != nil {
+		return err
+	}
+	zimCache := cache.New(cache.Opts{Store: filesystem.New(filepath.Join(dir, "cache"))})
+	for i, step := range s.Steps {
+		if err := runStep(ctx, root, zimCache, step, opts); err != nil {
+			name := step.Name
+			if name == "" {
+				name = strings.Join(step.Rules, ",")
+			}
+			return fmt.Errorf("step %d (%s): %s", i+1, name, err)
+		}
+	}
+	return nil
+}
+
+// runStep changes the project's files, runs the build, and checks the
+// outcome against the step's expectations
+func runStep(ctx context.Context, root string, zimCache *cache.Cache, step Step, opts Opts) error {
+	if step.Clean {
+		if err := os.RemoveAll(filepath.Join(root, "artifacts")); err != nil {
+			return err
+		}
+	}
+	for _, name := range step.Remove {
+		if err := os.RemoveAll(filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+	if err := writeFiles(root, step.Write); err != nil {
+		return err
+	}
+	if len(step.Rules) == 0 {
+		return nil
+	}
+
+	// The project is loaded again for each build, as zim run would, so
+	// that changes to definitions take effect
+	projDef, componentDefs, err := project.Discover(root)
+	if err != nil {
+		return err
+	}
+	executor := exec.NewBashExecutor()
+	proj, err := project.NewWithOptions(project.Opts{
+		Root:          root,
+		ProjectDef:    projDef,
+		ComponentDefs: componentDefs,
+		Executor:      executor,
+	})
+	if err != nil {
+		return err
+	}
+	components := proj.Components()
+	if len(step.Components) > 0 {
+		components = components.WithName(step.Components...)
+	}
+	rules := components.Rules(step.Rules)
+	if len(rules) == 0 {
+		return fmt.Errorf("no rules named %s", strings.Join(step.Rules, ", "))
+	}
+
+	codes := &codeRecorder{codes: map[string]project.Code{}}
+	runner := project.NewChain(
+		codes.Middleware,
+		output(opts.Output),
+		project.Logger,
+		cache.NewMiddleware(zimCache),
+	).Then(&project.StandardRunner{Baselines: zimCache})
+
+	buildErr := sched.NewGraphScheduler().Run(ctx, sched.Options{
+		BuildID:  project.UUID(),
+		Rules:    rules,
+		Runner:   runner,
+		Executor: executor,
+	})
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if buildErr != nil && !step.Fail {
+		return fmt.Errorf("build failed: %s", buildErr)
+	}
+	if buildErr == nil && step.Fail {
+		return fmt.Errorf("build succeeded but was expected to fail")
+	}
+	if err := codes.check(step.Expect); err != nil {
+		return err
+	}
+	return checkOutputs(root, step.Outputs)
+}
+
+// output is middleware that sends the output of Rules, and the commands
+// they run, to the writer
+func output(w io.Writer) project.RunnerBuilder {
+	return func(runner project.Runner) project.Runner {
+		return project.RunnerFunc(func(ctx context.Context, r *project.Rule, opts project.RunOpts) (project.Code, error) {
+			opts.Output = w
+			opts.DebugOutput = w
+			return runner.Run(ctx, r, opts)
+		})
+	}
+}
+
+// codeRecorder records the code each Rule finished with
+type codeRecorder struct {
+	mutex sync.Mutex
+	codes map[string]project.Code
+}
+
+// Middleware records the code of each Rule it runs
+func (c *codeRecorder) Middleware(runner project.Runner) project.Runner {
+	return project.RunnerFunc(func(ctx context.Context, r *project.Rule, opts project.RunOpts) (project.Code, error) {
+		code, err := runner.Run(ctx, r, opts)
+		c.mutex.Lock()
+		c.codes[r.NodeID()] = code
+		c.mutex.Unlock()
+		return code, err
+	})
+}
+
+// check compares the recorded codes with the expected ones, and describes
+// every mismatch
+func (c *codeRecorder) check(expect map[string]string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var problems []string
+	for nodeID, expected := range expect {
+		code, ran := c.codes[nodeID]
+		actual := NotRun
+		if ran {
+			actual = code.String()
+		}
+		if actual != expected {
+			problems = append(problems,
+				fmt.Sprintf("rule %s was %s, expected %s", nodeID, actual, expected))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// checkOutputs compares the contents of files in the project with the
+// expected ones
+func checkOutputs(root string, outputs map[string]string) error {
+	var problems []string
+	for name, expected := range outputs {
+		data, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("output %s is missing", name))
+		} else if string(data) != expected {
+			problems = append(problems,
+				fmt.Sprintf("output %s is %q, expected %q", name, string(data), expected))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// writeFiles writes files relative to the root, creating directories as
+// needed
+func writeFiles(root string, files map[string]string) error {
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+// Package e2e runs end-to-end scenarios, in which real builds run in fixture
+// projects and their outcomes are checked. Each scenario is a list of steps
+// that change the project's files and run rules, and the rule codes and
+// output contents that are expected after each build.
+package e2e
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fugue/zim/project"
+	"github.com/go-yaml/yaml"
+)
+
+// NotRun is the expected code of a Rule that must not run, such as one that
+// depends on a Rule that fails
+const NotRun = "not-run"
+
+// Scenario is a fixture project and the steps taken in it
+type Scenario struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+
+	// Files of the project keyed by their paths relative to its root, e.g.
+	// "widget/component.yaml"
+	Files map[string]string `yaml:"files"`
+
+	Steps []Step `yaml:"steps"`
+}
+
+// Step changes the project's files and then runs a build if Rules are given.
+// Changes are made in the order of the fields below.
+type Step struct {
+	Name string `yaml:"name"`
+
+	// Clean removes the artifacts directory so that outputs must be built
+	// or restored from the cache
+	Clean bool `yaml:"clean"`
+
+	// Remove lists files to remove from the project
+	Remove []string `yaml:"remove"`
+
+	// Write creates or replaces files in the project
+	Write map[string]string `yaml:"write"`
+
+	// Rules are the names of the Rules to run, e.g. "build"
+	Rules []string `yaml:"run"`
+
+	// Components limits the Rules run to these Components
+	Components []string `yaml:"components"`
+
+	// Expect maps Rule node IDs, e.g. "widget.build", to the code each Rule
+	// must finish with, e.g. "ok" or "cached", or "not-run"
+	Expect map[string]string `yaml:"expect"`
+
+	// Outputs maps paths relative to the project root to the content those
+	// files must have after the build
+	Outputs map[string]string `yaml:"outputs"`
+
+	// Fail is set if the build is expected to fail
+	Fail bool `yaml:"fail"`
+}
+
+// ParseScenario parses a Scenario from YAML and checks that it is valid
+func ParseScenario(text []byte) (*Scenario, error) {
+	s := &Scenario{}
+	if err := yaml.Unmarshal(text, s); err != nil {
+		return nil, err
+	}
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// LoadScenario loads a Scenario from a YAML file. The Scenario is named
+// after the file if it has no name.
+func LoadScenario(filePath string) (*Scenario, error) {
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	s := &Scenario{}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %s", filePath, err)
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %s", filePath, err)
+	}
+	return s, nil
+}
+
+// Validate checks that the Scenario has steps, that its paths stay within
+// the project, and that expected codes are known
+func (s *Scenario) Validate() error {
+	if s.Name == "" {
+		return errors.New("scenario has no name")
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("scenario %s has no steps", s.Name)
+	}
+	var paths []string
+	for name := range s.Files {
+		paths = append(paths, name)
+	}
+	for i, step := range s.Steps {
+		for name := range step.Write {
+			paths = append(paths, name)
+		}
+		for name := range step.Outputs {
+			paths = append(paths, name)
+		}
+		paths = append(paths, step.Remove...)
+		for nodeID, code := range step.Expect {
+			if _, known := project.ParseCode(code); !known && code != NotRun {
+				return fmt.Errorf("step %d of %s expects unknown code %q for %s",
+					i+1, s.Name, code, nodeID)
+			}
+		}
+		if len(step.Rules) == 0 && (len(step.Expect) > 0 || step.Fail) {
+			return fmt.Errorf("step %d of %s has expectations but runs no rules", i+1, s.Name)
+		}
+	}
+	for _, name := range paths {
+		if !isRelative(name) {
+			return fmt.Errorf("path %s in %s must be relative to the project", name, s.Name)
+		}
+	}
+	return nil
+}
+
+// isRelative returns true if the slash-separated path is within the
+// directory it is relative to
+func isRelative(name string) bool {
+	cleaned := path.Clean(name)
+	return name != "" && !path.IsAbs(cleaned) &&
+		cleaned != "." && cleaned != ".." && !strings.HasPrefix(cleaned, "../")
+}
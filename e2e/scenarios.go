@@ -0,0 +1,189 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package e2e
+
+// builtinScenarios cover behavior that most changes to the scheduler, the
+// runner, or the cache could break
+var builtinScenarios = []string{
+	`
+name: cache
+description: Outputs are restored from the cache until an input changes
+files:
+  widget/component.yaml: |
+    name: widget
+    rules:
+      build:
+        inputs: ["*.txt"]
+        outputs: [widget.out]
+        command: cat *.txt > $ARTIFACT
+  widget/a.txt: "a\n"
+steps:
+  - name: first build
+    run: [build]
+    expect: {widget.build: ok}
+    outputs: {artifacts/widget.out: "a\n"}
+  - name: unchanged
+    clean: true
+    run: [build]
+    expect: {widget.build: cached}
+    outputs: {artifacts/widget.out: "a\n"}
+  - name: changed input
+    write: {widget/a.txt: "b\n"}
+    run: [build]
+    expect: {widget.build: ok}
+    outputs: {artifacts/widget.out: "b\n"}
+  - name: added input
+    write: {widget/c.txt: "c\n"}
+    run: [build]
+    expect: {widget.build: ok}
+    outputs: {artifacts/widget.out: "b\nc\n"}
+  - name: removed input
+    remove: [widget/c.txt]
+    run: [build]
+    expect: {widget.build: cached}
+    outputs: {artifacts/widget.out: "b\n"}
+`,
+	`
+name: dependencies
+description: Keys include dependencies, whose outputs are restored for rules that use them
+files:
+  widget/component.yaml: |
+    name: widget
+    rules:
+      build:
+        inputs: [widget.txt]
+        outputs: [widget.out]
+        command: cat widget.txt > $ARTIFACT
+  widget/widget.txt: "widget\n"
+  app/component.yaml: |
+    name: app
+    rules:
+      build:
+        inputs: [app.txt]
+        requires:
+          - component: widget
+            rule: build
+        outputs: [app.out]
+        command: cat $ARTIFACTS_DIR/widget.out app.txt > $ARTIFACT
+  app/app.txt: "app\n"
+steps:
+  - name: first build
+    run: [build]
+    components: [app]
+    expect: {widget.build: ok, app.build: ok}
+    outputs: {artifacts/app.out: "widget\napp\n"}
+  - name: restored
+    clean: true
+    run: [build]
+    components: [app]
+    expect: {widget.build: cached, app.build: cached}
+    outputs: {artifacts/widget.out: "widget\n", artifacts/app.out: "widget\napp\n"}
+  - name: changed dependency
+    write: {widget/widget.txt: "gadget\n"}
+    run: [build]
+    components: [app]
+    expect: {widget.build: ok, app.build: ok}
+    outputs: {artifacts/app.out: "gadget\napp\n"}
+  - name: changed dependent
+    clean: true
+    write: {app/app.txt: "application\n"}
+    run: [build]
+    components: [app]
+    expect: {widget.build: cached, app.build: ok}
+    outputs: {artifacts/app.out: "gadget\napplication\n"}
+`,
+	`
+name: failures
+description: Failed rules are not cached and the rules that depend on them don't run
+files:
+  widget/component.yaml: |
+    name: widget
+    rules:
+      build:
+        outputs: [widget.out]
+        command: echo broken && exit 1
+  app/component.yaml: |
+    name: app
+    rules:
+      build:
+        requires:
+          - component: widget
+            rule: build
+        outputs: [app.out]
+        command: cp $ARTIFACTS_DIR/widget.out $ARTIFACT
+steps:
+  - name: failed dependency
+    run: [build]
+    fail: true
+    expect: {widget.build: exec-error, app.build: not-run}
+  - name: fixed
+    write:
+      widget/component.yaml: |
+        name: widget
+        rules:
+          build:
+            outputs: [widget.out]
+            command: echo fixed > $ARTIFACT
+    run: [build]
+    expect: {widget.build: ok, app.build: ok}
+    outputs: {artifacts/app.out: "fixed\n"}
+`,
+	`
+name: environment
+description: Keys include the environment of components and rules
+files:
+  widget/component.yaml: |
+    name: widget
+    environment:
+      GREETING: hello
+    rules:
+      build:
+        outputs: [greeting.txt]
+        command: echo $GREETING > $ARTIFACT
+steps:
+  - name: first build
+    run: [build]
+    expect: {widget.build: ok}
+    outputs: {artifacts/greeting.txt: "hello\n"}
+  - name: unchanged
+    run: [build]
+    expect: {widget.build: cached}
+  - name: changed variable
+    write:
+      widget/component.yaml: |
+        name: widget
+        environment:
+          GREETING: hi
+        rules:
+          build:
+            outputs: [greeting.txt]
+            command: echo $GREETING > $ARTIFACT
+    run: [build]
+    expect: {widget.build: ok}
+    outputs: {artifacts/greeting.txt: "hi\n"}
+`,
+}
+
+// Builtin returns the Scenarios run by zim selftest
+func Builtin() ([]*Scenario, error) {
+	var scenarios []*Scenario
+	for _, text := range builtinScenarios {
+		s, err := ParseScenario([]byte(text))
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, s)
+	}
+	return scenarios, nil
+}
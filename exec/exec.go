@@ -18,11 +18,13 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -38,6 +40,149 @@ type ExecOpts struct {
 	Env              []string
 	Image            string
 	Debug            bool
+	Usage            *Usage
+	Images           *Images
+	Network          string
+	Ulimits          []Ulimit
+
+	// DockerNetwork is the network containers are attached to unless
+	// Network is set, e.g. host or a network created with the runtime
+	DockerNetwork string
+
+	// Platform overrides the target platform of the executor
+	Platform string
+
+	// Wrapper is a command that runs bash, given as its trailing
+	// arguments, e.g. ["timeout", "600"]. It runs within the container
+	// when the executor uses one.
+	Wrapper []string
+
+	// Shell reads the command from stdin and runs it. Defaults to bash.
+	Shell string
+
+	// ShellOptions are the arguments given to the shell. Defaults to "-e"
+	// so that the command stops at the first failure.
+	ShellOptions []string
+
+	// CacheVolumes maps the names of volumes that persist between runs to
+	// the paths they are mounted at, when the executor uses containers
+	CacheVolumes map[string]string
+
+	// Attempt is the attempt at running the rule the command belongs to,
+	// counting from one when the rule is retried. Zero for its first run.
+	Attempt int
+}
+
+// DefaultShell runs commands in containers when no shell is given
+const DefaultShell = "bash"
+
+// shellCommand returns the shell and the arguments it is run with. Tracing
+// is enabled with -x in debug mode, except for PowerShell and cmd, which
+// echoes commands unless it is given /Q.
+func shellCommand(opts ExecOpts) []string {
+	shell := opts.Shell
+	if shell == "" {
+		shell = DefaultShell
+	}
+	options := opts.ShellOptions
+	if len(options) == 0 {
+		options = defaultShellOptions(shell, opts.Debug)
+	}
+	command := append([]string{shell}, options...)
+	if opts.Debug && !isPowerShell(shell) && !isCmd(shell) {
+		command = append(command, "-x")
+	}
+	return command
+}
+
+// defaultShellOptions returns the options a shell is run with unless others
+// are given. PowerShell must be told to read the command from stdin, while
+// cmd is given the path to a batch file as its last argument.
+func defaultShellOptions(shell string, debug bool) []string {
+	if isPowerShell(shell) {
+		return []string{"-NoProfile", "-NonInteractive", "-Command", "-"}
+	}
+	if isCmd(shell) {
+		if debug {
+			return []string{"/D", "/C"}
+		}
+		return []string{"/D", "/Q", "/C"}
+	}
+	return []string{"-e"}
+}
+
+// shellScript returns the script given to the shell on its stdin. Unlike
+// the -e option of other shells, PowerShell options can't make it stop at the
+// first error, so the script sets its preferences to do so unless other
+// options are given. Tracing is enabled the same way in debug mode.
+func shellScript(opts ExecOpts) string {
+	if !isPowerShell(opts.Shell) {
+		return opts.Command
+	}
+	var preamble string
+	if len(opts.ShellOptions) == 0 {
+		preamble += "$ErrorActionPreference = 'Stop'\n"
+		preamble += "$PSNativeCommandUseErrorActionPreference = $true\n"
+	}
+	if opts.Debug {
+		preamble += "Set-PSDebug -Trace 1\n"
+	}
+	return preamble + opts.Command
+}
+
+// shellName returns the name of a shell without its directory or extension,
+// which may be a Windows path
+func shellName(shell string) string {
+	name := strings.ToLower(shell[strings.LastIndexAny(shell, `/\`)+1:])
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// isPowerShell returns true if the shell is PowerShell
+func isPowerShell(shell string) bool {
+	name := shellName(shell)
+	return name == "pwsh" || name == "powershell"
+}
+
+// isCmd returns true if the shell is the Windows command interpreter
+func isCmd(shell string) bool {
+	return shellName(shell) == "cmd"
+}
+
+// ShellCommand returns a command that runs a script given as a string with
+// the host's shell, like bash -c
+func ShellCommand(ctx context.Context, script string) *exec.Cmd {
+	var args []string
+	switch {
+	case isPowerShell(HostShell):
+		args = []string{"-NoProfile", "-NonInteractive", "-Command", script}
+	case isCmd(HostShell):
+		args = []string{"/D", "/C", script}
+	default:
+		args = []string{"-c", script}
+	}
+	return exec.CommandContext(ctx, HostShell, args...)
+}
+
+// writeBatchFile writes a script to a temporary batch file for cmd, which
+// can't read a script from its stdin. The caller removes the file.
+func writeBatchFile(script string) (string, error) {
+	f, err := ioutil.TempFile("", "zim-*.cmd")
+	if err != nil {
+		return "", err
+	}
+	// Batch files are expected to have Windows line endings
+	script = strings.Replace(script, "\r\n", "\n", -1)
+	script = strings.Replace(script, "\n", "\r\n", -1)
+	if _, err := io.WriteString(f, script); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
 }
 
 // Executor is an interface for executing commands
@@ -65,25 +210,56 @@ func NewBashExecutor() Executor {
 	return &bashExecutor{}
 }
 
-type bashExecutor struct{}
+// NewWrappedBashExecutor returns an Executor that runs bash via the given
+// wrapper command, which is responsible for running its trailing arguments.
+// For example, a wrapper of "nix develop --command" runs bash within a Nix
+// development environment.
+func NewWrappedBashExecutor(wrapper ...string) Executor {
+	return &bashExecutor{wrapper: wrapper}
+}
+
+type bashExecutor struct {
+	wrapper []string
+}
 
 // Execute runs a command in a subprocess
 func (e *bashExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 
-	environment := append(os.Environ(), opts.Env...)
+	// Commands on the host run with its shell unless another is given
+	if opts.Shell == "" {
+		opts.Shell = HostShell
+	}
+
+	// Network and resource limits are applied via the environment and
+	// the script itself
+	env, script, err := isolate(opts)
+	if err != nil {
+		return err
+	}
+	environment := append(os.Environ(), env...)
 
 	workingDir := opts.WorkingDirectory
 	if workingDir == "" {
 		workingDir = "."
 	}
 
-	// Create list of bash options
-	args := []string{"-e"}
-	if opts.Debug {
-		args = extendSlice(args, "-x")
+	// Prepend the wrapper commands, if there are any. The wrapper given in
+	// the options runs the executor's own wrapper, which runs the shell.
+	command := append(append([]string{}, opts.Wrapper...), e.wrapper...)
+	command = append(command, shellCommand(opts)...)
+
+	// cmd runs the script from a batch file rather than its stdin
+	if isCmd(opts.Shell) {
+		batchFile, err := writeBatchFile(script)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(batchFile)
+		command = append(command, batchFile)
+		script = ""
 	}
 
-	bashCmd := exec.CommandContext(ctx, "bash", args...)
+	bashCmd := exec.CommandContext(ctx, command[0], command[1:]...)
 	bashCmd.Env = environment
 	bashCmd.Dir = workingDir
 	bashCmd.Stdout = getWriter(opts.Stdout, os.Stdout)
@@ -97,7 +273,7 @@ func (e *bashExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 	// Write command to the process' stdin.
 	go func() {
 		defer stdin.Close()
-		io.WriteString(stdin, opts.Command)
+		io.WriteString(stdin, script)
 	}()
 
 	// Show the command to be executed to the user
@@ -109,7 +285,10 @@ func (e *bashExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 	cmdColor := color.New(color.FgMagenta).SprintFunc()
 	fmt.Fprintln(cmdOut, "cmd:", cmdColor(opts.Command))
 
-	return bashCmd.Run()
+	startedAt := time.Now()
+	err = bashCmd.Run()
+	opts.Usage.record(bashCmd.ProcessState, time.Since(startedAt))
+	return err
 }
 
 func (e *bashExecutor) UsesDocker() bool {
@@ -24,17 +24,59 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 )
 
+// invalidContainerChars matches characters not allowed in container names,
+// such as the brackets and slashes in names of matrix rule instances
+var invalidContainerChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
 // DefaultDockerExecutorDir is the path to the execution root within the
 // Docker container
 const DefaultDockerExecutorDir = "/build"
 
+// Container runtimes supported by the container executor. Each provides a
+// CLI that is compatible with the Docker CLI.
+const (
+	RuntimeDocker  = "docker"
+	RuntimePodman  = "podman"
+	RuntimeNerdctl = "nerdctl"
+)
+
+// ContainerRuntimes lists the supported container runtimes in the order of
+// preference used when detecting the runtime
+var ContainerRuntimes = []string{RuntimeDocker, RuntimePodman, RuntimeNerdctl}
+
+// DetectContainerRuntime returns the first supported container runtime with
+// a CLI on the PATH. Docker is returned if none are found.
+func DetectContainerRuntime() string {
+	for _, runtime := range ContainerRuntimes {
+		if _, err := exec.LookPath(runtime); err == nil {
+			return runtime
+		}
+	}
+	return RuntimeDocker
+}
+
 // NewDockerExecutor returns an Executor that runs commands within containers
 func NewDockerExecutor(mountDirectory, platform string) Executor {
+	return NewContainerExecutor(RuntimeDocker, mountDirectory, platform)
+}
+
+// NewContainerExecutor returns an Executor that runs commands within
+// containers using the given runtime: docker, podman, or nerdctl for
+// containerd. If the runtime is empty it is detected automatically.
+func NewContainerExecutor(runtime, mountDirectory, platform string) Executor {
+
+	if runtime == "" {
+		runtime = DetectContainerRuntime()
+	}
 
 	var userID, groupID string
 
@@ -44,31 +86,103 @@ func NewDockerExecutor(mountDirectory, platform string) Executor {
 	}
 
 	return &dockerExecutor{
+		Runtime:        runtime,
 		MountDirectory: mountDirectory,
 		UserID:         userID,
 		GroupID:        groupID,
 		ExecDirectory:  DefaultDockerExecutorDir,
 		Platform:       platform,
+		Retries:        DefaultContainerRetries,
+		RetryDelay:     DefaultContainerRetryDelay,
+	}
+}
+
+// NewOfflineContainerExecutor returns a container Executor that never pulls
+// images. Commands fail if their image isn't already available locally.
+func NewOfflineContainerExecutor(runtime, mountDirectory, platform string) Executor {
+	e := NewContainerExecutor(runtime, mountDirectory, platform).(*dockerExecutor)
+	e.Offline = true
+	return e
+}
+
+// ImageExists returns true if the image is available locally to the
+// container runtime
+func ImageExists(runtime, image string) bool {
+	return exec.Command(runtime, "image", "inspect", image).Run() == nil
+}
+
+// PullImage downloads an image using the container runtime, writing its
+// progress to the given writer
+func PullImage(ctx context.Context, runtime, platform, image string, w io.Writer) error {
+	args := []string{"pull"}
+	if platform != "" {
+		args = extendSlice(args, "--platform", platform)
 	}
+	cmd := exec.CommandContext(ctx, runtime, append(args, image)...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
 }
 
 type dockerExecutor struct {
+	Runtime        string
 	MountDirectory string
 	UserID         string
 	GroupID        string
 	ExecDirectory  string
 	Platform       string
+	Offline        bool
+
+	// Retries is the number of times a command is run again after a
+	// transient failure of the container runtime, with a delay that
+	// starts at RetryDelay and doubles with each retry
+	Retries    int
+	RetryDelay time.Duration
+
+	mutex    sync.Mutex
+	prepared map[string]bool
 }
 
-// Execute runs a command in a container
-func (e *dockerExecutor) Execute(ctx context.Context, opts ExecOpts) error {
+// CacheVolumeName returns the name the container runtime knows a cache
+// volume by. The prefix identifies volumes created by Zim.
+func CacheVolumeName(name string) string {
+	return "zim-cache-" + name
+}
+
+// prepareCacheVolumes gives the user that commands run as ownership of the
+// cache volumes, which the runtime creates owned by root. Each volume is
+// prepared once by the executor. This is best effort: the image may lack
+// chown, and the volume may already be writable.
+func (e *dockerExecutor) prepareCacheVolumes(ctx context.Context, opts ExecOpts) {
+	if e.UserID == "" || e.GroupID == "" || e.Runtime == RuntimePodman {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.prepared == nil {
+		e.prepared = map[string]bool{}
+	}
+	for name := range opts.CacheVolumes {
+		if e.prepared[name] {
+			continue
+		}
+		e.prepared[name] = true
+		exec.CommandContext(ctx, e.Runtime, "run", "--rm", "--user", "0:0",
+			"--volume", CacheVolumeName(name)+":/zim-cache", "--entrypoint", "chown",
+			opts.Image, fmt.Sprintf("%s:%s", e.UserID, e.GroupID), "/zim-cache").Run()
+	}
+}
+
+// runArgs returns the arguments to the container runtime CLI used to run
+// the command
+func (e *dockerExecutor) runArgs(opts ExecOpts) ([]string, error) {
 
 	if opts.Image == "" {
-		return errors.New("Docker image is not specified")
+		return nil, errors.New("Docker image is not specified")
 	}
 	mountDir, err := filepath.Abs(e.MountDirectory)
 	if err != nil {
-		return fmt.Errorf("Invalid mount dir %s: %s", e.MountDirectory, err)
+		return nil, fmt.Errorf("Invalid mount dir %s: %s", e.MountDirectory, err)
 	}
 	workingDir := opts.WorkingDirectory
 	if workingDir == "" {
@@ -76,11 +190,11 @@ func (e *dockerExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 	}
 	workingAbsDir, err := filepath.Abs(workingDir)
 	if err != nil {
-		return fmt.Errorf("Invalid working dir %s: %s", workingDir, err)
+		return nil, fmt.Errorf("Invalid working dir %s: %s", workingDir, err)
 	}
 	workingRelDir, err := filepath.Rel(mountDir, workingAbsDir)
 	if err != nil {
-		return fmt.Errorf("Failed to get relative dir: %s", err)
+		return nil, fmt.Errorf("Failed to get relative dir: %s", err)
 	}
 
 	args := []string{
@@ -97,10 +211,15 @@ func (e *dockerExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 		fmt.Sprintf("GOPATH=%s", path.Join(e.ExecDirectory, ".go")),
 	}
 	if opts.Name != "" {
-		args = extendSlice(args, "--name", opts.Name)
+		args = extendSlice(args, "--name", containerName(opts.Name))
 	}
 	if e.UserID != "" && e.GroupID != "" {
 		args = extendSlice(args, "--user", fmt.Sprintf("%s:%s", e.UserID, e.GroupID))
+		// Rootless Podman maps the host user to root in the container by
+		// default. Keep the host IDs so outputs are owned by the user.
+		if e.Runtime == RuntimePodman {
+			args = extendSlice(args, "--userns", "keep-id")
+		}
 	}
 	if XDGCache() != "" {
 		args = extendSlice(args, "-e", fmt.Sprintf("XDG_CACHE_HOME=%s", path.Join(e.ExecDirectory, ".cache")))
@@ -112,37 +231,64 @@ func (e *dockerExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 		args = extendSlice(args, "--group-add", "root")
 		args = extendSlice(args, "--volume", "/var/run/docker.sock:/var/run/docker.sock")
 	}
-	if e.Platform != "" {
-		args = extendSlice(args, "--platform", e.Platform)
+	platform := e.Platform
+	if opts.Platform != "" {
+		platform = opts.Platform
+	}
+	if platform != "" {
+		args = extendSlice(args, "--platform", platform)
+	}
+	if e.Offline {
+		args = extendSlice(args, "--pull", "never")
+	}
+	network := opts.Network
+	if network == "" {
+		network = opts.DockerNetwork
+	}
+	if network != "" {
+		args = extendSlice(args, "--network", network)
+	}
+	for _, ulimit := range opts.Ulimits {
+		args = extendSlice(args, "--ulimit", ulimit.String())
+	}
+	volumes := make([]string, 0, len(opts.CacheVolumes))
+	for name := range opts.CacheVolumes {
+		volumes = append(volumes, name)
+	}
+	sort.Strings(volumes)
+	for _, name := range volumes {
+		args = extendSlice(args, "--volume",
+			fmt.Sprintf("%s:%s", CacheVolumeName(name), opts.CacheVolumes[name]))
 	}
 	for _, envVar := range opts.Env {
 		args = extendSlice(args, "-e", envVar)
 	}
-	args = extendSlice(args, opts.Image, "bash", "-e")
-	if opts.Debug {
-		args = extendSlice(args, "-x")
-	}
+	args = extendSlice(args, opts.Image)
+	args = extendSlice(args, opts.Wrapper...)
+	args = extendSlice(args, shellCommand(opts)...)
+	return args, nil
+}
 
-	dockerCmd := exec.CommandContext(ctx, "docker", args...)
-	dockerCmd.Stdout = getWriter(opts.Stdout, os.Stdout)
-	dockerCmd.Stderr = getWriter(opts.Stderr, os.Stderr)
+// Execute runs a command in a container. Transient failures of the
+// container runtime, such as a container name conflict or an unavailable
+// daemon, are retried with an increasing, randomized delay. Each retry is
+// logged to the command's stderr along with the attempt of its rule.
+func (e *dockerExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 
-	stdin, err := dockerCmd.StdinPipe()
+	args, err := e.runArgs(opts)
 	if err != nil {
 		return err
 	}
+	e.prepareCacheVolumes(ctx, opts)
 
-	// Write command to the process' stdin.
-	go func() {
-		defer stdin.Close()
-		io.WriteString(stdin, opts.Command)
-	}()
+	stdout := getWriter(opts.Stdout, os.Stdout)
+	stderr := getWriter(opts.Stderr, os.Stderr)
 
 	// Show the command to be executed to the user
 	cmdOut := getWriter(opts.Cmdout, os.Stdout)
 	if opts.Debug {
 		debugColor := color.New(color.FgYellow).SprintFunc()
-		fmt.Fprintln(cmdOut, "dbg:", debugColor(strings.Join(dockerCmd.Args, " ")))
+		fmt.Fprintln(cmdOut, "dbg:", debugColor(strings.Join(append([]string{e.Runtime}, args...), " ")))
 	}
 	cmdColor := color.New(color.FgCyan).SprintFunc()
 	fmt.Fprintln(cmdOut, "cmd:", cmdColor(opts.Command))
@@ -159,14 +305,81 @@ func (e *dockerExecutor) Execute(ctx context.Context, opts ExecOpts) error {
 		// Kill container since the context was canceled
 		case <-ctx.Done():
 			if opts.Name != "" {
-				killContainerWithName(opts.Name)
+				killContainerWithName(e.Runtime, containerName(opts.Name))
 			}
 		}
 	}()
 
+	// The runtime pulls the image when it runs the command if it isn't
+	// available locally
+	var pulled bool
+	if opts.Images != nil && !e.Offline {
+		pulled = !ImageExists(e.Runtime, opts.Image)
+	}
+
+	// Resource usage of the docker CLI process doesn't reflect the container,
+	// so only the wall clock time is recorded for Docker commands
+	startedAt := time.Now()
+	for retry := 1; ; retry++ {
+		tail := &tailWriter{size: 4096}
+		err = e.run(ctx, args, shellScript(opts), stdout, io.MultiWriter(stderr, tail))
+		reason := transientFailure(err, tail.String())
+		if reason == "" || retry > e.Retries || ctx.Err() != nil {
+			break
+		}
+		delay := retryDelay(e.RetryDelay, retry)
+		warnColor := color.New(color.FgYellow).SprintFunc()
+		fmt.Fprintln(stderr, "docker:", warnColor(fmt.Sprintf(
+			"[RETRY %d/%d] %s after %s, retrying in %s%s",
+			retry, e.Retries, opts.Name, reason, delay.Round(time.Millisecond),
+			attemptSuffix(opts.Attempt))))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+	opts.Usage.record(nil, time.Since(startedAt))
+	if opts.Images != nil {
+		opts.Images.record(ImageUse{
+			Image:  opts.Image,
+			Digest: ImageDigest(e.Runtime, opts.Image),
+			Pulled: pulled,
+		})
+	}
+	return err
+}
+
+// run runs the container runtime once, writing the command to its stdin
+func (e *dockerExecutor) run(ctx context.Context, args []string, command string, stdout, stderr io.Writer) error {
+
+	dockerCmd := exec.CommandContext(ctx, e.Runtime, args...)
+	dockerCmd.Stdout = stdout
+	dockerCmd.Stderr = stderr
+
+	stdin, err := dockerCmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// Write command to the process' stdin.
+	go func() {
+		defer stdin.Close()
+		io.WriteString(stdin, command)
+	}()
+
 	return dockerCmd.Run()
 }
 
+// attemptSuffix describes the attempt of the rule a command belongs to,
+// if the rule is being retried
+func attemptSuffix(attempt int) string {
+	if attempt <= 1 {
+		return ""
+	}
+	return fmt.Sprintf(" (rule attempt %d)", attempt)
+}
+
 func (e *dockerExecutor) UsesDocker() bool {
 	return true
 }
@@ -190,6 +403,12 @@ func extendSlice(s []string, item ...string) []string {
 	return s
 }
 
-func killContainerWithName(name string) error {
-	return exec.Command("docker", "rm", "-f", name).Run()
+// containerName returns the name with characters that are not allowed in
+// container names replaced
+func containerName(name string) string {
+	return invalidContainerChars.ReplaceAllString(name, "_")
+}
+
+func killContainerWithName(runtime, name string) error {
+	return exec.Command(runtime, "rm", "-f", name).Run()
 }
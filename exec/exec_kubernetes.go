@@ -0,0 +1,284 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// KubernetesOpts contains options used to configure a Kubernetes executor
+type KubernetesOpts struct {
+
+	// MountDirectory is the host directory, typically the project root,
+	// that is available within pods at DefaultDockerExecutorDir
+	MountDirectory string
+
+	// PersistentVolumeClaim holding the contents of the MountDirectory,
+	// which is mounted into each pod
+	PersistentVolumeClaim string
+
+	// Namespace in which pods are created. Defaults to the kubectl context.
+	Namespace string
+
+	// Image used for commands that don't specify one
+	Image string
+
+	// CPU and Memory requests for each pod, e.g. "500m" and "1Gi"
+	CPU    string
+	Memory string
+}
+
+// NewKubernetesExecutor returns an Executor that runs commands within pods
+// in a Kubernetes cluster using kubectl. The project must be available to the
+// pods via a persistent volume claim.
+func NewKubernetesExecutor(opts KubernetesOpts) Executor {
+	return &kubernetesExecutor{
+		KubernetesOpts: opts,
+		ExecDirectory:  DefaultDockerExecutorDir,
+	}
+}
+
+type kubernetesExecutor struct {
+	KubernetesOpts
+	ExecDirectory string
+}
+
+var invalidPodNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// podName returns a unique pod name derived from the command name
+func podName(name string) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	base := invalidPodNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	base = strings.Trim(base, "-")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	if base == "" {
+		base = "zim"
+	}
+	return fmt.Sprintf("%s-%s", base, hex.EncodeToString(suffix))
+}
+
+// podOverrides returns the pod spec passed to kubectl run
+func (e *kubernetesExecutor) podOverrides(image, workingDir string, opts ExecOpts) (string, error) {
+
+	env := []map[string]string{
+		{"name": "HOME", "value": e.ExecDirectory},
+		{"name": "GOPATH", "value": path.Join(e.ExecDirectory, ".go")},
+	}
+	for _, envVar := range opts.Env {
+		parts := strings.SplitN(envVar, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		env = append(env, map[string]string{"name": parts[0], "value": parts[1]})
+	}
+
+	command := append(append([]string{}, opts.Wrapper...), shellCommand(opts)...)
+
+	container := map[string]interface{}{
+		"name":       "zim",
+		"image":      image,
+		"command":    command,
+		"stdin":      true,
+		"stdinOnce":  true,
+		"workingDir": workingDir,
+		"env":        env,
+	}
+	requests := map[string]string{}
+	if e.CPU != "" {
+		requests["cpu"] = e.CPU
+	}
+	if e.Memory != "" {
+		requests["memory"] = e.Memory
+	}
+	if len(requests) > 0 {
+		container["resources"] = map[string]interface{}{"requests": requests}
+	}
+
+	spec := map[string]interface{}{
+		"restartPolicy": "Never",
+		"containers":    []interface{}{container},
+	}
+	if e.PersistentVolumeClaim != "" {
+		container["volumeMounts"] = []interface{}{
+			map[string]string{"name": "workspace", "mountPath": e.ExecDirectory},
+		}
+		spec["volumes"] = []interface{}{
+			map[string]interface{}{
+				"name": "workspace",
+				"persistentVolumeClaim": map[string]string{
+					"claimName": e.PersistentVolumeClaim,
+				},
+			},
+		}
+	}
+	overrides := map[string]interface{}{
+		"apiVersion": "v1",
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{"app.kubernetes.io/managed-by": "zim"},
+		},
+		"spec": spec,
+	}
+	data, err := json.Marshal(overrides)
+	return string(data), err
+}
+
+// kubectlArgs returns the arguments to kubectl used to run the command
+func (e *kubernetesExecutor) kubectlArgs(name string, opts ExecOpts) ([]string, error) {
+
+	image := opts.Image
+	if image == "" {
+		image = e.Image
+	}
+	if image == "" {
+		return nil, errors.New("Kubernetes image is not specified")
+	}
+	workingDir := opts.WorkingDirectory
+	if workingDir == "" {
+		workingDir = "."
+	}
+	workingAbsDir, err := filepath.Abs(workingDir)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid working dir %s: %s", workingDir, err)
+	}
+	podWorkingDir, err := e.ExecutorPath(workingAbsDir)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := e.podOverrides(image, podWorkingDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{
+		"run", name,
+		"--image", image,
+		"--restart", "Never",
+		"--rm",
+		"-i",
+		"--quiet",
+		"--overrides", overrides,
+	}
+	if e.Namespace != "" {
+		args = extendSlice(args, "--namespace", e.Namespace)
+	}
+	return args, nil
+}
+
+// Execute runs a command in a pod
+func (e *kubernetesExecutor) Execute(ctx context.Context, opts ExecOpts) error {
+
+	// Pods can't be run without a network, so network and resource limits
+	// are applied the same way as for native commands
+	command := opts.Command
+	env, script, err := isolate(opts)
+	if err != nil {
+		return err
+	}
+	opts.Env, opts.Command = env, script
+
+	name := podName(opts.Name)
+	args, err := e.kubectlArgs(name, opts)
+	if err != nil {
+		return err
+	}
+
+	kubectlCmd := exec.CommandContext(ctx, "kubectl", args...)
+	kubectlCmd.Stdout = getWriter(opts.Stdout, os.Stdout)
+	kubectlCmd.Stderr = getWriter(opts.Stderr, os.Stderr)
+
+	stdin, err := kubectlCmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	// Write command to the process' stdin.
+	go func() {
+		defer stdin.Close()
+		io.WriteString(stdin, opts.Command)
+	}()
+
+	// Show the command to be executed to the user
+	cmdOut := getWriter(opts.Cmdout, os.Stdout)
+	if opts.Debug {
+		debugColor := color.New(color.FgYellow).SprintFunc()
+		fmt.Fprintln(cmdOut, "dbg:", debugColor(strings.Join(kubectlCmd.Args, " ")))
+	}
+	cmdColor := color.New(color.FgCyan).SprintFunc()
+	fmt.Fprintln(cmdOut, "cmd:", cmdColor(command))
+
+	// Delete the pod if the context is canceled since it continues to run
+	// after kubectl exits otherwise
+	done := make(chan bool)
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			e.deletePod(name)
+		}
+	}()
+
+	// Only the wall clock time is known for commands run in pods. The image
+	// is resolved by the cluster, so its digest isn't known either.
+	startedAt := time.Now()
+	err = kubectlCmd.Run()
+	opts.Usage.record(nil, time.Since(startedAt))
+	image := opts.Image
+	if image == "" {
+		image = e.Image
+	}
+	opts.Images.record(ImageUse{Image: image})
+	return err
+}
+
+func (e *kubernetesExecutor) deletePod(name string) error {
+	args := []string{"delete", "pod", name, "--wait=false", "--ignore-not-found"}
+	if e.Namespace != "" {
+		args = extendSlice(args, "--namespace", e.Namespace)
+	}
+	return exec.Command("kubectl", args...).Run()
+}
+
+func (e *kubernetesExecutor) UsesDocker() bool {
+	return true
+}
+
+func (e *kubernetesExecutor) ExecutorPath(hostPath string) (string, error) {
+	if !filepath.IsAbs(hostPath) {
+		return "", fmt.Errorf("A relative path was incorrectly passed: %s", hostPath)
+	}
+	relPath, err := filepath.Rel(e.MountDirectory, hostPath)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(e.ExecDirectory, relPath), nil
+}
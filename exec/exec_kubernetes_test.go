@@ -0,0 +1,88 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKubernetesPodName(t *testing.T) {
+	name := podName("My_Component.build")
+	require.Regexp(t, regexp.MustCompile(`^my-component-build-[0-9a-f]{8}$`), name)
+	require.NotEqual(t, name, podName("My_Component.build"))
+	require.Regexp(t, regexp.MustCompile(`^zim-[0-9a-f]{8}$`), podName("..."))
+}
+
+func TestKubernetesArgs(t *testing.T) {
+	e := NewKubernetesExecutor(KubernetesOpts{
+		MountDirectory:        "/repo",
+		PersistentVolumeClaim: "workspace",
+		Namespace:             "ci",
+		Image:                 "golang:1.14",
+		CPU:                   "500m",
+		Memory:                "1Gi",
+	}).(*kubernetesExecutor)
+
+	require.True(t, e.UsesDocker())
+
+	args, err := e.kubectlArgs("foo-1234", ExecOpts{
+		WorkingDirectory: "/repo/src/foo",
+		Env:              []string{"NAME=foo"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, []string{"run", "foo-1234", "--image", "golang:1.14"}, args[:4])
+	require.Equal(t, []string{"--namespace", "ci"}, args[len(args)-2:])
+
+	var overrides struct {
+		Spec struct {
+			Containers []struct {
+				Image      string
+				WorkingDir string
+				Env        []map[string]string
+				Resources  struct {
+					Requests map[string]string
+				}
+				VolumeMounts []map[string]string
+			}
+			Volumes []struct {
+				PersistentVolumeClaim map[string]string
+			}
+		}
+	}
+	require.Nil(t, json.Unmarshal([]byte(args[10]), &overrides))
+	require.Len(t, overrides.Spec.Containers, 1)
+	c := overrides.Spec.Containers[0]
+	require.Equal(t, "/build/src/foo", c.WorkingDir)
+	require.Contains(t, c.Env, map[string]string{"name": "NAME", "value": "foo"})
+	require.Equal(t, map[string]string{"cpu": "500m", "memory": "1Gi"}, c.Resources.Requests)
+	require.Equal(t, "/build", c.VolumeMounts[0]["mountPath"])
+	require.Equal(t, "workspace", overrides.Spec.Volumes[0].PersistentVolumeClaim["claimName"])
+
+	// Images set on the rule take precedence
+	args, err = e.kubectlArgs("foo-1234", ExecOpts{
+		WorkingDirectory: "/repo",
+		Image:            "alpine",
+	})
+	require.Nil(t, err)
+	require.Equal(t, "alpine", args[3])
+
+	// An image is required
+	e.Image = ""
+	_, err = e.kubectlArgs("foo-1234", ExecOpts{WorkingDirectory: "/repo"})
+	require.NotNil(t, err)
+}
@@ -18,8 +18,11 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"golang.org/x/net/context"
@@ -82,3 +85,238 @@ echo bar`,
 	require.Nil(t, err)
 	require.Equal(t, "foo\nbar\n", stdout.String())
 }
+
+func TestBashExecutorUsage(t *testing.T) {
+	dir := testDir()
+	ctx := context.Background()
+	e := NewBashExecutor()
+
+	var usage Usage
+	for i := 0; i < 2; i++ {
+		err := e.Execute(ctx, ExecOpts{
+			Command:          "sleep 0.1",
+			WorkingDirectory: dir,
+			Stdout:           ioutil.Discard,
+			Cmdout:           ioutil.Discard,
+			Usage:            &usage,
+		})
+		require.Nil(t, err)
+	}
+	require.True(t, usage.Wall >= 200*time.Millisecond)
+	require.True(t, usage.MaxRSS > 0)
+}
+
+func TestUsageAdd(t *testing.T) {
+	u := Usage{Wall: time.Second, User: time.Second, MaxRSS: 10}
+	u.Add(Usage{Wall: time.Second, System: time.Second, MaxRSS: 5})
+	require.Equal(t, Usage{
+		Wall:   2 * time.Second,
+		User:   time.Second,
+		System: time.Second,
+		MaxRSS: 10,
+	}, u)
+}
+
+func TestContainerRuntimeArgs(t *testing.T) {
+	opts := ExecOpts{
+		Name:             "foo",
+		WorkingDirectory: "/repo/src/foo",
+		Image:            "alpine",
+	}
+
+	docker := NewContainerExecutor(RuntimeDocker, "/repo", "").(*dockerExecutor)
+	docker.UserID, docker.GroupID = "1000", "1000"
+	args, err := docker.runArgs(opts)
+	require.Nil(t, err)
+	require.Equal(t, "/build/src/foo", args[6])
+	require.Contains(t, args, "1000:1000")
+	require.NotContains(t, args, "--userns")
+
+	podman := NewContainerExecutor(RuntimePodman, "/repo", "").(*dockerExecutor)
+	podman.UserID, podman.GroupID = "1000", "1000"
+	args, err = podman.runArgs(opts)
+	require.Nil(t, err)
+	require.Contains(t, strings.Join(args, " "), "--userns keep-id")
+
+	path, err := podman.ExecutorPath("/repo/src/foo")
+	require.Nil(t, err)
+	require.Equal(t, "/build/src/foo", path)
+
+	detected := NewContainerExecutor("", "/repo", "").(*dockerExecutor)
+	require.Contains(t, ContainerRuntimes, detected.Runtime)
+}
+
+func TestContainerPlatformArgs(t *testing.T) {
+	docker := NewContainerExecutor(RuntimeDocker, "/repo", "linux/amd64").(*dockerExecutor)
+
+	args, err := docker.runArgs(ExecOpts{
+		Name:             "app.release[linux/arm64].0",
+		WorkingDirectory: "/repo/src/app",
+		Image:            "alpine",
+	})
+	require.Nil(t, err)
+	joined := strings.Join(args, " ")
+	require.Contains(t, joined, "--platform linux/amd64")
+	require.Contains(t, joined, "--name app.release_linux_arm64_.0")
+
+	// A rule's platform overrides that of the executor
+	args, err = docker.runArgs(ExecOpts{
+		WorkingDirectory: "/repo/src/app",
+		Image:            "alpine",
+		Platform:         "linux/arm64",
+	})
+	require.Nil(t, err)
+	joined = strings.Join(args, " ")
+	require.Contains(t, joined, "--platform linux/arm64")
+	require.NotContains(t, joined, "linux/amd64")
+}
+
+func TestWrappedBashExecutor(t *testing.T) {
+	e := NewWrappedBashExecutor("env", "WRAPPED=yes")
+
+	var stdout bytes.Buffer
+	err := e.Execute(context.Background(), ExecOpts{
+		Command: "echo $WRAPPED",
+		Stdout:  &stdout,
+		Cmdout:  ioutil.Discard,
+	})
+	require.Nil(t, err)
+	require.Equal(t, "yes", strings.TrimSpace(stdout.String()))
+}
+
+func TestWrapperOption(t *testing.T) {
+	// The wrapper in the options runs the executor's own wrapper
+	e := NewWrappedBashExecutor("env", "INNER=yes")
+
+	var stdout bytes.Buffer
+	err := e.Execute(context.Background(), ExecOpts{
+		Command: "echo $OUTER $INNER",
+		Stdout:  &stdout,
+		Cmdout:  ioutil.Discard,
+		Wrapper: []string{"env", "OUTER=yes"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, "yes yes", strings.TrimSpace(stdout.String()))
+
+	// Containers run the wrapper within them
+	docker := NewContainerExecutor(RuntimeDocker, "/repo", "").(*dockerExecutor)
+	args, err := docker.runArgs(ExecOpts{
+		WorkingDirectory: "/repo/src/app",
+		Image:            "alpine",
+		Wrapper:          []string{"timeout", "600"},
+	})
+	require.Nil(t, err)
+	require.Contains(t, strings.Join(args, " "), "alpine timeout 600 bash -e")
+}
+
+func TestShellOption(t *testing.T) {
+	e := NewBashExecutor()
+
+	// Options replace the default of -e, so a failing pipeline fails
+	// the command only with pipefail
+	var stdout bytes.Buffer
+	err := e.Execute(context.Background(), ExecOpts{
+		Command:      "false | true\necho $0",
+		Stdout:       &stdout,
+		Cmdout:       ioutil.Discard,
+		Shell:        "sh",
+		ShellOptions: []string{"-e"},
+	})
+	require.Nil(t, err)
+	require.Equal(t, "sh", strings.TrimSpace(stdout.String()))
+
+	err = e.Execute(context.Background(), ExecOpts{
+		Command:      "false | true",
+		Stdout:       ioutil.Discard,
+		Cmdout:       ioutil.Discard,
+		ShellOptions: []string{"-e", "-o", "pipefail"},
+	})
+	require.NotNil(t, err)
+
+	require.Equal(t, []string{"bash", "-e", "-x"}, shellCommand(ExecOpts{Debug: true}))
+	require.Equal(t, []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command", "-"},
+		shellCommand(ExecOpts{Shell: "pwsh", Debug: true}))
+
+	// Containers run the shell within them
+	docker := NewContainerExecutor(RuntimeDocker, "/repo", "").(*dockerExecutor)
+	args, err := docker.runArgs(ExecOpts{
+		WorkingDirectory: "/repo/src/app",
+		Image:            "alpine",
+		Shell:            "sh",
+		ShellOptions:     []string{"-eu"},
+	})
+	require.Nil(t, err)
+	require.True(t, strings.HasSuffix(strings.Join(args, " "), "alpine sh -eu"))
+}
+
+func TestCacheVolumeArgs(t *testing.T) {
+	docker := NewContainerExecutor(RuntimeDocker, "/repo", "").(*dockerExecutor)
+	args, err := docker.runArgs(ExecOpts{
+		WorkingDirectory: "/repo/src/app",
+		Image:            "golang",
+		CacheVolumes: map[string]string{
+			"gomod":   "/go/pkg/mod",
+			"gobuild": "/root/.cache/go-build",
+		},
+	})
+	require.Nil(t, err)
+	joined := strings.Join(args, " ")
+	require.Contains(t, joined,
+		"--volume zim-cache-gobuild:/root/.cache/go-build --volume zim-cache-gomod:/go/pkg/mod")
+}
+
+func TestPowerShellScript(t *testing.T) {
+	require.Equal(t, "make", shellScript(ExecOpts{Command: "make", Debug: true}))
+
+	// PowerShell is told to stop at the first error unless given options
+	script := shellScript(ExecOpts{Command: "make", Shell: "pwsh"})
+	require.Equal(t, "$ErrorActionPreference = 'Stop'\n"+
+		"$PSNativeCommandUseErrorActionPreference = $true\nmake", script)
+	script = shellScript(ExecOpts{
+		Command:      "make",
+		Shell:        "/usr/bin/pwsh",
+		ShellOptions: []string{"-Command", "-"},
+		Debug:        true,
+	})
+	require.Equal(t, "Set-PSDebug -Trace 1\nmake", script)
+
+	// Resource limits are applied with ulimit, which PowerShell lacks
+	_, _, err := isolate(ExecOpts{
+		Command: "make",
+		Shell:   "pwsh",
+		Ulimits: []Ulimit{{Name: "nofile", Soft: 64, Hard: 64}},
+	})
+	require.NotNil(t, err)
+}
+
+func TestCmdShell(t *testing.T) {
+	require.True(t, isCmd(`C:\Windows\System32\CMD.EXE`))
+	require.True(t, isPowerShell(`C:\Program Files\PowerShell\7\pwsh.exe`))
+
+	// cmd echoes commands in debug mode instead of being given -x
+	require.Equal(t, []string{"cmd", "/D", "/Q", "/C"}, shellCommand(ExecOpts{Shell: "cmd"}))
+	require.Equal(t, []string{"cmd.exe", "/D", "/C"},
+		shellCommand(ExecOpts{Shell: "cmd.exe", Debug: true}))
+
+	// The script is written to a batch file with Windows line endings
+	batchFile, err := writeBatchFile("echo one\necho two")
+	require.Nil(t, err)
+	defer os.Remove(batchFile)
+	require.Equal(t, ".cmd", filepath.Ext(batchFile))
+	data, err := ioutil.ReadFile(batchFile)
+	require.Nil(t, err)
+	require.Equal(t, "echo one\r\necho two", string(data))
+
+	_, _, err = isolate(ExecOpts{
+		Command: "make",
+		Shell:   "cmd",
+		Ulimits: []Ulimit{{Name: "nofile", Soft: 64, Hard: 64}},
+	})
+	require.NotNil(t, err)
+}
+
+func TestShellCommand(t *testing.T) {
+	output, err := ShellCommand(context.Background(), "echo $((1 + 2))").Output()
+	require.Nil(t, err)
+	require.Equal(t, "3\n", string(output))
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"encoding/json"
+	"os/exec"
+	"strings"
+)
+
+// ImageUse identifies the container image that commands ran in. The digest
+// is resolved when the command runs, so it records exactly which image a
+// tag referred to at the time.
+type ImageUse struct {
+	Image  string `json:"image"`
+	Digest string `json:"digest,omitempty"`
+	Pulled bool   `json:"pulled,omitempty"`
+}
+
+// Images records the container images used while executing commands. When
+// set in ExecOpts, a container Executor adds the image of each command.
+type Images struct {
+	Uses []ImageUse `json:"uses"`
+}
+
+// record adds an image to the list if it isn't already present. A nil
+// Images is ignored so callers need not check whether recording was
+// requested.
+func (i *Images) record(use ImageUse) {
+	if i == nil || use.Image == "" {
+		return
+	}
+	for idx, existing := range i.Uses {
+		if existing.Image == use.Image && existing.Digest == use.Digest {
+			i.Uses[idx].Pulled = existing.Pulled || use.Pulled
+			return
+		}
+	}
+	i.Uses = append(i.Uses, use)
+}
+
+// ImageDigest returns the digest of a local image using the container
+// runtime, e.g. "golang@sha256:...". Images that were built locally and
+// never pushed have no repository digest, so their ID is returned instead.
+// An empty string is returned if the image can't be inspected.
+func ImageDigest(runtime, image string) string {
+	out, err := exec.Command(runtime, "image", "inspect",
+		"--format", "{{json .RepoDigests}}", image).Output()
+	if err != nil {
+		return ""
+	}
+	var digests []string
+	if err := json.Unmarshal(out, &digests); err == nil && len(digests) > 0 {
+		return digests[0]
+	}
+	out, err = exec.Command(runtime, "image", "inspect", "--format", "{{.Id}}", image).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,38 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestImagesRecord(t *testing.T) {
+
+	// Recording to nil Images is ignored
+	var none *Images
+	none.record(ImageUse{Image: "alpine"})
+
+	images := &Images{}
+	images.record(ImageUse{Image: "golang:1.14", Digest: "golang@sha256:abc"})
+	images.record(ImageUse{Image: "golang:1.14", Digest: "golang@sha256:abc", Pulled: true})
+	images.record(ImageUse{Image: "golang:1.14", Digest: "golang@sha256:def"})
+	images.record(ImageUse{})
+
+	require.Equal(t, []ImageUse{
+		{Image: "golang:1.14", Digest: "golang@sha256:abc", Pulled: true},
+		{Image: "golang:1.14", Digest: "golang@sha256:def"},
+	}, images.Uses)
+}
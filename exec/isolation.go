@@ -0,0 +1,137 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NetworkNone runs commands without network access. Containers are given no
+// network, while native commands are configured to fail network requests.
+const NetworkNone = "none"
+
+// ulimitFlags maps Docker ulimit names to the equivalent bash ulimit flags
+var ulimitFlags = map[string]string{
+	"core":       "-c",
+	"cpu":        "-t",
+	"data":       "-d",
+	"fsize":      "-f",
+	"locks":      "-x",
+	"memlock":    "-l",
+	"msgqueue":   "-q",
+	"nice":       "-e",
+	"nofile":     "-n",
+	"nproc":      "-u",
+	"rss":        "-m",
+	"rtprio":     "-r",
+	"sigpending": "-i",
+	"stack":      "-s",
+}
+
+// Ulimit is a resource limit applied to a command
+type Ulimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+// ParseUlimits parses limits given as a soft limit or as soft and hard limits
+// separated by a colon, e.g. {"nofile": "1024:2048"}. The result is sorted.
+func ParseUlimits(limits map[string]string) ([]Ulimit, error) {
+	var result []Ulimit
+	for name, value := range limits {
+		if _, found := ulimitFlags[name]; !found {
+			return nil, fmt.Errorf("unknown ulimit: %s", name)
+		}
+		parts := strings.SplitN(value, ":", 2)
+		soft, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+		if err != nil || soft < 0 {
+			return nil, fmt.Errorf("invalid ulimit %s: %s", name, value)
+		}
+		hard := soft
+		if len(parts) == 2 {
+			hard, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64)
+			if err != nil || hard < soft {
+				return nil, fmt.Errorf("invalid ulimit %s: %s", name, value)
+			}
+		}
+		result = append(result, Ulimit{Name: name, Soft: soft, Hard: hard})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result, nil
+}
+
+// String returns the limit in Docker's --ulimit format
+func (u Ulimit) String() string {
+	return fmt.Sprintf("%s=%d:%d", u.Name, u.Soft, u.Hard)
+}
+
+// ulimitScript returns bash commands that apply the limits. The soft limit
+// is set first since a hard limit can't be lowered beneath the soft limit.
+func ulimitScript(ulimits []Ulimit) string {
+	var lines []string
+	for _, u := range ulimits {
+		flag := ulimitFlags[u.Name]
+		lines = append(lines,
+			fmt.Sprintf("ulimit -S %s %d", flag, u.Soft),
+			fmt.Sprintf("ulimit -H %s %d", flag, u.Hard))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// offlineEnvironment returns environment variables that send network
+// requests to a closed local port and put common package managers in offline
+// mode. This discourages, but does not prevent, network access.
+func offlineEnvironment() []string {
+	proxy := "http://127.0.0.1:9"
+	return []string{
+		"http_proxy=" + proxy,
+		"https_proxy=" + proxy,
+		"all_proxy=" + proxy,
+		"HTTP_PROXY=" + proxy,
+		"HTTPS_PROXY=" + proxy,
+		"ALL_PROXY=" + proxy,
+		"no_proxy=",
+		"NO_PROXY=",
+		"GOPROXY=off",
+		"npm_config_offline=true",
+		"YARN_ENABLE_OFFLINE_MODE=1",
+		"PIP_NO_INDEX=1",
+		"CARGO_NET_OFFLINE=true",
+	}
+}
+
+// isolate returns the environment and script used to run a command natively
+// with the network and resource limits requested in the options. Resource
+// limits are set with ulimit, which PowerShell and cmd don't have.
+func isolate(opts ExecOpts) (env []string, script string, err error) {
+	env = opts.Env
+	if opts.Network == NetworkNone {
+		env = append(append([]string{}, env...), offlineEnvironment()...)
+	}
+	script = shellScript(opts)
+	if len(opts.Ulimits) > 0 {
+		if isPowerShell(opts.Shell) || isCmd(opts.Shell) {
+			return nil, "", fmt.Errorf("ulimits can't be applied to %s commands outside of Docker",
+				shellName(opts.Shell))
+		}
+		script = ulimitScript(opts.Ulimits) + "\n" + script
+	}
+	return env, script, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUlimits(t *testing.T) {
+	ulimits, err := ParseUlimits(map[string]string{
+		"nproc":  "512",
+		"nofile": "256:1024",
+	})
+	require.Nil(t, err)
+	require.Equal(t, []Ulimit{
+		{Name: "nofile", Soft: 256, Hard: 1024},
+		{Name: "nproc", Soft: 512, Hard: 512},
+	}, ulimits)
+	require.Equal(t, "nofile=256:1024", ulimits[0].String())
+
+	_, err = ParseUlimits(map[string]string{"files": "10"})
+	require.NotNil(t, err)
+	_, err = ParseUlimits(map[string]string{"nofile": "many"})
+	require.NotNil(t, err)
+	_, err = ParseUlimits(map[string]string{"nofile": "1024:256"})
+	require.NotNil(t, err)
+}
+
+func TestBashExecutorIsolation(t *testing.T) {
+	e := NewBashExecutor()
+
+	var stdout bytes.Buffer
+	err := e.Execute(context.Background(), ExecOpts{
+		Command: "ulimit -S -n; echo $HTTPS_PROXY $GOPROXY",
+		Stdout:  &stdout,
+		Cmdout:  ioutil.Discard,
+		Network: NetworkNone,
+		Ulimits: []Ulimit{{Name: "nofile", Soft: 64, Hard: 128}},
+	})
+	require.Nil(t, err)
+	require.Equal(t, "64\nhttp://127.0.0.1:9 off", strings.TrimSpace(stdout.String()))
+}
+
+func TestContainerIsolationArgs(t *testing.T) {
+	docker := NewContainerExecutor(RuntimeDocker, "/repo", "").(*dockerExecutor)
+	args, err := docker.runArgs(ExecOpts{
+		WorkingDirectory: "/repo/src/foo",
+		Image:            "alpine",
+		Network:          NetworkNone,
+		Ulimits:          []Ulimit{{Name: "nofile", Soft: 64, Hard: 128}},
+	})
+	require.Nil(t, err)
+	joined := strings.Join(args, " ")
+	require.Contains(t, joined, "--network none")
+	require.Contains(t, joined, "--ulimit nofile=64:128")
+
+	// A Docker network is used unless the command has no network
+	args, err = docker.runArgs(ExecOpts{
+		WorkingDirectory: "/repo/src/foo",
+		Image:            "alpine",
+		DockerNetwork:    "host",
+	})
+	require.Nil(t, err)
+	require.Contains(t, strings.Join(args, " "), "--network host")
+
+	args, err = docker.runArgs(ExecOpts{
+		WorkingDirectory: "/repo/src/foo",
+		Image:            "alpine",
+		Network:          NetworkNone,
+		DockerNetwork:    "host",
+	})
+	require.Nil(t, err)
+	joined = strings.Join(args, " ")
+	require.Contains(t, joined, "--network none")
+	require.NotContains(t, joined, "host")
+}
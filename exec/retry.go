@@ -0,0 +1,100 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"math/rand"
+	"os/exec"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// Default retry behavior for transient failures of the container runtime
+const (
+	DefaultContainerRetries    = 3
+	DefaultContainerRetryDelay = 500 * time.Millisecond
+)
+
+// runtimeExitCode is the exit code of "docker run" when the runtime itself
+// fails, rather than the command in the container. Podman and nerdctl use
+// the same code.
+const runtimeExitCode = 125
+
+// transientRuntimeError matches a message from the container runtime that
+// is likely to succeed if the command is run again
+type transientRuntimeError struct {
+	pattern *regexp.Regexp
+	reason  string
+}
+
+// transientRuntimeErrors lists the failures of the container runtime that
+// are retried. A container name conflict happens when a container from an
+// earlier run of a rule, such as one that timed out, is still being removed.
+var transientRuntimeErrors = []transientRuntimeError{
+	{regexp.MustCompile(`(?i)name .* is already in use`), "container name conflict"},
+	{regexp.MustCompile(`(?i)cannot connect to the docker daemon|error during connect`), "daemon unavailable"},
+	{regexp.MustCompile(`(?i)connection reset by peer|connection refused|i/o timeout|tls handshake timeout|unexpected eof`), "connection error"},
+	{regexp.MustCompile(`(?i)device or resource busy|resource temporarily unavailable`), "resource busy"},
+}
+
+// transientFailure returns the reason a run of the container runtime failed
+// if the failure is transient, or an empty string otherwise. Failures of
+// the command within the container are never transient.
+func transientFailure(err error, stderr string) string {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != runtimeExitCode {
+		return ""
+	}
+	for _, transient := range transientRuntimeErrors {
+		if transient.pattern.MatchString(stderr) {
+			return transient.reason
+		}
+	}
+	return ""
+}
+
+// retryDelay returns the time to wait before the given retry, counting from
+// one. The delay doubles with each retry and is randomized by up to half in
+// either direction so that rules that failed together don't retry together.
+func retryDelay(base time.Duration, retry int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	delay := base << uint(retry-1)
+	return delay/2 + time.Duration(rand.Int63n(int64(delay)))
+}
+
+// tailWriter keeps the last bytes written to it, up to its size
+type tailWriter struct {
+	mutex sync.Mutex
+	size  int
+	buf   []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.size {
+		w.buf = w.buf[len(w.buf)-w.size:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) String() string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return string(w.buf)
+}
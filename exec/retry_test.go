@@ -0,0 +1,107 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTransientFailure(t *testing.T) {
+	runtimeErr := exec.Command("sh", "-c", "exit 125").Run()
+	commandErr := exec.Command("sh", "-c", "exit 1").Run()
+
+	conflict := `docker: Error response from daemon: Conflict. The container name "/app.test.0" is already in use by container "4f2a".`
+	require.Equal(t, "container name conflict", transientFailure(runtimeErr, conflict))
+	require.Equal(t, "daemon unavailable", transientFailure(runtimeErr,
+		"docker: Cannot connect to the Docker daemon at unix:///var/run/docker.sock."))
+	require.Equal(t, "", transientFailure(runtimeErr, "docker: invalid reference format."))
+
+	// Failures of the command itself are never retried
+	require.Equal(t, "", transientFailure(commandErr, conflict))
+	require.Equal(t, "", transientFailure(nil, conflict))
+}
+
+func TestRetryDelay(t *testing.T) {
+	for retry := 1; retry <= 4; retry++ {
+		delay := retryDelay(time.Second, retry)
+		nominal := time.Second << uint(retry-1)
+		require.True(t, delay >= nominal/2 && delay < nominal*3/2, delay)
+	}
+	require.Equal(t, time.Duration(0), retryDelay(0, 1))
+}
+
+func TestContainerRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+
+	// A fake runtime that reports a name conflict the first time it runs
+	marker := filepath.Join(dir, "ran")
+	runtime := filepath.Join(dir, "docker")
+	require.Nil(t, ioutil.WriteFile(runtime, []byte(fmt.Sprintf(`#!/bin/sh
+cat > /dev/null
+if [ ! -f %s ]; then
+  touch %s
+  echo 'docker: Error response from daemon: Conflict. The container name "/app.test.0" is already in use.' >&2
+  exit 125
+fi
+echo ran
+`, marker, marker)), 0755))
+
+	e := &dockerExecutor{
+		Runtime:        runtime,
+		MountDirectory: dir,
+		ExecDirectory:  DefaultDockerExecutorDir,
+		Retries:        2,
+		RetryDelay:     time.Millisecond,
+	}
+	var stdout, stderr bytes.Buffer
+	err = e.Execute(context.Background(), ExecOpts{
+		Name:             "app.test.0",
+		Command:          "echo hi",
+		WorkingDirectory: dir,
+		Image:            "alpine",
+		Stdout:           &stdout,
+		Stderr:           &stderr,
+		Cmdout:           ioutil.Discard,
+		Attempt:          2,
+	})
+	require.Nil(t, err)
+	require.Equal(t, "ran\n", stdout.String())
+	require.Contains(t, stderr.String(), "[RETRY 1/2] app.test.0 after container name conflict")
+	require.Contains(t, stderr.String(), "(rule attempt 2)")
+
+	// Failures that aren't transient are returned immediately
+	e.Runtime = "false"
+	stderr.Reset()
+	err = e.Execute(context.Background(), ExecOpts{
+		Command:          "echo hi",
+		WorkingDirectory: dir,
+		Image:            "alpine",
+		Stdout:           ioutil.Discard,
+		Stderr:           &stderr,
+		Cmdout:           ioutil.Discard,
+	})
+	require.NotNil(t, err)
+	require.False(t, strings.Contains(stderr.String(), "RETRY"))
+}
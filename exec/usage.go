@@ -0,0 +1,55 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package exec
+
+import (
+	"os"
+	"time"
+)
+
+// Usage records resources consumed while executing commands. When set in
+// ExecOpts, an Executor adds the usage of each command it runs.
+type Usage struct {
+	Wall   time.Duration `json:"wall"`
+	User   time.Duration `json:"user"`
+	System time.Duration `json:"system"`
+	MaxRSS int64         `json:"max_rss"`
+}
+
+// Add accumulates another Usage into this one. Times are summed while the
+// peak memory is the maximum of the two.
+func (u *Usage) Add(other Usage) {
+	u.Wall += other.Wall
+	u.User += other.User
+	u.System += other.System
+	if other.MaxRSS > u.MaxRSS {
+		u.MaxRSS = other.MaxRSS
+	}
+}
+
+// record adds the usage of a finished process. A nil Usage is ignored so
+// callers need not check whether accounting was requested.
+func (u *Usage) record(state *os.ProcessState, wall time.Duration) {
+	if u == nil {
+		return
+	}
+	sample := Usage{Wall: wall}
+	if state != nil {
+		sample.User = state.UserTime()
+		sample.System = state.SystemTime()
+		sample.MaxRSS = maxRSS(state)
+	}
+	u.Add(sample)
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fault
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/store"
+)
+
+// DefaultMaxDelay is the longest delay injected when none is configured
+const DefaultMaxDelay = time.Second
+
+// Config sets how often each kind of fault is injected into the cache store
+// and command executors. Injecting faults tests that the scheduler,
+// middleware, and retry logic behave correctly when things go wrong. It is
+// enabled with the undocumented ZIM_FAULT_INJECT setting, e.g.
+// "store=0.1,delay=0.2,kill=0.05,max-delay=2s,seed=42". The seed makes the
+// faults repeatable for a given order of operations.
+type Config struct {
+
+	// Store is the probability that a store operation fails
+	Store float64
+
+	// Delay is the probability that a command starts after a delay
+	Delay float64
+
+	// Kill is the probability that a command is killed while it runs
+	Kill float64
+
+	// MaxDelay bounds the delays before commands start and the time
+	// commands run before they are killed
+	MaxDelay time.Duration
+
+	// Seed initializes the random source. The time is used if zero.
+	Seed int64
+}
+
+// Error is returned by operations that failed due to an injected fault
+type Error string
+
+func (e Error) Error() string { return "injected fault: " + string(e) }
+
+// ParseConfig parses a list of comma separated settings, each a name and a
+// value separated by "="
+func ParseConfig(s string) (Config, error) {
+	cfg := Config{MaxDelay: DefaultMaxDelay}
+	for _, setting := range strings.Split(s, ",") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		parts := strings.SplitN(setting, "=", 2)
+		if len(parts) != 2 {
+			return cfg, fmt.Errorf("invalid fault setting: %s", setting)
+		}
+		name, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		var err error
+		switch name {
+		case "store":
+			cfg.Store, err = parseProbability(value)
+		case "delay":
+			cfg.Delay, err = parseProbability(value)
+		case "kill":
+			cfg.Kill, err = parseProbability(value)
+		case "max-delay":
+			cfg.MaxDelay, err = time.ParseDuration(value)
+			if err == nil && cfg.MaxDelay <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return cfg, fmt.Errorf("unknown fault setting: %s", name)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid fault setting %s: %s", setting, err)
+		}
+	}
+	return cfg, nil
+}
+
+func parseProbability(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("must be between 0 and 1")
+	}
+	return p, nil
+}
+
+// Injector decides when faults occur. A nil Injector never injects faults,
+// so that callers need not check whether injection is enabled.
+type Injector struct {
+	cfg    Config
+	mutex  sync.Mutex
+	random *rand.Rand
+}
+
+// NewInjector returns an Injector using the given configuration
+func NewInjector(cfg Config) *Injector {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	if cfg.MaxDelay <= 0 {
+		cfg.MaxDelay = DefaultMaxDelay
+	}
+	return &Injector{cfg: cfg, random: rand.New(rand.NewSource(seed))}
+}
+
+// happens returns true with the given probability
+func (i *Injector) happens(probability float64) bool {
+	if probability <= 0 {
+		return false
+	}
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.random.Float64() < probability
+}
+
+// duration returns a random duration up to the maximum delay
+func (i *Injector) duration() time.Duration {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return time.Duration(i.random.Int63n(int64(i.cfg.MaxDelay)))
+}
+
+// Store returns a Store that fails operations at random. Stores that can be
+// listed still can be.
+func (i *Injector) Store(s store.Store) store.Store {
+	if i == nil || s == nil {
+		return s
+	}
+	faulty := &faultyStore{Store: s, injector: i}
+	if lister, ok := s.(store.ListDeleter); ok {
+		return &faultyListStore{faultyStore: faulty, lister: lister}
+	}
+	return faulty
+}
+
+// Executor returns an Executor that delays and kills commands at random
+func (i *Injector) Executor(e exec.Executor) exec.Executor {
+	if i == nil || e == nil {
+		return e
+	}
+	return &faultyExecutor{Executor: e, injector: i}
+}
+
+type faultyStore struct {
+	store.Store
+	injector *Injector
+}
+
+// fail returns an injected error for the operation with the configured
+// probability, or nil
+func (s *faultyStore) fail(op, key string) error {
+	if s.injector.happens(s.injector.cfg.Store) {
+		return Error(fmt.Sprintf("store %s %s", op, key))
+	}
+	return nil
+}
+
+func (s *faultyStore) Get(ctx context.Context, key, dst string) error {
+	if err := s.fail("get", key); err != nil {
+		return err
+	}
+	return s.Store.Get(ctx, key, dst)
+}
+
+func (s *faultyStore) Put(ctx context.Context, key, src string, meta map[string]string) error {
+	if err := s.fail("put", key); err != nil {
+		return err
+	}
+	return s.Store.Put(ctx, key, src, meta)
+}
+
+func (s *faultyStore) Head(ctx context.Context, key string) (store.ItemMeta, error) {
+	if err := s.fail("head", key); err != nil {
+		return store.ItemMeta{}, err
+	}
+	return s.Store.Head(ctx, key)
+}
+
+type faultyListStore struct {
+	*faultyStore
+	lister store.ListDeleter
+}
+
+func (s *faultyListStore) List(ctx context.Context, prefix string) ([]store.Item, error) {
+	if err := s.fail("list", prefix); err != nil {
+		return nil, err
+	}
+	return s.lister.List(ctx, prefix)
+}
+
+func (s *faultyListStore) Delete(ctx context.Context, key string) error {
+	if err := s.fail("delete", key); err != nil {
+		return err
+	}
+	return s.lister.Delete(ctx, key)
+}
+
+type faultyExecutor struct {
+	exec.Executor
+	injector *Injector
+}
+
+// Execute runs the command after an optional delay. A command that is
+// killed has its context canceled after a random time, which also stops
+// the container of a container executor.
+func (e *faultyExecutor) Execute(ctx context.Context, opts exec.ExecOpts) error {
+	i := e.injector
+	if i.happens(i.cfg.Delay) {
+		select {
+		case <-time.After(i.duration()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if !i.happens(i.cfg.Kill) {
+		return e.Executor.Execute(ctx, opts)
+	}
+	after := i.duration()
+	killCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	timer := time.AfterFunc(after, cancel)
+	defer timer.Stop()
+	err := e.Executor.Execute(killCtx, opts)
+	if killCtx.Err() != nil && ctx.Err() == nil {
+		return Error(fmt.Sprintf("command %s killed after %s", opts.Name, after))
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fault
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig("store=0.1, delay=0.5,kill=1,max-delay=2s,seed=42")
+	require.Nil(t, err)
+	require.Equal(t, Config{
+		Store:    0.1,
+		Delay:    0.5,
+		Kill:     1,
+		MaxDelay: 2 * time.Second,
+		Seed:     42,
+	}, cfg)
+
+	cfg, err = ParseConfig("")
+	require.Nil(t, err)
+	require.Equal(t, Config{MaxDelay: DefaultMaxDelay}, cfg)
+
+	_, err = ParseConfig("store=2")
+	require.Equal(t, "invalid fault setting store=2: must be between 0 and 1", err.Error())
+	_, err = ParseConfig("disk=0.5")
+	require.Equal(t, "unknown fault setting: disk", err.Error())
+	_, err = ParseConfig("kill")
+	require.Equal(t, "invalid fault setting: kill", err.Error())
+}
+
+func TestNilInjector(t *testing.T) {
+	var injector *Injector
+	s := filesystem.New(os.TempDir())
+	require.Equal(t, store.Store(s), injector.Store(s))
+	e := exec.NewBashExecutor()
+	require.Equal(t, e, injector.Executor(e))
+}
+
+func TestStoreFaults(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src.txt")
+	require.Nil(t, ioutil.WriteFile(src, []byte("hello"), 0644))
+
+	s := NewInjector(Config{Store: 1}).Store(filesystem.New(filepath.Join(dir, "store")))
+	err = s.Put(ctx, "key", src, nil)
+	require.Equal(t, "injected fault: store put key", err.Error())
+	_, ok := err.(Error)
+	require.True(t, ok)
+
+	// Stores that can be listed still can be
+	_, ok = s.(store.ListDeleter)
+	require.True(t, ok)
+
+	// Operations succeed when no faults are injected
+	s = NewInjector(Config{}).Store(filesystem.New(filepath.Join(dir, "store")))
+	require.Nil(t, s.Put(ctx, "key", src, nil))
+	_, err = s.Head(ctx, "key")
+	require.Nil(t, err)
+}
+
+func TestExecutorFaults(t *testing.T) {
+	ctx := context.Background()
+
+	// Killed commands fail with an injected fault
+	e := NewInjector(Config{Kill: 1, MaxDelay: 50 * time.Millisecond}).Executor(exec.NewBashExecutor())
+	startedAt := time.Now()
+	err := e.Execute(ctx, exec.ExecOpts{
+		Name:    "app.test.0",
+		Command: "exec sleep 5",
+		Stdout:  ioutil.Discard,
+		Cmdout:  ioutil.Discard,
+	})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "injected fault: command app.test.0 killed after")
+	require.True(t, time.Since(startedAt) < 5*time.Second)
+	require.False(t, e.UsesDocker())
+
+	// Delayed commands still succeed
+	e = NewInjector(Config{Delay: 1, MaxDelay: 10 * time.Millisecond}).Executor(exec.NewBashExecutor())
+	require.Nil(t, e.Execute(ctx, exec.ExecOpts{
+		Command: "true",
+		Stdout:  ioutil.Discard,
+		Cmdout:  ioutil.Discard,
+	}))
+}
+
+func TestInjectorSeed(t *testing.T) {
+	sequence := func() []bool {
+		injector := NewInjector(Config{Seed: 42})
+		var result []bool
+		for i := 0; i < 20; i++ {
+			result = append(result, injector.happens(0.5))
+		}
+		return result
+	}
+	require.Equal(t, sequence(), sequence())
+}
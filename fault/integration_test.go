@@ -0,0 +1,112 @@
+//go:build faulttest
+// +build faulttest
+
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package fault
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/cache"
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/project"
+	"github.com/fugue/zim/sched"
+	"github.com/fugue/zim/store/filesystem"
+	"github.com/stretchr/testify/require"
+)
+
+// TestBuildWithFaults runs a build whose commands are delayed and killed and
+// whose cache operations fail at random. Rules are retried enough that the
+// build must succeed, with its outputs intact, unless a failure is mishandled
+// by the scheduler or middleware. Run with: go test -tags faulttest ./fault
+func TestBuildWithFaults(t *testing.T) {
+
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "zim-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"widget", "app"} {
+		require.Nil(t, os.MkdirAll(path.Join(dir, name), 0755))
+	}
+	retries := definitions.Retries{Count: 25, On: "exec_error, error"}
+	defs := []*definitions.Component{
+		{
+			Path: path.Join(dir, "widget", "component.yaml"),
+			Name: "widget",
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Outputs: []string{"widget.txt"},
+					Command: "sleep 0.1 && echo widget > $ARTIFACT",
+					Retries: retries,
+				},
+			},
+		},
+		{
+			Path: path.Join(dir, "app", "component.yaml"),
+			Name: "app",
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Requires: []definitions.Dependency{{Component: "widget", Rule: "build"}},
+					Outputs:  []string{"app.txt"},
+					Command:  "sleep 0.1 && cat $ARTIFACTS_DIR/widget.txt > $ARTIFACT",
+					Retries:  retries,
+				},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+
+	injector := NewInjector(Config{
+		Store:    0.2,
+		Delay:    0.5,
+		Kill:     0.3,
+		MaxDelay: 50 * time.Millisecond,
+		Seed:     1,
+	})
+	zimCache := cache.New(cache.Opts{
+		Store: injector.Store(filesystem.New(path.Join(dir, "cache"))),
+	})
+	runner := project.NewChain(project.Retry, cache.NewMiddleware(zimCache)).
+		Then(&project.StandardRunner{})
+
+	// The second build restores outputs from the cache, or builds them
+	// again when the cache fails
+	for i := 0; i < 2; i++ {
+		os.Remove(path.Join(dir, "artifacts", "widget.txt"))
+		os.Remove(path.Join(dir, "artifacts", "app.txt"))
+
+		err = sched.NewGraphScheduler().Run(ctx, sched.Options{
+			BuildID:    project.UUID(),
+			Runner:     runner,
+			Executor:   injector.Executor(exec.NewBashExecutor()),
+			Rules:      p.Components().Rules([]string{"build"}),
+			NumWorkers: 2,
+		})
+		require.Nil(t, err)
+
+		data, err := ioutil.ReadFile(path.Join(dir, "artifacts", "app.txt"))
+		require.Nil(t, err)
+		require.Equal(t, "widget\n", string(data))
+	}
+}
@@ -194,6 +194,40 @@ func Table(opts TableOpts) ([]string, error) {
 	return rows, nil
 }
 
+// Output formats supported by commands that print rows
+const (
+	TableFormat = "table"
+	JSONFormat  = "json"
+)
+
+// JSON renders the chosen columns of the given data items as an indented
+// JSON array of objects, keyed by the column names in snake case. Unlike
+// Table, an empty array is rendered if there are no rows.
+func JSON(opts TableOpts) (string, error) {
+
+	if len(opts.Columns) == 0 {
+		return "", errors.New("No columns to display")
+	}
+
+	items := []map[string]interface{}{}
+	for _, row := range getRowMaps(opts.Rows) {
+		item := map[string]interface{}{}
+		for _, column := range opts.Columns {
+			value, ok := row[column]
+			if !ok {
+				return "", fmt.Errorf("Item has no attribute: %s", column)
+			}
+			item[toSnakeCase(column)] = value
+		}
+		items = append(items, item)
+	}
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
 // NormalizeStrings normalizes a slice of strings to all uppercase
 func NormalizeStrings(input []string) []string {
 	output := make([]string, len(input))
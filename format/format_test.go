@@ -86,3 +86,43 @@ func TestFormatTableNoHeader(t *testing.T) {
 		}
 	}
 }
+
+func TestFormatJSON(t *testing.T) {
+
+	items := []interface{}{
+		item{"hank", 32, true, 42},
+		item{"bobby", 1, false, 44},
+	}
+
+	text, err := JSON(TableOpts{
+		Rows:    items,
+		Columns: []string{"Name", "Married", "FavoriteNumber"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[
+  {
+    "favorite_number": 42,
+    "married": true,
+    "name": "hank"
+  },
+  {
+    "favorite_number": 44,
+    "married": false,
+    "name": "bobby"
+  }
+]`
+	if text != expected {
+		t.Errorf("Got: '%s' Expected: '%s'", text, expected)
+	}
+
+	text, err = JSON(TableOpts{Columns: []string{"Name"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "[]" {
+		t.Errorf("Got: '%s' Expected: '[]'", text)
+	}
+}
@@ -8,7 +8,7 @@ require (
 	github.com/bmatcuk/doublestar v1.1.5
 	github.com/fatih/color v1.7.0
 	github.com/fatih/structs v1.1.0
-	github.com/fsnotify/fsnotify v1.4.9 // indirect
+	github.com/fsnotify/fsnotify v1.4.9
 	github.com/go-yaml/yaml v2.1.0+incompatible
 	github.com/golang/mock v1.4.1
 	github.com/hashicorp/go-multierror v1.0.0
@@ -30,4 +30,5 @@ require (
 	gonum.org/v1/gonum v0.6.0
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
+	lukechampine.com/blake3 v1.1.7
 )
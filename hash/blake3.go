@@ -0,0 +1,51 @@
+package hash
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+
+	"lukechampine.com/blake3"
+)
+
+type blake3Hasher struct{}
+
+func BLAKE3() Hasher {
+	return &blake3Hasher{}
+}
+
+func (hasher *blake3Hasher) Name() string {
+	return BLAKE3Name
+}
+
+func (hasher *blake3Hasher) Object(obj interface{}) (string, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return "", err
+	}
+	h := blake3.New(32, nil)
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (hasher *blake3Hasher) File(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := blake3.New(32, nil)
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (hasher *blake3Hasher) String(s string) (string, error) {
+	h := blake3.New(32, nil)
+	if _, err := h.Write([]byte(s)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
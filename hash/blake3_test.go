@@ -0,0 +1,31 @@
+package hash
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlake3(t *testing.T) {
+	// BLAKE3 hash of the empty input from the reference test vectors
+
+	var err error
+	var value string
+
+	expected := "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"
+
+	h := BLAKE3()
+
+	value, err = h.String("")
+	require.Nil(t, err)
+	require.Equal(t, expected, value)
+
+	f, err := ioutil.TempFile("", "zim-test-")
+	require.Nil(t, err)
+	f.Close()
+
+	value, err = h.File(f.Name())
+	require.Nil(t, err)
+	require.Equal(t, expected, value)
+}
@@ -0,0 +1,217 @@
+package hash
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"lukechampine.com/blake3"
+)
+
+// DefaultChunkSize is the size of the chunks large files are hashed in
+const DefaultChunkSize = 16 << 20
+
+// ChunkOpts configures incremental hashing of large files
+type ChunkOpts struct {
+
+	// IndexPath is the file where chunk hashes are saved between runs
+	IndexPath string
+
+	// Threshold is the size at which files are hashed in chunks
+	Threshold int64
+
+	// ChunkSize is the size of each chunk, DefaultChunkSize if zero
+	ChunkSize int64
+}
+
+// chunkedFile is the index entry for one large file
+type chunkedFile struct {
+	Size   int64   `json:"size"`
+	Hash   string  `json:"hash"`
+	Chunks []chunk `json:"chunks"`
+}
+
+// chunk is the index entry for one chunk of a large file. Check is the
+// BLAKE3 digest of its content and Hash its hash with the configured Hasher.
+type chunk struct {
+	Check string `json:"check"`
+	Hash  string `json:"hash"`
+}
+
+type chunkedHasher struct {
+	Hasher
+	opts   ChunkOpts
+	mutex  sync.Mutex
+	files  map[string]*chunkedFile
+	loaded bool
+}
+
+// Chunked returns a Hasher that hashes files at least as large as the
+// threshold in fixed size chunks. The hash of a large file is the hash of
+// its chunk hashes and size. Every chunk is read each time, but the hash of
+// a chunk is saved in an index together with a BLAKE3 digest of its content
+// and reused while the digest matches. This saves time when the given
+// Hasher is slower than BLAKE3, without trusting file sizes or modification
+// times to detect changes. Smaller files, objects, and strings use the given
+// Hasher.
+func Chunked(hasher Hasher, opts ChunkOpts) Hasher {
+	if opts.ChunkSize <= 0 {
+		opts.ChunkSize = DefaultChunkSize
+	}
+	return &chunkedHasher{Hasher: hasher, opts: opts}
+}
+
+func (hasher *chunkedHasher) File(filePath string) (string, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", err
+	}
+	if info.Size() < hasher.opts.Threshold {
+		return hasher.Hasher.File(filePath)
+	}
+	absPath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", err
+	}
+	previous := hasher.lookup(absPath)
+	entry, changed, err := hasher.hashChunks(absPath, previous)
+	if err != nil {
+		return "", err
+	}
+	if changed {
+		if err := hasher.store(absPath, entry); err != nil {
+			return "", err
+		}
+	}
+	return entry.Hash, nil
+}
+
+// hashChunks reads the file and hashes each chunk, reusing the hashes of
+// chunks whose content matches the previous index entry. It also returns
+// whether the entry differs from the previous one.
+func (hasher *chunkedHasher) hashChunks(filePath string, previous *chunkedFile) (*chunkedFile, bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	entry := &chunkedFile{}
+	changed := previous == nil
+	buf := make([]byte, hasher.opts.ChunkSize)
+	var hashes []string
+	for i := 0; ; i++ {
+		n, err := io.ReadFull(file, buf)
+		if err == io.EOF {
+			break
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, false, err
+		}
+		sum := blake3.Sum256(buf[:n])
+		c := chunk{Check: hex.EncodeToString(sum[:])}
+		if previous != nil && i < len(previous.Chunks) && previous.Chunks[i].Check == c.Check {
+			c.Hash = previous.Chunks[i].Hash
+		} else {
+			changed = true
+			if c.Hash, err = hasher.Hasher.String(string(buf[:n])); err != nil {
+				return nil, false, err
+			}
+		}
+		entry.Size += int64(n)
+		entry.Chunks = append(entry.Chunks, c)
+		hashes = append(hashes, c.Hash)
+		if n < len(buf) {
+			break
+		}
+	}
+	if !changed && entry.Size == previous.Size && previous.Hash != "" {
+		entry.Hash = previous.Hash
+		return entry, false, nil
+	}
+	entry.Hash, err = hasher.Hasher.String(
+		fmt.Sprintf("%d\n%s", entry.Size, strings.Join(hashes, "\n")))
+	if err != nil {
+		return nil, false, err
+	}
+	return entry, true, nil
+}
+
+// lookup returns the index entry for a file, loading the index if needed
+func (hasher *chunkedHasher) lookup(filePath string) *chunkedFile {
+	hasher.mutex.Lock()
+	defer hasher.mutex.Unlock()
+	hasher.load()
+	return hasher.files[filePath]
+}
+
+// load reads the saved index. A missing or unreadable index is treated as
+// empty since it is only an optimization.
+func (hasher *chunkedHasher) load() {
+	if hasher.loaded {
+		return
+	}
+	hasher.loaded = true
+	hasher.files = map[string]*chunkedFile{}
+	if hasher.opts.IndexPath == "" {
+		return
+	}
+	data, err := ioutil.ReadFile(hasher.opts.IndexPath)
+	if err != nil {
+		return
+	}
+	var index struct {
+		Algorithm string                  `json:"algorithm"`
+		ChunkSize int64                   `json:"chunk_size"`
+		Files     map[string]*chunkedFile `json:"files"`
+	}
+	if err := json.Unmarshal(data, &index); err != nil {
+		return
+	}
+	if index.Algorithm == hasher.Name() && index.ChunkSize == hasher.opts.ChunkSize && index.Files != nil {
+		hasher.files = index.Files
+	}
+}
+
+// store records the index entry for a file and saves the index
+func (hasher *chunkedHasher) store(filePath string, entry *chunkedFile) error {
+	hasher.mutex.Lock()
+	defer hasher.mutex.Unlock()
+	hasher.load()
+	hasher.files[filePath] = entry
+	if hasher.opts.IndexPath == "" {
+		return nil
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"algorithm":  hasher.Name(),
+		"chunk_size": hasher.opts.ChunkSize,
+		"files":      hasher.files,
+	})
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(hasher.opts.IndexPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so the index is never left partial
+	tmp, err := ioutil.TempFile(dir, ".chunks-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), hasher.opts.IndexPath)
+}
@@ -0,0 +1,76 @@
+package hash
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingHasher counts the strings it hashes
+type countingHasher struct {
+	Hasher
+	strings int
+}
+
+func (h *countingHasher) String(s string) (string, error) {
+	h.strings++
+	return h.Hasher.String(s)
+}
+
+func TestChunked(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data")
+	require.Nil(t, ioutil.WriteFile(path, []byte("aaaabbbbcc"), 0644))
+
+	counter := &countingHasher{Hasher: SHA256()}
+	opts := ChunkOpts{
+		IndexPath: filepath.Join(dir, "index", "chunks.json"),
+		Threshold: 8,
+		ChunkSize: 4,
+	}
+	h := Chunked(counter, opts)
+	require.Equal(t, SHA256Name, h.Name())
+
+	// Three chunks are hashed, then the hash of their hashes
+	first, err := h.File(path)
+	require.Nil(t, err)
+	require.Equal(t, 4, counter.strings)
+
+	// Hashes of unchanged chunks are reused, even by a new hasher
+	counter.strings = 0
+	value, err := Chunked(counter, opts).File(path)
+	require.Nil(t, err)
+	require.Equal(t, first, value)
+	require.Equal(t, 0, counter.strings)
+
+	// Only modified chunks are hashed again, then the hash of the hashes.
+	// Changes are found even if the size and modification time are kept.
+	info, err := os.Stat(path)
+	require.Nil(t, err)
+	require.Nil(t, ioutil.WriteFile(path, []byte("aaaaBBBBcc"), 0644))
+	require.Nil(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+	second, err := h.File(path)
+	require.Nil(t, err)
+	require.NotEqual(t, first, second)
+	require.Equal(t, 2, counter.strings)
+
+	// The result doesn't depend on the index
+	counter.strings = 0
+	value, err = Chunked(counter, ChunkOpts{Threshold: 8, ChunkSize: 4}).File(path)
+	require.Nil(t, err)
+	require.Equal(t, second, value)
+	require.Equal(t, 4, counter.strings)
+
+	// Small files are hashed normally
+	small := filepath.Join(dir, "small")
+	require.Nil(t, ioutil.WriteFile(small, []byte("1234"), 0644))
+	value, err = h.File(small)
+	require.Nil(t, err)
+	require.Equal(t, "03ac674216f3e15c761ee1a5e255f067953623c8b388b4459e13f978d7c846f4", value)
+}
@@ -1,9 +1,24 @@
 package hash
 
+import "fmt"
+
+// Names of the supported hash algorithms
+const (
+	SHA1Name   = "sha1"
+	SHA256Name = "sha256"
+	BLAKE3Name = "blake3"
+)
+
+// DefaultName is the hash algorithm used when none is selected
+const DefaultName = SHA256Name
+
 // Hasher is an interface for hashing objects, files, or strings.
 // Different implementations may exist for SHA1, SHA256, etc.
 type Hasher interface {
 
+	// Name returns the name of the hash algorithm, e.g. "sha256"
+	Name() string
+
 	// Object returns the hash of a given object
 	Object(obj interface{}) (string, error)
 
@@ -13,3 +28,20 @@ type Hasher interface {
 	// String returns the hash of a given string
 	String(s string) (string, error)
 }
+
+// New returns the Hasher for the named algorithm: sha1, sha256, or blake3.
+// The default algorithm is used if the name is empty.
+func New(name string) (Hasher, error) {
+	switch name {
+	case "":
+		return New(DefaultName)
+	case SHA1Name:
+		return SHA1(), nil
+	case SHA256Name:
+		return SHA256(), nil
+	case BLAKE3Name:
+		return BLAKE3(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm: %s (sha1 | sha256 | blake3)", name)
+	}
+}
@@ -0,0 +1,61 @@
+package hash
+
+import (
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	for _, name := range []string{SHA1Name, SHA256Name, BLAKE3Name} {
+		h, err := New(name)
+		require.Nil(t, err)
+		require.Equal(t, name, h.Name())
+	}
+
+	h, err := New("")
+	require.Nil(t, err)
+	require.Equal(t, DefaultName, h.Name())
+
+	_, err = New("md5")
+	require.NotNil(t, err)
+}
+
+// benchmarkFile hashes a file of the given size with each algorithm
+func benchmarkFile(b *testing.B, size int64) {
+	f, err := ioutil.TempFile("", "zim-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	data := make([]byte, 1<<20)
+	for written := int64(0); written < size; written += int64(len(data)) {
+		rand.Read(data)
+		if _, err := f.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+	f.Close()
+
+	for _, h := range []Hasher{SHA1(), SHA256(), BLAKE3()} {
+		b.Run(h.Name(), func(b *testing.B) {
+			b.SetBytes(size)
+			for i := 0; i < b.N; i++ {
+				if _, err := h.File(f.Name()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkFile1MB(b *testing.B) {
+	benchmarkFile(b, 1<<20)
+}
+
+func BenchmarkFile256MB(b *testing.B) {
+	benchmarkFile(b, 256<<20)
+}
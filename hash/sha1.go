@@ -14,6 +14,10 @@ func SHA1() Hasher {
 	return &sha1Hasher{}
 }
 
+func (hasher *sha1Hasher) Name() string {
+	return SHA1Name
+}
+
 func (hasher *sha1Hasher) Object(obj interface{}) (string, error) {
 	data, err := json.Marshal(obj)
 	if err != nil {
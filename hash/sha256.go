@@ -14,6 +14,10 @@ func SHA256() Hasher {
 	return &sha256Hasher{}
 }
 
+func (hasher *sha256Hasher) Name() string {
+	return SHA256Name
+}
+
 func (hasher *sha256Hasher) Object(obj interface{}) (string, error) {
 	data, err := json.Marshal(obj)
 	if err != nil {
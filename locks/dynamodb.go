@@ -0,0 +1,241 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package locks
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/fugue/zim/project"
+)
+
+// DefaultTTL is how long a lock is held without being renewed before it is
+// considered stale and may be taken over
+const DefaultTTL = 5 * time.Minute
+
+// DynamoDB acquires locks using conditional writes to a DynamoDB table whose
+// partition key is the string attribute "name". Each lock records its owner
+// and the Unix time it expires at in "expires_at", which may be used as the
+// table's TTL attribute so that abandoned locks are deleted. Held locks are
+// renewed in the background until they are released. The AWS session is
+// only created when the first lock is acquired.
+type DynamoDB struct {
+	table  string
+	region string
+	ttl    time.Duration
+	mutex  sync.Mutex
+	client dynamodbiface.DynamoDBAPI
+	now    func() time.Time
+}
+
+// NewDynamoDB returns a Locker that uses the table in the given AWS region.
+// The region is taken from the AWS configuration if it is empty, and the
+// TTL defaults to DefaultTTL.
+func NewDynamoDB(table, region string, ttl time.Duration) *DynamoDB {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &DynamoDB{table: table, region: region, ttl: ttl, now: time.Now}
+}
+
+// NewDynamoDBWithClient returns a Locker that uses the given client
+func NewDynamoDBWithClient(client dynamodbiface.DynamoDBAPI, table string, ttl time.Duration) *DynamoDB {
+	d := NewDynamoDB(table, "", ttl)
+	d.client = client
+	return d
+}
+
+// TryAcquire writes the lock unless another owner holds it and it hasn't
+// expired. An expired lock is taken over and its owner is returned.
+func (d *DynamoDB) TryAcquire(ctx context.Context, name, owner string) (project.Lock, string, error) {
+
+	client, err := d.connect()
+	if err != nil {
+		return nil, "", err
+	}
+	output, err := client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"name":       {S: aws.String(name)},
+			"owner":      {S: aws.String(owner)},
+			"expires_at": d.expiresAt(),
+		},
+		ConditionExpression: aws.String(
+			"attribute_not_exists(#name) OR #expires_at < :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#name":       aws.String("name"),
+			"#expires_at": aws.String("expires_at"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": unixTime(d.now()),
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+	})
+	if isConditionFailed(err) {
+		holder, err := d.holder(ctx, client, name)
+		return nil, holder, err
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	// An existing item means the lock expired and was taken over
+	var previous string
+	if old, found := output.Attributes["owner"]; found && old.S != nil {
+		previous = *old.S
+	}
+	lock := &dynamoLock{
+		locker: d,
+		client: client,
+		name:   name,
+		owner:  owner,
+		done:   make(chan bool),
+	}
+	go lock.renew()
+	return lock, previous, nil
+}
+
+// connect creates the DynamoDB client if it doesn't exist yet
+func (d *DynamoDB) connect() (dynamodbiface.DynamoDBAPI, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.client != nil {
+		return d.client, nil
+	}
+	cfg := aws.NewConfig().WithMaxRetries(8)
+	if d.region != "" {
+		cfg = cfg.WithRegion(d.region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.client = dynamodb.New(sess)
+	return d.client, nil
+}
+
+// holder returns the owner of a lock that couldn't be acquired. The lock
+// may have been released since, in which case the owner is unknown.
+func (d *DynamoDB) holder(ctx context.Context, client dynamodbiface.DynamoDBAPI, name string) (string, error) {
+	output, err := client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name": {S: aws.String(name)},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if owner, found := output.Item["owner"]; found && owner.S != nil {
+		return *owner.S, nil
+	}
+	return "unknown", nil
+}
+
+// expiresAt returns the time a lock written now expires at
+func (d *DynamoDB) expiresAt() *dynamodb.AttributeValue {
+	return unixTime(d.now().Add(d.ttl))
+}
+
+// dynamoLock is a lock held in a DynamoDB table
+type dynamoLock struct {
+	locker *DynamoDB
+	client dynamodbiface.DynamoDBAPI
+	name   string
+	owner  string
+	once   sync.Once
+	done   chan bool
+}
+
+// renew extends the expiration of the lock periodically until it is
+// released. Failures are retried at the next interval. If the lock was
+// lost, e.g. after a long network partition, renewal stops.
+func (l *dynamoLock) renew() {
+	ticker := time.NewTicker(l.locker.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+		}
+		_, err := l.client.UpdateItem(&dynamodb.UpdateItemInput{
+			TableName:           aws.String(l.locker.table),
+			Key:                 l.key(),
+			UpdateExpression:    aws.String("SET #expires_at = :expires_at"),
+			ConditionExpression: aws.String("#owner = :owner"),
+			ExpressionAttributeNames: map[string]*string{
+				"#owner":      aws.String("owner"),
+				"#expires_at": aws.String("expires_at"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":owner":      {S: aws.String(l.owner)},
+				":expires_at": l.locker.expiresAt(),
+			},
+		})
+		if isConditionFailed(err) {
+			return
+		}
+	}
+}
+
+// Release stops renewing the lock and deletes it, unless another owner has
+// taken it over since
+func (l *dynamoLock) Release() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		_, err = l.client.DeleteItem(&dynamodb.DeleteItemInput{
+			TableName:           aws.String(l.locker.table),
+			Key:                 l.key(),
+			ConditionExpression: aws.String("#owner = :owner"),
+			ExpressionAttributeNames: map[string]*string{
+				"#owner": aws.String("owner"),
+			},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":owner": {S: aws.String(l.owner)},
+			},
+		})
+		if isConditionFailed(err) {
+			err = nil
+		}
+	})
+	return err
+}
+
+func (l *dynamoLock) key() map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{"name": {S: aws.String(l.name)}}
+}
+
+// unixTime returns a number attribute holding the time in Unix seconds
+func unixTime(t time.Time) *dynamodb.AttributeValue {
+	return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))}
+}
+
+// isConditionFailed returns true if a write failed because its condition
+// wasn't met
+func isConditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package locks
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDynamoDB implements the conditional writes made by the locker
+type fakeDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	mutex sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+}
+
+func conditionFailed() error {
+	return awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+}
+
+func (f *fakeDynamoDB) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := *input.Item["name"].S
+	old, found := f.items[name]
+	if found {
+		expiresAt, _ := strconv.ParseInt(*old["expires_at"].N, 10, 64)
+		now, _ := strconv.ParseInt(*input.ExpressionAttributeValues[":now"].N, 10, 64)
+		if expiresAt >= now {
+			return nil, conditionFailed()
+		}
+	}
+	f.items[name] = input.Item
+	return &dynamodb.PutItemOutput{Attributes: old}, nil
+}
+
+func (f *fakeDynamoDB) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return &dynamodb.GetItemOutput{Item: f.items[*input.Key["name"].S]}, nil
+}
+
+func (f *fakeDynamoDB) UpdateItem(input *dynamodb.UpdateItemInput) (*dynamodb.UpdateItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	item, found := f.items[*input.Key["name"].S]
+	if !found || *item["owner"].S != *input.ExpressionAttributeValues[":owner"].S {
+		return nil, conditionFailed()
+	}
+	item["expires_at"] = input.ExpressionAttributeValues[":expires_at"]
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (f *fakeDynamoDB) DeleteItem(input *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	name := *input.Key["name"].S
+	item, found := f.items[name]
+	if !found || *item["owner"].S != *input.ExpressionAttributeValues[":owner"].S {
+		return nil, conditionFailed()
+	}
+	delete(f.items, name)
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func TestDynamoDBLocks(t *testing.T) {
+	ctx := context.Background()
+	client := &fakeDynamoDB{items: map[string]map[string]*dynamodb.AttributeValue{}}
+	now := time.Unix(1600000000, 0)
+	locker := NewDynamoDBWithClient(client, "zim-locks", time.Minute)
+	locker.now = func() time.Time { return now }
+
+	lock, previous, err := locker.TryAcquire(ctx, "deploy-prod", "ci-1")
+	require.Nil(t, err)
+	require.NotNil(t, lock)
+	require.Equal(t, "", previous)
+
+	// Another owner can't acquire the lock while it is held
+	other, holder, err := locker.TryAcquire(ctx, "deploy-prod", "ci-2")
+	require.Nil(t, err)
+	require.Nil(t, other)
+	require.Equal(t, "ci-1", holder)
+
+	// Once released it is available again
+	require.Nil(t, lock.Release())
+	require.Nil(t, lock.Release())
+	other, _, err = locker.TryAcquire(ctx, "deploy-prod", "ci-2")
+	require.Nil(t, err)
+	require.NotNil(t, other)
+
+	// A lock that expired without being renewed is taken over, and the
+	// release of the previous owner then leaves it alone
+	now = now.Add(2 * time.Minute)
+	lock, previous, err = locker.TryAcquire(ctx, "deploy-prod", "ci-3")
+	require.Nil(t, err)
+	require.NotNil(t, lock)
+	require.Equal(t, "ci-2", previous)
+	require.Nil(t, other.Release())
+	require.Equal(t, "ci-3", *client.items["deploy-prod"]["owner"].S)
+	require.Nil(t, lock.Release())
+	require.Empty(t, client.items)
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// AllowedFailure is the error of a Rule that is allowed to fail. The build
+// continues and the failure is reported as a warning.
+type AllowedFailure struct {
+	Err error
+}
+
+func (e *AllowedFailure) Error() string {
+	return e.Err.Error()
+}
+
+// IsAllowedFailure returns true if the error is the failure of a Rule that
+// is allowed to fail
+func IsAllowedFailure(err error) bool {
+	_, ok := err.(*AllowedFailure)
+	return ok
+}
+
+// AllowedFailures tracks Rules that failed while allowed to fail. Use its
+// Middleware in a Chain to enable allow_failure.
+type AllowedFailures struct {
+	mutex  sync.Mutex
+	failed map[*Rule]bool
+}
+
+// NewAllowedFailures returns an empty AllowedFailures
+func NewAllowedFailures() *AllowedFailures {
+	return &AllowedFailures{failed: map[*Rule]bool{}}
+}
+
+// Middleware marks the failures of Rules that are allowed to fail with an
+// AllowedFailure error. Rules that depend on them, directly or indirectly,
+// are skipped since their dependencies didn't produce outputs. It must be
+// chained above Retry so that a Rule that succeeds when retried isn't
+// marked as failed.
+func (a *AllowedFailures) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		if dep := a.failedDependency(r); dep != nil {
+			output := opts.Output
+			if output == nil {
+				output = os.Stdout
+			}
+			fmt.Fprintln(output, "rule:", Bright(r.NodeID()), Yellow(fmt.Sprintf(
+				"[WARNING] dependency %s failed", dep.NodeID())))
+			a.add(r)
+			return Skipped, nil
+		}
+		code, err := runner.Run(ctx, r, opts)
+		if err != nil && r.AllowFailure() && ctx.Err() == nil {
+			a.add(r)
+			return code, &AllowedFailure{Err: err}
+		}
+		return code, err
+	})
+}
+
+// failedDependency returns a dependency of the Rule that failed, or was
+// skipped because of a failure, or nil if there is none
+func (a *AllowedFailures) failedDependency(r *Rule) *Rule {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, dep := range r.Dependencies() {
+		if a.failed[dep] {
+			return dep
+		}
+	}
+	return nil
+}
+
+func (a *AllowedFailures) add(r *Rule) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.failed[r] = true
+}
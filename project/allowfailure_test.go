@@ -0,0 +1,122 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestAllowedFailures(t *testing.T) {
+
+	c := &Component{name: "app"}
+	lint := &Rule{component: c, name: "lint", allowFailure: true}
+	report := &Rule{component: c, name: "report", resolvedDeps: []*Rule{lint}}
+	publish := &Rule{component: c, name: "publish", resolvedDeps: []*Rule{report}}
+	test := &Rule{component: c, name: "test"}
+	build := &Rule{component: c, name: "build"}
+
+	var ran []string
+	summary := NewSummary()
+	runner := NewChain(summary.Middleware, NewAllowedFailures().Middleware).Then(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			ran = append(ran, r.Name())
+			if r == build {
+				return OK, nil
+			}
+			return ExecError, errors.New("failed")
+		}))
+
+	ctx := context.Background()
+	opts := RunOpts{Output: ioutil.Discard}
+
+	// The failure of a rule that is allowed to fail is marked
+	code, err := runner.Run(ctx, lint, opts)
+	require.Equal(t, ExecError, code)
+	require.True(t, IsAllowedFailure(err))
+
+	// Rules depending on it are skipped, directly or indirectly
+	code, err = runner.Run(ctx, report, opts)
+	require.Nil(t, err)
+	require.Equal(t, Skipped, code)
+	code, err = runner.Run(ctx, publish, opts)
+	require.Nil(t, err)
+	require.Equal(t, Skipped, code)
+
+	// Other failures are unaffected
+	code, err = runner.Run(ctx, test, opts)
+	require.Equal(t, ExecError, code)
+	require.NotNil(t, err)
+	require.False(t, IsAllowedFailure(err))
+	_, err = runner.Run(ctx, build, opts)
+	require.Nil(t, err)
+
+	require.Equal(t, []string{"lint", "test", "build"}, ran)
+
+	results := summary.Results()
+	require.Len(t, results, 5)
+	require.Equal(t, "app.lint", results[1].Rule)
+	require.True(t, results[1].Warning)
+	require.Equal(t, "app.test", results[4].Rule)
+	require.False(t, results[4].Warning)
+}
+
+func TestAllowedFailuresRetried(t *testing.T) {
+
+	c := &Component{name: "app"}
+	retry := RetryPolicy{Count: 1, On: []Code{ExecError}}
+	lint := &Rule{component: c, name: "lint", allowFailure: true, retry: retry}
+	report := &Rule{component: c, name: "report", resolvedDeps: []*Rule{lint}}
+
+	attempts := map[string]int{}
+	failures := 1
+	run := RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		attempts[r.Name()]++
+		if r == lint && attempts["lint"] <= failures {
+			return ExecError, errors.New("failed")
+		}
+		return OK, nil
+	})
+	runner := NewChain(NewAllowedFailures().Middleware, Retry).Then(run)
+
+	ctx := context.Background()
+	opts := RunOpts{Output: ioutil.Discard}
+
+	// A rule that succeeds when retried isn't a failure
+	code, err := runner.Run(ctx, lint, opts)
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+	require.Equal(t, 2, attempts["lint"])
+	code, err = runner.Run(ctx, report, opts)
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+	require.Equal(t, 1, attempts["report"])
+
+	// A rule that fails every attempt is
+	attempts = map[string]int{}
+	failures = 2
+	runner = NewChain(NewAllowedFailures().Middleware, Retry).Then(run)
+	code, err = runner.Run(ctx, lint, opts)
+	require.True(t, IsAllowedFailure(err))
+	require.Equal(t, ExecError, code)
+	require.Equal(t, 2, attempts["lint"])
+	code, err = runner.Run(ctx, report, opts)
+	require.Nil(t, err)
+	require.Equal(t, Skipped, code)
+	require.Equal(t, 0, attempts["report"])
+}
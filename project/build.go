@@ -0,0 +1,173 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fugue/zim/exec"
+)
+
+// Environment variables set for Rule commands that identify the build they
+// run in. Zim run by a Rule command, locally or on a remote worker, records
+// the build it was started from as the parent of its own build.
+const (
+	BuildIDVariable       = "ZIM_BUILD_ID"
+	ParentBuildIDVariable = "ZIM_PARENT_BUILD_ID"
+)
+
+// Build is the record of one logical run of Rules. Builds started by Rules
+// of another build, and retries of a Rule, are children of that build.
+// Keys holds the cache key computed for each Rule and Images the container
+// images each Rule ran in, by node ID.
+type Build struct {
+	ID         string                     `json:"id"`
+	ParentID   string                     `json:"parent_id,omitempty"`
+	Rules      []string                   `json:"rules"`
+	StartedAt  time.Time                  `json:"started_at"`
+	FinishedAt *time.Time                 `json:"finished_at,omitempty"`
+	Counts     map[string]int             `json:"counts,omitempty"`
+	Error      string                     `json:"error,omitempty"`
+	Keys       map[string]string          `json:"keys,omitempty"`
+	Images     map[string][]exec.ImageUse `json:"images,omitempty"`
+}
+
+// NewBuild returns a Build of the given Rules. Its parent is the build
+// identified by the environment, if any.
+func NewBuild(rules []string) *Build {
+	return &Build{
+		ID:        UUID(),
+		ParentID:  os.Getenv(BuildIDVariable),
+		Rules:     rules,
+		StartedAt: time.Now(),
+	}
+}
+
+// Child returns a new child of the Build
+func (b *Build) Child() *Build {
+	return &Build{
+		ID:        UUID(),
+		ParentID:  b.ID,
+		Rules:     b.Rules,
+		StartedAt: time.Now(),
+	}
+}
+
+// Finish records the end of the Build with the number of Rules finishing
+// with each result code and the error that stopped it, if any
+func (b *Build) Finish(counts map[string]int, err error) {
+	now := time.Now()
+	b.FinishedAt = &now
+	b.Counts = counts
+	if err != nil {
+		b.Error = err.Error()
+	}
+}
+
+// buildEnvironment returns the environment variables identifying a build
+func buildEnvironment(opts RunOpts) map[string]string {
+	env := map[string]string{}
+	if opts.BuildID != "" {
+		env[BuildIDVariable] = opts.BuildID
+	}
+	if opts.ParentBuildID != "" {
+		env[ParentBuildIDVariable] = opts.ParentBuildID
+	}
+	return env
+}
+
+// SaveBuild writes the record of a Build to the directory
+func SaveBuild(dir string, b *Build) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(dir, b.ID+".json"), data, 0644)
+}
+
+// LoadBuilds reads the Build records in the directory, most recent first
+func LoadBuilds(dir string) ([]*Build, error) {
+	paths, err := filepath.Glob(path.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var builds []*Build
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		var b Build
+		if err := json.Unmarshal(data, &b); err != nil {
+			return nil, err
+		}
+		builds = append(builds, &b)
+	}
+	sort.Slice(builds, func(i, j int) bool {
+		return builds[i].StartedAt.After(builds[j].StartedAt)
+	})
+	return builds, nil
+}
+
+// RelatedBuilds returns the builds in the same tree as the build with the
+// given ID: the root of the tree and all of its descendants
+func RelatedBuilds(builds []*Build, id string) []*Build {
+	byID := map[string]*Build{}
+	for _, b := range builds {
+		byID[b.ID] = b
+	}
+	root := id
+	for {
+		b, found := byID[root]
+		if !found || b.ParentID == "" || byID[b.ParentID] == nil {
+			break
+		}
+		root = b.ParentID
+	}
+	related := map[string]bool{root: true}
+	// Builds are only recorded after their parents start, so iterating
+	// oldest first finds all descendants in one pass
+	for i := len(builds) - 1; i >= 0; i-- {
+		b := builds[i]
+		if related[b.ParentID] {
+			related[b.ID] = true
+		}
+	}
+	var result []*Build
+	for _, b := range builds {
+		if related[b.ID] {
+			result = append(result, b)
+		}
+	}
+	return result
+}
+
+// FindBuild returns the Build with the given ID, or nil if there is none
+func FindBuild(builds []*Build, id string) *Build {
+	for _, b := range builds {
+		if b.ID == id {
+			return b
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewBuild(t *testing.T) {
+
+	os.Setenv(BuildIDVariable, "parent")
+	defer os.Unsetenv(BuildIDVariable)
+
+	b := NewBuild([]string{"build"})
+	require.NotEmpty(t, b.ID)
+	require.Equal(t, "parent", b.ParentID)
+
+	child := b.Child()
+	require.NotEqual(t, b.ID, child.ID)
+	require.Equal(t, b.ID, child.ParentID)
+
+	require.Equal(t, map[string]string{
+		BuildIDVariable:       child.ID,
+		ParentBuildIDVariable: b.ID,
+	}, buildEnvironment(RunOpts{BuildID: child.ID, ParentBuildID: b.ID}))
+}
+
+func TestSaveLoadBuilds(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	root := &Build{ID: "root", StartedAt: start}
+	child := &Build{ID: "child", ParentID: "root", StartedAt: start.Add(time.Second)}
+	grandchild := &Build{ID: "grandchild", ParentID: "child", StartedAt: start.Add(2 * time.Second)}
+	other := &Build{ID: "other", StartedAt: start.Add(3 * time.Second)}
+	root.Finish(map[string]int{"ok": 2}, errors.New("boom"))
+
+	for _, b := range []*Build{root, child, grandchild, other} {
+		require.Nil(t, SaveBuild(dir, b))
+	}
+	builds, err := LoadBuilds(dir)
+	require.Nil(t, err)
+	require.Len(t, builds, 4)
+	require.Equal(t, "other", builds[0].ID)
+	require.Equal(t, "boom", builds[3].Error)
+	require.Equal(t, map[string]int{"ok": 2}, builds[3].Counts)
+	require.NotNil(t, builds[3].FinishedAt)
+
+	var ids []string
+	for _, b := range RelatedBuilds(builds, "child") {
+		ids = append(ids, b.ID)
+	}
+	require.Equal(t, []string{"grandchild", "child", "root"}, ids)
+}
@@ -0,0 +1,620 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fugue/zim/exec"
+)
+
+// Default options of the tools that built-in commands once ran. Built-ins
+// given other options still run the tool, since the options are the tool's.
+const (
+	defaultCopyOptions      = "-R"
+	defaultZipOptions       = "-qrFS"
+	defaultUnzipOptions     = "-qo"
+	defaultArchiveOptions   = "-czf"
+	defaultUnarchiveOptions = "-xzf"
+)
+
+// builtin runs a built-in command natively, without a shell, so that it
+// behaves the same on every platform. The command is shown like those run
+// by an executor.
+type builtin struct {
+	dir    string
+	env    map[string]string
+	cmdOut io.Writer
+}
+
+// newBuiltin returns a builtin that resolves paths relative to the working
+// directory of the options and expands variables from their environment
+func newBuiltin(execOpts exec.ExecOpts) *builtin {
+	env := map[string]string{}
+	for _, kv := range execOpts.Env {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	cmdOut := execOpts.Cmdout
+	if cmdOut == nil {
+		cmdOut = os.Stdout
+	}
+	return &builtin{dir: execOpts.WorkingDirectory, env: env, cmdOut: cmdOut}
+}
+
+// show prints the command being run
+func (b *builtin) show(format string, args ...interface{}) {
+	fmt.Fprintln(b.cmdOut, "cmd:", Cyan(fmt.Sprintf(format, args...)))
+}
+
+// expand replaces references to variables, e.g. $ARTIFACT or ${NAME}, as
+// the shell did. Variables not in the Rule's environment are taken from
+// the process environment.
+func (b *builtin) expand(s string) string {
+	return os.Expand(s, func(name string) string {
+		if value, found := b.env[name]; found {
+			return value
+		}
+		return os.Getenv(name)
+	})
+}
+
+// path returns the absolute path referred to by an argument
+func (b *builtin) path(arg string) string {
+	p := filepath.FromSlash(b.expand(arg))
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(b.dir, p)
+	}
+	return filepath.Clean(p)
+}
+
+// paths returns the absolute paths referred to by a list of arguments
+// separated by whitespace. Glob patterns are expanded, and like the shell,
+// a pattern that matches nothing is kept as it is.
+func (b *builtin) paths(args string) ([]string, error) {
+	var result []string
+	for _, arg := range strings.Fields(args) {
+		p := b.path(arg)
+		if !strings.ContainsAny(p, "*?[") {
+			result = append(result, p)
+			continue
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			matches = []string{p}
+		}
+		result = append(result, matches...)
+	}
+	return result, nil
+}
+
+// mkdir creates directories and their parents
+func (b *builtin) mkdir(args string) error {
+	b.show("mkdir %s", args)
+	paths, err := b.paths(args)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// remove removes files and directories. Missing paths are ignored.
+func (b *builtin) remove(args string) error {
+	b.show("remove %s", args)
+	paths, err := b.paths(args)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if isRoot(p) {
+			return fmt.Errorf("refusing to remove %s", p)
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cleandir removes directories and creates them again, empty
+func (b *builtin) cleandir(args string) error {
+	b.show("cleandir %s", args)
+	paths, err := b.paths(args)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if isRoot(p) {
+			return fmt.Errorf("cleandir cannot run against %s", p)
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(p, 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// destination returns where each source is moved or copied to. Like mv
+// and cp, sources are placed inside the destination if it is an existing
+// directory, and otherwise there must be one source which takes its name.
+func (b *builtin) destination(srcs []string, dst string) ([]string, error) {
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		targets := make([]string, len(srcs))
+		for i, src := range srcs {
+			targets[i] = filepath.Join(dst, filepath.Base(src))
+		}
+		return targets, nil
+	}
+	if len(srcs) != 1 {
+		return nil, fmt.Errorf("destination %s is not a directory", dst)
+	}
+	return []string{dst}, nil
+}
+
+// move moves files and directories
+func (b *builtin) move(src, dst string) error {
+	b.show("move %s %s", src, dst)
+	srcs, err := b.paths(src)
+	if err != nil {
+		return err
+	}
+	targets, err := b.destination(srcs, b.path(dst))
+	if err != nil {
+		return err
+	}
+	for i, src := range srcs {
+		if err := os.Rename(src, targets[i]); err == nil {
+			continue
+		}
+		// Renaming fails across file systems and volumes
+		if err := copyEntry(src, targets[i]); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(src); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copy copies files and directories recursively
+func (b *builtin) copy(src, dst string) error {
+	b.show("copy %s %s", src, dst)
+	srcs, err := b.paths(src)
+	if err != nil {
+		return err
+	}
+	targets, err := b.destination(srcs, b.path(dst))
+	if err != nil {
+		return err
+	}
+	for i, src := range srcs {
+		if err := copyEntry(src, targets[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archive creates a gzipped tar archive of the inputs. Entries are named by
+// their paths relative to the working directory, as given.
+func (b *builtin) archive(input, output string) error {
+	b.show("archive %s %s", input, output)
+	inputs, err := b.paths(input)
+	if err != nil {
+		return err
+	}
+	outputPath := b.path(output)
+	return writeAtomically(outputPath, func(f *os.File) error {
+		gz := gzip.NewWriter(f)
+		tw := tar.NewWriter(gz)
+		for _, p := range inputs {
+			if err := addToTar(tw, b.dir, p, outputPath, f.Name()); err != nil {
+				return err
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// unarchive extracts a gzipped tar archive into the output directory, or
+// into the working directory if no output is given
+func (b *builtin) unarchive(input, output string) error {
+	b.show("unarchive %s %s", input, output)
+	dir := b.dir
+	if output != "" {
+		dir = b.path(output)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.Open(b.path(input))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	return ExtractTar(tar.NewReader(gz), dir)
+}
+
+// zip creates a zip archive of the input, relative to the directory cd,
+// replacing any existing archive
+func (b *builtin) zip(cd, input, output string) error {
+	b.show("zip %s %s", input, output)
+	base := b.dir
+	if cd != "" {
+		base = b.path(cd)
+	}
+	relative := &builtin{dir: base, env: b.env, cmdOut: b.cmdOut}
+	inputs, err := relative.paths(input)
+	if err != nil {
+		return err
+	}
+	outputPath := relative.path(output)
+	return writeAtomically(outputPath, func(f *os.File) error {
+		zw := zip.NewWriter(f)
+		for _, p := range inputs {
+			if err := addToZip(zw, base, p, outputPath, f.Name()); err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	})
+}
+
+// unzip extracts a zip archive into the output directory, or into the
+// working directory if no output is given, overwriting existing files
+func (b *builtin) unzip(input, output string) error {
+	b.show("unzip %s %s", input, output)
+	dir := b.dir
+	if output != "" {
+		dir = b.path(output)
+	}
+	zr, err := zip.OpenReader(b.path(input))
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		target, err := extractPath(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFileFrom(target, rc, f.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRoot returns true if the path is the root of a file system
+func isRoot(p string) bool {
+	return filepath.Dir(p) == p
+}
+
+// copyEntry copies a file, symlink, or directory tree
+func copyEntry(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.Remove(dst)
+		return os.Symlink(link, dst)
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		entries, err := ioutil.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if err := copyEntry(filepath.Join(src, name), filepath.Join(dst, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return writeFileFrom(dst, f, info.Mode())
+	}
+}
+
+// writeFileFrom writes a file with the given mode from a reader, creating
+// its parent directories
+func writeFileFrom(dst string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeAtomically writes a file via a temporary file in the same directory
+// so that a failure never leaves a partial file in its place
+func writeAtomically(dst string, write func(*os.File) error) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), ".zim-")
+	if err != nil {
+		return err
+	}
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Remove(dst)
+	return os.Rename(tmp.Name(), dst)
+}
+
+// archiveName returns the name of the entry for a path within an archive,
+// relative to the base directory with forward slashes. Like tar and zip, a
+// path outside of the base directory is named without its leading separator.
+func archiveName(base, p string) string {
+	rel, err := filepath.Rel(base, p)
+	if err != nil || isOutside(rel) {
+		rel = strings.TrimPrefix(p[len(filepath.VolumeName(p)):], string(filepath.Separator))
+	}
+	return filepath.ToSlash(rel)
+}
+
+// isOutside returns true if a relative path refers to a parent directory
+func isOutside(rel string) bool {
+	return rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// addToTar adds a file, symlink, or directory tree to a tar archive,
+// skipping the archive itself
+func addToTar(tw *tar.Writer, base, root string, archivePaths ...string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if containsString(archivePaths, p) {
+			return nil
+		}
+		name := archiveName(base, p)
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+}
+
+// addToZip adds a file or directory tree to a zip archive, skipping the
+// archive itself. Symlinks are followed.
+func addToZip(zw *zip.Writer, base, root string, archivePaths ...string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if containsString(archivePaths, p) {
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if info, err = os.Stat(p); err != nil {
+				return err
+			}
+		}
+		name := archiveName(base, p)
+		if name == "." {
+			return nil
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = zw.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+		w, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+}
+
+// extractPath returns where an archive entry is extracted to, refusing
+// entries that would be written outside of the directory
+func extractPath(dir, name string) (string, error) {
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dir, target); err != nil || isOutside(rel) {
+		return "", fmt.Errorf("archive entry %s is outside of the directory", name)
+	}
+	return target, nil
+}
+
+// checkLink returns an error if a symlink extracted to the target would
+// point outside of the directory
+func checkLink(dir, target, name, linkname string) error {
+	resolved := filepath.FromSlash(linkname)
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(filepath.Dir(target), resolved)
+	}
+	if rel, err := filepath.Rel(dir, resolved); err != nil || isOutside(rel) {
+		return fmt.Errorf("archive symlink %s points outside of the directory", name)
+	}
+	return nil
+}
+
+// checkParents returns an error if the target is beneath a symlink within
+// the directory, since writing through it could escape the directory
+func checkParents(dir, target, name string) error {
+	dir = filepath.Clean(dir)
+	for p := filepath.Dir(target); p != dir && !isRoot(p); p = filepath.Dir(p) {
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %s is beneath a symlink", name)
+		}
+	}
+	return nil
+}
+
+// ExtractTar extracts the files, directories, and symlinks of an archive
+// into the directory, refusing entries that would be written outside of it.
+// Symlinks must point within the directory and no entry may be extracted
+// beneath a symlink.
+func ExtractTar(tr *tar.Reader, dir string) error {
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		target, err := extractPath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkParents(dir, target, header.Name); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := checkLink(dir, target, header.Name, header.Linkname); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			// A symlink already at the target is replaced, not followed
+			if info, err := os.Lstat(target); err == nil && info.Mode()&os.ModeSymlink != 0 {
+				os.Remove(target)
+			}
+			if err := writeFileFrom(target, tr, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// containsString returns true if the value is in the list
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
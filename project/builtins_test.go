@@ -0,0 +1,183 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fugue/zim/exec"
+	"github.com/stretchr/testify/require"
+)
+
+func testBuiltin(dir string) (*builtin, *bytes.Buffer) {
+	var output bytes.Buffer
+	return newBuiltin(exec.ExecOpts{
+		WorkingDirectory: dir,
+		Env:              []string{"ARTIFACTS_DIR=" + filepath.Join(dir, "artifacts")},
+		Cmdout:           &output,
+	}), &output
+}
+
+func readTestFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	require.Nil(t, err)
+	return string(data)
+}
+
+func TestBuiltinPaths(t *testing.T) {
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	require.Nil(t, writeFile(filepath.Join(dir, "a.go"), "a"))
+	require.Nil(t, writeFile(filepath.Join(dir, "b.go"), "b"))
+
+	b, _ := testBuiltin(dir)
+	paths, err := b.paths("*.go  $ARTIFACTS_DIR/out ${MISSING}x /abs *.txt")
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		filepath.Join(dir, "a.go"),
+		filepath.Join(dir, "b.go"),
+		filepath.Join(dir, "artifacts", "out"),
+		filepath.Join(dir, "x"),
+		"/abs",
+		filepath.Join(dir, "*.txt"),
+	}, paths)
+}
+
+func TestBuiltinFiles(t *testing.T) {
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	b, output := testBuiltin(dir)
+
+	require.Nil(t, b.mkdir("src/pkg $ARTIFACTS_DIR"))
+	require.True(t, fileExists(filepath.Join(dir, "src", "pkg")))
+	require.True(t, fileExists(filepath.Join(dir, "artifacts")))
+	require.Contains(t, output.String(), "cmd:")
+	require.Contains(t, output.String(), "mkdir src/pkg $ARTIFACTS_DIR")
+
+	require.Nil(t, writeFile(filepath.Join(dir, "src", "pkg", "main.go"), "main"))
+	require.Nil(t, os.Symlink("main.go", filepath.Join(dir, "src", "pkg", "link.go")))
+
+	// Copying a directory into an existing one places it inside, like cp -R
+	require.Nil(t, b.copy("src", "$ARTIFACTS_DIR"))
+	require.Equal(t, "main", readTestFile(t, filepath.Join(dir, "artifacts", "src", "pkg", "main.go")))
+	link, err := os.Readlink(filepath.Join(dir, "artifacts", "src", "pkg", "link.go"))
+	require.Nil(t, err)
+	require.Equal(t, "main.go", link)
+
+	// Otherwise the copy takes the destination's name
+	require.Nil(t, b.copy("src/pkg/main.go", "copy.go"))
+	require.Equal(t, "main", readTestFile(t, filepath.Join(dir, "copy.go")))
+	require.NotNil(t, b.copy("src/pkg/main.go copy.go", "missing"))
+
+	require.Nil(t, b.move("copy.go", "moved.go"))
+	require.False(t, fileExists(filepath.Join(dir, "copy.go")))
+	require.Equal(t, "main", readTestFile(t, filepath.Join(dir, "moved.go")))
+
+	require.Nil(t, b.cleandir("artifacts"))
+	entries, err := ioutil.ReadDir(filepath.Join(dir, "artifacts"))
+	require.Nil(t, err)
+	require.Len(t, entries, 0)
+
+	require.Nil(t, b.remove("moved.go src missing"))
+	require.False(t, fileExists(filepath.Join(dir, "moved.go")))
+	require.False(t, fileExists(filepath.Join(dir, "src")))
+
+	require.NotNil(t, b.remove("/"))
+	require.NotNil(t, b.cleandir("/"))
+}
+
+func TestBuiltinArchives(t *testing.T) {
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	b, _ := testBuiltin(dir)
+
+	require.Nil(t, b.mkdir("dist/js"))
+	require.Nil(t, writeFile(filepath.Join(dir, "dist", "index.html"), "index"))
+	require.Nil(t, writeFile(filepath.Join(dir, "dist", "js", "app.js"), "app"))
+
+	// Archives include the inputs by the paths given
+	require.Nil(t, b.archive("dist", "dist.tgz"))
+	require.Nil(t, b.unarchive("dist.tgz", "out"))
+	require.Equal(t, "app", readTestFile(t, filepath.Join(dir, "out", "dist", "js", "app.js")))
+
+	// Zip archives are relative to the cd directory and may be within it
+	require.Nil(t, b.zip("dist", ".", "dist.zip"))
+	require.Nil(t, b.zip("dist", ".", "dist.zip"))
+	require.Nil(t, b.unzip("dist/dist.zip", "unzipped"))
+	require.Equal(t, "index", readTestFile(t, filepath.Join(dir, "unzipped", "index.html")))
+	require.Equal(t, "app", readTestFile(t, filepath.Join(dir, "unzipped", "js", "app.js")))
+	require.False(t, fileExists(filepath.Join(dir, "unzipped", "dist.zip")))
+}
+
+func TestExtractPath(t *testing.T) {
+	target, err := extractPath("/out", "a/b.txt")
+	require.Nil(t, err)
+	require.Equal(t, filepath.Join("/out", "a", "b.txt"), target)
+
+	_, err = extractPath("/out", "../etc/passwd")
+	require.NotNil(t, err)
+}
+
+func TestExtractTarSymlinks(t *testing.T) {
+
+	// testTar returns an archive of the given entries. Entries with a link
+	// are symlinks and the rest are files.
+	type entry struct{ name, link string }
+	testTar := func(entries ...entry) *tar.Reader {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for _, e := range entries {
+			header := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg}
+			if e.link != "" {
+				header.Typeflag, header.Linkname = tar.TypeSymlink, e.link
+			}
+			require.Nil(t, tw.WriteHeader(header))
+		}
+		require.Nil(t, tw.Close())
+		return tar.NewReader(&buf)
+	}
+
+	dir, err := ioutil.TempDir("", "zim-extract-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+
+	// Symlinks within the directory are extracted
+	err = ExtractTar(testTar(entry{name: "a/b.txt"}, entry{name: "a/link", link: "b.txt"}), out)
+	require.Nil(t, err)
+	link, err := os.Readlink(filepath.Join(out, "a", "link"))
+	require.Nil(t, err)
+	require.Equal(t, "b.txt", link)
+
+	// Symlinks resolving outside of the directory are refused
+	err = ExtractTar(testTar(entry{name: "escape", link: "../secret"}), out)
+	require.NotNil(t, err)
+	require.Equal(t, "archive symlink escape points outside of the directory", err.Error())
+	err = ExtractTar(testTar(entry{name: "a/escape", link: "../../secret"}), out)
+	require.NotNil(t, err)
+	err = ExtractTar(testTar(entry{name: "abs", link: dir}), out)
+	require.NotNil(t, err)
+
+	// Entries can't be written through a symlink, even one within the
+	// directory
+	err = ExtractTar(testTar(entry{name: "d", link: "a"}, entry{name: "d/c.txt"}), out)
+	require.NotNil(t, err)
+	require.Equal(t, "archive entry d/c.txt is beneath a symlink", err.Error())
+	require.False(t, fileExists(filepath.Join(out, "a", "c.txt")))
+}
@@ -0,0 +1,89 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"path/filepath"
+	"strings"
+
+	glob "github.com/bmatcuk/doublestar"
+	"github.com/fugue/zim/vcs"
+)
+
+// changedFiles returns the absolute paths of files that differ between the
+// working copy containing the directory and the given revision, including
+// uncommitted changes and untracked files that aren't ignored
+func changedFiles(dir, rev string) ([]string, error) {
+	repo, err := vcs.Detect(dir)
+	if err != nil {
+		return nil, err
+	}
+	return repo.ChangedFiles(rev)
+}
+
+// inputsChangedSince returns the first input of the Rule found to be changed
+// relative to the given revision, or an empty string if none changed. Deleted
+// files are matched against the input patterns since they no longer exist.
+// Submodule inputs are changed if the commit recorded for them changed.
+func (r *Rule) inputsChangedSince(ref string) (string, error) {
+	changed, err := changedFiles(r.Component().Directory(), ref)
+	if err != nil {
+		return "", err
+	}
+	if len(changed) == 0 {
+		return "", nil
+	}
+	inputs, err := r.Inputs()
+	if err != nil {
+		return "", err
+	}
+	inputPaths := make(map[string]bool, len(inputs))
+	for _, input := range inputs {
+		// The containing repository reports a submodule whose commit
+		// changed as a single path
+		if submodule, ok := input.(*Submodule); ok {
+			inputPaths[submodule.Directory()] = true
+			continue
+		}
+		inputPaths[filepath.Clean(input.Path())] = true
+	}
+	compDir := r.Component().Directory()
+	patterns, negated := splitInputPatterns(r.inputs)
+	for _, changedPath := range changed {
+		if inputPaths[changedPath] {
+			return changedPath, nil
+		}
+		relPath, err := filepath.Rel(compDir, changedPath)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			continue
+		}
+		if matchesGlob(negated, relPath) {
+			continue
+		}
+		if matchesGlob(patterns, relPath) {
+			return changedPath, nil
+		}
+	}
+	return "", nil
+}
+
+// matchesGlob returns true if the path matches one of the glob patterns
+func matchesGlob(patterns []string, relPath string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := glob.Match(pattern, relPath); matched {
+			return true
+		}
+	}
+	return false
+}
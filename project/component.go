@@ -18,6 +18,8 @@ import (
 	"fmt"
 	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 
 	"github.com/fugue/zim/definitions"
 	"github.com/hashicorp/go-multierror"
@@ -47,16 +49,56 @@ func NewComponent(p *Project, self *definitions.Component) (*Component, error) {
 	}
 
 	c := Component{
-		project:      p,
-		componentDir: componentDir,
-		relPath:      relPath,
-		kind:         self.Kind,
-		app:          self.App,
-		dockerImage:  self.Docker.Image,
-		name:         name,
-		rules:        make(map[string]*Rule, len(self.Rules)),
-		exports:      make(map[string]*Export, len(self.Exports)),
-		env:          self.Environment,
+		project:       p,
+		componentDir:  componentDir,
+		relPath:       relPath,
+		kind:          self.Kind,
+		tags:          self.Tags,
+		app:           self.App,
+		dockerImage:   self.Docker.Image,
+		cacheVolumes:  combineEnvironment(p.cacheVolumes, self.Docker.CacheVolumes),
+		dockerNetwork: self.Docker.Network,
+		nix:           newNixEnvironment(componentDir, self.Nix),
+		name:          name,
+		rules:         make(map[string]*Rule, len(self.Rules)),
+		matrices:      map[string][]*Rule{},
+		exports:       make(map[string]*Export, len(self.Exports)),
+		env:           self.Environment,
+		secrets:       self.Secrets,
+		wrapper:       self.Wrapper,
+		shell:         self.Shell,
+		shellOptions:  self.ShellOptions,
+		ecs: ECS{
+			Task:   self.ECS.Task,
+			Type:   self.ECS.Type,
+			Memory: self.ECS.Memory,
+			CPU:    self.ECS.CPU,
+		},
+	}
+
+	// The shell set for the project is used unless the Component sets one.
+	// Its options only apply along with it.
+	if c.shell == "" {
+		c.shell = p.shell
+		if len(c.shellOptions) == 0 {
+			c.shellOptions = p.shellOptions
+		}
+	}
+
+	if err := checkCacheVolumes(c.cacheVolumes); err != nil {
+		return nil, fmt.Errorf("Component %s %s", name, err)
+	}
+
+	tools, err := newToolVersions(p, componentDir, self.Tools)
+	if err != nil {
+		return nil, err
+	}
+	c.tools = tools
+	if c.nix != nil {
+		c.nix.Offline = p.offline
+	}
+	if c.tools != nil {
+		c.tools.Offline = p.offline
 	}
 
 	for _, item := range self.Toolchain.Items {
@@ -80,10 +122,24 @@ func NewComponent(p *Project, self *definitions.Component) (*Component, error) {
 			Provider:  provider,
 			Resources: export.Resources,
 			Ignore:    export.Ignore,
+			FromRule:  export.FromRule,
 		}
 	}
 
 	for name, ruleDef := range self.Rules {
+		// Matrix rules are expanded into an instance per combination of
+		// the matrix variables, each of which is a separate rule
+		if len(ruleDef.Matrix) > 0 {
+			rules, err := newMatrixRules(name, &c, &ruleDef)
+			if err != nil {
+				return nil, err
+			}
+			for _, rule := range rules {
+				c.rules[rule.Name()] = rule
+			}
+			c.matrices[name] = rules
+			continue
+		}
 		rule, err := NewRule(name, &c, &ruleDef)
 		if err != nil {
 			return nil, err
@@ -107,19 +163,40 @@ type Toolchain struct {
 	Items []ToolchainItem
 }
 
+// ECS defines the task configuration used when running a Component's rules
+// in ECS. CPU is in CPU units, where 1024 units is one vCPU, and Memory is
+// in MiB.
+type ECS struct {
+	Task   string
+	Type   string
+	Memory int
+	CPU    int
+}
+
 // Component to build and deploy in a repository
 type Component struct {
-	project      *Project
-	componentDir string
-	relPath      string
-	name         string
-	app          string
-	kind         string
-	dockerImage  string
-	rules        map[string]*Rule
-	exports      map[string]*Export
-	env          map[string]string
-	toolchain    Toolchain
+	project       *Project
+	componentDir  string
+	relPath       string
+	name          string
+	app           string
+	kind          string
+	tags          []string
+	dockerImage   string
+	cacheVolumes  map[string]string
+	dockerNetwork string
+	nix           *NixEnvironment
+	tools         *ToolVersions
+	rules         map[string]*Rule
+	matrices      map[string][]*Rule
+	exports       map[string]*Export
+	env           map[string]string
+	secrets       map[string]string
+	wrapper       []string
+	shell         string
+	shellOptions  []string
+	toolchain     Toolchain
+	ecs           ECS
 }
 
 // Project returns the Project that contains this Component
@@ -144,6 +221,65 @@ func (c *Component) Kind() string {
 	return c.kind
 }
 
+// Image returns the Docker image used to run the Component's rules, if any
+func (c *Component) Image() string {
+	return c.dockerImage
+}
+
+// CacheVolumes returns the names of volumes that persist between runs mapped
+// to the paths they are mounted at in the Component's containers, or nil if
+// there are none
+func (c *Component) CacheVolumes() map[string]string {
+	if len(c.cacheVolumes) == 0 {
+		return nil
+	}
+	return copyEnvironment(c.cacheVolumes)
+}
+
+// Tags are labels used to select Components, e.g. "backend"
+func (c *Component) Tags() []string {
+	return c.tags
+}
+
+// HasTag returns true if the Component has the given tag
+func (c *Component) HasTag(tag string) bool {
+	for _, t := range c.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// Nix returns the Nix environment for this Component, or nil if it has none
+func (c *Component) Nix() *NixEnvironment {
+	return c.nix
+}
+
+// Tools returns the tool versions activated for this Component, or nil if
+// it doesn't use a tool version manager
+func (c *Component) Tools() *ToolVersions {
+	return c.tools
+}
+
+// nativeWrapper returns the command wrapping native commands run for this
+// Component, or nil if they run directly. A Nix environment takes precedence
+// over a tool version manager.
+func (c *Component) nativeWrapper() []string {
+	if c.nix != nil {
+		return c.nix.Wrapper()
+	}
+	if c.tools != nil {
+		return c.tools.Wrapper()
+	}
+	return nil
+}
+
+// ECS returns the ECS task configuration for this Component
+func (c *Component) ECS() ECS {
+	return c.ecs
+}
+
 // Directory returns the absolute path to the Component directory
 func (c *Component) Directory() string {
 	return c.componentDir
@@ -201,10 +337,25 @@ func (c *Component) Rules() []*Rule {
 	return rules
 }
 
-// HasRule returns true if a Rule with the given name is defined
+// HasRule returns true if a Rule with the given name is defined, including
+// a matrix Rule
 func (c *Component) HasRule(name string) bool {
 	_, found := c.rules[name]
-	return found
+	return found || len(c.matrices[name]) > 0
+}
+
+// MatrixRules returns the instances of the named matrix Rule, which are
+// sorted by name. Nil is returned if there is no such matrix Rule.
+func (c *Component) MatrixRules(name string) []*Rule {
+	rules := make([]*Rule, len(c.matrices[name]))
+	copy(rules, c.matrices[name])
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].Name() < rules[j].Name()
+	})
+	if len(rules) == 0 {
+		return nil
+	}
+	return rules
 }
 
 // Export returns the Component export with the given name, if it exists
@@ -223,11 +374,14 @@ func (c *Component) Exports() []*Export {
 }
 
 // Select finds Rules belonging to this Component with the provided names.
-// Unknown names are just ignored.
+// The name of a matrix Rule selects all of its instances. Unknown names are
+// just ignored.
 func (c *Component) Select(names []string) (result []*Rule) {
 	for _, name := range names {
 		if r, exists := c.Rule(name); exists {
 			result = append(result, r)
+		} else {
+			result = append(result, c.MatrixRules(name)...)
 		}
 	}
 	return
@@ -259,3 +413,21 @@ func (c *Component) Toolchain() (map[string]string, error) {
 func (c *Component) Provider(name string) (Provider, error) {
 	return c.Project().Provider(name)
 }
+
+// dockerNamePattern matches the volume and network names accepted by
+// container runtimes
+var dockerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// checkCacheVolumes returns an error if a cache volume has an invalid name
+// or is not mounted at an absolute path
+func checkCacheVolumes(volumes map[string]string) error {
+	for name, mountPath := range volumes {
+		if !dockerNamePattern.MatchString(name) {
+			return fmt.Errorf("cache volume %q has an invalid name", name)
+		}
+		if !path.IsAbs(mountPath) {
+			return fmt.Errorf("cache volume %s path %q is not absolute", name, mountPath)
+		}
+	}
+	return nil
+}
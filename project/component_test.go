@@ -216,8 +216,8 @@ func TestComponentsFiltering(t *testing.T) {
 
 	comps := Components{
 		&Component{name: "a", kind: "go"},
-		&Component{name: "b", kind: "python"},
-		&Component{name: "c", kind: "go"},
+		&Component{name: "b", kind: "python", tags: []string{"backend"}},
+		&Component{name: "c", kind: "go", tags: []string{"frontend", "backend"}},
 	}
 
 	goComps := comps.WithKind("go")
@@ -237,6 +237,13 @@ func TestComponentsFiltering(t *testing.T) {
 	if bComp[0].Name() != "b" {
 		t.Error("Expected to get b, got:", bComp[0].Name())
 	}
+	backendComps := comps.WithTag("backend")
+	if len(backendComps) != 2 {
+		t.Fatal("Expected two backend components")
+	}
+	if len(comps.WithTag("frontend", "backend")) != 2 {
+		t.Error("Expected components with either tag once each")
+	}
 }
 
 func TestComponentToolchain(t *testing.T) {
@@ -274,3 +281,109 @@ func TestComponentToolchain(t *testing.T) {
 	value := m["go"]
 	require.True(t, strings.HasPrefix(value, "go version go1."))
 }
+
+func TestComponentNix(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	cDir, cDefPath := testComponentDir(dir, "foo")
+	testComponentFile(cDir, "flake.nix", "{ outputs = _: {}; }")
+	testComponentFile(cDir, "flake.lock", "{}")
+
+	p := &Project{
+		root:      dir,
+		rootAbs:   dir,
+		toolchain: map[string]string{},
+		executor:  exec.NewBashExecutor(),
+	}
+	self := &definitions.Component{
+		Path: cDefPath,
+		Nix:  definitions.Nix{File: "flake.nix"},
+	}
+	c, err := NewComponent(p, self)
+	require.Nil(t, err)
+
+	nix := c.Nix()
+	require.NotNil(t, nix)
+	require.Equal(t, path.Join(cDir, "flake.nix"), nix.File)
+	require.Equal(t, path.Join(cDir, "flake.lock"), nix.Lock)
+	require.Equal(t, []string{"nix", "develop", cDir, "--command"}, nix.Wrapper())
+
+	m, err := c.Toolchain()
+	require.Nil(t, err)
+	hash1 := m["nix"]
+	require.Len(t, hash1, 64)
+
+	// Changing the lock file changes the toolchain
+	testComponentFile(cDir, "flake.lock", `{"version": 7}`)
+	m, err = c.Toolchain()
+	require.Nil(t, err)
+	require.NotEqual(t, hash1, m["nix"])
+
+	shell := newNixEnvironment(cDir, definitions.Nix{File: "/repo/shell.nix"})
+	require.Equal(t, []string{"nix", "develop", "--file", "/repo/shell.nix", "--command"},
+		shell.Wrapper())
+	require.Equal(t, "", shell.Lock)
+}
+
+func TestComponentToolVersions(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	_, cDefPath := testComponentDir(dir, "foo")
+	testComponentFile(dir, ".tool-versions", "# pinned\nnodejs 18.1.0 system\ngolang 1.21.0 # comment\n")
+
+	p := &Project{
+		root:      dir,
+		rootAbs:   dir,
+		toolchain: map[string]string{},
+		executor:  exec.NewBashExecutor(),
+	}
+	self := &definitions.Component{
+		Path:  cDefPath,
+		Tools: definitions.Tools{Manager: "mise"},
+		Toolchain: definitions.Toolchain{
+			Items: []definitions.ToolchainItem{
+				{Name: "golang", Command: "echo explicit"},
+			},
+		},
+	}
+	c, err := NewComponent(p, self)
+	require.Nil(t, err)
+
+	tools := c.Tools()
+	require.NotNil(t, tools)
+	require.Equal(t, path.Join(dir, ".tool-versions"), tools.File)
+	require.Equal(t, []string{"mise", "exec", "--"}, tools.Wrapper())
+
+	// Toolchain commands run via the wrapper, so use asdf which only
+	// adjusts the PATH
+	c.tools.Manager = ToolManagerAsdf
+	require.Equal(t, "sh", tools.Wrapper()[0])
+
+	m, err := c.Toolchain()
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{
+		"nodejs": "18.1.0",
+		"golang": "explicit",
+	}, m)
+
+	// A file that is given is passed to the manager by name
+	testComponentFile(path.Dir(cDefPath), ".tool-versions.ci", "golang 1.22.0\n")
+	self.Tools.File = ".tool-versions.ci"
+	c, err = NewComponent(p, self)
+	require.Nil(t, err)
+	require.Equal(t, []string{"env", "MISE_DEFAULT_TOOL_VERSIONS_FILENAME=.tool-versions.ci",
+		"mise", "exec", "--"}, c.Tools().Wrapper())
+
+	// The managers would find the nearer .tool-versions instead
+	self.Tools.File = "../../.tool-versions"
+	testComponentFile(path.Dir(cDefPath), ".tool-versions", "golang 1.22.0\n")
+	_, err = NewComponent(p, self)
+	require.NotNil(t, err)
+	self.Tools.File = ""
+
+	self.Tools.Manager = "rbenv"
+	_, err = NewComponent(p, self)
+	require.NotNil(t, err)
+}
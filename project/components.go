@@ -54,7 +54,21 @@ func (comps Components) WithRule(rule ...string) Components {
 	var result Components
 	for _, c := range comps {
 		for _, r := range rule {
-			if _, found := c.rules[r]; found {
+			if c.HasRule(r) {
+				result = append(result, c)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// WithTag filters the Components to those with any of the given tags
+func (comps Components) WithTag(tag ...string) Components {
+	var result Components
+	for _, c := range comps {
+		for _, t := range tag {
+			if c.HasTag(t) {
 				result = append(result, c)
 				break
 			}
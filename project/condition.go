@@ -20,6 +20,7 @@ import (
 	"os"
 	"path"
 	"strings"
+	"sync"
 
 	"github.com/fugue/zim/exec"
 )
@@ -36,6 +37,7 @@ type Condition struct {
 	ResourceExists  string
 	DirectoryExists string
 	ScriptSucceeds  ConditionScript
+	ChangedSince    string
 }
 
 // IsEmpty returns true if the Script is not defined
@@ -54,6 +56,9 @@ func (c Condition) IsEmpty() bool {
 	if !c.ScriptSucceeds.IsEmpty() {
 		return false
 	}
+	if c.ChangedSince != "" {
+		return false
+	}
 	return true
 }
 
@@ -67,33 +72,59 @@ func CheckConditions(
 	executor exec.Executor,
 	env map[string]string,
 ) (bool, error) {
+	met, _, err := ExplainConditions(ctx, r, opts, executor, env)
+	return met, err
+}
+
+// ExplainConditions is like CheckConditions but also returns an explanation
+// of why the Rule should not execute, identifying the condition and the value
+// that was observed when checking it. The explanation is empty when all
+// conditions are met.
+func ExplainConditions(
+	ctx context.Context,
+	r *Rule,
+	opts RunOpts,
+	executor exec.Executor,
+	env map[string]string,
+) (bool, string, error) {
+	return explainConditions(ctx, r, opts, executor, env, nil)
+}
+
+func explainConditions(
+	ctx context.Context,
+	r *Rule,
+	opts RunOpts,
+	executor exec.Executor,
+	env map[string]string,
+	cache *conditionCache,
+) (bool, string, error) {
 
 	if !r.when.IsEmpty() {
 		// A when condition is defined
-		whenCondition, err := CheckCondition(ctx, r, r.when, opts, executor, env)
+		whenCondition, observed, err := cache.check(ctx, r, r.when, opts, executor, env)
 		if err != nil {
-			return false, err
+			return false, "", err
 		}
 		if !whenCondition {
 			// The "when" condition evaluted to false: condition not met
-			return false, nil
+			return false, fmt.Sprintf("when condition not met: %s", observed), nil
 		}
 	}
 
 	if !r.unless.IsEmpty() {
 		// An unless condition is defined
-		unlessCondition, err := CheckCondition(ctx, r, r.unless, opts, executor, env)
+		unlessCondition, observed, err := cache.check(ctx, r, r.unless, opts, executor, env)
 		if err != nil {
-			return false, err
+			return false, "", err
 		}
 		if unlessCondition {
 			// The "unless" condition evaluted to true: condition not met
-			return false, nil
+			return false, fmt.Sprintf("unless condition met: %s", observed), nil
 		}
 	}
 
 	// All conditions met
-	return true, nil
+	return true, "", nil
 }
 
 // CheckCondition returns true if the given Rule condition is met. The provided
@@ -106,6 +137,20 @@ func CheckCondition(
 	executor exec.Executor,
 	env map[string]string,
 ) (bool, error) {
+	met, _, err := checkCondition(ctx, r, c, opts, executor, env)
+	return met, err
+}
+
+// checkCondition returns whether the condition is met along with a
+// description of what was observed while checking it
+func checkCondition(
+	ctx context.Context,
+	r *Rule,
+	c Condition,
+	opts RunOpts,
+	executor exec.Executor,
+	env map[string]string,
+) (bool, string, error) {
 
 	if c.ResourceExists != "" {
 		pattern := substituteVars(c.ResourceExists, env)
@@ -113,18 +158,34 @@ func CheckCondition(
 		// match the provided filename or glob pattern
 		resources, err := matchResources(r.Component(), r.inProvider, []string{pattern})
 		if err != nil {
-			return false, err
+			return false, "", err
 		}
-		return len(resources) > 0, nil
+		observed := fmt.Sprintf("resource_exists %q matched %d resources",
+			pattern, len(resources))
+		return len(resources) > 0, observed, nil
 	}
 
 	if c.DirectoryExists != "" {
 		directoryName := substituteVars(c.DirectoryExists, env)
 		dirPath := path.Join(r.Component().Directory(), directoryName)
 		if stat, err := os.Stat(dirPath); err == nil && stat.IsDir() {
-			return true, nil
+			return true, fmt.Sprintf("directory_exists %q found", directoryName), nil
 		}
-		return false, nil
+		return false, fmt.Sprintf("directory_exists %q not found", directoryName), nil
+	}
+
+	if c.ChangedSince != "" {
+		// The "changed since" condition evaluates to true if any input of the
+		// rule differs from the given revision
+		ref := substituteVars(c.ChangedSince, env)
+		changed, err := r.inputsChangedSince(ref)
+		if err != nil {
+			return false, "", err
+		}
+		if changed == "" {
+			return false, fmt.Sprintf("changed_since %q found no changed inputs", ref), nil
+		}
+		return true, fmt.Sprintf("changed_since %q found changed input %s", ref, changed), nil
 	}
 
 	if !c.ScriptSucceeds.IsEmpty() {
@@ -146,18 +207,79 @@ func CheckCondition(
 		// error depending on whether "suppress_error" is set.
 		if err != nil {
 			if c.ScriptSucceeds.SuppressError {
-				return false, nil
+				return false, fmt.Sprintf("script_succeeds failed: %s", err), nil
 			}
-			return false, err
+			return false, "", err
 		}
 		// If "with_output" is set, the condition is met if the script output
 		// exactly matches the expected output.
 		if c.ScriptSucceeds.WithOutput != "" {
 			requiredOutput := substituteVars(c.ScriptSucceeds.WithOutput, env)
 			outputStr := strings.TrimSpace(outputBuffer.String())
-			return outputStr == requiredOutput, nil
+			observed := fmt.Sprintf("script_succeeds output %q, expected %q",
+				outputStr, requiredOutput)
+			return outputStr == requiredOutput, observed, nil
 		}
-		return true, nil
+		return true, "script_succeeds succeeded", nil
+	}
+	return true, "", nil
+}
+
+type conditionResult struct {
+	once     sync.Once
+	met      bool
+	observed string
+	err      error
+}
+
+// conditionCache remembers the results of condition scripts so that a script
+// is run only once within a run for the same Component, image, and script
+// environment. The environment includes the variables Zim sets for each Rule,
+// such as RULE and OUTPUT, so results are only shared between checks that
+// would run the script identically, e.g. when a Rule is retried.
+type conditionCache struct {
+	mutex   sync.Mutex
+	results map[string]*conditionResult
+}
+
+func newConditionCache() *conditionCache {
+	return &conditionCache{results: map[string]*conditionResult{}}
+}
+
+// check evaluates the condition, returning a previous result for the same
+// script, Component, and environment if there is one. Conditions not involving a script are
+// inexpensive and always evaluated. A nil cache evaluates every condition.
+func (cc *conditionCache) check(
+	ctx context.Context,
+	r *Rule,
+	c Condition,
+	opts RunOpts,
+	executor exec.Executor,
+	env map[string]string,
+) (bool, string, error) {
+
+	if cc == nil || c.ScriptSucceeds.IsEmpty() ||
+		c.ResourceExists != "" || c.DirectoryExists != "" || c.ChangedSince != "" {
+		return checkCondition(ctx, r, c, opts, executor, env)
+	}
+
+	script := c.ScriptSucceeds
+	key := fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%t\x00%s",
+		r.Component().Directory(), r.Image(), script.Run,
+		substituteVars(script.WithOutput, env), script.SuppressError,
+		strings.Join(flattenEnvironment(env), "\x00"))
+
+	cc.mutex.Lock()
+	result, found := cc.results[key]
+	if !found {
+		result = &conditionResult{}
+		cc.results[key] = result
 	}
-	return true, nil
+	cc.mutex.Unlock()
+
+	result.once.Do(func() {
+		result.met, result.observed, result.err = checkCondition(
+			ctx, r, c, opts, executor, env)
+	})
+	return result.met, result.observed, result.err
 }
@@ -17,10 +17,13 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	osexec "os/exec"
 	"path"
 	"testing"
 
+	"github.com/fugue/zim/definitions"
 	"github.com/fugue/zim/exec"
 	"github.com/stretchr/testify/require"
 )
@@ -79,6 +82,21 @@ func TestRuleCondition(t *testing.T) {
 	conditionsMet, err = CheckConditions(ctx, build, execOpts, executor, env)
 	require.Nil(t, err)
 	require.False(t, conditionsMet)
+
+	// Explanations identify the condition and what was observed
+	build, found = comp.Rule("build-when-skip")
+	require.True(t, found)
+	conditionsMet, reason, err := ExplainConditions(ctx, build, execOpts, executor, env)
+	require.Nil(t, err)
+	require.False(t, conditionsMet)
+	require.Equal(t, `when condition not met: resource_exists "missing.go" matched 0 resources`, reason)
+
+	build, found = comp.Rule("build-unless-skip")
+	require.True(t, found)
+	conditionsMet, reason, err = ExplainConditions(ctx, build, execOpts, executor, env)
+	require.Nil(t, err)
+	require.False(t, conditionsMet)
+	require.Equal(t, `unless condition met: resource_exists "main.go" matched 1 resources`, reason)
 }
 
 func TestConditionScript(t *testing.T) {
@@ -253,3 +271,184 @@ func errStringOrEmpty(err error) string {
 	}
 	return ""
 }
+
+func TestConditionCache(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	ctx := context.Background()
+	executor := exec.NewBashExecutor()
+	env := map[string]string{}
+
+	c := &Component{name: "test-comp", componentDir: dir}
+	r1 := &Rule{component: c, name: "rule-one"}
+	r2 := &Rule{component: c, name: "rule-two"}
+
+	counter := path.Join(dir, "counter")
+	cond := Condition{
+		ScriptSucceeds: ConditionScript{
+			Run: fmt.Sprintf("echo run >> %s", counter),
+		},
+	}
+
+	var stdout bytes.Buffer
+	runOpts := RunOpts{Output: &stdout, DebugOutput: &stdout}
+	cache := newConditionCache()
+
+	for _, r := range []*Rule{r1, r2, r1} {
+		met, _, err := cache.check(ctx, r, cond, runOpts, executor, env)
+		require.Nil(t, err)
+		require.True(t, met)
+	}
+
+	// The script is run in the same environment for both rules and should
+	// have run only once
+	data, err := ioutil.ReadFile(counter)
+	require.Nil(t, err)
+	require.Equal(t, "run\n", string(data))
+
+	// A different environment runs the script again
+	_, _, err = cache.check(ctx, r1, cond, runOpts, executor, map[string]string{"RULE": "rule-one"})
+	require.Nil(t, err)
+	data, err = ioutil.ReadFile(counter)
+	require.Nil(t, err)
+	require.Equal(t, "run\nrun\n", string(data))
+
+	// Without a cache the script runs every time
+	_, _, err = explainConditions(ctx, &Rule{component: c, when: cond}, runOpts, executor, env, nil)
+	require.Nil(t, err)
+	data, err = ioutil.ReadFile(counter)
+	require.Nil(t, err)
+	require.Equal(t, "run\nrun\nrun\n", string(data))
+}
+
+func TestChangedSinceCondition(t *testing.T) {
+
+	root := testDir()
+	defer os.RemoveAll(root)
+
+	componentDir, _ := testComponentDir(root, "my-component")
+	testComponentFile(componentDir, "main.go", "package main")
+	testComponentFile(componentDir, "README.md", "docs")
+
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		command := osexec.Command("git", args...)
+		command.Dir = root
+		require.Nil(t, command.Run())
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	ctx := context.Background()
+	executor := exec.NewBashExecutor()
+	fs, err := NewFileSystem(root)
+	require.Nil(t, err)
+
+	c := &Component{
+		name:         "my-component",
+		componentDir: componentDir,
+		relPath:      path.Join("src", "my-component"),
+	}
+	r := &Rule{
+		component:  c,
+		name:       "test-rule",
+		inputs:     []string{"*.go"},
+		inProvider: fs,
+	}
+	env := map[string]string{}
+	runOpts := RunOpts{Output: ioutil.Discard, DebugOutput: ioutil.Discard}
+	cond := Condition{ChangedSince: "HEAD"}
+
+	// Nothing changed yet
+	met, observed, err := checkCondition(ctx, r, cond, runOpts, executor, env)
+	require.Nil(t, err)
+	require.False(t, met)
+	require.Equal(t, `changed_since "HEAD" found no changed inputs`, observed)
+
+	// Changing a file that isn't an input doesn't count
+	testComponentFile(componentDir, "README.md", "more docs")
+	met, _, err = checkCondition(ctx, r, cond, runOpts, executor, env)
+	require.Nil(t, err)
+	require.False(t, met)
+
+	// Modifying an input counts as a change
+	testComponentFile(componentDir, "main.go", "package main // changed")
+	met, observed, err = checkCondition(ctx, r, cond, runOpts, executor, env)
+	require.Nil(t, err)
+	require.True(t, met)
+	require.Contains(t, observed, "main.go")
+	git("checkout", "-q", "--", "src/my-component/main.go")
+
+	// Deleting an input counts as a change
+	require.Nil(t, os.Remove(path.Join(componentDir, "main.go")))
+	met, observed, err = checkCondition(ctx, r, cond, runOpts, executor, env)
+	require.Nil(t, err)
+	require.True(t, met)
+	require.Contains(t, observed, "main.go")
+	git("checkout", "-q", "--", "src/my-component/main.go")
+
+	// Adding an input that isn't tracked yet counts as a change
+	testComponentFile(componentDir, "util.go", "package main")
+	met, observed, err = checkCondition(ctx, r, cond, runOpts, executor, env)
+	require.Nil(t, err)
+	require.True(t, met)
+	require.Contains(t, observed, "util.go")
+
+	// Unknown refs are an error
+	_, _, err = checkCondition(ctx, r, Condition{ChangedSince: "nope"}, runOpts, executor, env)
+	require.NotNil(t, err)
+}
+
+func TestConditionParameters(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.MkdirAll(path.Join(dir, "app"), 0755))
+
+	when := func(expected string) definitions.Condition {
+		return definitions.Condition{
+			ScriptSucceeds: definitions.ConditionScript{
+				Run:        "echo $VERSION-$CHANNEL-$ZIM_BUILD_ID",
+				WithOutput: expected,
+			},
+		}
+	}
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"release": {
+					Environment: map[string]string{"CHANNEL": "stable"},
+					When:        when("${VERSION}-stable-build1"),
+				},
+				"nightly": {
+					Environment: map[string]string{"CHANNEL": "nightly"},
+					When:        when("2.0-stable-build1"),
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{
+		Root:          dir,
+		ComponentDefs: defs,
+		Parameters:    map[string]string{"VERSION": "2.0"},
+	})
+	require.Nil(t, err)
+	c := p.Components().First()
+
+	// Conditions see parameters, rule variables, and the build ID, both in
+	// scripts and in the expected output
+	runner := &StandardRunner{}
+	opts := RunOpts{BuildID: "build1", DryRun: true, Output: ioutil.Discard, DebugOutput: ioutil.Discard}
+	code, err := runner.Run(context.Background(), c.MustRule("release"), opts)
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+
+	// The script is run again for a rule with different variables
+	code, err = runner.Run(context.Background(), c.MustRule("nightly"), opts)
+	require.Nil(t, err)
+	require.Equal(t, Skipped, code)
+}
@@ -0,0 +1,369 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fugue/zim/exec"
+)
+
+// BaselineStore persists named baselines, such as coverage percentages,
+// that are shared between runs
+type BaselineStore interface {
+
+	// ReadBaseline returns the named baseline or nil if it does not exist
+	ReadBaseline(ctx context.Context, name string) ([]byte, error)
+
+	// WriteBaseline stores the named baseline
+	WriteBaseline(ctx context.Context, name string, data []byte) error
+}
+
+// coverageBlock is one unit of coverage, i.e. a block of statements in a Go
+// cover profile or a line in an LCOV report
+type coverageBlock struct {
+	statements int
+	covered    bool
+}
+
+// CoverageProfile merges coverage reports. Blocks reported by more than one
+// report are covered if any of the reports covered them.
+type CoverageProfile struct {
+	blocks map[string]coverageBlock
+}
+
+// NewCoverageProfile returns an empty CoverageProfile
+func NewCoverageProfile() *CoverageProfile {
+	return &CoverageProfile{blocks: map[string]coverageBlock{}}
+}
+
+func (p *CoverageProfile) add(key string, statements int, covered bool) {
+	block := p.blocks[key]
+	if statements > block.statements {
+		block.statements = statements
+	}
+	block.covered = block.covered || covered
+	p.blocks[key] = block
+}
+
+// Merge a report in Go cover profile or LCOV format into the profile.
+// Returns false if the report is not in a known format.
+func (p *CoverageProfile) Merge(r io.Reader) (bool, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	format := ""
+	sourceFile := ""
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if format == "" {
+			switch {
+			case strings.HasPrefix(line, "mode:"):
+				format = "go"
+				continue
+			case strings.HasPrefix(line, "TN:"), strings.HasPrefix(line, "SF:"):
+				format = "lcov"
+			default:
+				return false, nil
+			}
+		}
+		if format == "go" {
+			// Format: name.go:line.column,line.column statements count
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				return true, fmt.Errorf("invalid cover profile line: %s", line)
+			}
+			statements, err := strconv.Atoi(fields[1])
+			if err != nil {
+				return true, fmt.Errorf("invalid cover profile line: %s", line)
+			}
+			count, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return true, fmt.Errorf("invalid cover profile line: %s", line)
+			}
+			p.add(fields[0], statements, count > 0)
+			continue
+		}
+		// LCOV records list line hits as DA:line,count for each source file
+		switch {
+		case strings.HasPrefix(line, "SF:"):
+			sourceFile = strings.TrimPrefix(line, "SF:")
+		case strings.HasPrefix(line, "DA:"):
+			parts := strings.Split(strings.TrimPrefix(line, "DA:"), ",")
+			if len(parts) < 2 {
+				return true, fmt.Errorf("invalid lcov line: %s", line)
+			}
+			count, err := strconv.Atoi(parts[1])
+			if err != nil {
+				return true, fmt.Errorf("invalid lcov line: %s", line)
+			}
+			p.add(fmt.Sprintf("%s:%s", sourceFile, parts[0]), 1, count > 0)
+		}
+	}
+	return format != "", scanner.Err()
+}
+
+// Statements returns the number of covered and total statements
+func (p *CoverageProfile) Statements() (covered, total int) {
+	for _, block := range p.blocks {
+		total += block.statements
+		if block.covered {
+			covered += block.statements
+		}
+	}
+	return
+}
+
+// Percent returns the percentage of statements covered
+func (p *CoverageProfile) Percent() float64 {
+	covered, total := p.Statements()
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// CoverageResult compares the coverage of one Component to its baseline
+type CoverageResult struct {
+	Component string   `json:"component"`
+	Covered   int      `json:"covered"`
+	Total     int      `json:"total"`
+	Percent   float64  `json:"percent"`
+	Baseline  *float64 `json:"baseline,omitempty"`
+	Passed    bool     `json:"passed"`
+}
+
+// Change returns the change in percentage points relative to the baseline
+func (r CoverageResult) Change() float64 {
+	if r.Baseline == nil {
+		return 0
+	}
+	return r.Percent - *r.Baseline
+}
+
+// coverageBaseline is the stored form of a coverage baseline
+type coverageBaseline struct {
+	Percent float64 `json:"percent"`
+}
+
+// CoverageGate compares merged coverage of Components to stored baselines
+type CoverageGate struct {
+
+	// Name used to prefix the baseline of each Component
+	Name string
+
+	// Threshold is the drop in percentage points that is tolerated
+	Threshold float64
+
+	// Update the baseline when coverage does not drop
+	Update bool
+
+	// Baselines where coverage is stored. Nothing is compared if nil.
+	Baselines BaselineStore
+}
+
+func (g *CoverageGate) baselineName(component string) string {
+	return fmt.Sprintf("%s/%s", g.Name, component)
+}
+
+// Check the coverage of each Component against its baseline
+func (g *CoverageGate) Check(
+	ctx context.Context,
+	profiles map[string]*CoverageProfile,
+) ([]CoverageResult, error) {
+
+	var components []string
+	for c := range profiles {
+		components = append(components, c)
+	}
+	sort.Strings(components)
+
+	var results []CoverageResult
+	for _, c := range components {
+		profile := profiles[c]
+		covered, total := profile.Statements()
+		result := CoverageResult{
+			Component: c,
+			Covered:   covered,
+			Total:     total,
+			Percent:   math.Round(profile.Percent()*100) / 100,
+			Passed:    true,
+		}
+		if g.Baselines != nil {
+			data, err := g.Baselines.ReadBaseline(ctx, g.baselineName(c))
+			if err != nil {
+				return nil, err
+			}
+			if data != nil {
+				var baseline coverageBaseline
+				if err := json.Unmarshal(data, &baseline); err != nil {
+					return nil, fmt.Errorf("invalid coverage baseline for %s: %s", c, err)
+				}
+				result.Baseline = &baseline.Percent
+				result.Passed = result.Change() >= -g.Threshold
+			}
+			// Ratchet the baseline upwards so that small drops can't accumulate
+			if g.Update && (result.Baseline == nil || result.Change() > 0) {
+				data, err := json.Marshal(coverageBaseline{Percent: result.Percent})
+				if err != nil {
+					return nil, err
+				}
+				if err := g.Baselines.WriteBaseline(ctx, g.baselineName(c), data); err != nil {
+					return nil, err
+				}
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// coverageProfiles merges the coverage reports found in the outputs of a
+// Rule's dependencies, grouped by the Component of each dependency. Only
+// outputs whose names match one of the patterns are considered, if any
+// patterns are given.
+func coverageProfiles(r *Rule, patterns []string) (map[string]*CoverageProfile, error) {
+	profiles := map[string]*CoverageProfile{}
+	for _, dep := range r.Dependencies() {
+		for _, output := range dep.Outputs() {
+			if !output.OnFilesystem() {
+				continue
+			}
+			name := filepath.Base(output.Path())
+			if len(patterns) > 0 && !matchesAny(name, patterns) {
+				continue
+			}
+			info, err := os.Stat(output.Path())
+			if err != nil || info.IsDir() {
+				continue
+			}
+			component := dep.Component().Name()
+			profile, found := profiles[component]
+			if !found {
+				profile = NewCoverageProfile()
+			}
+			f, err := os.Open(output.Path())
+			if err != nil {
+				return nil, err
+			}
+			ok, err := profile.Merge(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read coverage %s: %s", output.Path(), err)
+			}
+			if ok {
+				profiles[component] = profile
+			}
+		}
+	}
+	return profiles, nil
+}
+
+func matchesAny(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Merges coverage reports produced by dependencies of the Rule and fails if
+// the coverage of any Component dropped by more than the threshold. The
+// results are written as JSON to the first Rule output, if there is one.
+func (runner *StandardRunner) execCoverageCommand(
+	ctx context.Context,
+	r *Rule,
+	executor exec.Executor,
+	execOpts exec.ExecOpts,
+	cmd *Command,
+) error {
+	threshold, err := getCommandFloatAttr(cmd, "threshold", 0)
+	if err != nil {
+		return err
+	}
+	update, err := getCommandBoolAttr(cmd, "update", true)
+	if err != nil {
+		return err
+	}
+	patterns, err := getCommandListAttr(cmd, "reports")
+	if err != nil {
+		return err
+	}
+	gate := &CoverageGate{
+		Name:      getCommandAttr(cmd, "baseline", "coverage"),
+		Threshold: threshold,
+		Update:    update,
+		Baselines: runner.Baselines,
+	}
+	profiles, err := coverageProfiles(r, patterns)
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return fmt.Errorf("no coverage reports found in dependencies of %s", r.NodeID())
+	}
+	results, err := gate.Check(ctx, profiles)
+	if err != nil {
+		return err
+	}
+
+	out := execOpts.Stdout
+	if out == nil {
+		out = ioutil.Discard
+	}
+	var failed []string
+	for _, result := range results {
+		msg := fmt.Sprintf("coverage: %s %.2f%%", result.Component, result.Percent)
+		if result.Baseline != nil {
+			msg += fmt.Sprintf(" (baseline %.2f%%, %+.2f)", *result.Baseline, result.Change())
+		}
+		if !result.Passed {
+			failed = append(failed, result.Component)
+			msg = Red(msg)
+		}
+		fmt.Fprintln(out, msg)
+	}
+
+	if outputs := r.Outputs(); len(outputs) > 0 && outputs[0].OnFilesystem() {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(outputs[0].Path()), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(outputs[0].Path(), data, 0644); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("coverage dropped more than %.2f points: %s",
+			threshold, strings.Join(failed, ", "))
+	}
+	return nil
+}
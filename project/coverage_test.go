@@ -0,0 +1,214 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/fugue/zim/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testGoCoverProfile = `mode: set
+example.com/foo/main.go:3.13,5.2 2 1
+example.com/foo/main.go:7.13,9.2 2 0
+`
+	testGoCoverProfile2 = `mode: set
+example.com/foo/main.go:3.13,5.2 2 0
+example.com/foo/main.go:7.13,9.2 2 1
+example.com/foo/util.go:3.13,9.2 4 0
+`
+	testLcovReport = `TN:
+SF:src/index.js
+DA:1,1
+DA:2,0
+DA:3,4
+end_of_record
+`
+
+	testCompCoverage = `
+name: gate
+rules:
+  coverage:
+    requires:
+     - component: foo
+       rule: test
+    outputs:
+     - coverage.json
+    commands:
+     - coverage:
+         threshold: 5
+`
+)
+
+type testBaselines map[string][]byte
+
+func (b testBaselines) ReadBaseline(ctx context.Context, name string) ([]byte, error) {
+	return b[name], nil
+}
+
+func (b testBaselines) WriteBaseline(ctx context.Context, name string, data []byte) error {
+	b[name] = data
+	return nil
+}
+
+func TestCoverageProfileMerge(t *testing.T) {
+
+	p := NewCoverageProfile()
+	ok, err := p.Merge(strings.NewReader(testGoCoverProfile))
+	require.Nil(t, err)
+	assert.True(t, ok)
+	covered, total := p.Statements()
+	assert.Equal(t, 2, covered)
+	assert.Equal(t, 4, total)
+
+	// Blocks covered by either profile are covered once merged
+	ok, err = p.Merge(strings.NewReader(testGoCoverProfile2))
+	require.Nil(t, err)
+	assert.True(t, ok)
+	covered, total = p.Statements()
+	assert.Equal(t, 4, covered)
+	assert.Equal(t, 8, total)
+	assert.Equal(t, 50.0, p.Percent())
+
+	lcov := NewCoverageProfile()
+	ok, err = lcov.Merge(strings.NewReader(testLcovReport))
+	require.Nil(t, err)
+	assert.True(t, ok)
+	covered, total = lcov.Statements()
+	assert.Equal(t, 2, covered)
+	assert.Equal(t, 3, total)
+
+	// Files in other formats are ignored
+	ok, err = NewCoverageProfile().Merge(strings.NewReader("PASS\nok foo 0.1s\n"))
+	require.Nil(t, err)
+	assert.False(t, ok)
+
+	_, err = NewCoverageProfile().Merge(strings.NewReader("mode: set\nbad line\n"))
+	assert.NotNil(t, err)
+}
+
+func TestCoverageGate(t *testing.T) {
+
+	ctx := context.Background()
+	profile := func(report string) *CoverageProfile {
+		p := NewCoverageProfile()
+		_, err := p.Merge(strings.NewReader(report))
+		require.Nil(t, err)
+		return p
+	}
+	baselines := testBaselines{}
+	gate := &CoverageGate{
+		Name:      "coverage",
+		Threshold: 10,
+		Update:    true,
+		Baselines: baselines,
+	}
+
+	// Without a baseline the gate passes and stores one
+	results, err := gate.Check(ctx, map[string]*CoverageProfile{
+		"foo": profile(testGoCoverProfile),
+	})
+	require.Nil(t, err)
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Passed)
+	assert.Nil(t, results[0].Baseline)
+	assert.Equal(t, 50.0, results[0].Percent)
+	assert.JSONEq(t, `{"percent":50}`, string(baselines["coverage/foo"]))
+
+	// A drop within the threshold passes without lowering the baseline
+	baselines["coverage/foo"] = []byte(`{"percent":55}`)
+	results, err = gate.Check(ctx, map[string]*CoverageProfile{
+		"foo": profile(testGoCoverProfile),
+	})
+	require.Nil(t, err)
+	assert.True(t, results[0].Passed)
+	assert.Equal(t, -5.0, results[0].Change())
+	assert.JSONEq(t, `{"percent":55}`, string(baselines["coverage/foo"]))
+
+	// A larger drop fails
+	baselines["coverage/foo"] = []byte(`{"percent":75}`)
+	results, err = gate.Check(ctx, map[string]*CoverageProfile{
+		"foo": profile(testGoCoverProfile),
+	})
+	require.Nil(t, err)
+	assert.False(t, results[0].Passed)
+
+	// Increases raise the baseline
+	baselines["coverage/foo"] = []byte(`{"percent":25}`)
+	results, err = gate.Check(ctx, map[string]*CoverageProfile{
+		"foo": profile(testGoCoverProfile),
+	})
+	require.Nil(t, err)
+	assert.True(t, results[0].Passed)
+	assert.JSONEq(t, `{"percent":50}`, string(baselines["coverage/foo"]))
+}
+
+func TestCoverageCommand(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	testComponent(dir, "gate", testCompCoverage, nil)
+	p, err := New(dir)
+	require.Nil(t, err)
+
+	fooTest, found := p.Rule("foo", "test")
+	require.True(t, found)
+	gate, found := p.Rule("gate", "coverage")
+	require.True(t, found)
+
+	// The foo test rule output is a coverage report
+	output := fooTest.Outputs()[0].Path()
+	require.Nil(t, os.MkdirAll(path.Dir(output), 0755))
+	require.Nil(t, ioutil.WriteFile(output, []byte(testGoCoverProfile), 0644))
+
+	baselines := testBaselines{"coverage/foo": []byte(`{"percent":54}`)}
+	runner := &StandardRunner{Baselines: baselines}
+	code, err := runner.Run(context.Background(), gate, RunOpts{
+		Executor: exec.NewBashExecutor(),
+		Output:   ioutil.Discard,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, OK, code)
+
+	var results []CoverageResult
+	data, err := ioutil.ReadFile(gate.Outputs()[0].Path())
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(data, &results))
+	require.Len(t, results, 1)
+	assert.Equal(t, "foo", results[0].Component)
+	assert.Equal(t, 50.0, results[0].Percent)
+
+	// Coverage dropping beyond the threshold fails the rule
+	baselines["coverage/foo"] = []byte(`{"percent":80}`)
+	code, err = runner.Run(context.Background(), gate, RunOpts{
+		Executor: exec.NewBashExecutor(),
+		Output:   ioutil.Discard,
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, ExecError, code)
+	assert.Contains(t, err.Error(), "coverage dropped more than 5.00 points: foo")
+}
@@ -24,25 +24,6 @@ import (
 	"github.com/fugue/zim/definitions"
 )
 
-var ignoreDirs map[string]bool
-
-func init() {
-	ignoreDirs = map[string]bool{
-		".git":         true,
-		"node_modules": true,
-		"build":        true,
-		"artifacts":    true,
-		"dist":         true,
-		"venv":         true,
-		"venvs":        true,
-		".mypy_cache":  true,
-		".cache":       true,
-		".npm":         true,
-		".stack-work":  true,
-		"vendor":       true,
-	}
-}
-
 func fileExists(p string) bool {
 	if _, err := os.Stat(p); err == nil {
 		return true
@@ -50,18 +31,31 @@ func fileExists(p string) bool {
 	return false
 }
 
+// discoverDefs returns the paths of Component definitions within the root
+// directory, skipping the default ignored directories and any paths ignored
+// by the .zimignore file
 func discoverDefs(root string) ([]string, error) {
 
+	ignore, err := LoadIgnore(root, defaultIgnorePatterns...)
+	if err != nil {
+		return nil, err
+	}
+
 	var paths []string
 
 	callback := func(filePath string, fileInfo os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
-		if fileInfo.IsDir() {
-			if ignore := ignoreDirs[filepath.Base(filePath)]; ignore {
+		relPath, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		if relPath != "." && ignore.Ignored(relPath, fileInfo.IsDir()) {
+			if fileInfo.IsDir() {
 				return filepath.SkipDir
 			}
+			return nil
 		}
 		if fileInfo.Name() != "component.yaml" && fileInfo.Name() != "zim.yaml" {
 			return nil
@@ -77,7 +71,10 @@ func discoverDefs(root string) ([]string, error) {
 }
 
 // Discover Components located within the given directory. The directory
-// structure is searched recursively. Returns loaded Component definitions.
+// structure is searched recursively. Components printed by the project's
+// discovery command are included, and a definition on disk with the same
+// name as a generated one is merged over it. Returns loaded Component
+// definitions.
 func Discover(root string) (*definitions.Project, []*definitions.Component, error) {
 
 	var err error
@@ -103,12 +100,16 @@ func Discover(root string) (*definitions.Project, []*definitions.Component, erro
 			return nil, nil, err
 		}
 	} else {
+		ignore, err := LoadIgnore(root)
+		if err != nil {
+			return nil, nil, err
+		}
 		for _, pattern := range componentPatterns {
 			matches, err := MatchFiles(root, pattern)
 			if err != nil {
 				return nil, nil, err
 			}
-			paths = append(paths, matches...)
+			paths = append(paths, ignore.Filter(root, matches)...)
 		}
 	}
 
@@ -134,34 +135,65 @@ func Discover(root string) (*definitions.Project, []*definitions.Component, erro
 		templates[def.Kind] = def
 	}
 
-	for _, defPath := range paths {
-		def, err := definitions.LoadComponentFromPath(defPath)
-		if err != nil {
-			return nil, nil, fmt.Errorf("invalid component %s: %s", defPath, err)
+	generated, err := generatedDefs(root, pDef)
+	if err != nil {
+		return nil, nil, err
+	}
+	generatedByName := map[string]*definitions.Component{}
+	for _, def := range generated {
+		if _, used := generatedByName[def.Name]; used {
+			return nil, nil, fmt.Errorf("duplicate component name: %s", def.Name)
 		}
+		generatedByName[def.Name] = def
+	}
+
+	addDef := func(def *definitions.Component, source string) error {
 		// Ignore components by request
 		if def.Ignore {
-			continue
-		}
-		// Require component name to be filled in
-		if def.Name == "" {
-			return nil, nil, fmt.Errorf("Component name unset in %s", defPath)
+			return nil
 		}
 		// Disallow duplicate component names
 		if _, used := nameUsed[def.Name]; used {
-			return nil, nil, fmt.Errorf("duplicate component name: %s", def.Name)
+			return fmt.Errorf("duplicate component name: %s", def.Name)
 		}
 		nameUsed[def.Name] = true
 		// Raise error if definition kind is unknown
 		tmpl, found := templates[def.Kind]
 		if def.Kind != "" && !found {
-			return nil, nil, fmt.Errorf("Component kind unknown %s: %s", defPath, def.Kind)
+			return fmt.Errorf("Component kind unknown %s: %s", source, def.Kind)
 		}
 		if tmpl != nil {
 			defs = append(defs, tmpl.Merge(def))
 		} else {
 			defs = append(defs, def)
 		}
+		return nil
+	}
+
+	for _, defPath := range paths {
+		def, err := definitions.LoadComponentFromPath(defPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid component %s: %s", defPath, err)
+		}
+		// Require component name to be filled in
+		if def.Name == "" && !def.Ignore {
+			return nil, nil, fmt.Errorf("Component name unset in %s", defPath)
+		}
+		if gen, found := generatedByName[def.Name]; found {
+			def = gen.Merge(def)
+			delete(generatedByName, def.Name)
+		}
+		if err := addDef(def, defPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, def := range generated {
+		if _, remaining := generatedByName[def.Name]; !remaining {
+			continue
+		}
+		if err := addDef(def, "discovery command"); err != nil {
+			return nil, nil, err
+		}
 	}
 	return pDef, defs, nil
 }
@@ -14,6 +14,8 @@
 package project
 
 import (
+	"io/ioutil"
+	"os"
 	"path"
 	"testing"
 
@@ -42,3 +44,56 @@ func TestDiscover(t *testing.T) {
 	assert.Equal(t, path.Join(dir, "src", "hammer", "component.yaml"), def0.Path)
 	assert.Equal(t, path.Join(dir, "src", "nail", "component.yaml"), def1.Path)
 }
+
+func TestDiscoverGenerated(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponentDir(dir, "hammer")
+	testComponentDir(dir, "nail")
+
+	require.Nil(t, os.MkdirAll(path.Join(dir, ".zim"), 0755))
+	require.Nil(t, writeFile(path.Join(dir, ".zim", "project.yaml"), `
+discovery_command: echo run >> runs.txt && cat registry.json
+discovery_inputs:
+- registry.json
+`))
+	registry := `[
+		{"name": "nail", "path": "src/nail", "environment": {"SIZE": "2in"}},
+		{"name": "saw", "path": "services/saw", "rules": {"build": {"command": "make"}}}
+	]`
+	require.Nil(t, writeFile(path.Join(dir, "registry.json"), registry))
+
+	_, defs, err := Discover(dir)
+	require.Nil(t, err)
+	require.Len(t, defs, 3)
+	assert.Equal(t, "hammer", defs[0].Name)
+
+	// The definition on disk is merged over the generated one
+	assert.Equal(t, "nail", defs[1].Name)
+	assert.Equal(t, map[string]string{"SIZE": "2in"}, defs[1].Environment)
+	assert.Equal(t, path.Join(dir, "src", "nail", "component.yaml"), defs[1].Path)
+
+	assert.Equal(t, "saw", defs[2].Name)
+	assert.Equal(t, "make", defs[2].Rules["build"].Command)
+	assert.Equal(t, path.Join(dir, "services", "saw", "component.yaml"), defs[2].Path)
+
+	// The output is reused until the inputs change
+	_, defs, err = Discover(dir)
+	require.Nil(t, err)
+	require.Len(t, defs, 3)
+	runs, err := ioutil.ReadFile(path.Join(dir, "runs.txt"))
+	require.Nil(t, err)
+	assert.Equal(t, "run\n", string(runs))
+
+	require.Nil(t, writeFile(path.Join(dir, "registry.json"), `[
+		{"name": "saw", "path": "../saw"}
+	]`))
+	_, _, err = Discover(dir)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "must be a directory within the project")
+	runs, err = ioutil.ReadFile(path.Join(dir, "runs.txt"))
+	require.Nil(t, err)
+	assert.Equal(t, "run\nrun\n", string(runs))
+}
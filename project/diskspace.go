@@ -0,0 +1,95 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InsufficientSpace indicates there is not enough free disk space to write
+// a Rule's outputs
+type InsufficientSpace struct {
+	Dir       string
+	Needed    int64
+	Available int64
+}
+
+func (e InsufficientSpace) Error() string {
+	return fmt.Sprintf("not enough disk space in %s: %s needed, %s available",
+		e.Dir, FormatBytes(e.Needed), FormatBytes(e.Available))
+}
+
+// CheckFreeSpace returns InsufficientSpace if fewer than the needed bytes
+// are available in the filesystem containing the directory. The check is
+// skipped where free space can't be determined.
+func CheckFreeSpace(dir string, needed int64) error {
+	if needed <= 0 {
+		return nil
+	}
+	available, ok := freeSpace(dir)
+	if !ok || available >= needed {
+		return nil
+	}
+	return InsufficientSpace{Dir: dir, Needed: needed, Available: available}
+}
+
+// FormatBytes formats a size in bytes for display
+func FormatBytes(size int64) string {
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+	}
+	for _, unit := range units {
+		if float64(size) >= unit.size {
+			return fmt.Sprintf("%.1f %s", float64(size)/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%.1f KB", float64(size)/(1<<10))
+}
+
+// FormatDuration formats a duration for display, e.g. "350ms", "4.2s", or
+// "3m5s"
+func FormatDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	default:
+		return d.Round(time.Second).String()
+	}
+}
+
+// PathSize returns the size of a file, or the total size of the files
+// within a directory
+func PathSize(p string) (int64, error) {
+	var size int64
+	err := filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
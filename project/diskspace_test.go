@@ -0,0 +1,59 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	require.Nil(t, CheckFreeSpace(dir, 0))
+	require.Nil(t, CheckFreeSpace(dir, 1))
+
+	// Directories that don't exist yet are checked using their parent
+	missing := filepath.Join(dir, "artifacts", "out")
+	require.Nil(t, CheckFreeSpace(missing, 1))
+
+	if _, ok := freeSpace(dir); !ok {
+		t.Skip("free space is not available on this platform")
+	}
+	err := CheckFreeSpace(dir, 1<<62)
+	require.NotNil(t, err)
+	spaceErr, ok := err.(InsufficientSpace)
+	require.True(t, ok)
+	assert.Equal(t, dir, spaceErr.Dir)
+	assert.Contains(t, err.Error(), "4194304.0 TB needed")
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "0.5 KB", FormatBytes(512))
+	assert.Equal(t, "1.5 MB", FormatBytes(3<<19))
+	assert.Equal(t, "2.0 GB", FormatBytes(2<<30))
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "350ms", FormatDuration(350*time.Millisecond))
+	assert.Equal(t, "4.2s", FormatDuration(4200*time.Millisecond))
+	assert.Equal(t, "3m5s", FormatDuration(3*time.Minute+5400*time.Millisecond))
+}
@@ -0,0 +1,181 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/exec"
+)
+
+// dockerBuild describes a docker_build command
+type dockerBuild struct {
+	Dockerfile string
+	Context    string
+	Tags       []string
+	BuildArgs  map[string]string
+	CacheFrom  []string
+	Target     string
+	Platform   string
+	IIDFile    string
+}
+
+// newDockerBuild reads the attributes of a docker_build command. The image
+// ID is written to the first output of the Rule unless an iidfile is given.
+func newDockerBuild(r *Rule, cmd *Command) (*dockerBuild, error) {
+	b := &dockerBuild{
+		Dockerfile: getCommandAttr(cmd, "dockerfile", "Dockerfile"),
+		Context:    getCommandAttr(cmd, "context", "."),
+		Target:     getCommandAttr(cmd, "target", ""),
+		Platform:   getCommandAttr(cmd, "platform", r.Platform()),
+		IIDFile:    getCommandAttr(cmd, "iidfile", ""),
+	}
+	var err error
+	if b.Tags, err = getCommandListAttr(cmd, "tags"); err != nil {
+		return nil, err
+	}
+	if b.CacheFrom, err = getCommandListAttr(cmd, "cache_from"); err != nil {
+		return nil, err
+	}
+	if b.BuildArgs, err = getCommandMapAttr(cmd, "build_args"); err != nil {
+		return nil, err
+	}
+	if b.IIDFile == "" {
+		if outputs := r.Outputs(); len(outputs) > 0 && outputs[0].OnFilesystem() {
+			b.IIDFile = "${ARTIFACT}"
+		}
+	}
+	return b, nil
+}
+
+// Args returns the arguments of the build command run with the container
+// runtime, e.g. docker or podman
+func (b *dockerBuild) Args(runtime string) []string {
+	args := []string{runtime, "build", "-f", b.Dockerfile}
+	for _, tag := range b.Tags {
+		args = append(args, "-t", tag)
+	}
+	names := make([]string, 0, len(b.BuildArgs))
+	for name := range b.BuildArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--build-arg", name+"="+b.BuildArgs[name])
+	}
+	for _, image := range b.CacheFrom {
+		args = append(args, "--cache-from", image)
+	}
+	if b.Target != "" {
+		args = append(args, "--target", b.Target)
+	}
+	if b.Platform != "" {
+		args = append(args, "--platform", b.Platform)
+	}
+	if b.IIDFile != "" {
+		args = append(args, "--iidfile", b.IIDFile)
+	}
+	return append(args, b.Context)
+}
+
+// Script returns the build command line run by the shell. Variables in the
+// arguments are expanded from the environment beforehand and each argument
+// is quoted, so that values may contain spaces and quotes.
+func (b *dockerBuild) Script(runtime string, env []string) string {
+	return shellCommandLine(b.Args(runtime), env)
+}
+
+// shellCommandLine returns a command line that runs the arguments as they
+// are, after expanding variables in them from the environment, a list of
+// NAME=value. Variables not in the environment are taken from the process
+// environment, as the shell would.
+func shellCommandLine(args []string, env []string) string {
+	values := map[string]string{}
+	for _, kv := range env {
+		if parts := strings.SplitN(kv, "=", 2); len(parts) == 2 {
+			values[parts[0]] = parts[1]
+		}
+	}
+	quoted := make([]string, 0, len(args))
+	for _, arg := range args {
+		arg = os.Expand(arg, func(name string) string {
+			if value, found := values[name]; found {
+				return value
+			}
+			return os.Getenv(name)
+		})
+		quoted = append(quoted, shellQuote(arg))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellUnquoted matches arguments the shell reads as they are
+var shellUnquoted = regexp.MustCompile(`^[a-zA-Z0-9_@%+=:,./-]+$`)
+
+// shellQuote quotes an argument for the shell unless it needs no quotes
+func shellQuote(arg string) string {
+	if shellUnquoted.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+}
+
+// iidPath returns the path on the host to the file containing the image ID,
+// or an empty string if it isn't known
+func (b *dockerBuild) iidPath(r *Rule) string {
+	switch {
+	case b.IIDFile == "${ARTIFACT}":
+		return r.Outputs()[0].Path()
+	case b.IIDFile == "" || strings.Contains(b.IIDFile, "$"):
+		return ""
+	case filepath.IsAbs(b.IIDFile):
+		return b.IIDFile
+	default:
+		return filepath.Join(r.Component().Directory(), b.IIDFile)
+	}
+}
+
+// Builds a Docker image with `docker build`, or the build command of the
+// configured container runtime. The image ID is recorded in the Rule output
+// so that it is cached and changes the keys of Rules that use the output as
+// an input.
+func (runner *StandardRunner) execDockerBuildCommand(
+	ctx context.Context,
+	r *Rule,
+	executor exec.Executor,
+	execOpts exec.ExecOpts,
+	cmd *Command,
+) error {
+	b, err := newDockerBuild(r, cmd)
+	if err != nil {
+		return err
+	}
+	execOpts.Command = b.Script(runner.containerRuntime(), execOpts.Env)
+	if err := executor.Execute(ctx, execOpts); err != nil {
+		return err
+	}
+	if p := b.iidPath(r); p != "" && execOpts.Stdout != nil {
+		if id, err := ioutil.ReadFile(p); err == nil {
+			fmt.Fprintf(execOpts.Stdout, "Built image %s\n", strings.TrimSpace(string(id)))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDockerBuildScript(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{
+		{
+			Name: "api",
+			Path: path.Join(dir, "api", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"image": {
+					Inputs:  []string{"Dockerfile"},
+					Outputs: []string{"api.iid"},
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs, Platform: "linux/arm64"})
+	require.Nil(t, err)
+	r := p.Components().First().MustRule("image")
+
+	cmd, err := definitions.GetCommand(map[interface{}]interface{}{
+		"docker_build": map[interface{}]interface{}{
+			"dockerfile": "build/Dockerfile",
+			"tags":       []interface{}{"api:latest", "api:${VERSION}"},
+			"cache_from": "api:latest",
+			"build_args": map[interface{}]interface{}{
+				"VERSION": "${VERSION}",
+				"DEBUG":   false,
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	b, err := newDockerBuild(r, &Command{Kind: cmd.Kind, Attributes: cmd.Attributes})
+	require.Nil(t, err)
+	require.Equal(t, []string{"podman", "build", "-f", "build/Dockerfile",
+		"-t", "api:latest", "-t", "api:${VERSION}",
+		"--build-arg", "DEBUG=false", "--build-arg", "VERSION=${VERSION}",
+		"--cache-from", "api:latest", "--platform", "linux/arm64",
+		"--iidfile", "${ARTIFACT}", "."}, b.Args("podman"))
+
+	// Variables are expanded before the arguments are quoted for the shell
+	env := []string{`VERSION=1.0 "beta" 'rc'`, "ARTIFACT=/out/api.iid"}
+	require.Equal(t, "docker build -f build/Dockerfile -t api:latest"+
+		` -t 'api:1.0 "beta" '\''rc'\''' --build-arg DEBUG=false`+
+		` --build-arg 'VERSION=1.0 "beta" '\''rc'\'''`+
+		" --cache-from api:latest --platform linux/arm64 --iidfile /out/api.iid .",
+		b.Script("docker", env))
+	require.Equal(t, path.Join(dir, "artifacts", "linux_arm64", "api.iid"), b.iidPath(r))
+
+	// Defaults for a rule without outputs
+	r.outputs = nil
+	b, err = newDockerBuild(r, &Command{Kind: "docker_build", Attributes: map[string]interface{}{
+		"context":  "..",
+		"platform": "linux/amd64",
+	}})
+	require.Nil(t, err)
+	require.Equal(t, "docker build -f Dockerfile --platform linux/amd64 ..", b.Script("docker", nil))
+	require.Equal(t, "", b.iidPath(r))
+
+	_, err = newDockerBuild(r, &Command{Kind: "docker_build", Attributes: map[string]interface{}{
+		"build_args": []interface{}{"VERSION=1"},
+	}})
+	require.NotNil(t, err)
+}
@@ -0,0 +1,131 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/fugue/zim/exec"
+)
+
+// pushDigestPattern matches the digest reported by docker push
+var pushDigestPattern = regexp.MustCompile(`digest: (sha256:[0-9a-f]{64})`)
+
+// dockerPush describes a docker_push command
+type dockerPush struct {
+	Image      string
+	Retries    int
+	Delay      time.Duration
+	DigestFile string
+}
+
+// newDockerPush reads the attributes of a docker_push command. The digest
+// file, if any, must be one of the Rule outputs so that it is cached.
+func newDockerPush(r *Rule, cmd *Command) (*dockerPush, error) {
+	p := &dockerPush{Image: getCommandAttr(cmd, "image", "")}
+	if p.Image == "" {
+		return nil, fmt.Errorf("docker_push command has no image specified")
+	}
+	retries, err := getCommandFloatAttr(cmd, "retries", 3)
+	if err != nil {
+		return nil, err
+	}
+	if retries < 0 {
+		return nil, fmt.Errorf("invalid retries: %v", retries)
+	}
+	p.Retries = int(retries)
+	delay := getCommandAttr(cmd, "delay", "1s")
+	if p.Delay, err = time.ParseDuration(delay); err != nil || p.Delay < 0 {
+		return nil, fmt.Errorf("invalid delay: %s", delay)
+	}
+	if name := getCommandAttr(cmd, "digest_file", ""); name != "" {
+		if name, err = expandVars(r, name, r.BaseEnvironment()); err != nil {
+			return nil, err
+		}
+		outputs := r.Outputs()
+		for i, out := range r.outputs {
+			if out == name && outputs[i].OnFilesystem() {
+				p.DigestFile = outputs[i].Path()
+			}
+		}
+		if p.DigestFile == "" {
+			return nil, fmt.Errorf("docker_push digest_file %s is not an output of %s",
+				name, r.NodeID())
+		}
+	}
+	return p, nil
+}
+
+// Pushes a Docker image with `docker push`, or the push command of the
+// configured container runtime, retrying failed pushes with an exponential
+// backoff. The pushed digest is written to the digest file.
+func (runner *StandardRunner) execDockerPushCommand(
+	ctx context.Context,
+	r *Rule,
+	executor exec.Executor,
+	execOpts exec.ExecOpts,
+	cmd *Command,
+) error {
+	p, err := newDockerPush(r, cmd)
+	if err != nil {
+		return err
+	}
+	output := execOpts.Stdout
+	if output == nil {
+		output = ioutil.Discard
+	}
+	var pushOutput bytes.Buffer
+	execOpts.Stdout = io.MultiWriter(output, &pushOutput)
+	runtime := runner.containerRuntime()
+	execOpts.Command = shellCommandLine([]string{runtime, "push", p.Image}, execOpts.Env)
+
+	delay := p.Delay
+	for attempt := 0; ; attempt++ {
+		pushOutput.Reset()
+		err = executor.Execute(ctx, execOpts)
+		if err == nil || attempt >= p.Retries || ctx.Err() != nil {
+			break
+		}
+		fmt.Fprintf(output, "%s push failed, retrying in %s (%d/%d): %s\n",
+			runtime, delay, attempt+1, p.Retries, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+		delay *= 2
+	}
+	if err != nil {
+		return err
+	}
+	if p.DigestFile == "" {
+		return nil
+	}
+	match := pushDigestPattern.FindStringSubmatch(pushOutput.String())
+	if match == nil {
+		return fmt.Errorf("%s push of %s did not report a digest", runtime, p.Image)
+	}
+	if err := os.MkdirAll(filepath.Dir(p.DigestFile), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p.DigestFile, []byte(match[1]+"\n"), 0644)
+}
@@ -0,0 +1,111 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
+	"github.com/stretchr/testify/require"
+)
+
+// pushExecutor fails a number of pushes before reporting a digest
+type pushExecutor struct {
+	failures int
+	commands []string
+}
+
+func (e *pushExecutor) Execute(ctx context.Context, opts exec.ExecOpts) error {
+	e.commands = append(e.commands, opts.Command)
+	if len(e.commands) <= e.failures {
+		return errors.New("connection reset")
+	}
+	fmt.Fprintf(opts.Stdout, "latest: digest: sha256:%s size: 528\n", strings.Repeat("a", 64))
+	return nil
+}
+
+func (e *pushExecutor) UsesDocker() bool { return false }
+
+func (e *pushExecutor) ExecutorPath(hostPath string) (string, error) { return hostPath, nil }
+
+func TestDockerPush(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{
+		{
+			Name: "api",
+			Path: path.Join(dir, "api", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"push": {
+					Outputs: []string{"${NAME}.digest"},
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	r := p.Components().First().MustRule("push")
+
+	cmd := &Command{Kind: "docker_push", Attributes: map[string]interface{}{
+		"image":       "registry.example.com/api:latest",
+		"retries":     2,
+		"delay":       "1ms",
+		"digest_file": "${NAME}.digest",
+	}}
+	executor := &pushExecutor{failures: 2}
+	var out bytes.Buffer
+	runner := &StandardRunner{ContainerRuntime: "podman"}
+	err = runner.execDockerPushCommand(context.Background(), r, executor,
+		exec.ExecOpts{Stdout: &out}, cmd)
+	require.Nil(t, err)
+	require.Len(t, executor.commands, 3)
+	require.Equal(t, "podman push registry.example.com/api:latest", executor.commands[0])
+	require.Contains(t, out.String(), "retrying in 2ms (2/2)")
+
+	digest, err := ioutil.ReadFile(path.Join(dir, "artifacts", "api.digest"))
+	require.Nil(t, err)
+	require.Equal(t, "sha256:"+strings.Repeat("a", 64)+"\n", string(digest))
+
+	// Pushes fail once the retries are used up
+	executor = &pushExecutor{failures: 3}
+	err = runner.execDockerPushCommand(context.Background(), r, executor,
+		exec.ExecOpts{Stdout: &out}, cmd)
+	require.NotNil(t, err)
+	require.Len(t, executor.commands, 3)
+
+	// Variables in the image are expanded from the environment
+	cmd.Attributes["image"] = "registry.example.com/api:${VERSION}"
+	executor = &pushExecutor{}
+	err = runner.execDockerPushCommand(context.Background(), r, executor,
+		exec.ExecOpts{Stdout: &out, Env: []string{"VERSION=1.2"}}, cmd)
+	require.Nil(t, err)
+	require.Equal(t, "podman push registry.example.com/api:1.2", executor.commands[0])
+
+	// The digest file must be a rule output
+	cmd.Attributes["digest_file"] = "other.digest"
+	_, err = newDockerPush(r, cmd)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "is not an output of api.push")
+}
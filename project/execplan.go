@@ -0,0 +1,154 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// ExecutionPlan describes Rules in enough detail for an external system to
+// run them. Rules are listed after the Rules they depend on. Paths are
+// relative to the project root, except in the environment variables which
+// are set as they would be when running locally.
+type ExecutionPlan struct {
+	Project string        `json:"project"`
+	Root    string        `json:"root"`
+	BuildID string        `json:"build_id"`
+	Rules   []PlannedRule `json:"rules"`
+}
+
+// PlannedCommand is one command of a PlannedRule
+type PlannedCommand struct {
+	Kind       string                 `json:"kind"`
+	Argument   string                 `json:"argument,omitempty"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// PlannedRule is one Rule of an ExecutionPlan. Secrets are references for
+// the executor to resolve, never their values.
+type PlannedRule struct {
+	ID         string            `json:"id"`
+	Component  string            `json:"component"`
+	Rule       string            `json:"rule"`
+	Key        string            `json:"key,omitempty"`
+	Image      string            `json:"image,omitempty"`
+	Native     bool              `json:"native"`
+	Platform   string            `json:"platform,omitempty"`
+	WorkingDir string            `json:"working_dir"`
+	Timeout    string            `json:"timeout,omitempty"`
+	Env        map[string]string `json:"env"`
+	Secrets    map[string]string `json:"secrets,omitempty"`
+	Commands   []PlannedCommand  `json:"commands"`
+	Inputs     []string          `json:"inputs"`
+	Outputs    []string          `json:"outputs"`
+	Requires   []string          `json:"requires"`
+}
+
+// KeyFunc returns the cache key of a Rule
+type KeyFunc func(r *Rule) (string, error)
+
+// NewExecutionPlan returns a plan to run the Rules and their dependencies.
+// Keys are included if a KeyFunc is given.
+func NewExecutionPlan(p *Project, buildID string, rules []*Rule, key KeyFunc) (*ExecutionPlan, error) {
+
+	nodes, err := GraphFromRules(rules).Sort()
+	if err != nil {
+		return nil, fmt.Errorf("failed to sort rules: %s", err)
+	}
+	plan := &ExecutionPlan{
+		Project: p.Name(),
+		Root:    p.RootAbsPath(),
+		BuildID: buildID,
+		Rules:   make([]PlannedRule, 0, len(nodes)),
+	}
+	// The sort places each Rule before its dependencies
+	for i := len(nodes) - 1; i >= 0; i-- {
+		planned, err := newPlannedRule(nodes[i].(*Rule), buildID, key)
+		if err != nil {
+			return nil, err
+		}
+		plan.Rules = append(plan.Rules, planned)
+	}
+	return plan, nil
+}
+
+func newPlannedRule(r *Rule, buildID string, key KeyFunc) (PlannedRule, error) {
+
+	root := r.Project().RootAbsPath()
+	planned := PlannedRule{
+		ID:        r.NodeID(),
+		Component: r.Component().Name(),
+		Rule:      r.Name(),
+		Image:     r.Image(),
+		Native:    r.IsNative(),
+		Platform:  r.Platform(),
+		Requires:  []string{},
+	}
+	if r.Timeout() > 0 {
+		planned.Timeout = r.Timeout().String()
+	}
+	workingDir, err := filepath.Rel(root, r.Component().Directory())
+	if err != nil {
+		return planned, err
+	}
+	planned.WorkingDir = workingDir
+
+	env, err := r.Environment()
+	if err != nil {
+		return planned, fmt.Errorf("Environment error %s: %s", r.NodeID(), err)
+	}
+	for k, v := range buildEnvironment(RunOpts{BuildID: buildID}) {
+		env[k] = v
+	}
+	env["ROOT"] = root
+	env["ARTIFACTS_DIR"] = r.ArtifactsDir()
+	if outputs := r.Outputs(); len(outputs) > 0 {
+		env["ARTIFACT"] = outputs[0].Path()
+	}
+	planned.Env = env
+
+	for name, ref := range r.Secrets() {
+		if planned.Secrets == nil {
+			planned.Secrets = map[string]string{}
+		}
+		planned.Secrets[name] = ref.String()
+	}
+	for _, cmd := range r.Commands() {
+		planned.Commands = append(planned.Commands, PlannedCommand{
+			Kind:       cmd.Kind,
+			Argument:   cmd.Argument,
+			Attributes: cmd.Attributes,
+		})
+	}
+	inputs, err := r.Inputs()
+	if err != nil {
+		return planned, err
+	}
+	if planned.Inputs, err = inputs.RelativePaths(root); err != nil {
+		return planned, err
+	}
+	if planned.Outputs, err = r.Outputs().RelativePaths(root); err != nil {
+		return planned, err
+	}
+	for _, dep := range r.Dependencies() {
+		planned.Requires = append(planned.Requires, dep.NodeID())
+	}
+	if key != nil {
+		if planned.Key, err = key(r); err != nil {
+			return planned, err
+		}
+	}
+	return planned, nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecutionPlan(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Outputs: []string{"app"},
+					Command: "go build -o ${ARTIFACT}",
+				},
+				"package": {
+					Requires: []definitions.Dependency{{Rule: "build"}},
+					Outputs:  []string{"app.tar.gz"},
+					Secrets:  map[string]string{"TOKEN": "ssm:/ci/token"},
+					Commands: []interface{}{"cleandir", map[interface{}]interface{}{
+						"run": "tar czf ${ARTIFACT} ${DEPS}",
+					}},
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	pkg := p.Components().First().MustRule("package")
+
+	plan, err := NewExecutionPlan(p, "123", []*Rule{pkg}, func(r *Rule) (string, error) {
+		return "key-" + r.Name(), nil
+	})
+	require.Nil(t, err)
+	require.Equal(t, "123", plan.BuildID)
+	require.Len(t, plan.Rules, 2)
+
+	build := plan.Rules[0]
+	require.Equal(t, "app.build", build.ID)
+	require.Equal(t, "key-build", build.Key)
+	require.Equal(t, "app", build.WorkingDir)
+	require.Equal(t, []string{"artifacts/app"}, build.Outputs)
+	require.Empty(t, build.Requires)
+
+	packaged := plan.Rules[1]
+	require.Equal(t, "app.package", packaged.ID)
+	require.Equal(t, []string{"app.build"}, packaged.Requires)
+	require.Equal(t, []PlannedCommand{
+		{Kind: "cleandir"},
+		{Kind: "run", Argument: "tar czf ${ARTIFACT} ${DEPS}"},
+	}, packaged.Commands)
+	require.Equal(t, map[string]string{"TOKEN": "ssm:/ci/token"}, packaged.Secrets)
+	require.Equal(t, "123", packaged.Env[BuildIDVariable])
+	require.Equal(t, path.Join(dir, "artifacts", "app.tar.gz"), packaged.Env["ARTIFACT"])
+	require.Equal(t, "../artifacts/app", packaged.Env["DEP"])
+}
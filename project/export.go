@@ -1,16 +1,34 @@
 package project
 
 import (
+	"fmt"
 	"sync"
 )
 
+// Rule returns the Rule that produces this export, if the export was
+// declared with a producing rule. Returns nil otherwise.
+func (e *Export) Rule() (*Rule, error) {
+	if e.FromRule == "" {
+		return nil, nil
+	}
+	r, found := e.Component.Rule(e.FromRule)
+	if !found {
+		return nil, fmt.Errorf("export rule not found: %s.%s",
+			e.Component.Name(), e.FromRule)
+	}
+	return r, nil
+}
+
 // Export defines resources exposed by a Component. The resources referenced by
 // an export must be static, which allows the export to be resolved only once.
+// When FromRule is set, the export instead refers to the outputs of that Rule
+// and importing the export implies a dependency on the Rule.
 type Export struct {
 	Component         *Component
 	Provider          Provider
 	Resources         []string
 	Ignore            []string
+	FromRule          string
 	mutex             sync.Mutex
 	resolvedResources Resources
 	resolvedError     error
@@ -31,6 +49,20 @@ func (e *Export) Resolve() (Resources, error) {
 		return e.resolvedResources, e.resolvedError
 	}
 
+	// Exports produced by a rule consist of that rule's outputs
+	if e.FromRule != "" {
+		r, err := e.Rule()
+		if err != nil {
+			e.resolved = true
+			e.resolvedError = err
+			return nil, err
+		}
+		e.resolvedResources = r.Outputs()
+		e.resolvedError = nil
+		e.resolved = true
+		return e.resolvedResources, nil
+	}
+
 	// Discover exported resources
 	matches, err := matchResources(e.Component, e.Provider, e.Resources)
 	if err != nil {
@@ -21,14 +21,20 @@ import (
 	"time"
 )
 
-// FileSystem implements Provider
+// FileSystem implements Provider. Files ignored by the project's .zimignore
+// file are excluded from glob matches.
 type FileSystem struct {
-	root string
+	root   string
+	ignore *Ignore
 }
 
 // NewFileSystem returns
 func NewFileSystem(root string) (*FileSystem, error) {
-	return &FileSystem{root}, nil
+	ignore, err := LoadIgnore(root)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSystem{root: root, ignore: ignore}, nil
 }
 
 // Init accepts configuration options from Project configuration
@@ -67,6 +73,7 @@ func (fs *FileSystem) Match(pattern string) (Resources, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to match resources %s: %s", pattern, err)
 	}
+	matches = fs.ignore.Filter(fs.root, matches)
 	rs := make(Resources, 0, len(matches))
 	for _, match := range matches {
 		rs = append(rs, fs.New(match))
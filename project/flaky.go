@@ -0,0 +1,317 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlakyThreshold is the number of flakes after which a Rule is considered
+// flaky. A flake is a failure followed by a pass with unchanged inputs.
+const FlakyThreshold = 2
+
+// Retry policies for failing test Rules
+const (
+	// RetryNone never retries a failing Rule
+	RetryNone = "none"
+
+	// RetryFlaky retries Rules already known to be flaky once
+	RetryFlaky = "flaky"
+
+	// RetryAll retries any failing test Rule once
+	RetryAll = "all"
+)
+
+// RuleFlakiness records the pass and fail history of one test Rule
+type RuleFlakiness struct {
+	Passed    int       `json:"passed"`
+	Failed    int       `json:"failed"`
+	Flaked    int       `json:"flaked"`
+	LastFlake time.Time `json:"last_flake,omitempty"`
+
+	// Fingerprint of the inputs of the most recent failure, which is
+	// cleared once the Rule passes
+	FailedInputs string `json:"failed_inputs,omitempty"`
+}
+
+// Runs returns the number of recorded runs of the Rule
+func (f RuleFlakiness) Runs() int {
+	return f.Passed + f.Failed
+}
+
+// Flaky returns true if the Rule has flaked repeatedly
+func (f RuleFlakiness) Flaky() bool {
+	return f.Flaked >= FlakyThreshold
+}
+
+// FlakeRate returns the fraction of runs that flaked
+func (f RuleFlakiness) FlakeRate() float64 {
+	if f.Runs() == 0 {
+		return 0
+	}
+	return float64(f.Flaked) / float64(f.Runs())
+}
+
+// FlakyRule pairs a Rule ID with its flakiness
+type FlakyRule struct {
+	Rule string
+	RuleFlakiness
+}
+
+// FlakyTracker persists pass and fail history of test Rules between runs
+// of Zim in order to identify flaky tests. A Rule flakes when it fails and
+// then passes without its inputs changing, either on an automatic retry or
+// on a later run.
+type FlakyTracker struct {
+	mutex  sync.Mutex
+	path   string
+	rules  map[string]*RuleFlakiness
+	policy string
+	output io.Writer
+}
+
+// LoadFlakyTracker reads flakiness history from the given file. An empty
+// history is returned if the file does not yet exist.
+func LoadFlakyTracker(path string) (*FlakyTracker, error) {
+	t := &FlakyTracker{
+		path:   path,
+		rules:  map[string]*RuleFlakiness{},
+		policy: RetryNone,
+		output: os.Stdout,
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return t, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &t.rules); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// SetRetryPolicy sets when failing test Rules are retried
+func (t *FlakyTracker) SetRetryPolicy(policy string) error {
+	switch policy {
+	case "":
+		policy = RetryNone
+	case RetryNone, RetryFlaky, RetryAll:
+	default:
+		return fmt.Errorf("Invalid retry policy: %s", policy)
+	}
+	t.mutex.Lock()
+	t.policy = policy
+	t.mutex.Unlock()
+	return nil
+}
+
+// Save writes the history to the file it was loaded from
+func (t *FlakyTracker) Save() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	data, err := json.MarshalIndent(t.rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.path, data, 0644)
+}
+
+// Rule returns the flakiness of the given Rule
+func (t *FlakyTracker) Rule(r *Rule) (RuleFlakiness, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	f, found := t.rules[r.NodeID()]
+	if !found {
+		return RuleFlakiness{}, false
+	}
+	return *f, true
+}
+
+// Rules returns the Rules that have flaked at least once, with the highest
+// flake rate first
+func (t *FlakyTracker) Rules() []FlakyRule {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var result []FlakyRule
+	for id, f := range t.rules {
+		if f.Flaked > 0 {
+			result = append(result, FlakyRule{Rule: id, RuleFlakiness: *f})
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		ri, rj := result[i].FlakeRate(), result[j].FlakeRate()
+		if ri != rj {
+			return ri > rj
+		}
+		return result[i].Rule < result[j].Rule
+	})
+	return result
+}
+
+func (t *FlakyTracker) get(r *Rule) *RuleFlakiness {
+	f, found := t.rules[r.NodeID()]
+	if !found {
+		f = &RuleFlakiness{}
+		t.rules[r.NodeID()] = f
+	}
+	return f
+}
+
+// recordFailure notes a failure of the Rule with the given input fingerprint
+func (t *FlakyTracker) recordFailure(r *Rule, inputs string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	f := t.get(r)
+	f.Failed++
+	f.FailedInputs = inputs
+}
+
+// recordPass notes a pass of the Rule. A flake is recorded if the previous
+// run failed with the same inputs. Returns true if it was a flake.
+func (t *FlakyTracker) recordPass(r *Rule, inputs string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	f := t.get(r)
+	f.Passed++
+	flaked := f.FailedInputs != "" && f.FailedInputs == inputs
+	if flaked {
+		f.Flaked++
+		f.LastFlake = time.Now()
+	}
+	f.FailedInputs = ""
+	return flaked
+}
+
+// shouldRetry returns true if the retry policy allows retrying the Rule
+func (t *FlakyTracker) shouldRetry(r *Rule) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch t.policy {
+	case RetryAll:
+		return true
+	case RetryFlaky:
+		f, found := t.rules[r.NodeID()]
+		return found && f.Flaky()
+	}
+	return false
+}
+
+// pendingFailure returns true if the last recorded run of the Rule failed
+func (t *FlakyTracker) pendingFailure(r *Rule) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	f, found := t.rules[r.NodeID()]
+	return found && f.FailedInputs != ""
+}
+
+// Middleware records passes and failures of test Rules run by the wrapped
+// Runner, retrying failures once if allowed by the retry policy. Failures
+// the Rule's own RetryPolicy will retry are left to it. Rules that are not
+// tests, services, and cached results are not recorded.
+func (t *FlakyTracker) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		if !isTestRule(r) || r.IsService() {
+			return runner.Run(ctx, r, opts)
+		}
+		code, err := runner.Run(ctx, r, opts)
+		if code == Cached || code == Skipped || ctx.Err() != nil {
+			return code, err
+		}
+		failed := err != nil
+		if !failed && !t.pendingFailure(r) {
+			t.recordPass(r, "")
+			return code, err
+		}
+		// Fingerprints are only needed to compare a pass with a failure
+		inputs, fpErr := inputFingerprint(r)
+		if fpErr != nil {
+			return code, err
+		}
+		if !failed {
+			if t.recordPass(r, inputs) {
+				t.report(r, "passed after failing with the same inputs")
+			}
+			return code, err
+		}
+		t.recordFailure(r, inputs)
+		attempt := opts.Attempt
+		if attempt < 1 {
+			attempt = 1
+		}
+		if r.RetryPolicy().Retries(code, attempt) {
+			return code, err
+		}
+		if !t.shouldRetry(r) {
+			if f, _ := t.Rule(r); f.Flaky() {
+				t.report(r, fmt.Sprintf("failed, but has flaked %d times in %d runs",
+					f.Flaked, f.Runs()))
+			}
+			return code, err
+		}
+		t.report(r, "failed, retrying once")
+		code, err = runner.Run(ctx, r, opts)
+		if err == nil && t.recordPass(r, inputs) {
+			t.report(r, "passed on retry")
+		}
+		return code, err
+	})
+}
+
+func (t *FlakyTracker) report(r *Rule, msg string) {
+	fmt.Fprintln(t.output, Yellow(fmt.Sprintf("Flaky: %s %s", r.NodeID(), msg)))
+}
+
+// isTestRule returns true for Rules named "test" or beginning with "test"
+func isTestRule(r *Rule) bool {
+	return strings.HasPrefix(r.Name(), "test")
+}
+
+// inputFingerprint returns a hash of the commands, inputs, and dependency
+// outputs of a Rule. It changes whenever the Rule would run differently.
+func inputFingerprint(r *Rule) (string, error) {
+	h := sha1.New()
+	for _, cmd := range r.Commands() {
+		fmt.Fprintf(h, "command %s %s\n", cmd.Kind, cmd.Argument)
+	}
+	inputs, err := r.Inputs()
+	if err != nil {
+		return "", err
+	}
+	for _, resources := range []Resources{inputs, r.DependencyOutputs()} {
+		for _, res := range resources {
+			hash, err := res.Hash()
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "%s %s\n", res.Path(), hash)
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
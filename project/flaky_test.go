@@ -0,0 +1,130 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlakyTracker(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+	assert.Equal(t, path.Join(dir, "artifacts", ".zim", "flaky.json"), p.FlakyPath())
+
+	test, found := p.Rule("foo", "test")
+	require.True(t, found)
+	build, found := p.Rule("foo", "build")
+	require.True(t, found)
+
+	tracker, err := LoadFlakyTracker(p.FlakyPath())
+	require.Nil(t, err)
+	tracker.output = ioutil.Discard
+
+	// The stub fails the given number of times before passing
+	var attempts, failures int
+	runner := tracker.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			attempts++
+			if failures > 0 {
+				failures--
+				return ExecError, errors.New("failed")
+			}
+			return OK, nil
+		}))
+	ctx := context.Background()
+
+	// Without retries, a failure then a pass with the same inputs flakes
+	failures = 1
+	code, err := runner.Run(ctx, test, RunOpts{})
+	require.NotNil(t, err)
+	assert.Equal(t, ExecError, code)
+	code, err = runner.Run(ctx, test, RunOpts{})
+	require.Nil(t, err)
+	assert.Equal(t, OK, code)
+
+	f, found := tracker.Rule(test)
+	require.True(t, found)
+	assert.Equal(t, 1, f.Flaked)
+	assert.False(t, f.Flaky())
+
+	// Rules not yet known to be flaky aren't retried with the "flaky" policy
+	require.Nil(t, tracker.SetRetryPolicy(RetryFlaky))
+	failures = 1
+	_, err = runner.Run(ctx, test, RunOpts{})
+	require.NotNil(t, err)
+	assert.Equal(t, 3, attempts)
+
+	// Any failing test is retried once with the "all" policy
+	require.Nil(t, tracker.SetRetryPolicy(RetryAll))
+	failures = 1
+	code, err = runner.Run(ctx, test, RunOpts{})
+	require.Nil(t, err)
+	assert.Equal(t, OK, code)
+	assert.Equal(t, 5, attempts)
+
+	f, _ = tracker.Rule(test)
+	assert.Equal(t, RuleFlakiness{Passed: 2, Failed: 3, Flaked: 2, LastFlake: f.LastFlake}, f)
+	assert.True(t, f.Flaky())
+	assert.Equal(t, 0.4, f.FlakeRate())
+
+	// Only one retry is made
+	failures = 2
+	_, err = runner.Run(ctx, test, RunOpts{})
+	require.NotNil(t, err)
+	assert.Equal(t, 7, attempts)
+
+	// Beneath Retry, each attempt is tracked and the tracker leaves
+	// failures the Rule's policy retries to it
+	test.retry = RetryPolicy{Count: 1, On: []Code{ExecError}}
+	retried := Retry(runner)
+	failures = 1
+	code, err = retried.Run(ctx, test, RunOpts{Output: ioutil.Discard})
+	require.Nil(t, err)
+	assert.Equal(t, OK, code)
+	assert.Equal(t, 9, attempts)
+	f, _ = tracker.Rule(test)
+	assert.Equal(t, 3, f.Flaked)
+	test.retry = RetryPolicy{}
+
+	// Rules that aren't tests are not tracked
+	runner.Run(ctx, build, RunOpts{})
+	_, found = tracker.Rule(build)
+	assert.False(t, found)
+
+	require.Nil(t, tracker.SetRetryPolicy(RetryFlaky))
+	assert.NotNil(t, tracker.SetRetryPolicy("sometimes"))
+	require.Nil(t, tracker.Save())
+
+	loaded, err := LoadFlakyTracker(p.FlakyPath())
+	require.Nil(t, err)
+	rules := loaded.Rules()
+	require.Len(t, rules, 1)
+	assert.Equal(t, "foo.test", rules[0].Rule)
+	assert.Equal(t, 3, rules[0].Flaked)
+}
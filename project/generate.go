@@ -0,0 +1,149 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
+)
+
+// generatedDefsCache records the output of the discovery command along with
+// the key of the inputs it was produced from
+type generatedDefsCache struct {
+	Key    string `json:"key"`
+	Output string `json:"output"`
+}
+
+// generatedDefsCachePath returns the path of the file where the output of
+// the discovery command is saved
+func generatedDefsCachePath(root string) string {
+	return path.Join(root, "artifacts", ".zim", "discovery.json")
+}
+
+// generatedDefsKey returns a hash of the discovery command and the contents
+// of its inputs. An empty key is returned if the command has no inputs, in
+// which case its output is never reused.
+func generatedDefsKey(root string, pDef *definitions.Project) (string, error) {
+	if len(pDef.DiscoveryInputs) == 0 {
+		return "", nil
+	}
+	var inputs []string
+	for _, pattern := range pDef.DiscoveryInputs {
+		matches, err := MatchFiles(root, pattern)
+		if err != nil {
+			return "", err
+		}
+		inputs = append(inputs, matches...)
+	}
+	sort.Strings(inputs)
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n", pDef.DiscoveryCommand)
+	for _, input := range inputs {
+		hash, err := HashFile(input)
+		if err != nil {
+			return "", err
+		}
+		relPath, err := filepath.Rel(root, input)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %s\n", relPath, hash)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// runDiscoveryCommand runs the discovery command in the root directory and
+// returns its output
+func runDiscoveryCommand(root, command string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.ShellCommand(context.Background(), command)
+	cmd.Dir = root
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("discovery command failed: %s %s",
+			err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// generatedDefs returns the Component definitions printed by the project's
+// discovery command. Each must have a name and a path to its directory
+// relative to the project root. The output is reused from the previous run
+// if the command and its inputs are unchanged.
+func generatedDefs(root string, pDef *definitions.Project) ([]*definitions.Component, error) {
+	if pDef == nil || pDef.DiscoveryCommand == "" {
+		return nil, nil
+	}
+	key, err := generatedDefsKey(root, pDef)
+	if err != nil {
+		return nil, err
+	}
+	cachePath := generatedDefsCachePath(root)
+
+	var output []byte
+	if key != "" {
+		var cached generatedDefsCache
+		if data, err := ioutil.ReadFile(cachePath); err == nil &&
+			json.Unmarshal(data, &cached) == nil && cached.Key == key {
+			output = []byte(cached.Output)
+		}
+	}
+	if output == nil {
+		if output, err = runDiscoveryCommand(root, pDef.DiscoveryCommand); err != nil {
+			return nil, err
+		}
+	}
+
+	defs, err := definitions.LoadComponents(output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid discovery command output: %s", err)
+	}
+	for _, def := range defs {
+		if def.Name == "" {
+			return nil, fmt.Errorf("generated component name unset")
+		}
+		dir := filepath.Clean(def.Path)
+		if def.Path == "" || filepath.IsAbs(dir) || dir == ".." ||
+			strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("generated component %s path must be "+
+				"a directory within the project: %q", def.Name, def.Path)
+		}
+		def.Path = filepath.Join(root, dir, "component.yaml")
+	}
+
+	// Save the output for next time. This is only an optimization, so
+	// failures are ignored.
+	if key != "" {
+		if data, err := json.Marshal(generatedDefsCache{Key: key, Output: string(output)}); err == nil {
+			if err := os.MkdirAll(filepath.Dir(cachePath), 0755); err == nil {
+				ioutil.WriteFile(cachePath, data, 0644)
+			}
+		}
+	}
+	return defs, nil
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fugue/zim/graph"
+)
+
+// GraphNode describes one Rule in an exported dependency graph
+type GraphNode struct {
+	ID           string   `json:"id"`
+	Component    string   `json:"component"`
+	Rule         string   `json:"rule"`
+	Kind         string   `json:"kind"`
+	Duration     float64  `json:"duration"`
+	CacheHitRate float64  `json:"cache_hit_rate"`
+	OutputsExist bool     `json:"outputs_exist"`
+	Dependencies []string `json:"dependencies"`
+}
+
+// GraphNodes returns a description of the dependency graph originating from
+// the specified Rules. Durations and cache hit rates are taken from the
+// History, which may be nil. Nodes are sorted by ID.
+func GraphNodes(rules []*Rule, history *History) []*GraphNode {
+	var nodes []*GraphNode
+	GraphFromRules(rules).Visit(func(n graph.Node) bool {
+		r := n.(*Rule)
+		node := &GraphNode{
+			ID:           r.NodeID(),
+			Component:    r.Component().Name(),
+			Rule:         r.Name(),
+			Kind:         r.Component().Kind(),
+			OutputsExist: r.HasOutputs() && r.OutputsExist(),
+			Dependencies: []string{},
+		}
+		if history != nil {
+			if rh, found := history.Rule(r); found {
+				node.Duration = rh.AverageDuration().Seconds()
+				node.CacheHitRate = rh.CacheHitRate()
+			}
+		}
+		seen := map[string]bool{}
+		for _, dep := range r.Dependencies() {
+			if !seen[dep.NodeID()] {
+				node.Dependencies = append(node.Dependencies, dep.NodeID())
+				seen[dep.NodeID()] = true
+			}
+		}
+		sort.Strings(node.Dependencies)
+		nodes = append(nodes, node)
+		return true
+	})
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return nodes
+}
+
+// WriteGraphJSON writes the nodes as an adjacency list in JSON
+func WriteGraphJSON(w io.Writer, nodes []*GraphNode) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{"nodes": nodes})
+}
+
+type graphMLKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphMLData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+type graphMLNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphMLData `xml:"data"`
+}
+
+type graphMLEdge struct {
+	Source string `xml:"source,attr"`
+	Target string `xml:"target,attr"`
+}
+
+type graphMLGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphMLNode `xml:"node"`
+	Edges       []graphMLEdge `xml:"edge"`
+}
+
+type graphMLDocument struct {
+	XMLName xml.Name     `xml:"graphml"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Keys    []graphMLKey `xml:"key"`
+	Graph   graphMLGraph `xml:"graph"`
+}
+
+// WriteGraphML writes the nodes as a GraphML document. Edges are directed
+// from a Rule to each of its dependencies.
+func WriteGraphML(w io.Writer, nodes []*GraphNode) error {
+	doc := graphMLDocument{
+		XMLNS: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []graphMLKey{
+			{ID: "component", For: "node", Name: "component", Type: "string"},
+			{ID: "rule", For: "node", Name: "rule", Type: "string"},
+			{ID: "kind", For: "node", Name: "kind", Type: "string"},
+			{ID: "duration", For: "node", Name: "duration", Type: "double"},
+			{ID: "cache_hit_rate", For: "node", Name: "cache_hit_rate", Type: "double"},
+			{ID: "outputs_exist", For: "node", Name: "outputs_exist", Type: "boolean"},
+		},
+		Graph: graphMLGraph{ID: "zim", EdgeDefault: "directed"},
+	}
+	for _, n := range nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphMLNode{
+			ID: n.ID,
+			Data: []graphMLData{
+				{Key: "component", Value: n.Component},
+				{Key: "rule", Value: n.Rule},
+				{Key: "kind", Value: n.Kind},
+				{Key: "duration", Value: fmt.Sprintf("%g", n.Duration)},
+				{Key: "cache_hit_rate", Value: fmt.Sprintf("%g", n.CacheHitRate)},
+				{Key: "outputs_exist", Value: fmt.Sprintf("%t", n.OutputsExist)},
+			},
+		})
+		for _, dep := range n.Dependencies {
+			doc.Graph.Edges = append(doc.Graph.Edges, graphMLEdge{
+				Source: n.ID,
+				Target: dep,
+			})
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteGraphDOT writes the nodes as a Graphviz DOT digraph. Edges are
+// directed from a Rule to each of its dependencies and nodes are grouped
+// into a cluster per Component.
+func WriteGraphDOT(w io.Writer, nodes []*GraphNode) error {
+	var components []string
+	byComponent := map[string][]*GraphNode{}
+	for _, n := range nodes {
+		if _, found := byComponent[n.Component]; !found {
+			components = append(components, n.Component)
+		}
+		byComponent[n.Component] = append(byComponent[n.Component], n)
+	}
+	sort.Strings(components)
+
+	var b strings.Builder
+	b.WriteString("digraph zim {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+	for i, component := range components {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", strconv.Quote(component))
+		for _, n := range byComponent[component] {
+			fmt.Fprintf(&b, "    %s [label=%s];\n", strconv.Quote(n.ID), strconv.Quote(n.Rule))
+		}
+		b.WriteString("  }\n")
+	}
+	for _, n := range nodes {
+		for _, dep := range n.Dependencies {
+			fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(n.ID), strconv.Quote(dep))
+		}
+	}
+	b.WriteString("}\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
@@ -14,6 +14,7 @@
 package project
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -64,3 +65,52 @@ func TestConnectedRuleGraph(t *testing.T) {
 
 	assert.Equal(t, "foo.test", fromNodes[0].(*Rule).NodeID())
 }
+
+func TestGraphExport(t *testing.T) {
+
+	c := &Component{name: "foo", kind: "go"}
+
+	rules := []*Rule{
+		{component: c, name: "build"},
+		{component: c, name: "test"},
+	}
+	rules[0].resolvedDeps = []*Rule{rules[1]}
+
+	nodes := GraphNodes(rules[:1], nil)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, &GraphNode{
+		ID:           "foo.build",
+		Component:    "foo",
+		Rule:         "build",
+		Kind:         "go",
+		Dependencies: []string{"foo.test"},
+	}, nodes[0])
+	assert.Equal(t, "foo.test", nodes[1].ID)
+	assert.Empty(t, nodes[1].Dependencies)
+
+	var js bytes.Buffer
+	require.Nil(t, WriteGraphJSON(&js, nodes))
+	assert.Contains(t, js.String(), `"dependencies": [
+        "foo.test"
+      ]`)
+
+	var xml bytes.Buffer
+	require.Nil(t, WriteGraphML(&xml, nodes))
+	assert.Contains(t, xml.String(), `<node id="foo.build">`)
+	assert.Contains(t, xml.String(), `<data key="kind">go</data>`)
+	assert.Contains(t, xml.String(), `<edge source="foo.build" target="foo.test"></edge>`)
+
+	var dot bytes.Buffer
+	require.Nil(t, WriteGraphDOT(&dot, nodes))
+	assert.Equal(t, `digraph zim {
+  rankdir=LR;
+  node [shape=box];
+  subgraph cluster_0 {
+    label="foo";
+    "foo.build" [label="build"];
+    "foo.test" [label="test"];
+  }
+  "foo.build" -> "foo.test";
+}
+`, dot.String())
+}
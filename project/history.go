@@ -0,0 +1,140 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RuleHistory summarizes previous runs of one Rule
+type RuleHistory struct {
+	Runs     int           `json:"runs"`
+	Cached   int           `json:"cached"`
+	Executed int           `json:"executed"`
+	Duration time.Duration `json:"duration"`
+}
+
+// AverageDuration returns the mean duration of runs that executed the Rule
+func (h RuleHistory) AverageDuration() time.Duration {
+	if h.Executed == 0 {
+		return 0
+	}
+	return h.Duration / time.Duration(h.Executed)
+}
+
+// CacheHitRate returns the fraction of runs where outputs came from the cache
+func (h RuleHistory) CacheHitRate() float64 {
+	if h.Runs == 0 {
+		return 0
+	}
+	return float64(h.Cached) / float64(h.Runs)
+}
+
+// History of Rule runs persisted between invocations of Zim. It is used to
+// estimate how long rules take and how often they are found in the cache.
+type History struct {
+	mutex sync.Mutex
+	path  string
+	rules map[string]*RuleHistory
+}
+
+// LoadHistory reads History from the given file. An empty History is
+// returned if the file does not yet exist.
+func LoadHistory(path string) (*History, error) {
+	h := &History{path: path, rules: map[string]*RuleHistory{}}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return h, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &h.rules); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Save writes the History to the file it was loaded from
+func (h *History) Save() error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	data, err := json.MarshalIndent(h.rules, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(h.path), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(h.path, data, 0644)
+}
+
+// Record the result of running a Rule
+func (h *History) Record(r *Rule, code Code, duration time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	rh, found := h.rules[r.NodeID()]
+	if !found {
+		rh = &RuleHistory{}
+		h.rules[r.NodeID()] = rh
+	}
+	switch code {
+	case Cached:
+		rh.Runs++
+		rh.Cached++
+	case OK:
+		rh.Runs++
+		rh.Executed++
+		rh.Duration += duration
+	}
+}
+
+// Rule returns the History of the given Rule
+func (h *History) Rule(r *Rule) (RuleHistory, bool) {
+	return h.ruleHistory(r.NodeID())
+}
+
+// ruleHistory returns the History of the Rule with the given node ID
+func (h *History) ruleHistory(nodeID string) (RuleHistory, bool) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	rh, found := h.rules[nodeID]
+	if !found {
+		return RuleHistory{}, false
+	}
+	return *rh, true
+}
+
+// Middleware records the outcome and duration of Rules run by the wrapped
+// Runner. Only successful and cached runs are recorded. Service Rules are not
+// recorded since they run until stopped.
+func (h *History) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		if r.IsService() {
+			return runner.Run(ctx, r, opts)
+		}
+		startedAt := time.Now()
+		code, err := runner.Run(ctx, r, opts)
+		if err == nil {
+			h.Record(r, code, time.Since(startedAt))
+		}
+		return code, err
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+	assert.Equal(t, path.Join(dir, "artifacts", ".zim", "history.json"), p.HistoryPath())
+
+	build, found := p.Rule("foo", "build")
+	require.True(t, found)
+
+	h, err := LoadHistory(p.HistoryPath())
+	require.Nil(t, err)
+	_, found = h.Rule(build)
+	assert.False(t, found)
+
+	h.Record(build, OK, 2*time.Second)
+	h.Record(build, OK, 4*time.Second)
+	h.Record(build, Cached, 0)
+	h.Record(build, Cached, 0)
+	h.Record(build, ExecError, time.Second)
+	require.Nil(t, h.Save())
+
+	loaded, err := LoadHistory(p.HistoryPath())
+	require.Nil(t, err)
+	rh, found := loaded.Rule(build)
+	require.True(t, found)
+	assert.Equal(t, RuleHistory{
+		Runs:     4,
+		Cached:   2,
+		Executed: 2,
+		Duration: 6 * time.Second,
+	}, rh)
+	assert.Equal(t, 3*time.Second, rh.AverageDuration())
+	assert.Equal(t, 0.5, rh.CacheHitRate())
+}
+
+func TestHistoryMiddleware(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+	build, found := p.Rule("foo", "build")
+	require.True(t, found)
+
+	h, err := LoadHistory(p.HistoryPath())
+	require.Nil(t, err)
+
+	runner := h.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			return Cached, nil
+		}))
+	code, err := runner.Run(context.Background(), build, RunOpts{})
+	require.Nil(t, err)
+	assert.Equal(t, Cached, code)
+
+	rh, found := h.Rule(build)
+	require.True(t, found)
+	assert.Equal(t, RuleHistory{Runs: 1, Cached: 1}, rh)
+}
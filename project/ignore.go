@@ -0,0 +1,178 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	glob "github.com/bmatcuk/doublestar"
+)
+
+// IgnoreFileName is the name of the file at the project root that lists
+// paths for Zim to ignore, using gitignore syntax
+const IgnoreFileName = ".zimignore"
+
+// defaultIgnorePatterns are directories never searched for Components.
+// A .zimignore file may add to them or re-include one with "!", e.g.
+// "!build/".
+var defaultIgnorePatterns = []string{
+	".git/",
+	"node_modules/",
+	"build/",
+	"artifacts/",
+	"dist/",
+	"venv/",
+	"venvs/",
+	".mypy_cache/",
+	".cache/",
+	".npm/",
+	".stack-work/",
+	"vendor/",
+}
+
+// ignorePattern is one line of an ignore file
+type ignorePattern struct {
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// matches returns true if the pattern matches the path relative to the root
+func (p ignorePattern) matches(relPath string) bool {
+	name := relPath
+	if !p.anchored {
+		name = path.Base(relPath)
+	}
+	matched, _ := glob.Match(p.pattern, name)
+	return matched
+}
+
+// Ignore determines which paths within a project are ignored, following
+// gitignore rules: the last pattern matching a path decides whether it is
+// ignored, and everything within an ignored directory is ignored.
+type Ignore struct {
+	patterns []ignorePattern
+}
+
+// NewIgnore returns an Ignore for the given patterns written in gitignore
+// syntax. Blank lines and comments are skipped.
+func NewIgnore(lines []string) (*Ignore, error) {
+	ig := &Ignore{}
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var p ignorePattern
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// A slash anywhere but the end anchors the pattern to the root
+		if strings.Contains(line, "/") {
+			p.anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		if _, err := glob.Match(line, ""); err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern: %s", line)
+		}
+		p.pattern = line
+		ig.patterns = append(ig.patterns, p)
+	}
+	return ig, nil
+}
+
+// LoadIgnore returns the patterns in the project's .zimignore file, if
+// there is one, following the given default patterns
+func LoadIgnore(root string, defaults ...string) (*Ignore, error) {
+	lines := append([]string{}, defaults...)
+	f, err := os.Open(filepath.Join(root, IgnoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NewIgnore(lines)
+		}
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	ig, err := NewIgnore(lines)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", IgnoreFileName, err)
+	}
+	return ig, nil
+}
+
+// Ignored returns true if the path, relative to the project root, is
+// ignored. Paths within ignored directories are ignored too.
+func (ig *Ignore) Ignored(relPath string, isDir bool) bool {
+	if ig == nil || len(ig.patterns) == 0 {
+		return false
+	}
+	relPath = filepath.ToSlash(relPath)
+	parts := strings.Split(relPath, "/")
+	for i := 1; i < len(parts); i++ {
+		if ig.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return ig.match(relPath, isDir)
+}
+
+func (ig *Ignore) match(relPath string, isDir bool) bool {
+	ignored := false
+	for _, p := range ig.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.matches(relPath) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
+
+// Filter returns the files that are not ignored, given their absolute paths
+// and the absolute path of the project root
+func (ig *Ignore) Filter(root string, files []string) []string {
+	if ig == nil || len(ig.patterns) == 0 {
+		return files
+	}
+	result := make([]string, 0, len(files))
+	for _, f := range files {
+		relPath, err := filepath.Rel(root, f)
+		if err != nil || !ig.Ignored(relPath, false) {
+			result = append(result, f)
+		}
+	}
+	return result
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIgnore(t *testing.T) {
+
+	ig, err := NewIgnore([]string{
+		"# comment",
+		"",
+		"*.log",
+		"build/",
+		"/data/**/*.csv",
+		"!data/keep/*.csv",
+		"docs/generated",
+	})
+	require.Nil(t, err)
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"app.log", false, true},
+		{"src/app/debug.log", false, true},
+		{"src/app/main.go", false, false},
+		{"build", true, true},
+		{"src/build", true, true},
+		{"src/build/out.bin", false, true},
+		{"build", false, false},
+		{"data/raw/a.csv", false, true},
+		{"data/keep/b.csv", false, false},
+		{"src/data/raw/a.csv", false, false},
+		{"docs/generated/index.html", false, true},
+		{"src/docs/generated", true, false},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.ignored, ig.Ignored(tc.path, tc.isDir), tc.path)
+	}
+
+	require.Equal(t, []string{"/repo/src/main.go"},
+		ig.Filter("/repo", []string{"/repo/src/main.go", "/repo/src/out.log"}))
+
+	var none *Ignore
+	require.False(t, none.Ignored("app.log", false))
+}
+
+func TestDiscoverZimIgnore(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponentDir(dir, "hammer")
+	testComponentDir(dir, "nail")
+	testComponentDir(dir, "vendor")
+	os.MkdirAll(path.Join(dir, "vendor", "lib"), 0755)
+	ioutil.WriteFile(path.Join(dir, "vendor", "lib", "component.yaml"), []byte("name: lib"), 0644)
+
+	// By default vendor directories are skipped
+	_, defs, err := Discover(dir)
+	require.Nil(t, err)
+	require.Len(t, defs, 2)
+
+	ioutil.WriteFile(path.Join(dir, IgnoreFileName), []byte("src/nail/\n!vendor/\n"), 0644)
+	_, defs, err = Discover(dir)
+	require.Nil(t, err)
+	var names []string
+	for _, def := range defs {
+		names = append(names, def.Name)
+	}
+	require.ElementsMatch(t, []string{"hammer", "lib", "vendor"}, names)
+}
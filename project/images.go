@@ -0,0 +1,63 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fugue/zim/exec"
+)
+
+// ImageRecorder records the container images that each Rule ran in, with
+// the digest each image resolved to. Use its Middleware in a Chain to enable
+// recording.
+type ImageRecorder struct {
+	mutex  sync.Mutex
+	images map[string]*exec.Images
+}
+
+// NewImageRecorder returns an empty ImageRecorder
+func NewImageRecorder() *ImageRecorder {
+	return &ImageRecorder{images: map[string]*exec.Images{}}
+}
+
+// Middleware records the images used by Rules run by the wrapped Runner
+func (i *ImageRecorder) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		images := &exec.Images{}
+		opts.Images = images
+
+		code, err := runner.Run(ctx, r, opts)
+
+		if len(images.Uses) > 0 {
+			i.mutex.Lock()
+			defer i.mutex.Unlock()
+			i.images[r.NodeID()] = images
+		}
+		return code, err
+	})
+}
+
+// Images returns the images used by each Rule that ran a container
+// command, by node ID
+func (i *ImageRecorder) Images() map[string][]exec.ImageUse {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	result := make(map[string][]exec.ImageUse, len(i.images))
+	for nodeID, images := range i.images {
+		result[nodeID] = append([]exec.ImageUse{}, images.Uses...)
+	}
+	return result
+}
@@ -0,0 +1,61 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/fugue/zim/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImageRecorder(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+
+	test, found := p.Rule("foo", "test")
+	require.True(t, found)
+	build, found := p.Rule("foo", "build")
+	require.True(t, found)
+
+	use := exec.ImageUse{Image: "golang:1.14", Digest: "golang@sha256:abc", Pulled: true}
+	recorder := NewImageRecorder()
+	runner := recorder.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			require.NotNil(t, opts.Images)
+			if r == test {
+				opts.Images.Uses = append(opts.Images.Uses, use)
+			}
+			return OK, nil
+		}))
+
+	ctx := context.Background()
+	runner.Run(ctx, test, RunOpts{})
+	runner.Run(ctx, build, RunOpts{})
+
+	// Rules that ran no container commands aren't recorded
+	assert.Equal(t, map[string][]exec.ImageUse{
+		"foo.test": {use},
+	}, recorder.Images())
+}
@@ -0,0 +1,69 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// truncatingWriter passes through output until a limit is reached, then
+// writes a marker and discards the rest
+type truncatingWriter struct {
+	w         io.Writer
+	limit     int64
+	written   int64
+	truncated bool
+	mutex     sync.Mutex
+}
+
+// Write the data to the underlying writer if the limit allows. Discarded
+// data is reported as written so that commands are not interrupted.
+func (tw *truncatingWriter) Write(p []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+	if tw.truncated {
+		return len(p), nil
+	}
+	remaining := tw.limit - tw.written
+	if int64(len(p)) <= remaining {
+		n, err := tw.w.Write(p)
+		tw.written += int64(n)
+		return n, err
+	}
+	if _, err := tw.w.Write(p[:remaining]); err != nil {
+		return 0, err
+	}
+	tw.written = tw.limit
+	tw.truncated = true
+	marker := fmt.Sprintf("\n[output truncated after %s]\n", FormatBytes(tw.limit))
+	if _, err := io.WriteString(tw.w, Yellow(marker)); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// limitOutput returns a writer that truncates output after limit bytes.
+// Executors write to stdout when no writer is set, so that is limited too.
+func limitOutput(w io.Writer, limit int64) io.Writer {
+	if limit <= 0 {
+		return w
+	}
+	if w == nil {
+		w = os.Stdout
+	}
+	return &truncatingWriter{w: w, limit: limit}
+}
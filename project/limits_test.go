@@ -0,0 +1,77 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitOutput(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.Equal(t, &buf, limitOutput(&buf, 0))
+
+	w := limitOutput(&buf, 10)
+	n, err := fmt.Fprint(w, "0123456")
+	require.Nil(t, err)
+	require.Equal(t, 7, n)
+	n, err = fmt.Fprint(w, "789abcdef")
+	require.Nil(t, err)
+	require.Equal(t, 9, n)
+	n, err = fmt.Fprint(w, "more")
+	require.Nil(t, err)
+	require.Equal(t, 4, n)
+
+	// Only the marker follows the first ten bytes
+	require.Equal(t, "0123456789", buf.String()[:10])
+	require.Contains(t, buf.String()[10:], "[output truncated after")
+	require.NotContains(t, buf.String(), "more")
+}
+
+func TestRuleSizeLimits(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "app", `
+name: app
+rules:
+  build:
+    max_log_size: 10MB
+    max_output_size: 1.5G
+    command: make
+`, nil)
+	p, err := New(dir)
+	require.Nil(t, err)
+	rule, found := p.Rule("app", "build")
+	require.True(t, found)
+	require.Equal(t, int64(10<<20), rule.MaxLogSize())
+	require.Equal(t, int64(1.5*(1<<30)), rule.MaxOutputSize())
+
+	testComponent(dir, "app", `
+name: app
+rules:
+  build:
+    max_log_size: lots
+    command: make
+`, nil)
+	_, err = New(dir)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "invalid max_log_size: lots")
+}
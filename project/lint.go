@@ -0,0 +1,135 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Problem describes a questionable aspect of a Rule definition
+type Problem struct {
+	Rule    *Rule
+	Message string
+}
+
+// LintOpts contains options used to configure Rule linting
+type LintOpts struct {
+
+	// Deep enables checks that inspect the filesystem, which are slower but
+	// able to find unused inputs and stale outputs
+	Deep bool
+}
+
+// Lint checks the Rule for common configuration mistakes
+func (r *Rule) Lint(opts LintOpts) ([]*Problem, error) {
+
+	var problems []*Problem
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, &Problem{
+			Rule:    r,
+			Message: fmt.Sprintf(format, args...),
+		})
+	}
+
+	if r.HasOutputs() && len(r.inputs) == 0 && len(r.resolvedImports) == 0 {
+		add("outputs declared without any inputs")
+	}
+	if len(r.commands) == 1 && r.commands[0].Kind == "run" &&
+		strings.TrimSpace(r.commands[0].Argument) == "" {
+		add("no commands defined")
+	}
+	if !opts.Deep {
+		return problems, nil
+	}
+
+	// Input patterns that match zero files. Negated patterns are checked
+	// along with the ignore patterns below.
+	patterns, negated := splitInputPatterns(r.inputs)
+	ignorePatterns := append(negated, r.ignore...)
+	matched := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := matchResources(r.Component(), r.inProvider, []string{pattern})
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			add("input matches no files: %s", pattern)
+		}
+		for _, m := range matches {
+			matched[m.Path()] = true
+		}
+	}
+
+	// Ignore patterns that exclude every matched input
+	if len(ignorePatterns) > 0 && len(matched) > 0 {
+		ignored, err := matchResources(r.Component(), r.inProvider, ignorePatterns)
+		if err != nil {
+			return nil, err
+		}
+		remaining := len(matched)
+		for _, res := range ignored {
+			if matched[res.Path()] {
+				remaining--
+				matched[res.Path()] = false
+			}
+		}
+		if remaining == 0 {
+			add("ignore patterns exclude all inputs: %s",
+				strings.Join(ignorePatterns, ", "))
+		}
+	}
+
+	// Outputs that are not present, i.e. no recent run has created them
+	for _, out := range r.MissingOutputs() {
+		add("output not created by a recent run: %s", out.Name())
+	}
+
+	// Dependencies whose outputs are never referenced by commands
+	script := r.commandText()
+	if !strings.Contains(script, "DEP") {
+		for _, dep := range r.Dependencies() {
+			outputs := dep.Outputs()
+			if len(outputs) == 0 {
+				continue
+			}
+			referenced := false
+			for _, out := range outputs {
+				if strings.Contains(script, out.Name()) {
+					referenced = true
+					break
+				}
+			}
+			if !referenced {
+				add("outputs of dependency %s are never referenced", dep.NodeID())
+			}
+		}
+	}
+	return problems, nil
+}
+
+// commandText returns the text of all commands, used to look for references
+// to files and variables
+func (r *Rule) commandText() string {
+	var parts []string
+	for _, cmd := range r.commands {
+		parts = append(parts, cmd.Argument)
+		for _, value := range cmd.Attributes {
+			if s, ok := value.(string); ok {
+				parts = append(parts, s)
+			}
+		}
+	}
+	return strings.Join(parts, "\n")
+}
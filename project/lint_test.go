@@ -0,0 +1,87 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func problemMessages(problems []*Problem) (result []string) {
+	for _, p := range problems {
+		result = append(result, p.Message)
+	}
+	return
+}
+
+func TestLintDeep(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", `
+name: foo
+rules:
+  gen:
+    inputs:
+     - main.go
+    outputs:
+     - gen.txt
+    command: touch ${OUTPUT}
+  build:
+    requires:
+     - rule: gen
+    inputs:
+     - main.go
+     - "*.missing"
+    ignore:
+     - "*.go"
+    outputs:
+     - foo
+    command: go build
+`, map[string]string{
+		"main.go": testGoMain,
+	})
+
+	p, err := New(dir)
+	require.Nil(t, err)
+
+	build, found := p.Rule("foo", "build")
+	require.True(t, found)
+
+	// Shallow linting doesn't look at the filesystem
+	problems, err := build.Lint(LintOpts{})
+	require.Nil(t, err)
+	assert.Empty(t, problems)
+
+	problems, err = build.Lint(LintOpts{Deep: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"input matches no files: *.missing",
+		"ignore patterns exclude all inputs: *.go",
+		"output not created by a recent run: foo",
+		"outputs of dependency foo.gen are never referenced",
+	}, problemMessages(problems))
+
+	gen, found := p.Rule("foo", "gen")
+	require.True(t, found)
+	problems, err = gen.Lint(LintOpts{Deep: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		"output not created by a recent run: gen.txt",
+	}, problemMessages(problems))
+}
@@ -0,0 +1,110 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileAction is an action an editor may offer for a file
+type FileAction struct {
+	Title   string   `json:"title"`
+	Rule    string   `json:"rule"`
+	Command []string `json:"command"`
+}
+
+// FileInfo describes how a file relates to the Project: the Component that
+// owns it and the Rules that use it as an input
+type FileInfo struct {
+	File      string       `json:"file"`
+	Component string       `json:"component,omitempty"`
+	Rules     []string     `json:"rules"`
+	Actions   []FileAction `json:"actions"`
+}
+
+// Locate returns information about the file at the given path, which may be
+// relative to the Project root. The owning Component is the one with the
+// deepest directory containing the file. Actions run each Rule that uses the
+// file as an input, beginning with those of the owning Component.
+func (p *Project) Locate(path string) (*FileInfo, error) {
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(p.RootAbsPath(), path)
+	}
+	path = filepath.Clean(path)
+	relPath, err := filepath.Rel(p.RootAbsPath(), path)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return nil, fmt.Errorf("file is not within the project: %s", path)
+	}
+	info := &FileInfo{File: relPath, Rules: []string{}, Actions: []FileAction{}}
+
+	var owner *Component
+	for _, c := range p.Components() {
+		dir := c.Directory()
+		if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			continue
+		}
+		if owner == nil || len(dir) > len(owner.Directory()) {
+			owner = c
+		}
+	}
+	if owner != nil {
+		info.Component = owner.Name()
+	}
+
+	var rules []*Rule
+	for _, c := range p.Components() {
+		for _, r := range c.Rules() {
+			inputs, err := r.Inputs()
+			if err != nil {
+				return nil, err
+			}
+			for _, inputPath := range inputs.Paths() {
+				if inputPath == path {
+					rules = append(rules, r)
+					break
+				}
+			}
+		}
+	}
+	// Rules of the owning Component come first, with test rules first
+	rank := func(r *Rule) int {
+		rank := 0
+		if r.Component() != owner {
+			rank += 2
+		}
+		if r.Name() != "test" {
+			rank++
+		}
+		return rank
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rank(rules[i]) != rank(rules[j]) {
+			return rank(rules[i]) < rank(rules[j])
+		}
+		return rules[i].NodeID() < rules[j].NodeID()
+	})
+	for _, r := range rules {
+		info.Rules = append(info.Rules, r.NodeID())
+		info.Actions = append(info.Actions, FileAction{
+			Title:   fmt.Sprintf("Run %s", r.NodeID()),
+			Rule:    r.NodeID(),
+			Command: []string{"zim", "run", r.Name(), "-c", r.Component().Name()},
+		})
+	}
+	return info, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fugue/zim/definitions"
+)
+
+// expandMatrix returns every combination of the values of the matrix
+// variables. A matrix with no variables has no combinations.
+func expandMatrix(matrix map[string][]string) ([]map[string]string, error) {
+	if len(matrix) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(matrix))
+	for name, values := range matrix {
+		if len(values) == 0 {
+			return nil, fmt.Errorf("matrix variable %s has no values", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	combinations := []map[string]string{{}}
+	for _, name := range names {
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range matrix[name] {
+				expanded := copyEnvironment(combination)
+				expanded[name] = value
+				next = append(next, expanded)
+			}
+		}
+		combinations = next
+	}
+	return combinations, nil
+}
+
+// matrixRuleName returns the name of the instance of a matrix Rule with the
+// given variables, e.g. "build[linux,3.9]". Values are ordered by the names
+// of their variables.
+func matrixRuleName(name string, variables map[string]string) string {
+	keys := make([]string, 0, len(variables))
+	for k := range variables {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	values := make([]string, 0, len(variables))
+	for _, k := range keys {
+		values = append(values, variables[k])
+	}
+	return fmt.Sprintf("%s[%s]", name, strings.Join(values, ","))
+}
+
+// newMatrixRules returns one instance of the Rule for each combination of
+// its matrix variables
+func newMatrixRules(name string, c *Component, self *definitions.Rule) ([]*Rule, error) {
+	combinations, err := expandMatrix(self.Matrix)
+	if err != nil {
+		return nil, fmt.Errorf("Rule %s.%s %s", c.Name(), name, err)
+	}
+	rules := make([]*Rule, 0, len(combinations))
+	for _, variables := range combinations {
+		r, err := newRule(name, variables, c, self)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Matrix returns the matrix variables of this instance of a matrix Rule, or
+// nil if the Rule has no matrix
+func (r *Rule) Matrix() map[string]string {
+	if r.matrix == nil {
+		return nil
+	}
+	return copyEnvironment(r.matrix)
+}
+
+// BaseName returns the name of the Rule without its matrix values, e.g.
+// "build" for "build[linux]"
+func (r *Rule) BaseName() string {
+	if r.baseName != "" {
+		return r.baseName
+	}
+	return r.name
+}
+
+// matchesMatrix returns true if the Rule shares the values of every matrix
+// variable that it has in common with the other Rule
+func (r *Rule) matchesMatrix(other *Rule) bool {
+	for k, v := range r.matrix {
+		if otherValue, found := other.matrix[k]; found && otherValue != v {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandMatrix(t *testing.T) {
+
+	combinations, err := expandMatrix(map[string][]string{
+		"GOOS":   {"linux", "darwin"},
+		"GOARCH": {"amd64", "arm64"},
+	})
+	require.Nil(t, err)
+	require.Len(t, combinations, 4)
+	require.Equal(t, map[string]string{"GOARCH": "amd64", "GOOS": "linux"}, combinations[0])
+	require.Equal(t, "build[amd64,linux]", matrixRuleName("build", combinations[0]))
+
+	_, err = expandMatrix(map[string][]string{"GOOS": {}})
+	require.Equal(t, "matrix variable GOOS has no values", err.Error())
+}
+
+func TestMatrixRules(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Matrix:  map[string][]string{"GOOS": {"linux", "darwin"}},
+					Outputs: []string{"app-${GOOS}"},
+					Command: "go build -o ${ARTIFACT}",
+				},
+				"test": {
+					Matrix:   map[string][]string{"GOOS": {"linux", "darwin"}},
+					Requires: []definitions.Dependency{{Rule: "build"}},
+					Command:  "./test.sh",
+				},
+				"package": {
+					Requires: []definitions.Dependency{{Rule: "build"}},
+					Command:  "tar czf ${ARTIFACT} ${DEPS}",
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	c := p.Components().First()
+
+	builds := c.MatrixRules("build")
+	require.Len(t, builds, 2)
+	require.Equal(t, "app.build[darwin]", builds[0].NodeID())
+	require.Equal(t, "app.build[linux]", builds[1].NodeID())
+	require.Equal(t, "build", builds[1].BaseName())
+	require.Equal(t, map[string]string{"GOOS": "linux"}, builds[1].Matrix())
+	require.Equal(t, path.Join(dir, "artifacts", "app-linux"), builds[1].Outputs()[0].Path())
+
+	env, err := builds[1].Environment()
+	require.Nil(t, err)
+	require.Equal(t, "linux", env["GOOS"])
+
+	// Selecting the matrix rule by name selects all its instances
+	require.True(t, c.HasRule("build"))
+	require.Len(t, p.Components().Rules([]string{"build"}), 2)
+	require.Len(t, p.Components().Rules([]string{"build[linux]"}), 1)
+
+	// Instances depend on the instances of other matrix rules with the same
+	// values, while other rules depend on every instance
+	test, found := c.Rule("test[linux]")
+	require.True(t, found)
+	require.Equal(t, []*Rule{builds[1]}, test.Dependencies())
+
+	pkg := c.MustRule("package")
+	require.ElementsMatch(t, builds, pkg.Dependencies())
+}
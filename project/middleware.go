@@ -17,8 +17,11 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -41,6 +44,58 @@ func BufferedOutput(runner Runner) Runner {
 	})
 }
 
+// OrderedOutput holds the output of each Rule until Flush is called, when
+// it is printed in order of Rule ID. The output then doesn't depend on the
+// order in which Rules running concurrently finish.
+type OrderedOutput struct {
+	mutex   sync.Mutex
+	w       io.Writer
+	outputs map[string]*bytes.Buffer
+}
+
+// NewOrderedOutput returns an OrderedOutput that prints to the Writer
+func NewOrderedOutput(w io.Writer) *OrderedOutput {
+	return &OrderedOutput{w: w, outputs: map[string]*bytes.Buffer{}}
+}
+
+// Middleware captures the output of Rules run by the wrapped Runner
+func (o *OrderedOutput) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		buffer := &bytes.Buffer{}
+		opts.Output = buffer
+		opts.DebugOutput = buffer
+
+		code, err := runner.Run(ctx, r, opts)
+
+		o.mutex.Lock()
+		defer o.mutex.Unlock()
+		if previous, found := o.outputs[r.NodeID()]; found {
+			previous.Write(buffer.Bytes())
+		} else {
+			o.outputs[r.NodeID()] = buffer
+		}
+		return code, err
+	})
+}
+
+// Flush prints the output captured so far, sorted by Rule ID
+func (o *OrderedOutput) Flush() {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	ids := make([]string, 0, len(o.outputs))
+	for id := range o.outputs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		output := strings.TrimSpace(o.outputs[id].String())
+		if len(output) > 0 {
+			fmt.Fprintln(o.w, output)
+		}
+	}
+	o.outputs = map[string]*bytes.Buffer{}
+}
+
 // Logger is middleware that wraps logging around Rule execution
 func Logger(runner Runner) Runner {
 	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
@@ -62,23 +117,28 @@ func Logger(runner Runner) Runner {
 			return code, err
 		}
 
-		duration := time.Since(startedAt)
-		durationStr := fmt.Sprintf("in %.3f sec", duration.Seconds())
+		// Durations are left out of plain output since they vary
+		line := []interface{}{"rule:", Bright(r.NodeID())}
+		if !PlainOutput() {
+			duration := time.Since(startedAt)
+			line = append(line, Bright("in "+FormatDuration(duration)))
+		}
 
 		if err != nil {
 			isKilled := strings.Contains(err.Error(), "signal: killed")
 			isCanceled := strings.Contains(err.Error(), "context canceled")
 
-			if isKilled || isCanceled {
-				fmt.Fprintln(opts.Output, "rule:", Bright(r.NodeID()),
-					Bright(durationStr), Red("[KILLED]"))
+			if IsAllowedFailure(err) {
+				fmt.Fprintln(opts.Output, append(line, Yellow("[FAILED, ALLOWED]"))...)
+			} else if code == Timeout {
+				fmt.Fprintln(opts.Output, append(line, Red("[TIMEOUT]"))...)
+			} else if isKilled || isCanceled {
+				fmt.Fprintln(opts.Output, append(line, Red("[KILLED]"))...)
 			} else {
-				fmt.Fprintln(opts.Output, "rule:", Bright(r.NodeID()),
-					Bright(durationStr), Red("[FAILED]"))
+				fmt.Fprintln(opts.Output, append(line, Red("[FAILED]"))...)
 			}
 		} else {
-			fmt.Fprintln(opts.Output, "rule:", Bright(r.NodeID()),
-				Bright(durationStr), Green("[OK]"))
+			fmt.Fprintln(opts.Output, append(line, Green("[OK]"))...)
 		}
 		return code, err
 	})
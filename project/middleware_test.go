@@ -12,3 +12,49 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 package project
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrderedOutput(t *testing.T) {
+
+	var out bytes.Buffer
+	ordered := NewOrderedOutput(&out)
+	runner := NewChain(ordered.Middleware).Then(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			fmt.Fprintf(opts.Output, "built %s\n", r.NodeID())
+			return OK, nil
+		}))
+
+	c := &Component{name: "app"}
+	for _, name := range []string{"test", "build", "lint"} {
+		_, err := runner.Run(context.Background(), &Rule{component: c, name: name}, RunOpts{})
+		require.Nil(t, err)
+	}
+	require.Empty(t, out.String())
+
+	ordered.Flush()
+	require.Equal(t, "built app.build\nbuilt app.lint\nbuilt app.test\n", out.String())
+}
+
+func TestLoggerPlainOutput(t *testing.T) {
+
+	SetPlainOutput(true)
+	defer SetPlainOutput(false)
+
+	var out bytes.Buffer
+	runner := NewChain(Logger).Then(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			return OK, nil
+		}))
+	r := &Rule{component: &Component{name: "app"}, name: "build"}
+	_, err := runner.Run(context.Background(), r, RunOpts{Output: &out})
+	require.Nil(t, err)
+	require.Equal(t, "rule: app.build\nrule: app.build [OK]\n", out.String())
+}
@@ -0,0 +1,166 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultMutexInterval is how often a held lock is checked again while a
+// Rule waits for it
+const DefaultMutexInterval = 5 * time.Second
+
+// Lock is a lock acquired from a Locker, held until it is released
+type Lock interface {
+
+	// Release the lock so that other owners may acquire it
+	Release() error
+}
+
+// Locker acquires named locks shared with builds on other machines, such as
+// CI jobs that deploy the same component
+type Locker interface {
+
+	// TryAcquire acquires the named lock for the owner without waiting.
+	// If another owner holds the lock, a nil Lock is returned along with
+	// that owner. If the lock was acquired by taking it over from an
+	// owner whose lock expired, that owner is returned with the Lock.
+	TryAcquire(ctx context.Context, name, owner string) (Lock, string, error)
+}
+
+// RuleMutexes is middleware that runs Rules sharing a mutex one at a time.
+// Rules in the same build wait for each other locally. If a Locker is set,
+// its lock is acquired as well, so that builds elsewhere wait too. Rules
+// restored from the cache are never locked when this is placed beneath the
+// cache middleware.
+type RuleMutexes struct {
+	locker   Locker
+	interval time.Duration
+	mutex    sync.Mutex
+	local    map[string]chan struct{}
+}
+
+// NewRuleMutexes returns middleware that serializes Rules sharing a mutex.
+// The locker may be nil to only serialize Rules within this build.
+func NewRuleMutexes(locker Locker) *RuleMutexes {
+	return &RuleMutexes{
+		locker:   locker,
+		interval: DefaultMutexInterval,
+		local:    map[string]chan struct{}{},
+	}
+}
+
+// Middleware acquires the Rule's mutex, if it has one, while it runs
+func (m *RuleMutexes) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		name := r.Mutex()
+		if name == "" {
+			return runner.Run(ctx, r, opts)
+		}
+		output := opts.Output
+		if output == nil {
+			output = os.Stdout
+		}
+		logf := func(format string, args ...interface{}) {
+			fmt.Fprintln(output, "rule:", Bright(r.NodeID()),
+				Yellow(fmt.Sprintf(format, args...)))
+		}
+
+		// Rules in this build wait for each other before the shared lock
+		// is requested, so that it is only requested once at a time
+		local := m.localMutex(name)
+		select {
+		case local <- struct{}{}:
+		default:
+			logf("waiting for mutex %s held within this build", name)
+			select {
+			case local <- struct{}{}:
+			case <-ctx.Done():
+				return Error, ctx.Err()
+			}
+		}
+		defer func() { <-local }()
+
+		if m.locker == nil {
+			return runner.Run(ctx, r, opts)
+		}
+		lock, err := m.acquire(ctx, name, mutexOwner(r, opts.BuildID), logf)
+		if err != nil {
+			return Error, fmt.Errorf("Rule %s failed to acquire mutex %s: %s",
+				r.NodeID(), name, err)
+		}
+		code, err := runner.Run(ctx, r, opts)
+		if releaseErr := lock.Release(); releaseErr != nil {
+			logf("failed to release mutex %s: %s", name, releaseErr)
+		}
+		return code, err
+	})
+}
+
+// localMutex returns the channel used to serialize Rules in this build that
+// share the named mutex. The mutex is held while the channel is full.
+func (m *RuleMutexes) localMutex(name string) chan struct{} {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	local, found := m.local[name]
+	if !found {
+		local = make(chan struct{}, 1)
+		m.local[name] = local
+	}
+	return local
+}
+
+// acquire waits until the Locker's lock is acquired for the owner. Waiting
+// for another owner, and taking over an expired lock, are logged.
+func (m *RuleMutexes) acquire(ctx context.Context, name, owner string, logf func(string, ...interface{})) (Lock, error) {
+	var waitingFor string
+	for {
+		lock, holder, err := m.locker.TryAcquire(ctx, name, owner)
+		if err != nil {
+			return nil, err
+		}
+		if lock != nil {
+			if holder != "" {
+				logf("took over expired mutex %s from %s", name, holder)
+			}
+			return lock, nil
+		}
+		if holder != waitingFor {
+			logf("waiting for mutex %s held by %s", name, holder)
+			waitingFor = holder
+		}
+		select {
+		case <-time.After(m.interval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// mutexOwner identifies the machine, build, and Rule holding a lock, so
+// that those waiting for it can tell who they are waiting for
+func mutexOwner(r *Rule, buildID string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	if buildID == "" {
+		buildID = UUID()
+	}
+	return fmt.Sprintf("%s/%s/%s", host, buildID, r.NodeID())
+}
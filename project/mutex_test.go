@@ -0,0 +1,139 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLocker holds locks in memory. A lock held by another owner is
+// released after it has been requested the given number of times.
+type fakeLocker struct {
+	mutex    sync.Mutex
+	holders  map[string]string
+	requests int
+	release  int
+}
+
+type fakeLock struct {
+	locker *fakeLocker
+	name   string
+}
+
+func (l *fakeLock) Release() error {
+	l.locker.mutex.Lock()
+	defer l.locker.mutex.Unlock()
+	delete(l.locker.holders, l.name)
+	return nil
+}
+
+func (f *fakeLocker) TryAcquire(ctx context.Context, name, owner string) (Lock, string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.requests++
+	if holder, found := f.holders[name]; found {
+		if f.requests <= f.release {
+			return nil, holder, nil
+		}
+	}
+	f.holders[name] = owner
+	return &fakeLock{locker: f, name: name}, "", nil
+}
+
+func TestRuleMutexesLocal(t *testing.T) {
+
+	c := &Component{name: "app"}
+	deployA := &Rule{component: c, name: "deploy-a", mutex: "prod"}
+	deployB := &Rule{component: c, name: "deploy-b", mutex: "prod"}
+
+	started := make(chan bool)
+	proceed := make(chan bool)
+	var ran []string
+	mutexes := NewRuleMutexes(nil)
+	runner := mutexes.Middleware(RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		if r == deployA {
+			close(started)
+			<-proceed
+		}
+		ran = append(ran, r.Name())
+		return OK, nil
+	}))
+
+	done := make(chan bool)
+	go func() {
+		runner.Run(context.Background(), deployA, RunOpts{Output: ioutil.Discard})
+		close(done)
+	}()
+	<-started
+
+	// The second Rule waits until the first finishes
+	reader, writer := io.Pipe()
+	go func() {
+		code, err := runner.Run(context.Background(), deployB, RunOpts{Output: writer})
+		require.Nil(t, err)
+		require.Equal(t, OK, code)
+		writer.Close()
+	}()
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	require.Nil(t, err)
+	require.Contains(t, line, "waiting for mutex prod held within this build")
+	require.Empty(t, ran)
+	close(proceed)
+	<-done
+	ioutil.ReadAll(reader)
+	require.Equal(t, []string{"deploy-a", "deploy-b"}, ran)
+}
+
+func TestRuleMutexesLocker(t *testing.T) {
+
+	c := &Component{name: "app"}
+	r := &Rule{component: c, name: "deploy", mutex: "prod"}
+
+	locker := &fakeLocker{holders: map[string]string{"prod": "ci-1/abc/app.deploy"}, release: 2}
+	mutexes := NewRuleMutexes(locker)
+	mutexes.interval = time.Millisecond
+
+	var output bytes.Buffer
+	var held string
+	runner := mutexes.Middleware(RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		held = locker.holders["prod"]
+		return OK, nil
+	}))
+	code, err := runner.Run(context.Background(), r, RunOpts{Output: &output, BuildID: "xyz"})
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+	require.Equal(t, 3, locker.requests)
+	require.Contains(t, held, "/xyz/app.deploy")
+	require.Empty(t, locker.holders)
+
+	// The wait for each holder is logged once
+	require.Equal(t, 1, bytes.Count(output.Bytes(),
+		[]byte("waiting for mutex prod held by ci-1/abc/app.deploy")))
+
+	// Rules without a mutex aren't locked
+	locker.requests = 0
+	code, err = runner.Run(context.Background(), &Rule{component: c, name: "build"}, RunOpts{})
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+	require.Equal(t, 0, locker.requests)
+}
@@ -0,0 +1,104 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/fugue/zim/definitions"
+)
+
+// NixEnvironment is a Nix development environment in which the native
+// commands of a Component are run
+type NixEnvironment struct {
+
+	// File is the absolute path to a flake.nix or shell.nix file
+	File string
+
+	// Lock is the absolute path to the lock file, if there is one
+	Lock string
+
+	// Offline uses only what is already in the Nix store
+	Offline bool
+}
+
+// newNixEnvironment returns the Nix environment for a Component definition,
+// or nil if the Component doesn't specify one. Paths are relative to the
+// Component directory. If no lock is specified, a flake.lock alongside the
+// Nix file is used if it exists.
+func newNixEnvironment(componentDir string, def definitions.Nix) *NixEnvironment {
+	if def.File == "" {
+		return nil
+	}
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(componentDir, path)
+	}
+	env := &NixEnvironment{File: resolve(def.File)}
+	if def.Lock != "" {
+		env.Lock = resolve(def.Lock)
+	} else if lock := filepath.Join(filepath.Dir(env.File), "flake.lock"); fileExists(lock) {
+		env.Lock = lock
+	}
+	return env
+}
+
+// IsFlake returns true if the environment is defined by a flake
+func (n *NixEnvironment) IsFlake() bool {
+	return filepath.Base(n.File) == "flake.nix"
+}
+
+// Wrapper returns the command that runs its trailing arguments within the
+// Nix environment
+func (n *NixEnvironment) Wrapper() []string {
+	args := []string{"nix", "develop"}
+	if n.Offline {
+		args = append(args, "--offline")
+	}
+	if n.IsFlake() {
+		return append(args, filepath.Dir(n.File), "--command")
+	}
+	return append(args, "--file", n.File, "--command")
+}
+
+// FetchCommand returns a command that downloads everything needed by the
+// Nix environment
+func (n *NixEnvironment) FetchCommand() []string {
+	online := *n
+	online.Offline = false
+	return append(online.Wrapper(), "true")
+}
+
+// Hash returns a hash of the Nix file and lock file, which changes whenever
+// the pinned environment changes
+func (n *NixEnvironment) Hash() (string, error) {
+	h := sha256.New()
+	for _, path := range []string{n.File, n.Lock} {
+		if path == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read nix file: %s", err)
+		}
+		fmt.Fprintf(h, "%s:%d:", filepath.Base(path), len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"sync"
+)
+
+// Actions that a Plan records for each Rule
+const (
+	// PlanRun indicates the Rule would execute its commands
+	PlanRun = "run"
+
+	// PlanCached indicates the Rule outputs would be restored from the cache
+	PlanCached = "cached"
+
+	// PlanSkip indicates the Rule would be skipped due to a condition
+	PlanSkip = "skip"
+)
+
+// PlanItem is the action that would be taken for one Rule
+type PlanItem struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+}
+
+// Plan records what would happen when running Rules without running any
+// of their commands. Use its Middleware at the top of a Chain to enable
+// dry-run mode in the middleware and Runner beneath it.
+type Plan struct {
+	mutex sync.Mutex
+	items []PlanItem
+}
+
+// NewPlan returns an empty Plan
+func NewPlan() *Plan {
+	return &Plan{}
+}
+
+// Middleware sets the dry-run option and records the resulting action for
+// each Rule run by the wrapped Runner
+func (p *Plan) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		opts.DryRun = true
+		code, err := runner.Run(ctx, r, opts)
+		if err != nil {
+			return code, err
+		}
+		action := PlanRun
+		switch code {
+		case Cached:
+			action = PlanCached
+		case Skipped:
+			action = PlanSkip
+		}
+		p.mutex.Lock()
+		p.items = append(p.items, PlanItem{Rule: r.NodeID(), Action: action})
+		p.mutex.Unlock()
+		return code, err
+	})
+}
+
+// Items returns the recorded actions in the order the Rules were planned
+func (p *Plan) Items() []PlanItem {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	items := make([]PlanItem, len(p.items))
+	copy(items, p.items)
+	return items
+}
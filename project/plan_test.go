@@ -0,0 +1,80 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/fugue/zim/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+
+	c := &Component{name: "app"}
+	codes := map[string]Code{
+		"build":  OK,
+		"test":   Cached,
+		"deploy": Skipped,
+	}
+
+	plan := NewPlan()
+	runner := plan.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			assert.True(t, opts.DryRun)
+			if r.Name() == "broken" {
+				return Error, errors.New("failed to compute key")
+			}
+			return codes[r.Name()], nil
+		}))
+
+	ctx := context.Background()
+	for _, name := range []string{"build", "test", "deploy", "broken"} {
+		runner.Run(ctx, &Rule{component: c, name: name}, RunOpts{})
+	}
+
+	assert.Equal(t, []PlanItem{
+		{Rule: "app.build", Action: PlanRun},
+		{Rule: "app.test", Action: PlanCached},
+		{Rule: "app.deploy", Action: PlanSkip},
+	}, plan.Items())
+}
+
+func TestStandardRunnerDryRun(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+	build, found := p.Rule("foo", "build")
+	require.True(t, found)
+
+	// Nothing is executed, so the missing output is not an error
+	runner := &StandardRunner{}
+	code, err := runner.Run(context.Background(), build, RunOpts{
+		Executor: exec.NewBashExecutor(),
+		DryRun:   true,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, OK, code)
+	assert.False(t, build.OutputsExist())
+}
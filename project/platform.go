@@ -0,0 +1,61 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"path"
+	"strings"
+)
+
+// PlatformVariable is the matrix variable that selects the target platform
+// of each instance of a matrix Rule, e.g. "linux/arm64"
+const PlatformVariable = "PLATFORM"
+
+// Platform returns the target platform of the Rule, e.g. "linux/amd64". A
+// PLATFORM matrix variable takes precedence over the Project platform. An
+// empty string is returned if no platform is targeted.
+func (r *Rule) Platform() string {
+	if platform := r.matrix[PlatformVariable]; platform != "" {
+		return platform
+	}
+	if p := r.Project(); p != nil {
+		return p.Platform()
+	}
+	return ""
+}
+
+// PlatformDir returns the name of the directory holding artifacts built for
+// the platform, e.g. "linux_amd64" for "linux/amd64"
+func PlatformDir(platform string) string {
+	return strings.Replace(path.Clean(platform), "/", "_", -1)
+}
+
+// platformEnvironment returns the variables describing a target platform:
+// PLATFORM, PLATFORM_OS, PLATFORM_ARCH, and PLATFORM_VARIANT if there is one.
+// Nothing is returned if there is no platform.
+func platformEnvironment(platform string) map[string]string {
+	if platform == "" {
+		return nil
+	}
+	env := map[string]string{PlatformVariable: platform}
+	parts := strings.SplitN(platform, "/", 3)
+	env["PLATFORM_OS"] = parts[0]
+	if len(parts) > 1 {
+		env["PLATFORM_ARCH"] = parts[1]
+	}
+	if len(parts) > 2 {
+		env["PLATFORM_VARIANT"] = parts[2]
+	}
+	return env
+}
@@ -0,0 +1,87 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlatformDir(t *testing.T) {
+	require.Equal(t, "linux_amd64", PlatformDir("linux/amd64"))
+	require.Equal(t, "linux_arm64_v8", PlatformDir("linux/arm64/v8"))
+}
+
+func TestPlatformEnvironment(t *testing.T) {
+	require.Nil(t, platformEnvironment(""))
+	require.Equal(t, map[string]string{
+		"PLATFORM":         "linux/arm/v7",
+		"PLATFORM_OS":      "linux",
+		"PLATFORM_ARCH":    "arm",
+		"PLATFORM_VARIANT": "v7",
+	}, platformEnvironment("linux/arm/v7"))
+}
+
+func TestRulePlatform(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Outputs: []string{"app"},
+				},
+				"release": {
+					Matrix:  map[string][]string{"PLATFORM": {"linux/amd64", "linux/arm64"}},
+					Outputs: []string{"app.tar.gz"},
+				},
+			},
+		},
+	}
+
+	// Without a platform, artifacts are not namespaced
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	build := p.Components().First().MustRule("build")
+	require.Equal(t, "", build.Platform())
+	require.Equal(t, path.Join(dir, "artifacts", "app"), build.Outputs()[0].Path())
+	_, found := build.BaseEnvironment()["PLATFORM"]
+	require.False(t, found)
+
+	// The project platform applies to every rule
+	p, err = NewWithOptions(Opts{Root: dir, ComponentDefs: defs, Platform: "linux/amd64"})
+	require.Nil(t, err)
+	build = p.Components().First().MustRule("build")
+	require.Equal(t, "linux/amd64", build.Platform())
+	require.Equal(t, path.Join(dir, "artifacts", "linux_amd64"), build.ArtifactsDir())
+	require.Equal(t, path.Join(dir, "artifacts", "linux_amd64", "app"), build.Outputs()[0].Path())
+	env := build.BaseEnvironment()
+	require.Equal(t, "linux/amd64", env["PLATFORM"])
+	require.Equal(t, "amd64", env["PLATFORM_ARCH"])
+
+	// A PLATFORM matrix variable takes precedence
+	arm, found := p.Components().First().Rule("release[linux/arm64]")
+	require.True(t, found)
+	require.Equal(t, "linux/arm64", arm.Platform())
+	require.Equal(t, path.Join(dir, "artifacts", "linux_arm64", "app.tar.gz"), arm.Outputs()[0].Path())
+	require.Equal(t, "arm64", arm.BaseEnvironment()["PLATFORM_ARCH"])
+}
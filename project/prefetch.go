@@ -0,0 +1,72 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"sort"
+)
+
+// Prerequisites are the things that must be downloaded before a set of
+// Rules can run without network access
+type Prerequisites struct {
+
+	// Images are the Docker images used by the Rules
+	Images []string
+
+	// Nix are the Nix environments of native Rules
+	Nix []*NixEnvironment
+
+	// Tools are the pinned tool versions of native Rules
+	Tools []*ToolVersions
+}
+
+// FindPrerequisites returns the prerequisites of the given Rules. Images are
+// only included if rules run in Docker, in which case the Nix environments
+// and tool versions of Docker-enabled Components are not needed.
+func FindPrerequisites(rules []*Rule, useDocker bool) Prerequisites {
+	var result Prerequisites
+	images := map[string]bool{}
+	nix := map[string]bool{}
+	tools := map[string]bool{}
+	for _, r := range rules {
+		c := r.Component()
+		if useDocker && !r.IsNative() {
+			if !images[r.Image()] {
+				images[r.Image()] = true
+				result.Images = append(result.Images, r.Image())
+			}
+			continue
+		}
+		// Matches the precedence used to wrap native commands
+		if c.nix != nil {
+			if !nix[c.nix.File] {
+				nix[c.nix.File] = true
+				result.Nix = append(result.Nix, c.nix)
+			}
+		} else if c.tools != nil {
+			if !tools[c.tools.File] {
+				tools[c.tools.File] = true
+				result.Tools = append(result.Tools, c.tools)
+			}
+		}
+	}
+	sort.Strings(result.Images)
+	sort.Slice(result.Nix, func(i, j int) bool {
+		return result.Nix[i].File < result.Nix[j].File
+	})
+	sort.Slice(result.Tools, func(i, j int) bool {
+		return result.Tools[i].File < result.Tools[j].File
+	})
+	return result
+}
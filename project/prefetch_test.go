@@ -0,0 +1,61 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindPrerequisites(t *testing.T) {
+
+	nix := &NixEnvironment{File: "/repo/a/flake.nix"}
+	tools := &ToolVersions{Manager: ToolManagerMise, File: "/repo/.tool-versions"}
+
+	a := &Component{name: "a", nix: nix, dockerImage: "golang:1.14"}
+	b := &Component{name: "b", tools: tools}
+	c := &Component{name: "c", dockerImage: "alpine:3"}
+	rules := []*Rule{
+		{component: a, name: "build"},
+		{component: a, name: "test"},
+		{component: b, name: "build"},
+		{component: c, name: "build"},
+		{component: c, name: "native", native: true},
+	}
+
+	prereqs := FindPrerequisites(rules, true)
+	require.Equal(t, []string{"alpine:3", "golang:1.14"}, prereqs.Images)
+	require.Empty(t, prereqs.Nix)
+	require.Equal(t, []*ToolVersions{tools}, prereqs.Tools)
+
+	prereqs = FindPrerequisites(rules, false)
+	require.Empty(t, prereqs.Images)
+	require.Equal(t, []*NixEnvironment{nix}, prereqs.Nix)
+	require.Equal(t, []*ToolVersions{tools}, prereqs.Tools)
+}
+
+func TestOfflineWrappers(t *testing.T) {
+
+	nix := &NixEnvironment{File: "/repo/flake.nix", Offline: true}
+	require.Equal(t, []string{"nix", "develop", "--offline", "/repo", "--command"},
+		nix.Wrapper())
+	require.Equal(t, []string{"nix", "develop", "/repo", "--command", "true"},
+		nix.FetchCommand())
+
+	tools := &ToolVersions{Manager: ToolManagerMise, Offline: true}
+	require.Equal(t, []string{"env", "MISE_OFFLINE=1", "mise", "exec", "--"},
+		tools.Wrapper())
+	require.Equal(t, []string{"mise", "install"}, tools.InstallCommand())
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	osexec "os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// processQueryLimitedInformation is the access right needed to read
+	// the exit code of a process
+	processQueryLimitedInformation = 0x1000
+
+	// stillActive is the exit code of a process that hasn't exited
+	stillActive = 259
+)
+
+// processExists returns true if a process with the given ID is running.
+// Windows can't signal processes, so the process is opened instead.
+func processExists(pid int) bool {
+	handle, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(handle)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(handle, &code); err != nil {
+		return false
+	}
+	return code == stillActive
+}
+
+// StopProcess terminates the process with the given ID along with its child
+// processes. Windows can't deliver SIGTERM, so a zim process is unable to stop
+// its services itself and the whole process tree is terminated instead.
+func StopProcess(pid int) error {
+	output, err := osexec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(pid)).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("taskkill failed: %s %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
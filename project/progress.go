@@ -0,0 +1,250 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Types of ProgressEvent
+const (
+	RunStarted   = "run_started"
+	RuleStarted  = "rule_started"
+	RuleLog      = "log"
+	RuleFinished = "rule_finished"
+	RunFinished  = "run_finished"
+)
+
+// ProgressEvent describes progress of a run. Events for a Rule share an ID
+// derived from the run ID and the Rule's node ID, so the ID is stable across
+// events and meaningful across runs of the same Rule.
+type ProgressEvent struct {
+	ID       string    `json:"id"`
+	RunID    string    `json:"run_id"`
+	Type     string    `json:"type"`
+	Time     time.Time `json:"time"`
+	Rule     string    `json:"rule,omitempty"`
+	Code     string    `json:"code,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Warning  bool      `json:"warning,omitempty"`
+	Log      string    `json:"log,omitempty"`
+	Duration float64   `json:"duration,omitempty"`
+	Estimate float64   `json:"estimate,omitempty"`
+	Percent  float64   `json:"percent"`
+	Outputs  []string  `json:"outputs,omitempty"`
+	LogFile  string    `json:"log_file,omitempty"`
+}
+
+// ProgressOpts are options used to create a Progress recorder
+type ProgressOpts struct {
+
+	// RunID identifies the run
+	RunID string
+
+	// Total is the number of Rules expected to run, used to estimate the
+	// percentage of the run that is complete. Zero if unknown.
+	Total int
+
+	// History provides estimated durations for Rules, if set
+	History *History
+
+	// LogsDir is a directory where the output of each Rule is written, to a
+	// file named after its node ID. If set, output is not emitted as log
+	// events and the path of the file is given when the Rule finishes. A
+	// log already written by RuleLogs is used instead of a new file.
+	LogsDir string
+
+	// Emit is called with each event, one at a time
+	Emit func(ProgressEvent)
+}
+
+// Progress emits events as Rules start, write output, and finish. Use its
+// Middleware in a Chain to enable it.
+type Progress struct {
+	mutex    sync.Mutex
+	opts     ProgressOpts
+	finished int
+}
+
+// NewProgress returns a Progress recorder
+func NewProgress(opts ProgressOpts) *Progress {
+	return &Progress{opts: opts}
+}
+
+// percent returns the estimated percentage of the run that is complete.
+// The caller must hold the mutex.
+func (p *Progress) percent() float64 {
+	if p.opts.Total <= 0 {
+		return 0
+	}
+	percent := 100 * float64(p.finished) / float64(p.opts.Total)
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// emit fills in common fields and emits the event
+func (p *Progress) emit(event ProgressEvent, ruleFinished bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if ruleFinished {
+		p.finished++
+	}
+	event.RunID = p.opts.RunID
+	event.ID = p.opts.RunID
+	if event.Rule != "" {
+		event.ID = p.opts.RunID + "/" + event.Rule
+	}
+	event.Time = time.Now().UTC()
+	event.Percent = p.percent()
+	if event.Type == RunFinished {
+		event.Percent = 100
+	}
+	p.opts.Emit(event)
+}
+
+// Start emits an event indicating the run started
+func (p *Progress) Start() {
+	p.emit(ProgressEvent{Type: RunStarted}, false)
+}
+
+// Finish emits an event indicating the run finished with the given error
+func (p *Progress) Finish(err error) {
+	event := ProgressEvent{Type: RunFinished}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	p.emit(event, false)
+}
+
+// progressWriter emits output written by a Rule as log events
+type progressWriter struct {
+	progress *Progress
+	rule     string
+}
+
+func (w *progressWriter) Write(data []byte) (int, error) {
+	w.progress.emit(ProgressEvent{
+		Type: RuleLog,
+		Rule: w.rule,
+		Log:  string(data),
+	}, false)
+	return len(data), nil
+}
+
+// Middleware emits events for Rules run by the wrapped Runner
+func (p *Progress) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+
+		started := ProgressEvent{Type: RuleStarted, Rule: r.NodeID()}
+		if p.opts.History != nil {
+			if h, found := p.opts.History.Rule(r); found {
+				started.Estimate = h.AverageDuration().Seconds()
+			}
+		}
+		p.emit(started, false)
+
+		var writer io.Writer = &progressWriter{progress: p, rule: r.NodeID()}
+		var logFile string
+		if p.opts.LogsDir != "" && opts.LogFile != "" {
+			// The output is already being written to a log by RuleLogs
+			writer, logFile = ioutil.Discard, opts.LogFile
+		} else if p.opts.LogsDir != "" {
+			f, err := createRuleLog(p.opts.LogsDir, r)
+			if err != nil {
+				return Error, err
+			}
+			defer f.Close()
+			writer, logFile = f, f.Name()
+		}
+		opts.Output = teeWriter(opts.Output, writer)
+		opts.DebugOutput = teeWriter(opts.DebugOutput, writer)
+
+		startedAt := time.Now()
+		code, err := runner.Run(ctx, r, opts)
+
+		finished := ProgressEvent{
+			Type:     RuleFinished,
+			Rule:     r.NodeID(),
+			Code:     code.String(),
+			Duration: time.Since(startedAt).Seconds(),
+			LogFile:  logFile,
+		}
+		if code == OK || code == Cached {
+			finished.Outputs = outputPaths(r)
+		}
+		if err != nil {
+			finished.Error = err.Error()
+			finished.Warning = IsAllowedFailure(err)
+		}
+		p.emit(finished, true)
+		return code, err
+	})
+}
+
+// createRuleLog creates the file in the directory that a Rule's output is
+// written to
+func createRuleLog(dir string, r *Rule) (*os.File, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(filepath.Join(dir, r.NodeID()+".log"))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create log for %s: %s", r.NodeID(), err)
+	}
+	return f, nil
+}
+
+// outputPaths returns the paths of the Rule's outputs. Files are given
+// relative to the project root.
+func outputPaths(r *Rule) []string {
+	var paths []string
+	for _, out := range r.Outputs() {
+		outPath := out.Path()
+		if out.OnFilesystem() {
+			if rel, err := filepath.Rel(r.Project().RootAbsPath(), outPath); err == nil {
+				outPath = rel
+			}
+		}
+		paths = append(paths, outPath)
+	}
+	return paths
+}
+
+// NewEventWriter returns a function that writes each event it is called
+// with to w as a line of JSON, suitable for use as ProgressOpts.Emit
+func NewEventWriter(w io.Writer) func(ProgressEvent) {
+	enc := json.NewEncoder(w)
+	return func(event ProgressEvent) {
+		enc.Encode(event)
+	}
+}
+
+// teeWriter returns a writer that writes to both w and the progress writer.
+// Output defaults to stdout when w is nil.
+func teeWriter(w io.Writer, progress io.Writer) io.Writer {
+	if w == nil {
+		w = os.Stdout
+	}
+	return io.MultiWriter(w, progress)
+}
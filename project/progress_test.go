@@ -0,0 +1,124 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgress(t *testing.T) {
+
+	c := &Component{name: "app"}
+	build := &Rule{component: c, name: "build"}
+	test := &Rule{component: c, name: "test"}
+
+	var events []ProgressEvent
+	progress := NewProgress(ProgressOpts{
+		RunID: "run1",
+		Total: 2,
+		Emit:  func(e ProgressEvent) { events = append(events, e) },
+	})
+	runner := progress.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			fmt.Fprint(opts.Output, "running ", r.Name())
+			if r == test {
+				return ExecError, errors.New("tests failed")
+			}
+			return OK, nil
+		}))
+
+	ctx := context.Background()
+	progress.Start()
+	runner.Run(ctx, build, RunOpts{Output: ioutil.Discard, DebugOutput: ioutil.Discard})
+	runner.Run(ctx, test, RunOpts{Output: ioutil.Discard, DebugOutput: ioutil.Discard})
+	progress.Finish(errors.New("failed"))
+
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+		assert.Equal(t, "run1", e.RunID)
+	}
+	require.Equal(t, []string{
+		RunStarted,
+		RuleStarted, RuleLog, RuleFinished,
+		RuleStarted, RuleLog, RuleFinished,
+		RunFinished,
+	}, types)
+
+	assert.Equal(t, "run1", events[0].ID)
+	assert.Equal(t, "run1/app.build", events[1].ID)
+	assert.Equal(t, "running build", events[2].Log)
+	assert.Equal(t, "ok", events[3].Code)
+	assert.Equal(t, 50.0, events[3].Percent)
+	assert.Equal(t, "run1/app.test", events[6].ID)
+	assert.Equal(t, "exec-error", events[6].Code)
+	assert.Equal(t, "tests failed", events[6].Error)
+	assert.Equal(t, 100.0, events[6].Percent)
+	assert.Equal(t, "failed", events[7].Error)
+}
+
+func TestProgressEventStream(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	c := &Component{name: "app"}
+	build := &Rule{component: c, name: "build"}
+
+	var buf bytes.Buffer
+	progress := NewProgress(ProgressOpts{
+		RunID:   "run1",
+		Total:   1,
+		LogsDir: filepath.Join(dir, "logs"),
+		Emit:    NewEventWriter(&buf),
+	})
+	runner := progress.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			fmt.Fprint(opts.Output, "running ", r.Name())
+			return Cached, nil
+		}))
+
+	progress.Start()
+	runner.Run(context.Background(), build, RunOpts{Output: ioutil.Discard, DebugOutput: ioutil.Discard})
+	progress.Finish(nil)
+
+	var events []ProgressEvent
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var event ProgressEvent
+		require.Nil(t, dec.Decode(&event))
+		events = append(events, event)
+	}
+	require.Len(t, events, 4)
+	assert.Equal(t, RuleFinished, events[2].Type)
+	assert.Equal(t, "cached", events[2].Code)
+
+	// Output goes to the log file rather than the stream
+	logFile := filepath.Join(dir, "logs", "app.build.log")
+	assert.Equal(t, logFile, events[2].LogFile)
+	data, err := ioutil.ReadFile(logFile)
+	require.Nil(t, err)
+	assert.Equal(t, "running build", string(data))
+}
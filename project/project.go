@@ -25,6 +25,7 @@ import (
 
 	"github.com/fugue/zim/definitions"
 	"github.com/fugue/zim/exec"
+	"github.com/fugue/zim/vcs"
 	"github.com/hashicorp/go-multierror"
 )
 
@@ -41,6 +42,14 @@ type Project struct {
 	providers       map[string]Provider
 	providerOptions map[string]map[string]interface{}
 	executor        exec.Executor
+	offline         bool
+	platform        string
+	repo            vcs.VCS
+	commitID        string
+	parameters      map[string]string
+	cacheVolumes    map[string]string
+	shell           string
+	shellOptions    []string
 }
 
 // Opts defines options used when initializing a Project
@@ -50,6 +59,21 @@ type Opts struct {
 	ComponentDefs []*definitions.Component
 	Providers     []Provider
 	Executor      exec.Executor
+
+	// Offline prevents Nix and tool version managers from downloading
+	Offline bool
+
+	// Platform is the target platform of Rules, e.g. "linux/amd64", unless
+	// a Rule selects one with a PLATFORM matrix variable
+	Platform string
+
+	// VCS manages the Project's files. It is detected from the root
+	// directory if not given.
+	VCS vcs.VCS
+
+	// Parameters of the build, e.g. given on the command line, which are
+	// variables of every Rule
+	Parameters map[string]string
 }
 
 // New returns a Project that resides at the given root directory
@@ -88,6 +112,13 @@ func NewWithOptions(opts Opts) (*Project, error) {
 		executor = exec.NewBashExecutor()
 	}
 
+	repo := opts.VCS
+	if repo == nil {
+		if repo, err = vcs.Detect(rootAbs); err != nil {
+			return nil, err
+		}
+	}
+
 	p := &Project{
 		root:            root,
 		rootAbs:         rootAbs,
@@ -97,10 +128,17 @@ func NewWithOptions(opts Opts) (*Project, error) {
 		providers:       map[string]Provider{},
 		providerOptions: map[string]map[string]interface{}{},
 		executor:        executor,
+		offline:         opts.Offline,
+		platform:        opts.Platform,
+		repo:            repo,
+		parameters:      copyEnvironment(opts.Parameters),
 	}
 
 	if opts.ProjectDef != nil {
 		p.name = opts.ProjectDef.Name
+		p.cacheVolumes = opts.ProjectDef.Docker.CacheVolumes
+		p.shell = opts.ProjectDef.Shell
+		p.shellOptions = opts.ProjectDef.ShellOptions
 	}
 
 	for _, provider := range opts.Providers {
@@ -144,6 +182,12 @@ func (p *Project) Name() string {
 	return p.name
 }
 
+// Parameters returns the build parameters, which are variables of every
+// Rule. They override variables set for Components and Rules.
+func (p *Project) Parameters() map[string]string {
+	return copyEnvironment(p.parameters)
+}
+
 // Components returns all Components within the project
 func (p *Project) Components() Components {
 	return p.components
@@ -173,6 +217,69 @@ func (p *Project) ArtifactsDir() string {
 	return p.artifacts
 }
 
+// Platform returns the target platform of the Project, if any
+func (p *Project) Platform() string {
+	return p.platform
+}
+
+// Offline returns true if the Project was loaded in offline mode
+func (p *Project) Offline() bool {
+	return p.offline
+}
+
+// HistoryPath returns the path to the file used to persist run History
+func (p *Project) HistoryPath() string {
+	return path.Join(p.artifacts, ".zim", "history.json")
+}
+
+// FlakyPath returns the path to the file used to persist test flakiness
+func (p *Project) FlakyPath() string {
+	return path.Join(p.artifacts, ".zim", "flaky.json")
+}
+
+// BuildsDir returns the directory where records of builds are kept
+func (p *Project) BuildsDir() string {
+	return path.Join(p.artifacts, ".zim", "builds")
+}
+
+// QuarantineDir returns the directory where outputs rejected by a scanner
+// are moved
+func (p *Project) QuarantineDir() string {
+	return path.Join(p.artifacts, ".zim", "quarantine")
+}
+
+// LogsDir returns the directory where the output of each rule is kept, in a
+// subdirectory for each build
+func (p *Project) LogsDir() string {
+	return path.Join(p.artifacts, "logs")
+}
+
+// VCS returns the version control system managing the Project's files
+func (p *Project) VCS() vcs.VCS {
+	return p.repo
+}
+
+// CommitID returns the commit checked out in the Project's repository. It
+// is looked up once and then remembered.
+func (p *Project) CommitID() (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if p.commitID == "" {
+		commitID, err := p.repo.CommitID()
+		if err != nil {
+			return "", err
+		}
+		p.commitID = commitID
+	}
+	return p.commitID, nil
+}
+
+// ServicesDir returns the path to the directory where the state of running
+// services is saved
+func (p *Project) ServicesDir() string {
+	return path.Join(p.artifacts, ".zim", "services")
+}
+
 // Select returns components with matching names or kind
 func (p *Project) Select(names, kinds []string) (Components, error) {
 
@@ -210,6 +317,82 @@ func (p *Project) Select(names, kinds []string) (Components, error) {
 	return selected, nil
 }
 
+// componentDeps returns the Components that each Component depends on, either
+// through rule dependencies or imported exports
+func (p *Project) componentDeps() map[*Component]Components {
+	result := map[*Component]Components{}
+	for _, c := range p.components {
+		seen := map[*Component]bool{c: true}
+		add := func(dep *Component) {
+			if !seen[dep] {
+				seen[dep] = true
+				result[c] = append(result[c], dep)
+			}
+		}
+		for _, r := range c.rules {
+			for _, dep := range r.Dependencies() {
+				add(dep.Component())
+			}
+			for _, imp := range r.resolvedImports {
+				add(imp.Component)
+			}
+		}
+	}
+	return result
+}
+
+// expandComponents returns the named Components plus all Components reachable
+// from them by following the given edges. Project ordering is preserved.
+func (p *Project) expandComponents(names []string, edges map[*Component]Components) (Components, error) {
+	reached := map[*Component]bool{}
+	var queue Components
+	for _, name := range names {
+		c := p.Components().WithName(name).First()
+		if c == nil {
+			return nil, fmt.Errorf("unknown component: %s", name)
+		}
+		if !reached[c] {
+			reached[c] = true
+			queue = append(queue, c)
+		}
+	}
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		for _, next := range edges[c] {
+			if !reached[next] {
+				reached[next] = true
+				queue = append(queue, next)
+			}
+		}
+	}
+	var result Components
+	for _, c := range p.components {
+		if reached[c] {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+
+// Dependents returns the named Components along with every Component that
+// directly or transitively depends on them
+func (p *Project) Dependents(names []string) (Components, error) {
+	reverse := map[*Component]Components{}
+	for c, deps := range p.componentDeps() {
+		for _, dep := range deps {
+			reverse[dep] = append(reverse[dep], c)
+		}
+	}
+	return p.expandComponents(names, reverse)
+}
+
+// Dependencies returns the named Components along with every Component that
+// they directly or transitively depend on
+func (p *Project) Dependencies(names []string) (Components, error) {
+	return p.expandComponents(names, p.componentDeps())
+}
+
 // Rule returns the specified Rule and a boolean indicating whether it was found
 func (p *Project) Rule(component, ruleName string) (*Rule, bool) {
 	rule := p.Components().WithName(component).Rule(ruleName).First()
@@ -251,7 +434,9 @@ func (p *Project) Toolchain(c *Component) (map[string]string, error) {
 		executor = p.executor
 	} else {
 		// Component is not using Docker
-		if p.executor.UsesDocker() {
+		if wrapper := c.nativeWrapper(); wrapper != nil {
+			executor = exec.NewWrappedBashExecutor(wrapper...)
+		} else if p.executor.UsesDocker() {
 			executor = exec.NewBashExecutor()
 		} else {
 			executor = p.executor
@@ -263,9 +448,36 @@ func (p *Project) Toolchain(c *Component) (map[string]string, error) {
 		if usingDocker {
 			return fmt.Sprintf("%s.%s", c.dockerImage, command)
 		}
+		if c.nix != nil {
+			return fmt.Sprintf("%s.%s", c.nix.File, command)
+		}
+		if c.tools != nil {
+			return fmt.Sprintf("%s.%s", c.tools.File, command)
+		}
 		return command
 	}
 
+	// Changes to the pinned Nix environment are part of the toolchain
+	if c.nix != nil {
+		nixHash, err := c.nix.Hash()
+		if err != nil {
+			return nil, err
+		}
+		res["nix"] = nixHash
+	}
+
+	// Pinned tool versions are part of the toolchain. Items defined
+	// explicitly take precedence.
+	if c.tools != nil {
+		versions, err := c.tools.Versions()
+		if err != nil {
+			return nil, err
+		}
+		for name, version := range versions {
+			res[name] = version
+		}
+	}
+
 	for _, item := range c.toolchain.Items {
 		key := toolchainKey(item.Command)
 		value, found := p.toolchain[key]
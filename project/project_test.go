@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
 )
 
 func testDir(parent ...string) string {
@@ -243,3 +244,165 @@ func TestResolveDeps(t *testing.T) {
 		t.Fatal("Expected dependency to be 'b.build'")
 	}
 }
+
+func TestDependentsAndDependencies(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	dep := func(component, rule string) definitions.Dependency {
+		return definitions.Dependency{Component: component, Rule: rule}
+	}
+	defs := []*definitions.Component{
+		{
+			Name:  "lib",
+			Path:  path.Join(dir, "lib", "component.yaml"),
+			Rules: map[string]definitions.Rule{"build": {}},
+		},
+		{
+			Name: "svc",
+			Path: path.Join(dir, "svc", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {Requires: []definitions.Dependency{dep("lib", "build")}},
+			},
+		},
+		{
+			Name: "app",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {Requires: []definitions.Dependency{dep("svc", "build")}},
+			},
+		},
+		{
+			Name:  "other",
+			Path:  path.Join(dir, "other", "component.yaml"),
+			Rules: map[string]definitions.Rule{"build": {}},
+		},
+	}
+
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := func(comps Components) (result []string) {
+		for _, c := range comps {
+			result = append(result, c.Name())
+		}
+		return
+	}
+
+	dependents, err := p.Dependents([]string{"lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(dependents), []string{"lib", "svc", "app"}) {
+		t.Errorf("Unexpected dependents: %v", names(dependents))
+	}
+
+	dependencies, err := p.Dependencies([]string{"app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(dependencies), []string{"lib", "svc", "app"}) {
+		t.Errorf("Unexpected dependencies: %v", names(dependencies))
+	}
+
+	if _, err := p.Dependents([]string{"missing"}); err == nil {
+		t.Error("Expected an error for an unknown component")
+	}
+}
+
+func TestLocate(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	fooDir, _ := testComponentDir(dir, "foo")
+	testComponentFile(fooDir, "main.go", "")
+
+	defs := []*definitions.Component{
+		{
+			Name: "foo",
+			Path: path.Join(dir, "src", "foo", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {Inputs: []string{"*.go"}},
+				"test":  {Inputs: []string{"*.go"}},
+			},
+		},
+		{
+			Name: "bar",
+			Path: path.Join(dir, "src", "bar", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {Inputs: []string{"../foo/main.go"}},
+				"lint":  {Inputs: []string{"*.go"}},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+
+	info, err := p.Locate(path.Join(fooDir, "main.go"))
+	require.Nil(t, err)
+	require.Equal(t, "src/foo/main.go", info.File)
+	require.Equal(t, "foo", info.Component)
+	require.Equal(t, []string{"foo.test", "foo.build", "bar.build"}, info.Rules)
+	require.Equal(t, FileAction{
+		Title:   "Run foo.test",
+		Rule:    "foo.test",
+		Command: []string{"zim", "run", "test", "-c", "foo"},
+	}, info.Actions[0])
+
+	info, err = p.Locate("README.md")
+	require.Nil(t, err)
+	require.Equal(t, "", info.Component)
+	require.Empty(t, info.Rules)
+
+	_, err = p.Locate("/etc/passwd")
+	require.NotNil(t, err)
+}
+
+func TestProjectShell(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{
+		{
+			Name: "a",
+			Path: path.Join(dir, "a", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {Command: "make"},
+				"test":  {Command: "make test", Shell: "bash"},
+			},
+		},
+		{
+			Name:  "b",
+			Path:  path.Join(dir, "b", "component.yaml"),
+			Shell: "pwsh",
+			Rules: map[string]definitions.Rule{
+				"build": {Command: "Write-Output hi"},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{
+		Root:          dir,
+		ComponentDefs: defs,
+		ProjectDef: &definitions.Project{
+			Name:         "example",
+			Shell:        "sh",
+			ShellOptions: []string{"-eu"},
+		},
+	})
+	require.Nil(t, err)
+
+	build, _ := p.Rule("a", "build")
+	require.Equal(t, "sh", build.Shell())
+	require.Equal(t, []string{"-eu"}, build.ShellOptions())
+
+	test, _ := p.Rule("a", "test")
+	require.Equal(t, "bash", test.Shell())
+
+	// The project's options don't apply to a component's own shell
+	other, _ := p.Rule("b", "build")
+	require.Equal(t, "pwsh", other.Shell())
+	require.Nil(t, other.ShellOptions())
+}
@@ -0,0 +1,176 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Report is the outcome of running the Rules of an ExecutionPlan, written by
+// the external system that ran them
+type Report struct {
+	BuildID string         `json:"build_id"`
+	Results []ReportedRule `json:"results"`
+}
+
+// ReportedRule is the outcome of one Rule of a Report. The status is a result
+// code name such as "ok" or "exec-error". Outputs are the locations of the
+// artifacts the Rule produced, in the order of the Rule's outputs in the
+// plan. They may be omitted if the artifacts were written to the planned
+// paths. Relative locations are relative to the project root.
+type ReportedRule struct {
+	ID       string   `json:"id"`
+	Key      string   `json:"key,omitempty"`
+	Status   string   `json:"status"`
+	Duration float64  `json:"duration,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Outputs  []string `json:"outputs,omitempty"`
+}
+
+// Code returns the result code of the reported status
+func (rr ReportedRule) Code() (Code, error) {
+	code, ok := ParseCode(rr.Status)
+	if !ok {
+		return Error, fmt.Errorf("rule %s has unknown status %q", rr.ID, rr.Status)
+	}
+	return code, nil
+}
+
+// Elapsed returns the reported duration of the Rule
+func (rr ReportedRule) Elapsed() time.Duration {
+	return time.Duration(rr.Duration * float64(time.Second))
+}
+
+// LoadReport reads a Report from a JSON file
+func LoadReport(path string) (*Report, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("invalid report %s: %s", path, err)
+	}
+	for _, rr := range report.Results {
+		if rr.ID == "" {
+			return nil, fmt.Errorf("invalid report %s: result has no rule id", path)
+		}
+		if _, err := rr.Code(); err != nil {
+			return nil, fmt.Errorf("invalid report %s: %s", path, err)
+		}
+	}
+	return &report, nil
+}
+
+// RuleByID returns the Rule with the given node ID, e.g. "app.build" or
+// "app.build[linux]"
+func (p *Project) RuleByID(id string) (*Rule, error) {
+	parts := strings.SplitN(id, ".", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid rule id: %s", id)
+	}
+	r, found := p.Rule(parts[0], parts[1])
+	if !found {
+		return nil, fmt.Errorf("unknown rule: %s", id)
+	}
+	return r, nil
+}
+
+// StageOutputs copies the reported artifacts of a Rule to its output paths
+// and confirms that every output exists. Artifacts already at their output
+// paths are left in place.
+func StageOutputs(r *Rule, rr ReportedRule) error {
+	outputs := r.Outputs()
+	if len(rr.Outputs) > 0 {
+		if len(rr.Outputs) != len(outputs) {
+			return fmt.Errorf("rule %s reported %d outputs, expected %d",
+				r.NodeID(), len(rr.Outputs), len(outputs))
+		}
+		root := r.Project().RootAbsPath()
+		for i, location := range rr.Outputs {
+			if !outputs[i].OnFilesystem() {
+				continue
+			}
+			if !filepath.IsAbs(location) {
+				location = filepath.Join(root, location)
+			}
+			dst := outputs[i].Path()
+			if filepath.Clean(location) == filepath.Clean(dst) {
+				continue
+			}
+			if err := copyPath(location, dst); err != nil {
+				return fmt.Errorf("rule %s output %s: %s", r.NodeID(), location, err)
+			}
+		}
+	}
+	if missing := r.MissingOutputs(); len(missing) > 0 {
+		return fmt.Errorf("rule %s is missing output %s", r.NodeID(), missing[0].Path())
+	}
+	return nil
+}
+
+// copyPath copies a file or a directory tree, replacing the destination
+func copyPath(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return copyFile(src, dst, info.Mode())
+	}
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		return copyFile(p, target, info.Mode())
+	})
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
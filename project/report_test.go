@@ -0,0 +1,114 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadReport(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	reportPath := path.Join(dir, "results.json")
+	require.Nil(t, ioutil.WriteFile(reportPath, []byte(`{
+		"build_id": "123",
+		"results": [
+			{"id": "app.build", "status": "ok", "duration": 1.5, "outputs": ["out/app"]},
+			{"id": "app.test", "status": "exec-error", "error": "exit status 1"}
+		]
+	}`), 0644))
+
+	report, err := LoadReport(reportPath)
+	require.Nil(t, err)
+	require.Equal(t, "123", report.BuildID)
+	require.Len(t, report.Results, 2)
+	require.Equal(t, []string{"out/app"}, report.Results[0].Outputs)
+	require.Equal(t, "1.5s", report.Results[0].Elapsed().String())
+
+	code, err := report.Results[1].Code()
+	require.Nil(t, err)
+	require.Equal(t, ExecError, code)
+
+	require.Nil(t, ioutil.WriteFile(reportPath, []byte(`{
+		"results": [{"id": "app.build", "status": "done"}]
+	}`), 0644))
+	_, err = LoadReport(reportPath)
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), `unknown status "done"`)
+}
+
+func TestStageOutputs(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Outputs: []string{"app", "docs"},
+					Command: "go build -o ${ARTIFACT}",
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+
+	r, err := p.RuleByID("app.build")
+	require.Nil(t, err)
+	_, err = p.RuleByID("app.deploy")
+	require.NotNil(t, err)
+
+	// Artifacts produced elsewhere, one file and one directory
+	require.Nil(t, os.MkdirAll(path.Join(dir, "remote", "docs"), 0755))
+	require.Nil(t, ioutil.WriteFile(path.Join(dir, "remote", "app"), []byte("bin"), 0755))
+	require.Nil(t, ioutil.WriteFile(path.Join(dir, "remote", "docs", "index.html"), []byte("<html>"), 0644))
+
+	err = StageOutputs(r, ReportedRule{ID: "app.build", Status: "ok", Outputs: []string{"remote/app"}})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "reported 1 outputs, expected 2")
+
+	err = StageOutputs(r, ReportedRule{ID: "app.build", Status: "ok"})
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "missing output")
+
+	err = StageOutputs(r, ReportedRule{
+		ID:      "app.build",
+		Status:  "ok",
+		Outputs: []string{"remote/app", path.Join(dir, "remote", "docs")},
+	})
+	require.Nil(t, err)
+
+	data, err := ioutil.ReadFile(path.Join(dir, "artifacts", "app"))
+	require.Nil(t, err)
+	require.Equal(t, "bin", string(data))
+	data, err = ioutil.ReadFile(path.Join(dir, "artifacts", "docs", "index.html"))
+	require.Nil(t, err)
+	require.Equal(t, "<html>", string(data))
+
+	// Outputs already in place are accepted as they are
+	err = StageOutputs(r, ReportedRule{ID: "app.build", Status: "ok"})
+	require.Nil(t, err)
+}
@@ -50,6 +50,18 @@ type Resource interface {
 	AsFile() (string, error)
 }
 
+// Portable is implemented by Resources that are not files but can be saved
+// to a file and restored from it, such as container images. This allows them
+// to be stored in a cache.
+type Portable interface {
+
+	// Export saves the Resource to a file at the given path
+	Export(dst string) error
+
+	// Import restores the Resource from a file created by Export
+	Import(src string) error
+}
+
 // HashFile returns the SHA1 hash of File contents
 func HashFile(filePath string) (string, error) {
 	file, err := os.Open(filePath)
@@ -15,12 +15,19 @@ package project
 
 import (
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Resources is shorthand for a slice of Resources
 type Resources []Resource
 
+// IsURI returns true if the path is a URI such as "s3://bucket/key" rather
+// than a path relative to a component
+func IsURI(p string) bool {
+	return strings.Contains(p, "://")
+}
+
 // Paths of all the Resources
 func (rs Resources) Paths() (paths []string) {
 	paths = make([]string, 0, len(rs))
@@ -47,6 +54,25 @@ func (rs Resources) RelativePaths(base string) (paths []string, err error) {
 	return
 }
 
+// Without returns these Resources less any with the same path as one of
+// the given Resources
+func (rs Resources) Without(removing Resources) Resources {
+	if len(removing) == 0 {
+		return rs
+	}
+	removed := make(map[string]bool, len(removing))
+	for _, r := range removing {
+		removed[r.Path()] = true
+	}
+	result := make(Resources, 0, len(rs))
+	for _, r := range rs {
+		if !removed[r.Path()] {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
 // LastModified returns the most recent modification of all these Resources
 func (rs Resources) LastModified() (t time.Time, err error) {
 	for _, f := range rs {
@@ -0,0 +1,129 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/fugue/zim/definitions"
+)
+
+// retryableCodes are the failures a RetryPolicy may retry
+var retryableCodes = map[string]Code{
+	"error":          Error,
+	"exec-error":     ExecError,
+	"missing-output": MissingOutputError,
+	"timeout":        Timeout,
+}
+
+// RetryPolicy determines whether and how a failed Rule is run again
+type RetryPolicy struct {
+
+	// Count is the number of times the Rule may be retried
+	Count int
+
+	// Delay is the time to wait before each retry
+	Delay time.Duration
+
+	// On lists the failures that are retried
+	On []Code
+}
+
+// NewRetryPolicy returns a RetryPolicy from its definition. Command
+// failures are retried if the definition doesn't specify which failures.
+func NewRetryPolicy(self definitions.Retries) (RetryPolicy, error) {
+	policy := RetryPolicy{Count: self.Count}
+	if self.Count < 0 {
+		return policy, fmt.Errorf("invalid retry count: %d", self.Count)
+	}
+	if self.Delay != "" {
+		delay, err := time.ParseDuration(self.Delay)
+		if err != nil || delay < 0 {
+			return policy, fmt.Errorf("invalid retry delay: %s", self.Delay)
+		}
+		policy.Delay = delay
+	}
+	for _, name := range strings.Split(self.On, ",") {
+		name = strings.Replace(strings.TrimSpace(name), "_", "-", -1)
+		if name == "" {
+			continue
+		}
+		code, found := retryableCodes[name]
+		if !found {
+			return policy, fmt.Errorf("invalid retry condition: %s", name)
+		}
+		policy.On = append(policy.On, code)
+	}
+	if len(policy.On) == 0 {
+		policy.On = []Code{ExecError}
+	}
+	return policy, nil
+}
+
+// Retries returns true if the policy allows retrying a failure with the
+// given code after the given number of attempts
+func (p RetryPolicy) Retries(code Code, attempts int) bool {
+	if attempts > p.Count {
+		return false
+	}
+	for _, c := range p.On {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Retry is middleware that runs a failed Rule again as allowed by its
+// RetryPolicy. The reason for each retry is logged, and the output of each
+// attempt follows a heading of its own so that attempts can be told apart,
+// including in the Rule's log. Each retry runs as a child of the build.
+func Retry(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		policy := r.RetryPolicy()
+		code, err := runner.Run(ctx, r, opts)
+		for attempt := 1; err != nil && policy.Retries(code, attempt); attempt++ {
+			if ctx.Err() != nil {
+				break
+			}
+			output := opts.Output
+			if output == nil {
+				output = os.Stdout
+			}
+			retryOpts := opts
+			retryOpts.Attempt = attempt + 1
+			if opts.BuildID != "" {
+				retryOpts.BuildID = UUID()
+				retryOpts.ParentBuildID = opts.BuildID
+			}
+			fmt.Fprintln(output, "rule:", Bright(r.NodeID()), Yellow(fmt.Sprintf(
+				"[RETRY %d/%d] after %s: %s", attempt, policy.Count, code, err)))
+			if policy.Delay > 0 {
+				select {
+				case <-time.After(policy.Delay):
+				case <-ctx.Done():
+					return code, err
+				}
+			}
+			fmt.Fprintln(output, "rule:", Bright(r.NodeID()), Bright(fmt.Sprintf(
+				"[ATTEMPT %d/%d]", retryOpts.Attempt, policy.Count+1)))
+			code, err = runner.Run(ctx, r, retryOpts)
+		}
+		return code, err
+	})
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRetryPolicy(t *testing.T) {
+
+	policy, err := NewRetryPolicy(definitions.Retries{Count: 3, Delay: "10s"})
+	require.Nil(t, err)
+	require.Equal(t, RetryPolicy{Count: 3, Delay: 10 * time.Second, On: []Code{ExecError}}, policy)
+
+	policy, err = NewRetryPolicy(definitions.Retries{Count: 1, On: "exec_error, timeout"})
+	require.Nil(t, err)
+	require.Equal(t, []Code{ExecError, Timeout}, policy.On)
+
+	_, err = NewRetryPolicy(definitions.Retries{Count: 1, On: "cached"})
+	require.Equal(t, "invalid retry condition: cached", err.Error())
+
+	_, err = NewRetryPolicy(definitions.Retries{Count: 1, Delay: "soon"})
+	require.Equal(t, "invalid retry delay: soon", err.Error())
+}
+
+func TestRetry(t *testing.T) {
+
+	c := &Component{name: "app"}
+	r := &Rule{
+		component: c,
+		name:      "test",
+		retry:     RetryPolicy{Count: 2, On: []Code{ExecError}},
+	}
+
+	var attempts int
+	var output bytes.Buffer
+	runner := Retry(RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		attempts++
+		if attempts < 3 {
+			return ExecError, errors.New("boom")
+		}
+		return OK, nil
+	}))
+	code, err := runner.Run(context.Background(), r, RunOpts{Output: &output})
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+	require.Equal(t, 3, attempts)
+	require.Contains(t, output.String(), "[RETRY 2/2] after exec-error: boom")
+	require.Contains(t, output.String(), "[ATTEMPT 3/3]")
+
+	// Failures not named by the policy are not retried
+	attempts = 0
+	runner = Retry(RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		attempts++
+		return MissingOutputError, errors.New("no output")
+	}))
+	code, _ = runner.Run(context.Background(), r, RunOpts{Output: &output})
+	require.Equal(t, MissingOutputError, code)
+	require.Equal(t, 1, attempts)
+}
@@ -17,8 +17,10 @@ import (
 	"fmt"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
 )
 
 // Dependency on another Component (a Rule or an Export)
@@ -27,6 +29,7 @@ type Dependency struct {
 	Rule      string
 	Export    string
 	Recurse   int
+	Outputs   []string
 }
 
 // Command to be run by a Rule
@@ -65,14 +68,36 @@ type Rule struct {
 	name            string
 	local           bool
 	native          bool
+	service         bool
+	ports           []string
+	healthCheck     HealthCheck
+	restart         RestartPolicy
+	network         string
+	dockerNetwork   string
+	mutex           string
+	wrapper         []string
+	shell           string
+	shellOptions    []string
+	env             map[string]string
+	ulimits         []exec.Ulimit
+	timeout         time.Duration
+	maxLogSize      int64
+	maxOutputSize   int64
+	retry           RetryPolicy
+	allowFailure    bool
+	baseName        string
+	matrix          map[string]string
+	secrets         map[string]SecretRef
 	inputs          []string
 	ignore          []string
+	cacheIgnore     []string
 	requires        []*Dependency
 	outputs         []string
 	description     string
 	commands        []*Command
 	resolvedDeps    []*Rule
 	resolvedImports []*Export
+	streamed        map[*Rule][]string
 	inProvider      Provider
 	outProvider     Provider
 	when            Condition
@@ -81,6 +106,12 @@ type Rule struct {
 
 // NewRule constructs a Rule from a provided YAML definition
 func NewRule(name string, c *Component, self *definitions.Rule) (*Rule, error) {
+	return newRule(name, nil, c, self)
+}
+
+// newRule constructs a Rule, which is an instance of a matrix Rule if
+// matrix variables are given
+func newRule(name string, matrix map[string]string, c *Component, self *definitions.Rule) (*Rule, error) {
 
 	commands, err := NewCommands(self)
 	if err != nil {
@@ -88,16 +119,33 @@ func NewRule(name string, c *Component, self *definitions.Rule) (*Rule, error) {
 	}
 
 	r := &Rule{
-		component:   c,
-		name:        name,
-		description: self.Description,
-		local:       self.Local,
-		native:      self.Native,
-		inputs:      self.Inputs,
-		ignore:      self.Ignore,
-		outputs:     self.Outputs,
-		commands:    commands,
-		requires:    make([]*Dependency, 0, len(self.Requires)),
+		component:     c,
+		name:          name,
+		description:   self.Description,
+		local:         self.Local,
+		native:        self.Native,
+		service:       self.Service,
+		ports:         self.Ports,
+		restart:       RestartPolicy(self.Restart),
+		network:       self.Network,
+		dockerNetwork: self.Docker.Network,
+		mutex:         self.Mutex,
+		wrapper:       self.Wrapper,
+		shell:         self.Shell,
+		shellOptions:  self.ShellOptions,
+		env:           self.Environment,
+		allowFailure:  self.AllowFailure,
+		inputs:        self.Inputs,
+		ignore:        self.Ignore,
+		cacheIgnore:   self.CacheIgnoreInputs,
+		outputs:       self.Outputs,
+		commands:      commands,
+		requires:      make([]*Dependency, 0, len(self.Requires)),
+	}
+	if matrix != nil {
+		r.baseName = name
+		r.name = matrixRuleName(name, matrix)
+		r.matrix = matrix
 	}
 
 	for _, dep := range self.Requires {
@@ -106,8 +154,73 @@ func NewRule(name string, c *Component, self *definitions.Rule) (*Rule, error) {
 			Rule:      dep.Rule,
 			Export:    dep.Export,
 			Recurse:   dep.Recurse,
+			Outputs:   dep.Outputs,
 		})
 	}
+	if r.service && len(r.outputs) > 0 {
+		return nil, fmt.Errorf("Rule %s is a service and cannot have outputs",
+			r.NodeID())
+	}
+
+	if r.healthCheck, err = newHealthCheck(self.HealthCheck); err != nil {
+		return nil, fmt.Errorf("Rule %s health check error: %s", r.NodeID(), err)
+	}
+	switch r.restart {
+	case RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return nil, fmt.Errorf("Rule %s has an invalid restart policy: %s",
+			r.NodeID(), r.restart)
+	}
+
+	switch r.network {
+	case "", exec.NetworkNone:
+	default:
+		return nil, fmt.Errorf("Rule %s has an invalid network: %s",
+			r.NodeID(), r.network)
+	}
+	if r.dockerNetwork == "" {
+		r.dockerNetwork = c.dockerNetwork
+	}
+	if r.dockerNetwork != "" && !dockerNamePattern.MatchString(r.dockerNetwork) {
+		return nil, fmt.Errorf("Rule %s has an invalid Docker network: %s",
+			r.NodeID(), r.dockerNetwork)
+	}
+	if r.ulimits, err = exec.ParseUlimits(self.Ulimits); err != nil {
+		return nil, fmt.Errorf("Rule %s %s", r.NodeID(), err)
+	}
+	if self.Timeout != "" {
+		if r.timeout, err = time.ParseDuration(self.Timeout); err != nil || r.timeout <= 0 {
+			return nil, fmt.Errorf("Rule %s has an invalid timeout: %s",
+				r.NodeID(), self.Timeout)
+		}
+		if r.service {
+			return nil, fmt.Errorf("Rule %s is a service and cannot have a timeout",
+				r.NodeID())
+		}
+	}
+	if self.MaxLogSize != "" {
+		if r.maxLogSize, err = ParseSize(self.MaxLogSize); err != nil || r.maxLogSize <= 0 {
+			return nil, fmt.Errorf("Rule %s has an invalid max_log_size: %s",
+				r.NodeID(), self.MaxLogSize)
+		}
+	}
+	if self.MaxOutputSize != "" {
+		if r.maxOutputSize, err = ParseSize(self.MaxOutputSize); err != nil || r.maxOutputSize <= 0 {
+			return nil, fmt.Errorf("Rule %s has an invalid max_output_size: %s",
+				r.NodeID(), self.MaxOutputSize)
+		}
+	}
+	if r.retry, err = NewRetryPolicy(self.Retries); err != nil {
+		return nil, fmt.Errorf("Rule %s has an %s", r.NodeID(), err)
+	}
+	if r.retry.Count > 0 && r.service {
+		return nil, fmt.Errorf("Rule %s is a service and cannot be retried",
+			r.NodeID())
+	}
+
+	if r.secrets, err = parseSecretRefs(combineEnvironment(c.secrets, self.Secrets)); err != nil {
+		return nil, fmt.Errorf("Rule %s %s", r.NodeID(), err)
+	}
 
 	r.inProvider, err = c.Provider(self.Providers.Inputs)
 	if err != nil {
@@ -118,10 +231,33 @@ func NewRule(name string, c *Component, self *definitions.Rule) (*Rule, error) {
 		return nil, fmt.Errorf("Rule %s provider error: %s", r.NodeID(), err)
 	}
 
+	// A wrapper or shell set for the Rule replaces the one set for its
+	// Component
+	if len(r.wrapper) == 0 {
+		r.wrapper = c.wrapper
+	}
+	if r.shell == "" {
+		r.shell = c.shell
+	}
+	if len(r.shellOptions) == 0 {
+		r.shellOptions = c.shellOptions
+	}
+
 	variables := r.BaseEnvironment()
-	r.inputs = substituteVarsSlice(r.inputs, variables)
-	r.ignore = substituteVarsSlice(r.ignore, variables)
-	r.outputs = substituteVarsSlice(r.outputs, variables)
+	for _, field := range []*[]string{&r.inputs, &r.ignore, &r.cacheIgnore, &r.outputs, &r.wrapper, &r.shellOptions} {
+		if *field, err = expandVarsSlice(r, *field, variables); err != nil {
+			return nil, fmt.Errorf("Rule %s %s", r.NodeID(), err)
+		}
+	}
+	if r.mutex, err = expandVars(r, r.mutex, variables); err != nil {
+		return nil, fmt.Errorf("Rule %s %s", r.NodeID(), err)
+	}
+	// Plain variable references in commands are left to the shell
+	for _, cmd := range r.commands {
+		if cmd.Argument, err = expandTemplateCalls(r, cmd.Argument, variables); err != nil {
+			return nil, fmt.Errorf("Rule %s %s", r.NodeID(), err)
+		}
+	}
 	r.when = Condition{
 		ResourceExists:  self.When.ResourceExists,
 		DirectoryExists: self.When.DirectoryExists,
@@ -130,6 +266,7 @@ func NewRule(name string, c *Component, self *definitions.Rule) (*Rule, error) {
 			WithOutput:    self.When.ScriptSucceeds.WithOutput,
 			SuppressError: self.When.ScriptSucceeds.SuppressError,
 		},
+		ChangedSince: self.When.ChangedSince,
 	}
 	r.unless = Condition{
 		ResourceExists:  self.Unless.ResourceExists,
@@ -139,6 +276,7 @@ func NewRule(name string, c *Component, self *definitions.Rule) (*Rule, error) {
 			WithOutput:    self.Unless.ScriptSucceeds.WithOutput,
 			SuppressError: self.Unless.ScriptSucceeds.SuppressError,
 		},
+		ChangedSince: self.Unless.ChangedSince,
 	}
 	return r, nil
 }
@@ -148,7 +286,7 @@ func NewRule(name string, c *Component, self *definitions.Rule) (*Rule, error) {
 func (r *Rule) resolveDeps() error {
 	for _, dep := range r.requires {
 		// A Rule cannot depend on itself
-		if r.Component().Name() == dep.Component && r.Name() == dep.Rule {
+		if r.Component().Name() == dep.Component && r.BaseName() == dep.Rule {
 			return fmt.Errorf("invalid dep - self reference: %s.%s",
 				dep.Component, dep.Rule)
 		}
@@ -159,15 +297,32 @@ func (r *Rule) resolveDeps() error {
 			if err != nil {
 				return err
 			}
+			// Exports produced by a rule are consumed as a dependency on
+			// that rule, which brings in its outputs instead of sources
+			exportRule, err := export.Rule()
+			if err != nil {
+				return fmt.Errorf("invalid dep in %s - %s", r.NodeID(), err)
+			}
+			if exportRule != nil {
+				r.resolvedDeps = append(r.resolvedDeps, exportRule)
+				continue
+			}
 			r.resolvedImports = append(r.resolvedImports, export)
 			continue
 		}
-		// Otherwise, this dependency is on the output of another Rule
-		depRule, err := r.resolveDep(dep)
+		// Otherwise, this dependency is on the output of other Rules
+		depRules, err := r.resolveDep(dep)
 		if err != nil {
 			return err
 		}
-		r.resolvedDeps = append(r.resolvedDeps, depRule)
+		r.resolvedDeps = append(r.resolvedDeps, depRules...)
+		for _, depRule := range depRules {
+			if len(dep.Outputs) > 0 {
+				if err := r.streamOutputs(depRule, dep.Outputs); err != nil {
+					return err
+				}
+			}
+		}
 		// Currently it is allowed to pull in transitive dependencies that
 		// are one step removed as dependencies of this Rule, if desired.
 		// This can be helpful when the immediate dependency doesn't actually
@@ -177,18 +332,40 @@ func (r *Rule) resolveDeps() error {
 				dep.Component, dep.Rule)
 		} else if dep.Recurse == 1 {
 			// Pull in transitive dependencies that are one step removed
-			for _, rDep := range depRule.requires {
-				rDepRule, err := r.resolveDep(rDep)
-				if err != nil {
-					return err
+			for _, depRule := range depRules {
+				for _, rDep := range depRule.requires {
+					rDepRules, err := r.resolveDep(rDep)
+					if err != nil {
+						return err
+					}
+					r.resolvedDeps = append(r.resolvedDeps, rDepRules...)
 				}
-				r.resolvedDeps = append(r.resolvedDeps, rDepRule)
 			}
 		}
 	}
 	return nil
 }
 
+// streamOutputs records the outputs of a dependency that this Rule needs,
+// which must be declared by the dependency
+func (r *Rule) streamOutputs(dep *Rule, names []string) error {
+	declared := map[string]bool{}
+	for _, out := range dep.outputs {
+		declared[out] = true
+	}
+	for _, name := range names {
+		if !declared[name] {
+			return fmt.Errorf("invalid dep in %s - %s has no output %s",
+				r.NodeID(), dep.NodeID(), name)
+		}
+	}
+	if r.streamed == nil {
+		r.streamed = map[*Rule][]string{}
+	}
+	r.streamed[dep] = names
+	return nil
+}
+
 // Accepts an export Dependency and returns the Export to which it refers.
 func (r *Rule) resolveExport(dep *Dependency) (*Export, error) {
 	if dep.Component == "" {
@@ -210,7 +387,7 @@ func (r *Rule) resolveExport(dep *Dependency) (*Export, error) {
 // Accepts a Dependency and returns the Rule to which it refers.
 // If the Dependency component name is blank, the component is assumed
 // to be the one containing this Rule.
-func (r *Rule) resolveDep(dep *Dependency) (*Rule, error) {
+func (r *Rule) resolveDep(dep *Dependency) ([]*Rule, error) {
 
 	var depCompName string
 	if dep.Component == "" {
@@ -219,18 +396,38 @@ func (r *Rule) resolveDep(dep *Dependency) (*Rule, error) {
 		depCompName = dep.Component
 	}
 
-	depRule, found := r.Component().Project().Rule(depCompName, dep.Rule)
-	if !found {
+	p := r.Component().Project()
+	if depRule, found := p.Rule(depCompName, dep.Rule); found {
+		return []*Rule{depRule}, nil
+	}
+
+	// A dependency on a matrix Rule is on its instances with matrix values
+	// matching this Rule's, which is all of them unless they share variables
+	var depRules []*Rule
+	if c := p.Components().WithName(depCompName).First(); c != nil {
+		for _, instance := range c.MatrixRules(dep.Rule) {
+			if instance != r && r.matchesMatrix(instance) {
+				depRules = append(depRules, instance)
+			}
+		}
+	}
+	if len(depRules) == 0 {
 		return nil, fmt.Errorf("invalid dep - rule not found: %s.%s",
 			depCompName, dep.Rule)
 	}
-	return depRule, nil
+	return depRules, nil
 }
 
-// BaseEnvironment returns Rule environment variables that are known upfront
+// BaseEnvironment returns Rule environment variables that are known upfront.
+// Variables set for the Rule override those set for its Component, and build
+// parameters override both.
 func (r *Rule) BaseEnvironment() map[string]string {
 	c := r.Component()
-	return combineEnvironment(c.Environment(), map[string]string{
+	var parameters map[string]string
+	if p := c.Project(); p != nil {
+		parameters = p.parameters
+	}
+	return combineEnvironment(c.Environment(), r.env, parameters, r.matrix, platformEnvironment(r.Platform()), map[string]string{
 		"COMPONENT": c.Name(),
 		"NAME":      c.Name(),
 		"KIND":      c.Kind(),
@@ -307,6 +504,11 @@ func (r *Rule) NodeID() string {
 	return fmt.Sprintf("%s.%s", r.Component().Name(), r.Name())
 }
 
+// Description of the Rule, if one was given
+func (r *Rule) Description() string {
+	return r.description
+}
+
 // Image returns the Docker image used to build this Rule, if configured
 func (r *Rule) Image() string {
 	return r.Component().dockerImage
@@ -317,12 +519,133 @@ func (r *Rule) IsNative() bool {
 	return r.native || r.Image() == ""
 }
 
+// IsService returns true if this Rule starts a long-lived process, such as a
+// development server, that is left running rather than run to completion
+func (r *Rule) IsService() bool {
+	return r.service
+}
+
+// Ports returns the names of variables set to free ports when the Rule runs
+// as a service
+func (r *Rule) Ports() []string {
+	return r.ports
+}
+
+// HealthCheck returns the check used to determine when a service is ready
+func (r *Rule) HealthCheck() HealthCheck {
+	return r.healthCheck
+}
+
+// RestartPolicy returns when a service should be restarted after it exits
+func (r *Rule) RestartPolicy() RestartPolicy {
+	return r.restart
+}
+
+// Network returns the network the Rule commands run with. An empty string
+// indicates the default network.
+func (r *Rule) Network() string {
+	return r.network
+}
+
+// Mutex returns the name of the mutex the Rule holds while it runs, or an
+// empty string if it may run alongside any other Rule
+func (r *Rule) Mutex() string {
+	return r.mutex
+}
+
+// DockerNetwork returns the network the Rule's containers are attached to,
+// e.g. host, or an empty string for the default network. The network from
+// Network takes precedence.
+func (r *Rule) DockerNetwork() string {
+	return r.dockerNetwork
+}
+
+// Wrapper returns the command that runs the Rule's commands, which are
+// given to it as trailing arguments, or nil if they run directly
+func (r *Rule) Wrapper() []string {
+	return r.wrapper
+}
+
+// Shell returns the shell that runs the Rule's commands, or an empty string
+// if they run with the default shell
+func (r *Rule) Shell() string {
+	return r.shell
+}
+
+// ShellOptions returns the arguments the shell is run with, or nil if the
+// defaults of the shell are used
+func (r *Rule) ShellOptions() []string {
+	return r.shellOptions
+}
+
+// Ulimits returns resource limits applied to the Rule commands
+func (r *Rule) Ulimits() []exec.Ulimit {
+	return r.ulimits
+}
+
+// Timeout returns the maximum time the Rule's commands may run, or zero if
+// there is no limit
+func (r *Rule) Timeout() time.Duration {
+	return r.timeout
+}
+
+// MaxLogSize returns the number of bytes of output the Rule's commands may
+// print before the rest is discarded, or zero if there is no limit
+func (r *Rule) MaxLogSize() int64 {
+	return r.maxLogSize
+}
+
+// MaxOutputSize returns the largest size in bytes of an output that may be
+// stored in the cache, or zero if there is no limit
+func (r *Rule) MaxOutputSize() int64 {
+	return r.maxOutputSize
+}
+
+// RetryPolicy returns the policy for retrying the Rule when it fails
+func (r *Rule) RetryPolicy() RetryPolicy {
+	return r.retry
+}
+
+// AllowFailure returns true if the build continues when the Rule fails, in
+// which case its failure is reported as a warning
+func (r *Rule) AllowFailure() bool {
+	return r.allowFailure
+}
+
 // Dependencies of this rule. In order for this to Rule to run, its
 // Dependencies should first be run.
 func (r *Rule) Dependencies() []*Rule {
 	return r.resolvedDeps
 }
 
+// StreamedOutputs returns the outputs of a dependency that this Rule needs.
+// When pipelined scheduling is enabled this Rule may start once they exist,
+// before the dependency finishes. False is returned if this Rule must wait
+// for the dependency to finish.
+func (r *Rule) StreamedOutputs(dep *Rule) (Resources, bool) {
+	names, ok := r.streamed[dep]
+	if !ok {
+		return nil, false
+	}
+	needed := map[string]bool{}
+	for _, name := range names {
+		needed[name] = true
+	}
+	var outputs Resources
+	for i, out := range dep.Outputs() {
+		if needed[dep.outputs[i]] {
+			outputs = append(outputs, out)
+		}
+	}
+	return outputs, true
+}
+
+// ProviderNames returns the names of the Providers of the Rule's inputs and
+// outputs
+func (r *Rule) ProviderNames() (inputs, outputs string) {
+	return r.inProvider.Name(), r.outProvider.Name()
+}
+
 // HasOutputs returns true if this Rule produces one or more output Resources
 func (r *Rule) HasOutputs() bool {
 	return len(r.outputs) > 0
@@ -337,17 +660,25 @@ func (r *Rule) Outputs() (outputs Resources) {
 		prefix = r.ArtifactsDir()
 	}
 	for _, out := range r.outputs {
+		if IsURI(out) {
+			outputs = append(outputs, r.outProvider.New(out))
+			continue
+		}
 		outputs = append(outputs, r.outProvider.New(path.Join(prefix, out)))
 	}
 	return
 }
 
 // ArtifactsDir returns the absolute path to the directory used for artifacts
-// produced by this Rule.
+// produced by this Rule. Artifacts built for a target platform are kept in a
+// subdirectory named after it, e.g. "artifacts/linux_amd64".
 func (r *Rule) ArtifactsDir() string {
 	if r.local {
 		return r.Component().Directory()
 	}
+	if platform := r.Platform(); platform != "" {
+		return path.Join(r.Project().ArtifactsDir(), PlatformDir(platform))
+	}
 	return r.Project().ArtifactsDir()
 }
 
@@ -403,12 +734,17 @@ func (r *Rule) Inputs() (Resources, error) {
 		}
 	}
 
-	// Find input resources
-	inputs, err := matchResources(r.Component(), r.inProvider, r.inputs)
+	// Find input resources, less those excluded by negated patterns
+	patterns, negated := splitInputPatterns(r.inputs)
+	inputs, err := matchResources(r.Component(), r.inProvider, patterns)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find input: %s", err)
 	}
-	add(inputs)
+	excluded, err := matchResources(r.Component(), r.inProvider, negated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find input: %s", err)
+	}
+	add(inputs.Without(excluded))
 
 	// Exclude ignored resources
 	ignored, err := matchResources(r.Component(), r.inProvider, r.ignore)
@@ -436,9 +772,49 @@ func (r *Rule) Inputs() (Resources, error) {
 	return result, nil
 }
 
+// KeyInputs returns the input Resources that contribute to the Rule's cache
+// key. These are its inputs less those matched by cache_ignore_inputs, which
+// must be present when the Rule runs but don't invalidate its cached outputs.
+func (r *Rule) KeyInputs() (Resources, error) {
+	inputs, err := r.Inputs()
+	if err != nil {
+		return nil, err
+	}
+	if len(r.cacheIgnore) == 0 {
+		return inputs, nil
+	}
+	ignored, err := matchResources(r.Component(), r.inProvider, r.cacheIgnore)
+	if err != nil {
+		return nil, fmt.Errorf("failed cache_ignore_inputs: %s", err)
+	}
+	return inputs.Without(ignored), nil
+}
+
+// splitInputPatterns separates input patterns from negated patterns, which
+// begin with "!" and exclude files matched by the other patterns. The "!"
+// is removed from the negated patterns.
+func splitInputPatterns(inputs []string) (patterns, negated []string) {
+	for _, pattern := range inputs {
+		if strings.HasPrefix(pattern, "!") {
+			negated = append(negated, strings.TrimPrefix(pattern, "!"))
+		} else {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return
+}
+
 func matchResources(c *Component, p Provider, patterns []string) (result Resources, err error) {
 	for _, pat := range patterns {
-		matches, err := p.Match(path.Join(c.RelPath(), pat))
+		if submodule := submoduleInput(c, pat); submodule != nil {
+			result = append(result, submodule)
+			continue
+		}
+		// URIs identify Resources outside the project, e.g. in S3
+		if !IsURI(pat) {
+			pat = path.Join(c.RelPath(), pat)
+		}
+		matches, err := p.Match(pat)
 		if err != nil {
 			return nil, err
 		}
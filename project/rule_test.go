@@ -14,10 +14,13 @@
 package project
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 	"testing"
 
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -204,3 +207,328 @@ func TestRuleOutputs(t *testing.T) {
 	// absOuts := build.OutputsAbs()
 	// assert.Equal(t, []string{path.Join(dir, "artifacts", "bar")}, absOuts)
 }
+
+func TestRuleExportFromRule(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "proto", `
+name: proto
+rules:
+  codegen:
+    inputs:
+     - api.proto
+    outputs:
+     - api.pb.go
+    command: touch ${ARTIFACT}
+exports:
+  generated:
+    from_rule: codegen
+`, map[string]string{
+		"api.proto": "syntax = \"proto3\";",
+	})
+	testComponent(dir, "svc", `
+name: svc
+rules:
+  build:
+    requires:
+     - component: proto
+       export: generated
+    inputs:
+     - main.go
+    outputs:
+     - svc
+    command: go build
+`, map[string]string{
+		"main.go": testGoMain,
+	})
+
+	p, err := New(dir)
+	require.Nil(t, err)
+
+	codegen, found := p.Rule("proto", "codegen")
+	require.True(t, found)
+	build, found := p.Rule("svc", "build")
+	require.True(t, found)
+
+	// The producing rule becomes a dependency of the consumer
+	assert.Equal(t, []*Rule{codegen}, build.Dependencies())
+
+	// The generated outputs are consumed rather than raw sources
+	inputs, err := build.Inputs()
+	require.Nil(t, err)
+	assert.Equal(t, []string{path.Join(dir, "svc", "main.go")}, inputs.Paths())
+	assert.Equal(t, []string{path.Join(dir, "artifacts", "api.pb.go")},
+		build.DependencyOutputs().Paths())
+
+	export, found := p.Export("proto", "generated")
+	require.True(t, found)
+	exported, err := export.Resolve()
+	require.Nil(t, err)
+	assert.Equal(t, []string{path.Join(dir, "artifacts", "api.pb.go")}, exported.Paths())
+}
+
+func TestRuleExportFromRuleMissing(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "proto", `
+name: proto
+exports:
+  generated:
+    from_rule: codegen
+`, nil)
+	testComponent(dir, "svc", `
+name: svc
+rules:
+  build:
+    requires:
+     - component: proto
+       export: generated
+`, nil)
+
+	_, err := New(dir)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "export rule not found: proto.codegen")
+}
+
+func TestRuleIsolation(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "gen", `
+name: gen
+rules:
+  generate:
+    network: none
+    ulimits:
+      nofile: 1024
+      nproc: "256:512"
+    command: go generate
+`, nil)
+	p, err := New(dir)
+	require.Nil(t, err)
+	rule, found := p.Rule("gen", "generate")
+	require.True(t, found)
+	assert.Equal(t, exec.NetworkNone, rule.Network())
+	assert.Equal(t, []exec.Ulimit{
+		{Name: "nofile", Soft: 1024, Hard: 1024},
+		{Name: "nproc", Soft: 256, Hard: 512},
+	}, rule.Ulimits())
+
+	testComponent(dir, "gen", `
+name: gen
+rules:
+  generate:
+    network: host
+    command: go generate
+`, nil)
+	_, err = New(dir)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid network: host")
+}
+
+func TestRuleDockerNetwork(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "api", `
+name: api
+docker:
+  image: golang
+  network: integration
+rules:
+  build:
+    command: go build
+  test:
+    docker:
+      network: host
+    command: go test
+`, nil)
+	p, err := New(dir)
+	require.Nil(t, err)
+	build, found := p.Rule("api", "build")
+	require.True(t, found)
+	assert.Equal(t, "integration", build.DockerNetwork())
+	test, found := p.Rule("api", "test")
+	require.True(t, found)
+	assert.Equal(t, "host", test.DockerNetwork())
+
+	testComponent(dir, "api", `
+name: api
+docker:
+  image: golang
+  network: "my network"
+rules:
+  build:
+    command: go build
+`, nil)
+	_, err = New(dir)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "invalid Docker network: my network")
+}
+
+func TestRuleInputNegation(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	cDir := path.Join(dir, "app")
+	require.Nil(t, os.MkdirAll(path.Join(cDir, "pkg"), 0755))
+	for _, name := range []string{"main.go", "main_test.go", "pkg/util.go", "pkg/util_test.go"} {
+		require.Nil(t, ioutil.WriteFile(path.Join(cDir, name), []byte(name), 0644))
+	}
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(cDir, "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Inputs:  []string{"**/*.go", "!**/*_test.go"},
+					Outputs: []string{"app"},
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	r := p.Components().First().MustRule("build")
+
+	inputs, err := r.Inputs()
+	require.Nil(t, err)
+	relInputs, err := inputs.RelativePaths(cDir)
+	require.Nil(t, err)
+	require.Equal(t, []string{"main.go", "pkg/util.go"}, relInputs)
+}
+
+func TestRuleKeyInputs(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	cDir := path.Join(dir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	for _, name := range []string{"main.go", "version.go"} {
+		require.Nil(t, ioutil.WriteFile(path.Join(cDir, name), []byte(name), 0644))
+	}
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(cDir, "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Inputs:            []string{"*.go"},
+					CacheIgnoreInputs: []string{"version.go"},
+					Outputs:           []string{"app"},
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	r := p.Components().First().MustRule("build")
+
+	// The ignored file is still an input but is left out of the key
+	inputs, err := r.Inputs()
+	require.Nil(t, err)
+	relInputs, err := inputs.RelativePaths(cDir)
+	require.Nil(t, err)
+	require.Equal(t, []string{"main.go", "version.go"}, relInputs)
+
+	keyInputs, err := r.KeyInputs()
+	require.Nil(t, err)
+	relInputs, err = keyInputs.RelativePaths(cDir)
+	require.Nil(t, err)
+	require.Equal(t, []string{"main.go"}, relInputs)
+}
+
+// uriProvider records the patterns it is asked to match
+type uriProvider struct {
+	patterns []string
+}
+
+func (p *uriProvider) Init(opts map[string]interface{}) error { return nil }
+
+func (p *uriProvider) Name() string { return "uri" }
+
+func (p *uriProvider) New(path string) Resource { return NewFile(path) }
+
+func (p *uriProvider) Match(pattern string) (Resources, error) {
+	p.patterns = append(p.patterns, pattern)
+	return Resources{p.New(pattern)}, nil
+}
+
+func TestRuleEnvironment(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.MkdirAll(path.Join(dir, "app"), 0755))
+
+	defs := []*definitions.Component{
+		{
+			Name:        "app",
+			Path:        path.Join(dir, "app", "component.yaml"),
+			Environment: map[string]string{"GOOS": "linux", "CGO_ENABLED": "0"},
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Environment: map[string]string{"CGO_ENABLED": "1", "TAGS": "sqlite"},
+				},
+				"test": {},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	c := p.Components().First()
+
+	// Rule variables are merged over those of the component
+	env := c.MustRule("build").BaseEnvironment()
+	require.Equal(t, "linux", env["GOOS"])
+	require.Equal(t, "1", env["CGO_ENABLED"])
+	require.Equal(t, "sqlite", env["TAGS"])
+
+	env = c.MustRule("test").BaseEnvironment()
+	require.Equal(t, "0", env["CGO_ENABLED"])
+	_, found := env["TAGS"]
+	require.False(t, found)
+}
+
+func TestRuleURIResources(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	provider := &uriProvider{}
+	defs := []*definitions.Component{
+		{
+			Name: "pipeline",
+			Path: path.Join(dir, "pipeline", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"transform": {
+					Inputs:    []string{"s3://data/raw/*.csv"},
+					Outputs:   []string{"s3://data/clean/all.csv"},
+					Providers: definitions.Providers{Inputs: "uri", Outputs: "uri"},
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{
+		Root:          dir,
+		ComponentDefs: defs,
+		Providers:     []Provider{provider},
+	})
+	require.Nil(t, err)
+	r := p.Components().First().MustRule("transform")
+
+	// URIs are not joined with the component or artifacts directories
+	_, err = r.Inputs()
+	require.Nil(t, err)
+	require.Equal(t, []string{"s3://data/raw/*.csv"}, provider.patterns)
+	require.Equal(t, []string{"s3://data/clean/all.csv"}, r.Outputs().Paths())
+}
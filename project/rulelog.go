@@ -0,0 +1,59 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// RuleLogs is middleware that writes the output of each Rule to its own file
+// as well as to the console, so that the log of a Rule can be found after
+// the run without searching through the interleaved output of others. The
+// path of the log is printed when a Rule fails.
+type RuleLogs struct {
+	dir string
+}
+
+// NewRuleLogs returns RuleLogs that write to files named after the node ID
+// of each Rule in the given directory
+func NewRuleLogs(dir string) *RuleLogs {
+	return &RuleLogs{dir: dir}
+}
+
+// Middleware records the output of Rules run by the wrapped Runner
+func (l *RuleLogs) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		f, err := createRuleLog(l.dir, r)
+		if err != nil {
+			return Error, err
+		}
+		defer f.Close()
+
+		console := opts.Output
+		opts.Output = teeWriter(opts.Output, f)
+		opts.DebugOutput = teeWriter(opts.DebugOutput, f)
+		opts.LogFile = f.Name()
+
+		code, err := runner.Run(ctx, r, opts)
+		if err != nil {
+			if console == nil {
+				console = os.Stdout
+			}
+			fmt.Fprintln(console, "rule:", Bright(r.NodeID()), "log:", f.Name())
+		}
+		return code, err
+	})
+}
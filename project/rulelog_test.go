@@ -0,0 +1,68 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleLogs(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "zim-logs-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	logs := NewRuleLogs(filepath.Join(dir, "build1"))
+	runner := logs.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			fmt.Fprintln(opts.Output, "running", r.Name())
+			fmt.Fprintln(opts.DebugOutput, "debug", r.Name())
+			if r.Name() == "test" {
+				return ExecError, errors.New("failed")
+			}
+			return OK, nil
+		}))
+
+	c := &Component{name: "app"}
+	var console bytes.Buffer
+	opts := RunOpts{Output: &console, DebugOutput: &console}
+	_, err = runner.Run(context.Background(), &Rule{component: c, name: "build"}, opts)
+	require.Nil(t, err)
+	require.Equal(t, "running build\ndebug build\n", console.String())
+
+	console.Reset()
+	_, err = runner.Run(context.Background(), &Rule{component: c, name: "test"}, opts)
+	require.NotNil(t, err)
+
+	// Each rule has its own log and the path is shown on failure
+	buildLog := filepath.Join(dir, "build1", "app.build.log")
+	data, err := ioutil.ReadFile(buildLog)
+	require.Nil(t, err)
+	require.Equal(t, "running build\ndebug build\n", string(data))
+
+	testLog := filepath.Join(dir, "build1", "app.test.log")
+	data, err = ioutil.ReadFile(testLog)
+	require.Nil(t, err)
+	require.Equal(t, "running test\ndebug test\n", string(data))
+	require.Contains(t, console.String(), "log: "+testLog+"\n")
+}
@@ -17,7 +17,9 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/fugue/zim/exec"
 	"github.com/hashicorp/go-multierror"
@@ -30,6 +32,22 @@ type RunOpts struct {
 	Output      io.Writer
 	DebugOutput io.Writer
 	Debug       bool
+	Usage       *exec.Usage
+	Images      *exec.Images
+	Environment map[string]string
+
+	// DryRun evaluates conditions and the cache without running commands
+	DryRun bool
+
+	// ParentBuildID identifies the parent of the build, if any
+	ParentBuildID string
+
+	// LogFile is the file the Rule's output is also written to, if any
+	LogFile string
+
+	// Attempt is the attempt at running the Rule, counting from one, when
+	// it is retried. Zero for a Rule's first run.
+	Attempt int
 }
 
 // Runner is an interface used to run Rules. Different implementations may
@@ -49,8 +67,48 @@ func (f RunnerFunc) Run(ctx context.Context, r *Rule, opts RunOpts) (Code, error
 	return f(ctx, r, opts)
 }
 
-// StandardRunner defines good default behavior for running a Rule
-type StandardRunner struct{}
+// StandardRunner defines good default behavior for running a Rule. Results
+// of condition scripts run in the same environment are reused by the runner.
+type StandardRunner struct {
+	mutex      sync.Mutex
+	conditions *conditionCache
+
+	// Baselines used by the coverage built-in, if set
+	Baselines BaselineStore
+
+	// MinFreeSpace is the number of bytes that must be free in the
+	// artifacts directory before a Rule's commands run
+	MinFreeSpace int64
+
+	// Secrets resolves the secrets of Rules, if set
+	Secrets SecretResolver
+
+	// Strict checks that each command only writes where it is expected
+	// to, if set
+	Strict *StrictMode
+
+	// ContainerRuntime runs the docker_build and docker_push commands,
+	// e.g. docker or podman. It is detected if empty.
+	ContainerRuntime string
+}
+
+// containerRuntime returns the container runtime that runs image commands
+func (runner *StandardRunner) containerRuntime() string {
+	if runner.ContainerRuntime == "" {
+		return exec.DetectContainerRuntime()
+	}
+	return runner.ContainerRuntime
+}
+
+// conditionCache returns the cache of condition results for this runner
+func (runner *StandardRunner) conditionCache() *conditionCache {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	if runner.conditions == nil {
+		runner.conditions = newConditionCache()
+	}
+	return runner.conditions
+}
 
 // Run a rule with the provided executor and other options
 func (runner *StandardRunner) Run(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
@@ -73,24 +131,65 @@ func (runner *StandardRunner) Run(ctx context.Context, r *Rule, opts RunOpts) (C
 		primaryExecutor = opts.Executor
 	}
 
+	// Native commands run within the Component's Nix environment or with
+	// its pinned tool versions active, if either is configured
+	if wrapper := r.Component().nativeWrapper(); wrapper != nil && !primaryExecutor.UsesDocker() {
+		primaryExecutor = exec.NewWrappedBashExecutor(wrapper...)
+	}
+
 	// Generate the bash environment variables to be available to the execution
 	bashEnv, err := r.Environment()
 	if err != nil {
 		return Error, fmt.Errorf("Environment error %s: %s", r.NodeID(), err)
 	}
+	for k, v := range buildEnvironment(opts) {
+		bashEnv[k] = v
+	}
+	for k, v := range opts.Environment {
+		bashEnv[k] = v
+	}
 	if err := runner.setArtifactVariables(r, bashExecutor, bashEnv); err != nil {
 		return Error, err
 	}
 
 	// Evaluate rule conditions which could lead to rule execution being skipped.
 	// Any scripting done to check the condition will be via the bash executor.
-	conditionsMet, err := CheckConditions(ctx, r, opts, bashExecutor, bashEnv)
+	conditionsMet, reason, err := explainConditions(ctx, r, opts, bashExecutor,
+		bashEnv, runner.conditionCache())
 	if err != nil {
 		return Error, fmt.Errorf("error checking conditions on rule %s: %s", r.NodeID(), err)
 	}
 	if !conditionsMet {
+		if opts.Output != nil {
+			fmt.Fprintln(opts.Output, "skip:", Yellow(reason))
+		}
 		return Skipped, nil
 	}
+	if opts.DryRun {
+		return OK, nil
+	}
+
+	// Fail before writing outputs that may not fit
+	if err := CheckFreeSpace(r.ArtifactsDir(), runner.MinFreeSpace); err != nil {
+		return Error, fmt.Errorf("Rule %s cannot run: %s", r.NodeID(), err)
+	}
+
+	// Secrets are resolved each time the Rule runs and are only available to
+	// its commands. They are not part of the Rule's key and are redacted
+	// from its output.
+	secrets, err := resolveSecrets(ctx, r, runner.Secrets)
+	if err != nil {
+		return Error, err
+	}
+	for k, v := range secrets {
+		bashEnv[k] = v
+	}
+	opts.Output = redactSecrets(opts.Output, secrets)
+	opts.DebugOutput = redactSecrets(opts.DebugOutput, secrets)
+
+	// Runaway commands may print far more than is useful, so the output
+	// of all the Rule's commands is truncated at its limit
+	opts.Output = limitOutput(opts.Output, r.MaxLogSize())
 
 	// Generate a second set of environment variables for the primary executor.
 	// This supports the primary executor being dockerized, in which case the
@@ -100,14 +199,34 @@ func (runner *StandardRunner) Run(ctx context.Context, r *Rule, opts RunOpts) (C
 		return Error, err
 	}
 
+	// Limit the time the commands may run. Canceling the context kills the
+	// command processes and any containers they run in.
+	parentCtx := ctx
+	if r.Timeout() > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout())
+		defer cancel()
+	}
+
+	if runner.Strict != nil {
+		runner.Strict.begin(r)
+		defer runner.Strict.end(r)
+	}
+
 	// Execute each of the rule's commands
 	for i, cmd := range r.Commands() {
 		env := bashEnv
 		exc := bashExecutor
+		var wrapper, shellOptions []string
+		var shell string
 		if cmd.Kind == "run" {
-			// Run commands use the primary environment and executor
+			// Run commands use the primary environment and executor, and
+			// run within the Rule's wrapper command and shell if it has them
 			env = primaryEnv
 			exc = primaryExecutor
+			wrapper = r.Wrapper()
+			shell = r.Shell()
+			shellOptions = r.ShellOptions()
 		}
 		execOpts := exec.ExecOpts{
 			WorkingDirectory: r.Component().Directory(),
@@ -118,6 +237,25 @@ func (runner *StandardRunner) Run(ctx context.Context, r *Rule, opts RunOpts) (C
 			Cmdout:           opts.DebugOutput,
 			Image:            r.Image(),
 			Name:             fmt.Sprintf("%s.%d", r.NodeID(), i),
+			Usage:            opts.Usage,
+			Images:           opts.Images,
+			Network:          r.Network(),
+			DockerNetwork:    r.DockerNetwork(),
+			Ulimits:          r.Ulimits(),
+			Platform:         r.Platform(),
+			Wrapper:          wrapper,
+			Shell:            shell,
+			ShellOptions:     shellOptions,
+			CacheVolumes:     r.Component().CacheVolumes(),
+			Attempt:          opts.Attempt,
+		}
+		// In strict mode the files in the project are compared before and
+		// after the command
+		var before map[string]fileState
+		if runner.Strict != nil {
+			if before, err = runner.Strict.snapshot(); err != nil {
+				return Error, err
+			}
 		}
 		// Run the command
 		var execError error
@@ -142,14 +280,29 @@ func (runner *StandardRunner) Run(ctx context.Context, r *Rule, opts RunOpts) (C
 			execError = runner.execMoveCommand(ctx, r, exc, execOpts, cmd)
 		case "copy":
 			execError = runner.execCopyCommand(ctx, r, exc, execOpts, cmd)
+		case "coverage":
+			execError = runner.execCoverageCommand(ctx, r, exc, execOpts, cmd)
+		case "docker_build":
+			execError = runner.execDockerBuildCommand(ctx, r, exc, execOpts, cmd)
+		case "docker_push":
+			execError = runner.execDockerPushCommand(ctx, r, exc, execOpts, cmd)
 		default:
 			return Error, fmt.Errorf("unknown command kind in %s: %s",
 				r.NodeID(), cmd.Kind)
 		}
 		if execError != nil {
+			if ctx.Err() == context.DeadlineExceeded && parentCtx.Err() == nil {
+				return Timeout, fmt.Errorf("Rule %s timed out after %s",
+					r.NodeID(), r.Timeout())
+			}
 			return ExecError, fmt.Errorf("error running rule command. Rule: %s. Command: %+v. Error: %s",
 				r.NodeID(), cmd, execError)
 		}
+		if runner.Strict != nil {
+			if err := runner.Strict.check(r, before); err != nil {
+				return ExecError, err
+			}
+		}
 	}
 
 	// At this point the commands were all successful. If the rule defines
@@ -199,6 +352,8 @@ func (runner *StandardRunner) setArtifactVariables(
 				return err
 			}
 			env["ARTIFACT"] = artifactPath
+		} else {
+			env["ARTIFACT"] = firstRuleOutput.Path()
 		}
 	}
 	return nil
@@ -221,8 +376,9 @@ func (runner *StandardRunner) execRunCommand(
 }
 
 // Creates a zip file with the specified contents. By default, the options
-// `-qrFS` are used. The `cd` attribute may be used to change into the specified
-// directory before running the command.
+// `-qrFS` are used and the archive is written natively. The `cd` attribute
+// may be used to change into the specified directory before running the
+// command.
 func (runner *StandardRunner) execZipCommand(
 	ctx context.Context,
 	r *Rule,
@@ -230,13 +386,16 @@ func (runner *StandardRunner) execZipCommand(
 	execOpts exec.ExecOpts,
 	cmd *Command,
 ) error {
-	opts := getCommandAttr(cmd, "options", "-qrFS")
+	opts := getCommandAttr(cmd, "options", defaultZipOptions)
 	input := getCommandAttr(cmd, "input", ".")
 	output := getCommandAttr(cmd, "output", "")
 	dir := getCommandAttr(cmd, "cd", "")
 	if output == "" {
 		return fmt.Errorf("zip command has no output specified")
 	}
+	if opts == defaultZipOptions {
+		return newBuiltin(execOpts).zip(dir, input, output)
+	}
 	script := fmt.Sprintf("zip %s %s %s", opts, output, input)
 	if dir != "" {
 		script = fmt.Sprintf("cd %s && %s", dir, script)
@@ -245,7 +404,8 @@ func (runner *StandardRunner) execZipCommand(
 	return executor.Execute(ctx, execOpts)
 }
 
-// Unzips a zip archive. By default, the options `-qo` are used.
+// Unzips a zip archive. By default, the options `-qo` are used and the
+// archive is extracted natively.
 func (runner *StandardRunner) execUnzipCommand(
 	ctx context.Context,
 	r *Rule,
@@ -253,12 +413,15 @@ func (runner *StandardRunner) execUnzipCommand(
 	execOpts exec.ExecOpts,
 	cmd *Command,
 ) error {
-	opts := getCommandAttr(cmd, "options", "-qo")
+	opts := getCommandAttr(cmd, "options", defaultUnzipOptions)
 	input := getCommandAttr(cmd, "input", "")
 	output := getCommandAttr(cmd, "output", "")
 	if input == "" {
 		return fmt.Errorf("unzip command has no input specified")
 	}
+	if opts == defaultUnzipOptions {
+		return newBuiltin(execOpts).unzip(input, output)
+	}
 	script := fmt.Sprintf("unzip %s %s", opts, input)
 	if output != "" {
 		script = fmt.Sprintf("%s -d %s", script, output)
@@ -267,8 +430,8 @@ func (runner *StandardRunner) execUnzipCommand(
 	return executor.Execute(ctx, execOpts)
 }
 
-// Runs the `tar` command to create an archive using gzip if the default options
-// are used. Equivalent to `tar -czf $OUTPUT $INPUT`.
+// Creates a gzipped tar archive natively if the default options are used.
+// Equivalent to `tar -czf $OUTPUT $INPUT`. Otherwise the `tar` command is run.
 func (runner *StandardRunner) execArchiveCommand(
 	ctx context.Context,
 	r *Rule,
@@ -276,7 +439,7 @@ func (runner *StandardRunner) execArchiveCommand(
 	execOpts exec.ExecOpts,
 	cmd *Command,
 ) error {
-	opts := getCommandAttr(cmd, "options", "-czf")
+	opts := getCommandAttr(cmd, "options", defaultArchiveOptions)
 	input := getCommandAttr(cmd, "input", "")
 	output := getCommandAttr(cmd, "output", "")
 	if input == "" {
@@ -285,12 +448,16 @@ func (runner *StandardRunner) execArchiveCommand(
 	if output == "" {
 		return fmt.Errorf("archive command has no output specified")
 	}
+	if opts == defaultArchiveOptions {
+		return newBuiltin(execOpts).archive(input, output)
+	}
 	execOpts.Command = fmt.Sprintf("tar %s %s %s", opts, output, input)
 	return executor.Execute(ctx, execOpts)
 }
 
-// Runs the `tar` command to extract an archive.
-// Equivalent to `tar -xzf $OUTPUT $INPUT`.
+// Extracts a gzipped tar archive natively if the default options are used.
+// Equivalent to `tar -xzf $INPUT -C $OUTPUT`. Otherwise the `tar` command is
+// run.
 func (runner *StandardRunner) execUnarchiveCommand(
 	ctx context.Context,
 	r *Rule,
@@ -298,12 +465,15 @@ func (runner *StandardRunner) execUnarchiveCommand(
 	execOpts exec.ExecOpts,
 	cmd *Command,
 ) error {
-	opts := getCommandAttr(cmd, "options", "-xzf")
+	opts := getCommandAttr(cmd, "options", defaultUnarchiveOptions)
 	input := getCommandAttr(cmd, "input", "")
 	output := getCommandAttr(cmd, "output", "")
 	if input == "" {
 		return fmt.Errorf("archive command has no input specified")
 	}
+	if opts == defaultUnarchiveOptions {
+		return newBuiltin(execOpts).unarchive(input, output)
+	}
 	script := fmt.Sprintf("tar %s %s", opts, input)
 	if output != "" {
 		script = fmt.Sprintf("mkdir -p %s && %s -C %s", output, script, output)
@@ -324,8 +494,7 @@ func (runner *StandardRunner) execMkdirCommand(
 	if arg == "" {
 		return fmt.Errorf("mkdir command has no targets specified")
 	}
-	execOpts.Command = fmt.Sprintf("mkdir -p %s", arg)
-	return executor.Execute(ctx, execOpts)
+	return newBuiltin(execOpts).mkdir(arg)
 }
 
 // Ensures an empty directory with the given name exists. If the directory
@@ -345,8 +514,7 @@ func (runner *StandardRunner) execCleandirCommand(
 	if arg == "/" {
 		return fmt.Errorf("cleandir cannot run against /")
 	}
-	execOpts.Command = fmt.Sprintf("rm -rf %s && mkdir -p %s", arg, arg)
-	return executor.Execute(ctx, execOpts)
+	return newBuiltin(execOpts).cleandir(arg)
 }
 
 // Remove one or more files or directories. Equivalent to `rm -rf`.
@@ -361,11 +529,10 @@ func (runner *StandardRunner) execRemoveCommand(
 	if arg == "" {
 		return fmt.Errorf("remove command has no targets specified")
 	}
-	execOpts.Command = fmt.Sprintf("rm -rf %s", arg)
-	return executor.Execute(ctx, execOpts)
+	return newBuiltin(execOpts).remove(arg)
 }
 
-// Move one or more files or directories.
+// Move one or more files or directories. Equivalent to `mv`.
 func (runner *StandardRunner) execMoveCommand(
 	ctx context.Context,
 	r *Rule,
@@ -381,11 +548,12 @@ func (runner *StandardRunner) execMoveCommand(
 	if dst == "" {
 		return fmt.Errorf("move command has no dst specified")
 	}
-	execOpts.Command = fmt.Sprintf("mv %s %s", src, dst)
-	return executor.Execute(ctx, execOpts)
+	return newBuiltin(execOpts).move(src, dst)
 }
 
-// Copy one or more files or directories.
+// Copy one or more files or directories. Files are copied natively if the
+// default options are used. Equivalent to `cp -R`. Otherwise the `cp`
+// command is run.
 func (runner *StandardRunner) execCopyCommand(
 	ctx context.Context,
 	r *Rule,
@@ -395,13 +563,16 @@ func (runner *StandardRunner) execCopyCommand(
 ) error {
 	src := getCommandAttr(cmd, "src", "")
 	dst := getCommandAttr(cmd, "dst", "")
-	opts := getCommandAttr(cmd, "options", "-R")
+	opts := getCommandAttr(cmd, "options", defaultCopyOptions)
 	if src == "" {
 		return fmt.Errorf("copy command has no src specified")
 	}
 	if dst == "" {
 		return fmt.Errorf("copy command has no dst specified")
 	}
+	if opts == defaultCopyOptions {
+		return newBuiltin(execOpts).copy(src, dst)
+	}
 	args := fmt.Sprintf("%s %s", src, dst)
 	if opts != "" {
 		args = fmt.Sprintf("%s %s", opts, args)
@@ -417,3 +588,88 @@ func getCommandAttr(cmd *Command, attr, defaultValue string) string {
 	}
 	return value
 }
+
+// getCommandFloatAttr returns a numeric attribute which may be given in YAML
+// as either a number or a string
+func getCommandFloatAttr(cmd *Command, attr string, defaultValue float64) (float64, error) {
+	switch value := cmd.Attributes[attr].(type) {
+	case nil:
+		return defaultValue, nil
+	case int:
+		return float64(value), nil
+	case float64:
+		return value, nil
+	case string:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", attr, value)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("invalid %s: %v", attr, value)
+	}
+}
+
+// getCommandBoolAttr returns a boolean attribute which may be given in YAML
+// as either a boolean or a string
+func getCommandBoolAttr(cmd *Command, attr string, defaultValue bool) (bool, error) {
+	switch value := cmd.Attributes[attr].(type) {
+	case nil:
+		return defaultValue, nil
+	case bool:
+		return value, nil
+	case string:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s: %s", attr, value)
+		}
+		return b, nil
+	default:
+		return false, fmt.Errorf("invalid %s: %v", attr, value)
+	}
+}
+
+// getCommandMapAttr returns an attribute given in YAML as a map of strings.
+// Numbers and booleans are converted to strings.
+func getCommandMapAttr(cmd *Command, attr string) (map[string]string, error) {
+	switch value := cmd.Attributes[attr].(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		result := make(map[string]string, len(value))
+		for k, v := range value {
+			switch v.(type) {
+			case string, int, float64, bool:
+				result[k] = fmt.Sprintf("%v", v)
+			default:
+				return nil, fmt.Errorf("invalid %s: %v", attr, value)
+			}
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("invalid %s: %v", attr, value)
+	}
+}
+
+// getCommandListAttr returns an attribute which may be given in YAML as a
+// list of strings or as a string of space separated values
+func getCommandListAttr(cmd *Command, attr string) ([]string, error) {
+	switch value := cmd.Attributes[attr].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return strings.Fields(value), nil
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			str, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid %s: %v", attr, value)
+			}
+			result = append(result, str)
+		}
+		return result, nil
+	default:
+		return nil, fmt.Errorf("invalid %s: %v", attr, value)
+	}
+}
@@ -24,6 +24,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/fugue/zim/definitions"
 	"github.com/fugue/zim/exec"
@@ -84,6 +85,7 @@ func TestStandardRunner(t *testing.T) {
 		"OUTPUTS":       "../../artifacts/myartifact",
 		"RULE":          "build",
 		"ROOT":          dir,
+		"ZIM_BUILD_ID":  "1234",
 	})
 	buf := bytes.Buffer{}
 	var writer io.Writer = &buf
@@ -192,6 +194,7 @@ func TestStandardRunnerDockerized(t *testing.T) {
 		"NODE_ID":       "widget.twist-it",
 		"RULE":          "twist-it",
 		"ROOT":          dir,
+		"ZIM_BUILD_ID":  "777",
 	})
 	buf := bytes.Buffer{}
 	var writer io.Writer = &buf
@@ -289,6 +292,7 @@ func TestStandardRunnerWhenCondition(t *testing.T) {
 		"OUTPUT":        "",
 		"OUTPUTS":       "",
 		"ROOT":          "/path/to/root",
+		"ZIM_BUILD_ID":  "777",
 	})
 
 	m.EXPECT().ExecutorPath(dir).Return("/path/to/root", nil)
@@ -314,6 +318,8 @@ func TestStandardRunnerWhenCondition(t *testing.T) {
 	})
 	require.Nil(t, err)
 	require.Equal(t, Skipped, code)
+	require.Contains(t, outputBuffer.String(),
+		"skip: when condition not met: script_succeeds failed: Exiting with 1")
 }
 
 func TestStandardRunnerUnlessCondition(t *testing.T) {
@@ -369,6 +375,7 @@ func TestStandardRunnerUnlessCondition(t *testing.T) {
 		"OUTPUT":        "",
 		"OUTPUTS":       "",
 		"ROOT":          "/path/to/root",
+		"ZIM_BUILD_ID":  "777",
 	})
 
 	m.EXPECT().UsesDocker().Return(false).AnyTimes()
@@ -395,6 +402,8 @@ func TestStandardRunnerUnlessCondition(t *testing.T) {
 	})
 	require.Nil(t, err)
 	require.Equal(t, Skipped, code)
+	require.Contains(t, outputBuffer.String(),
+		"skip: unless condition met: script_succeeds succeeded")
 }
 
 func TestRunnerBuiltIns(t *testing.T) {
@@ -608,3 +617,38 @@ func TestEmptyCommand(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, OK, code)
 }
+
+func TestRuleTimeout(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	executor := exec.NewMockExecutor(ctrl)
+	executor.EXPECT().UsesDocker().Return(false).AnyTimes()
+	executor.EXPECT().ExecutorPath(gomock.Any()).AnyTimes()
+	executor.EXPECT().Execute(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, opts exec.ExecOpts) error {
+			<-ctx.Done()
+			return ctx.Err()
+		})
+
+	ctx := context.Background()
+	runner := &StandardRunner{}
+
+	p := &Project{rootAbs: dir}
+	c := &Component{name: "test-comp", componentDir: dir, project: p}
+	r := &Rule{
+		component: c,
+		name:      "test-rule",
+		local:     true,
+		timeout:   10 * time.Millisecond,
+		commands:  []*Command{{Kind: "run", Argument: "sleep 60"}},
+	}
+
+	code, err := runner.Run(ctx, r, RunOpts{Executor: executor})
+	require.NotNil(t, err)
+	require.Equal(t, Timeout, code)
+	require.Equal(t, "Rule test-comp.test-rule timed out after 10ms", err.Error())
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// starterTemplates are written to .zim/templates by Scaffold, keyed by the
+// Component kind they define
+var starterTemplates = map[string]string{
+	"go": `kind: go
+docker:
+  image: golang:1.21
+rules:
+  build:
+    inputs:
+    - go.mod
+    - go.sum
+    - "**/*.go"
+    - "!**/*_test.go"
+    outputs:
+    - ${NAME}
+    command: go build -o ${OUTPUT}
+  test:
+    inputs:
+    - go.mod
+    - go.sum
+    - "**/*.go"
+    command: go test ./...
+`,
+	"node": `kind: node
+docker:
+  image: node:20
+rules:
+  build:
+    inputs:
+    - package.json
+    - package-lock.json
+    - "src/**"
+    outputs:
+    - ${NAME}-dist
+    command: npm ci && npm run build && rm -rf ${OUTPUT} && cp -r dist ${OUTPUT}
+  test:
+    inputs:
+    - package.json
+    - package-lock.json
+    - "src/**"
+    - "test/**"
+    command: npm ci && npm test
+`,
+}
+
+// kindMarkers identify the kind of a Component by a file in its directory,
+// in order of precedence
+var kindMarkers = []struct {
+	file string
+	kind string
+}{
+	{"go.mod", "go"},
+	{"package.json", "node"},
+}
+
+// ScaffoldOpts configures the files created for a new Project
+type ScaffoldOpts struct {
+
+	// Root is the root directory of the Project
+	Root string
+
+	// Infer creates a Component definition for each Go module and Node
+	// package found in the Project. Otherwise an example definition is
+	// created at the root if there are no definitions.
+	Infer bool
+}
+
+// Scaffold creates the files of a new Project: .zim/project.yaml, starter
+// templates in .zim/templates, and Component definitions. Existing files are
+// left unchanged. The paths of the files created are returned relative to
+// the root.
+func Scaffold(opts ScaffoldOpts) ([]string, error) {
+
+	root, err := filepath.Abs(opts.Root)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]string{
+		filepath.Join(".zim", "project.yaml"): fmt.Sprintf("name: %s\n", filepath.Base(root)),
+	}
+	for kind, text := range starterTemplates {
+		files[filepath.Join(".zim", "templates", kind+".yaml")] = text
+	}
+
+	var inferred []inferredComponent
+	if opts.Infer {
+		if inferred, err = inferComponents(root); err != nil {
+			return nil, err
+		}
+	}
+	for _, c := range inferred {
+		files[filepath.Join(c.relDir, "component.yaml")] =
+			fmt.Sprintf("name: %s\nkind: %s\n", c.name, c.kind)
+	}
+	// An example definition is created if the Project has none
+	existing, err := discoverDefs(root)
+	if err != nil {
+		return nil, err
+	}
+	if len(inferred) == 0 && len(existing) == 0 {
+		files["component.yaml"] = fmt.Sprintf(`name: %s
+rules:
+  hello:
+    outputs:
+    - hello.txt
+    command: echo hello > ${OUTPUT}
+`, filepath.Base(root))
+	}
+
+	var created []string
+	for relPath, text := range files {
+		absPath := filepath.Join(root, relPath)
+		if fileExists(absPath) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(absPath), 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(absPath, []byte(text), 0644); err != nil {
+			return nil, err
+		}
+		created = append(created, relPath)
+	}
+	sort.Strings(created)
+	return created, nil
+}
+
+// inferredComponent is a Component found by inferComponents
+type inferredComponent struct {
+	name   string
+	kind   string
+	relDir string
+}
+
+// inferComponents finds directories within the root that look like Go
+// modules or Node packages and don't have a Component definition yet. Each
+// is named after its directory, or its relative path if that name is used.
+func inferComponents(root string) ([]inferredComponent, error) {
+
+	ignore, err := LoadIgnore(root, defaultIgnorePatterns...)
+	if err != nil {
+		return nil, err
+	}
+	var found []inferredComponent
+	err = filepath.Walk(root, func(dirPath string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		relDir, err := filepath.Rel(root, dirPath)
+		if err != nil {
+			return err
+		}
+		if relDir != "." && ignore.Ignored(relDir, true) {
+			return filepath.SkipDir
+		}
+		if fileExists(filepath.Join(dirPath, "component.yaml")) ||
+			fileExists(filepath.Join(dirPath, "zim.yaml")) {
+			return nil
+		}
+		for _, marker := range kindMarkers {
+			if fileExists(filepath.Join(dirPath, marker.file)) {
+				found = append(found, inferredComponent{
+					name:   filepath.Base(dirPath),
+					kind:   marker.kind,
+					relDir: relDir,
+				})
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %s", root, err)
+	}
+
+	counts := map[string]int{}
+	for _, c := range found {
+		counts[c.name]++
+	}
+	for i, c := range found {
+		if counts[c.name] > 1 && c.relDir != "." {
+			found[i].name = strings.Replace(filepath.ToSlash(c.relDir), "/", "-", -1)
+		}
+	}
+	return found, nil
+}
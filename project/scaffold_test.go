@@ -0,0 +1,81 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScaffold(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{
+		"svc/go.mod",
+		"web/package.json",
+		"web/node_modules/left-pad/package.json",
+		"lib/go.mod",
+		"lib/component.yaml",
+	} {
+		p := filepath.Join(dir, name)
+		require.Nil(t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.Nil(t, ioutil.WriteFile(p, []byte("name: lib\n"), 0644))
+	}
+
+	created, err := Scaffold(ScaffoldOpts{Root: dir, Infer: true})
+	require.Nil(t, err)
+	assert.Equal(t, []string{
+		".zim/project.yaml",
+		".zim/templates/go.yaml",
+		".zim/templates/node.yaml",
+		"svc/component.yaml",
+		"web/component.yaml",
+	}, created)
+
+	// The inferred components use the starter templates
+	_, defs, err := Discover(dir)
+	require.Nil(t, err)
+	kinds := map[string]string{}
+	for _, def := range defs {
+		kinds[def.Name] = def.Kind
+	}
+	assert.Equal(t, map[string]string{"lib": "", "svc": "go", "web": "node"}, kinds)
+
+	// Existing files are left unchanged
+	created, err = Scaffold(ScaffoldOpts{Root: dir, Infer: true})
+	require.Nil(t, err)
+	assert.Empty(t, created)
+}
+
+func TestScaffoldExample(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	created, err := Scaffold(ScaffoldOpts{Root: dir})
+	require.Nil(t, err)
+	assert.Contains(t, created, "component.yaml")
+
+	_, defs, err := Discover(dir)
+	require.Nil(t, err)
+	require.Len(t, defs, 1)
+	assert.Equal(t, filepath.Base(dir), defs[0].Name)
+}
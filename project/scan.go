@@ -0,0 +1,225 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+
+	glob "github.com/bmatcuk/doublestar"
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
+)
+
+// Scanner policies determine what happens when a scanner finds a problem
+const (
+	ScanFail       = "fail"
+	ScanWarn       = "warn"
+	ScanQuarantine = "quarantine"
+)
+
+// Scanner inspects the outputs of a Rule after it runs, either by running a
+// command or by looking for forbidden files
+type Scanner struct {
+	name    string
+	command string
+	forbid  []string
+	policy  string
+}
+
+// NewScanner returns a Scanner from its definition
+func NewScanner(self definitions.Scanner) (*Scanner, error) {
+	s := &Scanner{
+		name:    self.Name,
+		command: self.Command,
+		forbid:  self.Forbid,
+		policy:  self.Policy,
+	}
+	if s.name == "" {
+		s.name = "scanner"
+	}
+	if s.command == "" && len(s.forbid) == 0 {
+		return nil, fmt.Errorf("Scanner %s has no command or forbid patterns", s.name)
+	}
+	for _, pattern := range s.forbid {
+		if _, err := glob.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("Scanner %s has an invalid pattern: %s", s.name, pattern)
+		}
+	}
+	switch s.policy {
+	case "":
+		s.policy = ScanFail
+	case ScanFail, ScanWarn, ScanQuarantine:
+	default:
+		return nil, fmt.Errorf("Scanner %s has an invalid policy: %s (%s | %s | %s)",
+			s.name, s.policy, ScanFail, ScanWarn, ScanQuarantine)
+	}
+	return s, nil
+}
+
+// Scan inspects the given output paths of the Rule. A description of the
+// problem found is returned, or an empty string if there is none.
+func (s *Scanner) Scan(ctx context.Context, r *Rule, paths []string) (string, error) {
+	if len(s.forbid) > 0 {
+		found, err := s.findForbidden(r, paths)
+		if err != nil || found != "" {
+			return found, err
+		}
+	}
+	if s.command == "" {
+		return "", nil
+	}
+	cmd := exec.ShellCommand(ctx, s.command)
+	cmd.Dir = r.Project().RootAbsPath()
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("NODE_ID=%s", r.NodeID()),
+		fmt.Sprintf("COMPONENT=%s", r.Component().Name()),
+		fmt.Sprintf("RULE=%s", r.Name()),
+		fmt.Sprintf("OUTPUTS=%s", strings.Join(paths, " ")))
+	output, err := cmd.CombinedOutput()
+	if _, ok := err.(*osexec.ExitError); ok {
+		return fmt.Sprintf("%s %s", err, strings.TrimSpace(string(output))), nil
+	}
+	return "", err
+}
+
+// findForbidden returns a description of the first file within the output
+// paths that matches a forbidden pattern. Files are matched by their path
+// relative to the Rule's artifacts directory.
+func (s *Scanner) findForbidden(r *Rule, paths []string) (string, error) {
+	var found string
+	for _, outPath := range paths {
+		err := filepath.Walk(outPath, func(p string, info os.FileInfo, err error) error {
+			if err != nil || found != "" || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(r.ArtifactsDir(), p)
+			if err != nil {
+				return err
+			}
+			for _, pattern := range s.forbid {
+				if matched, _ := glob.Match(pattern, rel); matched {
+					found = fmt.Sprintf("%s matches forbidden pattern %s", rel, pattern)
+					break
+				}
+			}
+			return nil
+		})
+		if err != nil || found != "" {
+			return found, err
+		}
+	}
+	return "", nil
+}
+
+// Scanners inspect the outputs of Rules after they run. Use its Middleware
+// in a Chain beneath the cache so outputs are scanned before they are stored.
+type Scanners struct {
+	scanners      []*Scanner
+	quarantineDir string
+}
+
+// NewScanners returns Scanners from their definitions. Outputs rejected by
+// scanners with the quarantine policy are moved to the given directory.
+func NewScanners(defs []definitions.Scanner, quarantineDir string) (*Scanners, error) {
+	s := &Scanners{quarantineDir: quarantineDir}
+	for _, def := range defs {
+		scanner, err := NewScanner(def)
+		if err != nil {
+			return nil, err
+		}
+		s.scanners = append(s.scanners, scanner)
+	}
+	return s, nil
+}
+
+// Middleware scans the outputs of Rules built by the wrapped Runner. A
+// problem found by a scanner fails the Rule, so that its outputs are not
+// cached, unless the scanner only warns. Quarantined outputs are moved out
+// of the artifacts directory as well.
+func (s *Scanners) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+
+		code, err := runner.Run(ctx, r, opts)
+		if code != OK || err != nil || opts.DryRun || len(s.scanners) == 0 {
+			return code, err
+		}
+		var paths []string
+		for _, out := range r.Outputs() {
+			if out.OnFilesystem() {
+				paths = append(paths, out.Path())
+			}
+		}
+		if len(paths) == 0 {
+			return code, nil
+		}
+		for _, scanner := range s.scanners {
+			problem, err := scanner.Scan(ctx, r, paths)
+			if err != nil {
+				return Error, fmt.Errorf("Scanner %s failed on %s: %s",
+					scanner.name, r.NodeID(), err)
+			}
+			if problem == "" {
+				continue
+			}
+			msg := fmt.Sprintf("scanner %s found a problem in the outputs of %s: %s",
+				scanner.name, r.NodeID(), problem)
+			switch scanner.policy {
+			case ScanWarn:
+				output := opts.Output
+				if output == nil {
+					output = os.Stdout
+				}
+				fmt.Fprintln(output, Yellow("Warning: "+msg))
+			case ScanQuarantine:
+				dir, err := s.quarantine(r, paths)
+				if err != nil {
+					return Error, err
+				}
+				return ExecError, fmt.Errorf("%s (moved to %s)", msg, dir)
+			default:
+				return ExecError, errors.New(msg)
+			}
+		}
+		return code, nil
+	})
+}
+
+// quarantine moves the output paths of the Rule to a directory named after
+// it in the quarantine directory, replacing any outputs quarantined before
+func (s *Scanners) quarantine(r *Rule, paths []string) (string, error) {
+	dir := filepath.Join(s.quarantineDir, r.NodeID())
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	for _, outPath := range paths {
+		rel, err := filepath.Rel(r.ArtifactsDir(), outPath)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = filepath.Base(outPath)
+		}
+		dst := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Rename(outPath, dst); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return dir, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScanners(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	cDir := path.Join(dir, "app")
+	require.Nil(t, os.MkdirAll(cDir, 0755))
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(cDir, "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {Outputs: []string{"app.tar", "secrets/key.pem"}},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	r := p.Components().First().MustRule("build")
+
+	runner := RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		for _, out := range r.Outputs() {
+			require.Nil(t, os.MkdirAll(filepath.Dir(out.Path()), 0755))
+			require.Nil(t, ioutil.WriteFile(out.Path(), []byte("data"), 0644))
+		}
+		return OK, nil
+	})
+	run := func(def definitions.Scanner) (Code, error) {
+		scanners, err := NewScanners([]definitions.Scanner{def}, p.QuarantineDir())
+		require.Nil(t, err)
+		return scanners.Middleware(runner).Run(context.Background(), r, RunOpts{Output: ioutil.Discard})
+	}
+
+	code, err := run(definitions.Scanner{
+		Name:    "nonempty",
+		Command: "for f in $OUTPUTS; do test -s $f; done",
+	})
+	require.Nil(t, err)
+	assert.Equal(t, OK, code)
+
+	code, err = run(definitions.Scanner{
+		Name:   "keys",
+		Forbid: []string{"**/*.pem"},
+		Policy: ScanWarn,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, OK, code)
+
+	code, err = run(definitions.Scanner{Name: "keys", Forbid: []string{"**/*.pem"}})
+	require.NotNil(t, err)
+	assert.Equal(t, ExecError, code)
+	assert.Contains(t, err.Error(), "secrets/key.pem matches forbidden pattern **/*.pem")
+
+	code, err = run(definitions.Scanner{
+		Name:    "virus",
+		Command: "echo infected; exit 1",
+		Policy:  ScanQuarantine,
+	})
+	require.NotNil(t, err)
+	assert.Equal(t, ExecError, code)
+	assert.Contains(t, err.Error(), "infected")
+	assert.FileExists(t, filepath.Join(p.QuarantineDir(), "app.build", "secrets", "key.pem"))
+	exists, err := r.Outputs()[0].Exists()
+	require.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestNewScannerInvalid(t *testing.T) {
+	_, err := NewScanner(definitions.Scanner{Name: "empty"})
+	assert.NotNil(t, err)
+
+	_, err = NewScanner(definitions.Scanner{Command: "true", Policy: "delete"})
+	assert.NotNil(t, err)
+
+	s, err := NewScanner(definitions.Scanner{Command: "true"})
+	require.Nil(t, err)
+	assert.Equal(t, ScanFail, s.policy)
+}
@@ -0,0 +1,179 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Secret providers supported in secret references
+const (
+	SecretProviderSSM            = "ssm"
+	SecretProviderSecretsManager = "secretsmanager"
+)
+
+// redacted replaces the values of secrets in Rule output
+const redacted = "********"
+
+// SecretRef identifies a secret held by a provider, written in definitions
+// as "provider:name" or "provider:name#key". The key selects one field of a
+// secret whose value is a JSON object.
+type SecretRef struct {
+	Provider string
+	Name     string
+	Key      string
+}
+
+// String returns the reference as it is written in definitions
+func (ref SecretRef) String() string {
+	if ref.Key != "" {
+		return fmt.Sprintf("%s:%s#%s", ref.Provider, ref.Name, ref.Key)
+	}
+	return fmt.Sprintf("%s:%s", ref.Provider, ref.Name)
+}
+
+// ParseSecretRef parses a secret reference, e.g. "ssm:/prod/db/password" or
+// "secretsmanager:prod/db#password"
+func ParseSecretRef(s string) (SecretRef, error) {
+	var ref SecretRef
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return ref, fmt.Errorf("invalid secret reference: %s", s)
+	}
+	ref.Provider = parts[0]
+	ref.Name = parts[1]
+	if i := strings.LastIndex(ref.Name, "#"); i >= 0 {
+		ref.Key = ref.Name[i+1:]
+		ref.Name = ref.Name[:i]
+		if ref.Key == "" {
+			return ref, fmt.Errorf("invalid secret reference: %s", s)
+		}
+	}
+	switch ref.Provider {
+	case SecretProviderSSM, SecretProviderSecretsManager:
+	default:
+		return ref, fmt.Errorf("unsupported secret provider: %s (%s | %s)",
+			ref.Provider, SecretProviderSSM, SecretProviderSecretsManager)
+	}
+	if ref.Name == "" {
+		return ref, fmt.Errorf("invalid secret reference: %s", s)
+	}
+	return ref, nil
+}
+
+// parseSecretRefs parses secret references keyed by environment variable
+func parseSecretRefs(secrets map[string]string) (map[string]SecretRef, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	refs := make(map[string]SecretRef, len(secrets))
+	for name, value := range secrets {
+		ref, err := ParseSecretRef(value)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s has an %s", name, err)
+		}
+		refs[name] = ref
+	}
+	return refs, nil
+}
+
+// SecretResolver retrieves the values of secrets
+type SecretResolver interface {
+
+	// Resolve returns the value of the referenced secret
+	Resolve(ctx context.Context, ref SecretRef) (string, error)
+}
+
+// Secrets returns references to the secrets set as environment variables
+// when the Rule runs, keyed by variable name
+func (r *Rule) Secrets() map[string]SecretRef {
+	if r.secrets == nil {
+		return nil
+	}
+	secrets := make(map[string]SecretRef, len(r.secrets))
+	for k, v := range r.secrets {
+		secrets[k] = v
+	}
+	return secrets
+}
+
+// resolveSecrets returns the values of the Rule's secrets keyed by
+// environment variable
+func resolveSecrets(ctx context.Context, r *Rule, resolver SecretResolver) (map[string]string, error) {
+	refs := r.Secrets()
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if resolver == nil {
+		return nil, fmt.Errorf("Rule %s has secrets but no secrets provider is configured",
+			r.NodeID())
+	}
+	values := make(map[string]string, len(refs))
+	for name, ref := range refs {
+		value, err := resolver.Resolve(ctx, ref)
+		if err != nil {
+			return nil, fmt.Errorf("Rule %s failed to resolve secret %s: %s",
+				r.NodeID(), name, err)
+		}
+		values[name] = value
+	}
+	return values, nil
+}
+
+// redactingWriter replaces secret values in everything written through it.
+// A value is only found if it is contained in a single write, which holds
+// for line buffered command output.
+type redactingWriter struct {
+	w        io.Writer
+	replacer *strings.Replacer
+}
+
+// Write the data to the underlying writer with secrets redacted
+func (rw *redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(rw.w, rw.replacer.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactSecrets returns a writer that redacts the given secret values.
+// Executors write to stdout when no writer is set, so that is redacted too.
+func redactSecrets(w io.Writer, secrets map[string]string) io.Writer {
+	if len(secrets) == 0 {
+		return w
+	}
+	if w == nil {
+		w = os.Stdout
+	}
+	values := make([]string, 0, len(secrets))
+	for _, value := range secrets {
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+	// Longer values are replaced first in case one contains another
+	sort.Slice(values, func(i, j int) bool {
+		return len(values[i]) > len(values[j])
+	})
+	var pairs []string
+	for _, value := range values {
+		pairs = append(pairs, value, redacted)
+	}
+	return &redactingWriter{w: w, replacer: strings.NewReplacer(pairs...)}
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/fugue/zim/exec"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+type testSecrets map[string]string
+
+func (s testSecrets) Resolve(ctx context.Context, ref SecretRef) (string, error) {
+	value, found := s[ref.String()]
+	if !found {
+		return "", fmt.Errorf("not found")
+	}
+	return value, nil
+}
+
+func TestParseSecretRef(t *testing.T) {
+	ref, err := ParseSecretRef("ssm:/prod/db/password")
+	require.Nil(t, err)
+	require.Equal(t, SecretRef{Provider: "ssm", Name: "/prod/db/password"}, ref)
+
+	ref, err = ParseSecretRef("secretsmanager:prod/db#password")
+	require.Nil(t, err)
+	require.Equal(t, SecretRef{
+		Provider: "secretsmanager",
+		Name:     "prod/db",
+		Key:      "password",
+	}, ref)
+	require.Equal(t, "secretsmanager:prod/db#password", ref.String())
+
+	for _, s := range []string{"/prod/db", "vault:db", "ssm:", "ssm:/db#"} {
+		_, err = ParseSecretRef(s)
+		require.NotNil(t, err, s)
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	w := redactSecrets(&buf, map[string]string{
+		"A": "abc",
+		"B": "abcdef",
+		"C": "",
+	})
+	n, err := w.Write([]byte("token abcdef and abc\n"))
+	require.Nil(t, err)
+	require.Equal(t, 21, n)
+	require.Equal(t, "token ******** and ********\n", buf.String())
+
+	require.Equal(t, &buf, redactSecrets(&buf, nil))
+}
+
+func TestRuleSecrets(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	var output bytes.Buffer
+	var env []string
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	executor := exec.NewMockExecutor(ctrl)
+	executor.EXPECT().UsesDocker().Return(false).AnyTimes()
+	executor.EXPECT().ExecutorPath(gomock.Any()).AnyTimes()
+	executor.EXPECT().Execute(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, opts exec.ExecOpts) error {
+			env = opts.Env
+			fmt.Fprintln(opts.Stdout, "password is hunter2")
+			return nil
+		})
+
+	ctx := context.Background()
+	p := &Project{rootAbs: dir}
+	c := &Component{name: "test-comp", componentDir: dir, project: p}
+	r := &Rule{
+		component: c,
+		name:      "test-rule",
+		local:     true,
+		commands:  []*Command{{Kind: "run", Argument: "echo $DB_PASSWORD"}},
+		secrets: map[string]SecretRef{
+			"DB_PASSWORD": {Provider: "ssm", Name: "/prod/db/password"},
+		},
+	}
+
+	// Rules with secrets fail without a provider
+	runner := &StandardRunner{}
+	code, err := runner.Run(ctx, r, RunOpts{Executor: executor})
+	require.NotNil(t, err)
+	require.Equal(t, Error, code)
+
+	runner.Secrets = testSecrets{"ssm:/prod/db/password": "hunter2"}
+	code, err = runner.Run(ctx, r, RunOpts{Executor: executor, Output: &output})
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+	require.Contains(t, env, "DB_PASSWORD=hunter2")
+	require.Equal(t, "password is ********\n", output.String())
+
+	// Secrets are not part of the Rule environment used in keys
+	ruleEnv, err := r.Environment()
+	require.Nil(t, err)
+	require.NotContains(t, ruleEnv, "DB_PASSWORD")
+}
@@ -0,0 +1,514 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/fugue/zim/exec"
+)
+
+const (
+	defaultHealthCheckInterval = time.Second
+	defaultHealthCheckTimeout  = time.Minute
+
+	// Delay before restarting a service that exited
+	restartDelay = time.Second
+)
+
+// RestartPolicy determines whether a service is restarted after it exits
+type RestartPolicy string
+
+const (
+	// RestartNever leaves a service stopped after it exits
+	RestartNever RestartPolicy = ""
+
+	// RestartOnFailure restarts a service that exits with an error
+	RestartOnFailure RestartPolicy = "on-failure"
+
+	// RestartAlways restarts a service whenever it exits
+	RestartAlways RestartPolicy = "always"
+)
+
+// HealthCheck defines a script that succeeds once a service is ready
+type HealthCheck struct {
+	Run      string
+	Interval time.Duration
+	Timeout  time.Duration
+}
+
+// IsEmpty returns true if the HealthCheck is not defined
+func (h HealthCheck) IsEmpty() bool {
+	return h.Run == ""
+}
+
+func newHealthCheck(self definitions.HealthCheck) (HealthCheck, error) {
+	h := HealthCheck{
+		Run:      self.Run,
+		Interval: defaultHealthCheckInterval,
+		Timeout:  defaultHealthCheckTimeout,
+	}
+	var err error
+	if self.Interval != "" {
+		if h.Interval, err = time.ParseDuration(self.Interval); err != nil {
+			return h, err
+		}
+	}
+	if self.Timeout != "" {
+		if h.Timeout, err = time.ParseDuration(self.Timeout); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// ServiceState describes a running service. It is saved to disk so that
+// other zim processes are able to inspect and stop services.
+type ServiceState struct {
+	Rule      string         `json:"rule"`
+	PID       int            `json:"pid"`
+	Ports     map[string]int `json:"ports"`
+	StartedAt time.Time      `json:"started_at"`
+	Restarts  int            `json:"restarts"`
+	Healthy   bool           `json:"healthy"`
+}
+
+// ReadServiceStates returns the states of services saved in the directory,
+// sorted by Rule. States saved by processes that are no longer running are
+// removed.
+func ReadServiceStates(dir string) ([]*ServiceState, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var states []*ServiceState
+	for _, statePath := range paths {
+		data, err := ioutil.ReadFile(statePath)
+		if err != nil {
+			return nil, err
+		}
+		state := &ServiceState{}
+		if err := json.Unmarshal(data, state); err != nil {
+			return nil, fmt.Errorf("invalid service state %s: %s", statePath, err)
+		}
+		if !processExists(state.PID) {
+			os.Remove(statePath)
+			continue
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Rule < states[j].Rule
+	})
+	return states, nil
+}
+
+// freePorts returns the given number of distinct TCP ports that are unused.
+// Listeners are held open until all ports are found to avoid duplicates.
+func freePorts(count int) ([]int, error) {
+	var ports []int
+	for i := 0; i < count; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer listener.Close()
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// service is one running instance of a service Rule
+type service struct {
+	rule    *Rule
+	runner  Runner
+	opts    RunOpts
+	state   ServiceState
+	cancel  context.CancelFunc
+	healthy chan struct{}
+	done    chan struct{}
+}
+
+// ServicesOpts contains options used to configure Services
+type ServicesOpts struct {
+
+	// Output receives service output. Defaults to stdout.
+	Output io.Writer
+
+	// StateDir is where the state of running services is saved. State is not
+	// saved if this is empty.
+	StateDir string
+}
+
+// Services starts service Rules in the background and keeps track of them
+// while they run. A service is restarted when one of its dependencies is
+// rebuilt and all services are stopped when the Services context is canceled.
+// Use its Middleware in a Chain to enable service Rules.
+type Services struct {
+	mutex    sync.Mutex
+	ctx      context.Context
+	output   io.Writer
+	stateDir string
+	services map[string]*service
+	ports    map[string]map[string]int
+}
+
+// NewServices returns a Services that stops its services when the given
+// context is canceled
+func NewServices(ctx context.Context, opts ServicesOpts) *Services {
+	output := opts.Output
+	if output == nil {
+		output = os.Stdout
+	}
+	return &Services{
+		ctx:      ctx,
+		output:   output,
+		stateDir: opts.StateDir,
+		services: map[string]*service{},
+		ports:    map[string]map[string]int{},
+	}
+}
+
+// Middleware starts service Rules in the background, returning once the
+// service passes its health check, if it has one. When any other Rule is
+// built, services that depend on it are restarted.
+func (s *Services) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		if r.IsService() {
+			// Output is written directly since the service outlives any
+			// buffering done for the duration of the rule
+			opts.Output = s.output
+			opts.DebugOutput = s.output
+			svc, err := s.start(r, runner, opts, 0)
+			if err != nil {
+				return Error, err
+			}
+			if err := s.waitHealthy(ctx, svc); err != nil {
+				s.Stop(r)
+				return ExecError, err
+			}
+			return OK, nil
+		}
+		code, err := runner.Run(ctx, r, opts)
+		if code == OK {
+			s.restartDependents(r)
+		}
+		return code, err
+	})
+}
+
+// allocatePorts returns free ports for each port variable of the Rule. Ports
+// are reused when a service is restarted.
+func (s *Services) allocatePorts(r *Rule) (map[string]int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ports, found := s.ports[r.NodeID()]
+	if found {
+		return ports, nil
+	}
+	free, err := freePorts(len(r.Ports()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate ports: %s", err)
+	}
+	ports = map[string]int{}
+	for i, name := range r.Ports() {
+		ports[name] = free[i]
+	}
+	s.ports[r.NodeID()] = ports
+	return ports, nil
+}
+
+// start runs the service Rule in the background, first stopping any
+// instance of it that is already running
+func (s *Services) start(r *Rule, runner Runner, opts RunOpts, restarts int) (*service, error) {
+	s.Stop(r)
+
+	ports, err := s.allocatePorts(r)
+	if err != nil {
+		return nil, err
+	}
+	env := copyEnvironment(opts.Environment)
+	for name, port := range ports {
+		env[name] = strconv.Itoa(port)
+	}
+	opts.Environment = env
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	svc := &service{
+		rule:   r,
+		runner: runner,
+		opts:   opts,
+		state: ServiceState{
+			Rule:      r.NodeID(),
+			PID:       os.Getpid(),
+			Ports:     ports,
+			StartedAt: time.Now(),
+			Restarts:  restarts,
+			Healthy:   r.HealthCheck().IsEmpty(),
+		},
+		cancel:  cancel,
+		healthy: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	s.mutex.Lock()
+	s.services[r.NodeID()] = svc
+	s.mutex.Unlock()
+	s.saveState(svc)
+
+	if !r.HealthCheck().IsEmpty() {
+		go s.checkHealth(ctx, svc)
+	}
+
+	go func() {
+		defer close(svc.done)
+		defer cancel()
+		for {
+			code, err := runner.Run(ctx, r, opts)
+			if ctx.Err() != nil || code == Skipped {
+				break // Stopped intentionally or conditions not met
+			}
+			if err != nil {
+				fmt.Fprintln(s.output, "service:", Bright(r.NodeID()), Red("exited:"), err)
+			} else {
+				fmt.Fprintln(s.output, "service:", Bright(r.NodeID()), Yellow("exited"))
+			}
+			policy := r.RestartPolicy()
+			if policy != RestartAlways && (policy != RestartOnFailure || err == nil) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(restartDelay):
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			s.mutex.Lock()
+			svc.state.Restarts++
+			svc.state.StartedAt = time.Now()
+			s.mutex.Unlock()
+			s.saveState(svc)
+			fmt.Fprintln(s.output, "service:", Bright(r.NodeID()), Yellow("restarting"))
+		}
+		s.mutex.Lock()
+		current := s.services[r.NodeID()] == svc
+		if current {
+			delete(s.services, r.NodeID())
+		}
+		s.mutex.Unlock()
+		if current {
+			s.removeState(r)
+		}
+	}()
+	return svc, nil
+}
+
+// checkHealth runs the health check script of the service until it succeeds
+func (s *Services) checkHealth(ctx context.Context, svc *service) {
+	check := svc.rule.HealthCheck()
+	env, err := svc.rule.Environment()
+	if err != nil {
+		return
+	}
+	for k, v := range svc.opts.Environment {
+		env[k] = v
+	}
+	executor := exec.NewBashExecutor()
+	for {
+		err := executor.Execute(ctx, exec.ExecOpts{
+			Command:          check.Run,
+			WorkingDirectory: svc.rule.Component().Directory(),
+			Env:              flattenEnvironment(env),
+			Name:             fmt.Sprintf("%s.health", svc.rule.NodeID()),
+			Stdout:           ioutil.Discard,
+			Stderr:           ioutil.Discard,
+			Cmdout:           ioutil.Discard,
+		})
+		if err == nil {
+			s.mutex.Lock()
+			svc.state.Healthy = true
+			s.mutex.Unlock()
+			s.saveState(svc)
+			close(svc.healthy)
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(check.Interval):
+		}
+	}
+}
+
+// waitHealthy blocks until the service passes its health check
+func (s *Services) waitHealthy(ctx context.Context, svc *service) error {
+	check := svc.rule.HealthCheck()
+	if check.IsEmpty() {
+		return nil
+	}
+	select {
+	case <-svc.healthy:
+		fmt.Fprintln(s.output, "service:", Bright(svc.rule.NodeID()), Green("healthy"))
+		return nil
+	case <-svc.done:
+		return fmt.Errorf("service %s exited before becoming healthy", svc.rule.NodeID())
+	case <-time.After(check.Timeout):
+		return fmt.Errorf("service %s failed health check after %s",
+			svc.rule.NodeID(), check.Timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// restartDependents restarts running services that depend on the given Rule
+func (s *Services) restartDependents(dep *Rule) {
+	for _, svc := range s.running() {
+		if !dependsOn(svc.rule, dep) {
+			continue
+		}
+		fmt.Fprintln(s.output, "service:", Bright(svc.rule.NodeID()),
+			Yellow(fmt.Sprintf("restarting since %s was rebuilt", dep.NodeID())))
+		s.mutex.Lock()
+		restarts := svc.state.Restarts
+		s.mutex.Unlock()
+		if _, err := s.start(svc.rule, svc.runner, svc.opts, restarts+1); err != nil {
+			fmt.Fprintln(s.output, "service:", Bright(svc.rule.NodeID()), Red(err.Error()))
+		}
+	}
+}
+
+// Stop the service Rule if it is running and wait for it to exit
+func (s *Services) Stop(r *Rule) {
+	s.mutex.Lock()
+	svc, found := s.services[r.NodeID()]
+	delete(s.services, r.NodeID())
+	s.mutex.Unlock()
+	if found {
+		svc.cancel()
+		<-svc.done
+		s.removeState(r)
+	}
+}
+
+// Running returns the service Rules that are currently running
+func (s *Services) Running() []*Rule {
+	var rules []*Rule
+	for _, svc := range s.running() {
+		rules = append(rules, svc.rule)
+	}
+	return rules
+}
+
+// States returns the state of each running service, sorted by Rule
+func (s *Services) States() []ServiceState {
+	var states []ServiceState
+	for _, svc := range s.running() {
+		s.mutex.Lock()
+		states = append(states, svc.state)
+		s.mutex.Unlock()
+	}
+	return states
+}
+
+// Wait blocks until all services have exited, which happens when they crash
+// or when the Services context is canceled
+func (s *Services) Wait() {
+	for {
+		running := s.running()
+		if len(running) == 0 {
+			return
+		}
+		// Services may be restarted while waiting, so check again after
+		// the current ones exit
+		for _, svc := range running {
+			<-svc.done
+		}
+	}
+}
+
+func (s *Services) running() []*service {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]*service, 0, len(s.services))
+	for _, svc := range s.services {
+		result = append(result, svc)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].rule.NodeID() < result[j].rule.NodeID()
+	})
+	return result
+}
+
+func (s *Services) statePath(r *Rule) string {
+	return filepath.Join(s.stateDir, r.NodeID()+".json")
+}
+
+// saveState writes the state of the service to the state directory
+func (s *Services) saveState(svc *service) {
+	if s.stateDir == "" {
+		return
+	}
+	s.mutex.Lock()
+	data, err := json.MarshalIndent(svc.state, "", "  ")
+	s.mutex.Unlock()
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(s.stateDir, 0755); err != nil {
+		return
+	}
+	if err := ioutil.WriteFile(s.statePath(svc.rule), data, 0644); err != nil {
+		fmt.Fprintln(s.output, "service:", Bright(svc.rule.NodeID()),
+			Yellow(fmt.Sprintf("failed to save state: %s", err)))
+	}
+}
+
+func (s *Services) removeState(r *Rule) {
+	if s.stateDir != "" {
+		os.Remove(s.statePath(r))
+	}
+}
+
+// dependsOn returns true if Rule a depends on Rule b directly or transitively
+func dependsOn(a, b *Rule) bool {
+	visited := map[*Rule]bool{}
+	var visit func(r *Rule) bool
+	visit = func(r *Rule) bool {
+		for _, dep := range r.Dependencies() {
+			if dep == b {
+				return true
+			}
+			if !visited[dep] {
+				visited[dep] = true
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return visit(a)
+}
@@ -0,0 +1,201 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServices(t *testing.T) {
+
+	c := &Component{name: "app"}
+	build := &Rule{component: c, name: "build"}
+	serve := &Rule{component: c, name: "serve", service: true,
+		resolvedDeps: []*Rule{build}}
+	other := &Rule{component: c, name: "other"}
+
+	var mutex sync.Mutex
+	starts := map[string]int{}
+	next := RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		mutex.Lock()
+		starts[r.NodeID()]++
+		mutex.Unlock()
+		if r.IsService() {
+			<-ctx.Done()
+			return ExecError, ctx.Err()
+		}
+		return OK, nil
+	})
+	startCount := func(r *Rule) int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return starts[r.NodeID()]
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var output bytes.Buffer
+	services := NewServices(ctx, ServicesOpts{Output: &output})
+	runner := services.Middleware(next)
+
+	// The service starts in the background
+	code, err := runner.Run(ctx, serve, RunOpts{})
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+	require.Equal(t, []*Rule{serve}, services.Running())
+
+	// Rebuilding an unrelated rule leaves the service alone
+	runner.Run(ctx, other, RunOpts{})
+	require.Eventually(t, func() bool { return startCount(serve) == 1 }, time.Second, 10*time.Millisecond)
+
+	// Rebuilding a dependency restarts the service
+	runner.Run(ctx, build, RunOpts{})
+	require.Eventually(t, func() bool { return startCount(serve) == 2 }, time.Second, 10*time.Millisecond)
+	require.Equal(t, []*Rule{serve}, services.Running())
+	assert.Contains(t, output.String(), "restarting since app.build was rebuilt")
+
+	// Canceling the context stops all services
+	cancel()
+	services.Wait()
+	require.Empty(t, services.Running())
+	assert.NotContains(t, output.String(), "exited")
+}
+
+func TestServicePortsAndState(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	c := &Component{name: "app", componentDir: dir}
+	serve := &Rule{component: c, name: "serve", service: true,
+		ports: []string{"PORT", "DEBUG_PORT"},
+		healthCheck: HealthCheck{
+			Run:      `test -n "$PORT"`,
+			Interval: 10 * time.Millisecond,
+			Timeout:  5 * time.Second,
+		}}
+
+	envs := make(chan map[string]string, 2)
+	next := RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		envs <- opts.Environment
+		<-ctx.Done()
+		return ExecError, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stateDir := path.Join(dir, "services")
+	services := NewServices(ctx, ServicesOpts{Output: ioutil.Discard, StateDir: stateDir})
+	runner := services.Middleware(next)
+
+	code, err := runner.Run(ctx, serve, RunOpts{})
+	require.Nil(t, err)
+	require.Equal(t, OK, code)
+
+	env := <-envs
+	require.NotEmpty(t, env["PORT"])
+	require.NotEmpty(t, env["DEBUG_PORT"])
+	require.NotEqual(t, env["PORT"], env["DEBUG_PORT"])
+
+	states, err := ReadServiceStates(stateDir)
+	require.Nil(t, err)
+	require.Len(t, states, 1)
+	assert.Equal(t, "app.serve", states[0].Rule)
+	assert.Equal(t, os.Getpid(), states[0].PID)
+	assert.True(t, states[0].Healthy)
+	assert.Equal(t, env["PORT"], strconv.Itoa(states[0].Ports["PORT"]))
+
+	// Ports are kept when the service is restarted
+	services.start(serve, next, RunOpts{}, 1)
+	restartedEnv := <-envs
+	assert.Equal(t, env["PORT"], restartedEnv["PORT"])
+
+	services.Stop(serve)
+	states, err = ReadServiceStates(stateDir)
+	require.Nil(t, err)
+	require.Empty(t, states)
+}
+
+func TestServiceRestartPolicy(t *testing.T) {
+
+	c := &Component{name: "app"}
+	crashy := &Rule{component: c, name: "crashy", service: true,
+		restart: RestartOnFailure}
+	unhealthy := &Rule{component: c, name: "unhealthy", service: true,
+		healthCheck: HealthCheck{Run: "exit 1", Interval: time.Millisecond,
+			Timeout: 50 * time.Millisecond}}
+
+	var mutex sync.Mutex
+	runs := 0
+	next := RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		if r == unhealthy {
+			<-ctx.Done()
+			return ExecError, ctx.Err()
+		}
+		mutex.Lock()
+		runs++
+		count := runs
+		mutex.Unlock()
+		if count < 2 {
+			return ExecError, errors.New("crashed")
+		}
+		<-ctx.Done()
+		return ExecError, ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var output bytes.Buffer
+	services := NewServices(ctx, ServicesOpts{Output: &output})
+	runner := services.Middleware(next)
+
+	// A crashed service is restarted
+	_, err := runner.Run(ctx, crashy, RunOpts{})
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		states := services.States()
+		return len(states) == 1 && states[0].Restarts == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// A service that never passes its health check fails and is stopped
+	code, err := runner.Run(ctx, unhealthy, RunOpts{})
+	require.NotNil(t, err)
+	require.Equal(t, ExecError, code)
+	require.Equal(t, []*Rule{crashy}, services.Running())
+
+	cancel()
+	services.Wait()
+}
+
+func TestProcessExists(t *testing.T) {
+	require.True(t, processExists(os.Getpid()))
+
+	// Run the test binary without any tests so that it exits at once
+	executable, err := os.Executable()
+	require.Nil(t, err)
+	command := osexec.Command(executable, "-test.run=^$")
+	require.Nil(t, command.Run())
+	require.False(t, processExists(command.Process.Pid))
+}
@@ -0,0 +1,297 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Snapshot is a canonical description of a resolved Project. Comparing
+// snapshots taken before and after upgrading Zim, or refactoring component
+// definitions, shows whether the build changed.
+type Snapshot struct {
+	ZimVersion string              `json:"zim_version,omitempty"`
+	Project    string              `json:"project"`
+	Components []ComponentSnapshot `json:"components"`
+}
+
+// ComponentSnapshot describes one Component of a Snapshot
+type ComponentSnapshot struct {
+	Name  string         `json:"name"`
+	Kind  string         `json:"kind,omitempty"`
+	Path  string         `json:"path"`
+	Rules []RuleSnapshot `json:"rules"`
+}
+
+// RuleSnapshot describes one Rule of a Snapshot. Paths are relative to the
+// project root.
+type RuleSnapshot struct {
+	ID       string           `json:"id"`
+	Key      string           `json:"key,omitempty"`
+	Image    string           `json:"image,omitempty"`
+	Native   bool             `json:"native,omitempty"`
+	Platform string           `json:"platform,omitempty"`
+	Commands []PlannedCommand `json:"commands"`
+	Inputs   []string         `json:"inputs"`
+	Outputs  []string         `json:"outputs"`
+	Requires []string         `json:"requires"`
+}
+
+// SnapshotChange is one difference between two Snapshots
+type SnapshotChange struct {
+	Item   string `json:"item"`
+	Change string `json:"change"`
+	Old    string `json:"old,omitempty"`
+	New    string `json:"new,omitempty"`
+}
+
+// Kinds of SnapshotChange
+const (
+	SnapshotAdded   = "added"
+	SnapshotRemoved = "removed"
+	SnapshotChanged = "changed"
+)
+
+// NewSnapshot returns a Snapshot of every Rule in the Project. Keys are
+// included if a KeyFunc is given.
+func NewSnapshot(p *Project, key KeyFunc) (*Snapshot, error) {
+	root := p.RootAbsPath()
+	snapshot := &Snapshot{Project: p.Name(), Components: []ComponentSnapshot{}}
+	for _, c := range p.Components() {
+		cs := ComponentSnapshot{Name: c.Name(), Kind: c.Kind(), Path: c.RelPath()}
+		for _, r := range c.Rules() {
+			rs, err := newRuleSnapshot(r, root, key)
+			if err != nil {
+				return nil, err
+			}
+			cs.Rules = append(cs.Rules, rs)
+		}
+		sort.Slice(cs.Rules, func(i, j int) bool {
+			return cs.Rules[i].ID < cs.Rules[j].ID
+		})
+		snapshot.Components = append(snapshot.Components, cs)
+	}
+	sort.Slice(snapshot.Components, func(i, j int) bool {
+		return snapshot.Components[i].Name < snapshot.Components[j].Name
+	})
+	return snapshot, nil
+}
+
+func newRuleSnapshot(r *Rule, root string, key KeyFunc) (RuleSnapshot, error) {
+	rs := RuleSnapshot{
+		ID:       r.NodeID(),
+		Image:    r.Image(),
+		Native:   r.IsNative(),
+		Platform: r.Platform(),
+		Commands: []PlannedCommand{},
+		Requires: []string{},
+	}
+	for _, cmd := range r.Commands() {
+		rs.Commands = append(rs.Commands, PlannedCommand{
+			Kind:       cmd.Kind,
+			Argument:   cmd.Argument,
+			Attributes: cmd.Attributes,
+		})
+	}
+	inputs, err := r.Inputs()
+	if err != nil {
+		return rs, fmt.Errorf("Rule %s inputs: %s", r.NodeID(), err)
+	}
+	if rs.Inputs, err = inputs.RelativePaths(root); err != nil {
+		return rs, err
+	}
+	sort.Strings(rs.Inputs)
+	if rs.Outputs, err = r.Outputs().RelativePaths(root); err != nil {
+		return rs, err
+	}
+	for _, dep := range r.Dependencies() {
+		rs.Requires = append(rs.Requires, dep.NodeID())
+	}
+	sort.Strings(rs.Requires)
+	if key != nil {
+		if rs.Key, err = key(r); err != nil {
+			return rs, err
+		}
+	}
+	return rs, nil
+}
+
+// LoadSnapshot reads a Snapshot from a JSON file
+func LoadSnapshot(path string) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot %s: %s", path, err)
+	}
+	return &snapshot, nil
+}
+
+// DiffSnapshots returns the differences between two Snapshots, ordered by
+// component and rule. Keys are only compared if both Snapshots have them.
+func DiffSnapshots(old, new *Snapshot) []SnapshotChange {
+	changes := []SnapshotChange{}
+	var oldNames, newNames []string
+	oldComponents := map[string]ComponentSnapshot{}
+	for _, c := range old.Components {
+		oldComponents[c.Name] = c
+		oldNames = append(oldNames, c.Name)
+	}
+	newComponents := map[string]ComponentSnapshot{}
+	for _, c := range new.Components {
+		newComponents[c.Name] = c
+		newNames = append(newNames, c.Name)
+	}
+	for _, name := range sortedUnion(oldNames, newNames) {
+		oldC, inOld := oldComponents[name]
+		newC, inNew := newComponents[name]
+		switch {
+		case !inNew:
+			changes = append(changes, SnapshotChange{Item: name, Change: SnapshotRemoved})
+		case !inOld:
+			changes = append(changes, SnapshotChange{Item: name, Change: SnapshotAdded})
+		default:
+			changes = append(changes, diffComponents(oldC, newC)...)
+		}
+	}
+	return changes
+}
+
+func diffComponents(old, new ComponentSnapshot) []SnapshotChange {
+	changes := diffValue(old.Name+" kind", old.Kind, new.Kind)
+	changes = append(changes, diffValue(old.Name+" path", old.Path, new.Path)...)
+	var oldIDs, newIDs []string
+	oldRules := map[string]RuleSnapshot{}
+	for _, r := range old.Rules {
+		oldRules[r.ID] = r
+		oldIDs = append(oldIDs, r.ID)
+	}
+	newRules := map[string]RuleSnapshot{}
+	for _, r := range new.Rules {
+		newRules[r.ID] = r
+		newIDs = append(newIDs, r.ID)
+	}
+	for _, id := range sortedUnion(oldIDs, newIDs) {
+		oldR, inOld := oldRules[id]
+		newR, inNew := newRules[id]
+		switch {
+		case !inNew:
+			changes = append(changes, SnapshotChange{Item: id, Change: SnapshotRemoved})
+		case !inOld:
+			changes = append(changes, SnapshotChange{Item: id, Change: SnapshotAdded})
+		default:
+			changes = append(changes, diffRules(oldR, newR)...)
+		}
+	}
+	return changes
+}
+
+func diffRules(old, new RuleSnapshot) []SnapshotChange {
+	id := old.ID
+	var changes []SnapshotChange
+	if old.Key != "" && new.Key != "" {
+		changes = append(changes, diffValue(id+" key", old.Key, new.Key)...)
+	}
+	changes = append(changes, diffValue(id+" image", old.Image, new.Image)...)
+	changes = append(changes, diffValue(id+" native",
+		fmt.Sprint(old.Native), fmt.Sprint(new.Native))...)
+	changes = append(changes, diffValue(id+" platform", old.Platform, new.Platform)...)
+	changes = append(changes, diffList(id+" commands",
+		commandStrings(old.Commands), commandStrings(new.Commands), true)...)
+	changes = append(changes, diffList(id+" inputs", old.Inputs, new.Inputs, false)...)
+	changes = append(changes, diffList(id+" outputs", old.Outputs, new.Outputs, true)...)
+	changes = append(changes, diffList(id+" requires", old.Requires, new.Requires, false)...)
+	return changes
+}
+
+func diffValue(item, old, new string) []SnapshotChange {
+	if old == new {
+		return nil
+	}
+	return []SnapshotChange{{Item: item, Change: SnapshotChanged, Old: old, New: new}}
+}
+
+// diffList reports the items added to and removed from a list. If order
+// matters, a list with the same items in another order has changed.
+func diffList(item string, old, new []string, ordered bool) []SnapshotChange {
+	var changes []SnapshotChange
+	oldSet := map[string]bool{}
+	for _, s := range old {
+		oldSet[s] = true
+	}
+	newSet := map[string]bool{}
+	for _, s := range new {
+		newSet[s] = true
+	}
+	for _, s := range old {
+		if !newSet[s] {
+			changes = append(changes, SnapshotChange{Item: item, Change: SnapshotRemoved, Old: s})
+		}
+	}
+	for _, s := range new {
+		if !oldSet[s] {
+			changes = append(changes, SnapshotChange{Item: item, Change: SnapshotAdded, New: s})
+		}
+	}
+	if len(changes) == 0 && ordered && strings.Join(old, "\n") != strings.Join(new, "\n") {
+		changes = append(changes, SnapshotChange{
+			Item:   item,
+			Change: SnapshotChanged,
+			Old:    strings.Join(old, ", "),
+			New:    strings.Join(new, ", "),
+		})
+	}
+	return changes
+}
+
+// commandStrings returns a canonical string for each command
+func commandStrings(cmds []PlannedCommand) []string {
+	result := make([]string, 0, len(cmds))
+	for _, cmd := range cmds {
+		s := cmd.Kind
+		if cmd.Argument != "" {
+			s += ": " + cmd.Argument
+		}
+		if len(cmd.Attributes) > 0 {
+			// Map keys are sorted when encoding JSON
+			attrs, err := json.Marshal(cmd.Attributes)
+			if err != nil {
+				attrs = []byte(fmt.Sprint(cmd.Attributes))
+			}
+			s += " " + string(attrs)
+		}
+		result = append(result, s)
+	}
+	return result
+}
+
+// sortedUnion returns the strings found in either slice, sorted
+func sortedUnion(a, b []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, s := range append(append([]string{}, a...), b...) {
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
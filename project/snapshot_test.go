@@ -0,0 +1,118 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSnapshot(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+
+	snapshot, err := NewSnapshot(p, func(r *Rule) (string, error) {
+		return "key-" + r.NodeID(), nil
+	})
+	require.Nil(t, err)
+	require.Len(t, snapshot.Components, 1)
+
+	c := snapshot.Components[0]
+	require.Equal(t, "foo", c.Name)
+	require.Equal(t, "foo", c.Path)
+	require.NotEmpty(t, c.Rules)
+	for i, r := range c.Rules {
+		if i > 0 {
+			require.True(t, c.Rules[i-1].ID < r.ID)
+		}
+		require.Equal(t, "key-"+r.ID, r.Key)
+	}
+
+	// A snapshot is unchanged when loaded from a file
+	data, err := json.Marshal(snapshot)
+	require.Nil(t, err)
+	snapshotPath := path.Join(dir, "snapshot.json")
+	require.Nil(t, ioutil.WriteFile(snapshotPath, data, 0644))
+	loaded, err := LoadSnapshot(snapshotPath)
+	require.Nil(t, err)
+	require.Empty(t, DiffSnapshots(loaded, snapshot))
+}
+
+func TestDiffSnapshots(t *testing.T) {
+
+	old := &Snapshot{Components: []ComponentSnapshot{
+		{Name: "api", Path: "src/api", Rules: []RuleSnapshot{
+			{
+				ID:       "api.build",
+				Key:      "111",
+				Image:    "golang:1.14",
+				Commands: []PlannedCommand{{Kind: "run", Argument: "go build"}},
+				Inputs:   []string{"src/api/main.go"},
+				Outputs:  []string{"src/api/api"},
+			},
+			{ID: "api.test"},
+		}},
+		{Name: "web", Path: "src/web"},
+	}}
+	new := &Snapshot{Components: []ComponentSnapshot{
+		{Name: "api", Path: "src/api", Rules: []RuleSnapshot{
+			{
+				ID:       "api.build",
+				Key:      "222",
+				Image:    "golang:1.15",
+				Commands: []PlannedCommand{{Kind: "run", Argument: "go build"}},
+				Inputs:   []string{"src/api/main.go", "src/api/util.go"},
+				Outputs:  []string{"src/api/api"},
+			},
+			{ID: "api.lint"},
+		}},
+		{Name: "worker", Path: "src/worker"},
+	}}
+
+	require.Equal(t, []SnapshotChange{
+		{Item: "api.build key", Change: SnapshotChanged, Old: "111", New: "222"},
+		{Item: "api.build image", Change: SnapshotChanged, Old: "golang:1.14", New: "golang:1.15"},
+		{Item: "api.build inputs", Change: SnapshotAdded, New: "src/api/util.go"},
+		{Item: "api.lint", Change: SnapshotAdded},
+		{Item: "api.test", Change: SnapshotRemoved},
+		{Item: "web", Change: SnapshotRemoved},
+		{Item: "worker", Change: SnapshotAdded},
+	}, DiffSnapshots(old, new))
+
+	// Keys are ignored unless both snapshots have them, and the order of
+	// commands matters
+	new.Components[0].Rules[0].Key = ""
+	new.Components[0].Rules[0].Image = "golang:1.14"
+	new.Components[0].Rules[0].Inputs = old.Components[0].Rules[0].Inputs
+	new.Components[0].Rules[0].Commands = []PlannedCommand{
+		{Kind: "run", Argument: "go generate"},
+	}
+	changes := DiffSnapshots(old, new)
+	require.Equal(t, []SnapshotChange{
+		{Item: "api.build commands", Change: SnapshotRemoved, Old: "run: go build"},
+		{Item: "api.build commands", Change: SnapshotAdded, New: "run: go generate"},
+	}, changes[:2])
+}
@@ -35,4 +35,36 @@ const (
 
 	// Cached indicates the Rule artifact was cached
 	Cached
+
+	// Timeout indicates Rule execution was stopped after its timeout
+	Timeout
 )
+
+var codeNames = map[Code]string{
+	Error:              "error",
+	Skipped:            "skipped",
+	ExecError:          "exec-error",
+	MissingOutputError: "missing-output",
+	OK:                 "ok",
+	Cached:             "cached",
+	Timeout:            "timeout",
+}
+
+// String returns a short name for the Code, e.g. "cached"
+func (c Code) String() string {
+	if name, found := codeNames[c]; found {
+		return name
+	}
+	return "unknown"
+}
+
+// ParseCode returns the Code with the given short name, e.g. "cached", and a
+// boolean indicating whether the name is known
+func ParseCode(name string) (Code, bool) {
+	for code, codeName := range codeNames {
+		if codeName == name {
+			return code, true
+		}
+	}
+	return Error, false
+}
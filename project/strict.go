@@ -0,0 +1,179 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	glob "github.com/bmatcuk/doublestar"
+)
+
+// fileState identifies a version of a file without reading it
+type fileState struct {
+	size    int64
+	modTime time.Time
+}
+
+// StrictMode checks that Rule commands only write to the files they are
+// expected to: those in the Component directory, the declared outputs of
+// the Rule, and scratch locations shared by all Rules. The files in the
+// project are compared before and after each command. Only files under the
+// project root are checked, so writes elsewhere, such as to the system
+// temporary directory, go unnoticed. Since Rules run
+// concurrently, a write is allowed if it is expected of any Rule that is
+// running at the time, including Rules whose outputs are being restored
+// from the cache.
+type StrictMode struct {
+	mutex   sync.Mutex
+	root    string
+	scratch []string
+	skip    map[string]bool
+	active  map[*Rule]int
+}
+
+// NewStrictMode returns StrictMode for the Project. Scratch is a list of glob
+// patterns, relative to the project root, that any Rule may write to.
+func NewStrictMode(p *Project, scratch []string) *StrictMode {
+	root := p.RootAbsPath()
+	return &StrictMode{
+		root:    root,
+		scratch: scratch,
+		// Version control and Zim's own records are not checked
+		skip: map[string]bool{
+			filepath.Join(root, ".git"):             true,
+			filepath.Join(root, ".hg"):              true,
+			filepath.Join(p.ArtifactsDir(), ".zim"): true,
+			filepath.Clean(p.LogsDir()):             true,
+		},
+		active: map[*Rule]int{},
+	}
+}
+
+// Middleware marks Rules as active while they run in the wrapped Runner, so
+// that their writes are allowed while other Rules are checked. It belongs
+// above the cache middleware.
+func (s *StrictMode) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		s.begin(r)
+		defer s.end(r)
+		return runner.Run(ctx, r, opts)
+	})
+}
+
+func (s *StrictMode) begin(r *Rule) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.active[r]++
+}
+
+func (s *StrictMode) end(r *Rule) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.active[r]--; s.active[r] <= 0 {
+		delete(s.active, r)
+	}
+}
+
+// snapshot returns the state of every file in the project
+func (s *StrictMode) snapshot() (map[string]fileState, error) {
+	files := map[string]fileState{}
+	err := filepath.Walk(s.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files may be removed by running commands during the walk
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			if s.skip[p] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		files[p] = fileState{size: info.Size(), modTime: info.ModTime()}
+		return nil
+	})
+	return files, err
+}
+
+// check compares the files in the project against the snapshot taken before
+// a command of the Rule ran. An error listing the files that were created or
+// modified unexpectedly is returned if there are any.
+func (s *StrictMode) check(r *Rule, before map[string]fileState) error {
+	after, err := s.snapshot()
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var undeclared []string
+	for p, state := range after {
+		if previous, found := before[p]; found && previous == state {
+			continue
+		}
+		if !s.allowed(p) {
+			rel, err := filepath.Rel(s.root, p)
+			if err != nil {
+				rel = p
+			}
+			undeclared = append(undeclared, rel)
+		}
+	}
+	if len(undeclared) == 0 {
+		return nil
+	}
+	sort.Strings(undeclared)
+	return fmt.Errorf("Rule %s wrote to undeclared files: %s",
+		r.NodeID(), strings.Join(undeclared, ", "))
+}
+
+// allowed returns true if an active Rule is expected to write to the file
+func (s *StrictMode) allowed(p string) bool {
+	if rel, err := filepath.Rel(s.root, p); err == nil {
+		for _, pattern := range s.scratch {
+			if matched, _ := glob.Match(pattern, filepath.ToSlash(rel)); matched {
+				return true
+			}
+		}
+	}
+	for r := range s.active {
+		if withinDir(r.Component().Directory(), p) {
+			return true
+		}
+		for _, out := range r.Outputs() {
+			if out.OnFilesystem() && (out.Path() == p || withinDir(out.Path(), p)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// withinDir returns true if the path is within the directory
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." &&
+		!strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,83 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrictMode(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+	require.Nil(t, os.MkdirAll(path.Join(dir, "app"), 0755))
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Outputs: []string{"app.bin"},
+					Command: "mkdir -p $(dirname $OUTPUT) && echo built > $OUTPUT",
+				},
+				"notes": {
+					Command: "echo notes > notes.txt",
+				},
+				"scratch": {
+					Command: "mkdir -p ../.cache && echo cached > ../.cache/tool",
+				},
+				"leak": {
+					Command: "echo leaked > ../leak.txt",
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	c := p.Components().First()
+
+	runner := &StandardRunner{Strict: NewStrictMode(p, []string{".cache/**"})}
+	opts := RunOpts{Output: ioutil.Discard, DebugOutput: ioutil.Discard}
+	ctx := context.Background()
+
+	// Writes to outputs, the component directory, and scratch are allowed
+	for _, name := range []string{"build", "notes", "scratch"} {
+		code, err := runner.Run(ctx, c.MustRule(name), opts)
+		require.Nil(t, err, name)
+		require.Equal(t, OK, code, name)
+	}
+
+	code, err := runner.Run(ctx, c.MustRule("leak"), opts)
+	require.NotNil(t, err)
+	require.Equal(t, ExecError, code)
+	require.Equal(t, "Rule app.leak wrote to undeclared files: leak.txt", err.Error())
+}
+
+func TestWithinDir(t *testing.T) {
+	dir := filepath.Join("root", "app")
+	require.True(t, withinDir(dir, filepath.Join(dir, "main.go")))
+	require.True(t, withinDir(dir+string(filepath.Separator), filepath.Join(dir, "a", "b")))
+	require.False(t, withinDir(dir, dir))
+	require.False(t, withinDir(dir, filepath.Join("root", "application", "main.go")))
+	require.False(t, withinDir(dir, filepath.Join("root", "other.go")))
+}
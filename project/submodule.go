@@ -0,0 +1,136 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fugue/zim/vcs"
+)
+
+// SubmodulePrefix marks inputs that are whole submodules, e.g.
+// "submodule:../../vendor/lib", identified by their commit and changes
+// rather than by the contents of their files
+const SubmodulePrefix = "submodule:"
+
+// Submodule implements the Resource interface for a submodule, or another
+// repository nested within the project. Its hash is the commit checked out,
+// with a hash of any uncommitted changes appended.
+type Submodule struct {
+	root    string
+	relPath string
+}
+
+// NewSubmodule returns the Submodule at the given path relative to the
+// project root directory
+func NewSubmodule(root, relPath string) *Submodule {
+	return &Submodule{root: root, relPath: path.Clean(relPath)}
+}
+
+// submoduleInput returns the Submodule named by an input pattern relative
+// to the component, or nil if the pattern doesn't name a submodule
+func submoduleInput(c *Component, pattern string) *Submodule {
+	if !strings.HasPrefix(pattern, SubmodulePrefix) {
+		return nil
+	}
+	relPath := path.Join(c.RelPath(), strings.TrimPrefix(pattern, SubmodulePrefix))
+	return NewSubmodule(c.Project().RootAbsPath(), relPath)
+}
+
+// Directory returns the absolute path of the Submodule's working copy
+func (s *Submodule) Directory() string {
+	return filepath.Join(s.root, filepath.FromSlash(s.relPath))
+}
+
+// repo returns the working copy of the Submodule, which must be the root of
+// a repository
+func (s *Submodule) repo() (vcs.VCS, error) {
+	repo, err := vcs.Detect(s.Directory())
+	if err != nil {
+		return nil, err
+	}
+	if repo.Name() == "none" || repo.Root() != s.Directory() {
+		return nil, fmt.Errorf("submodule %s is not checked out", s.relPath)
+	}
+	return repo, nil
+}
+
+// OnFilesystem is false since the Submodule is identified by its commit
+func (s *Submodule) OnFilesystem() bool {
+	return false
+}
+
+// Cacheable is false since Submodules are only used as inputs
+func (s *Submodule) Cacheable() bool {
+	return false
+}
+
+// Name of the Resource
+func (s *Submodule) Name() string {
+	return path.Base(s.relPath)
+}
+
+// Path identifies the Submodule by its path relative to the project root,
+// so that it is the same on every machine
+func (s *Submodule) Path() string {
+	return SubmodulePrefix + s.relPath
+}
+
+// Exists indicates whether the Submodule is checked out
+func (s *Submodule) Exists() (bool, error) {
+	_, err := s.repo()
+	return err == nil, nil
+}
+
+// Hash returns the commit checked out. If there are uncommitted changes, a
+// hash of them is appended, e.g. "<commit>-dirty-<hash>".
+func (s *Submodule) Hash() (string, error) {
+	repo, err := s.repo()
+	if err != nil {
+		return "", err
+	}
+	commitID, err := repo.CommitID()
+	if err != nil {
+		return "", err
+	}
+	changes, err := repo.Changes()
+	if err != nil {
+		return "", err
+	}
+	if changes == "" {
+		return commitID, nil
+	}
+	return fmt.Sprintf("%s-dirty-%x", commitID, sha1.Sum([]byte(changes))), nil
+}
+
+// LastModified returns the modification time of the Submodule directory
+func (s *Submodule) LastModified() (time.Time, error) {
+	info, err := os.Stat(s.Directory())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// AsFile is not supported for Submodules
+func (s *Submodule) AsFile() (string, error) {
+	return "", errors.New("submodules cannot be represented as a file")
+}
@@ -0,0 +1,81 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubmodule(t *testing.T) {
+
+	root := testDir()
+	defer os.RemoveAll(root)
+
+	libDir := path.Join(root, "vendor", "lib")
+	require.Nil(t, os.MkdirAll(libDir, 0755))
+	require.Nil(t, ioutil.WriteFile(path.Join(libDir, "lib.go"), []byte("package lib"), 0644))
+
+	git := func(args ...string) string {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		command := osexec.Command("git", args...)
+		command.Dir = libDir
+		out, err := command.Output()
+		require.Nil(t, err)
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	commit := git("rev-parse", "HEAD")
+
+	s := NewSubmodule(root, "vendor/lib")
+	require.Equal(t, "submodule:vendor/lib", s.Path())
+	require.Equal(t, "lib", s.Name())
+	require.False(t, s.OnFilesystem())
+
+	exists, err := s.Exists()
+	require.Nil(t, err)
+	require.True(t, exists)
+
+	// A clean submodule is identified by its commit
+	hash, err := s.Hash()
+	require.Nil(t, err)
+	require.Equal(t, commit, hash)
+
+	// Uncommitted changes are included in the hash
+	require.Nil(t, ioutil.WriteFile(path.Join(libDir, "lib.go"), []byte("package lib // changed"), 0644))
+	dirty, err := s.Hash()
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(dirty, commit+"-dirty-"))
+
+	require.Nil(t, ioutil.WriteFile(path.Join(libDir, "lib.go"), []byte("package lib // again"), 0644))
+	dirtier, err := s.Hash()
+	require.Nil(t, err)
+	require.NotEqual(t, dirty, dirtier)
+
+	// A directory that isn't the root of a repository isn't checked out
+	missing := NewSubmodule(root, "vendor")
+	exists, err = missing.Exists()
+	require.Nil(t, err)
+	require.False(t, exists)
+	_, err = missing.Hash()
+	require.NotNil(t, err)
+}
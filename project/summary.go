@@ -0,0 +1,135 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RuleResult is the outcome of running one Rule
+type RuleResult struct {
+	Rule     string  `json:"rule"`
+	Code     string  `json:"code"`
+	Duration float64 `json:"duration"`
+	Error    string  `json:"error,omitempty"`
+	Warning  bool    `json:"warning,omitempty"`
+
+	// ReusedBytes is the size of the outputs restored from the cache
+	ReusedBytes int64 `json:"reused_bytes,omitempty"`
+}
+
+// Totals summarize a run as a whole. Times are in seconds.
+type Totals struct {
+	WallTime    float64 `json:"wall_time"`
+	RuleTime    float64 `json:"rule_time"`
+	Cached      int     `json:"cached"`
+	TimeSaved   float64 `json:"time_saved"`
+	BytesReused int64   `json:"bytes_reused"`
+}
+
+// Summary records the outcome of each Rule that runs. Use its Middleware in
+// a Chain to enable recording.
+type Summary struct {
+	mutex   sync.Mutex
+	results []RuleResult
+}
+
+// NewSummary returns an empty Summary
+func NewSummary() *Summary {
+	return &Summary{}
+}
+
+// Middleware records the outcome of Rules run by the wrapped Runner
+func (s *Summary) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		startedAt := time.Now()
+		code, err := runner.Run(ctx, r, opts)
+		result := RuleResult{
+			Rule:     r.NodeID(),
+			Code:     code.String(),
+			Duration: time.Since(startedAt).Seconds(),
+		}
+		if err != nil {
+			result.Error = err.Error()
+			result.Warning = IsAllowedFailure(err)
+		}
+		if code == Cached {
+			result.ReusedBytes = outputsSize(r)
+		}
+		s.mutex.Lock()
+		s.results = append(s.results, result)
+		s.mutex.Unlock()
+		return code, err
+	})
+}
+
+// Results returns the recorded results sorted by Rule
+func (s *Summary) Results() []RuleResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	results := make([]RuleResult, len(s.results))
+	copy(results, s.results)
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Rule < results[j].Rule
+	})
+	return results
+}
+
+// Counts returns the number of results with each code
+func (s *Summary) Counts() map[string]int {
+	counts := map[string]int{}
+	for _, result := range s.Results() {
+		counts[result.Code]++
+	}
+	return counts
+}
+
+// Totals returns the totals of the recorded results for a run that took the
+// given wall-clock time. The time saved by cache hits is estimated from the
+// average duration of each cached Rule when it executed, according to the
+// History, which may be nil.
+func (s *Summary) Totals(wallTime time.Duration, history *History) Totals {
+	totals := Totals{WallTime: wallTime.Seconds()}
+	for _, result := range s.Results() {
+		totals.RuleTime += result.Duration
+		if result.Code != Cached.String() {
+			continue
+		}
+		totals.Cached++
+		totals.BytesReused += result.ReusedBytes
+		if history != nil {
+			if h, found := history.ruleHistory(result.Rule); found {
+				totals.TimeSaved += h.AverageDuration().Seconds()
+			}
+		}
+	}
+	return totals
+}
+
+// outputsSize returns the total size of the Rule's outputs on the filesystem
+func outputsSize(r *Rule) int64 {
+	var size int64
+	for _, out := range r.Outputs() {
+		if !out.OnFilesystem() {
+			continue
+		}
+		if outSize, err := PathSize(out.Path()); err == nil {
+			size += outSize
+		}
+	}
+	return size
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+
+	c := &Component{name: "app"}
+	build := &Rule{component: c, name: "build"}
+	test := &Rule{component: c, name: "test"}
+
+	summary := NewSummary()
+	runner := summary.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			if r == test {
+				return ExecError, errors.New("tests failed")
+			}
+			return Cached, nil
+		}))
+
+	ctx := context.Background()
+	runner.Run(ctx, test, RunOpts{})
+	runner.Run(ctx, build, RunOpts{})
+
+	results := summary.Results()
+	require.Len(t, results, 2)
+	assert.Equal(t, "app.build", results[0].Rule)
+	assert.Equal(t, "cached", results[0].Code)
+	assert.Empty(t, results[0].Error)
+	assert.Equal(t, "app.test", results[1].Rule)
+	assert.Equal(t, "exec-error", results[1].Code)
+	assert.Equal(t, "tests failed", results[1].Error)
+	assert.Equal(t, map[string]int{"cached": 1, "exec-error": 1}, summary.Counts())
+}
+
+func TestSummaryTotals(t *testing.T) {
+
+	c := &Component{name: "app"}
+	build := &Rule{component: c, name: "build"}
+	test := &Rule{component: c, name: "test"}
+
+	history := &History{rules: map[string]*RuleHistory{
+		"app.build": {Runs: 3, Cached: 1, Executed: 2, Duration: 20 * time.Second},
+	}}
+	summary := NewSummary()
+	runner := summary.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			if r == build {
+				return Cached, nil
+			}
+			return OK, nil
+		}))
+
+	ctx := context.Background()
+	runner.Run(ctx, build, RunOpts{})
+	runner.Run(ctx, test, RunOpts{})
+
+	totals := summary.Totals(time.Minute, history)
+	assert.Equal(t, 60.0, totals.WallTime)
+	assert.Equal(t, 1, totals.Cached)
+	assert.Equal(t, 10.0, totals.TimeSaved)
+
+	// Without history the time saved is unknown
+	assert.Equal(t, 0.0, summary.Totals(time.Minute, nil).TimeSaved)
+}
@@ -0,0 +1,143 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// templateCallPattern matches function calls in variable substitutions,
+// e.g. "${basename(INPUT)}" or "${git_commit()}"
+var templateCallPattern = regexp.MustCompile(`\$\{([a-z_][a-z0-9_]*)\(([^(){}]*)\)\}`)
+
+// templateFunc computes the value of a function call given its arguments
+type templateFunc func(r *Rule, args []string) (string, error)
+
+// templateFuncs are the functions available in variable substitutions.
+// Arguments are variable names or quoted strings.
+var templateFuncs = map[string]struct {
+	arity int
+	fn    templateFunc
+}{
+	"basename": {1, func(r *Rule, args []string) (string, error) {
+		return filepath.Base(args[0]), nil
+	}},
+	"dirname": {1, func(r *Rule, args []string) (string, error) {
+		return filepath.Dir(args[0]), nil
+	}},
+	"upper": {1, func(r *Rule, args []string) (string, error) {
+		return strings.ToUpper(args[0]), nil
+	}},
+	"lower": {1, func(r *Rule, args []string) (string, error) {
+		return strings.ToLower(args[0]), nil
+	}},
+	"env": {1, func(r *Rule, args []string) (string, error) {
+		return os.Getenv(args[0]), nil
+	}},
+	"git_commit": {0, func(r *Rule, args []string) (string, error) {
+		return r.Project().CommitID()
+	}},
+}
+
+// templateArgs returns the values of the arguments of a function call.
+// Quoted arguments are literal strings and the rest are variable names,
+// except for env which takes the name of a process environment variable.
+func templateArgs(name, argList string, variables map[string]string) ([]string, error) {
+	var args []string
+	if strings.TrimSpace(argList) == "" {
+		return args, nil
+	}
+	for _, arg := range strings.Split(argList, ",") {
+		arg = strings.TrimSpace(arg)
+		if len(arg) >= 2 && (arg[0] == '"' || arg[0] == '\'') && arg[len(arg)-1] == arg[0] {
+			args = append(args, arg[1:len(arg)-1])
+			continue
+		}
+		if name == "env" {
+			args = append(args, arg)
+			continue
+		}
+		value, found := variables[arg]
+		if !found {
+			return nil, fmt.Errorf("unknown variable in %s(): %s", name, arg)
+		}
+		args = append(args, value)
+	}
+	return args, nil
+}
+
+// expandTemplateCalls replaces the function calls in the string with their
+// results. Plain variable references are left as they are.
+func expandTemplateCalls(r *Rule, s string, variables map[string]string) (string, error) {
+	var expandErr error
+	result := templateCallPattern.ReplaceAllStringFunc(s, func(call string) string {
+		if expandErr != nil {
+			return call
+		}
+		match := templateCallPattern.FindStringSubmatch(call)
+		name := match[1]
+		f, found := templateFuncs[name]
+		if !found {
+			expandErr = fmt.Errorf("unknown function in %s: %s", call, name)
+			return call
+		}
+		args, err := templateArgs(name, match[2], variables)
+		if err != nil {
+			expandErr = err
+			return call
+		}
+		if len(args) != f.arity {
+			expandErr = fmt.Errorf("%s() takes %d argument(s), got %d",
+				name, f.arity, len(args))
+			return call
+		}
+		value, err := f.fn(r, args)
+		if err != nil {
+			expandErr = err
+			return call
+		}
+		return value
+	})
+	return result, expandErr
+}
+
+// expandVars replaces function calls and then variable references in the
+// string, e.g. "${upper(KIND)}/${NAME}.zip" -> "GO/foo.zip"
+func expandVars(r *Rule, s string, variables map[string]string) (string, error) {
+	s, err := expandTemplateCalls(r, s, variables)
+	if err != nil {
+		return "", err
+	}
+	return substituteVars(s, variables), nil
+}
+
+// expandVarsSlice applies expandVars to each string in the slice
+func expandVarsSlice(r *Rule, strs []string, variables map[string]string) ([]string, error) {
+	if len(strs) == 0 {
+		return strs, nil
+	}
+	result := make([]string, len(strs))
+	for i, s := range strs {
+		value, err := expandVars(r, s, variables)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = value
+	}
+	return result, nil
+}
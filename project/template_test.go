@@ -0,0 +1,63 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/fugue/zim/definitions"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateFunctions(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	os.Setenv("ZIM_TEST_TARGET", "prod")
+	defer os.Unsetenv("ZIM_TEST_TARGET")
+
+	defs := []*definitions.Component{
+		{
+			Name: "app",
+			Kind: "go",
+			Path: path.Join(dir, "app", "component.yaml"),
+			Environment: map[string]string{
+				"CONFIG": "config/settings.yaml",
+			},
+			Rules: map[string]definitions.Rule{
+				"build": {
+					Inputs:  []string{"${dirname(CONFIG)}/*.yaml"},
+					Outputs: []string{"${upper(KIND)}-${env(ZIM_TEST_TARGET)}-${basename(CONFIG)}"},
+					Command: "echo ${lower('ABC')} ${NAME}",
+				},
+			},
+		},
+	}
+	p, err := NewWithOptions(Opts{Root: dir, ComponentDefs: defs})
+	require.Nil(t, err)
+	r, found := p.Components().First().Rule("build")
+	require.True(t, found)
+
+	require.Equal(t, path.Join(dir, "artifacts", "GO-prod-settings.yaml"), r.Outputs()[0].Path())
+	require.Equal(t, []string{"config/*.yaml"}, r.inputs)
+	require.Equal(t, "echo abc ${NAME}", r.Commands()[0].Argument)
+
+	for _, s := range []string{"${nope(KIND)}", "${upper(MISSING)}", "${upper()}"} {
+		_, err := expandVars(r, s, r.BaseEnvironment())
+		require.NotNil(t, err, s)
+	}
+}
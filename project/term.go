@@ -14,6 +14,8 @@
 package project
 
 import (
+	"os"
+
 	"github.com/fatih/color"
 )
 
@@ -41,3 +43,29 @@ func init() {
 	Red = color.New(color.FgRed).SprintFunc()
 	Yellow = color.New(color.FgYellow).SprintFunc()
 }
+
+// plainOutput is set when output must not vary between runs
+var plainOutput bool
+
+// SetPlainOutput disables colors and rule durations in output so that
+// running the same Rules prints the same text, e.g. for golden file tests
+func SetPlainOutput(plain bool) {
+	plainOutput = plain
+	if plain {
+		color.NoColor = true
+	}
+}
+
+// PlainOutput returns true if plain output is enabled
+func PlainOutput() bool {
+	return plainOutput
+}
+
+// IsTerminal returns true if the file is an interactive terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,163 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fugue/zim/definitions"
+)
+
+// Tool version managers that may activate a Component's tools
+const (
+	ToolManagerAsdf = "asdf"
+	ToolManagerMise = "mise"
+)
+
+// ToolVersionsFile is the name of the file listing pinned tool versions
+const ToolVersionsFile = ".tool-versions"
+
+// ToolVersions activates the tool versions pinned in a .tool-versions file
+// using asdf or mise before running a Component's native commands
+type ToolVersions struct {
+
+	// Manager is the tool version manager: asdf or mise
+	Manager string
+
+	// File is the absolute path to the .tool-versions file
+	File string
+
+	// Offline prevents the manager from downloading tools
+	Offline bool
+}
+
+// newToolVersions returns the tool versions configuration for a Component
+// definition, or nil if it doesn't specify a manager. If no file is given,
+// the nearest .tool-versions file in the Component directory or its parents
+// within the project is used. Both managers look for the file by name from
+// the directory commands run in, so a file that is given must be the nearest
+// one with its name.
+func newToolVersions(p *Project, componentDir string, def definitions.Tools) (*ToolVersions, error) {
+	if def.Manager == "" {
+		return nil, nil
+	}
+	if def.Manager != ToolManagerAsdf && def.Manager != ToolManagerMise {
+		return nil, fmt.Errorf("invalid tool manager %q (asdf | mise)", def.Manager)
+	}
+	tools := &ToolVersions{Manager: def.Manager}
+	name := ToolVersionsFile
+	var given string
+	if def.File != "" {
+		given = def.File
+		if !filepath.IsAbs(given) {
+			given = filepath.Join(componentDir, def.File)
+		}
+		given = filepath.Clean(given)
+		name = filepath.Base(given)
+	}
+	for dir := componentDir; ; dir = filepath.Dir(dir) {
+		path := filepath.Join(dir, name)
+		if fileExists(path) {
+			tools.File = path
+			break
+		}
+		if dir == p.RootAbsPath() || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	if given != "" && tools.File != given {
+		return nil, fmt.Errorf("tools file %s must be the nearest %s in %s or its parents",
+			def.File, name, componentDir)
+	}
+	if tools.File == "" {
+		return nil, fmt.Errorf("no %s file found for %s", ToolVersionsFile, componentDir)
+	}
+	return tools, nil
+}
+
+// command returns the manager command with the arguments, preceded by the
+// given variables and one naming the file if it isn't .tool-versions
+func (t *ToolVersions) command(env []string, args ...string) []string {
+	if name := filepath.Base(t.File); t.File != "" && name != ToolVersionsFile {
+		if t.Manager == ToolManagerMise {
+			env = append(env, "MISE_DEFAULT_TOOL_VERSIONS_FILENAME="+name)
+		} else {
+			env = append(env, "ASDF_DEFAULT_TOOL_VERSIONS_FILENAME="+name)
+		}
+	}
+	if len(env) == 0 {
+		return args
+	}
+	return append(append([]string{"env"}, env...), args...)
+}
+
+// Wrapper returns the command that runs its trailing arguments with the
+// pinned tool versions active
+func (t *ToolVersions) Wrapper() []string {
+	if t.Manager == ToolManagerMise {
+		var env []string
+		if t.Offline {
+			env = append(env, "MISE_OFFLINE=1")
+		}
+		return t.command(env, "mise", "exec", "--")
+	}
+	// The asdf shims select versions using the .tool-versions file found
+	// from the working directory. They are added to the PATH the command
+	// runs with, which may differ from that of zim.
+	dataDir := os.Getenv("ASDF_DATA_DIR")
+	if dataDir == "" {
+		home, _ := os.UserHomeDir()
+		dataDir = filepath.Join(home, ".asdf")
+	}
+	shims := filepath.Join(dataDir, "shims")
+	return t.command(nil, "sh", "-c", `export PATH="$0${PATH:+:$PATH}"; exec "$@"`, shims)
+}
+
+// InstallCommand returns a command that installs the tool versions. It is
+// run from the directory containing the tool versions file.
+func (t *ToolVersions) InstallCommand() []string {
+	if t.Manager == ToolManagerMise {
+		return t.command(nil, "mise", "install")
+	}
+	return t.command(nil, "asdf", "install")
+}
+
+// Versions returns the tool versions listed in the file. Where a tool lists
+// fallback versions, the first is returned.
+func (t *ToolVersions) Versions() (map[string]string, error) {
+	f, err := os.Open(t.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tool versions: %s", err)
+	}
+	defer f.Close()
+
+	versions := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		versions[fields[0]] = fields[1]
+	}
+	return versions, scanner.Err()
+}
@@ -0,0 +1,202 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxQueuedShown is the number of queued Rules named in the terminal view
+const maxQueuedShown = 3
+
+// TerminalView is middleware that shows a live view of a run in an
+// interactive terminal. Each running Rule is shown on its own line with its
+// elapsed time, followed by a count of the Rules done and cached and the
+// Rules still queued. A line is printed above the view as each Rule
+// finishes. Rule output is held as in buffered mode and is only shown if
+// the Rule fails.
+type TerminalView struct {
+	mutex    sync.Mutex
+	w        io.Writer
+	queued   []string
+	seen     map[string]bool
+	running  []string
+	started  map[string]time.Time
+	done     int
+	cached   int
+	drawn    int
+	now      func() time.Time
+	stop     chan struct{}
+	finished chan struct{}
+}
+
+// NewTerminalView returns a TerminalView that draws to the Writer. The
+// queued Rule IDs are those expected to run, in the order they may start.
+func NewTerminalView(w io.Writer, queued []string) *TerminalView {
+	return &TerminalView{
+		w:       w,
+		queued:  queued,
+		seen:    map[string]bool{},
+		started: map[string]time.Time{},
+		now:     time.Now,
+	}
+}
+
+// Start redraws the view at the given interval, so that elapsed times
+// advance while Rules run, until Stop is called
+func (v *TerminalView) Start(interval time.Duration) {
+	v.stop = make(chan struct{})
+	v.finished = make(chan struct{})
+	go func() {
+		defer close(v.finished)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-v.stop:
+				return
+			case <-ticker.C:
+				v.mutex.Lock()
+				v.redraw()
+				v.mutex.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop stops redrawing and erases the view, leaving the lines printed for
+// the Rules that finished
+func (v *TerminalView) Stop() {
+	if v.stop != nil {
+		close(v.stop)
+		<-v.finished
+		v.stop = nil
+	}
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.erase()
+}
+
+// Middleware tracks the Rules run by the wrapped Runner
+func (v *TerminalView) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		buffer := &bytes.Buffer{}
+		opts.Output = buffer
+		opts.DebugOutput = buffer
+
+		nodeID := r.NodeID()
+		v.mutex.Lock()
+		v.seen[nodeID] = true
+		v.running = append(v.running, nodeID)
+		v.started[nodeID] = v.now()
+		v.redraw()
+		v.mutex.Unlock()
+
+		code, err := runner.Run(ctx, r, opts)
+
+		v.mutex.Lock()
+		defer v.mutex.Unlock()
+		elapsed := v.now().Sub(v.started[nodeID])
+		delete(v.started, nodeID)
+		for i, id := range v.running {
+			if id == nodeID {
+				v.running = append(v.running[:i], v.running[i+1:]...)
+				break
+			}
+		}
+		v.done++
+		if code == Cached {
+			v.cached++
+		}
+		v.erase()
+		if err != nil {
+			if output := strings.TrimSpace(buffer.String()); output != "" {
+				fmt.Fprintln(v.w, output)
+			}
+		} else {
+			fmt.Fprintln(v.w, finishedLine(nodeID, code, elapsed))
+		}
+		v.draw()
+		return code, err
+	})
+}
+
+// finishedLine describes a Rule that finished without error
+func finishedLine(nodeID string, code Code, elapsed time.Duration) string {
+	switch code {
+	case Cached:
+		return fmt.Sprint("rule: ", Bright(nodeID), " ", Green("[CACHED]"))
+	case Skipped:
+		return fmt.Sprint("rule: ", Bright(nodeID), " ", Green("[SKIPPED]"))
+	default:
+		return fmt.Sprint("rule: ", Bright(nodeID), " ",
+			Bright("in "+FormatDuration(elapsed)), " ", Green("[OK]"))
+	}
+}
+
+// lines returns the lines of the view: one for each running Rule and a
+// status line
+func (v *TerminalView) lines() []string {
+	now := v.now()
+	var lines []string
+	for _, nodeID := range v.running {
+		lines = append(lines, fmt.Sprintf("  %s %s %s", Cyan("running"),
+			Bright(nodeID), FormatDuration(now.Sub(v.started[nodeID]))))
+	}
+	var queued []string
+	for _, nodeID := range v.queued {
+		if !v.seen[nodeID] {
+			queued = append(queued, nodeID)
+		}
+	}
+	total := v.done + len(v.running) + len(queued)
+	status := fmt.Sprintf("%d/%d done, %d cached", v.done, total, v.cached)
+	if len(queued) > maxQueuedShown {
+		status += fmt.Sprintf(", queued: %s +%d more",
+			strings.Join(queued[:maxQueuedShown], " "), len(queued)-maxQueuedShown)
+	} else if len(queued) > 0 {
+		status += ", queued: " + strings.Join(queued, " ")
+	}
+	return append(lines, Yellow(status))
+}
+
+// draw writes the view below the cursor
+func (v *TerminalView) draw() {
+	lines := v.lines()
+	for _, line := range lines {
+		fmt.Fprintln(v.w, line)
+	}
+	v.drawn = len(lines)
+}
+
+// erase moves the cursor up to where the view was drawn and clears the
+// rest of the screen
+func (v *TerminalView) erase() {
+	if v.drawn > 0 {
+		fmt.Fprintf(v.w, "\033[%dA\033[J", v.drawn)
+		v.drawn = 0
+	}
+}
+
+// redraw replaces the view with an up to date one
+func (v *TerminalView) redraw() {
+	v.erase()
+	v.draw()
+}
@@ -0,0 +1,72 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTerminalView(t *testing.T) {
+
+	SetPlainOutput(true)
+	defer SetPlainOutput(false)
+
+	var out bytes.Buffer
+	view := NewTerminalView(&out, []string{"app.build", "app.test", "app.lint"})
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	view.now = func() time.Time { return now }
+
+	var views [][]string
+	runner := NewChain(view.Middleware).Then(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			fmt.Fprintln(opts.Output, "output of", r.NodeID())
+			now = now.Add(2 * time.Second)
+			views = append(views, view.lines())
+			switch r.Name() {
+			case "test":
+				return Cached, nil
+			case "lint":
+				return ExecError, errors.New("lint failed")
+			}
+			return OK, nil
+		}))
+
+	c := &Component{name: "app"}
+	for _, name := range []string{"build", "test", "lint"} {
+		runner.Run(context.Background(), &Rule{component: c, name: name}, RunOpts{})
+	}
+	view.Stop()
+
+	require.Equal(t, [][]string{
+		{"  running app.build 2.0s", "0/3 done, 0 cached, queued: app.test app.lint"},
+		{"  running app.test 2.0s", "1/3 done, 0 cached, queued: app.lint"},
+		{"  running app.lint 2.0s", "2/3 done, 1 cached"},
+	}, views)
+
+	// Only the output of the failed rule is shown, and the view is erased
+	output := out.String()
+	require.Contains(t, output, "rule: app.build in 2.0s [OK]\n")
+	require.Contains(t, output, "rule: app.test [CACHED]\n")
+	require.Contains(t, output, "output of app.lint\n")
+	require.NotContains(t, output, "output of app.build")
+	require.True(t, strings.HasSuffix(output, "\033[1A\033[J"))
+}
@@ -0,0 +1,93 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/fugue/zim/exec"
+)
+
+// RuleUsage is the resource usage accumulated by one Rule
+type RuleUsage struct {
+	NodeID string
+	exec.Usage
+}
+
+// UsageRecorder accumulates the resources consumed by commands of each
+// Rule that runs. Use its Middleware in a Chain to enable accounting.
+type UsageRecorder struct {
+	mutex sync.Mutex
+	usage map[string]*exec.Usage
+}
+
+// NewUsageRecorder returns an empty UsageRecorder
+func NewUsageRecorder() *UsageRecorder {
+	return &UsageRecorder{usage: map[string]*exec.Usage{}}
+}
+
+// Middleware records the usage of Rules run by the wrapped Runner
+func (u *UsageRecorder) Middleware(runner Runner) Runner {
+	return RunnerFunc(func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+		usage := &exec.Usage{}
+		opts.Usage = usage
+
+		code, err := runner.Run(ctx, r, opts)
+
+		u.mutex.Lock()
+		defer u.mutex.Unlock()
+		if existing, found := u.usage[r.NodeID()]; found {
+			existing.Add(*usage)
+		} else {
+			u.usage[r.NodeID()] = usage
+		}
+		return code, err
+	})
+}
+
+// Rule returns the usage recorded for the given Rule
+func (u *UsageRecorder) Rule(r *Rule) (exec.Usage, bool) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	usage, found := u.usage[r.NodeID()]
+	if !found {
+		return exec.Usage{}, false
+	}
+	return *usage, true
+}
+
+// Rules returns the usage of all recorded Rules, sorted by Node ID
+func (u *UsageRecorder) Rules() []RuleUsage {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	result := make([]RuleUsage, 0, len(u.usage))
+	for nodeID, usage := range u.usage {
+		result = append(result, RuleUsage{NodeID: nodeID, Usage: *usage})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NodeID < result[j].NodeID
+	})
+	return result
+}
+
+// Total returns the combined usage of all recorded Rules
+func (u *UsageRecorder) Total() exec.Usage {
+	var total exec.Usage
+	for _, ru := range u.Rules() {
+		total.Add(ru.Usage)
+	}
+	return total
+}
@@ -0,0 +1,65 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/exec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageRecorder(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+
+	test, found := p.Rule("foo", "test")
+	require.True(t, found)
+	build, found := p.Rule("foo", "build")
+	require.True(t, found)
+
+	recorder := NewUsageRecorder()
+	runner := recorder.Middleware(RunnerFunc(
+		func(ctx context.Context, r *Rule, opts RunOpts) (Code, error) {
+			require.NotNil(t, opts.Usage)
+			opts.Usage.Add(exec.Usage{Wall: time.Second, MaxRSS: 100})
+			return OK, nil
+		}))
+
+	ctx := context.Background()
+	runner.Run(ctx, test, RunOpts{})
+	runner.Run(ctx, test, RunOpts{})
+	runner.Run(ctx, build, RunOpts{})
+
+	usage, found := recorder.Rule(test)
+	require.True(t, found)
+	assert.Equal(t, exec.Usage{Wall: 2 * time.Second, MaxRSS: 100}, usage)
+
+	rules := recorder.Rules()
+	require.Len(t, rules, 2)
+	assert.Equal(t, "foo.build", rules[0].NodeID)
+	assert.Equal(t, "foo.test", rules[1].NodeID)
+	assert.Equal(t, exec.Usage{Wall: 3 * time.Second, MaxRSS: 100}, recorder.Total())
+}
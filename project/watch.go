@@ -0,0 +1,214 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/fugue/zim/graph"
+)
+
+// DefaultDebounce is how long to wait for file changes to settle before
+// running affected Rules
+const DefaultDebounce = 300 * time.Millisecond
+
+// WatchOpts configures watching Rule inputs
+type WatchOpts struct {
+
+	// Load returns the Rules to watch. It is called before each run so
+	// that changes to Component definitions are picked up.
+	Load func() ([]*Rule, error)
+
+	// Run the given Rules, which are those affected by changed files.
+	// It is responsible for reporting any errors.
+	Run func(ctx context.Context, rules []*Rule)
+
+	// Debounce is how long to wait for file changes to settle
+	Debounce time.Duration
+}
+
+// inputIndex maps input file paths to the watched Rules that depend on them,
+// directly or via a dependency
+type inputIndex map[string][]*Rule
+
+// newInputIndex returns an index of the inputs of the given Rules and the
+// Rules they depend on
+func newInputIndex(rules []*Rule) (inputIndex, error) {
+	index := inputIndex{}
+	for _, target := range rules {
+		var visitErr error
+		GraphFromRules([]*Rule{target}).Visit(func(n graph.Node) bool {
+			inputs, err := n.(*Rule).Inputs()
+			if err != nil {
+				visitErr = err
+				return false
+			}
+			for _, input := range inputs {
+				if !input.OnFilesystem() {
+					continue
+				}
+				p := input.Path()
+				if !containsRule(index[p], target) {
+					index[p] = append(index[p], target)
+				}
+			}
+			return true
+		})
+		if visitErr != nil {
+			return nil, visitErr
+		}
+	}
+	return index, nil
+}
+
+// directories returns the directories to watch for changes to the inputs
+func (index inputIndex) directories(rules []*Rule) []string {
+	dirs := map[string]bool{}
+	for p := range index {
+		dirs[filepath.Dir(p)] = true
+	}
+	// Watching Component directories notices new files in them
+	for _, r := range rules {
+		dirs[r.Component().Directory()] = true
+	}
+	var result []string
+	for dir := range dirs {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// affectedRules returns the Rules that use any of the changed paths as
+// inputs, before or after the change. Comparing both indexes accounts for
+// files that were created or removed.
+func affectedRules(before, after inputIndex, changed []string) []*Rule {
+	var result []*Rule
+	for _, p := range changed {
+		for _, index := range []inputIndex{before, after} {
+			for _, r := range index[p] {
+				if !containsRule(result, r) {
+					result = append(result, r)
+				}
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].NodeID() < result[j].NodeID()
+	})
+	return result
+}
+
+func containsRule(rules []*Rule, rule *Rule) bool {
+	for _, r := range rules {
+		if r == rule || r.NodeID() == rule.NodeID() {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch runs the loaded Rules and then watches their inputs, running the
+// affected Rules again whenever inputs change. It returns when the context
+// is canceled or if watching fails. Failures loading Rules after a change
+// are printed while watching continues.
+func Watch(ctx context.Context, opts WatchOpts) error {
+
+	if opts.Debounce <= 0 {
+		opts.Debounce = DefaultDebounce
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	watched := map[string]bool{}
+	load := func() ([]*Rule, inputIndex, error) {
+		rules, err := opts.Load()
+		if err != nil {
+			return nil, nil, err
+		}
+		index, err := newInputIndex(rules)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, dir := range index.directories(rules) {
+			if watched[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				return nil, nil, err
+			}
+			watched[dir] = true
+		}
+		return rules, index, nil
+	}
+
+	rules, index, err := load()
+	if err != nil {
+		return err
+	}
+	opts.Run(ctx, rules)
+
+	changed := map[string]bool{}
+	timer := time.NewTimer(opts.Debounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-watcher.Errors:
+			return err
+		case event := <-watcher.Events:
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			changed[event.Name] = true
+			timer.Reset(opts.Debounce)
+		case <-timer.C:
+			var paths []string
+			for p := range changed {
+				paths = append(paths, p)
+			}
+			changed = map[string]bool{}
+
+			rules, after, err := load()
+			if err != nil {
+				// Keep watching so the problem can be fixed
+				fmt.Fprintln(os.Stderr, Red(fmt.Sprintf("Failed to load rules: %s", err)))
+				continue
+			}
+			affected := affectedRules(index, after, paths)
+			index = after
+			if len(affected) > 0 {
+				// Run using the Rules as loaded after the change
+				var targets []*Rule
+				for _, r := range rules {
+					if containsRule(affected, r) {
+						targets = append(targets, r)
+					}
+				}
+				opts.Run(ctx, targets)
+			}
+		}
+	}
+}
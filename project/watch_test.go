@@ -0,0 +1,127 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package project
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ruleIDs(rules []*Rule) (ids []string) {
+	for _, r := range rules {
+		ids = append(ids, r.NodeID())
+	}
+	return
+}
+
+func TestAffectedRules(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	testComponent(dir, "bar", testCompBar, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+
+	fooTest, _ := p.Rule("foo", "test")
+	barBuild, _ := p.Rule("bar", "build")
+	rules := []*Rule{fooTest, barBuild}
+
+	before, err := newInputIndex(rules)
+	require.Nil(t, err)
+	fooMain := path.Join(dir, "foo", "main.go")
+	barMain := path.Join(dir, "bar", "main.go")
+
+	// bar.build depends on foo.build which depends on foo.test
+	assert.Equal(t, []string{"bar.build", "foo.test"},
+		ruleIDs(affectedRules(before, before, []string{fooMain})))
+	assert.Equal(t, []string{"bar.build"},
+		ruleIDs(affectedRules(before, before, []string{barMain})))
+	assert.Empty(t, affectedRules(before, before, []string{path.Join(dir, "README")}))
+
+	// Removed inputs are found in the index from before the change
+	require.Nil(t, os.Remove(barMain))
+	after, err := newInputIndex(rules)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"bar.build"},
+		ruleIDs(affectedRules(before, after, []string{barMain})))
+
+	// Created inputs are found in the index from after the change
+	require.Nil(t, writeFile(barMain, testGoMain))
+	again, err := newInputIndex(rules)
+	require.Nil(t, err)
+	assert.Equal(t, []string{"bar.build"},
+		ruleIDs(affectedRules(after, again, []string{barMain})))
+
+	assert.Contains(t, before.directories(rules), path.Join(dir, "foo"))
+}
+
+func TestWatch(t *testing.T) {
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	testComponent(dir, "foo", testCompFoo, map[string]string{
+		"main.go": testGoMain,
+	})
+	p, err := New(dir)
+	require.Nil(t, err)
+	fooTest, _ := p.Rule("foo", "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	runs := make(chan []string, 10)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, WatchOpts{
+			Load: func() ([]*Rule, error) {
+				return []*Rule{fooTest}, nil
+			},
+			Run: func(ctx context.Context, rules []*Rule) {
+				runs <- ruleIDs(rules)
+			},
+			Debounce: 10 * time.Millisecond,
+		})
+	}()
+
+	// Rules run once at startup and again after an input changes
+	select {
+	case ids := <-runs:
+		assert.Equal(t, []string{"foo.test"}, ids)
+	case <-time.After(5 * time.Second):
+		t.Fatal("initial run timed out")
+	}
+	require.Nil(t, writeFile(path.Join(dir, "foo", "main.go"), "package main"))
+	select {
+	case ids := <-runs:
+		assert.Equal(t, []string{"foo.test"}, ids)
+	case <-time.After(5 * time.Second):
+		t.Fatal("run after change timed out")
+	}
+
+	cancel()
+	require.Nil(t, <-done)
+}
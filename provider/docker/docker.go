@@ -0,0 +1,240 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fugue/zim/project"
+)
+
+// Name identifies the Docker Provider in rule configuration
+const Name = "docker"
+
+// scheme is the prefix of image URIs
+const scheme = "docker://"
+
+// runFunc runs a container runtime command and returns its standard output
+type runFunc func(runtime string, args ...string) ([]byte, error)
+
+// imageNotFound is returned by a runFunc when an image does not exist
+type imageNotFound string
+
+func (e imageNotFound) Error() string { return string(e) }
+
+// Provider implements project.Provider for images in the local image store
+// of a container runtime. Resources are identified by URIs of the form
+// docker://repository:tag. Images are cacheable: they are saved to an
+// archive when written to the cache and loaded from it on a cache hit.
+type Provider struct {
+	runtime string
+	mutex   sync.Mutex
+	run     runFunc
+}
+
+// New returns a Provider for images of the given container runtime, which
+// is "docker" if empty
+func New(runtime string) *Provider {
+	if runtime == "" {
+		runtime = "docker"
+	}
+	return &Provider{runtime: runtime, run: runCommand}
+}
+
+// Init accepts configuration options from Project configuration. The
+// "runtime" option selects the container runtime that holds the images.
+func (p *Provider) Init(opts map[string]interface{}) error {
+	runtime, found := opts["runtime"]
+	if !found {
+		return nil
+	}
+	s, ok := runtime.(string)
+	if !ok || s == "" {
+		return fmt.Errorf("docker provider runtime must be a string")
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.runtime = s
+	return nil
+}
+
+// Name identifies the type of the Docker Provider
+func (p *Provider) Name() string {
+	return Name
+}
+
+// New returns an Image Resource given its URI
+func (p *Provider) New(uri string) project.Resource {
+	return &Image{provider: p, ref: strings.TrimPrefix(uri, scheme)}
+}
+
+// Match images by URI. Patterns are not supported, so the result contains
+// the image if it exists.
+func (p *Provider) Match(pattern string) (project.Resources, error) {
+	if !strings.HasPrefix(pattern, scheme) || len(pattern) == len(scheme) {
+		return nil, fmt.Errorf("invalid docker uri: %s", pattern)
+	}
+	img := p.New(pattern)
+	exists, err := img.Exists()
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return project.Resources{}, nil
+	}
+	return project.Resources{img}, nil
+}
+
+// command runs the container runtime with the given arguments
+func (p *Provider) command(args ...string) ([]byte, error) {
+	p.mutex.Lock()
+	runtime := p.runtime
+	p.mutex.Unlock()
+	return p.run(runtime, args...)
+}
+
+// runCommand runs a container runtime command. A failed image inspection
+// is reported as imageNotFound.
+func runCommand(runtime string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := osexec.Command(runtime, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		if _, exited := err.(*osexec.ExitError); exited && len(args) > 1 && args[1] == "inspect" {
+			return nil, imageNotFound(msg)
+		}
+		return nil, fmt.Errorf("%s %s failed: %s", runtime, args[0], msg)
+	}
+	return stdout.Bytes(), nil
+}
+
+// Image is a Resource representing a container image
+type Image struct {
+	provider *Provider
+	ref      string
+}
+
+// inspect returns the ID and creation time of the image, or an empty ID if
+// it does not exist
+func (img *Image) inspect() (string, time.Time, error) {
+	out, err := img.provider.command("image", "inspect",
+		"--format", "{{.Id}} {{.Created}}", img.ref)
+	if err != nil {
+		if _, ok := err.(imageNotFound); ok {
+			return "", time.Time{}, nil
+		}
+		return "", time.Time{}, err
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 2 {
+		return "", time.Time{}, fmt.Errorf("unexpected inspect output for %s: %q",
+			img.ref, strings.TrimSpace(string(out)))
+	}
+	created, err := time.Parse(time.RFC3339Nano, fields[1])
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("invalid creation time for %s: %s", img.ref, err)
+	}
+	return fields[0], created, nil
+}
+
+// OnFilesystem is false for images
+func (img *Image) OnFilesystem() bool {
+	return false
+}
+
+// Cacheable is true since images can be saved to and loaded from an archive
+func (img *Image) Cacheable() bool {
+	return true
+}
+
+// Name of the Resource, which is the image reference
+func (img *Image) Name() string {
+	return img.ref
+}
+
+// Path returns the URI of the image
+func (img *Image) Path() string {
+	return scheme + img.ref
+}
+
+// Exists indicates whether the image is present in the local image store
+func (img *Image) Exists() (bool, error) {
+	id, _, err := img.inspect()
+	if err != nil {
+		return false, err
+	}
+	return id != "", nil
+}
+
+// Hash of the image, which is its ID
+func (img *Image) Hash() (string, error) {
+	id, _, err := img.inspect()
+	if err != nil {
+		return "", err
+	}
+	if id == "" {
+		return "", fmt.Errorf("image not found: %s", img.ref)
+	}
+	return id, nil
+}
+
+// LastModified returns the time the image was created
+func (img *Image) LastModified() (time.Time, error) {
+	id, created, err := img.inspect()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if id == "" {
+		return time.Time{}, fmt.Errorf("image not found: %s", img.ref)
+	}
+	return created, nil
+}
+
+// AsFile saves the image to a temporary archive and returns its path
+func (img *Image) AsFile() (string, error) {
+	f, err := ioutil.TempFile("", "zim-image-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	if err := img.Export(f.Name()); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// Export saves the image to an archive at the given path
+func (img *Image) Export(dst string) error {
+	_, err := img.provider.command("image", "save", "-o", dst, img.ref)
+	return err
+}
+
+// Import loads the image from an archive created by Export
+func (img *Image) Import(src string) error {
+	_, err := img.provider.command("image", "load", "-i", src)
+	return err
+}
@@ -0,0 +1,116 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package docker
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRuntime records commands and keeps images in memory
+type fakeRuntime struct {
+	images   map[string]string
+	commands []string
+}
+
+func (f *fakeRuntime) run(runtime string, args ...string) ([]byte, error) {
+	f.commands = append(f.commands, runtime+" "+strings.Join(args, " "))
+	switch args[1] {
+	case "inspect":
+		ref := args[len(args)-1]
+		id, found := f.images[ref]
+		if !found {
+			return nil, imageNotFound("No such image: " + ref)
+		}
+		return []byte(id + " 2020-06-01T12:30:00.123456789Z\n"), nil
+	case "save":
+		return nil, ioutil.WriteFile(args[3], []byte(f.images[args[4]]), 0644)
+	case "load":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unexpected command: %v", args)
+}
+
+func TestImage(t *testing.T) {
+
+	fake := &fakeRuntime{images: map[string]string{"app:1.0": "sha256:abc"}}
+	p := New("podman")
+	p.run = fake.run
+
+	img := p.New("docker://app:1.0")
+	require.Equal(t, "docker://app:1.0", img.Path())
+	require.Equal(t, "app:1.0", img.Name())
+	require.False(t, img.OnFilesystem())
+	require.True(t, img.Cacheable())
+
+	exists, err := img.Exists()
+	require.Nil(t, err)
+	require.True(t, exists)
+
+	hash, err := img.Hash()
+	require.Nil(t, err)
+	require.Equal(t, "sha256:abc", hash)
+
+	modified, err := img.LastModified()
+	require.Nil(t, err)
+	require.Equal(t, time.Date(2020, 6, 1, 12, 30, 0, 123456789, time.UTC), modified)
+
+	missing := p.New("docker://app:2.0")
+	exists, err = missing.Exists()
+	require.Nil(t, err)
+	require.False(t, exists)
+	_, err = missing.Hash()
+	require.NotNil(t, err)
+
+	path, err := img.AsFile()
+	require.Nil(t, err)
+	data, err := ioutil.ReadFile(path)
+	require.Nil(t, err)
+	require.Equal(t, "sha256:abc", string(data))
+
+	require.Nil(t, img.(*Image).Import(path))
+	require.Equal(t, "podman image load -i "+path, fake.commands[len(fake.commands)-1])
+}
+
+func TestMatch(t *testing.T) {
+
+	fake := &fakeRuntime{images: map[string]string{"app:1.0": "sha256:abc"}}
+	p := New("")
+	p.run = fake.run
+
+	found, err := p.Match("docker://app:1.0")
+	require.Nil(t, err)
+	require.Len(t, found, 1)
+	require.Equal(t, "docker image inspect --format {{.Id}} {{.Created}} app:1.0", fake.commands[0])
+
+	found, err = p.Match("docker://app:2.0")
+	require.Nil(t, err)
+	require.Empty(t, found)
+
+	_, err = p.Match("app:1.0")
+	require.NotNil(t, err)
+}
+
+func TestInit(t *testing.T) {
+
+	p := New("docker")
+	require.Nil(t, p.Init(map[string]interface{}{"runtime": "nerdctl"}))
+	require.Equal(t, "nerdctl", p.runtime)
+	require.NotNil(t, p.Init(map[string]interface{}{"runtime": 5}))
+}
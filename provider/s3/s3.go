@@ -0,0 +1,310 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package s3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	glob "github.com/bmatcuk/doublestar"
+	"github.com/fugue/zim/project"
+)
+
+// Name identifies the S3 Provider in rule configuration
+const Name = "s3"
+
+// scheme is the prefix of S3 object URIs
+const scheme = "s3://"
+
+// Provider implements project.Provider for objects in S3. Resources are
+// identified by URIs of the form s3://bucket/key. The AWS session is only
+// created when the first request is made.
+type Provider struct {
+	region string
+	mutex  sync.Mutex
+	client s3iface.S3API
+}
+
+// New returns a Provider for S3 objects in the given AWS region. The region
+// is taken from the AWS configuration if it is empty.
+func New(region string) *Provider {
+	return &Provider{region: region}
+}
+
+// NewWithClient returns a Provider that uses the given S3 client
+func NewWithClient(client s3iface.S3API) *Provider {
+	return &Provider{client: client}
+}
+
+// Init accepts configuration options from Project configuration. The
+// "region" option selects the AWS region of the buckets.
+func (p *Provider) Init(opts map[string]interface{}) error {
+	region, found := opts["region"]
+	if !found {
+		return nil
+	}
+	s, ok := region.(string)
+	if !ok {
+		return fmt.Errorf("s3 provider region must be a string")
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.region = s
+	return nil
+}
+
+// Name identifies the type of the S3 Provider
+func (p *Provider) Name() string {
+	return Name
+}
+
+// New returns an Object Resource given its URI
+func (p *Provider) New(uri string) project.Resource {
+	bucket, key := splitURI(uri)
+	return &Object{provider: p, bucket: bucket, key: key}
+}
+
+// Match objects by URI. The key may contain glob patterns, which are
+// matched against the objects listed under the key's leading literal part.
+func (p *Provider) Match(pattern string) (project.Resources, error) {
+	if !strings.HasPrefix(pattern, scheme) {
+		return nil, fmt.Errorf("invalid s3 uri: %s", pattern)
+	}
+	bucket, key := splitURI(pattern)
+	if bucket == "" || key == "" {
+		return nil, fmt.Errorf("invalid s3 uri: %s", pattern)
+	}
+	if !strings.ContainsAny(key, "*?[{") {
+		obj := &Object{provider: p, bucket: bucket, key: key}
+		exists, err := obj.Exists()
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return project.Resources{}, nil
+		}
+		return project.Resources{obj}, nil
+	}
+	client, err := p.connect()
+	if err != nil {
+		return nil, err
+	}
+	prefix := key[:strings.IndexAny(key, "*?[{")]
+	var keys []string
+	var matchErr error
+	err = client.ListObjectsV2PagesWithContext(context.Background(),
+		&awss3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		},
+		func(page *awss3.ListObjectsV2Output, lastPage bool) bool {
+			for _, item := range page.Contents {
+				matched, err := glob.Match(key, *item.Key)
+				if err != nil {
+					matchErr = err
+					return false
+				}
+				if matched {
+					keys = append(keys, *item.Key)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %s", pattern, err)
+	}
+	if matchErr != nil {
+		return nil, fmt.Errorf("failed to match resources %s: %s", pattern, matchErr)
+	}
+	rs := make(project.Resources, 0, len(keys))
+	for _, k := range keys {
+		rs = append(rs, &Object{provider: p, bucket: bucket, key: k})
+	}
+	return rs, nil
+}
+
+// connect creates the S3 client if it doesn't exist yet
+func (p *Provider) connect() (s3iface.S3API, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.client != nil {
+		return p.client, nil
+	}
+	cfg := aws.NewConfig().WithMaxRetries(8)
+	if p.region != "" {
+		cfg = cfg.WithRegion(p.region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p.client = awss3.New(sess)
+	return p.client, nil
+}
+
+// splitURI returns the bucket and key of an S3 URI
+func splitURI(uri string) (bucket, key string) {
+	parts := strings.SplitN(strings.TrimPrefix(uri, scheme), "/", 2)
+	if len(parts) < 2 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+// isNotFound returns true if the error indicates the object does not exist
+func isNotFound(err error) bool {
+	if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "NotFound", awss3.ErrCodeNoSuchKey:
+			return true
+		}
+	}
+	return false
+}
+
+// Object implements the Resource interface for an S3 object. Its metadata
+// is requested each time it is needed, since rules may change the object.
+type Object struct {
+	provider *Provider
+	bucket   string
+	key      string
+}
+
+// head returns the object's metadata, or nil if it does not exist
+func (o *Object) head() (*awss3.HeadObjectOutput, error) {
+	client, err := o.provider.connect()
+	if err != nil {
+		return nil, err
+	}
+	output, err := client.HeadObjectWithContext(context.Background(),
+		&awss3.HeadObjectInput{
+			Bucket: aws.String(o.bucket),
+			Key:    aws.String(o.key),
+		})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to head %s: %s", o.Path(), err)
+	}
+	return output, nil
+}
+
+// OnFilesystem is false for S3 objects
+func (o *Object) OnFilesystem() bool {
+	return false
+}
+
+// Cacheable is false since objects already live in shared storage
+func (o *Object) Cacheable() bool {
+	return false
+}
+
+// Name of the Resource
+func (o *Object) Name() string {
+	return path.Base(o.key)
+}
+
+// Path returns the URI of the object
+func (o *Object) Path() string {
+	return scheme + o.bucket + "/" + o.key
+}
+
+// Exists indicates whether the object currently exists
+func (o *Object) Exists() (bool, error) {
+	output, err := o.head()
+	if err != nil {
+		return false, err
+	}
+	return output != nil, nil
+}
+
+// Hash of the object, which is its ETag. The version ID is included when
+// the bucket is versioned.
+func (o *Object) Hash() (string, error) {
+	output, err := o.head()
+	if err != nil {
+		return "", err
+	}
+	if output == nil || output.ETag == nil {
+		return "", fmt.Errorf("object not found: %s", o.Path())
+	}
+	hash := strings.Trim(*output.ETag, `"`)
+	if output.VersionId != nil && *output.VersionId != "" && *output.VersionId != "null" {
+		hash += ":" + *output.VersionId
+	}
+	return hash, nil
+}
+
+// LastModified time of the object
+func (o *Object) LastModified() (time.Time, error) {
+	output, err := o.head()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if output == nil || output.LastModified == nil {
+		return time.Time{}, fmt.Errorf("object not found: %s", o.Path())
+	}
+	return *output.LastModified, nil
+}
+
+// AsFile downloads the object to a temporary file and returns its path
+func (o *Object) AsFile() (string, error) {
+	client, err := o.provider.connect()
+	if err != nil {
+		return "", err
+	}
+	output, err := client.GetObjectWithContext(context.Background(),
+		&awss3.GetObjectInput{
+			Bucket: aws.String(o.bucket),
+			Key:    aws.String(o.key),
+		})
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %s", o.Path(), err)
+	}
+	defer output.Body.Close()
+	f, err := ioutil.TempFile("", "zim-s3-")
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, output.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
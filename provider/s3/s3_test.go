@@ -0,0 +1,147 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package s3
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeObject struct {
+	etag     string
+	modified time.Time
+}
+
+type fakeS3 struct {
+	s3iface.S3API
+	objects map[string]fakeObject
+	heads   int
+}
+
+func (f *fakeS3) HeadObjectWithContext(ctx aws.Context, input *awss3.HeadObjectInput, opts ...request.Option) (*awss3.HeadObjectOutput, error) {
+	f.heads++
+	obj, found := f.objects[*input.Bucket+"/"+*input.Key]
+	if !found {
+		return nil, awserr.NewRequestFailure(
+			awserr.New("NotFound", "Not Found", nil), http.StatusNotFound, "")
+	}
+	return &awss3.HeadObjectOutput{
+		ETag:         aws.String(`"` + obj.etag + `"`),
+		LastModified: aws.Time(obj.modified),
+	}, nil
+}
+
+func (f *fakeS3) ListObjectsV2PagesWithContext(ctx aws.Context, input *awss3.ListObjectsV2Input, fn func(*awss3.ListObjectsV2Output, bool) bool, opts ...request.Option) error {
+	var keys []string
+	for k := range f.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	page := &awss3.ListObjectsV2Output{}
+	prefix := *input.Bucket + "/" + *input.Prefix
+	for _, k := range keys {
+		if len(k) >= len(prefix) && k[:len(prefix)] == prefix {
+			page.Contents = append(page.Contents,
+				&awss3.Object{Key: aws.String(k[len(*input.Bucket)+1:])})
+		}
+	}
+	fn(page, true)
+	return nil
+}
+
+func TestObject(t *testing.T) {
+	modified := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	client := &fakeS3{objects: map[string]fakeObject{
+		"data/raw/2020/day1.csv": {etag: "abc", modified: modified},
+	}}
+	p := NewWithClient(client)
+	require.Equal(t, "s3", p.Name())
+
+	obj := p.New("s3://data/raw/2020/day1.csv")
+	require.Equal(t, "day1.csv", obj.Name())
+	require.Equal(t, "s3://data/raw/2020/day1.csv", obj.Path())
+	require.False(t, obj.OnFilesystem())
+	require.False(t, obj.Cacheable())
+
+	exists, err := obj.Exists()
+	require.Nil(t, err)
+	require.True(t, exists)
+
+	hash, err := obj.Hash()
+	require.Nil(t, err)
+	require.Equal(t, "abc", hash)
+
+	lastModified, err := obj.LastModified()
+	require.Nil(t, err)
+	require.Equal(t, modified, lastModified)
+
+	missing := p.New("s3://data/raw/2020/day2.csv")
+	exists, err = missing.Exists()
+	require.Nil(t, err)
+	require.False(t, exists)
+	_, err = missing.Hash()
+	require.NotNil(t, err)
+}
+
+func TestMatch(t *testing.T) {
+	client := &fakeS3{objects: map[string]fakeObject{
+		"data/raw/2020/day1.csv":  {etag: "a"},
+		"data/raw/2020/day2.csv":  {etag: "b"},
+		"data/raw/2020/notes.txt": {etag: "c"},
+		"data/raw/2021/day1.csv":  {etag: "d"},
+	}}
+	p := NewWithClient(client)
+
+	matches, err := p.Match("s3://data/raw/2020/*.csv")
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"s3://data/raw/2020/day1.csv",
+		"s3://data/raw/2020/day2.csv",
+	}, matches.Paths())
+
+	matches, err = p.Match("s3://data/raw/**/day1.csv")
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"s3://data/raw/2020/day1.csv",
+		"s3://data/raw/2021/day1.csv",
+	}, matches.Paths())
+
+	// Patterns without wildcards match a single object if it exists
+	matches, err = p.Match("s3://data/raw/2020/notes.txt")
+	require.Nil(t, err)
+	require.Len(t, matches, 1)
+
+	matches, err = p.Match("s3://data/raw/2020/missing.txt")
+	require.Nil(t, err)
+	require.Len(t, matches, 0)
+
+	_, err = p.Match("data/raw/*.csv")
+	require.NotNil(t, err)
+}
+
+func TestInit(t *testing.T) {
+	p := New("")
+	require.Nil(t, p.Init(map[string]interface{}{"region": "us-west-2"}))
+	require.Equal(t, "us-west-2", p.region)
+	require.NotNil(t, p.Init(map[string]interface{}{"region": 2}))
+}
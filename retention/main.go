@@ -0,0 +1,239 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/fugue/zim/sign"
+	"github.com/sirupsen/logrus"
+)
+
+// maxDeleteBatch is the number of keys S3 accepts in one DeleteObjects call
+const maxDeleteBatch = 1000
+
+var logger *logrus.Logger
+
+func init() {
+	logger = logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+}
+
+type retentionHandler struct {
+	s3          s3iface.S3API
+	ddb         dynamodbiface.DynamoDBAPI
+	bucket      string
+	prefix      string
+	policyTable string
+}
+
+// HandleRequest applies the retention policy of each project to its items
+// in the cache. It runs on a schedule.
+func (h *retentionHandler) HandleRequest(ctx context.Context, event events.CloudWatchEvent) error {
+
+	policies, err := h.policies(ctx)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read policies")
+		return err
+	}
+	if len(policies) == 0 {
+		logger.Info("No policies")
+		return nil
+	}
+	items, manifests, err := h.projectItems(ctx, policies)
+	if err != nil {
+		logger.WithError(err).Error("Failed to list items")
+		return err
+	}
+	now := time.Now()
+	expiredItems := map[string][]sign.Item{}
+	deleted := map[string]bool{}
+	for project, policy := range policies {
+		expiredItems[project] = policy.Expired(items[project], now)
+		for _, item := range expiredItems[project] {
+			deleted[item.Key] = true
+		}
+	}
+	// Content stored once under its hash by the content layout is kept
+	// while a manifest that isn't deleted refers to it
+	referenced := map[string]bool{}
+	for key, blob := range manifests {
+		if !deleted[key] {
+			referenced[blob] = true
+		}
+	}
+	for project := range policies {
+		var expired []sign.Item
+		for _, item := range expiredItems[project] {
+			if !referenced[item.Key] {
+				expired = append(expired, item)
+			}
+		}
+		if err := h.delete(ctx, expired); err != nil {
+			logger.WithError(err).WithField("project", project).Error("Failed to delete items")
+			return err
+		}
+		var size int64
+		for _, item := range expired {
+			size += item.Size
+		}
+		logger.WithFields(logrus.Fields{
+			"project": project,
+			"items":   len(items[project]),
+			"deleted": len(expired),
+			"size":    size,
+		}).Info("Applied policy")
+	}
+	return nil
+}
+
+// policies returns the retention policies of all projects that limit the
+// age or size of their items
+func (h *retentionHandler) policies(ctx context.Context) (map[string]*sign.Policy, error) {
+	policies := map[string]*sign.Policy{}
+	var scanErr error
+	err := h.ddb.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(h.policyTable),
+	}, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			var policy sign.Policy
+			if scanErr = dynamodbattribute.UnmarshalMap(item, &policy); scanErr != nil {
+				return false
+			}
+			if policy.Validate() == nil && (policy.MaxAge > 0 || policy.MaxSize > 0) {
+				policies[policy.Project] = &policy
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return policies, scanErr
+}
+
+// projectItems returns the items in the cache belonging to projects with a
+// policy, by project. The project is found in the metadata of each item,
+// which was recorded by the client that stored it. Items stored by older
+// clients have no project and are left alone. The keys of the content that
+// manifests of any project refer to are also returned, by manifest key.
+func (h *retentionHandler) projectItems(
+	ctx context.Context,
+	policies map[string]*sign.Policy,
+) (map[string][]sign.Item, map[string]string, error) {
+
+	prefix := h.prefix
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	}
+	var objects []*s3.Object
+	err := h.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(h.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		objects = append(objects, page.Contents...)
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	items := map[string][]sign.Item{}
+	manifests := map[string]string{}
+	for _, obj := range objects {
+		head, err := h.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(h.bucket),
+			Key:    obj.Key,
+		})
+		if err != nil {
+			// Deleted since it was listed
+			logger.WithError(err).WithField("key", aws.StringValue(obj.Key)).Info("Skipping item")
+			continue
+		}
+		// Manifests refer to content by its key within the prefix
+		blob := aws.StringValue(head.Metadata["Blob"])
+		if aws.StringValue(head.Metadata["Format"]) == "manifest" && blob != "" {
+			manifests[aws.StringValue(obj.Key)] = prefix + blob
+		}
+		project := aws.StringValue(head.Metadata["Project"])
+		if policies[project] == nil {
+			continue
+		}
+		items[project] = append(items[project], sign.Item{
+			Key:          aws.StringValue(obj.Key),
+			Size:         aws.Int64Value(obj.Size),
+			LastModified: aws.TimeValue(obj.LastModified),
+		})
+	}
+	return items, manifests, nil
+}
+
+// delete removes the items from the bucket in batches
+func (h *retentionHandler) delete(ctx context.Context, items []sign.Item) error {
+	for start := 0; start < len(items); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(items) {
+			end = len(items)
+		}
+		var objects []*s3.ObjectIdentifier
+		for _, item := range items[start:end] {
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(item.Key)})
+		}
+		_, err := h.s3.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(h.bucket),
+			Delete: &s3.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func main() {
+
+	logger.Info("coldstart")
+
+	sess := session.Must(session.NewSession())
+
+	bucketName := os.Getenv("BUCKET")
+	policyTable := os.Getenv("POLICY_TABLE")
+
+	if bucketName == "" {
+		logger.Fatal("BUCKET is not set")
+	}
+	if policyTable == "" {
+		logger.Fatal("POLICY_TABLE is not set")
+	}
+
+	handler := &retentionHandler{
+		s3:          s3.New(sess),
+		ddb:         dynamodb.New(sess),
+		bucket:      bucketName,
+		prefix:      os.Getenv("BUCKET_PREFIX"),
+		policyTable: policyTable,
+	}
+	lambda.Start(handler.HandleRequest)
+}
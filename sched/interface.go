@@ -29,6 +29,13 @@ type Options struct {
 	Rules      []*project.Rule
 	RunRemote  bool
 	NumWorkers int
+
+	// ParentBuildID identifies the build that started this one, if any
+	ParentBuildID string
+
+	// Pipeline allows a Rule to start once the outputs it needs from a
+	// running dependency exist, if the dependency names them
+	Pipeline bool
 }
 
 // Scheduler for jobs
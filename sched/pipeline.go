@@ -0,0 +1,67 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sched
+
+import (
+	"os"
+	"time"
+
+	"github.com/fugue/zim/project"
+)
+
+// outputState is the size and modification time of an output file
+type outputState struct {
+	size    int64
+	modTime time.Time
+}
+
+// mtimeSlack allows for filesystems that record modification times with a
+// coarser clock, or granularity, than the time a rule started was taken with
+const mtimeSlack = time.Second
+
+// outputWatcher decides when outputs of running rules are finalized. An
+// output is considered finalized once it has been written since the rule
+// started and is unchanged since the previous check.
+type outputWatcher struct {
+	states map[string]outputState
+}
+
+func newOutputWatcher() *outputWatcher {
+	return &outputWatcher{states: map[string]outputState{}}
+}
+
+// ready returns true if all the outputs are finalized, having been written
+// since the given time. Outputs that are not on the filesystem are never
+// considered ready.
+func (w *outputWatcher) ready(outputs project.Resources, since time.Time) bool {
+	result := true
+	for _, out := range outputs {
+		if !out.OnFilesystem() {
+			return false
+		}
+		info, err := os.Stat(out.Path())
+		if err != nil || info.IsDir() || info.ModTime().Before(since.Add(-mtimeSlack)) {
+			delete(w.states, out.Path())
+			result = false
+			continue
+		}
+		state := outputState{size: info.Size(), modTime: info.ModTime()}
+		if previous, found := w.states[out.Path()]; !found || previous != state {
+			result = false
+		}
+		w.states[out.Path()] = state
+	}
+	return result
+}
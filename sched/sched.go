@@ -73,7 +73,7 @@ func (s *dagScheduler) Run(ctx context.Context, opts Options) error {
 	results := make(chan *workerResult, opts.NumWorkers)
 	for w := 0; w < opts.NumWorkers; w++ {
 		wg.Add(1)
-		go worker(ctx, opts.Runner, opts.BuildID, executor, jobs, results, &wg)
+		go worker(ctx, opts.Runner, opts.BuildID, opts.ParentBuildID, executor, jobs, results, &wg)
 	}
 
 	// Signal to the workers to exit when done running and then wait
@@ -95,20 +95,53 @@ func (s *dagScheduler) Run(ctx context.Context, opts Options) error {
 	rulesFinished := 0
 	rulesCount := len(ruleStates)
 
+	// With pipelining, rules may start before their dependencies finish.
+	// Track when each rule started and which rules started early.
+	watcher := newOutputWatcher()
+	startTimes := map[*project.Rule]time.Time{}
+	pipelined := map[*project.Rule][]*project.Rule{}
+
 	// Called each time a rule starts executing to update scheduler state
-	ruleStart := func(r *project.Rule) {
+	ruleStart := func(r *project.Rule, startedAt time.Time) {
 		if ruleStates[r] != Unscheduled {
 			panic(fmt.Sprintf("Rule started from unexpected state"))
 		}
 		ruleStates[r] = Running
+		startTimes[r] = startedAt
+		for _, dep := range schedGraph.From(r) {
+			pipelined[dep.(*project.Rule)] = append(pipelined[dep.(*project.Rule)], r)
+		}
+	}
+
+	// Returns true if a rule may start. Normally all its dependencies must
+	// have finished. With pipelining, a running dependency is acceptable
+	// if the rule only needs outputs of it that are finalized.
+	ruleReady := func(r *project.Rule) bool {
+		for _, n := range schedGraph.From(r) {
+			dep := n.(*project.Rule)
+			if !opts.Pipeline || ruleStates[dep] != Running {
+				return false
+			}
+			outputs, streamed := r.StreamedOutputs(dep)
+			if !streamed || !watcher.ready(outputs, startTimes[dep]) {
+				return false
+			}
+		}
+		return true
 	}
 
 	// Called each time a rule finishes executing to update scheduler
 	// state. We can update the rule dependency graph as needed here.
 	var ruleDone func(*project.Rule, error)
 	ruleDone = func(r *project.Rule, err error) {
+		if ruleStates[r] == Error {
+			// A pipelined rule already failed due to its dependency
+			return
+		}
 		rulesFinished++
-		if err != nil {
+		// Rules that are allowed to fail don't fail the build. The runner
+		// skips rules that depend on them.
+		if err != nil && !project.IsAllowedFailure(err) {
 			errors = multierror.Append(errors, err)
 			ruleStates[r] = Error
 			// Any rules dependent on this rule should now error as well.
@@ -118,6 +151,15 @@ func (s *dagScheduler) Run(ctx context.Context, opts Options) error {
 					project.Bright(other.NodeID()), project.Bright(r.NodeID()))
 				ruleDone(other.(*project.Rule), nextErr)
 			}
+			// Pipelined rules that already completed used outputs of
+			// this rule that may be incomplete
+			for _, other := range pipelined[r] {
+				if ruleStates[other] == Completed {
+					errors = multierror.Append(errors, fmt.Errorf(
+						"Rule %s used outputs of %s which then failed",
+						project.Bright(other.NodeID()), project.Bright(r.NodeID())))
+				}
+			}
 		} else {
 			ruleStates[r] = Completed
 		}
@@ -144,8 +186,8 @@ func (s *dagScheduler) Run(ctx context.Context, opts Options) error {
 		// These are nodes that have no dependencies or the dependencies
 		// have already been run and removed from the graph.
 		candidateNodes := schedGraph.Filter(func(n graph.Node) bool {
-			status := ruleStates[n.(*project.Rule)]
-			return status == Unscheduled && len(schedGraph.From(n)) == 0
+			r := n.(*project.Rule)
+			return ruleStates[r] == Unscheduled && ruleReady(r)
 		})
 
 		if len(candidateNodes) == 0 {
@@ -162,9 +204,11 @@ func (s *dagScheduler) Run(ctx context.Context, opts Options) error {
 		// Send rules to workers to execute (non-blocking send)
 		var allWorkersBusy bool
 		for _, rule := range candidates {
+			// The start time is taken before the worker can write outputs
+			startedAt := time.Now()
 			select {
 			case jobs <- rule:
-				ruleStart(rule)
+				ruleStart(rule, startedAt)
 			default: // Workers busy
 				allWorkersBusy = true
 			}
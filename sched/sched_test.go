@@ -15,10 +15,14 @@ package sched
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/fugue/zim/definitions"
 	"github.com/fugue/zim/project"
@@ -95,3 +99,108 @@ func TestScheduler(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, expectedOrder, got)
 }
+
+func TestSchedulerAllowFailure(t *testing.T) {
+
+	ctx := context.Background()
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	defs := []*definitions.Component{{
+		Path: path.Join(dir, "widget"),
+		Name: "widget",
+		Rules: map[string]definitions.Rule{
+			"lint": definitions.Rule{AllowFailure: true},
+			"report": definitions.Rule{
+				Requires: []definitions.Dependency{{Rule: "lint"}},
+			},
+		},
+	}}
+	p, err := project.NewWithOptions(project.Opts{
+		Root:          dir,
+		ComponentDefs: defs,
+	})
+	require.Nil(t, err)
+	widget := p.Components().First()
+
+	var got []*project.Rule
+	runner := project.NewChain(project.NewAllowedFailures().Middleware).Then(project.RunnerFunc(
+		func(ctx context.Context, rule *project.Rule, opts project.RunOpts) (project.Code, error) {
+			got = append(got, rule)
+			return project.ExecError, errors.New("lint failed")
+		}))
+
+	// The failure doesn't fail the run and its dependent is skipped
+	err = NewGraphScheduler().Run(ctx, Options{
+		Runner:     runner,
+		Rules:      []*project.Rule{widget.MustRule("report")},
+		NumWorkers: 2,
+	})
+	require.Nil(t, err)
+	require.Equal(t, []*project.Rule{widget.MustRule("lint")}, got)
+}
+
+func TestSchedulerPipeline(t *testing.T) {
+
+	ctx := context.Background()
+
+	dir := testDir()
+	defer os.RemoveAll(dir)
+
+	def := &definitions.Component{
+		Path: path.Join(dir, "widget"),
+		Name: "widget",
+		Rules: map[string]definitions.Rule{
+			"produce": definitions.Rule{
+				Outputs: []string{"data.txt", "log.txt"},
+			},
+			"consume": definitions.Rule{
+				Requires: []definitions.Dependency{
+					{Rule: "produce", Outputs: []string{"data.txt"}},
+				},
+			},
+		},
+	}
+	p, err := project.NewWithOptions(project.Opts{
+		Root:          dir,
+		ComponentDefs: []*definitions.Component{def},
+	})
+	require.Nil(t, err)
+	widget := p.Components().First()
+
+	outputs, streamed := widget.MustRule("consume").StreamedOutputs(widget.MustRule("produce"))
+	require.True(t, streamed)
+	require.Len(t, outputs, 1)
+
+	// The producer writes its first output and then only finishes once the
+	// consumer has started
+	consumerStarted := make(chan bool)
+	runner := project.RunnerFunc(func(ctx context.Context, rule *project.Rule, opts project.RunOpts) (project.Code, error) {
+		if rule.Name() == "consume" {
+			close(consumerStarted)
+			return project.OK, nil
+		}
+		out := rule.Outputs()[0].Path()
+		if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+			return project.Error, err
+		}
+		if err := ioutil.WriteFile(out, []byte("data"), 0644); err != nil {
+			return project.Error, err
+		}
+		select {
+		case <-consumerStarted:
+			return project.OK, nil
+		case <-time.After(5 * time.Second):
+			return project.Error, errors.New("consumer did not start")
+		}
+	})
+
+	err = NewGraphScheduler().Run(ctx, Options{
+		Runner:     runner,
+		Rules:      []*project.Rule{widget.MustRule("consume")},
+		NumWorkers: 2,
+		Pipeline:   true,
+	})
+	require.Nil(t, err)
+}
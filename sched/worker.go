@@ -34,6 +34,7 @@ func worker(
 	ctx context.Context,
 	runner project.Runner,
 	buildID string,
+	parentBuildID string,
 	exc exec.Executor,
 	rules <-chan *project.Rule,
 	results chan<- *workerResult,
@@ -49,8 +50,9 @@ func worker(
 				return
 			}
 			code, err := runner.Run(ctx, rule, project.RunOpts{
-				BuildID:  buildID,
-				Executor: exc,
+				BuildID:       buildID,
+				ParentBuildID: parentBuildID,
+				Executor:      exc,
 			})
 			if ctx.Err() == nil {
 				results <- &workerResult{Rule: rule, Code: code, Error: err}
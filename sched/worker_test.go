@@ -70,6 +70,7 @@ func TestWorker(t *testing.T) {
 	runner := project.RunnerFunc(func(ctx context.Context, rule *project.Rule, opts project.RunOpts) (project.Code, error) {
 		assert.Equal(t, build, rule)
 		assert.Equal(t, "123", opts.BuildID)
+		assert.Equal(t, "456", opts.ParentBuildID)
 		assert.Equal(t, executor, opts.Executor)
 		assert.Equal(t, nil, opts.Output)
 		return project.Cached, errors.New("bourgeoisie")
@@ -78,7 +79,7 @@ func TestWorker(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
-	go worker(ctx, runner, "123", executor, ruleChan, resultChan, &wg)
+	go worker(ctx, runner, "123", "456", executor, ruleChan, resultChan, &wg)
 
 	// Send build rule to the worker
 	ruleChan <- build
@@ -0,0 +1,158 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/fugue/zim/project"
+)
+
+// AWS resolves secrets from SSM Parameter Store and Secrets Manager. Each
+// secret is retrieved once and then held in memory, never on disk. The AWS
+// session is only created when the first secret is resolved.
+type AWS struct {
+	region         string
+	mutex          sync.Mutex
+	ssm            ssmiface.SSMAPI
+	secretsManager secretsmanageriface.SecretsManagerAPI
+	values         map[string]string
+}
+
+// NewAWS returns a resolver for secrets in the given AWS region. The region
+// is taken from the AWS configuration if it is empty.
+func NewAWS(region string) *AWS {
+	return &AWS{region: region, values: map[string]string{}}
+}
+
+// NewAWSWithClients returns a resolver that uses the given clients
+func NewAWSWithClients(ssmClient ssmiface.SSMAPI, smClient secretsmanageriface.SecretsManagerAPI) *AWS {
+	return &AWS{ssm: ssmClient, secretsManager: smClient, values: map[string]string{}}
+}
+
+// Resolve returns the value of the referenced secret
+func (a *AWS) Resolve(ctx context.Context, ref project.SecretRef) (string, error) {
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if value, found := a.values[ref.String()]; found {
+		return value, nil
+	}
+	if err := a.connect(); err != nil {
+		return "", err
+	}
+	var value string
+	var err error
+	switch ref.Provider {
+	case project.SecretProviderSSM:
+		value, err = a.getParameter(ctx, ref.Name)
+	case project.SecretProviderSecretsManager:
+		value, err = a.getSecret(ctx, ref.Name)
+	default:
+		err = fmt.Errorf("unsupported secret provider: %s", ref.Provider)
+	}
+	if err != nil {
+		return "", err
+	}
+	if ref.Key != "" {
+		if value, err = jsonField(value, ref.Key); err != nil {
+			return "", fmt.Errorf("secret %s %s", ref.Name, err)
+		}
+	}
+	a.values[ref.String()] = value
+	return value, nil
+}
+
+// connect creates the AWS clients if they don't exist yet
+func (a *AWS) connect() error {
+	if a.ssm != nil && a.secretsManager != nil {
+		return nil
+	}
+	cfg := aws.NewConfig().WithMaxRetries(8)
+	if a.region != "" {
+		cfg = cfg.WithRegion(a.region)
+	}
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config:            *cfg,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return err
+	}
+	if a.ssm == nil {
+		a.ssm = ssm.New(sess)
+	}
+	if a.secretsManager == nil {
+		a.secretsManager = secretsmanager.New(sess)
+	}
+	return nil
+}
+
+// getParameter returns the decrypted value of an SSM parameter
+func (a *AWS) getParameter(ctx context.Context, name string) (string, error) {
+	output, err := a.ssm.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	if output.Parameter == nil || output.Parameter.Value == nil {
+		return "", fmt.Errorf("parameter %s has no value", name)
+	}
+	return *output.Parameter.Value, nil
+}
+
+// getSecret returns the value of a Secrets Manager secret
+func (a *AWS) getSecret(ctx context.Context, name string) (string, error) {
+	output, err := a.secretsManager.GetSecretValueWithContext(ctx,
+		&secretsmanager.GetSecretValueInput{SecretId: aws.String(name)})
+	if err != nil {
+		return "", err
+	}
+	if output.SecretString == nil {
+		return "", fmt.Errorf("secret %s is binary and cannot be used", name)
+	}
+	return *output.SecretString, nil
+}
+
+// jsonField returns one field of a secret containing a JSON object
+func jsonField(value, key string) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("is not a JSON object")
+	}
+	field, found := fields[key]
+	if !found {
+		return "", fmt.Errorf("has no key %s", key)
+	}
+	if s, ok := field.(string); ok {
+		return s, nil
+	}
+	data, err := json.Marshal(field)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+	"github.com/fugue/zim/project"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSSM struct {
+	ssmiface.SSMAPI
+	params map[string]string
+	calls  int
+}
+
+func (f *fakeSSM) GetParameterWithContext(ctx aws.Context, input *ssm.GetParameterInput, opts ...request.Option) (*ssm.GetParameterOutput, error) {
+	f.calls++
+	value, found := f.params[*input.Name]
+	if !found {
+		return nil, errors.New("ParameterNotFound")
+	}
+	return &ssm.GetParameterOutput{
+		Parameter: &ssm.Parameter{Name: input.Name, Value: aws.String(value)},
+	}, nil
+}
+
+type fakeSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	secrets map[string]string
+}
+
+func (f *fakeSecretsManager) GetSecretValueWithContext(ctx aws.Context, input *secretsmanager.GetSecretValueInput, opts ...request.Option) (*secretsmanager.GetSecretValueOutput, error) {
+	value, found := f.secrets[*input.SecretId]
+	if !found {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+func TestAWSResolve(t *testing.T) {
+	ctx := context.Background()
+
+	ssmClient := &fakeSSM{params: map[string]string{"/prod/token": "abc123"}}
+	smClient := &fakeSecretsManager{secrets: map[string]string{
+		"prod/db": `{"user": "admin", "password": "hunter2", "port": 5432}`,
+	}}
+	resolver := NewAWSWithClients(ssmClient, smClient)
+
+	resolve := func(s string) (string, error) {
+		ref, err := project.ParseSecretRef(s)
+		require.Nil(t, err)
+		return resolver.Resolve(ctx, ref)
+	}
+
+	value, err := resolve("ssm:/prod/token")
+	require.Nil(t, err)
+	require.Equal(t, "abc123", value)
+
+	// Values are held after the first lookup
+	_, err = resolve("ssm:/prod/token")
+	require.Nil(t, err)
+	require.Equal(t, 1, ssmClient.calls)
+
+	value, err = resolve("secretsmanager:prod/db#password")
+	require.Nil(t, err)
+	require.Equal(t, "hunter2", value)
+
+	value, err = resolve("secretsmanager:prod/db#port")
+	require.Nil(t, err)
+	require.Equal(t, "5432", value)
+
+	_, err = resolve("secretsmanager:prod/db#host")
+	require.NotNil(t, err)
+	require.Equal(t, "secret prod/db has no key host", err.Error())
+
+	_, err = resolve("ssm:/prod/missing")
+	require.NotNil(t, err)
+}
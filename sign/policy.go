@@ -0,0 +1,69 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sign
+
+import (
+	"errors"
+	"sort"
+	"time"
+)
+
+// Policy is the retention policy for the cache items of one project, which
+// the server enforces periodically. Items last modified more than MaxAge ago
+// are deleted, then the oldest remaining items are deleted until the total
+// size of the project's items is at most MaxSize. Zero means no limit.
+type Policy struct {
+	Project   string        `json:"project"`
+	MaxAge    time.Duration `json:"max_age"`
+	MaxSize   int64         `json:"max_size"`
+	UpdatedBy string        `json:"updated_by,omitempty"`
+	UpdatedAt time.Time     `json:"updated_at,omitempty"`
+}
+
+// Validate returns an error if the policy can't be applied
+func (p *Policy) Validate() error {
+	if p.Project == "" {
+		return errors.New("policy has no project")
+	}
+	if p.MaxAge < 0 || p.MaxSize < 0 {
+		return errors.New("policy limits must not be negative")
+	}
+	return nil
+}
+
+// Expired returns the items of the project that the policy deletes. The
+// newest items are kept first when the total size is limited.
+func (p *Policy) Expired(items []Item, now time.Time) []Item {
+	sorted := make([]Item, len(items))
+	copy(sorted, items)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].LastModified.After(sorted[j].LastModified)
+	})
+	var expired []Item
+	var total int64
+	var full bool
+	for _, item := range sorted {
+		if p.MaxAge > 0 && now.Sub(item.LastModified) > p.MaxAge {
+			expired = append(expired, item)
+			continue
+		}
+		if p.MaxSize > 0 && (full || total+item.Size > p.MaxSize) {
+			full = true
+			expired = append(expired, item)
+			continue
+		}
+		total += item.Size
+	}
+	return expired
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package sign
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyExpired(t *testing.T) {
+
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	items := []Item{
+		{Key: "a", Size: 10, LastModified: now.Add(-40 * day)},
+		{Key: "b", Size: 10, LastModified: now.Add(-1 * day)},
+		{Key: "c", Size: 10, LastModified: now.Add(-3 * day)},
+		{Key: "d", Size: 5, LastModified: now.Add(-5 * day)},
+	}
+	keys := func(items []Item) []string {
+		var result []string
+		for _, item := range items {
+			result = append(result, item.Key)
+		}
+		return result
+	}
+
+	// No limits
+	p := &Policy{Project: "p"}
+	require.Nil(t, p.Validate())
+	require.Empty(t, p.Expired(items, now))
+
+	// Old items are deleted
+	p = &Policy{Project: "p", MaxAge: 30 * day}
+	require.Equal(t, []string{"a"}, keys(p.Expired(items, now)))
+
+	// The newest items are kept within the size limit, and nothing older
+	// than the first item that doesn't fit is kept
+	p = &Policy{Project: "p", MaxSize: 15}
+	require.Equal(t, []string{"c", "d", "a"}, keys(p.Expired(items, now)))
+
+	p = &Policy{Project: "p", MaxAge: 30 * day, MaxSize: 25}
+	require.Equal(t, []string{"a"}, keys(p.Expired(items, now)))
+
+	require.NotNil(t, (&Policy{}).Validate())
+	require.NotNil(t, (&Policy{Project: "p", MaxSize: -1}).Validate())
+}
@@ -21,6 +21,8 @@ type Input struct {
 	Name          string            `json:"name"`
 	Metadata      map[string]string `json:"metadata"`
 	ContentLength int64             `json:"content_len"`
+	Token         string            `json:"token,omitempty"`
+	Policy        *Policy           `json:"policy,omitempty"`
 }
 
 // Output from a signing request
@@ -29,6 +31,13 @@ type Output struct {
 	Headers map[string]string `json:"headers"`
 }
 
+// ListOutput is one page of items from a list request. If Token is set,
+// it is passed in the next request to get the following page.
+type ListOutput struct {
+	Items []Item `json:"items"`
+	Token string `json:"token,omitempty"`
+}
+
 // Item contains information about an item in storage
 type Item struct {
 	Key          string            `json:"key"`
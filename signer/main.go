@@ -20,6 +20,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fugue/zim/sign"
@@ -29,6 +30,9 @@ import (
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 	"github.com/sirupsen/logrus"
@@ -42,10 +46,12 @@ func init() {
 }
 
 type eventHandler struct {
-	s3        s3iface.S3API
-	bucket    string
-	prefix    string
-	expireMin int
+	s3          s3iface.S3API
+	ddb         dynamodbiface.DynamoDBAPI
+	bucket      string
+	prefix      string
+	expireMin   int
+	policyTable string
 }
 
 func (h *eventHandler) HandleRequest(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
@@ -70,6 +76,12 @@ func (h *eventHandler) HandleRequest(ctx context.Context, req events.APIGatewayP
 		output, err = h.Sign(ctx, &input)
 	} else if req.Path == "/head" {
 		output, err = h.Head(ctx, &input)
+	} else if req.Path == "/list" {
+		output, err = h.List(ctx, &input)
+	} else if req.Path == "/delete" {
+		output, err = h.Delete(ctx, &input)
+	} else if req.Path == "/policy" {
+		output, err = h.Policy(ctx, &input, principalID)
 	} else {
 		return events.APIGatewayProxyResponse{Body: "unknown path", StatusCode: 404}, nil
 	}
@@ -187,6 +199,119 @@ func (h *eventHandler) Head(ctx context.Context, input *sign.Input) (*sign.Item,
 	return item, nil
 }
 
+// List returns a page of items with keys beginning with the input name
+func (h *eventHandler) List(ctx context.Context, input *sign.Input) (*sign.ListOutput, error) {
+	prefix := h.prefix
+	if prefix != "" {
+		prefix = strings.TrimSuffix(prefix, "/") + "/"
+	}
+	listInput := &s3.ListObjectsV2Input{
+		Bucket: aws.String(h.bucket),
+		Prefix: aws.String(prefix + input.Name),
+	}
+	if input.Token != "" {
+		listInput.ContinuationToken = aws.String(input.Token)
+	}
+	result, err := h.s3.ListObjectsV2WithContext(ctx, listInput)
+	if err != nil {
+		return nil, fmt.Errorf("List failed %s: %s", input.Name, err)
+	}
+	output := &sign.ListOutput{Items: []sign.Item{}}
+	for _, obj := range result.Contents {
+		item := sign.Item{Key: strings.TrimPrefix(aws.StringValue(obj.Key), prefix)}
+		if obj.ETag != nil {
+			item.ETag = *obj.ETag
+		}
+		if obj.Size != nil {
+			item.Size = *obj.Size
+		}
+		if obj.LastModified != nil {
+			item.LastModified = *obj.LastModified
+		}
+		output.Items = append(output.Items, item)
+	}
+	if aws.BoolValue(result.IsTruncated) && result.NextContinuationToken != nil {
+		output.Token = *result.NextContinuationToken
+	}
+	return output, nil
+}
+
+// Delete removes the item with the input name
+func (h *eventHandler) Delete(ctx context.Context, input *sign.Input) (*sign.Item, error) {
+	if input.Name == "" {
+		return nil, fmt.Errorf("Invalid name: '%s'", input.Name)
+	}
+	key := filepath.Join(h.prefix, input.Name)
+	_, err := h.s3.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(h.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Delete failed %s: %s", key, err)
+	}
+	logger.WithFields(logrus.Fields{
+		"bucket": h.bucket,
+		"key":    key,
+	}).Info("Deleted")
+	return &sign.Item{Key: key}, nil
+}
+
+// Policy returns the retention policy of the project with the input name,
+// after replacing it with the input policy if the method is PUT
+func (h *eventHandler) Policy(ctx context.Context, input *sign.Input, principalID string) (*sign.Policy, error) {
+	if h.policyTable == "" {
+		return nil, fmt.Errorf("Cache policies are not configured")
+	}
+	if input.Method == "PUT" {
+		if input.Policy == nil {
+			return nil, fmt.Errorf("Policy is not specified")
+		}
+		policy := *input.Policy
+		policy.UpdatedBy = principalID
+		policy.UpdatedAt = time.Now().UTC()
+		if err := policy.Validate(); err != nil {
+			return nil, err
+		}
+		item, err := dynamodbattribute.MarshalMap(policy)
+		if err != nil {
+			return nil, err
+		}
+		_, err = h.ddb.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(h.policyTable),
+			Item:      item,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Failed to store policy %s: %s", policy.Project, err)
+		}
+		logger.WithFields(logrus.Fields{
+			"project":  policy.Project,
+			"max_age":  policy.MaxAge.String(),
+			"max_size": policy.MaxSize,
+		}).Info("Updated policy")
+		return &policy, nil
+	}
+	if input.Name == "" {
+		return nil, fmt.Errorf("Invalid name: '%s'", input.Name)
+	}
+	result, err := h.ddb.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(h.policyTable),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Project": {S: aws.String(input.Name)},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to get policy %s: %s", input.Name, err)
+	}
+	// Projects without a policy are kept indefinitely
+	policy := &sign.Policy{Project: input.Name}
+	if result.Item != nil {
+		if err := dynamodbattribute.UnmarshalMap(result.Item, policy); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
 func main() {
 
 	logger.Info("coldstart")
@@ -210,10 +335,12 @@ func main() {
 	}
 
 	handler := &eventHandler{
-		s3:        svc,
-		bucket:    bucketName,
-		prefix:    bucketPrefix,
-		expireMin: expireMin,
+		s3:          svc,
+		ddb:         dynamodb.New(sess),
+		bucket:      bucketName,
+		prefix:      bucketPrefix,
+		expireMin:   expireMin,
+		policyTable: os.Getenv("POLICY_TABLE"),
 	}
 	lambda.Start(handler.HandleRequest)
 }
@@ -22,10 +22,23 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/fugue/zim/store"
 )
 
+// accessResolution is how often an item's access time is updated. Reading
+// an item more often than this doesn't rewrite its metadata.
+const accessResolution = time.Hour
+
+// fileMeta is the content of the metadata file stored alongside each item.
+// The access time is used to evict least recently used items.
+type fileMeta struct {
+	store.ItemMeta
+	AccessedAt time.Time `json:"accessed_at,omitempty"`
+}
+
 type fileStore struct {
 	rootDirectory string
 }
@@ -72,7 +85,40 @@ func (s *fileStore) Get(ctx context.Context, key, dst string) error {
 	}
 	defer srcFile.Close()
 
-	return copyFile(srcFile, dst)
+	if err := copyFile(srcFile, dst); err != nil {
+		return err
+	}
+	if meta, err := readMeta(path); err == nil {
+		touch(path, meta)
+	}
+	return nil
+}
+
+// GetRange returns a reader of part of an item
+func (s *fileStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+
+	path := s.path(key)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("not found: %s", key)
+		}
+		return nil, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to seek in file %s: %w", path, err)
+	}
+	if meta, err := readMeta(path); err == nil {
+		touch(path, meta)
+	}
+	return &rangeReader{Reader: io.LimitReader(f, length), Closer: f}, nil
+}
+
+// rangeReader reads part of a file and closes the file
+type rangeReader struct {
+	io.Reader
+	io.Closer
 }
 
 func (s *fileStore) Put(ctx context.Context, key, src string, meta map[string]string) error {
@@ -94,23 +140,69 @@ func (s *fileStore) Put(ctx context.Context, key, src string, meta map[string]st
 		return err
 	}
 
+	return writeMeta(path, fileMeta{
+		ItemMeta:   store.ItemMeta{Meta: meta},
+		AccessedAt: time.Now().UTC(),
+	})
+}
+
+// readMeta reads the metadata for the item at the given path
+func readMeta(path string) (fileMeta, error) {
+	var meta fileMeta
+	metaBytes, err := ioutil.ReadFile(fmt.Sprintf("%s.meta", path))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse metadata: %w", err)
+	}
+	return meta, nil
+}
+
+// writeMeta writes the metadata for the item at the given path. It is written
+// to a temporary file first so that readers never see partial metadata, even
+// when it is rewritten as the item is read.
+func writeMeta(path string, meta fileMeta) error {
 	metaPath := fmt.Sprintf("%s.meta", path)
-	metaBytes, err := json.Marshal(store.ItemMeta{Meta: meta})
+	metaBytes, err := json.Marshal(meta)
 	if err != nil {
-		return fmt.Errorf("failed to marshal metadata; key: %s err: %w", key, err)
+		return fmt.Errorf("failed to marshal metadata %s: %w", metaPath, err)
 	}
-	metaFile, err := os.Create(metaPath)
+	tmp, err := ioutil.TempFile(filepath.Dir(metaPath), filepath.Base(metaPath)+".tmp-")
 	if err != nil {
 		return fmt.Errorf("failed to create file %s: %w", metaPath, err)
 	}
-	defer metaFile.Close()
-
-	if _, err := metaFile.Write(metaBytes); err != nil {
+	if _, err := tmp.Write(metaBytes); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file %s: %w", metaPath, err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file %s: %w", metaPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write file %s: %w", metaPath, err)
+	}
+	if err := os.Rename(tmp.Name(), metaPath); err != nil {
+		os.Remove(tmp.Name())
 		return fmt.Errorf("failed to write file %s: %w", metaPath, err)
 	}
 	return nil
 }
 
+// touch records that the item at the given path was accessed. Failures are
+// ignored since they only affect which items are evicted first.
+func touch(path string, meta fileMeta) {
+	if time.Since(meta.AccessedAt) < accessResolution {
+		return
+	}
+	meta.AccessedAt = time.Now().UTC()
+	writeMeta(path, meta)
+}
+
 func copyFile(f *os.File, dstPath string) error {
 
 	dstFile, err := os.Create(dstPath)
@@ -128,18 +220,81 @@ func copyFile(f *os.File, dstPath string) error {
 // Head checks if the item exists in the store
 func (s *fileStore) Head(ctx context.Context, key string) (store.ItemMeta, error) {
 
-	metaPath := fmt.Sprintf("%s.meta", s.path(key))
-	metaBytes, err := ioutil.ReadFile(metaPath)
+	// A Head request is an access since the caller may find its local copy
+	// of the item is up to date and not need to Get it
+	path := s.path(key)
+	meta, err := readMeta(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return store.ItemMeta{}, store.NotFound(fmt.Sprintf("not found: %s", key))
 		}
 		return store.ItemMeta{}, err
 	}
+	touch(path, meta)
+	return meta.ItemMeta, nil
+}
+
+// keyFromPath returns the key of the item at the given path, reversing the
+// nesting done by path
+func (s *fileStore) keyFromPath(path string) (string, error) {
+	relPath, err := filepath.Rel(s.rootDirectory, path)
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	if len(parts) >= 3 && strings.HasPrefix(parts[2], parts[0]+parts[1]) {
+		return strings.Join(parts[2:], "/"), nil
+	}
+	if len(parts) >= 2 && strings.HasPrefix(parts[1], parts[0]) {
+		return strings.Join(parts[1:], "/"), nil
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// List the items with keys that begin with the prefix
+func (s *fileStore) List(ctx context.Context, prefix string) ([]store.Item, error) {
+	var items []store.Item
+	err := filepath.Walk(s.rootDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".meta") {
+			return nil
+		}
+		// Only items that have metadata are complete
+		if _, err := os.Stat(path + ".meta"); err != nil {
+			return nil
+		}
+		key, err := s.keyFromPath(path)
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(key, prefix) {
+			items = append(items, store.Item{
+				Key:          key,
+				Size:         info.Size(),
+				LastModified: info.ModTime(),
+			})
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return items, nil
+}
 
-	var itemMeta store.ItemMeta
-	if err := json.Unmarshal(metaBytes, &itemMeta); err != nil {
-		return store.ItemMeta{}, fmt.Errorf("failed to parse metadata: %w", err)
+// Delete an item from the Store
+func (s *fileStore) Delete(ctx context.Context, key string) error {
+	path := s.path(key)
+	for _, p := range []string{path, path + ".meta"} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
-	return itemMeta, nil
+	removeEmptyParents(s.rootDirectory, filepath.Dir(path))
+	return nil
 }
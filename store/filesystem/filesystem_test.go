@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/store/storetest"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -78,3 +79,74 @@ func TestPresentKey(t *testing.T) {
 	require.Nil(t, err)
 	require.Equal(t, "The quick brown fox\njumps over the lazy dog", string(bytes))
 }
+
+// Confirm part of an item can be read without reading all of it
+func TestGetRange(t *testing.T) {
+
+	ctx := context.Background()
+	cacheDir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fs := New(cacheDir)
+	require.Nil(t, fs.Put(ctx, "abcdef", "test_fixture.txt", nil))
+
+	reader, err := fs.(store.RangeGetter).GetRange(ctx, "abcdef", 4, 11)
+	require.Nil(t, err)
+	defer reader.Close()
+	data, err := ioutil.ReadAll(reader)
+	require.Nil(t, err)
+	require.Equal(t, "quick brown", string(data))
+
+	_, err = fs.(store.RangeGetter).GetRange(ctx, "missing", 0, 1)
+	require.NotNil(t, err)
+}
+
+// Confirm items can be listed by prefix and deleted
+func TestListDelete(t *testing.T) {
+
+	ctx := context.Background()
+	cacheDir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fs := New(cacheDir).(store.ListDeleter)
+	for _, key := range []string{"abcdef", "abcxyz", "xy", "q"} {
+		require.Nil(t, fs.(store.Store).Put(ctx, key, "test_fixture.txt", nil))
+	}
+
+	items, err := fs.List(ctx, "")
+	require.Nil(t, err)
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+		require.Equal(t, int64(43), item.Size)
+	}
+	require.ElementsMatch(t, []string{"abcdef", "abcxyz", "xy", "q"}, keys)
+
+	items, err = fs.List(ctx, "abc")
+	require.Nil(t, err)
+	require.Len(t, items, 2)
+
+	require.Nil(t, fs.Delete(ctx, "abcdef"))
+	_, err = fs.(store.Store).Head(ctx, "abcdef")
+	require.NotNil(t, err)
+	items, err = fs.List(ctx, "abc")
+	require.Nil(t, err)
+	require.Equal(t, "abcxyz", items[0].Key)
+}
+
+func TestContract(t *testing.T) {
+	var dirs []string
+	defer func() {
+		for _, dir := range dirs {
+			os.RemoveAll(dir)
+		}
+	}()
+	storetest.TestStore(t, func() store.Store {
+		dir, err := ioutil.TempDir("", "zim-test-")
+		require.Nil(t, err)
+		dirs = append(dirs, dir)
+		return New(dir)
+	})
+}
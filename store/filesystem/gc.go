@@ -0,0 +1,119 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package filesystem
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// GCStats describes the result of garbage collecting a cache directory
+type GCStats struct {
+	Items        int   `json:"items"`
+	Size         int64 `json:"size"`
+	RemovedItems int   `json:"removed_items"`
+	RemovedSize  int64 `json:"removed_size"`
+}
+
+// gcItem is an item considered for removal during garbage collection
+type gcItem struct {
+	path       string
+	size       int64
+	accessedAt time.Time
+}
+
+// GarbageCollect removes the least recently used items from the cache in the
+// root directory until its total size is at most maxSize bytes. Items stored
+// before access times were recorded are treated as accessed when stored.
+// If dryRun is set, the items that would be removed are counted but kept.
+func GarbageCollect(rootDirectory string, maxSize int64, dryRun bool) (GCStats, error) {
+
+	var stats GCStats
+	var items []gcItem
+
+	err := filepath.Walk(rootDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(path, ".meta") {
+			return nil
+		}
+		itemPath := strings.TrimSuffix(path, ".meta")
+		itemInfo, err := os.Stat(itemPath)
+		if err != nil {
+			// Metadata without an item is left over from an interrupted Put
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		item := gcItem{
+			path:       itemPath,
+			size:       itemInfo.Size() + info.Size(),
+			accessedAt: info.ModTime(),
+		}
+		if meta, err := readMeta(itemPath); err == nil && !meta.AccessedAt.IsZero() {
+			item.accessedAt = meta.AccessedAt
+		}
+		items = append(items, item)
+		stats.Items++
+		stats.Size += item.size
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return stats, nil
+		}
+		return stats, err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].accessedAt.Before(items[j].accessedAt)
+	})
+
+	size := stats.Size
+	for _, item := range items {
+		if size <= maxSize {
+			break
+		}
+		if !dryRun {
+			if err := os.Remove(item.path); err != nil && !os.IsNotExist(err) {
+				return stats, err
+			}
+			if err := os.Remove(item.path + ".meta"); err != nil && !os.IsNotExist(err) {
+				return stats, err
+			}
+			removeEmptyParents(rootDirectory, filepath.Dir(item.path))
+		}
+		size -= item.size
+		stats.RemovedItems++
+		stats.RemovedSize += item.size
+	}
+	return stats, nil
+}
+
+// removeEmptyParents removes the directory and its parents up to the root
+// directory as long as they are empty
+func removeEmptyParents(rootDirectory, dir string) {
+	root := filepath.Clean(rootDirectory)
+	for dir != root && strings.HasPrefix(dir, root) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
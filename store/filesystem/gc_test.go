@@ -0,0 +1,140 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package filesystem
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGarbageCollect(t *testing.T) {
+
+	ctx := context.Background()
+	cacheDir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	src := filepath.Join(cacheDir, "src")
+	require.Nil(t, ioutil.WriteFile(src, []byte(strings.Repeat("x", 1000)), 0644))
+	defer os.Remove(src)
+
+	fs := New(cacheDir).(*fileStore)
+	keys := []string{"aaaa1", "bbbb2", "cccc3"}
+	for _, key := range keys {
+		require.Nil(t, fs.Put(ctx, key, src, nil))
+	}
+
+	// Make the first item the most recently used and the second the least
+	now := time.Now().UTC()
+	ages := map[string]time.Duration{"aaaa1": 0, "bbbb2": 72 * time.Hour, "cccc3": 24 * time.Hour}
+	for key, age := range ages {
+		meta, err := readMeta(fs.path(key))
+		require.Nil(t, err)
+		meta.AccessedAt = now.Add(-age)
+		require.Nil(t, writeMeta(fs.path(key), meta))
+	}
+	require.Nil(t, os.Remove(src))
+
+	// A dry run removes nothing
+	stats, err := GarbageCollect(cacheDir, 1500, true)
+	require.Nil(t, err)
+	require.Equal(t, 3, stats.Items)
+	require.Equal(t, 2, stats.RemovedItems)
+	_, err = os.Stat(fs.path("bbbb2"))
+	require.Nil(t, err)
+
+	stats, err = GarbageCollect(cacheDir, 1500, false)
+	require.Nil(t, err)
+	require.Equal(t, 2, stats.RemovedItems)
+	require.True(t, stats.Size-stats.RemovedSize <= 1500)
+
+	_, err = fs.Head(ctx, "aaaa1")
+	require.Nil(t, err)
+	for _, key := range []string{"bbbb2", "cccc3"} {
+		_, err = fs.Head(ctx, key)
+		require.NotNil(t, err)
+		_, err = os.Stat(filepath.Dir(fs.path(key)))
+		require.True(t, os.IsNotExist(err))
+	}
+}
+
+func TestAccessTime(t *testing.T) {
+
+	ctx := context.Background()
+	cacheDir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fs := New(cacheDir).(*fileStore)
+	require.Nil(t, fs.Put(ctx, "abcdef", "test_fixture.txt", nil))
+
+	old := time.Now().UTC().Add(-48 * time.Hour)
+	meta, err := readMeta(fs.path("abcdef"))
+	require.Nil(t, err)
+	meta.AccessedAt = old
+	require.Nil(t, writeMeta(fs.path("abcdef"), meta))
+
+	dst := filepath.Join(cacheDir, "dst")
+	require.Nil(t, fs.Get(ctx, "abcdef", dst))
+
+	meta, err = readMeta(fs.path("abcdef"))
+	require.Nil(t, err)
+	require.True(t, meta.AccessedAt.After(old.Add(47*time.Hour)))
+}
+
+func TestConcurrentAccess(t *testing.T) {
+
+	ctx := context.Background()
+	cacheDir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(cacheDir)
+
+	fs := New(cacheDir).(*fileStore)
+	require.Nil(t, fs.Put(ctx, "abcdef", "test_fixture.txt", map[string]string{"Hash": "123"}))
+	path := fs.path("abcdef")
+
+	// Metadata rewritten while it is read is never seen partially written
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				assert.Nil(t, writeMeta(path, fileMeta{
+					ItemMeta:   store.ItemMeta{Meta: map[string]string{"Hash": "123"}},
+					AccessedAt: time.Now().UTC().Add(-48 * time.Hour),
+				}))
+				item, err := fs.Head(ctx, "abcdef")
+				assert.Nil(t, err)
+				assert.Equal(t, "123", item.Meta["Hash"])
+			}
+		}()
+	}
+	wg.Wait()
+
+	// No temporary files are left behind
+	entries, err := ioutil.ReadDir(filepath.Dir(path))
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+}
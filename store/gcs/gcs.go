@@ -0,0 +1,413 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	osexec "os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/fugue/zim/store"
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+// DefaultEndpoint is the Google Cloud Storage XML API endpoint
+const DefaultEndpoint = "https://storage.googleapis.com"
+
+// metaHeader is the custom metadata header holding the metadata of an item as
+// JSON. Header names are case insensitive and are canonicalized in requests
+// and responses, so keys such as "HashAlgorithm" can't be headers of their
+// own without losing their case.
+const metaHeader = "X-Goog-Meta-Zim"
+
+// metadataTokenURL provides access tokens on Google Compute Engine
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// TokenSource returns an OAuth2 access token used to authorize requests
+type TokenSource func(ctx context.Context) (string, error)
+
+// Opts contains options used to configure a GCS store
+type Opts struct {
+
+	// Bucket containing the stored items
+	Bucket string
+
+	// Prefix prepended to the key of each item, e.g. "zim/cache"
+	Prefix string
+
+	// Endpoint of the XML API. Defaults to the STORAGE_EMULATOR_HOST
+	// environment variable if it is set or otherwise DefaultEndpoint.
+	Endpoint string
+
+	// TokenSource provides access tokens. Defaults to DefaultTokenSource.
+	TokenSource TokenSource
+}
+
+type gcsStore struct {
+	bucket   string
+	prefix   string
+	endpoint string
+	token    TokenSource
+	client   *retryablehttp.Client
+}
+
+// New returns a store.Store interface backed by Google Cloud Storage
+func New(opts Opts) store.Store {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("STORAGE_EMULATOR_HOST")
+	}
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	token := opts.TokenSource
+	if token == nil {
+		token = DefaultTokenSource()
+	}
+	client := retryablehttp.NewClient()
+	client.RetryMax = 4
+	client.Logger = nil
+	return &gcsStore{
+		bucket:   opts.Bucket,
+		prefix:   strings.Trim(opts.Prefix, "/"),
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		token:    token,
+		client:   client,
+	}
+}
+
+// objectURL returns the URL of the object for a key
+func (s *gcsStore) objectURL(key string) string {
+	name := path.Join(s.prefix, key)
+	return fmt.Sprintf("%s/%s/%s", s.endpoint, url.PathEscape(s.bucket),
+		strings.Replace(url.PathEscape(name), "%2F", "/", -1))
+}
+
+// do makes an authorized request. The content length of the body must be
+// given if there is one.
+func (s *gcsStore) do(ctx context.Context, method, url string, body interface{}, length int64, header http.Header) (*http.Response, error) {
+	token, err := s.token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GCS access token: %s", err)
+	}
+	req, err := retryablehttp.NewRequest(method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %s", err)
+	}
+	req = req.WithContext(ctx)
+	req.ContentLength = length
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+	return s.client.Do(req)
+}
+
+// Get an item from storage
+func (s *gcsStore) Get(ctx context.Context, key, dst string) error {
+
+	resp, err := s.do(ctx, "GET", s.objectURL(key), nil, 0, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return store.NotFound(fmt.Sprintf("Not found: %s", key))
+	}
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GET failed %s (%d): %s", key, resp.StatusCode, message)
+	}
+
+	file, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %s", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("failed to write file: %s", err)
+	}
+	return nil
+}
+
+// GetRange returns a reader of part of an item
+func (s *gcsStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := s.do(ctx, "GET", s.objectURL(key), nil, 0, header)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %s", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, store.NotFound(fmt.Sprintf("Not found: %s", key))
+	default:
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET failed %s (%d): %s", key, resp.StatusCode, message)
+	}
+}
+
+// Put an item in the Store
+func (s *gcsStore) Put(ctx context.Context, key, src string, meta map[string]string) error {
+
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %s", src, err)
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %s", src, err)
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	if len(meta) > 0 {
+		value, err := encodeMeta(meta)
+		if err != nil {
+			return err
+		}
+		header.Set(metaHeader, value)
+	}
+	resp, err := s.do(ctx, "PUT", s.objectURL(key), f, stat.Size(), header)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("PUT failed %s (%d): %s", key, resp.StatusCode, message)
+	}
+	return nil
+}
+
+// Head checks if the item exists in the store
+func (s *gcsStore) Head(ctx context.Context, key string) (store.ItemMeta, error) {
+
+	resp, err := s.do(ctx, "HEAD", s.objectURL(key), nil, 0, nil)
+	if err != nil {
+		return store.ItemMeta{}, fmt.Errorf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return store.ItemMeta{}, store.NotFound(fmt.Sprintf("Not found: %s", key))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return store.ItemMeta{}, fmt.Errorf("HEAD failed %s (%d)", key, resp.StatusCode)
+	}
+	meta := map[string]string{}
+	if value := resp.Header.Get(metaHeader); value != "" {
+		if err := json.Unmarshal([]byte(value), &meta); err != nil {
+			return store.ItemMeta{}, fmt.Errorf("invalid metadata of %s: %s", key, err)
+		}
+	}
+	return store.ItemMeta{Meta: meta}, nil
+}
+
+// encodeMeta returns item metadata as JSON for the metadata header. Header
+// values are limited to ASCII, so other characters are escaped.
+func encodeMeta(meta map[string]string) (string, error) {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode metadata: %s", err)
+	}
+	var value strings.Builder
+	for _, r := range string(data) {
+		if r < utf8.RuneSelf {
+			value.WriteRune(r)
+			continue
+		}
+		for _, c := range utf16.Encode([]rune{r}) {
+			fmt.Fprintf(&value, "\\u%04x", c)
+		}
+	}
+	return value.String(), nil
+}
+
+// listBucketResult is the response to a request to list objects
+type listBucketResult struct {
+	IsTruncated bool   `xml:"IsTruncated"`
+	NextMarker  string `xml:"NextMarker"`
+	Contents    []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+}
+
+// List the items with keys that begin with the prefix
+func (s *gcsStore) List(ctx context.Context, prefix string) ([]store.Item, error) {
+
+	objectPrefix := prefix
+	if s.prefix != "" {
+		objectPrefix = s.prefix + "/" + prefix
+	}
+	var items []store.Item
+	var marker string
+	for {
+		query := url.Values{"prefix": {objectPrefix}}
+		if marker != "" {
+			query.Set("marker", marker)
+		}
+		listURL := fmt.Sprintf("%s/%s?%s", s.endpoint, url.PathEscape(s.bucket), query.Encode())
+		resp, err := s.do(ctx, "GET", listURL, nil, 0, nil)
+		if err != nil {
+			return nil, fmt.Errorf("request failed: %s", err)
+		}
+		var result listBucketResult
+		if resp.StatusCode != http.StatusOK {
+			message, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			return nil, fmt.Errorf("list failed (%d): %s", resp.StatusCode, message)
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %s", err)
+		}
+		for _, obj := range result.Contents {
+			key := obj.Key
+			if s.prefix != "" {
+				key = strings.TrimPrefix(key, s.prefix+"/")
+			}
+			items = append(items, store.Item{
+				Key:          key,
+				Size:         obj.Size,
+				LastModified: obj.LastModified,
+			})
+		}
+		if !result.IsTruncated || len(result.Contents) == 0 {
+			return items, nil
+		}
+		marker = result.NextMarker
+		if marker == "" {
+			marker = result.Contents[len(result.Contents)-1].Key
+		}
+	}
+}
+
+// Delete an item from the Store
+func (s *gcsStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, "DELETE", s.objectURL(key), nil, 0, nil)
+	if err != nil {
+		return fmt.Errorf("request failed: %s", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusNotFound {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("DELETE failed %s (%d): %s", key, resp.StatusCode, message)
+	}
+	return nil
+}
+
+// DefaultTokenSource returns access tokens from the first of these that is
+// available: the GOOGLE_OAUTH_ACCESS_TOKEN environment variable, the gcloud
+// CLI, or the Compute Engine metadata server. Tokens are reused until they
+// are close to expiring.
+func DefaultTokenSource() TokenSource {
+	var mutex sync.Mutex
+	var token string
+	var expiresAt time.Time
+
+	return func(ctx context.Context) (string, error) {
+		if t := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); t != "" {
+			return t, nil
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if token != "" && time.Now().Before(expiresAt) {
+			return token, nil
+		}
+		var err error
+		var lifetime time.Duration
+		if token, lifetime, err = gcloudToken(ctx); err != nil {
+			if token, lifetime, err = metadataToken(ctx); err != nil {
+				return "", errors.New(
+					"set GOOGLE_OAUTH_ACCESS_TOKEN or log in with gcloud")
+			}
+		}
+		expiresAt = time.Now().Add(lifetime - time.Minute)
+		return token, nil
+	}
+}
+
+// gcloudToken returns an access token from the gcloud CLI. These tokens are
+// valid for an hour.
+func gcloudToken(ctx context.Context) (string, time.Duration, error) {
+	out, err := osexec.CommandContext(ctx, "gcloud", "auth", "print-access-token").Output()
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.TrimSpace(string(out)), time.Hour, nil
+}
+
+// metadataToken returns an access token for the default service account
+// from the Compute Engine metadata server
+func metadataToken(ctx context.Context) (string, time.Duration, error) {
+	req, err := http.NewRequest("GET", metadataTokenURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("metadata server returned %d", resp.StatusCode)
+	}
+	var output struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&output); err != nil {
+		return "", 0, err
+	}
+	return output.AccessToken, time.Duration(output.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package gcs
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/store"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeObject struct {
+	data   []byte
+	header http.Header
+}
+
+// fakeGCS implements the parts of the XML API used by the store
+func fakeGCS(t *testing.T) (*httptest.Server, map[string]*fakeObject) {
+	var mutex sync.Mutex
+	objects := map[string]*fakeObject{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		mutex.Lock()
+		defer mutex.Unlock()
+		switch r.Method {
+		case "PUT":
+			data, _ := ioutil.ReadAll(r.Body)
+			header := http.Header{}
+			for k, v := range r.Header {
+				if strings.HasPrefix(k, "X-Goog-Meta-") {
+					header[k] = v
+				}
+			}
+			objects[r.URL.Path] = &fakeObject{data: data, header: header}
+		case "DELETE":
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		case "GET", "HEAD":
+			if prefix := r.URL.Query().Get("prefix"); r.URL.Path == "/my-bucket" {
+				listObjects(w, objects, prefix, r.URL.Query().Get("marker"))
+				return
+			}
+			obj, found := objects[r.URL.Path]
+			if !found {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			for k, v := range obj.header {
+				w.Header()[k] = v
+			}
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(obj.data))
+		}
+	}))
+	return server, objects
+}
+
+// listObjects writes one object per page to exercise pagination
+func listObjects(w http.ResponseWriter, objects map[string]*fakeObject, prefix, marker string) {
+	var keys []string
+	for path := range objects {
+		key := strings.TrimPrefix(path, "/my-bucket/")
+		if strings.HasPrefix(key, prefix) && key > marker {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	fmt.Fprint(w, "<ListBucketResult>")
+	if len(keys) > 0 {
+		fmt.Fprintf(w, "<IsTruncated>%t</IsTruncated>", len(keys) > 1)
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size>"+
+			"<LastModified>2020-01-02T03:04:05.000Z</LastModified></Contents>",
+			keys[0], len(objects["/my-bucket/"+keys[0]].data))
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func TestStore(t *testing.T) {
+	server, objects := fakeGCS(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "zim-gcs-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	s := New(Opts{
+		Bucket:   "my-bucket",
+		Prefix:   "/zim/cache/",
+		Endpoint: server.URL,
+		TokenSource: func(ctx context.Context) (string, error) {
+			return "secret", nil
+		},
+	})
+	ctx := context.Background()
+
+	_, err = s.Head(ctx, "abc/out.tgz")
+	require.IsType(t, store.NotFound(""), err)
+
+	src := filepath.Join(dir, "src")
+	require.Nil(t, ioutil.WriteFile(src, []byte("hello"), 0644))
+	require.Nil(t, s.Put(ctx, "abc/out.tgz", src, map[string]string{"Hash": "123"}))
+	require.Contains(t, objects, "/my-bucket/zim/cache/abc/out.tgz")
+
+	item, err := s.Head(ctx, "abc/out.tgz")
+	require.Nil(t, err)
+	require.Equal(t, map[string]string{"Hash": "123"}, item.Meta)
+
+	dst := filepath.Join(dir, "dst")
+	require.Nil(t, s.Get(ctx, "abc/out.tgz", dst))
+	data, err := ioutil.ReadFile(dst)
+	require.Nil(t, err)
+	require.Equal(t, "hello", string(data))
+
+	reader, err := s.(store.RangeGetter).GetRange(ctx, "abc/out.tgz", 1, 3)
+	require.Nil(t, err)
+	data, err = ioutil.ReadAll(reader)
+	reader.Close()
+	require.Nil(t, err)
+	require.Equal(t, "ell", string(data))
+
+	err = s.Get(ctx, "missing", dst)
+	require.IsType(t, store.NotFound(""), err)
+
+	// List and delete
+	require.Nil(t, s.Put(ctx, "abd", src, nil))
+	require.Nil(t, s.Put(ctx, "xyz", src, nil))
+	lister := s.(store.ListDeleter)
+	items, err := lister.List(ctx, "ab")
+	require.Nil(t, err)
+	require.Equal(t, []store.Item{
+		{Key: "abc/out.tgz", Size: 5, LastModified: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Key: "abd", Size: 5, LastModified: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)},
+	}, items)
+
+	require.Nil(t, lister.Delete(ctx, "abd"))
+	require.NotContains(t, objects, "/my-bucket/zim/cache/abd")
+}
+
+// Every metadata key written by the cache keeps its case
+func TestMetadataKeys(t *testing.T) {
+	server, _ := fakeGCS(t)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "zim-gcs-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	require.Nil(t, ioutil.WriteFile(src, []byte("hello"), 0644))
+
+	s := New(Opts{
+		Bucket:   "my-bucket",
+		Endpoint: server.URL,
+		TokenSource: func(ctx context.Context) (string, error) {
+			return "secret", nil
+		},
+	})
+	ctx := context.Background()
+
+	meta := map[string]string{
+		"User":          "Zoë",
+		"Project":       "my-project",
+		"BuildID":       "3f2a9c1e-5b7d-4f0e-9a61-2c8e4d7b1a90",
+		"Baseline":      "coverage",
+		"Format":        "seekable-dir",
+		"Hash":          "5d41402abc4b2a76b9719d911017c592",
+		"HashAlgorithm": "blake3",
+		"Size":          "5",
+		"Encoding":      "gzip",
+		"ContentHash":   "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d",
+		"ContentSize":   "25",
+		"Blob":          "blobs/aa/aaf4c61ddcc5e8a2",
+		"Signature":     "hmac-sha256:c2lnbmF0dXJl",
+	}
+	require.Nil(t, s.Put(ctx, "key", src, meta))
+	item, err := s.Head(ctx, "key")
+	require.Nil(t, err)
+	require.Equal(t, meta, item.Meta)
+}
@@ -105,7 +105,94 @@ func (s *httpStore) requestHead(ctx context.Context, input *sign.Input) (*sign.I
 	return output, nil
 }
 
-// Get an item from storage
+// requestPath makes a request to the given path of the signing API
+func (s *httpStore) requestPath(ctx context.Context, apiPath string, input *sign.Input, output interface{}) error {
+	u, err := url.Parse(s.signingURL)
+	if err != nil {
+		return err
+	}
+	u.Path = path.Join(u.Path, apiPath)
+	return s.request(ctx, u.String(), input, output)
+}
+
+// List the items with keys that begin with the prefix
+func (s *httpStore) List(ctx context.Context, prefix string) ([]store.Item, error) {
+	var items []store.Item
+	input := sign.Input{Name: prefix}
+	for {
+		var output sign.ListOutput
+		if err := s.requestPath(ctx, "list", &input, &output); err != nil {
+			return nil, err
+		}
+		for _, item := range output.Items {
+			items = append(items, store.Item{
+				Key:          item.Key,
+				Size:         item.Size,
+				LastModified: item.LastModified,
+			})
+		}
+		if output.Token == "" {
+			return items, nil
+		}
+		input.Token = output.Token
+	}
+}
+
+// Delete an item from the Store
+func (s *httpStore) Delete(ctx context.Context, key string) error {
+	var output sign.Item
+	return s.requestPath(ctx, "delete", &sign.Input{Name: key}, &output)
+}
+
+// Policies is implemented by stores that manage the retention policies of
+// projects on the cache server
+type Policies interface {
+
+	// GetPolicy returns the retention policy of a project
+	GetPolicy(ctx context.Context, project string) (*sign.Policy, error)
+
+	// PutPolicy replaces the retention policy of a project
+	PutPolicy(ctx context.Context, policy *sign.Policy) (*sign.Policy, error)
+}
+
+// GetPolicy returns the retention policy of a project. Projects without a
+// policy have no limits.
+func (s *httpStore) GetPolicy(ctx context.Context, project string) (*sign.Policy, error) {
+	var output sign.Policy
+	if err := s.requestPath(ctx, "policy", &sign.Input{Method: "GET", Name: project}, &output); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+// PutPolicy replaces the retention policy of a project
+func (s *httpStore) PutPolicy(ctx context.Context, policy *sign.Policy) (*sign.Policy, error) {
+	input := sign.Input{Method: "PUT", Name: policy.Project, Policy: policy}
+	var output sign.Policy
+	if err := s.requestPath(ctx, "policy", &input, &output); err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+// PartialSuffix is appended to the destination path while an item is being
+// downloaded. An interrupted download is resumed from the partial file.
+const PartialSuffix = ".partial"
+
+// ETagSuffix is appended to the path of a partial file to name the file
+// holding the ETag of the item being downloaded
+const ETagSuffix = ".etag"
+
+// maxDownloadAttempts is the number of times a download is resumed after
+// the connection fails partway through
+const maxDownloadAttempts = 5
+
+// Get an item from storage. The item is written to a partial file which is
+// renamed to the destination once complete. If the connection fails, the
+// download resumes from the end of the partial file using range requests.
+// The ETag of the item is saved beside the partial file, so that a later Get
+// may resume it too. The server only sends the rest of the item if its ETag
+// still matches, so a partial file of another item or version is replaced.
 func (s *httpStore) Get(ctx context.Context, key, dst string) error {
 
 	input := sign.Input{Method: "GET", Name: key}
@@ -114,24 +201,146 @@ func (s *httpStore) Get(ctx context.Context, key, dst string) error {
 		return err
 	}
 
-	resp, err := http.Get(output.URL)
+	partial := dst + PartialSuffix
+	for attempt := 1; ; attempt++ {
+		err = s.download(ctx, output.URL, partial)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil || attempt >= maxDownloadAttempts {
+			return err
+		}
+		if _, ok := err.(resumableError); !ok {
+			return err
+		}
+	}
+	if err := os.Rename(partial, dst); err != nil {
+		return fmt.Errorf("failed to rename file: %s", err)
+	}
+	os.Remove(partial + ETagSuffix)
+	return nil
+}
+
+// resumableError indicates a download failed partway through and may be
+// resumed from the partial file
+type resumableError struct {
+	err error
+}
+
+func (e resumableError) Error() string {
+	return fmt.Sprintf("failed to write file: %s", e.err)
+}
+
+// download requests the remainder of the item beyond what already exists in
+// the partial file and appends it. A range is only requested if the ETag of
+// the partial file is known, and it is sent with the request so that the
+// full item is returned instead if the partial file is of something else.
+func (s *httpStore) download(ctx context.Context, url, partial string) error {
+
+	etagPath := partial + ETagSuffix
+	var offset int64
+	var etag string
+	if info, err := os.Stat(partial); err == nil {
+		if data, err := ioutil.ReadFile(etagPath); err == nil && len(data) > 0 {
+			offset = info.Size()
+			etag = string(data)
+		}
+	}
+
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to build request: %s", err)
 	}
+	req = req.WithContext(ctx)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return resumableError{err}
+	}
 	defer resp.Body.Close()
 
-	file, err := os.Create(dst)
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// The full item was returned, so start over and remember its ETag
+		// for resuming. Without one, the download can't be resumed.
+		flags |= os.O_TRUNC
+		os.Remove(etagPath)
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			if err := ioutil.WriteFile(etagPath, []byte(etag), 0644); err != nil {
+				return fmt.Errorf("failed to write file: %s", err)
+			}
+		}
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file doesn't belong to this item. Discard it.
+		os.Remove(partial)
+		os.Remove(etagPath)
+		return resumableError{fmt.Errorf("invalid partial file %s", partial)}
+	default:
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("GET failed (%d): %s", resp.StatusCode, message)
+	}
+
+	file, err := os.OpenFile(partial, flags, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %s", err)
 	}
 	defer file.Close()
 
 	if _, err := io.Copy(file, resp.Body); err != nil {
-		return fmt.Errorf("failed to write file: %s", err)
+		return resumableError{err}
 	}
 	return nil
 }
 
+// GetRange returns a reader of part of an item, which is requested with a
+// range request. If the server ignores the range and returns the whole item,
+// the bytes before the offset are skipped.
+func (s *httpStore) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+
+	input := sign.Input{Method: "GET", Name: key}
+	output, err := s.requestSign(ctx, &input)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", output.URL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %s", err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	resp, err := s.client.StandardClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %s", err)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+	case http.StatusOK:
+		if _, err := io.CopyN(ioutil.Discard, resp.Body, offset); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("failed to read %s: %s", key, err)
+		}
+	default:
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET failed (%d): %s", resp.StatusCode, message)
+	}
+	return &rangeReader{Reader: io.LimitReader(resp.Body, length), Closer: resp.Body}, nil
+}
+
+// rangeReader reads part of a response body and closes the body
+type rangeReader struct {
+	io.Reader
+	io.Closer
+}
+
 // Put an item in the Store
 func (s *httpStore) Put(ctx context.Context, key, src string, meta map[string]string) error {
 
@@ -0,0 +1,197 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/sign"
+	"github.com/fugue/zim/store"
+	"github.com/stretchr/testify/require"
+)
+
+// testServer serves a signing endpoint and the item content. The given
+// number of initial content requests are cut off halfway through.
+func testServer(content []byte, failures int) (*httptest.Server, *[]string) {
+	var mutex sync.Mutex
+	var ranges []string
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	mux.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(sign.Output{URL: server.URL + "/item"})
+	})
+	mux.HandleFunc("/item", func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		fail := failures > 0
+		failures--
+		mutex.Unlock()
+
+		w.Header().Set("ETag", `"v1"`)
+		if fail {
+			w.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:len(content)/2])
+			return
+		}
+		http.ServeContent(w, r, "item", time.Time{}, bytes.NewReader(content))
+	})
+	return server, &ranges
+}
+
+func TestGetResume(t *testing.T) {
+	content := []byte(strings.Repeat("0123456789", 1000))
+	server, ranges := testServer(content, 1)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "zim-http-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "item")
+
+	s := New(server.URL, "token")
+	require.Nil(t, s.Get(context.Background(), "key", dst))
+
+	data, err := ioutil.ReadFile(dst)
+	require.Nil(t, err)
+	require.Equal(t, content, data)
+	require.Equal(t, []string{"", "bytes=5000-"}, *ranges)
+
+	_, err = os.Stat(dst + PartialSuffix)
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(dst + PartialSuffix + ETagSuffix)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestGetPartialFile(t *testing.T) {
+	content := []byte(strings.Repeat("abcdefghij", 1000))
+	other := []byte(strings.Repeat("0123456789", 300))
+	server, ranges := testServer(content, 0)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "zim-http-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "item")
+	partial := dst + PartialSuffix
+
+	s := New(server.URL, "token")
+	get := func(partialContent []byte, etag string) []byte {
+		*ranges = nil
+		require.Nil(t, ioutil.WriteFile(partial, partialContent, 0644))
+		if etag != "" {
+			require.Nil(t, ioutil.WriteFile(partial+ETagSuffix, []byte(etag), 0644))
+		}
+		require.Nil(t, s.Get(context.Background(), "key", dst))
+		data, err := ioutil.ReadFile(dst)
+		require.Nil(t, err)
+		_, err = os.Stat(partial + ETagSuffix)
+		require.True(t, os.IsNotExist(err))
+		return data
+	}
+
+	// A partial file of the same item left by an earlier Get is resumed
+	require.Equal(t, content, get(content[:3000], `"v1"`))
+	require.Equal(t, []string{"bytes=3000-"}, *ranges)
+
+	// A partial file of another item or version is replaced
+	require.Equal(t, content, get(other, `"v0"`))
+	require.Equal(t, []string{"bytes=3000-"}, *ranges)
+
+	// A partial file without an ETag isn't resumed
+	require.Equal(t, content, get(other, ""))
+	require.Equal(t, []string{""}, *ranges)
+
+	// A partial file larger than the item is discarded
+	require.Equal(t, content, get(append(content, content...), `"v1"`))
+	require.Equal(t, []string{"bytes=20000-", ""}, *ranges)
+}
+
+func TestPolicy(t *testing.T) {
+	policies := map[string]sign.Policy{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/policy", r.URL.Path)
+		var input sign.Input
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&input))
+		if input.Method == "PUT" {
+			policies[input.Name] = *input.Policy
+		}
+		policy, found := policies[input.Name]
+		if !found {
+			policy = sign.Policy{Project: input.Name}
+		}
+		json.NewEncoder(w).Encode(policy)
+	}))
+	defer server.Close()
+
+	s := New(server.URL, "token").(Policies)
+	ctx := context.Background()
+
+	policy, err := s.GetPolicy(ctx, "my-project")
+	require.Nil(t, err)
+	require.Equal(t, &sign.Policy{Project: "my-project"}, policy)
+
+	update := &sign.Policy{Project: "my-project", MaxAge: time.Hour, MaxSize: 1024}
+	policy, err = s.PutPolicy(ctx, update)
+	require.Nil(t, err)
+	require.Equal(t, update, policy)
+
+	policy, err = s.GetPolicy(ctx, "my-project")
+	require.Nil(t, err)
+	require.Equal(t, update, policy)
+}
+
+func TestGetRange(t *testing.T) {
+	content := []byte("The quick brown fox jumps over the lazy dog")
+	ignoreRanges := false
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	mux.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(sign.Output{URL: server.URL + "/item"})
+	})
+	mux.HandleFunc("/item", func(w http.ResponseWriter, r *http.Request) {
+		if ignoreRanges {
+			r.Header.Del("Range")
+		}
+		http.ServeContent(w, r, "item", time.Time{}, bytes.NewReader(content))
+	})
+
+	s := New(server.URL, "token").(store.RangeGetter)
+	getRange := func(offset, length int64) string {
+		reader, err := s.GetRange(context.Background(), "key", offset, length)
+		require.Nil(t, err)
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		require.Nil(t, err)
+		return string(data)
+	}
+	require.Equal(t, "quick brown", getRange(4, 11))
+
+	// Servers that return the whole item still give the range
+	ignoreRanges = true
+	require.Equal(t, "lazy", getRange(35, 4))
+}
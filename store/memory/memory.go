@@ -0,0 +1,279 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fugue/zim/store"
+)
+
+// Epoch is the modification time of the first item put in a Store. Each
+// later Put advances the time by one second, so that listings are the same
+// on every run.
+var Epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Operation kinds recorded by a Store
+const (
+	OpGet      = "get"
+	OpGetRange = "get-range"
+	OpPut      = "put"
+	OpHead     = "head"
+	OpList     = "list"
+	OpDelete   = "delete"
+)
+
+// Operation is a call made to a Store. The key is the prefix for a List.
+type Operation struct {
+	Kind string
+	Key  string
+}
+
+func (op Operation) String() string {
+	return fmt.Sprintf("%s %s", op.Kind, op.Key)
+}
+
+type item struct {
+	data     []byte
+	meta     map[string]string
+	modified time.Time
+}
+
+// Store keeps items in memory. It is meant for testing code that uses a
+// store.Store, and records the operations made so that tests may check
+// them. Items may be listed, deleted, and read in part. A Store is safe to use from
+// multiple goroutines.
+type Store struct {
+	mutex sync.Mutex
+	items map[string]*item
+	ops   []Operation
+	puts  int
+}
+
+// New returns an empty Store
+func New() *Store {
+	return &Store{items: map[string]*item{}}
+}
+
+// record appends an operation to the log. The mutex must be held.
+func (s *Store) record(kind, key string) {
+	s.ops = append(s.ops, Operation{Kind: kind, Key: key})
+}
+
+// notFound returns the error for a missing item
+func notFound(key string) error {
+	return store.NotFound(fmt.Sprintf("not found: %s", key))
+}
+
+// copyMeta returns a copy of item metadata so that callers can't modify
+// what is stored
+func copyMeta(meta map[string]string) map[string]string {
+	result := make(map[string]string, len(meta))
+	for k, v := range meta {
+		result[k] = v
+	}
+	return result
+}
+
+// Get an item from the Store, writing it to the dst file
+func (s *Store) Get(ctx context.Context, key, dst string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	s.record(OpGet, key)
+	it, found := s.items[key]
+	s.mutex.Unlock()
+	if !found {
+		return notFound(key)
+	}
+	return ioutil.WriteFile(dst, it.data, 0644)
+}
+
+// GetRange returns a reader of part of an item
+func (s *Store) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	s.record(OpGetRange, key)
+	it, found := s.items[key]
+	s.mutex.Unlock()
+	if !found {
+		return nil, notFound(key)
+	}
+	if offset < 0 || offset > int64(len(it.data)) {
+		return nil, fmt.Errorf("invalid range of %s: offset %d", key, offset)
+	}
+	end := offset + length
+	if end > int64(len(it.data)) {
+		end = int64(len(it.data))
+	}
+	return ioutil.NopCloser(bytes.NewReader(it.data[offset:end])), nil
+}
+
+// Put an item in the Store, reading it from the src file
+func (s *Store) Put(ctx context.Context, key, src string, meta map[string]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read file %s: %w", src, err)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(OpPut, key)
+	s.set(key, data, meta)
+	return nil
+}
+
+// set stores an item. The mutex must be held.
+func (s *Store) set(key string, data []byte, meta map[string]string) {
+	s.items[key] = &item{
+		data:     append([]byte{}, data...),
+		meta:     copyMeta(meta),
+		modified: Epoch.Add(time.Duration(s.puts) * time.Second),
+	}
+	s.puts++
+}
+
+// Head checks if the item exists in the store
+func (s *Store) Head(ctx context.Context, key string) (store.ItemMeta, error) {
+	if err := ctx.Err(); err != nil {
+		return store.ItemMeta{}, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(OpHead, key)
+	it, found := s.items[key]
+	if !found {
+		return store.ItemMeta{}, notFound(key)
+	}
+	return store.ItemMeta{Meta: copyMeta(it.meta)}, nil
+}
+
+// List the items with keys that begin with the prefix, sorted by key
+func (s *Store) List(ctx context.Context, prefix string) ([]store.Item, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(OpList, prefix)
+	var items []store.Item
+	for _, key := range s.keys() {
+		if strings.HasPrefix(key, prefix) {
+			it := s.items[key]
+			items = append(items, store.Item{
+				Key:          key,
+				Size:         int64(len(it.data)),
+				LastModified: it.modified,
+			})
+		}
+	}
+	return items, nil
+}
+
+// Delete an item from the Store. Deleting a missing item is not an error.
+func (s *Store) Delete(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.record(OpDelete, key)
+	delete(s.items, key)
+	return nil
+}
+
+// keys returns the sorted keys of the items. The mutex must be held.
+func (s *Store) keys() []string {
+	keys := make([]string, 0, len(s.items))
+	for key := range s.items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set stores an item directly, without a file and without recording an
+// operation. It is useful for preparing the Store before a test.
+func (s *Store) Set(key string, data []byte, meta map[string]string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.set(key, data, meta)
+}
+
+// Item returns the content and metadata of an item without recording an
+// operation. False is returned if there is no such item.
+func (s *Store) Item(key string) ([]byte, map[string]string, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	it, found := s.items[key]
+	if !found {
+		return nil, nil, false
+	}
+	return append([]byte{}, it.data...), copyMeta(it.meta), true
+}
+
+// Keys returns the sorted keys of the items in the Store
+func (s *Store) Keys() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.keys()
+}
+
+// Operations returns the operations made on the Store, in order
+func (s *Store) Operations() []Operation {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]Operation{}, s.ops...)
+}
+
+// Gets returns the keys of the items that were requested with Get, in order
+func (s *Store) Gets() []string {
+	return s.opKeys(OpGet)
+}
+
+// Puts returns the keys of the items that were stored with Put, in order
+func (s *Store) Puts() []string {
+	return s.opKeys(OpPut)
+}
+
+// opKeys returns the keys of the operations of one kind
+func (s *Store) opKeys(kind string) []string {
+	var keys []string
+	for _, op := range s.Operations() {
+		if op.Kind == kind {
+			keys = append(keys, op.Key)
+		}
+	}
+	return keys
+}
+
+// Reset forgets the operations made so far, keeping the items
+func (s *Store) Reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ops = nil
+}
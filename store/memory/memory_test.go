@@ -0,0 +1,81 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package memory
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fugue/zim/store"
+	"github.com/fugue/zim/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContract(t *testing.T) {
+	storetest.TestStore(t, func() store.Store { return New() })
+}
+
+func TestOperations(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "zim-test-")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	require.Nil(t, ioutil.WriteFile(src, []byte("data"), 0644))
+
+	s := New()
+	s.Set("seeded", []byte("seed"), map[string]string{"Hash": "1"})
+	require.Nil(t, s.Put(ctx, "b", src, nil))
+	require.Nil(t, s.Put(ctx, "a", src, map[string]string{"Hash": "2"}))
+	require.Nil(t, s.Get(ctx, "a", filepath.Join(dir, "dst")))
+	_, err = s.Head(ctx, "c")
+	require.Equal(t, store.NotFound("not found: c"), err)
+	require.Equal(t, []string{"b", "a"}, s.Puts())
+	require.Equal(t, []string{"a"}, s.Gets())
+	require.Equal(t, []Operation{
+		{Kind: OpPut, Key: "b"},
+		{Kind: OpPut, Key: "a"},
+		{Kind: OpGet, Key: "a"},
+		{Kind: OpHead, Key: "c"},
+	}, s.Operations())
+	require.Equal(t, []string{"a", "b", "seeded"}, s.Keys())
+
+	data, meta, found := s.Item("a")
+	require.True(t, found)
+	require.Equal(t, "data", string(data))
+	require.Equal(t, map[string]string{"Hash": "2"}, meta)
+
+	// Items are listed by key with modification times that advance with
+	// each Put
+	items, err := s.List(ctx, "")
+	require.Nil(t, err)
+	require.Equal(t, []store.Item{
+		{Key: "a", Size: 4, LastModified: Epoch.Add(2 * time.Second)},
+		{Key: "b", Size: 4, LastModified: Epoch.Add(time.Second)},
+		{Key: "seeded", Size: 4, LastModified: Epoch},
+	}, items)
+
+	s.Reset()
+	require.Len(t, s.Operations(), 0)
+	require.Len(t, s.Keys(), 3)
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	require.Equal(t, context.Canceled, s.Put(canceled, "c", src, nil))
+	require.Len(t, s.Operations(), 0)
+}
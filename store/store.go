@@ -16,6 +16,8 @@ package store
 
 import (
 	"context"
+	"io"
+	"time"
 )
 
 // NotFound indicates an object does not exist
@@ -40,3 +42,29 @@ type Store interface {
 	// Head checks if the item exists in the store
 	Head(ctx context.Context, key string) (ItemMeta, error)
 }
+
+// RangeGetter is implemented by Stores that can read part of an item without
+// downloading all of it
+type RangeGetter interface {
+
+	// GetRange returns a reader of length bytes of the item, starting at the
+	// offset. The caller closes the reader.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
+// Item describes an item in storage
+type Item struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+// ListDeleter is implemented by Stores whose items may be listed and deleted
+type ListDeleter interface {
+
+	// List the items with keys that begin with the prefix
+	List(ctx context.Context, prefix string) ([]Item, error)
+
+	// Delete an item from the Store
+	Delete(ctx context.Context, key string) error
+}
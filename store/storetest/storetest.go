@@ -0,0 +1,212 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package storetest
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/fugue/zim/store"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStore checks that a Store behaves as the rest of zim expects. It is
+// meant for the tests of Store implementations and of middleware that wraps
+// a Store, e.g. one from the memory package. The function is called for each
+// check and must return an empty Store. Stores that implement
+// store.ListDeleter are also checked for listing and deleting items, and
+// those that implement store.RangeGetter for reading part of an item.
+func TestStore(t *testing.T, newStore func() store.Store) {
+	t.Run("Missing", func(t *testing.T) { testMissing(t, newStore()) })
+	t.Run("PutGet", func(t *testing.T) { testPutGet(t, newStore()) })
+	t.Run("Overwrite", func(t *testing.T) { testOverwrite(t, newStore()) })
+	t.Run("Metadata", func(t *testing.T) { testMetadata(t, newStore()) })
+	t.Run("ListDelete", func(t *testing.T) {
+		lister, ok := newStore().(listStore)
+		if !ok {
+			t.Skip("the store does not support listing items")
+		}
+		testListDelete(t, lister)
+	})
+	t.Run("GetRange", func(t *testing.T) {
+		getter, ok := newStore().(rangeStore)
+		if !ok {
+			t.Skip("the store does not support ranged reads")
+		}
+		testGetRange(t, getter)
+	})
+}
+
+type listStore interface {
+	store.Store
+	store.ListDeleter
+}
+
+type rangeStore interface {
+	store.Store
+	store.RangeGetter
+}
+
+// files creates temporary files for the items put in and taken out of a
+// Store, removing them when the test ends
+type files struct {
+	t   *testing.T
+	dir string
+}
+
+func newFiles(t *testing.T) *files {
+	dir, err := ioutil.TempDir("", "zim-storetest-")
+	require.Nil(t, err)
+	return &files{t: t, dir: dir}
+}
+
+func (f *files) close() {
+	os.RemoveAll(f.dir)
+}
+
+// write returns the path to a new file with the given content
+func (f *files) write(name, content string) string {
+	p := filepath.Join(f.dir, name)
+	require.Nil(f.t, ioutil.WriteFile(p, []byte(content), 0644))
+	return p
+}
+
+// get returns the content of an item in the Store
+func (f *files) get(s store.Store, key string) string {
+	p := filepath.Join(f.dir, "get")
+	require.Nil(f.t, s.Get(context.Background(), key, p))
+	data, err := ioutil.ReadFile(p)
+	require.Nil(f.t, err)
+	return string(data)
+}
+
+func testMissing(t *testing.T, s store.Store) {
+	f := newFiles(t)
+	defer f.close()
+	ctx := context.Background()
+
+	_, err := s.Head(ctx, "missing")
+	require.NotNil(t, err)
+	_, ok := err.(store.NotFound)
+	require.True(t, ok, "Head of a missing item must return store.NotFound, got %T", err)
+
+	require.NotNil(t, s.Get(ctx, "missing", filepath.Join(f.dir, "get")))
+}
+
+func testPutGet(t *testing.T, s store.Store) {
+	f := newFiles(t)
+	defer f.close()
+	ctx := context.Background()
+
+	content := "The quick brown fox\njumps over the lazy dog"
+	require.Nil(t, s.Put(ctx, "abcdef", f.write("src", content), nil))
+	require.Equal(t, content, f.get(s, "abcdef"))
+
+	// Empty items and keys containing slashes are allowed
+	require.Nil(t, s.Put(ctx, "dir/empty", f.write("empty", ""), nil))
+	require.Equal(t, "", f.get(s, "dir/empty"))
+	require.Equal(t, content, f.get(s, "abcdef"))
+}
+
+func testOverwrite(t *testing.T, s store.Store) {
+	f := newFiles(t)
+	defer f.close()
+	ctx := context.Background()
+
+	require.Nil(t, s.Put(ctx, "key", f.write("one", "one"), map[string]string{"Version": "1"}))
+	require.Nil(t, s.Put(ctx, "key", f.write("two", "two"), map[string]string{"Version": "2"}))
+	require.Equal(t, "two", f.get(s, "key"))
+
+	info, err := s.Head(ctx, "key")
+	require.Nil(t, err)
+	require.Equal(t, "2", info.Meta["Version"])
+}
+
+func testMetadata(t *testing.T, s store.Store) {
+	f := newFiles(t)
+	defer f.close()
+	ctx := context.Background()
+
+	meta := map[string]string{"Hash": "8ab4c1", "Format": "dir"}
+	require.Nil(t, s.Put(ctx, "key", f.write("src", "data"), meta))
+
+	// Changes to the map after the Put don't change the item
+	meta["Hash"] = "changed"
+
+	info, err := s.Head(ctx, "key")
+	require.Nil(t, err)
+	require.Equal(t, "8ab4c1", info.Meta["Hash"])
+	require.Equal(t, "dir", info.Meta["Format"])
+}
+
+func testListDelete(t *testing.T, s listStore) {
+	f := newFiles(t)
+	defer f.close()
+	ctx := context.Background()
+
+	items, err := s.List(ctx, "")
+	require.Nil(t, err)
+	require.Len(t, items, 0)
+
+	require.Nil(t, s.Put(ctx, "abc1", f.write("a", "a"), nil))
+	require.Nil(t, s.Put(ctx, "abc2", f.write("b", "bb"), nil))
+	require.Nil(t, s.Put(ctx, "xyz1", f.write("c", "ccc"), nil))
+
+	items, err = s.List(ctx, "abc")
+	require.Nil(t, err)
+	sort.Slice(items, func(i, j int) bool { return items[i].Key < items[j].Key })
+	require.Len(t, items, 2)
+	require.Equal(t, "abc1", items[0].Key)
+	require.Equal(t, int64(1), items[0].Size)
+	require.Equal(t, "abc2", items[1].Key)
+	require.Equal(t, int64(2), items[1].Size)
+
+	require.Nil(t, s.Delete(ctx, "abc1"))
+	_, err = s.Head(ctx, "abc1")
+	_, ok := err.(store.NotFound)
+	require.True(t, ok, "Head of a deleted item must return store.NotFound, got %v", err)
+
+	// Deleting a missing item is not an error
+	require.Nil(t, s.Delete(ctx, "abc1"))
+
+	items, err = s.List(ctx, "")
+	require.Nil(t, err)
+	require.Len(t, items, 2)
+}
+
+func testGetRange(t *testing.T, s rangeStore) {
+	f := newFiles(t)
+	defer f.close()
+	ctx := context.Background()
+
+	require.Nil(t, s.Put(ctx, "key", f.write("src", "The quick brown fox"), nil))
+	getRange := func(offset, length int64) string {
+		reader, err := s.GetRange(ctx, "key", offset, length)
+		require.Nil(t, err)
+		defer reader.Close()
+		data, err := ioutil.ReadAll(reader)
+		require.Nil(t, err)
+		return string(data)
+	}
+	require.Equal(t, "quick", getRange(4, 5))
+	require.Equal(t, "The", getRange(0, 3))
+	require.Equal(t, "fox", getRange(16, 3))
+
+	_, err := s.GetRange(ctx, "missing", 0, 1)
+	require.NotNil(t, err)
+}
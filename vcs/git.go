@@ -0,0 +1,192 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vcs
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// git manages a Git working copy by running git
+type git struct {
+	root string
+}
+
+func (g *git) Name() string {
+	return "git"
+}
+
+func (g *git) Root() string {
+	return g.root
+}
+
+// CommitID reads the commit from the repository when it can, so that git
+// doesn't need to be installed, and otherwise runs git
+func (g *git) CommitID() (string, error) {
+	if commitID, err := readHead(g.root); err == nil {
+		return commitID, nil
+	}
+	out, err := output(g.root, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ChangedFiles lists files that differ from the revision, followed by
+// untracked files that aren't ignored, which git diff leaves out
+func (g *git) ChangedFiles(rev string) ([]string, error) {
+	out, err := output(g.root, "git", "diff", "--name-only", rev, "--")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := output(g.root, "git", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	return append(absPaths(g.root, out), absPaths(g.root, untracked)...), nil
+}
+
+func (g *git) Changes() (string, error) {
+	status, err := output(g.root, "git", "status", "--porcelain", "-z", "--untracked-files=all")
+	if err != nil || status == "" {
+		return "", err
+	}
+	diff, err := output(g.root, "git", "diff", "HEAD", "--binary")
+	if err != nil {
+		return "", err
+	}
+	// Entries are separated by NUL and renames are followed by the old path
+	var untracked []string
+	entries := strings.Split(status, "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		if len(entry) < 4 {
+			continue
+		}
+		if strings.HasPrefix(entry, "??") {
+			untracked = append(untracked, entry[3:])
+		} else if entry[0] == 'R' || entry[0] == 'C' {
+			i++
+		}
+	}
+	return describeChanges(g.root, status, diff, untracked)
+}
+
+// Archive honors export-ignore and other attributes set in .gitattributes
+func (g *git) Archive(w io.Writer) error {
+	return run(g.root, w, "git", "archive", "--format=tar", "HEAD")
+}
+
+// gitDir returns the git directory of a working copy. In linked worktrees
+// and submodules .git is a file containing the path of the directory.
+func gitDir(root string) (string, error) {
+	dir := filepath.Join(root, ".git")
+	info, err := os.Stat(dir)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return dir, nil
+	}
+	data, err := ioutil.ReadFile(dir)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("invalid git file: %s", dir)
+	}
+	dir = strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return dir, nil
+}
+
+// readHead returns the commit checked out in a working copy by reading
+// HEAD and the ref it points to, which may be a loose or packed ref
+func readHead(root string) (string, error) {
+	dir, err := gitDir(root)
+	if err != nil {
+		return "", err
+	}
+	head, err := readLine(filepath.Join(dir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(head, "ref: ") {
+		return validCommitID(head)
+	}
+	ref := strings.TrimPrefix(head, "ref: ")
+
+	// Linked worktrees share refs with the main repository
+	commonDir := dir
+	if common, err := readLine(filepath.Join(dir, "commondir")); err == nil {
+		if filepath.IsAbs(common) {
+			commonDir = common
+		} else {
+			commonDir = filepath.Join(dir, common)
+		}
+	}
+	for _, d := range []string{dir, commonDir} {
+		if commitID, err := readLine(filepath.Join(d, filepath.FromSlash(ref))); err == nil {
+			return validCommitID(commitID)
+		}
+	}
+	return readPackedRef(filepath.Join(commonDir, "packed-refs"), ref)
+}
+
+// readPackedRef returns the commit of a ref listed in a packed-refs file
+func readPackedRef(path, ref string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == ref {
+			return validCommitID(fields[0])
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("ref not found: %s", ref)
+}
+
+// readLine returns the first line of a file
+func readLine(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(strings.SplitN(string(data), "\n", 2)[0]), nil
+}
+
+// validCommitID returns the commit ID if it is a SHA-1 or SHA-256 object ID
+func validCommitID(commitID string) (string, error) {
+	if _, err := hex.DecodeString(commitID); err != nil || (len(commitID) != 40 && len(commitID) != 64) {
+		return "", fmt.Errorf("invalid commit ID: %q", commitID)
+	}
+	return commitID, nil
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vcs
+
+import (
+	"io"
+	"strings"
+)
+
+// mercurial manages a Mercurial working copy by running hg
+type mercurial struct {
+	root string
+}
+
+func (m *mercurial) Name() string {
+	return "hg"
+}
+
+func (m *mercurial) Root() string {
+	return m.root
+}
+
+func (m *mercurial) CommitID() (string, error) {
+	out, err := output(m.root, "hg", "log", "--rev", ".", "--template", "{node}")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// ChangedFiles lists modified, added, removed, and deleted files, and
+// untracked files that aren't ignored
+func (m *mercurial) ChangedFiles(rev string) ([]string, error) {
+	out, err := output(m.root, "hg", "status", "--modified", "--added",
+		"--removed", "--deleted", "--unknown", "--no-status", "--rev", rev)
+	if err != nil {
+		return nil, err
+	}
+	return absPaths(m.root, out), nil
+}
+
+func (m *mercurial) Changes() (string, error) {
+	status, err := output(m.root, "hg", "status")
+	if err != nil || status == "" {
+		return "", err
+	}
+	diff, err := output(m.root, "hg", "diff", "--git")
+	if err != nil {
+		return "", err
+	}
+	var untracked []string
+	for _, line := range strings.Split(status, "\n") {
+		if strings.HasPrefix(line, "? ") {
+			untracked = append(untracked, line[2:])
+		}
+	}
+	return describeChanges(m.root, status, diff, untracked)
+}
+
+func (m *mercurial) Archive(w io.Writer) error {
+	return run(m.root, w, "hg", "archive", "--type", "tar", "--rev", ".",
+		"--prefix", ".", "--exclude", ".hg_archival.txt", "-")
+}
@@ -0,0 +1,123 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vcs
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ErrNoVersionControl is returned for operations that need a commit history
+// in a directory that is not under version control
+var ErrNoVersionControl = errors.New("the directory is not under version control")
+
+// none manages a plain directory
+type none struct {
+	root string
+}
+
+// None returns a VCS for a plain directory with no commit history. Its
+// archive contains every file in the directory.
+func None(dir string) VCS {
+	return &none{root: dir}
+}
+
+// Extracted returns a VCS for a directory extracted from an archive of the
+// given commit, e.g. on another machine. Its archive contains every file in
+// the directory. The commit ID is empty if the archive had no commit.
+func Extracted(dir, commitID string) VCS {
+	return &extracted{none: none{root: dir}, commitID: commitID}
+}
+
+// extracted manages a plain directory holding the files of a commit
+type extracted struct {
+	none
+	commitID string
+}
+
+func (e *extracted) CommitID() (string, error) {
+	if e.commitID == "" {
+		return "", ErrNoVersionControl
+	}
+	return e.commitID, nil
+}
+
+func (n *none) Name() string {
+	return "none"
+}
+
+func (n *none) Root() string {
+	return n.root
+}
+
+func (n *none) CommitID() (string, error) {
+	return "", ErrNoVersionControl
+}
+
+func (n *none) ChangedFiles(rev string) ([]string, error) {
+	return nil, ErrNoVersionControl
+}
+
+func (n *none) Changes() (string, error) {
+	return "", ErrNoVersionControl
+}
+
+func (n *none) Archive(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.Walk(n.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(n.root, p)
+		if err != nil || relPath == "." {
+			return err
+		}
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(p); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
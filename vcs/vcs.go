@@ -0,0 +1,136 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vcs
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// VCS is the version control system managing a working copy
+type VCS interface {
+
+	// Name returns the name of the system, e.g. "git"
+	Name() string
+
+	// Root returns the absolute path of the top directory of the working copy
+	Root() string
+
+	// CommitID returns the ID of the commit checked out
+	CommitID() (string, error)
+
+	// ChangedFiles returns the absolute paths of files that differ between
+	// the working copy and the given revision, including uncommitted changes
+	// and untracked files that aren't ignored
+	ChangedFiles(rev string) ([]string, error)
+
+	// Changes returns a description of the uncommitted changes in the working
+	// copy, including the contents of untracked files, which is empty if
+	// there are none. It differs whenever the changes do.
+	Changes() (string, error)
+
+	// Archive writes a tar archive of the files in the commit checked out
+	Archive(w io.Writer) error
+}
+
+// markers identify the top directory of a working copy of each system
+var markers = []struct {
+	name string
+	open func(root string) VCS
+}{
+	{".git", func(root string) VCS { return &git{root: root} }},
+	{".hg", func(root string) VCS { return &mercurial{root: root} }},
+}
+
+// Detect returns the version control system managing the directory, found by
+// searching it and its parents for a repository. Directories that are not
+// under version control are managed by None.
+func Detect(dir string) (VCS, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for current := absDir; ; {
+		for _, m := range markers {
+			if _, err := os.Stat(filepath.Join(current, m.name)); err == nil {
+				return m.open(current), nil
+			}
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			break
+		}
+		current = parent
+	}
+	return None(absDir), nil
+}
+
+// run runs a command in the given directory, writing its output to stdout
+func run(dir string, stdout io.Writer, name string, args ...string) error {
+	var stderr bytes.Buffer
+	command := osexec.Command(name, args...)
+	command.Dir = dir
+	command.Stdout = stdout
+	command.Stderr = &stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("failed to run %s %s: %s %s",
+			name, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// output runs a command in the given directory and returns its output
+func output(dir string, name string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	if err := run(dir, &stdout, name, args...); err != nil {
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+// describeChanges returns the status and diff of a working copy, followed
+// by the hashes of the untracked files among the given paths
+func describeChanges(root, status, diff string, untracked []string) (string, error) {
+	var b strings.Builder
+	b.WriteString(status)
+	b.WriteString("\n")
+	b.WriteString(diff)
+	for _, name := range untracked {
+		data, err := ioutil.ReadFile(filepath.Join(root, name))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "\n%s %x", name, sha1.Sum(data))
+	}
+	return b.String(), nil
+}
+
+// absPaths returns the absolute paths of the relative paths listed one per
+// line in the output of a command
+func absPaths(root, output string) []string {
+	var result []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			result = append(result, filepath.Join(root, line))
+		}
+	}
+	return result
+}
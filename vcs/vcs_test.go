@@ -0,0 +1,178 @@
+// Copyright 2020 Fugue, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package vcs
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, text string) {
+	require.Nil(t, os.MkdirAll(filepath.Dir(name), 0755))
+	require.Nil(t, ioutil.WriteFile(name, []byte(text), 0644))
+}
+
+// archiveNames returns the names of the files in a tar archive
+func archiveNames(t *testing.T, data []byte) []string {
+	var names []string
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		if header.Typeflag == tar.TypeReg {
+			names = append(names, header.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestNone(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "zim-vcs-")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	writeFile(t, filepath.Join(root, "src", "main.go"), "package main")
+	writeFile(t, filepath.Join(root, "README.md"), "docs")
+
+	repo, err := Detect(filepath.Join(root, "src"))
+	require.Nil(t, err)
+	require.Equal(t, "none", repo.Name())
+	require.Equal(t, filepath.Join(root, "src"), repo.Root())
+
+	_, err = repo.CommitID()
+	require.Equal(t, ErrNoVersionControl, err)
+	_, err = repo.ChangedFiles("HEAD")
+	require.Equal(t, ErrNoVersionControl, err)
+	_, err = repo.Changes()
+	require.Equal(t, ErrNoVersionControl, err)
+
+	var buf bytes.Buffer
+	require.Nil(t, None(root).Archive(&buf))
+	require.Equal(t, []string{"README.md", "src/main.go"}, archiveNames(t, buf.Bytes()))
+
+	// A directory extracted from an archive knows the commit it came from
+	extracted := Extracted(root, "8ab4c1d")
+	commitID, err := extracted.CommitID()
+	require.Nil(t, err)
+	require.Equal(t, "8ab4c1d", commitID)
+	_, err = Extracted(root, "").CommitID()
+	require.Equal(t, ErrNoVersionControl, err)
+}
+
+func TestGit(t *testing.T) {
+
+	root, err := ioutil.TempDir("", "zim-vcs-")
+	require.Nil(t, err)
+	defer os.RemoveAll(root)
+
+	writeFile(t, filepath.Join(root, "src", "main.go"), "package main")
+	writeFile(t, filepath.Join(root, "docs", "guide.md"), "docs")
+	writeFile(t, filepath.Join(root, ".gitattributes"), "docs export-ignore\n")
+
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		_, err := output(root, "git", args...)
+		require.Nil(t, err)
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+
+	// The repository is found from within a subdirectory
+	repo, err := Detect(filepath.Join(root, "src"))
+	require.Nil(t, err)
+	require.Equal(t, "git", repo.Name())
+	require.Equal(t, root, repo.Root())
+
+	commit, err := repo.CommitID()
+	require.Nil(t, err)
+	require.Len(t, commit, 40)
+
+	// The commit is read without running git, from loose or packed refs
+	// or a detached HEAD
+	native, err := readHead(root)
+	require.Nil(t, err)
+	require.Equal(t, commit, native)
+	git("pack-refs", "--all")
+	native, err = readHead(root)
+	require.Nil(t, err)
+	require.Equal(t, commit, native)
+	git("checkout", "-q", "--detach")
+	native, err = readHead(root)
+	require.Nil(t, err)
+	require.Equal(t, commit, native)
+
+	// Linked worktrees refer to the main repository
+	worktree := root + "-worktree"
+	defer os.RemoveAll(worktree)
+	git("worktree", "add", "-q", "-b", "other", worktree)
+	native, err = readHead(worktree)
+	require.Nil(t, err)
+	require.Equal(t, commit, native)
+
+	changed, err := repo.ChangedFiles("HEAD")
+	require.Nil(t, err)
+	require.Empty(t, changed)
+	changes, err := repo.Changes()
+	require.Nil(t, err)
+	require.Empty(t, changes)
+
+	writeFile(t, filepath.Join(root, "src", "main.go"), "package main // changed")
+	changed, err = repo.ChangedFiles("HEAD")
+	require.Nil(t, err)
+	require.Equal(t, []string{filepath.Join(root, "src", "main.go")}, changed)
+	writeFile(t, filepath.Join(root, "src", "new.go"), "package main")
+	changed, err = repo.ChangedFiles("HEAD")
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		filepath.Join(root, "src", "main.go"),
+		filepath.Join(root, "src", "new.go"),
+	}, changed)
+	require.Nil(t, os.Remove(filepath.Join(root, "src", "new.go")))
+
+	// Uncommitted changes include the contents of untracked files
+	modified, err := repo.Changes()
+	require.Nil(t, err)
+	require.NotEmpty(t, modified)
+	writeFile(t, filepath.Join(root, "src", "new.go"), "package main")
+	untracked, err := repo.Changes()
+	require.Nil(t, err)
+	require.NotEqual(t, modified, untracked)
+	writeFile(t, filepath.Join(root, "src", "new.go"), "package main // edited")
+	edited, err := repo.Changes()
+	require.Nil(t, err)
+	require.NotEqual(t, untracked, edited)
+	require.Nil(t, os.Remove(filepath.Join(root, "src", "new.go")))
+
+	_, err = repo.ChangedFiles("no-such-ref")
+	require.NotNil(t, err)
+
+	// Files marked export-ignore are left out of the archive
+	var buf bytes.Buffer
+	require.Nil(t, repo.Archive(&buf))
+	require.Equal(t, []string{".gitattributes", "src/main.go"}, archiveNames(t, buf.Bytes()))
+}